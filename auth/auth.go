@@ -96,6 +96,14 @@ func Register(r *http.Request) (bool, error) {
 	u.Username = username
 	u.Hash = string(h)
 	u.ApiKey = GenerateSecureKey()
+	u.Role = r.FormValue("role")
+	if u.Role == "" {
+		u.Role = models.ROLE_OPERATOR
+	}
+	err = u.Validate()
+	if err != nil {
+		return false, err
+	}
 	err = models.PutUser(&u)
 	return true, nil
 }
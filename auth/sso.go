@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/gophish/gophish/config"
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/gophish/models"
+	"github.com/gophish/gophish/sso"
+	"github.com/jinzhu/gorm"
+)
+
+// ErrSSOUsernameMissing is thrown when the provider's ID token doesn't
+// contain a username or email address for the user
+var ErrSSOUsernameMissing = errors.New("Single sign-on provider didn't return a username")
+
+// ErrSSOUserNotFound is thrown when no gophish user exists for the identity
+// and users aren't provisioned automatically
+var ErrSSOUserNotFound = errors.New("No gophish user exists for this account")
+
+// ErrSSOSubjectMismatch is thrown when the user with the identity's username
+// is already linked to a different account at the provider
+var ErrSSOSubjectMismatch = errors.New("User is linked to a different single sign-on account")
+
+// ErrSSOUserExists is thrown when a local user already has the identity's
+// username, and existing users aren't linked to the provider's accounts
+var ErrSSOUserExists = errors.New("A local user with this username already exists")
+
+// ErrSSOAdminLink is thrown when the local user with the identity's username
+// is an admin, since admins are never linked to the provider's accounts
+var ErrSSOAdminLink = errors.New("Admins can't be linked to a single sign-on account")
+
+// roleRank orders the roles by privilege, so that users in several mapped
+// groups are given the most privileged role
+var roleRank = map[string]int{
+	models.ROLE_AUDITOR:  1,
+	models.ROLE_OPERATOR: 2,
+	models.ROLE_ADMIN:    3,
+}
+
+// SSORole returns the most privileged role any of the groups are mapped to,
+// or an empty string if none of them are mapped to a role.
+func SSORole(mapping map[string]string, groups []string) string {
+	role := ""
+	for _, g := range groups {
+		if r, ok := mapping[g]; ok && roleRank[r] > roleRank[role] {
+			role = r
+		}
+	}
+	return role
+}
+
+// ssoDefaultRole returns the role given to provisioned users who aren't in
+// any mapped groups
+func ssoDefaultRole(conf config.SSO) string {
+	if conf.DefaultRole == "" {
+		return models.ROLE_AUDITOR
+	}
+	return conf.DefaultRole
+}
+
+// ValidateSSORoles returns an error if the default role or any of the roles
+// groups are mapped to isn't a known role
+func ValidateSSORoles(conf config.SSO) error {
+	if roleRank[ssoDefaultRole(conf)] == 0 {
+		return models.ErrInvalidRole
+	}
+	for _, r := range conf.RoleMapping {
+		if roleRank[r] == 0 {
+			return models.ErrInvalidRole
+		}
+	}
+	return nil
+}
+
+// LoginSSO returns the gophish user for an identity verified by the single
+// sign-on provider. Users are found by the subject they were linked to when
+// they first logged in through the provider. If no user is linked to the
+// subject, one is created if users are provisioned automatically. A local
+// user with the same username is only linked to the subject if existing
+// users are linked, and never if they're an admin, since whoever controls
+// the username at the provider would otherwise take over their account. If
+// groups are mapped to roles, the user's role is updated to match their
+// groups each time they log in.
+func LoginSSO(conf config.SSO, id *sso.Identity) (models.User, error) {
+	if id.Subject == "" {
+		return models.User{}, sso.ErrInvalidClaims
+	}
+	u, err := models.GetUserBySSOSubject(id.Subject)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return models.User{}, err
+	}
+	if err == gorm.ErrRecordNotFound {
+		if id.Username == "" {
+			return models.User{}, ErrSSOUsernameMissing
+		}
+		u, err = models.GetUserByUsername(id.Username)
+		switch {
+		case err == gorm.ErrRecordNotFound:
+			if !conf.AutoProvision {
+				return models.User{}, ErrSSOUserNotFound
+			}
+			u = models.User{
+				Username: id.Username,
+				ApiKey:   GenerateSecureKey(),
+				Role:     ssoDefaultRole(conf),
+			}
+			log.Infof("provisioning user %s from single sign-on", id.Username)
+		case err != nil:
+			return models.User{}, err
+		case u.SSOSubject != "":
+			return models.User{}, ErrSSOSubjectMismatch
+		case !conf.LinkExistingUsers:
+			return models.User{}, ErrSSOUserExists
+		case u.IsAdmin():
+			return models.User{}, ErrSSOAdminLink
+		default:
+			log.Infof("linking user %s to single sign-on", u.Username)
+		}
+		u.SSOSubject = id.Subject
+	}
+	if len(conf.RoleMapping) > 0 {
+		role := SSORole(conf.RoleMapping, id.Groups)
+		if role == "" {
+			role = ssoDefaultRole(conf)
+		}
+		u.Role = role
+	}
+	err = u.Validate()
+	if err != nil {
+		return models.User{}, err
+	}
+	err = models.PutUser(&u)
+	if err != nil {
+		return models.User{}, err
+	}
+	return u, nil
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	log "github.com/gophish/gophish/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// The thresholds used when they aren't set in the login_throttle section of
+// the config.
+const (
+	DefaultMaxIPAttempts      = 20
+	DefaultMaxAccountAttempts = 5
+	DefaultLoginWindow        = 15 * time.Minute
+	DefaultLockout            = time.Minute
+	DefaultMaxLockout         = time.Hour
+)
+
+// loginFailures tracks the failed logins for a single IP address or account.
+// Each time the attempts in a window reach the limit, the IP address or
+// account is locked out for twice as long as the previous time.
+type loginFailures struct {
+	count       int
+	start       time.Time
+	last        time.Time
+	lockouts    int
+	lockedUntil time.Time
+}
+
+// LoginGuard protects the admin login against brute-force attacks by
+// counting failed logins per IP address and per account, locking each out
+// temporarily once it has too many.
+type LoginGuard struct {
+	sync.Mutex
+	failures map[string]*loginFailures
+	now      func() time.Time
+}
+
+// NewLoginGuard returns a new LoginGuard with no failed logins
+func NewLoginGuard() *LoginGuard {
+	return &LoginGuard{failures: make(map[string]*loginFailures), now: time.Now}
+}
+
+// DefaultLoginGuard is the LoginGuard used by the admin login
+var DefaultLoginGuard = NewLoginGuard()
+
+// loginThrottleSettings returns the configured thresholds, falling back to
+// the defaults for any which aren't set.
+func loginThrottleSettings() (maxIP int, maxAccount int, window, lockout, maxLockout time.Duration) {
+	c := config.Conf.AdminConf.LoginThrottle
+	maxIP, maxAccount = c.MaxIPAttempts, c.MaxAccountAttempts
+	window = time.Duration(c.WindowMinutes) * time.Minute
+	lockout = time.Duration(c.LockoutMinutes) * time.Minute
+	maxLockout = time.Duration(c.MaxLockoutMinutes) * time.Minute
+	if maxIP <= 0 {
+		maxIP = DefaultMaxIPAttempts
+	}
+	if maxAccount <= 0 {
+		maxAccount = DefaultMaxAccountAttempts
+	}
+	if window <= 0 {
+		window = DefaultLoginWindow
+	}
+	if lockout <= 0 {
+		lockout = DefaultLockout
+	}
+	if maxLockout <= 0 {
+		maxLockout = DefaultMaxLockout
+	}
+	return
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func accountKey(username string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// Locked returns how much longer logins from the IP address or for the
+// account are locked out, or zero if neither is. Accounts are locked out
+// whether or not they exist, so that lockouts don't reveal valid usernames.
+func (g *LoginGuard) Locked(ip string, username string) time.Duration {
+	g.Lock()
+	defer g.Unlock()
+	now := g.now()
+	remaining := time.Duration(0)
+	for _, key := range []string{ipKey(ip), accountKey(username)} {
+		f, ok := g.failures[key]
+		if !ok {
+			continue
+		}
+		if d := f.lockedUntil.Sub(now); d > remaining {
+			remaining = d
+		}
+	}
+	return remaining
+}
+
+// Fail records a failed login from the IP address for the account, locking
+// either out if it has reached its limit.
+func (g *LoginGuard) Fail(ip string, username string) {
+	maxIP, maxAccount, window, lockout, maxLockout := loginThrottleSettings()
+	g.Lock()
+	defer g.Unlock()
+	now := g.now()
+	g.prune(now, window, maxLockout)
+	for _, key := range []string{ipKey(ip), accountKey(username)} {
+		max := maxIP
+		if key == accountKey(username) {
+			max = maxAccount
+		}
+		f, ok := g.failures[key]
+		if !ok {
+			f = &loginFailures{start: now}
+			g.failures[key] = f
+		}
+		if now.Sub(f.start) > window {
+			f.count = 0
+			f.start = now
+		}
+		f.count++
+		f.last = now
+		if f.count < max {
+			continue
+		}
+		d := lockout << uint(f.lockouts)
+		if d > maxLockout || d <= 0 {
+			d = maxLockout
+		}
+		f.lockouts++
+		f.count = 0
+		f.start = now
+		f.lockedUntil = now.Add(d)
+		log.WithFields(logrus.Fields{
+			"event":    "login_lockout",
+			"ip":       ip,
+			"username": username,
+			"key":      key,
+			"attempts": max,
+			"lockout":  d.String(),
+		}).Warn("Locking out logins after repeated failures")
+	}
+}
+
+// Succeed clears the failed logins for the account once its user has
+// logged in. Failures from the IP address are kept, so that a user logging
+// in to their own account can't reset the limit for guessing others.
+func (g *LoginGuard) Succeed(username string) {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.failures, accountKey(username))
+}
+
+// prune removes IP addresses and accounts which have had no failed logins
+// for long enough that they'd no longer have any effect.
+func (g *LoginGuard) prune(now time.Time, window, maxLockout time.Duration) {
+	idle := window
+	if maxLockout > idle {
+		idle = maxLockout
+	}
+	for key, f := range g.failures {
+		if now.Sub(f.last) > idle && now.After(f.lockedUntil) {
+			delete(g.failures, key)
+		}
+	}
+}
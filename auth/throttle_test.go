@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	"github.com/stretchr/testify/suite"
+)
+
+// LoginGuardSuite is a suite of tests to cover login throttling
+type LoginGuardSuite struct {
+	suite.Suite
+	guard *LoginGuard
+	now   time.Time
+}
+
+func (s *LoginGuardSuite) SetupTest() {
+	config.Conf.AdminConf.LoginThrottle = config.LoginThrottle{
+		MaxIPAttempts:      4,
+		MaxAccountAttempts: 2,
+		WindowMinutes:      10,
+		LockoutMinutes:     1,
+		MaxLockoutMinutes:  3,
+	}
+	s.now = time.Unix(1500000000, 0)
+	s.guard = NewLoginGuard()
+	s.guard.now = func() time.Time { return s.now }
+}
+
+func (s *LoginGuardSuite) TearDownTest() {
+	config.Conf.AdminConf.LoginThrottle = config.LoginThrottle{}
+}
+
+func (s *LoginGuardSuite) TestAccountLockout() {
+	s.guard.Fail("192.0.2.1", "admin")
+	s.Equal(time.Duration(0), s.guard.Locked("192.0.2.1", "admin"))
+	s.guard.Fail("192.0.2.2", "Admin")
+	// The account is locked out from every IP address
+	s.Equal(time.Minute, s.guard.Locked("192.0.2.3", "admin"))
+	s.Equal(time.Duration(0), s.guard.Locked("192.0.2.3", "other"))
+
+	s.now = s.now.Add(time.Minute)
+	s.Equal(time.Duration(0), s.guard.Locked("192.0.2.1", "admin"))
+}
+
+func (s *LoginGuardSuite) TestExponentialBackoff() {
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		s.guard.Fail("192.0.2.1", "admin")
+		s.guard.Fail("192.0.2.2", "admin")
+		s.Equal(expected, s.guard.Locked("192.0.2.3", "admin"))
+		s.now = s.now.Add(expected)
+	}
+	// Logging in successfully resets the backoff
+	s.guard.Succeed("admin")
+	s.guard.Fail("192.0.2.1", "admin")
+	s.guard.Fail("192.0.2.2", "admin")
+	s.Equal(time.Minute, s.guard.Locked("192.0.2.3", "admin"))
+}
+
+func (s *LoginGuardSuite) TestIPLockout() {
+	for _, username := range []string{"a", "b", "c", "d"} {
+		s.guard.Fail("192.0.2.1", username)
+	}
+	s.Equal(time.Minute, s.guard.Locked("192.0.2.1", "e"))
+	s.Equal(time.Duration(0), s.guard.Locked("192.0.2.2", "e"))
+	// Logging in successfully doesn't reset the IP address' failures
+	s.guard.Succeed("a")
+	s.Equal(time.Minute, s.guard.Locked("192.0.2.1", "e"))
+}
+
+func (s *LoginGuardSuite) TestWindow() {
+	s.guard.Fail("192.0.2.1", "admin")
+	s.now = s.now.Add(11 * time.Minute)
+	s.guard.Fail("192.0.2.1", "admin")
+	s.Equal(time.Duration(0), s.guard.Locked("192.0.2.1", "admin"))
+}
+
+func TestLoginGuardSuite(t *testing.T) {
+	suite.Run(t, new(LoginGuardSuite))
+}
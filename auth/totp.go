@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gophish/gophish/models"
+)
+
+// TOTPIssuer is the issuer shown next to the account in authenticator apps
+var TOTPIssuer = "Gophish"
+
+// TOTPPeriod is how long each TOTP code is valid for
+const TOTPPeriod = 30 * time.Second
+
+// TOTPDigits is the number of digits in each TOTP code
+const TOTPDigits = 6
+
+// TOTPSkew is the number of periods before and after the current one whose
+// codes are also accepted, to allow for clock drift
+const TOTPSkew = 1
+
+// RecoveryCodeCount is the number of recovery codes generated when two-factor
+// authentication is enabled
+const RecoveryCodeCount = 10
+
+// ErrInvalidTOTPCode is thrown when a user provides an incorrect or reused
+// TOTP code or recovery code
+var ErrInvalidTOTPCode = errors.New("Invalid authentication code")
+
+// ErrTOTPNotEnrolled is thrown when a user verifies a TOTP code before
+// enrolling
+var ErrTOTPNotEnrolled = errors.New("Two-factor authentication hasn't been set up")
+
+// ErrTOTPAlreadyEnabled is thrown when a user with two-factor authentication
+// enabled tries to enroll again
+var ErrTOTPAlreadyEnabled = errors.New("Two-factor authentication is already enabled")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new random base32 encoded TOTP secret
+func GenerateTOTPSecret() string {
+	k := make([]byte, 20)
+	io.ReadFull(rand.Reader, k)
+	return totpEncoding.EncodeToString(k)
+}
+
+// TOTPProvisioningURI returns the otpauth:// URI which authenticator apps use
+// to add the account, usually by scanning it as a QR code.
+func TOTPProvisioningURI(secret string, username string) string {
+	label := url.PathEscape(TOTPIssuer) + ":" + url.PathEscape(username)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", TOTPIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	q.Set("period", fmt.Sprintf("%d", int(TOTPPeriod.Seconds())))
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, q.Encode())
+}
+
+// totpCode returns the code for the given counter, as described in RFC 4226
+func totpCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod), nil
+}
+
+// GenerateTOTPCode returns the TOTP code for the secret at the given time
+func GenerateTOTPCode(secret string, t time.Time) (string, error) {
+	return totpCode(secret, t.Unix()/int64(TOTPPeriod.Seconds()))
+}
+
+// validateTOTP returns the counter the code is valid for at the given time,
+// allowing for TOTPSkew. Counters at or before last are rejected, so that
+// each code can only be used once.
+func validateTOTP(secret string, code string, t time.Time, last int64) (int64, bool) {
+	code = strings.Replace(code, " ", "", -1)
+	current := t.Unix() / int64(TOTPPeriod.Seconds())
+	for counter := current - TOTPSkew; counter <= current+TOTPSkew; counter++ {
+		if counter <= last {
+			continue
+		}
+		expected, err := totpCode(secret, counter)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// hashRecoveryCode returns the hex encoded SHA-256 hash a recovery code is
+// stored as. Recovery codes are random, so they don't need a slow hash.
+func hashRecoveryCode(code string) string {
+	h := sha256.Sum256([]byte(strings.ToLower(strings.Replace(code, "-", "", -1))))
+	return hex.EncodeToString(h[:])
+}
+
+// generateRecoveryCodes returns new recovery codes, along with the hashes
+// they're stored as.
+func generateRecoveryCodes() ([]string, []string) {
+	codes := []string{}
+	hashes := []string{}
+	for i := 0; i < RecoveryCodeCount; i++ {
+		k := make([]byte, 5)
+		io.ReadFull(rand.Reader, k)
+		code := fmt.Sprintf("%x", k)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes
+}
+
+// EnrollTOTP generates a new TOTP secret for the user, returning the
+// provisioning URI for it. Two-factor authentication isn't enabled until
+// the user verifies a code with EnableTOTP.
+func EnrollTOTP(u *models.User) (string, error) {
+	if u.TOTPEnabled {
+		return "", ErrTOTPAlreadyEnabled
+	}
+	u.TOTPSecret = GenerateTOTPSecret()
+	u.TOTPLastCounter = 0
+	err := models.PutUser(u)
+	if err != nil {
+		return "", err
+	}
+	return TOTPProvisioningURI(u.TOTPSecret, u.Username), nil
+}
+
+// EnableTOTP enables two-factor authentication for the user if the code is
+// valid for the secret they enrolled with, returning their recovery codes.
+func EnableTOTP(u *models.User, code string) ([]string, error) {
+	if u.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if u.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	counter, ok := validateTOTP(u.TOTPSecret, code, time.Now(), u.TOTPLastCounter)
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	codes, hashes := generateRecoveryCodes()
+	u.TOTPEnabled = true
+	u.TOTPLastCounter = counter
+	u.TOTPRecoveryCodes = strings.Join(hashes, ",")
+	err := models.PutUser(u)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP disables two-factor authentication for the user if the code
+// is a valid TOTP code or recovery code.
+func DisableTOTP(u *models.User, code string) error {
+	err := VerifySecondFactor(u, code)
+	if err != nil {
+		return err
+	}
+	u.TOTPEnabled = false
+	u.TOTPSecret = ""
+	u.TOTPRecoveryCodes = ""
+	u.TOTPLastCounter = 0
+	return models.PutUser(u)
+}
+
+// VerifySecondFactor checks the TOTP code or recovery code given by a user
+// with two-factor authentication enabled. Recovery codes can only be used
+// once, and are removed from the user when they are.
+func VerifySecondFactor(u *models.User, code string) error {
+	if !u.TOTPEnabled {
+		return ErrTOTPNotEnrolled
+	}
+	counter, ok := validateTOTP(u.TOTPSecret, code, time.Now(), u.TOTPLastCounter)
+	if ok {
+		u.TOTPLastCounter = counter
+		return models.PutUser(u)
+	}
+	hash := hashRecoveryCode(strings.TrimSpace(code))
+	hashes := strings.Split(u.TOTPRecoveryCodes, ",")
+	for i, h := range hashes {
+		if h != "" && subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			hashes = append(hashes[:i], hashes[i+1:]...)
+			u.TOTPRecoveryCodes = strings.Join(hashes, ",")
+			return models.PutUser(u)
+		}
+	}
+	return ErrInvalidTOTPCode
+}
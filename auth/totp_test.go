@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// TOTPSuite is a suite of tests to cover TOTP code generation and validation
+type TOTPSuite struct {
+	suite.Suite
+}
+
+// rfcSecret is the base32 encoded secret used by the RFC 6238 test vectors
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func (s *TOTPSuite) TestTOTPCode() {
+	// The SHA-1 test vectors from RFC 6238, truncated to six digits
+	for _, tc := range []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		code, err := GenerateTOTPCode(rfcSecret, time.Unix(tc.time, 0))
+		s.Nil(err)
+		s.Equal(tc.code, code)
+	}
+}
+
+func (s *TOTPSuite) TestValidateTOTP() {
+	now := time.Unix(1111111109, 0)
+	counter, ok := validateTOTP(rfcSecret, "081804", now, 0)
+	s.True(ok)
+	s.Equal(now.Unix()/30, counter)
+
+	// Codes from the adjacent periods are accepted, to allow for clock drift
+	previous, _ := GenerateTOTPCode(rfcSecret, now.Add(-TOTPPeriod))
+	_, ok = validateTOTP(rfcSecret, previous, now, 0)
+	s.True(ok)
+	old, _ := GenerateTOTPCode(rfcSecret, now.Add(-3*TOTPPeriod))
+	_, ok = validateTOTP(rfcSecret, old, now, 0)
+	s.False(ok)
+
+	// Codes can't be reused
+	_, ok = validateTOTP(rfcSecret, "081804", now, counter)
+	s.False(ok)
+	_, ok = validateTOTP(rfcSecret, "000000", now, 0)
+	s.False(ok)
+}
+
+func (s *TOTPSuite) TestProvisioningURI() {
+	uri := TOTPProvisioningURI(rfcSecret, "admin")
+	s.Equal("otpauth://totp/Gophish:admin?algorithm=SHA1&digits=6&issuer=Gophish&period=30&secret="+rfcSecret, uri)
+}
+
+func (s *TOTPSuite) TestRecoveryCodes() {
+	codes, hashes := generateRecoveryCodes()
+	s.Equal(RecoveryCodeCount, len(codes))
+	s.Equal(RecoveryCodeCount, len(hashes))
+	for i, code := range codes {
+		s.Equal(11, len(code))
+		s.Equal(hashes[i], hashRecoveryCode(code))
+	}
+}
+
+func TestTOTPSuite(t *testing.T) {
+	suite.Run(t, new(TOTPSuite))
+}
@@ -7,30 +7,335 @@ import (
 	log "github.com/gophish/gophish/logger"
 )
 
-// AdminServer represents the Admin server configuration details
+// LoginThrottle represents the brute-force protection settings for the Admin
+// server's login. Settings which aren't given use the defaults.
+type LoginThrottle struct {
+	MaxIPAttempts      int `json:"max_ip_attempts"`
+	MaxAccountAttempts int `json:"max_account_attempts"`
+	WindowMinutes      int `json:"window_minutes"`
+	LockoutMinutes     int `json:"lockout_minutes"`
+	MaxLockoutMinutes  int `json:"max_lockout_minutes"`
+}
+
+// SSO represents single sign-on to the Admin server through an OpenID Connect
+// provider, identified by its Issuer URL, with which gophish is registered as
+// a client with the ClientID, ClientSecret and RedirectURL, which is the
+// admin server's /login/sso/callback URL. Users are matched to gophish users
+// by the UsernameClaim of their ID token, which defaults to
+// "preferred_username", and are created with the DefaultRole if
+// AutoProvision is set. The RoleMapping maps the values of the GroupsClaim,
+// which defaults to "groups", to gophish roles, and users are given the most
+// privileged role any of their groups map to each time they log in.
+// Existing local users are only linked to the provider's accounts with the
+// same username if LinkExistingUsers is set, and admins are never linked.
+// If DisableLocalLogin is set, users can only log in through the provider.
+type SSO struct {
+	Issuer            string            `json:"issuer"`
+	ClientID          string            `json:"client_id"`
+	ClientSecret      string            `json:"client_secret"`
+	RedirectURL       string            `json:"redirect_url"`
+	Scopes            []string          `json:"scopes"`
+	UsernameClaim     string            `json:"username_claim"`
+	GroupsClaim       string            `json:"groups_claim"`
+	RoleMapping       map[string]string `json:"role_mapping"`
+	DefaultRole       string            `json:"default_role"`
+	AutoProvision     bool              `json:"auto_provision"`
+	LinkExistingUsers bool              `json:"link_existing_users"`
+	DisableLocalLogin bool              `json:"disable_local_login"`
+}
+
+// AdminServer represents the Admin server configuration details. If
+// Require2FA is set, users must set up two-factor authentication before they
+// can use the admin UI or their API keys, unless they're linked to the single
+// sign-on provider. If a ClientCAPath is given, clients must present a TLS
+// certificate signed by one of the CA certificates in the PEM file, which
+// requires TLS. If any allowed networks are given, each an IP address or a
+// network in CIDR notation, only requests from those networks are served.
+// These settings are separate from the Phish server's, so that the Phish
+// server can be exposed publicly.
 type AdminServer struct {
-	ListenURL string `json:"listen_url"`
-	UseTLS    bool   `json:"use_tls"`
-	CertPath  string `json:"cert_path"`
-	KeyPath   string `json:"key_path"`
+	ListenURL       string        `json:"listen_url"`
+	UseTLS          bool          `json:"use_tls"`
+	CertPath        string        `json:"cert_path"`
+	KeyPath         string        `json:"key_path"`
+	Require2FA      bool          `json:"require_2fa"`
+	LoginThrottle   LoginThrottle `json:"login_throttle"`
+	ClientCAPath    string        `json:"client_ca_path"`
+	AllowedNetworks []string      `json:"allowed_networks"`
+	SSO             SSO           `json:"sso"`
+}
+
+// LookupThrottle represents the protection against enumerating recipient IDs
+// on the Phish server. Once an IP address has looked up MaxInvalidLookups
+// unknown IDs within the window, every ID it requests gets the not found
+// response, delayed by DelayMilliseconds, until the window has passed.
+// Settings which aren't given use the defaults, and a negative delay sends the
+// response straight away.
+type LookupThrottle struct {
+	MaxInvalidLookups int `json:"max_invalid_lookups"`
+	WindowMinutes     int `json:"window_minutes"`
+	DelayMilliseconds int `json:"delay_ms"`
+}
+
+// DuplicateOpens represents how repeated opens of the same email are
+// collapsed. Opens of a result within WindowSeconds of its last recorded open,
+// such as the bursts of requests made by mail scanners, aren't recorded. The
+// default window is used if none is given, and a negative window records every
+// open.
+type DuplicateOpens struct {
+	WindowSeconds int `json:"window_seconds"`
+}
+
+// LinkExpiry represents how long the links in campaign emails keep working.
+// Links stop working Hours after the email is sent, and never expire if no
+// hours are given.
+type LinkExpiry struct {
+	Hours int `json:"hours"`
+}
+
+// ReverseDNS represents whether or not the reverse DNS (PTR) names of the
+// addresses recipients connect from are looked up. At most Workers lookups,
+// 10 by default, run at once and each gives up after TimeoutMilliseconds,
+// which defaults to 2 seconds. Names are cached for CacheMinutes, which
+// defaults to an hour.
+type ReverseDNS struct {
+	Enabled             bool `json:"enabled"`
+	TimeoutMilliseconds int  `json:"timeout_ms"`
+	Workers             int  `json:"workers"`
+	CacheMinutes        int  `json:"cache_minutes"`
 }
 
-// PhishServer represents the Phish server configuration details
+// PhishServer represents the Phish server configuration details. Requests
+// from the trusted proxies, each an IP address or a network in CIDR notation,
+// have their client's address taken from the ClientIPHeader, which is
+// X-Forwarded-For (the default), X-Real-IP or Forwarded. Landing pages can
+// only proxy sites at public addresses, unless they're in one of the
+// ProxyAllowedNetworks, each in CIDR notation. Form submissions which only
+// fill in the HoneypotFields, hidden inputs added to every landing page, are
+// recorded as coming from a bot.
 type PhishServer struct {
-	ListenURL string `json:"listen_url"`
-	UseTLS    bool   `json:"use_tls"`
-	CertPath  string `json:"cert_path"`
-	KeyPath   string `json:"key_path"`
+	ListenURL            string         `json:"listen_url"`
+	UseTLS               bool           `json:"use_tls"`
+	CertPath             string         `json:"cert_path"`
+	KeyPath              string         `json:"key_path"`
+	TrustedProxies       []string       `json:"trusted_proxies"`
+	ClientIPHeader       string         `json:"client_ip_header"`
+	ProxyAllowedNetworks []string       `json:"proxy_allowed_networks"`
+	LookupThrottle       LookupThrottle `json:"lookup_throttle"`
+	DuplicateOpens       DuplicateOpens `json:"duplicate_opens"`
+	LinkExpiry           LinkExpiry     `json:"link_expiry"`
+	HoneypotFields       []string       `json:"honeypot_fields"`
+	ReverseDNS           ReverseDNS     `json:"reverse_dns"`
+}
+
+// EventForwarding represents where campaign events are forwarded to, such
+// as a SIEM. The network is "tcp", "udp" or "tls" and the format is
+// "syslog", "cef" or "json". Events aren't forwarded if no address is given.
+type EventForwarding struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Format  string `json:"format"`
+}
+
+// RecipientIds represents how the IDs identifying recipients in tracking URLs
+// are generated. IDs are Length characters long, chosen from Alphabet, and
+// the defaults are used for settings which aren't given. If a SigningKey is
+// given, the IDs in tracking URLs are signed with it, so that forged IDs are
+// rejected without being looked up. Campaigns created before the key was given
+// keep accepting the unsigned IDs in the emails they've already sent, unless
+// RejectUnsigned is set, in which case unsigned IDs are rejected without being
+// looked up as well.
+type RecipientIds struct {
+	Length         int    `json:"length"`
+	Alphabet       string `json:"alphabet"`
+	SigningKey     string `json:"signing_key"`
+	RejectUnsigned bool   `json:"reject_unsigned"`
+}
+
+// Worker represents how the background worker shares the sending of campaigns
+// with the other gophish instances using the same database. Each instance
+// claims the emails it sends, holding a lease on them which is renewed while
+// they're being sent, so that the emails of an instance which stops are sent
+// by another once the lease expires. Settings which aren't given use the
+// defaults, and the instance ID defaults to the hostname.
+type Worker struct {
+	InstanceId   string `json:"instance_id"`
+	LeaseMinutes int    `json:"lease_minutes"`
+	BatchSize    int    `json:"batch_size"`
+}
+
+// Retention represents the global data retention policy. The results and
+// events of a completed campaign are anonymized or purged, according to the
+// action, once the given number of days has passed since the campaign was
+// completed. Campaigns can set their own policy, which overrides this one, and
+// no data is removed automatically if no days are given. HashKey is the
+// secret used to hash the email addresses of anonymized results.
+type Retention struct {
+	Days    int    `json:"days"`
+	Action  string `json:"action"`
+	HashKey string `json:"hash_key"`
+}
+
+// URLShortener represents how the phishing URLs of campaigns which shorten
+// their links are shortened. The "builtin" shortener serves short links from
+// BaseURL, such as "https://go.example.com", which must be a domain pointed
+// at the phishing server. The "api" shortener sends each URL to APIURL,
+// authenticated with the APIKey, and reads the short link from the
+// ResponseField of the JSON response, which defaults to "short_url". URLs
+// aren't shortened if no type is given.
+type URLShortener struct {
+	Type          string `json:"type"`
+	BaseURL       string `json:"base_url"`
+	APIURL        string `json:"api_url"`
+	APIKey        string `json:"api_key"`
+	ResponseField string `json:"response_field"`
+}
+
+// EventProcessor represents an external processor which is given each result
+// event before it's recorded, so that it can enrich or suppress the event.
+// The "http" type POSTs the event to the URL, signing it with the Secret if
+// one is given, and the "plugin" type loads the Go plugin at the Path. Only
+// the events with the given messages, such as "Clicked Link", are processed,
+// or every event if none are given. HTTP processors which don't respond
+// within the timeout, which defaults to 2 seconds, are skipped.
+type EventProcessor struct {
+	Name           string   `json:"name"`
+	Type           string   `json:"type"`
+	URL            string   `json:"url"`
+	Secret         string   `json:"secret"`
+	Path           string   `json:"path"`
+	Events         []string `json:"events"`
+	TimeoutSeconds int      `json:"timeout_seconds"`
+}
+
+// Webhook represents the endpoint which is notified of every result event,
+// in addition to the webhooks users configure. Each delivery is signed with
+// the Secret. Events aren't delivered to it if no URL is given. Users'
+// webhooks can only deliver to public addresses, unless they're in one of the
+// AllowedNetworks, each in CIDR notation.
+type Webhook struct {
+	URL             string   `json:"url"`
+	Secret          string   `json:"secret"`
+	AllowedNetworks []string `json:"allowed_networks"`
+}
+
+// Bounce represents the VERP return path addresses campaign emails are sent
+// from, which have the form LocalPart+RId@Domain, so that bounces can be
+// mapped back to the result they were sent to. The local part defaults to
+// "bounces", and emails are sent from the sending profile's address if no
+// domain is given.
+type Bounce struct {
+	Domain    string `json:"domain"`
+	LocalPart string `json:"local_part"`
+}
+
+// PasswordBreach represents whether or not passwords submitted to landing
+// pages are checked against a breached password set through the k-anonymity
+// range API at the URL, which defaults to Have I Been Pwned's. Checks which
+// take longer than the timeout, which defaults to 2 seconds, are skipped.
+type PasswordBreach struct {
+	Enabled        bool   `json:"enabled"`
+	URL            string `json:"url"`
+	TimeoutSeconds int    `json:"timeout_seconds"`
+}
+
+// EmailProviders represents how the providers hosting recipients' email
+// addresses are classified. Addresses at the CorporateDomains, or their
+// subdomains, are corporate, as are those at domains which accept mail if
+// MXLookup is set. SafeLinksHosts are the hosts mail gateways rewrite links
+// to for scanning, and default to the well known gateways if not given.
+type EmailProviders struct {
+	CorporateDomains []string `json:"corporate_domains"`
+	MXLookup         bool     `json:"mx_lookup"`
+	SafeLinksHosts   []string `json:"safe_links_hosts"`
+}
+
+// GeoSuspicion represents the networks whose engagement is suspected of not
+// coming from the recipient. HostingNetworks, in CIDR notation, and
+// HostingASNs, the autonomous system numbers looked up in the GeoIP ASN
+// database, belong to hosting and cloud providers, and TorExitNodes are the
+// IP addresses of known Tor exit nodes.
+type GeoSuspicion struct {
+	HostingNetworks []string `json:"hosting_networks"`
+	HostingASNs     []uint   `json:"hosting_asns"`
+	TorExitNodes    []string `json:"tor_exit_nodes"`
+}
+
+// Resilience represents how a campaign's resilience score is computed, as the
+// weighted average of the report rate and the fraction of recipients who
+// didn't click the link. Both weights default to 0.5 if neither is given.
+// Clicks reported within GraceMinutes of being made only count against the
+// score by 1 - GraceCredit, where the credit defaults to 0.5. No grace is
+// given if no minutes are given.
+type Resilience struct {
+	ReportWeight  float64 `json:"report_weight"`
+	NoClickWeight float64 `json:"no_click_weight"`
+	GraceMinutes  int     `json:"grace_minutes"`
+	GraceCredit   float64 `json:"grace_credit"`
+}
+
+// CorporateNetworks represents the networks belonging to the organization
+// being tested, such as its offices and VPN egress addresses. Networks are in
+// CIDR notation, and ASNs are autonomous system numbers looked up in the
+// GeoIP ASN database.
+type CorporateNetworks struct {
+	Networks []string `json:"networks"`
+	ASNs     []uint   `json:"asns"`
+}
+
+// Validation represents the checks made by a campaign's validation pass.
+// Campaigns including any of the SuppressedEmails, such as executives or
+// addresses which have opted out, are flagged.
+type Validation struct {
+	SuppressedEmails []string `json:"suppressed_emails"`
+}
+
+// Sending represents how campaign emails are spread out. Each email is sent
+// IntervalSeconds after the one before it, plus a random delay of up to
+// JitterSeconds, so that they don't arrive as a single blast. Every email is
+// sent at launch if no interval is given.
+type Sending struct {
+	IntervalSeconds int `json:"interval_seconds"`
+	JitterSeconds   int `json:"jitter_seconds"`
+}
+
+// ResultStore represents how result updates are written to the database.
+// When BatchWindowMilliseconds is set, updates to each result are held for up
+// to the window and written together, flushing early once MaxPending results
+// are waiting. Updates are written as they happen if no window is given.
+type ResultStore struct {
+	BatchWindowMilliseconds int `json:"batch_window_ms"`
+	MaxPending              int `json:"max_pending"`
 }
 
 // Config represents the configuration information.
 type Config struct {
-	AdminConf      AdminServer `json:"admin_server"`
-	PhishConf      PhishServer `json:"phish_server"`
-	DBName         string      `json:"db_name"`
-	DBPath         string      `json:"db_path"`
-	MigrationsPath string      `json:"migrations_prefix"`
-	TestFlag       bool        `json:"test_flag"`
+	AdminConf       AdminServer       `json:"admin_server"`
+	PhishConf       PhishServer       `json:"phish_server"`
+	DBName          string            `json:"db_name"`
+	DBPath          string            `json:"db_path"`
+	MigrationsPath  string            `json:"migrations_prefix"`
+	GeoIPPath       string            `json:"geoip_database_path"`
+	GeoIPASNPath    string            `json:"geoip_asn_database_path"`
+	GeoIPReload     int               `json:"geoip_reload_minutes"`
+	ArchivePath     string            `json:"archive_path"`
+	TestFlag        bool              `json:"test_flag"`
+	EventForwarding EventForwarding   `json:"event_forwarding"`
+	RecipientIds    RecipientIds      `json:"recipient_ids"`
+	WorkerConf      Worker            `json:"worker"`
+	Retention       Retention         `json:"retention"`
+	URLShortener    URLShortener      `json:"url_shortener"`
+	EventProcessors []EventProcessor  `json:"event_processors"`
+	Webhook         Webhook           `json:"webhook"`
+	Bounce          Bounce            `json:"bounce"`
+	PasswordBreach  PasswordBreach    `json:"password_breach"`
+	EmailProviders  EmailProviders    `json:"email_providers"`
+	GeoSuspicion    GeoSuspicion      `json:"geo_suspicion"`
+	Corporate       CorporateNetworks `json:"corporate_networks"`
+	Validation      Validation        `json:"validation"`
+	Sending         Sending           `json:"sending"`
+	ResultStore     ResultStore       `json:"result_store"`
+	Resilience      Resilience        `json:"resilience"`
 }
 
 // Conf contains the initialized configuration struct
@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/gophish/gophish/config"
+	log "github.com/gophish/gophish/logger"
+)
+
+// ErrClientCARequiresTLS is thrown when the admin server is configured to
+// require client certificates without using TLS
+var ErrClientCARequiresTLS = errors.New("client_ca_path requires use_tls on the admin server")
+
+// ErrInvalidClientCA is thrown when the admin server's client CA file doesn't
+// contain any PEM encoded certificates
+var ErrInvalidClientCA = errors.New("client_ca_path must contain PEM encoded CA certificates")
+
+// CheckAdminAccess returns an error if the admin server's allowed networks or
+// client CA aren't valid, so that a mistake in the configuration is caught
+// when gophish starts rather than leaving the admin server exposed.
+func CheckAdminAccess() error {
+	err := checkNetworks(config.Conf.AdminConf.AllowedNetworks, "allowed network")
+	if err != nil {
+		return err
+	}
+	_, err = AdminTLSConfig()
+	return err
+}
+
+// AdminTLSConfig returns the TLS configuration for the admin server, which
+// requires clients to present a certificate signed by one of the CAs in the
+// configured client CA file. It returns nil if no client CA is configured.
+func AdminTLSConfig() (*tls.Config, error) {
+	conf := config.Conf.AdminConf
+	if conf.ClientCAPath == "" {
+		return nil, nil
+	}
+	if !conf.UseTLS {
+		return nil, ErrClientCARequiresTLS
+	}
+	pem, err := ioutil.ReadFile(conf.ClientCAPath)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, ErrInvalidClientCA
+	}
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}, nil
+}
+
+// RequireAllowedNetwork only serves requests which come from one of the admin
+// server's allowed networks, if any are configured. The address checked is
+// the one the request was received from, so proxies in front of the admin
+// server must be allowed themselves.
+func RequireAllowedNetwork(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		networks := config.Conf.AdminConf.AllowedNetworks
+		if len(networks) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !inNetworks(ip, networks) {
+			log.Warnf("admin request from %s rejected: address not in an allowed network", host)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
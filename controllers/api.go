@@ -3,10 +3,16 @@ package controllers
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"text/template"
@@ -17,6 +23,7 @@ import (
 	ctx "github.com/gophish/gophish/context"
 	log "github.com/gophish/gophish/logger"
 	"github.com/gophish/gophish/models"
+	"github.com/gophish/gophish/report"
 	"github.com/gophish/gophish/util"
 	"github.com/gophish/gophish/worker"
 	"github.com/gorilla/mux"
@@ -66,10 +73,35 @@ func API_Reset(w http.ResponseWriter, r *http.Request) {
 func API_Campaigns(w http.ResponseWriter, r *http.Request) {
 	switch {
 	case r.Method == "GET":
-		cs, err := models.GetCampaigns(ctx.Get(r, "user_id").(int64))
+		q := r.URL.Query()
+		f := models.CampaignFilter{
+			Status: q.Get("status"),
+			Name:   q.Get("name"),
+			Sort:   q.Get("sort"),
+		}
+		if v := q.Get("schedule_id"); v != "" {
+			sid, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				JSONResponse(w, models.Response{Success: false, Message: "Invalid schedule_id"}, http.StatusBadRequest)
+				return
+			}
+			f.ScheduleId = sid
+		}
+		err := parseListParams(q, map[string]*int{"limit": &f.Limit, "offset": &f.Offset},
+			map[string]*time.Time{"since": &f.Since, "until": &f.Until})
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		cs, total, err := models.GetFilteredCampaigns(ctx.Get(r, "user_id").(int64), f)
+		if err == models.ErrInvalidSort {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			log.Error(err)
 		}
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
 		JSONResponse(w, cs, http.StatusOK)
 	//POST: Create a new campaign and return it as JSON
 	case r.Method == "POST":
@@ -134,9 +166,24 @@ func API_Campaigns_Id(w http.ResponseWriter, r *http.Request) {
 
 // API_Campaigns_Id_Results returns just the results for a given campaign to
 // significantly reduce the information returned.
+//
+// If any of the filtering, sorting or pagination parameters are given, only
+// the matching page of results is returned, and the total number of matching
+// results is returned in the X-Total-Count header.
 func API_Campaigns_Id_Results(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	q := r.URL.Query()
+	paged := false
+	for _, name := range []string{"limit", "offset", "status", "email", "reported", "since", "until", "sort"} {
+		if _, ok := q[name]; ok {
+			paged = true
+		}
+	}
+	if paged {
+		campaignResultsPage(w, r, id, q)
+		return
+	}
 	cr, err := models.GetCampaignResults(id, ctx.Get(r, "user_id").(int64))
 	if err != nil {
 		log.Error(err)
@@ -149,6 +196,228 @@ func API_Campaigns_Id_Results(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// campaignResultsPage returns the page of the campaign's results
+// matching the filters in the query parameters.
+func campaignResultsPage(w http.ResponseWriter, r *http.Request, id int64, q url.Values) {
+	rq := models.ResultQuery{
+		Search: q.Get("email"),
+		Sort:   q.Get("sort"),
+	}
+	for _, s := range q["status"] {
+		for _, status := range strings.Split(s, ",") {
+			if status != "" {
+				rq.Statuses = append(rq.Statuses, status)
+			}
+		}
+	}
+	if v := q.Get("reported"); v != "" {
+		reported, err := strconv.ParseBool(v)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid reported"}, http.StatusBadRequest)
+			return
+		}
+		rq.Reported = &reported
+	}
+	err := parseListParams(q, map[string]*int{"limit": &rq.Limit, "offset": &rq.Offset},
+		map[string]*time.Time{"since": &rq.Since, "until": &rq.Until})
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	cr, total, err := models.GetCampaignResultsPage(id, ctx.Get(r, "user_id").(int64), rq)
+	if err == models.ErrInvalidSort {
+		JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		JSONResponse(w, models.Response{Success: false, Message: "Campaign not found"}, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	JSONResponse(w, cr, http.StatusOK)
+}
+
+// API_Results_RId_Tags returns the tags attached to a result if requested via
+// GET. If requested via PUT, API_Results_RId_Tags replaces the result's tags
+// with the given list of tags.
+func API_Results_RId_Tags(w http.ResponseWriter, r *http.Request) {
+	rid := mux.Vars(r)["rid"]
+	uid := ctx.Get(r, "user_id").(int64)
+	switch {
+	case r.Method == "GET":
+		ts, err := models.GetResultTags(rid, uid)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Result not found"}, http.StatusNotFound)
+			return
+		}
+		JSONResponse(w, ts, http.StatusOK)
+	case r.Method == "PUT":
+		tags := []string{}
+		err := json.NewDecoder(r.Body).Decode(&tags)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
+			return
+		}
+		ts, err := models.PutResultTags(rid, uid, tags)
+		if err == gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Result not found"}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		JSONResponse(w, ts, http.StatusOK)
+	}
+}
+
+// API_Results_RId_Notes returns the notes written about a result if requested
+// via GET. If requested via POST, API_Results_RId_Notes adds a note to the
+// result and returns a reference to it.
+func API_Results_RId_Notes(w http.ResponseWriter, r *http.Request) {
+	rid := mux.Vars(r)["rid"]
+	uid := ctx.Get(r, "user_id").(int64)
+	switch {
+	case r.Method == "GET":
+		ns, err := models.GetResultNotes(rid, uid)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Result not found"}, http.StatusNotFound)
+			return
+		}
+		JSONResponse(w, ns, http.StatusOK)
+	case r.Method == "POST":
+		n := models.ResultNote{}
+		err := json.NewDecoder(r.Body).Decode(&n)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
+			return
+		}
+		err = models.PostResultNote(rid, uid, &n)
+		if err == gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Result not found"}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		JSONResponse(w, n, http.StatusCreated)
+	}
+}
+
+// API_Results_RId_Notes_Id changes the text of a note written about a result
+// if requested via PUT, and deletes it if requested via DELETE.
+func API_Results_RId_Notes_Id(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	uid := ctx.Get(r, "user_id").(int64)
+	switch {
+	case r.Method == "PUT":
+		n := models.ResultNote{}
+		err := json.NewDecoder(r.Body).Decode(&n)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
+			return
+		}
+		if n.Id != id {
+			JSONResponse(w, models.Response{Success: false, Message: "/:id and /:note_id mismatch"}, http.StatusBadRequest)
+			return
+		}
+		err = models.PutResultNote(vars["rid"], uid, &n)
+		if err == gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Note not found"}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		JSONResponse(w, n, http.StatusOK)
+	case r.Method == "DELETE":
+		err := models.DeleteResultNote(vars["rid"], id, uid)
+		if err == gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Note not found"}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting note"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "Note deleted successfully!"}, http.StatusOK)
+	}
+}
+
+// StreamKeepAlive is how often a comment is sent to clients streaming a
+// campaign's events, so that idle connections aren't closed by proxies.
+var StreamKeepAlive = 15 * time.Second
+
+// API_Campaigns_Id_Stream streams the events recorded for a campaign's
+// results as server-sent events as they occur, so that the results can be
+// watched live without polling. Each event's id is sent, so clients which
+// reconnect with the Last-Event-ID header are sent the events they missed.
+func API_Campaigns_Id_Stream(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	uid := ctx.Get(r, "user_id").(int64)
+	_, err := models.GetCampaignSummary(id, uid)
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Campaign not found"}, http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		JSONResponse(w, models.Response{Success: false, Message: "Streaming is not supported"}, http.StatusInternalServerError)
+		return
+	}
+	// Subscribe before catching up, so that no events are missed in between
+	events, unsubscribe := models.SubscribeCampaignEvents(id)
+	defer unsubscribe()
+	var last int64
+	missed := []models.StreamEvent{}
+	if lid := r.Header.Get("Last-Event-ID"); lid != "" {
+		last, _ = strconv.ParseInt(lid, 10, 64)
+		missed, err = models.GetCampaignStreamEvents(id, uid, last)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	send := func(e models.StreamEvent) {
+		if e.Id <= last {
+			return
+		}
+		last = e.Id
+		data, err := json.Marshal(e)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.Id, data)
+	}
+	for _, e := range missed {
+		send(e)
+	}
+	flusher.Flush()
+	keepAlive := time.NewTicker(StreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			send(e)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
 // API_Campaigns_Id_Summary returns just the summary for a given campaign.
 func API_Campaign_Id_Summary(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -185,350 +454,1641 @@ func API_Campaigns_Id_Complete(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// API_Groups returns a list of groups if requested via GET.
-// If requested via POST, API_Groups creates a new group and returns a reference to it.
-func API_Groups(w http.ResponseWriter, r *http.Request) {
+// API_Campaigns_Id_Anonymize removes the personal information from a
+// completed campaign's results immediately, anonymizing or purging them with
+// the given action, or the campaign's retention action if none is given.
+func API_Campaigns_Id_Anonymize(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
 	switch {
-	case r.Method == "GET":
-		gs, err := models.GetGroups(ctx.Get(r, "user_id").(int64))
+	case r.Method == "POST":
+		req := struct {
+			Action string `json:"action"`
+		}{}
+		// The body is optional, so an empty one uses the retention action
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && err != io.EOF {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid JSON structure"}, http.StatusBadRequest)
+			return
+		}
+		action, err := models.AnonymizeCampaign(id, ctx.Get(r, "user_id").(int64), req.Action)
+		if err == gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Campaign not found"}, http.StatusNotFound)
+			return
+		}
+		if err == models.ErrCampaignNotCompleted || err == models.ErrInvalidRetentionAction {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: "No groups found"}, http.StatusNotFound)
+			JSONResponse(w, models.Response{Success: false, Message: "Error anonymizing campaign"}, http.StatusInternalServerError)
 			return
 		}
-		JSONResponse(w, gs, http.StatusOK)
-	//POST: Create a new group and return it as JSON
+		message := "Campaign results anonymized successfully!"
+		if action == models.RETENTION_PURGE {
+			message = "Campaign results purged successfully!"
+		}
+		JSONResponse(w, models.Response{Success: true, Message: message}, http.StatusOK)
+	}
+}
+
+// API_Campaigns_Id_Pause stops a campaign from sending any more emails until
+// it's resumed.
+func API_Campaigns_Id_Pause(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	switch {
 	case r.Method == "POST":
-		g := models.Group{}
-		// Put the request into a group
-		err := json.NewDecoder(r.Body).Decode(&g)
-		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: "Invalid JSON structure"}, http.StatusBadRequest)
+		err := models.PauseCampaign(id, ctx.Get(r, "user_id").(int64))
+		if err == gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Campaign not found"}, http.StatusNotFound)
 			return
 		}
-		_, err = models.GetGroupByName(g.Name, ctx.Get(r, "user_id").(int64))
-		if err != gorm.ErrRecordNotFound {
-			JSONResponse(w, models.Response{Success: false, Message: "Group name already in use"}, http.StatusConflict)
+		if err == models.ErrCampaignNotPausable {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
 			return
 		}
-		g.ModifiedDate = time.Now().UTC()
-		g.UserId = ctx.Get(r, "user_id").(int64)
-		err = models.PostGroup(&g)
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			JSONResponse(w, models.Response{Success: false, Message: "Error pausing campaign"}, http.StatusInternalServerError)
 			return
 		}
-		JSONResponse(w, g, http.StatusCreated)
+		JSONResponse(w, models.Response{Success: true, Message: "Campaign paused successfully!"}, http.StatusOK)
 	}
 }
 
-// API_Groups_Summary returns a summary of the groups owned by the current user.
-func API_Groups_Summary(w http.ResponseWriter, r *http.Request) {
+// API_Campaigns_Id_Resume continues sending the emails of a paused campaign,
+// pushing the remaining sends back by the time it was paused for.
+func API_Campaigns_Id_Resume(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
 	switch {
-	case r.Method == "GET":
-		gs, err := models.GetGroupSummaries(ctx.Get(r, "user_id").(int64))
+	case r.Method == "POST":
+		err := models.ResumeCampaign(id, ctx.Get(r, "user_id").(int64))
+		if err == gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Campaign not found"}, http.StatusNotFound)
+			return
+		}
+		if err == models.ErrCampaignNotPaused {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			log.Error(err)
-			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
+			JSONResponse(w, models.Response{Success: false, Message: "Error resuming campaign"}, http.StatusInternalServerError)
 			return
 		}
-		JSONResponse(w, gs, http.StatusOK)
+		JSONResponse(w, models.Response{Success: true, Message: "Campaign resumed successfully!"}, http.StatusOK)
 	}
 }
 
-// API_Groups_Id returns details about the requested group.
-// If the group is not valid, API_Groups_Id returns null.
-func API_Groups_Id(w http.ResponseWriter, r *http.Request) {
+// API_Campaigns_Id_Previews returns a page of the campaign's emails and
+// landing pages, rendered for each of its results without sending them, so
+// that a dry run campaign can be checked for mistakes in its templates. The
+// total number of results is returned in the X-Total-Count header.
+func API_Campaigns_Id_Previews(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, _ := strconv.ParseInt(vars["id"], 0, 64)
-	g, err := models.GetGroup(id, ctx.Get(r, "user_id").(int64))
+	uid := ctx.Get(r, "user_id").(int64)
+	if r.Method != "GET" {
+		JSONResponse(w, models.Response{Success: false, Message: "Method not allowed"}, http.StatusBadRequest)
+		return
+	}
+	rq := models.ResultQuery{}
+	err := parseListParams(r.URL.Query(), map[string]*int{"limit": &rq.Limit, "offset": &rq.Offset}, nil)
 	if err != nil {
-		JSONResponse(w, models.Response{Success: false, Message: "Group not found"}, http.StatusNotFound)
+		JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	c, err := models.GetCampaign(id, uid)
+	if err != nil {
+		log.Error(err)
+		JSONResponse(w, models.Response{Success: false, Message: "Campaign not found"}, http.StatusNotFound)
+		return
+	}
+	rs, total, err := models.GetResultsPage(id, uid, rq)
+	if err != nil {
+		log.Error(err)
+		JSONResponse(w, models.Response{Success: false, Message: "Error rendering previews"}, http.StatusInternalServerError)
+		return
+	}
+	ps := []models.EmailPreview{}
+	for _, result := range rs {
+		p, err := models.PreviewEmail(&c, &result)
+		if err != nil {
+			p = models.EmailPreview{RId: result.RId, Email: result.Email, Errors: []string{err.Error()}}
+		}
+		previewPage(&p, c.VariantFor(&result).Page, c, result)
+		ps = append(ps, p)
+	}
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	JSONResponse(w, ps, http.StatusOK)
+}
+
+// previewPage adds the landing page rendered for the result to the preview,
+// recording any error rendering it as a problem with the preview.
+func previewPage(ep *models.EmailPreview, p models.Page, c models.Campaign, rs models.Result) {
+	htmlBuff := bytes.Buffer{}
+	err := renderLandingPage(&htmlBuff, p, c, rs)
+	if err != nil {
+		ep.Errors = append(ep.Errors, err.Error())
 		return
 	}
+	ep.Page = htmlBuff.String()
+}
+
+// API_Campaigns_Id_Copy creates a new campaign with the content and settings
+// of an existing campaign. The groups to send the copy to must be given, and
+// the name and launch date can be.
+func API_Campaigns_Id_Copy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
 	switch {
-	case r.Method == "GET":
-		JSONResponse(w, g, http.StatusOK)
-	case r.Method == "DELETE":
-		err = models.DeleteGroup(&g)
+	case r.Method == "POST":
+		c := models.Campaign{}
+		err := json.NewDecoder(r.Body).Decode(&c)
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: "Error deleting group"}, http.StatusInternalServerError)
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid JSON structure"}, http.StatusBadRequest)
 			return
 		}
-		JSONResponse(w, models.Response{Success: true, Message: "Group deleted successfully!"}, http.StatusOK)
-	case r.Method == "PUT":
-		// Change this to get from URL and uid (don't bother with id in r.Body)
-		g = models.Group{}
-		err = json.NewDecoder(r.Body).Decode(&g)
-		if g.Id != id {
-			JSONResponse(w, models.Response{Success: false, Message: "Error: /:id and group_id mismatch"}, http.StatusInternalServerError)
+		err = models.CopyCampaign(id, ctx.Get(r, "user_id").(int64), &c)
+		if err == gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Campaign not found"}, http.StatusNotFound)
 			return
 		}
-		g.ModifiedDate = time.Now().UTC()
-		g.UserId = ctx.Get(r, "user_id").(int64)
-		err = models.PutGroup(&g)
 		if err != nil {
 			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
 			return
 		}
-		JSONResponse(w, g, http.StatusOK)
+		if c.Status == models.CAMPAIGN_IN_PROGRESS {
+			go Worker.LaunchCampaign(c)
+		}
+		JSONResponse(w, c, http.StatusCreated)
 	}
 }
 
-// API_Groups_Id_Summary returns a summary of the groups owned by the current user.
-func API_Groups_Id_Summary(w http.ResponseWriter, r *http.Request) {
+// API_Campaigns_Id_Export returns the campaign's template, landing page and
+// settings as a bundle which can be imported into another gophish instance.
+func API_Campaigns_Id_Export(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
 	switch {
 	case r.Method == "GET":
-		vars := mux.Vars(r)
-		id, _ := strconv.ParseInt(vars["id"], 0, 64)
-		g, err := models.GetGroupSummary(id, ctx.Get(r, "user_id").(int64))
+		b, err := models.ExportCampaignBundle(id, ctx.Get(r, "user_id").(int64))
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: "Group not found"}, http.StatusNotFound)
+			JSONResponse(w, models.Response{Success: false, Message: "Campaign not found"}, http.StatusNotFound)
 			return
 		}
-		JSONResponse(w, g, http.StatusOK)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"campaign-%d.json\"", id))
+		JSONResponse(w, b, http.StatusOK)
 	}
 }
 
-// API_Templates handles the functionality for the /api/templates endpoint
-func API_Templates(w http.ResponseWriter, r *http.Request) {
-	switch {
+// API_Campaigns_Id_Archive moves a completed campaign to cold storage on
+// POST, and downloads the archive of an archived campaign on GET.
+func API_Campaigns_Id_Archive(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	uid := ctx.Get(r, "user_id").(int64)
+	switch {
+	case r.Method == "GET":
+		f, err := models.OpenCampaignArchive(id, uid)
+		if err == gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Campaign not found"}, http.StatusNotFound)
+			return
+		}
+		if err == models.ErrArchiveNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error opening campaign archive"}, http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"campaign-%d.zip\"", id))
+		_, err = io.Copy(w, f)
+		if err != nil {
+			log.Error(err)
+		}
+	case r.Method == "POST":
+		c, err := models.ArchiveCampaign(id, uid)
+		if err == gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Campaign not found"}, http.StatusNotFound)
+			return
+		}
+		if err == models.ErrCampaignArchived || err == models.ErrCampaignNotArchivable {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error archiving campaign"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, c, http.StatusOK)
+	}
+}
+
+// API_Campaigns_Id_Report generates a complete report on a campaign, with its
+// summary statistics, per-target outcomes, location breakdown and timeline,
+// in the format given by the format parameter: csv, xlsx or pdf. Reports are
+// CSV files by default.
+func API_Campaigns_Id_Report(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	switch {
+	case r.Method == "GET":
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = report.FormatCSV
+		}
+		// The report is generated in full before it's sent, so that errors
+		// can still be returned as JSON
+		b := &bytes.Buffer{}
+		err := models.WriteCampaignReport(b, id, ctx.Get(r, "user_id").(int64), format)
+		if err == report.ErrInvalidFormat {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err == gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Campaign not found"}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: "Error generating report"}, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", report.ContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"campaign-%d-report.%s\"", id, format))
+		_, err = b.WriteTo(w)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// parseTrendFilter parses the campaign ids, date range, interval and
+// attribute selecting the campaigns aggregated by the trend endpoints. Ids are
+// given as a comma separated list.
+func parseTrendFilter(q url.Values) (models.TrendFilter, error) {
+	f := models.TrendFilter{
+		CampaignIds: []int64{},
+		Interval:    q.Get("interval"),
+		Attribute:   q.Get("attribute"),
+	}
+	if v := q.Get("ids"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				return f, errors.New("Invalid ids")
+			}
+			f.CampaignIds = append(f.CampaignIds, id)
+		}
+	}
+	err := parseListParams(q, nil, map[string]*time.Time{"since": &f.Since, "until": &f.Until})
+	return f, err
+}
+
+// API_Campaigns_Compare returns the summaries and combined statistics of a
+// set of campaigns, given by their ids or the range of dates they were
+// launched in.
+func API_Campaigns_Compare(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		f, err := parseTrendFilter(r.URL.Query())
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		cc, err := models.CompareCampaigns(ctx.Get(r, "user_id").(int64), f)
+		if err != nil {
+			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: "Error comparing campaigns"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, cc, http.StatusOK)
+	}
+}
+
+// API_Campaigns_Trends returns the combined statistics of a set of campaigns
+// grouped by the day, week or month they were launched in.
+func API_Campaigns_Trends(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		f, err := parseTrendFilter(r.URL.Query())
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		bs, err := models.GetCampaignTrends(ctx.Get(r, "user_id").(int64), f)
+		if err == models.ErrInvalidTrendInterval {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: "Error fetching trends"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, bs, http.StatusOK)
+	}
+}
+
+// API_Campaigns_Trends_Attribute returns the trends of a set of campaigns for
+// each value of a target attribute, such as each department.
+func API_Campaigns_Trends_Attribute(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		f, err := parseTrendFilter(r.URL.Query())
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		ats, err := models.GetAttributeTrends(ctx.Get(r, "user_id").(int64), f)
+		if err == models.ErrInvalidTrendInterval {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: "Error fetching trends"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, ats, http.StatusOK)
+	}
+}
+
+// API_Campaigns_Import imports the templates and landing pages in a campaign
+// bundle, replacing those with the same names, and returns the bundle with
+// their ids.
+func API_Campaigns_Import(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "POST":
+		b := models.CampaignBundle{}
+		err := json.NewDecoder(r.Body).Decode(&b)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid JSON structure"}, http.StatusBadRequest)
+			return
+		}
+		err = models.ImportCampaignBundle(&b, ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		JSONResponse(w, b, http.StatusCreated)
+	}
+}
+
+// API_Groups returns a list of groups if requested via GET.
+// If requested via POST, API_Groups creates a new group and returns a reference to it.
+func API_Groups(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		q := r.URL.Query()
+		f := models.GroupFilter{
+			Name: q.Get("name"),
+			Sort: q.Get("sort"),
+		}
+		err := parseListParams(q, map[string]*int{"limit": &f.Limit, "offset": &f.Offset}, nil)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		gs, total, err := models.GetFilteredGroups(ctx.Get(r, "user_id").(int64), f)
+		if err == models.ErrInvalidSort {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "No groups found"}, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+		JSONResponse(w, gs, http.StatusOK)
+	//POST: Create a new group and return it as JSON
+	case r.Method == "POST":
+		g := models.Group{}
+		// Put the request into a group
+		err := json.NewDecoder(r.Body).Decode(&g)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid JSON structure"}, http.StatusBadRequest)
+			return
+		}
+		_, err = models.GetGroupByName(g.Name, ctx.Get(r, "user_id").(int64))
+		if err != gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Group name already in use"}, http.StatusConflict)
+			return
+		}
+		g.ModifiedDate = time.Now().UTC()
+		g.UserId = ctx.Get(r, "user_id").(int64)
+		err = models.PostGroup(&g)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		JSONResponse(w, g, http.StatusCreated)
+	}
+}
+
+// API_Groups_Summary returns a summary of the groups owned by the current user.
+func API_Groups_Summary(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		gs, err := models.GetGroupSummaries(ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, gs, http.StatusOK)
+	}
+}
+
+// API_Groups_Id returns details about the requested group.
+// If the group is not valid, API_Groups_Id returns null.
+func API_Groups_Id(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	g, err := models.GetGroup(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Group not found"}, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		JSONResponse(w, g, http.StatusOK)
+	case r.Method == "DELETE":
+		err = models.DeleteGroup(&g)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting group"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "Group deleted successfully!"}, http.StatusOK)
+	case r.Method == "PUT":
+		// Change this to get from URL and uid (don't bother with id in r.Body)
+		// Keep the original owner, which may be another member of the team
+		owner := g.UserId
+		g = models.Group{}
+		err = json.NewDecoder(r.Body).Decode(&g)
+		if g.Id != id {
+			JSONResponse(w, models.Response{Success: false, Message: "Error: /:id and group_id mismatch"}, http.StatusInternalServerError)
+			return
+		}
+		g.ModifiedDate = time.Now().UTC()
+		g.UserId = owner
+		err = models.PutGroup(&g)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		JSONResponse(w, g, http.StatusOK)
+	}
+}
+
+// API_Groups_Id_Summary returns a summary of the groups owned by the current user.
+func API_Groups_Id_Summary(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		vars := mux.Vars(r)
+		id, _ := strconv.ParseInt(vars["id"], 0, 64)
+		g, err := models.GetGroupSummary(id, ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Group not found"}, http.StatusNotFound)
+			return
+		}
+		JSONResponse(w, g, http.StatusOK)
+	}
+}
+
+// API_Groups_Id_Risk returns the risk scores of the targets in a group.
+func API_Groups_Id_Risk(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		vars := mux.Vars(r)
+		id, _ := strconv.ParseInt(vars["id"], 0, 64)
+		g, err := models.GetGroupRisk(id, ctx.Get(r, "user_id").(int64))
+		if err == gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Group not found"}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, g, http.StatusOK)
+	}
+}
+
+// API_Users_Risk returns the risk score of every person targeted by the
+// current user's campaigns, combined across campaigns.
+func API_Users_Risk(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		urs, err := models.GetUserRisk(ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, urs, http.StatusOK)
+	}
+}
+
+// API_Bounces records a bounce against the result it was sent to. It's used
+// as a webhook by the MTA or mailbox receiving the VERP return path addresses,
+// which posts each delivery status notification as the raw message content.
+func API_Bounces(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "POST":
+		br := struct {
+			Content string `json:"content"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&br)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error decoding JSON Request"}, http.StatusBadRequest)
+			return
+		}
+		rs, err := models.ProcessBounce(strings.NewReader(br.Content), ctx.Get(r, "user_id").(int64))
+		if err == models.ErrNotBounce {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err == models.ErrBounceNotMatched {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: "Error recording bounce"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, rs, http.StatusOK)
+	}
+}
+
+// API_Replies records a reply to a campaign email against the result it was
+// sent to. It's used as a webhook by the mailbox receiving the replies, which
+// posts each reply as the raw message content.
+func API_Replies(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "POST":
+		rr := struct {
+			Content string `json:"content"`
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&rr)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error decoding JSON Request"}, http.StatusBadRequest)
+			return
+		}
+		rs, err := models.ProcessReply(strings.NewReader(rr.Content), ctx.Get(r, "user_id").(int64))
+		if err == models.ErrAutoReply {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err == models.ErrReplyNotMatched {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: "Error recording reply"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, rs, http.StatusOK)
+	}
+}
+
+// API_Templates handles the functionality for the /api/templates endpoint
+func API_Templates(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		ts, err := models.GetTemplates(ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			log.Error(err)
+		}
+		JSONResponse(w, ts, http.StatusOK)
+	//POST: Create a new template and return it as JSON
+	case r.Method == "POST":
+		t := models.Template{}
+		// Put the request into a template
+		err := json.NewDecoder(r.Body).Decode(&t)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid JSON structure"}, http.StatusBadRequest)
+			return
+		}
+		_, err = models.GetTemplateByName(t.Name, ctx.Get(r, "user_id").(int64))
+		if err != gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Template name already in use"}, http.StatusConflict)
+			return
+		}
+		t.ModifiedDate = time.Now().UTC()
+		t.UserId = ctx.Get(r, "user_id").(int64)
+		err = models.PostTemplate(&t)
+		if err == models.ErrTemplateNameNotSpecified {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err == models.ErrTemplateMissingParameter {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error inserting template into database"}, http.StatusInternalServerError)
+			log.Error(err)
+			return
+		}
+		JSONResponse(w, t, http.StatusCreated)
+	}
+}
+
+// API_Templates_Id handles the functions for the /api/templates/:id endpoint
+func API_Templates_Id(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	t, err := models.GetTemplate(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Template not found"}, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		JSONResponse(w, t, http.StatusOK)
+	case r.Method == "DELETE":
+		err = models.DeleteTemplate(id, ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting template"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "Template deleted successfully!"}, http.StatusOK)
+	case r.Method == "PUT":
+		owner := t.UserId
+		t = models.Template{}
+		err = json.NewDecoder(r.Body).Decode(&t)
+		if err != nil {
+			log.Error(err)
+		}
+		if t.Id != id {
+			JSONResponse(w, models.Response{Success: false, Message: "Error: /:id and template_id mismatch"}, http.StatusBadRequest)
+			return
+		}
+		t.ModifiedDate = time.Now().UTC()
+		t.UserId = owner
+		err = models.PutTemplate(&t)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		JSONResponse(w, t, http.StatusOK)
+	}
+}
+
+// API_Pages handles requests for the /api/pages/ endpoint
+func API_Pages(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		ps, err := models.GetPages(ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			log.Error(err)
+		}
+		JSONResponse(w, ps, http.StatusOK)
+	//POST: Create a new page and return it as JSON
+	case r.Method == "POST":
+		p := models.Page{}
+		// Put the request into a page
+		err := json.NewDecoder(r.Body).Decode(&p)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
+			return
+		}
+		// Check to make sure the name is unique
+		_, err = models.GetPageByName(p.Name, ctx.Get(r, "user_id").(int64))
+		if err != gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "Page name already in use"}, http.StatusConflict)
+			log.Error(err)
+			return
+		}
+		p.ModifiedDate = time.Now().UTC()
+		p.UserId = ctx.Get(r, "user_id").(int64)
+		err = models.PostPage(&p)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, p, http.StatusCreated)
+	}
+}
+
+// API_Pages_Id contains functions to handle the GET'ing, DELETE'ing, and PUT'ing
+// of a Page object
+func API_Pages_Id(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	p, err := models.GetPage(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Page not found"}, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		JSONResponse(w, p, http.StatusOK)
+	case r.Method == "DELETE":
+		err = models.DeletePage(id, ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting page"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "Page Deleted Successfully"}, http.StatusOK)
+	case r.Method == "PUT":
+		owner := p.UserId
+		p = models.Page{}
+		err = json.NewDecoder(r.Body).Decode(&p)
+		if err != nil {
+			log.Error(err)
+		}
+		if p.Id != id {
+			JSONResponse(w, models.Response{Success: false, Message: "/:id and /:page_id mismatch"}, http.StatusBadRequest)
+			return
+		}
+		p.ModifiedDate = time.Now().UTC()
+		p.UserId = owner
+		err = models.PutPage(&p)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error updating page: " + err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, p, http.StatusOK)
+	}
+}
+
+// API_Pages_Id_Assets handles requests for the /api/pages/:id/assets
+// endpoint, which lists the page's assets and uploads new ones. Uploading an
+// asset with the same name as an existing one replaces it.
+func API_Pages_Id_Assets(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	p, err := models.GetPage(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Page not found"}, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		as, err := models.GetPageAssets(p.Id)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, as, http.StatusOK)
+	case r.Method == "POST":
+		a := models.PageAsset{}
+		err = json.NewDecoder(r.Body).Decode(&a)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
+			return
+		}
+		a.Id = 0
+		a.PageId = p.Id
+		err = models.PostPageAsset(&a)
+		switch err {
+		case nil:
+		case models.ErrInvalidPageAssetName, models.ErrInvalidPageAssetContent, models.ErrPageAssetTooLarge:
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		default:
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		a.Content = ""
+		JSONResponse(w, a, http.StatusCreated)
+	}
+}
+
+// API_Pages_Id_Assets_Id handles requests for the /api/pages/:id/assets/:asset_id
+// endpoint, which returns an asset along with its content, or deletes it.
+func API_Pages_Id_Assets_Id(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	aid, _ := strconv.ParseInt(vars["asset_id"], 0, 64)
+	p, err := models.GetPage(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Page not found"}, http.StatusNotFound)
+		return
+	}
+	a, err := models.GetPageAsset(p.Id, aid)
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Asset not found"}, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		JSONResponse(w, a, http.StatusOK)
+	case r.Method == "DELETE":
+		err = models.DeletePageAsset(p.Id, a.Id)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting asset"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "Asset Deleted Successfully"}, http.StatusOK)
+	}
+}
+
+// API_SMTP handles requests for the /api/smtp/ endpoint
+func API_SMTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		ss, err := models.GetSMTPs(ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			log.Error(err)
+		}
+		JSONResponse(w, ss, http.StatusOK)
+	//POST: Create a new SMTP and return it as JSON
+	case r.Method == "POST":
+		s := models.SMTP{}
+		// Put the request into a page
+		err := json.NewDecoder(r.Body).Decode(&s)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
+			return
+		}
+		// Check to make sure the name is unique
+		_, err = models.GetSMTPByName(s.Name, ctx.Get(r, "user_id").(int64))
+		if err != gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "SMTP name already in use"}, http.StatusConflict)
+			log.Error(err)
+			return
+		}
+		s.ModifiedDate = time.Now().UTC()
+		s.UserId = ctx.Get(r, "user_id").(int64)
+		err = models.PostSMTP(&s)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, s, http.StatusCreated)
+	}
+}
+
+// API_SMTP_Id contains functions to handle the GET'ing, DELETE'ing, and PUT'ing
+// of a SMTP object
+func API_SMTP_Id(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	s, err := models.GetSMTP(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "SMTP not found"}, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		JSONResponse(w, s, http.StatusOK)
+	case r.Method == "DELETE":
+		err = models.DeleteSMTP(id, ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting SMTP"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "SMTP Deleted Successfully"}, http.StatusOK)
+	case r.Method == "PUT":
+		owner := s.UserId
+		s = models.SMTP{}
+		err = json.NewDecoder(r.Body).Decode(&s)
+		if err != nil {
+			log.Error(err)
+		}
+		if s.Id != id {
+			JSONResponse(w, models.Response{Success: false, Message: "/:id and /:smtp_id mismatch"}, http.StatusBadRequest)
+			return
+		}
+		err = s.Validate()
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		s.ModifiedDate = time.Now().UTC()
+		s.UserId = owner
+		err = models.PutSMTP(&s)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error updating page"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, s, http.StatusOK)
+	}
+}
+
+// API_SMS handles requests for the /api/sms/ endpoint
+func API_SMS(w http.ResponseWriter, r *http.Request) {
+	switch {
 	case r.Method == "GET":
-		ts, err := models.GetTemplates(ctx.Get(r, "user_id").(int64))
+		ss, err := models.GetSMSProfiles(ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			log.Error(err)
+		}
+		JSONResponse(w, ss, http.StatusOK)
+	//POST: Create a new SMS profile and return it as JSON
+	case r.Method == "POST":
+		s := models.SMS{}
+		err := json.NewDecoder(r.Body).Decode(&s)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
+			return
+		}
+		// Check to make sure the name is unique
+		_, err = models.GetSMSProfileByName(s.Name, ctx.Get(r, "user_id").(int64))
+		if err != gorm.ErrRecordNotFound {
+			JSONResponse(w, models.Response{Success: false, Message: "SMS profile name already in use"}, http.StatusConflict)
+			log.Error(err)
+			return
+		}
+		s.ModifiedDate = time.Now().UTC()
+		s.UserId = ctx.Get(r, "user_id").(int64)
+		err = models.PostSMSProfile(&s)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		JSONResponse(w, s, http.StatusCreated)
+	}
+}
+
+// API_SMS_Id contains functions to handle the GET'ing, DELETE'ing, and PUT'ing
+// of an SMS profile
+func API_SMS_Id(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	s, err := models.GetSMSProfile(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "SMS profile not found"}, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		JSONResponse(w, s, http.StatusOK)
+	case r.Method == "DELETE":
+		err = models.DeleteSMSProfile(id, ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting SMS profile"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "SMS Profile Deleted Successfully"}, http.StatusOK)
+	case r.Method == "PUT":
+		owner := s.UserId
+		s = models.SMS{}
+		err = json.NewDecoder(r.Body).Decode(&s)
 		if err != nil {
 			log.Error(err)
 		}
+		if s.Id != id {
+			JSONResponse(w, models.Response{Success: false, Message: "/:id and /:sms_id mismatch"}, http.StatusBadRequest)
+			return
+		}
+		err = s.Validate()
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		s.ModifiedDate = time.Now().UTC()
+		s.UserId = owner
+		err = models.PutSMSProfile(&s)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error updating SMS profile"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, s, http.StatusOK)
+	}
+}
+
+// API_Users returns a list of users if requested via GET. API keys aren't
+// included.
+func API_Users(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		us, err := models.GetUsers()
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error fetching users"}, http.StatusInternalServerError)
+			return
+		}
+		for i := range us {
+			us[i].ApiKey = ""
+		}
+		JSONResponse(w, us, http.StatusOK)
+	}
+}
+
+// API_Users_Id returns the details of a user if requested via GET. If
+// requested via PUT, API_Users_Id updates the user's role and team.
+func API_Users_Id(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	u, err := models.GetUser(id)
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "User not found"}, http.StatusNotFound)
+		return
+	}
+	u.ApiKey = ""
+	switch {
+	case r.Method == "GET":
+		JSONResponse(w, u, http.StatusOK)
+	case r.Method == "PUT":
+		ur := struct {
+			Role   string `json:"role"`
+			TeamId int64  `json:"team_id"`
+		}{}
+		err = json.NewDecoder(r.Body).Decode(&ur)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
+			return
+		}
+		// Don't let administrators lock themselves out
+		if u.Id == ctx.Get(r, "user_id").(int64) && ur.Role != models.ROLE_ADMIN {
+			JSONResponse(w, models.Response{Success: false, Message: "You can't change your own role"}, http.StatusBadRequest)
+			return
+		}
+		// Reload the user so that the API key isn't cleared when saving
+		u, _ = models.GetUser(id)
+		u.Role = ur.Role
+		u.TeamId = ur.TeamId
+		err = u.Validate()
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		err = models.PutUser(&u)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error updating user"}, http.StatusInternalServerError)
+			return
+		}
+		u.ApiKey = ""
+		JSONResponse(w, u, http.StatusOK)
+	}
+}
+
+// API_Teams returns a list of teams if requested via GET.
+// If requested via POST, API_Teams creates a new team and returns it.
+func API_Teams(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		ts, err := models.GetTeams()
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error fetching teams"}, http.StatusInternalServerError)
+			return
+		}
 		JSONResponse(w, ts, http.StatusOK)
-	//POST: Create a new template and return it as JSON
 	case r.Method == "POST":
-		t := models.Template{}
-		// Put the request into a template
+		t := models.Team{}
 		err := json.NewDecoder(r.Body).Decode(&t)
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: "Invalid JSON structure"}, http.StatusBadRequest)
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
 			return
 		}
-		_, err = models.GetTemplateByName(t.Name, ctx.Get(r, "user_id").(int64))
-		if err != gorm.ErrRecordNotFound {
-			JSONResponse(w, models.Response{Success: false, Message: "Template name already in use"}, http.StatusConflict)
+		err = models.PostTeam(&t)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
 			return
 		}
-		t.ModifiedDate = time.Now().UTC()
-		t.UserId = ctx.Get(r, "user_id").(int64)
-		err = models.PostTemplate(&t)
-		if err == models.ErrTemplateNameNotSpecified {
+		JSONResponse(w, t, http.StatusCreated)
+	}
+}
+
+// API_Teams_Id returns the details of a team if requested via GET.
+// If requested via PUT, API_Teams_Id renames the team, and if requested via
+// DELETE, the team is deleted and its members removed from it.
+func API_Teams_Id(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	t, err := models.GetTeam(id)
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Team not found"}, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		JSONResponse(w, t, http.StatusOK)
+	case r.Method == "PUT":
+		t = models.Team{}
+		err = json.NewDecoder(r.Body).Decode(&t)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
+			return
+		}
+		if t.Id != id {
+			JSONResponse(w, models.Response{Success: false, Message: "/:id and /:team_id mismatch"}, http.StatusBadRequest)
+			return
+		}
+		err = models.PutTeam(&t)
+		if err != nil {
 			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
 			return
 		}
-		if err == models.ErrTemplateMissingParameter {
+		JSONResponse(w, t, http.StatusOK)
+	case r.Method == "DELETE":
+		err = models.DeleteTeam(id)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting team"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "Team deleted successfully!"}, http.StatusOK)
+	}
+}
+
+// API_AuditLog returns the audit log of state-changing actions, newest first.
+// Entries can be filtered by the user_id, action, resource, resource_id,
+// since and until (RFC 3339 timestamps) query parameters, and paged with
+// limit and offset.
+func API_AuditLog(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		q := r.URL.Query()
+		f := models.AuditLogFilter{
+			Action:   q.Get("action"),
+			Resource: q.Get("resource"),
+		}
+		var err error
+		for name, dst := range map[string]*int64{"user_id": &f.UserId, "resource_id": &f.ResourceId} {
+			if v := q.Get(name); v != "" {
+				*dst, err = strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					JSONResponse(w, models.Response{Success: false, Message: fmt.Sprintf("Invalid %s", name)}, http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		for name, dst := range map[string]*int{"limit": &f.Limit, "offset": &f.Offset} {
+			if v := q.Get(name); v != "" {
+				*dst, err = strconv.Atoi(v)
+				if err != nil {
+					JSONResponse(w, models.Response{Success: false, Message: fmt.Sprintf("Invalid %s", name)}, http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		for name, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+			if v := q.Get(name); v != "" {
+				*dst, err = time.Parse(time.RFC3339, v)
+				if err != nil {
+					JSONResponse(w, models.Response{Success: false, Message: fmt.Sprintf("Invalid %s", name)}, http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		as, err := models.GetAuditLogs(f)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error fetching audit log"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, as, http.StatusOK)
+	}
+}
+
+// API_Keys returns the current user's scoped API keys if requested via GET.
+// If requested via POST, API_Keys creates a new key, which is only returned in
+// the response. Scoped keys can only create keys with scopes they have.
+func API_Keys(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		ks, err := models.GetAPIKeys(ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error fetching API keys"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, ks, http.StatusOK)
+	case r.Method == "POST":
+		k := models.APIKey{}
+		err := json.NewDecoder(r.Body).Decode(&k)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
+			return
+		}
+		if current, ok := ctx.Get(r, "scoped_api_key").(models.APIKey); ok {
+			for _, scope := range k.Scopes {
+				if !current.HasScope(scope) {
+					JSONResponse(w, models.Response{Success: false, Message: "API keys can't grant scopes they don't have"}, http.StatusForbidden)
+					return
+				}
+			}
+		}
+		k.UserId = ctx.Get(r, "user_id").(int64)
+		err = models.PostAPIKey(&k)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		JSONResponse(w, k, http.StatusCreated)
+	}
+}
+
+// API_Keys_Id returns the details of a scoped API key if requested via GET.
+// If requested via DELETE, the key is revoked.
+func API_Keys_Id(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	k, err := models.GetAPIKey(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "API key not found"}, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		JSONResponse(w, k, http.StatusOK)
+	case r.Method == "DELETE":
+		err = models.RevokeAPIKey(id, ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error revoking API key"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "API Key Revoked Successfully"}, http.StatusOK)
+	}
+}
+
+// API_Webhooks returns a list of webhooks if requested via GET.
+// If requested via POST, API_Webhooks creates a new webhook and returns a
+// reference to it.
+func API_Webhooks(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		ws, err := models.GetWebhooks(ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error fetching webhooks"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, ws, http.StatusOK)
+	case r.Method == "POST":
+		wh := models.Webhook{}
+		err := json.NewDecoder(r.Body).Decode(&wh)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
+			return
+		}
+		wh.Id = 0
+		wh.UserId = ctx.Get(r, "user_id").(int64)
+		err = models.PostWebhook(&wh)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		JSONResponse(w, wh, http.StatusCreated)
+	}
+}
+
+// API_Webhooks_Id contains functions to handle the GET'ing, DELETE'ing, and
+// PUT'ing of a webhook
+func API_Webhooks_Id(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	wh, err := models.GetWebhook(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Webhook not found"}, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		JSONResponse(w, wh, http.StatusOK)
+	case r.Method == "DELETE":
+		err = models.DeleteWebhook(id, ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting webhook"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "Webhook Deleted Successfully"}, http.StatusOK)
+	case r.Method == "PUT":
+		owner := wh.UserId
+		wh = models.Webhook{}
+		err = json.NewDecoder(r.Body).Decode(&wh)
+		if err != nil {
+			log.Error(err)
+		}
+		if wh.Id != id {
+			JSONResponse(w, models.Response{Success: false, Message: "/:id and /:webhook_id mismatch"}, http.StatusBadRequest)
+			return
+		}
+		wh.UserId = owner
+		err = models.PutWebhook(&wh)
+		if err != nil {
 			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
 			return
 		}
+		JSONResponse(w, wh, http.StatusOK)
+	}
+}
+
+// API_Notifications returns a list of campaign milestone notifications if
+// requested via GET, optionally only those sent for the campaign given by
+// the campaign_id parameter. If requested via POST, API_Notifications
+// creates a new notification and returns a reference to it.
+func API_Notifications(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET":
+		cid, _ := strconv.ParseInt(r.URL.Query().Get("campaign_id"), 0, 64)
+		ns, err := models.GetNotifications(ctx.Get(r, "user_id").(int64), cid)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error fetching notifications"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, ns, http.StatusOK)
+	case r.Method == "POST":
+		n := models.Notification{}
+		err := json.NewDecoder(r.Body).Decode(&n)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
+			return
+		}
+		n.Id = 0
+		n.UserId = ctx.Get(r, "user_id").(int64)
+		err = models.PostNotification(&n)
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: "Error inserting template into database"}, http.StatusInternalServerError)
-			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
 			return
 		}
-		JSONResponse(w, t, http.StatusCreated)
+		JSONResponse(w, n, http.StatusCreated)
 	}
 }
 
-// API_Templates_Id handles the functions for the /api/templates/:id endpoint
-func API_Templates_Id(w http.ResponseWriter, r *http.Request) {
+// API_Notifications_Id contains functions to handle the GET'ing, DELETE'ing,
+// and PUT'ing of a notification
+func API_Notifications_Id(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, _ := strconv.ParseInt(vars["id"], 0, 64)
-	t, err := models.GetTemplate(id, ctx.Get(r, "user_id").(int64))
+	n, err := models.GetNotification(id, ctx.Get(r, "user_id").(int64))
 	if err != nil {
-		JSONResponse(w, models.Response{Success: false, Message: "Template not found"}, http.StatusNotFound)
+		JSONResponse(w, models.Response{Success: false, Message: "Notification not found"}, http.StatusNotFound)
 		return
 	}
 	switch {
 	case r.Method == "GET":
-		JSONResponse(w, t, http.StatusOK)
+		JSONResponse(w, n, http.StatusOK)
 	case r.Method == "DELETE":
-		err = models.DeleteTemplate(id, ctx.Get(r, "user_id").(int64))
+		err = models.DeleteNotification(id, ctx.Get(r, "user_id").(int64))
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: "Error deleting template"}, http.StatusInternalServerError)
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting notification"}, http.StatusInternalServerError)
 			return
 		}
-		JSONResponse(w, models.Response{Success: true, Message: "Template deleted successfully!"}, http.StatusOK)
+		JSONResponse(w, models.Response{Success: true, Message: "Notification Deleted Successfully"}, http.StatusOK)
 	case r.Method == "PUT":
-		t = models.Template{}
-		err = json.NewDecoder(r.Body).Decode(&t)
+		owner := n.UserId
+		n = models.Notification{}
+		err = json.NewDecoder(r.Body).Decode(&n)
 		if err != nil {
 			log.Error(err)
 		}
-		if t.Id != id {
-			JSONResponse(w, models.Response{Success: false, Message: "Error: /:id and template_id mismatch"}, http.StatusBadRequest)
+		if n.Id != id {
+			JSONResponse(w, models.Response{Success: false, Message: "/:id and /:notification_id mismatch"}, http.StatusBadRequest)
 			return
 		}
-		t.ModifiedDate = time.Now().UTC()
-		t.UserId = ctx.Get(r, "user_id").(int64)
-		err = models.PutTemplate(&t)
+		n.UserId = owner
+		err = models.PutNotification(&n)
 		if err != nil {
 			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
 			return
 		}
-		JSONResponse(w, t, http.StatusOK)
+		JSONResponse(w, n, http.StatusOK)
 	}
 }
 
-// API_Pages handles requests for the /api/pages/ endpoint
-func API_Pages(w http.ResponseWriter, r *http.Request) {
+// API_Schedules returns a list of recurring campaign schedules if requested
+// via GET. If requested via POST, API_Schedules creates a new schedule and
+// returns a reference to it.
+func API_Schedules(w http.ResponseWriter, r *http.Request) {
 	switch {
 	case r.Method == "GET":
-		ps, err := models.GetPages(ctx.Get(r, "user_id").(int64))
+		ss, err := models.GetCampaignSchedules(ctx.Get(r, "user_id").(int64))
 		if err != nil {
-			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: "Error fetching schedules"}, http.StatusInternalServerError)
+			return
 		}
-		JSONResponse(w, ps, http.StatusOK)
-	//POST: Create a new page and return it as JSON
+		JSONResponse(w, ss, http.StatusOK)
 	case r.Method == "POST":
-		p := models.Page{}
-		// Put the request into a page
-		err := json.NewDecoder(r.Body).Decode(&p)
+		s := models.CampaignSchedule{}
+		err := json.NewDecoder(r.Body).Decode(&s)
 		if err != nil {
 			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
 			return
 		}
-		// Check to make sure the name is unique
-		_, err = models.GetPageByName(p.Name, ctx.Get(r, "user_id").(int64))
-		if err != gorm.ErrRecordNotFound {
-			JSONResponse(w, models.Response{Success: false, Message: "Page name already in use"}, http.StatusConflict)
-			log.Error(err)
-			return
-		}
-		p.ModifiedDate = time.Now().UTC()
-		p.UserId = ctx.Get(r, "user_id").(int64)
-		err = models.PostPage(&p)
+		s.Id = 0
+		s.UserId = ctx.Get(r, "user_id").(int64)
+		err = models.PostCampaignSchedule(&s)
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
 			return
 		}
-		JSONResponse(w, p, http.StatusCreated)
+		JSONResponse(w, s, http.StatusCreated)
 	}
 }
 
-// API_Pages_Id contains functions to handle the GET'ing, DELETE'ing, and PUT'ing
-// of a Page object
-func API_Pages_Id(w http.ResponseWriter, r *http.Request) {
+// API_Schedules_Id contains functions to handle the GET'ing, DELETE'ing,
+// and PUT'ing of a recurring campaign schedule. The campaigns launched by the
+// schedule can be listed with the schedule_id parameter of /api/campaigns/.
+func API_Schedules_Id(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, _ := strconv.ParseInt(vars["id"], 0, 64)
-	p, err := models.GetPage(id, ctx.Get(r, "user_id").(int64))
+	s, err := models.GetCampaignSchedule(id, ctx.Get(r, "user_id").(int64))
 	if err != nil {
-		JSONResponse(w, models.Response{Success: false, Message: "Page not found"}, http.StatusNotFound)
+		JSONResponse(w, models.Response{Success: false, Message: "Schedule not found"}, http.StatusNotFound)
 		return
 	}
 	switch {
 	case r.Method == "GET":
-		JSONResponse(w, p, http.StatusOK)
+		JSONResponse(w, s, http.StatusOK)
 	case r.Method == "DELETE":
-		err = models.DeletePage(id, ctx.Get(r, "user_id").(int64))
+		err = models.DeleteCampaignSchedule(id, ctx.Get(r, "user_id").(int64))
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: "Error deleting page"}, http.StatusInternalServerError)
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting schedule"}, http.StatusInternalServerError)
 			return
 		}
-		JSONResponse(w, models.Response{Success: true, Message: "Page Deleted Successfully"}, http.StatusOK)
+		JSONResponse(w, models.Response{Success: true, Message: "Schedule Deleted Successfully"}, http.StatusOK)
 	case r.Method == "PUT":
-		p = models.Page{}
-		err = json.NewDecoder(r.Body).Decode(&p)
+		owner := s.UserId
+		s = models.CampaignSchedule{}
+		err = json.NewDecoder(r.Body).Decode(&s)
 		if err != nil {
 			log.Error(err)
 		}
-		if p.Id != id {
-			JSONResponse(w, models.Response{Success: false, Message: "/:id and /:page_id mismatch"}, http.StatusBadRequest)
+		if s.Id != id {
+			JSONResponse(w, models.Response{Success: false, Message: "/:id and /:schedule_id mismatch"}, http.StatusBadRequest)
 			return
 		}
-		p.ModifiedDate = time.Now().UTC()
-		p.UserId = ctx.Get(r, "user_id").(int64)
-		err = models.PutPage(&p)
+		s.UserId = owner
+		err = models.PutCampaignSchedule(&s)
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: "Error updating page: " + err.Error()}, http.StatusInternalServerError)
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
 			return
 		}
-		JSONResponse(w, p, http.StatusOK)
+		JSONResponse(w, s, http.StatusOK)
 	}
 }
 
-// API_SMTP handles requests for the /api/smtp/ endpoint
-func API_SMTP(w http.ResponseWriter, r *http.Request) {
+// API_Directories returns a list of the LDAP directories groups are synced
+// from if requested via GET. If requested via POST, API_Directories creates a
+// new directory and returns a reference to it.
+func API_Directories(w http.ResponseWriter, r *http.Request) {
 	switch {
 	case r.Method == "GET":
-		ss, err := models.GetSMTPs(ctx.Get(r, "user_id").(int64))
+		ds, err := models.GetDirectories(ctx.Get(r, "user_id").(int64))
 		if err != nil {
-			log.Error(err)
+			JSONResponse(w, models.Response{Success: false, Message: "Error fetching directories"}, http.StatusInternalServerError)
+			return
 		}
-		JSONResponse(w, ss, http.StatusOK)
-	//POST: Create a new SMTP and return it as JSON
+		JSONResponse(w, ds, http.StatusOK)
 	case r.Method == "POST":
-		s := models.SMTP{}
-		// Put the request into a page
-		err := json.NewDecoder(r.Body).Decode(&s)
+		d := models.Directory{}
+		err := json.NewDecoder(r.Body).Decode(&d)
 		if err != nil {
 			JSONResponse(w, models.Response{Success: false, Message: "Invalid request"}, http.StatusBadRequest)
 			return
 		}
-		// Check to make sure the name is unique
-		_, err = models.GetSMTPByName(s.Name, ctx.Get(r, "user_id").(int64))
-		if err != gorm.ErrRecordNotFound {
-			JSONResponse(w, models.Response{Success: false, Message: "SMTP name already in use"}, http.StatusConflict)
-			log.Error(err)
-			return
-		}
-		s.ModifiedDate = time.Now().UTC()
-		s.UserId = ctx.Get(r, "user_id").(int64)
-		err = models.PostSMTP(&s)
+		d.Id = 0
+		d.UserId = ctx.Get(r, "user_id").(int64)
+		err = models.PostDirectory(&d)
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
 			return
 		}
-		JSONResponse(w, s, http.StatusCreated)
+		JSONResponse(w, d, http.StatusCreated)
 	}
 }
 
-// API_SMTP_Id contains functions to handle the GET'ing, DELETE'ing, and PUT'ing
-// of a SMTP object
-func API_SMTP_Id(w http.ResponseWriter, r *http.Request) {
+// API_Directories_Id contains functions to handle the GET'ing, DELETE'ing,
+// and PUT'ing of an LDAP directory.
+func API_Directories_Id(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, _ := strconv.ParseInt(vars["id"], 0, 64)
-	s, err := models.GetSMTP(id, ctx.Get(r, "user_id").(int64))
+	d, err := models.GetDirectory(id, ctx.Get(r, "user_id").(int64))
 	if err != nil {
-		JSONResponse(w, models.Response{Success: false, Message: "SMTP not found"}, http.StatusNotFound)
+		JSONResponse(w, models.Response{Success: false, Message: "Directory not found"}, http.StatusNotFound)
 		return
 	}
 	switch {
 	case r.Method == "GET":
-		JSONResponse(w, s, http.StatusOK)
+		JSONResponse(w, d, http.StatusOK)
 	case r.Method == "DELETE":
-		err = models.DeleteSMTP(id, ctx.Get(r, "user_id").(int64))
+		err = models.DeleteDirectory(id, ctx.Get(r, "user_id").(int64))
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: "Error deleting SMTP"}, http.StatusInternalServerError)
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting directory"}, http.StatusInternalServerError)
 			return
 		}
-		JSONResponse(w, models.Response{Success: true, Message: "SMTP Deleted Successfully"}, http.StatusOK)
+		JSONResponse(w, models.Response{Success: true, Message: "Directory Deleted Successfully"}, http.StatusOK)
 	case r.Method == "PUT":
-		s = models.SMTP{}
-		err = json.NewDecoder(r.Body).Decode(&s)
+		owner := d.UserId
+		d = models.Directory{}
+		err = json.NewDecoder(r.Body).Decode(&d)
 		if err != nil {
 			log.Error(err)
 		}
-		if s.Id != id {
-			JSONResponse(w, models.Response{Success: false, Message: "/:id and /:smtp_id mismatch"}, http.StatusBadRequest)
+		if d.Id != id {
+			JSONResponse(w, models.Response{Success: false, Message: "/:id and /:directory_id mismatch"}, http.StatusBadRequest)
 			return
 		}
-		err = s.Validate()
+		d.UserId = owner
+		err = models.PutDirectory(&d)
 		if err != nil {
 			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
 			return
 		}
-		s.ModifiedDate = time.Now().UTC()
-		s.UserId = ctx.Get(r, "user_id").(int64)
-		err = models.PutSMTP(&s)
+		JSONResponse(w, d, http.StatusOK)
+	}
+}
+
+// API_Directories_Id_Sync syncs the directory's group with the directory
+// immediately, returning a report of the targets which were added, removed
+// and updated. If the dry_run parameter is true, the report is returned
+// without the group being changed.
+func API_Directories_Id_Sync(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	d, err := models.GetDirectory(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Directory not found"}, http.StatusNotFound)
+		return
+	}
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		dryRun, err = strconv.ParseBool(v)
 		if err != nil {
-			JSONResponse(w, models.Response{Success: false, Message: "Error updating page"}, http.StatusInternalServerError)
+			JSONResponse(w, models.Response{Success: false, Message: "Invalid dry_run"}, http.StatusBadRequest)
 			return
 		}
-		JSONResponse(w, s, http.StatusOK)
 	}
+	report, err := d.Sync(time.Now().UTC(), dryRun)
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: err.Error(), Data: report}, http.StatusBadGateway)
+		return
+	}
+	JSONResponse(w, report, http.StatusOK)
+}
+
+// API_Directories_Id_Reports returns the directory's most recent sync
+// reports, newest first. The number returned can be limited with the limit
+// parameter.
+func API_Directories_Id_Reports(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	limit := 0
+	err := parseListParams(r.URL.Query(), map[string]*int{"limit": &limit}, nil)
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	rs, err := models.GetDirectorySyncReports(id, ctx.Get(r, "user_id").(int64), limit)
+	if err == gorm.ErrRecordNotFound {
+		JSONResponse(w, models.Response{Success: false, Message: "Directory not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Error fetching sync reports"}, http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, rs, http.StatusOK)
+}
+
+// API_Dead_Letters returns the webhook deliveries which failed after every
+// retry.
+func API_Dead_Letters(w http.ResponseWriter, r *http.Request) {
+	ds, err := models.GetWebhookDeadLetters(ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Error fetching dead letters"}, http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, ds, http.StatusOK)
+}
+
+// API_Dead_Letters_Id returns a failed webhook delivery if requested via GET.
+// If requested via DELETE, the delivery is discarded without being replayed.
+func API_Dead_Letters_Id(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	d, err := models.GetWebhookDeadLetter(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Dead letter not found"}, http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		JSONResponse(w, d, http.StatusOK)
+	case r.Method == "DELETE":
+		err = models.DeleteWebhookDeadLetter(id, ctx.Get(r, "user_id").(int64))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: "Error deleting dead letter"}, http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "Dead Letter Deleted Successfully"}, http.StatusOK)
+	}
+}
+
+// API_Dead_Letters_Id_Replay delivers a failed webhook delivery again. It's
+// removed from the dead-letter queue once it's delivered.
+func API_Dead_Letters_Id_Replay(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.ParseInt(vars["id"], 0, 64)
+	_, err := models.GetWebhookDeadLetter(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Dead letter not found"}, http.StatusNotFound)
+		return
+	}
+	err = models.ReplayWebhookDeadLetter(id, ctx.Get(r, "user_id").(int64))
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: fmt.Sprintf("Error replaying webhook: %s", err)}, http.StatusBadGateway)
+		return
+	}
+	JSONResponse(w, models.Response{Success: true, Message: "Webhook Replayed Successfully"}, http.StatusOK)
 }
 
 // API_Import_Group imports a CSV of group members
@@ -629,6 +2189,16 @@ func API_Import_Site(w http.ResponseWriter, r *http.Request) {
 	if d.Find("head base").Length() == 0 {
 		d.Find("head").PrependHtml(fmt.Sprintf("<base href=\"%s\">", cr.URL))
 	}
+	cs := cloneResponse{}
+	if cr.IncludeResources {
+		base := resp.Request.URL
+		if href, ok := d.Find("head base").Attr("href"); ok {
+			if u, err := base.Parse(href); err == nil {
+				base = u
+			}
+		}
+		cs.Assets = cloneResources(client, d, base)
+	}
 	forms := d.Find("form")
 	forms.Each(func(i int, f *goquery.Selection) {
 		// We'll want to store where we got the form from
@@ -644,11 +2214,27 @@ func API_Import_Site(w http.ResponseWriter, r *http.Request) {
 		JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
 		return
 	}
-	cs := cloneResponse{HTML: h}
+	cs.HTML = h
 	JSONResponse(w, cs, http.StatusOK)
 	return
 }
 
+// API_GeoIP_Reload reloads the GeoIP database from disk if requested via POST,
+// so that an updated database is used without restarting Gophish.
+func API_GeoIP_Reload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		JSONResponse(w, models.Response{Success: false, Message: "Method not allowed"}, http.StatusBadRequest)
+		return
+	}
+	err := models.ReloadGeoIPDatabase()
+	if err != nil {
+		log.Error(err)
+		JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, models.Response{Success: true, Message: "GeoIP database reloaded"}, http.StatusOK)
+}
+
 // API_Send_Test_Email sends a test email using the template name
 // and Target given.
 func API_Send_Test_Email(w http.ResponseWriter, r *http.Request) {
@@ -726,6 +2312,101 @@ func API_Send_Test_Email(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// API_Test_Send sends a template to a single address, rendered for a
+// synthetic result exactly as it would be for a campaign, and returns the
+// rendered email and landing page. This lets a template's variables be
+// checked without launching a campaign.
+func API_Test_Send(w http.ResponseWriter, r *http.Request) {
+	s := &models.TestSendRequest{
+		ErrorChan: make(chan error),
+	}
+	if r.Method != "POST" {
+		JSONResponse(w, models.Response{Success: false, Message: "Method not allowed"}, http.StatusBadRequest)
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(s)
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Error decoding JSON Request"}, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validate(); err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	err = s.Prepare(ctx.Get(r, "user_id").(int64))
+	if err == models.ErrTemplateNotFound || err == models.ErrPageNotFound || err == models.ErrSMTPNotFound {
+		JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusInternalServerError)
+		return
+	}
+	p, err := s.Preview()
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	previewPage(&p, s.Page, s.Campaign(), s.Result())
+	err = Worker.TestSend(s)
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: err.Error(), Data: p}, http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, models.Response{Success: true, Message: "Email Sent", Data: p}, http.StatusOK)
+}
+
+// API_Lint renders a template, landing page, or both for a sample target or
+// an existing result, returning the rendered content along with the problems
+// found with it, so that content can be checked before it's used in a
+// campaign.
+func API_Lint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		JSONResponse(w, models.Response{Success: false, Message: "Method not allowed"}, http.StatusBadRequest)
+		return
+	}
+	l := models.LintRequest{}
+	err := json.NewDecoder(r.Body).Decode(&l)
+	if err != nil {
+		JSONResponse(w, models.Response{Success: false, Message: "Error decoding JSON Request"}, http.StatusBadRequest)
+		return
+	}
+	lr, err := l.Lint(ctx.Get(r, "user_id").(int64))
+	switch {
+	case err == models.ErrResultNotFound || err == models.ErrTemplateNotFound || err == models.ErrPageNotFound:
+		JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusNotFound)
+		return
+	case err != nil:
+		JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	JSONResponse(w, lr, http.StatusOK)
+}
+
+// parseListParams parses the integer and RFC 3339 time query parameters used
+// to filter and paginate lists into the given destinations.
+func parseListParams(q url.Values, ints map[string]*int, times map[string]*time.Time) error {
+	var err error
+	for name, dst := range ints {
+		if v := q.Get(name); v != "" {
+			*dst, err = strconv.Atoi(v)
+			if err != nil || *dst < 0 {
+				return fmt.Errorf("Invalid %s", name)
+			}
+		}
+	}
+	for name, dst := range times {
+		if v := q.Get(name); v != "" {
+			*dst, err = time.Parse(time.RFC3339, v)
+			if err != nil {
+				return fmt.Errorf("Invalid %s", name)
+			}
+		}
+	}
+	return nil
+}
+
 // JSONResponse attempts to set the status code, c, and marshal the given interface, d, into a response that
 // is written to the given ResponseWriter.
 func JSONResponse(w http.ResponseWriter, d interface{}, c int) {
@@ -751,10 +2432,109 @@ func (cr *cloneRequest) validate() error {
 	return nil
 }
 
+// cloneResponse is the cloned page, along with the images, stylesheets and
+// scripts it links to when the resources were included
 type cloneResponse struct {
-	HTML string `json:"html"`
+	HTML   string             `json:"html"`
+	Assets []models.PageAsset `json:"assets,omitempty"`
+}
+
+// clonedResources are the elements and attributes which link to the
+// resources downloaded when cloning a site with its resources
+var clonedResources = []struct {
+	selector string
+	attr     string
+}{
+	{"img[src]", "src"},
+	{"script[src]", "src"},
+	{"link[rel~=stylesheet][href]", "href"},
+	{"link[rel~=icon][href]", "href"},
+}
+
+// cloneResources downloads the resources the cloned page links to as page
+// assets, pointing the page's links at the copies hosted by the phishing
+// server. Resources which can't be downloaded are left linking to the
+// original site.
+func cloneResources(client *http.Client, d *goquery.Document, base *url.URL) []models.PageAsset {
+	assets := []models.PageAsset{}
+	// The asset names given to each downloaded resource's URL, and the
+	// names already taken
+	names := map[string]string{}
+	taken := map[string]bool{}
+	for _, res := range clonedResources {
+		d.Find(res.selector).Each(func(i int, e *goquery.Selection) {
+			ref := strings.TrimSpace(e.AttrOr(res.attr, ""))
+			if ref == "" {
+				return
+			}
+			u, err := base.Parse(ref)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return
+			}
+			u.Fragment = ""
+			name, ok := names[u.String()]
+			if !ok {
+				a, err := cloneResource(client, u, base, taken)
+				if err != nil {
+					log.Warnf("unable to clone %s: %s", u, err)
+					return
+				}
+				name = a.Name
+				names[u.String()] = name
+				taken[name] = true
+				assets = append(assets, a)
+			}
+			e.SetAttr(res.attr, "{{.AssetURL}}/"+name)
+		})
+	}
+	return assets
+}
+
+// cloneResource downloads the resource at the URL as a page asset, named
+// after its path. Resources from other hosts are named under a directory
+// for their host.
+func cloneResource(client *http.Client, u *url.URL, base *url.URL, taken map[string]bool) (models.PageAsset, error) {
+	a := models.PageAsset{}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return a, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return a, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(models.MaxPageAssetSize)+1))
+	if err != nil {
+		return a, err
+	}
+	if len(b) > models.MaxPageAssetSize {
+		return a, models.ErrPageAssetTooLarge
+	}
+	name := strings.TrimPrefix(path.Clean("/"+u.Path), "/")
+	if name == "" {
+		name = "index"
+	}
+	if u.Host != base.Host {
+		name = u.Host + "/" + name
+	}
+	name = assetNameReplacer.ReplaceAllString(name, "_")
+	// Resources at the same path with different query strings are given
+	// their own names
+	ext := path.Ext(name)
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	a.Name = unique
+	a.Type = resp.Header.Get("Content-Type")
+	a.Content = base64.StdEncoding.EncodeToString(b)
+	return a, a.Validate()
 }
 
+// assetNameReplacer matches the characters which can't be used in asset
+// names
+var assetNameReplacer = regexp.MustCompile(`[^A-Za-z0-9._/-]`)
+
 type emailResponse struct {
 	Text    string `json:"text"`
 	HTML    string `json:"html"`
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/gophish/gophish/config"
 	"github.com/gophish/gophish/models"
@@ -126,6 +128,564 @@ func (s *ControllersSuite) TestBearerToken() {
 	s.Equal(resp.StatusCode, http.StatusOK)
 }
 
+func (s *ControllersSuite) apiRequest(method string, path string, key string, body []byte) *http.Response {
+	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", as.URL, path), bytes.NewBuffer(body))
+	s.Nil(err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", key))
+	resp, err := http.DefaultClient.Do(req)
+	s.Nil(err)
+	resp.Body.Close()
+	return resp
+}
+
+func (s *ControllersSuite) TestScopedAPIKey() {
+	reqBody, _ := json.Marshal(models.APIKey{Name: "Reporting", Scopes: []string{"campaigns:read"}})
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/keys/", as.URL), bytes.NewBuffer(reqBody))
+	s.Nil(err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.ApiKey))
+	resp, err := http.DefaultClient.Do(req)
+	s.Nil(err)
+	defer resp.Body.Close()
+	s.Equal(http.StatusCreated, resp.StatusCode)
+	k := models.APIKey{}
+	s.Nil(json.NewDecoder(resp.Body).Decode(&k))
+	s.NotEqual("", k.Key)
+
+	s.Equal(http.StatusOK, s.apiRequest("GET", "/api/campaigns/", k.Key, nil).StatusCode)
+	s.Equal(http.StatusForbidden, s.apiRequest("POST", "/api/campaigns/", k.Key, []byte("{}")).StatusCode)
+	s.Equal(http.StatusForbidden, s.apiRequest("GET", "/api/groups/", k.Key, nil).StatusCode)
+
+	// Scoped keys can't create or revoke keys without the keys scopes
+	s.Equal(http.StatusForbidden, s.apiRequest("POST", "/api/keys/", k.Key, reqBody).StatusCode)
+
+	path := fmt.Sprintf("/api/keys/%d", k.Id)
+	s.Equal(http.StatusOK, s.apiRequest("DELETE", path, s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusUnauthorized, s.apiRequest("GET", "/api/campaigns/", k.Key, nil).StatusCode)
+}
+
+func (s *ControllersSuite) TestAPIKeyRequires2FA() {
+	defer func(require bool) { config.Conf.AdminConf.Require2FA = require }(config.Conf.AdminConf.Require2FA)
+	config.Conf.AdminConf.Require2FA = true
+	k := models.APIKey{UserId: 1, Name: "Reporting", Scopes: []string{"campaigns:read"}}
+	s.Nil(models.PostAPIKey(&k))
+
+	// Neither the user's own key nor their scoped keys can be used until
+	// they've set up two-factor authentication
+	s.Equal(http.StatusForbidden, s.apiRequest("GET", "/api/campaigns/", s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusForbidden, s.apiRequest("GET", "/api/campaigns/", k.Key, nil).StatusCode)
+
+	u, err := models.GetUser(1)
+	s.Nil(err)
+	u.TOTPEnabled = true
+	s.Nil(models.PutUser(&u))
+	defer func() {
+		u.TOTPEnabled = false
+		models.PutUser(&u)
+	}()
+	s.Equal(http.StatusOK, s.apiRequest("GET", "/api/campaigns/", s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusOK, s.apiRequest("GET", "/api/campaigns/", k.Key, nil).StatusCode)
+
+	// Users linked to the single sign-on provider are left to its
+	// multi-factor authentication
+	sso := models.User{Username: "sso-api", ApiKey: "sso-api-key", Role: models.ROLE_OPERATOR, SSOSubject: "sso-api"}
+	s.Nil(models.PutUser(&sso))
+	s.Equal(http.StatusOK, s.apiRequest("GET", "/api/campaigns/", sso.ApiKey, nil).StatusCode)
+}
+
+func (s *ControllersSuite) TestScopedAPIKeyEscalation() {
+	k := models.APIKey{UserId: 1, Name: "Key Manager", Scopes: []string{"keys:write"}}
+	s.Nil(models.PostAPIKey(&k))
+	reqBody, _ := json.Marshal(models.APIKey{Name: "Admin", Scopes: []string{models.SCOPE_ALL}})
+	s.Equal(http.StatusForbidden, s.apiRequest("POST", "/api/keys/", k.Key, reqBody).StatusCode)
+	reqBody, _ = json.Marshal(models.APIKey{Name: "Manager", Scopes: []string{"keys:write"}})
+	s.Equal(http.StatusCreated, s.apiRequest("POST", "/api/keys/", k.Key, reqBody).StatusCode)
+}
+
+func (s *ControllersSuite) TestRoles() {
+	auditor := models.User{Username: "auditor", ApiKey: "auditor-key", Role: models.ROLE_AUDITOR}
+	s.Nil(models.PutUser(&auditor))
+	operator := models.User{Username: "operator", ApiKey: "operator-key", Role: models.ROLE_OPERATOR}
+	s.Nil(models.PutUser(&operator))
+
+	// Auditors have read-only access
+	s.Equal(http.StatusOK, s.apiRequest("GET", "/api/campaigns/", auditor.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusForbidden, s.apiRequest("POST", "/api/groups/", auditor.ApiKey, []byte("{}")).StatusCode)
+
+	// Only administrators can manage users and teams
+	s.Equal(http.StatusForbidden, s.apiRequest("GET", "/api/users/", operator.ApiKey, nil).StatusCode)
+	reqBody, _ := json.Marshal(models.Team{Name: "Red Team"})
+	s.Equal(http.StatusForbidden, s.apiRequest("POST", "/api/teams/", operator.ApiKey, reqBody).StatusCode)
+	s.Equal(http.StatusCreated, s.apiRequest("POST", "/api/teams/", s.ApiKey, reqBody).StatusCode)
+	// Only administrators can reload the GeoIP database, which is shared by
+	// every user
+	s.Equal(http.StatusForbidden, s.apiRequest("POST", "/api/geoip/reload", operator.ApiKey, nil).StatusCode)
+	ts, err := models.GetTeams()
+	s.Nil(err)
+	s.Equal(1, len(ts))
+
+	// Adding the auditor to the admin's team shares the admin's campaigns
+	path := fmt.Sprintf("/api/users/%d", auditor.Id)
+	reqBody, _ = json.Marshal(map[string]interface{}{"role": models.ROLE_AUDITOR, "team_id": ts[0].Id})
+	s.Equal(http.StatusOK, s.apiRequest("PUT", path, s.ApiKey, reqBody).StatusCode)
+	// Administrators can't demote themselves
+	s.Equal(http.StatusBadRequest, s.apiRequest("PUT", "/api/users/1", s.ApiKey, reqBody).StatusCode)
+	reqBody, _ = json.Marshal(map[string]interface{}{"role": models.ROLE_ADMIN, "team_id": ts[0].Id})
+	s.Equal(http.StatusOK, s.apiRequest("PUT", "/api/users/1", s.ApiKey, reqBody).StatusCode)
+	c := s.getFirstCampaign()
+	_, err = models.GetCampaign(c.Id, auditor.Id)
+	s.Nil(err)
+	u, err := models.GetUser(1)
+	s.Nil(err)
+	s.Equal(models.ROLE_ADMIN, u.Role)
+	s.Equal(s.ApiKey, u.ApiKey)
+	s.Equal(http.StatusOK, s.apiRequest("DELETE", fmt.Sprintf("/api/teams/%d", ts[0].Id), s.ApiKey, nil).StatusCode)
+}
+
+func (s *ControllersSuite) TestAuditLog() {
+	t := models.Template{Name: "Audited Template", Subject: "Subject", Text: "Text"}
+	reqBody, _ := json.Marshal(t)
+	s.Equal(http.StatusCreated, s.apiRequest("POST", "/api/templates/", s.ApiKey, reqBody).StatusCode)
+	t, err := models.GetTemplateByName("Audited Template", 1)
+	s.Nil(err)
+	t.Subject = "New Subject"
+	reqBody, _ = json.Marshal(t)
+	path := fmt.Sprintf("/api/templates/%d", t.Id)
+	s.Equal(http.StatusOK, s.apiRequest("PUT", path, s.ApiKey, reqBody).StatusCode)
+	s.Equal(http.StatusOK, s.apiRequest("DELETE", path, s.ApiKey, nil).StatusCode)
+
+	as, err := models.GetAuditLogs(models.AuditLogFilter{Resource: "templates", ResourceId: t.Id})
+	s.Nil(err)
+	s.Equal(3, len(as))
+	s.Equal("templates.delete", as[0].Action)
+	s.Equal("templates.update", as[1].Action)
+	s.Equal("templates.create", as[2].Action)
+	s.Equal("admin", as[1].Username)
+	s.Equal("127.0.0.1", as[1].IP)
+	diff := map[string]models.AuditChange{}
+	s.Nil(json.Unmarshal(as[1].Diff, &diff))
+	s.Equal("New Subject", diff["subject"].After)
+	s.Nil(as[0].After)
+
+	// Operators can't read the audit log
+	operator := models.User{Username: "audit-operator", ApiKey: "audit-operator-key", Role: models.ROLE_OPERATOR}
+	s.Nil(models.PutUser(&operator))
+	s.Equal(http.StatusForbidden, s.apiRequest("GET", "/api/auditlog", operator.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusOK, s.apiRequest("GET", "/api/auditlog?resource=templates", s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusBadRequest, s.apiRequest("GET", "/api/auditlog?since=yesterday", s.ApiKey, nil).StatusCode)
+}
+
+func (s *ControllersSuite) TestCampaignResultsPage() {
+	get := func(path string) (*http.Response, models.CampaignResults) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s%s", as.URL, path), nil)
+		s.Nil(err)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.ApiKey))
+		resp, err := http.DefaultClient.Do(req)
+		s.Nil(err)
+		defer resp.Body.Close()
+		cr := models.CampaignResults{}
+		json.NewDecoder(resp.Body).Decode(&cr)
+		return resp, cr
+	}
+	campaign := s.getFirstCampaign()
+	path := fmt.Sprintf("/api/campaigns/%d/results", campaign.Id)
+
+	// Without any parameters, every result and event is returned
+	resp, cr := get(path)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("", resp.Header.Get("X-Total-Count"))
+	s.Equal(2, len(cr.Results))
+
+	resp, cr = get(path + "?sort=-email&limit=1")
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("2", resp.Header.Get("X-Total-Count"))
+	s.Equal(1, len(cr.Results))
+	s.Equal("test2@example.com", cr.Results[0].Email)
+	for _, e := range cr.Events {
+		s.NotEqual("test1@example.com", e.Email)
+	}
+
+	resp, cr = get(path + "?email=TEST1&reported=false")
+	s.Equal("1", resp.Header.Get("X-Total-Count"))
+	s.Equal("test1@example.com", cr.Results[0].Email)
+
+	resp, _ = get(path + "?sort=ip")
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp, _ = get(path + "?reported=sometimes")
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	resp = s.apiRequest("GET", "/api/campaigns/?name=test&sort=-created_date&limit=1", s.ApiKey, nil)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("1", resp.Header.Get("X-Total-Count"))
+	resp = s.apiRequest("GET", "/api/campaigns/?status="+url.QueryEscape(models.CAMPAIGN_COMPLETE), s.ApiKey, nil)
+	s.Equal("0", resp.Header.Get("X-Total-Count"))
+	resp = s.apiRequest("GET", "/api/groups/?name=group&limit=-1", s.ApiKey, nil)
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp = s.apiRequest("GET", "/api/groups/?name=group&sort=-modified_date", s.ApiKey, nil)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.NotEqual("0", resp.Header.Get("X-Total-Count"))
+}
+
+func (s *ControllersSuite) TestCampaignPreviews() {
+	campaign := s.getFirstCampaign()
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/campaigns/%d/previews?limit=1", as.URL, campaign.Id), nil)
+	s.Nil(err)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.ApiKey))
+	resp, err := http.DefaultClient.Do(req)
+	s.Nil(err)
+	defer resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("2", resp.Header.Get("X-Total-Count"))
+	ps := []models.EmailPreview{}
+	s.Nil(json.NewDecoder(resp.Body).Decode(&ps))
+	s.Equal(1, len(ps))
+	s.Equal("Test subject", ps[0].Subject)
+	s.Contains(ps[0].Page, "Test")
+	s.Equal(0, len(ps[0].Errors))
+
+	resp = s.apiRequest("GET", "/api/campaigns/9999/previews", s.ApiKey, nil)
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (s *ControllersSuite) TestTestSendValidation() {
+	body, _ := json.Marshal(models.TestSendRequest{Template: models.Template{Name: "Test Template"}})
+	resp := s.apiRequest("POST", "/api/util/test_send", s.ApiKey, body)
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	body, _ = json.Marshal(models.TestSendRequest{
+		Template: models.Template{Name: "Missing"},
+		Page:     models.Page{Name: "Test Page"},
+		SMTP:     models.SMTP{Name: "Test Page"},
+		Target:   models.Target{Email: "test@example.com"},
+	})
+	resp = s.apiRequest("POST", "/api/util/test_send", s.ApiKey, body)
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *ControllersSuite) TestCampaignAnonymize() {
+	c := s.getFirstCampaign()
+	path := fmt.Sprintf("/api/campaigns/%d/anonymize", c.Id)
+	resp := s.apiRequest("POST", path, s.ApiKey, nil)
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	s.Nil(models.CompleteCampaign(c.Id, 1))
+	body, _ := json.Marshal(map[string]string{"action": "delete"})
+	resp = s.apiRequest("POST", path, s.ApiKey, body)
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp = s.apiRequest("POST", path, s.ApiKey, nil)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	rs, err := models.ResultStorage.List(c.Id, 1)
+	s.Nil(err)
+	for _, r := range rs {
+		s.NotContains(r.Email, "@")
+		s.Equal("", r.FirstName)
+	}
+
+	resp = s.apiRequest("POST", "/api/campaigns/9999/anonymize", s.ApiKey, nil)
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (s *ControllersSuite) TestCampaignArchive() {
+	defer func(path string) { models.ArchivePath = path }(models.ArchivePath)
+	models.ArchivePath = s.T().TempDir()
+	c := s.getFirstCampaign()
+	path := fmt.Sprintf("/api/campaigns/%d/archive", c.Id)
+	s.Equal(http.StatusBadRequest, s.apiRequest("POST", path, s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusNotFound, s.apiRequest("GET", path, s.ApiKey, nil).StatusCode)
+
+	s.Nil(models.CompleteCampaign(c.Id, 1))
+	s.Equal(http.StatusOK, s.apiRequest("POST", path, s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusBadRequest, s.apiRequest("POST", path, s.ApiKey, nil).StatusCode)
+	resp := s.apiRequest("GET", path, s.ApiKey, nil)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("application/zip", resp.Header.Get("Content-Type"))
+}
+
+func (s *ControllersSuite) TestCampaignReport() {
+	c := s.getFirstCampaign()
+	for format, contentType := range map[string]string{
+		"":     "text/csv",
+		"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"pdf":  "application/pdf",
+	} {
+		resp := s.apiRequest("GET", fmt.Sprintf("/api/campaigns/%d/report?format=%s", c.Id, format), s.ApiKey, nil)
+		s.Equal(http.StatusOK, resp.StatusCode)
+		s.Equal(contentType, resp.Header.Get("Content-Type"))
+		s.Contains(resp.Header.Get("Content-Disposition"), "attachment")
+	}
+	resp := s.apiRequest("GET", fmt.Sprintf("/api/campaigns/%d/report?format=docx", c.Id), s.ApiKey, nil)
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp = s.apiRequest("GET", "/api/campaigns/9999/report", s.ApiKey, nil)
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (s *ControllersSuite) TestCampaignTrends() {
+	c := s.getFirstCampaign()
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/campaigns/compare?ids=%d", as.URL, c.Id), nil)
+	s.Nil(err)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.ApiKey))
+	resp, err := http.DefaultClient.Do(req)
+	s.Nil(err)
+	defer resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	cc := models.CampaignComparison{}
+	s.Nil(json.NewDecoder(resp.Body).Decode(&cc))
+	s.Equal(1, len(cc.Campaigns))
+	s.Equal(c.Id, cc.Campaigns[0].Id)
+
+	resp = s.apiRequest("GET", "/api/campaigns/trends?interval=week", s.ApiKey, nil)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	resp = s.apiRequest("GET", "/api/campaigns/trends/attribute?attribute=position", s.ApiKey, nil)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	resp = s.apiRequest("GET", "/api/campaigns/trends?interval=year", s.ApiKey, nil)
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp = s.apiRequest("GET", "/api/campaigns/compare?ids=1,x", s.ApiKey, nil)
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp = s.apiRequest("GET", "/api/campaigns/trends?since=yesterday", s.ApiKey, nil)
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *ControllersSuite) TestResultAnnotations() {
+	c := s.getFirstCampaign()
+	rid := c.Results[0].RId
+	body, _ := json.Marshal([]string{"VIP", "followed up"})
+	resp := s.apiRequest("PUT", fmt.Sprintf("/api/results/%s/tags", rid), s.ApiKey, body)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	resp = s.apiRequest("PUT", "/api/results/missing/tags", s.ApiKey, body)
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+
+	body, _ = json.Marshal(models.ResultNote{Text: "Called to follow up"})
+	resp = s.apiRequest("POST", fmt.Sprintf("/api/results/%s/notes", rid), s.ApiKey, body)
+	s.Equal(http.StatusCreated, resp.StatusCode)
+	body, _ = json.Marshal(models.ResultNote{})
+	resp = s.apiRequest("POST", fmt.Sprintf("/api/results/%s/notes", rid), s.ApiKey, body)
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	ns, err := models.GetResultNotes(rid, 1)
+	s.Nil(err)
+	s.Equal(1, len(ns))
+	body, _ = json.Marshal(models.ResultNote{Id: ns[0].Id, Text: "Called twice"})
+	resp = s.apiRequest("PUT", fmt.Sprintf("/api/results/%s/notes/%d", rid, ns[0].Id), s.ApiKey, body)
+	s.Equal(http.StatusOK, resp.StatusCode)
+
+	cr, err := models.GetCampaignResults(c.Id, 1)
+	s.Nil(err)
+	for _, r := range cr.Results {
+		if r.RId == rid {
+			s.Equal([]string{"VIP", "followed up"}, r.Tags)
+			s.Equal("Called twice", r.Notes[0].Text)
+		}
+	}
+
+	resp = s.apiRequest("DELETE", fmt.Sprintf("/api/results/%s/notes/%d", rid, ns[0].Id), s.ApiKey, nil)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	resp = s.apiRequest("DELETE", fmt.Sprintf("/api/results/%s/notes/%d", rid, ns[0].Id), s.ApiKey, nil)
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (s *ControllersSuite) TestLint() {
+	body, _ := json.Marshal(models.LintRequest{Template: models.Template{Name: "Test Template"}, Page: models.Page{Name: "Test Page"}})
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/util/lint", as.URL), bytes.NewBuffer(body))
+	s.Nil(err)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.ApiKey))
+	resp, err := http.DefaultClient.Do(req)
+	s.Nil(err)
+	defer resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	lr := models.LintReport{}
+	s.Nil(json.NewDecoder(resp.Body).Decode(&lr))
+	s.Equal("Test subject", lr.Subject)
+	s.Contains(lr.Page, "Test")
+	s.False(lr.Valid())
+
+	body, _ = json.Marshal(models.LintRequest{Template: models.Template{Name: "Missing"}})
+	resp = s.apiRequest("POST", "/api/util/lint", s.ApiKey, body)
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+	resp = s.apiRequest("POST", "/api/util/lint", s.ApiKey, []byte("{}"))
+	s.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *ControllersSuite) TestCampaignCopyExportImport() {
+	campaign := s.getFirstCampaign()
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/campaigns/%d/export", as.URL, campaign.Id), nil)
+	s.Nil(err)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.ApiKey))
+	resp, err := http.DefaultClient.Do(req)
+	s.Nil(err)
+	defer resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Contains(resp.Header.Get("Content-Disposition"), "attachment")
+	b := models.CampaignBundle{}
+	s.Nil(json.NewDecoder(resp.Body).Decode(&b))
+	s.Equal(campaign.Template.Name, b.Template.Name)
+
+	reqBody, _ := json.Marshal(b)
+	s.Equal(http.StatusCreated, s.apiRequest("POST", "/api/campaigns/import", s.ApiKey, reqBody).StatusCode)
+	s.Equal(http.StatusBadRequest, s.apiRequest("POST", "/api/campaigns/import", s.ApiKey, []byte(`{"version":99}`)).StatusCode)
+
+	path := fmt.Sprintf("/api/campaigns/%d/copy", campaign.Id)
+	s.Equal(http.StatusBadRequest, s.apiRequest("POST", path, s.ApiKey, []byte(`{}`)).StatusCode)
+	reqBody, _ = json.Marshal(models.Campaign{
+		Name:       "Copied campaign",
+		Groups:     []models.Group{{Name: "Test Group"}},
+		LaunchDate: time.Now().Add(time.Hour),
+	})
+	s.Equal(http.StatusCreated, s.apiRequest("POST", path, s.ApiKey, reqBody).StatusCode)
+	cs, total, err := models.GetFilteredCampaigns(1, models.CampaignFilter{Name: "Copied campaign"})
+	s.Nil(err)
+	s.Equal(int64(1), total)
+	s.Equal(campaign.TemplateId, cs[0].TemplateId)
+	s.Equal(models.CAMPAIGN_QUEUED, cs[0].Status)
+	s.Equal(http.StatusNotFound, s.apiRequest("POST", "/api/campaigns/0/copy", s.ApiKey, reqBody).StatusCode)
+}
+
+func (s *ControllersSuite) TestSchedules() {
+	campaign := s.getFirstCampaign()
+	group, err := models.GetGroupByName("Test Group", 1)
+	s.Nil(err)
+	sched := models.CampaignSchedule{
+		Name:       "Weekly",
+		TemplateId: campaign.TemplateId,
+		PageId:     campaign.PageId,
+		SMTPId:     campaign.SMTPId,
+		GroupId:    group.Id,
+		Recurrence: "every week",
+		IsActive:   true,
+	}
+	reqBody, _ := json.Marshal(sched)
+	s.Equal(http.StatusBadRequest, s.apiRequest("POST", "/api/schedules/", s.ApiKey, reqBody).StatusCode)
+
+	sched.Recurrence = "@weekly"
+	reqBody, _ = json.Marshal(sched)
+	s.Equal(http.StatusCreated, s.apiRequest("POST", "/api/schedules/", s.ApiKey, reqBody).StatusCode)
+	ss, err := models.GetCampaignSchedules(1)
+	s.Nil(err)
+	s.Equal(1, len(ss))
+	s.False(ss[0].NextRunDate.IsZero())
+
+	path := fmt.Sprintf("/api/schedules/%d", ss[0].Id)
+	ss[0].SamplePercent = 50
+	reqBody, _ = json.Marshal(ss[0])
+	s.Equal(http.StatusOK, s.apiRequest("PUT", path, s.ApiKey, reqBody).StatusCode)
+	s.Equal(http.StatusOK, s.apiRequest("DELETE", path, s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusNotFound, s.apiRequest("GET", path, s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusBadRequest, s.apiRequest("GET", "/api/campaigns/?schedule_id=weekly", s.ApiKey, nil).StatusCode)
+}
+
+func (s *ControllersSuite) TestDirectories() {
+	d := models.Directory{
+		Name:   "Sales",
+		URL:    "https://dc.example.com",
+		BaseDN: "ou=Sales,dc=example,dc=com",
+	}
+	reqBody, _ := json.Marshal(d)
+	s.Equal(http.StatusBadRequest, s.apiRequest("POST", "/api/directories/", s.ApiKey, reqBody).StatusCode)
+
+	// Nothing listens on the port, so syncing fails
+	d.URL = "ldap://127.0.0.1:1"
+	reqBody, _ = json.Marshal(d)
+	s.Equal(http.StatusCreated, s.apiRequest("POST", "/api/directories/", s.ApiKey, reqBody).StatusCode)
+	ds, err := models.GetDirectories(1)
+	s.Nil(err)
+	s.Equal(1, len(ds))
+	s.Equal("mail", ds[0].EmailAttribute)
+
+	path := fmt.Sprintf("/api/directories/%d", ds[0].Id)
+	ds[0].Recurrence = "@daily"
+	reqBody, _ = json.Marshal(ds[0])
+	s.Equal(http.StatusOK, s.apiRequest("PUT", path, s.ApiKey, reqBody).StatusCode)
+	s.Equal(http.StatusBadRequest, s.apiRequest("POST", path+"/sync?dry_run=maybe", s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusBadGateway, s.apiRequest("POST", path+"/sync", s.ApiKey, nil).StatusCode)
+
+	resp, err := http.Get(fmt.Sprintf("%s%s/reports?api_key=%s", as.URL, path, s.ApiKey))
+	s.Nil(err)
+	defer resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	rs := []models.DirectorySyncReport{}
+	s.Nil(json.NewDecoder(resp.Body).Decode(&rs))
+	s.Equal(1, len(rs))
+	s.NotEqual("", rs[0].Error)
+
+	s.Equal(http.StatusOK, s.apiRequest("DELETE", path, s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusNotFound, s.apiRequest("GET", path, s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusNotFound, s.apiRequest("GET", path+"/reports", s.ApiKey, nil).StatusCode)
+}
+
+func (s *ControllersSuite) TestWebhooks() {
+	campaign := s.getFirstCampaign()
+	wh := models.Webhook{Name: "Clicks", URL: "ftp://example.com", EventTypes: []string{models.EVENT_CLICKED}, IsActive: true}
+	reqBody, _ := json.Marshal(wh)
+	s.Equal(http.StatusBadRequest, s.apiRequest("POST", "/api/webhooks/", s.ApiKey, reqBody).StatusCode)
+
+	wh.URL = "https://203.0.113.10/hook"
+	wh.CampaignIds = []int64{campaign.Id}
+	reqBody, _ = json.Marshal(wh)
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/webhooks/", as.URL), bytes.NewBuffer(reqBody))
+	s.Nil(err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.ApiKey))
+	resp, err := http.DefaultClient.Do(req)
+	s.Nil(err)
+	defer resp.Body.Close()
+	s.Equal(http.StatusCreated, resp.StatusCode)
+	s.Nil(json.NewDecoder(resp.Body).Decode(&wh))
+	s.NotEqual(int64(0), wh.Id)
+
+	wh.IsActive = false
+	reqBody, _ = json.Marshal(wh)
+	path := fmt.Sprintf("/api/webhooks/%d", wh.Id)
+	s.Equal(http.StatusOK, s.apiRequest("PUT", path, s.ApiKey, reqBody).StatusCode)
+	got, err := models.GetWebhook(wh.Id, 1)
+	s.Nil(err)
+	s.False(got.IsActive)
+	s.Equal([]int64{campaign.Id}, got.CampaignIds)
+
+	s.Equal(http.StatusOK, s.apiRequest("GET", "/api/dead_letters/", s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusNotFound, s.apiRequest("POST", "/api/dead_letters/1000/replay", s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusOK, s.apiRequest("DELETE", path, s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusNotFound, s.apiRequest("GET", path, s.ApiKey, nil).StatusCode)
+}
+
+func (s *ControllersSuite) TestNotifications() {
+	campaign := s.getFirstCampaign()
+	n := models.Notification{
+		Name:       "Campaign Completed",
+		CampaignId: campaign.Id,
+		Type:       models.NOTIFICATION_TEAMS,
+		URL:        "https://example.webhook.office.com/webhook",
+		Milestones: []string{models.NOTIFY_CAMPAIGN_COMPLETED, models.NOTIFY_CLICK_RATE},
+	}
+	reqBody, _ := json.Marshal(n)
+	s.Equal(http.StatusBadRequest, s.apiRequest("POST", "/api/notifications/", s.ApiKey, reqBody).StatusCode)
+
+	n.ClickRate = 30
+	reqBody, _ = json.Marshal(n)
+	s.Equal(http.StatusCreated, s.apiRequest("POST", "/api/notifications/", s.ApiKey, reqBody).StatusCode)
+	ns, err := models.GetNotifications(1, campaign.Id)
+	s.Nil(err)
+	s.Equal(1, len(ns))
+	s.Equal(30, ns[0].ClickRate)
+
+	ns[0].ClickRate = 60
+	reqBody, _ = json.Marshal(ns[0])
+	path := fmt.Sprintf("/api/notifications/%d", ns[0].Id)
+	s.Equal(http.StatusOK, s.apiRequest("PUT", path, s.ApiKey, reqBody).StatusCode)
+	got, err := models.GetNotification(ns[0].Id, 1)
+	s.Nil(err)
+	s.Equal(60, got.ClickRate)
+	s.Equal(campaign.Id, got.CampaignId)
+
+	s.Equal(http.StatusOK, s.apiRequest("DELETE", path, s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusNotFound, s.apiRequest("GET", path, s.ApiKey, nil).StatusCode)
+}
+
+func (s *ControllersSuite) TestMetricsEndpoint() {
+	s.Equal(http.StatusOK, s.apiRequest("GET", "/metrics", s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusOK, s.apiRequest("GET", "/api/metrics", s.ApiKey, nil).StatusCode)
+	s.NotEqual(http.StatusOK, s.apiRequest("GET", "/metrics", "invalid", nil).StatusCode)
+}
+
 func (s *ControllersSuite) TestSiteImportBaseHref() {
 	h := "<html><head></head><body><img src=\"/test.png\"/></body></html>"
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -148,6 +708,65 @@ func (s *ControllersSuite) TestSiteImportBaseHref() {
 	s.Equal(cs.HTML, hr)
 }
 
+func (s *ControllersSuite) TestSiteImportResources() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><link rel="stylesheet" href="/css/site.css?v=1"/><link rel="stylesheet" href="/css/site.css?v=2"/></head>`+
+			`<body><img src="img/logo.png"/><img src="img/logo.png#top"/><img src="data:image/png;base64,AA=="/><img src="/missing.png"/></body></html>`)
+	})
+	mux.HandleFunc("/css/site.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		fmt.Fprint(w, "body { color: red; }")
+	})
+	mux.HandleFunc("/img/logo.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, "logo")
+	})
+	mux.HandleFunc("/missing.png", http.NotFound)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	reqBody, _ := json.Marshal(cloneRequest{URL: ts.URL + "/", IncludeResources: true})
+	resp, err := http.Post(fmt.Sprintf("%s/api/import/site?api_key=%s", as.URL, s.ApiKey), "application/json", bytes.NewBuffer(reqBody))
+	s.Nil(err)
+	defer resp.Body.Close()
+	cs := cloneResponse{}
+	s.Nil(json.NewDecoder(resp.Body).Decode(&cs))
+
+	names := []string{}
+	for _, a := range cs.Assets {
+		names = append(names, a.Name)
+	}
+	s.Equal([]string{"img/logo.png", "css/site.css", "css/site-2.css"}, names)
+	s.Equal("image/png", cs.Assets[0].Type)
+	b, err := cs.Assets[1].Data()
+	s.Nil(err)
+	s.Equal("body { color: red; }", string(b))
+	s.Contains(cs.HTML, `<link rel="stylesheet" href="{{.AssetURL}}/css/site.css"/>`)
+	s.Contains(cs.HTML, `<link rel="stylesheet" href="{{.AssetURL}}/css/site-2.css"/>`)
+	s.Contains(cs.HTML, `<img src="{{.AssetURL}}/img/logo.png"/><img src="{{.AssetURL}}/img/logo.png"/>`)
+	// Resources which can't be downloaded are left as they were
+	s.Contains(cs.HTML, `<img src="data:image/png;base64,AA=="/><img src="/missing.png"/>`)
+}
+
+func (s *ControllersSuite) TestPageAssetsAPI() {
+	p := s.getFirstCampaign().Page
+	path := fmt.Sprintf("/api/pages/%d/assets", p.Id)
+	reqBody, _ := json.Marshal(models.PageAsset{Name: "css/style.css", Content: "Ym9keSB7fQ=="})
+	s.Equal(http.StatusCreated, s.apiRequest("POST", path, s.ApiKey, reqBody).StatusCode)
+	reqBody, _ = json.Marshal(models.PageAsset{Name: "../style.css", Content: "Ym9keSB7fQ=="})
+	s.Equal(http.StatusBadRequest, s.apiRequest("POST", path, s.ApiKey, reqBody).StatusCode)
+	s.Equal(http.StatusNotFound, s.apiRequest("GET", "/api/pages/999/assets", s.ApiKey, nil).StatusCode)
+
+	as, err := models.GetPageAssets(p.Id)
+	s.Nil(err)
+	s.Equal(1, len(as))
+	s.Equal("text/css; charset=utf-8", as[0].Type)
+	assetPath := fmt.Sprintf("%s/%d", path, as[0].Id)
+	s.Equal(http.StatusOK, s.apiRequest("GET", assetPath, s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusOK, s.apiRequest("DELETE", assetPath, s.ApiKey, nil).StatusCode)
+	s.Equal(http.StatusNotFound, s.apiRequest("GET", assetPath, s.ApiKey, nil).StatusCode)
+}
+
 func (s *ControllersSuite) TearDownSuite() {
 	// Tear down the admin and phishing servers
 	as.Close()
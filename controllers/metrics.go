@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// phishRequests counts the requests handled by the phishing server, labeled
+// by the kind of request and the response's status code. The path isn't
+// used as a label, since landing page paths are chosen by the operator.
+var phishRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "gophish_phish_requests_total",
+	Help: "The number of requests handled by the phishing server, by handler and status code.",
+}, []string{"handler", "code"})
+
+// instrumentPhish counts the requests to the handler in phishRequests under
+// the given name.
+func instrumentPhish(name string, handler http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerCounter(phishRequests.MustCurryWith(prometheus.Labels{"handler": name}), handler)
+}
+
+// RegisterMetrics registers the collectors for the admin and phishing
+// servers' metrics with the given registerer. It should be called once at
+// startup.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	return reg.Register(phishRequests)
+}
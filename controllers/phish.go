@@ -7,14 +7,19 @@ import (
 	"html/template"
 	"net"
 	"net/http"
-	"net/mail"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/gophish/gophish/config"
 	ctx "github.com/gophish/gophish/context"
 	log "github.com/gophish/gophish/logger"
 	"github.com/gophish/gophish/models"
 	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 )
 
 // ErrInvalidRequest is thrown when a request with an invalid structure is
@@ -25,17 +30,221 @@ var ErrInvalidRequest = errors.New("Invalid request")
 // has already been marked as complete.
 var ErrCampaignComplete = errors.New("Event received on completed campaign")
 
+// ErrLinkExpired is thrown when an event is received for a result whose links
+// have expired.
+var ErrLinkExpired = errors.New("Event received on expired link")
+
+// The thresholds used when they aren't set in the lookup_throttle section of
+// the phish server's config.
+const (
+	DefaultMaxInvalidLookups   = 10
+	DefaultInvalidLookupWindow = 5 * time.Minute
+	DefaultInvalidLookupDelay  = 2 * time.Second
+)
+
+// MaxDelayedLookups is the most throttled responses which are delayed at
+// once. Responses beyond it are sent straight away, so that a client can't tie
+// up a goroutine for every request it makes.
+var MaxDelayedLookups int32 = 100
+
+// delayedLookups is the number of throttled responses currently being
+// delayed.
+var delayedLookups int32
+
+// MaxLookupGuardEntries is the most IP addresses the lookupGuard tracks at
+// once. Once it's reached, the addresses whose window has passed are removed,
+// followed by the address whose window started the longest ago if none have.
+var MaxLookupGuardEntries = 10000
+
+// ErrLookupThrottled is returned when a request is received from an IP
+// address that has made too many lookups for unknown recipient IDs.
+var ErrLookupThrottled = errors.New("Too many invalid recipient lookups")
+
+// lookupThrottleSettings returns the configured thresholds, falling back to
+// the defaults for any which aren't set.
+func lookupThrottleSettings() (max int, window time.Duration) {
+	c := config.Conf.PhishConf.LookupThrottle
+	max = c.MaxInvalidLookups
+	window = time.Duration(c.WindowMinutes) * time.Minute
+	if max <= 0 {
+		max = DefaultMaxInvalidLookups
+	}
+	if window <= 0 {
+		window = DefaultInvalidLookupWindow
+	}
+	return
+}
+
+// lookupThrottleDelay returns how long the not found response to a throttled
+// request is delayed, falling back to the default if it isn't set. A negative
+// delay in the config disables it.
+func lookupThrottleDelay() time.Duration {
+	delay := time.Duration(config.Conf.PhishConf.LookupThrottle.DelayMilliseconds) * time.Millisecond
+	switch {
+	case delay < 0:
+		return 0
+	case delay == 0:
+		return DefaultInvalidLookupDelay
+	}
+	return delay
+}
+
+// delayThrottled holds the response to a throttled request for the configured
+// delay, slowing down enumeration, unless MaxDelayedLookups responses are
+// already being delayed.
+func delayThrottled() {
+	delay := lookupThrottleDelay()
+	if delay <= 0 {
+		return
+	}
+	if atomic.AddInt32(&delayedLookups, 1) > MaxDelayedLookups {
+		atomic.AddInt32(&delayedLookups, -1)
+		return
+	}
+	defer atomic.AddInt32(&delayedLookups, -1)
+	time.Sleep(delay)
+}
+
+// lookupFailures tracks the invalid recipient ID lookups made by a single IP
+// address during the current window.
+type lookupFailures struct {
+	count int
+	start time.Time
+}
+
+// lookupGuard stops attempts to enumerate valid recipient IDs by tracking
+// failed lookups per source IP address. Throttled addresses get the not
+// found response for every recipient ID without it being looked up.
+type lookupGuard struct {
+	sync.Mutex
+	failures  map[string]*lookupFailures
+	lastSweep time.Time
+}
+
+func newLookupGuard() *lookupGuard {
+	return &lookupGuard{failures: make(map[string]*lookupFailures), lastSweep: time.Now()}
+}
+
+// throttled returns whether or not the given IP address has exceeded the
+// allowed number of invalid lookups in the current window.
+func (g *lookupGuard) throttled(ip string) bool {
+	max, window := lookupThrottleSettings()
+	g.Lock()
+	defer g.Unlock()
+	f, ok := g.failures[ip]
+	if !ok {
+		return false
+	}
+	if time.Since(f.start) > window {
+		delete(g.failures, ip)
+		return false
+	}
+	return f.count >= max
+}
+
+// fail records an invalid lookup for the given IP address.
+func (g *lookupGuard) fail(ip string) {
+	_, window := lookupThrottleSettings()
+	g.Lock()
+	defer g.Unlock()
+	now := time.Now()
+	f, ok := g.failures[ip]
+	if !ok || now.Sub(f.start) > window {
+		if !ok && (len(g.failures) >= MaxLookupGuardEntries || now.Sub(g.lastSweep) > window) {
+			g.sweep(now, window)
+		}
+		f = &lookupFailures{start: now}
+		g.failures[ip] = f
+	}
+	f.count++
+}
+
+// sweep removes the IP addresses whose window has passed, and if the guard is
+// still full, the address whose window started the longest ago.
+func (g *lookupGuard) sweep(now time.Time, window time.Duration) {
+	g.lastSweep = now
+	oldest := ""
+	for ip, f := range g.failures {
+		if now.Sub(f.start) > window {
+			delete(g.failures, ip)
+			continue
+		}
+		if oldest == "" || f.start.Before(g.failures[oldest].start) {
+			oldest = ip
+		}
+	}
+	if len(g.failures) >= MaxLookupGuardEntries && oldest != "" {
+		delete(g.failures, oldest)
+	}
+}
+
+// guard is the lookupGuard used by the phishing handlers.
+var guard = newLookupGuard()
+
+// guardedLookup runs the lookup for the IP address unless it's throttled, in
+// which case ErrLookupThrottled is returned, after a delay, without running it.
+// Lookups for unknown or invalid IDs count towards the address being
+// throttled, but other errors, such as the database being unavailable, don't.
+func guardedLookup(ip string, lookup func() error) error {
+	if guard.throttled(ip) {
+		delayThrottled()
+		return ErrLookupThrottled
+	}
+	err := lookup()
+	if err == gorm.ErrRecordNotFound || err == ErrInvalidRequest {
+		guard.fail(ip)
+	}
+	return err
+}
+
+// lookupResult returns the result with the given recipient ID, looked up
+// through the guard for the IP address. Forged IDs are rejected with
+// ErrInvalidRequest before they're looked up.
+func lookupResult(ip string, id string) (models.Result, error) {
+	var rs models.Result
+	err := guardedLookup(ip, func() error {
+		var err error
+		rs, err = models.GetResultByRecipientId(id)
+		if err == models.ErrInvalidRecipientSignature || err == models.ErrUnsignedRecipientId {
+			return ErrInvalidRequest
+		}
+		return err
+	})
+	return rs, err
+}
+
+// isExpectedLookupError returns whether or not the error is one returned for
+// requests which can't be processed, such as those for unknown recipient IDs
+// or completed campaigns, which don't need to be logged.
+func isExpectedLookupError(err error) bool {
+	switch err {
+	case ErrInvalidRequest, ErrCampaignComplete, ErrLookupThrottled, ErrLinkExpired:
+		return true
+	}
+	return false
+}
+
 // CreatePhishingRouter creates the router that handles phishing connections.
 func CreatePhishingRouter() http.Handler {
 	router := mux.NewRouter()
+	// Links made by the built-in URL shortener are served from their own
+	// domain, so they're matched before any of the phishing server's paths
+	router.MatcherFunc(isShortURL).Handler(instrumentPhish("short_url", http.HandlerFunc(ShortURLHandler)))
 	fileServer := http.FileServer(UnindexedFileSystem{http.Dir("./static/endpoint/")})
-	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fileServer))
-	router.HandleFunc("/track", PhishTracker)
-	router.HandleFunc("/robots.txt", RobotsHandler)
-	router.HandleFunc("/{path:.*}/track", PhishTracker)
-	router.HandleFunc("/{path:.*}/report", PhishReporter)
-	router.HandleFunc("/report", PhishReporter)
-	router.HandleFunc("/{path:.*}", PhishHandler)
+	router.PathPrefix("/static/").Handler(instrumentPhish("static", http.StripPrefix("/static/", fileServer)))
+	router.Handle("/track", instrumentPhish("track", http.HandlerFunc(PhishTracker)))
+	router.Handle("/robots.txt", instrumentPhish("robots", http.HandlerFunc(RobotsHandler)))
+	router.Handle("/assets/{rid}/{name:.*}", instrumentPhish("asset", http.HandlerFunc(PhishAssetHandler)))
+	router.Handle("/{path:.*}/track", instrumentPhish("track", http.HandlerFunc(PhishTracker)))
+	router.Handle("/{path:.*}/report", instrumentPhish("report", http.HandlerFunc(PhishReporter)))
+	router.Handle("/{path:.*}/attachment", instrumentPhish("attachment", http.HandlerFunc(PhishAttachmentTracker)))
+	router.Handle("/attachment", instrumentPhish("attachment", http.HandlerFunc(PhishAttachmentTracker)))
+	router.Handle("/report", instrumentPhish("report", http.HandlerFunc(PhishReporter)))
+	router.Handle("/{path:.*}/training", instrumentPhish("training", http.HandlerFunc(TrainingHandler)))
+	router.Handle("/{path:.*}/training/complete", instrumentPhish("training", http.HandlerFunc(TrainingCompleteHandler)))
+	router.Handle("/training", instrumentPhish("training", http.HandlerFunc(TrainingHandler)))
+	router.Handle("/training/complete", instrumentPhish("training", http.HandlerFunc(TrainingCompleteHandler)))
+	router.Handle("/{path:.*}", instrumentPhish("landing", http.HandlerFunc(PhishHandler)))
 	return router
 }
 
@@ -44,7 +253,7 @@ func PhishTracker(w http.ResponseWriter, r *http.Request) {
 	err, r := setupContext(r)
 	if err != nil {
 		// Log the error if it wasn't something we can safely ignore
-		if err != ErrInvalidRequest && err != ErrCampaignComplete {
+		if !isExpectedLookupError(err) {
 			log.Error(err)
 		}
 		http.NotFound(w, r)
@@ -59,12 +268,77 @@ func PhishTracker(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "static/images/pixel.png")
 }
 
+// PhishAttachmentTracker tracks attachments as they are opened, recording the
+// name of the attachment for the given Result
+func PhishAttachmentTracker(w http.ResponseWriter, r *http.Request) {
+	err, r := setupContext(r)
+	if err != nil {
+		// Log the error if it wasn't something we can safely ignore
+		if !isExpectedLookupError(err) {
+			log.Error(err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	rs := ctx.Get(r, "result").(models.Result)
+	d := ctx.Get(r, "details").(models.EventDetails)
+	d.AttachmentName = r.Form.Get(models.AttachmentParameter)
+	err = rs.HandleAttachmentOpened(d)
+	if err != nil {
+		log.Error(err)
+	}
+	http.ServeFile(w, r, "static/images/pixel.png")
+}
+
+// PhishAssetHandler serves the assets of the landing page the recipient was
+// sent, which are linked to from the page with a URL including the
+// recipient's ID. Requests for assets aren't recorded as events.
+func PhishAssetHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ip, err := clientIP(r)
+	if err != nil {
+		log.Error(err)
+		http.NotFound(w, r)
+		return
+	}
+	rs, err := lookupResult(ip, vars["rid"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	c, err := models.GetCampaign(rs.CampaignId, rs.UserId)
+	if err != nil {
+		log.Error(err)
+		http.NotFound(w, r)
+		return
+	}
+	// The landing page isn't served for completed campaigns or expired
+	// links, so neither are its assets
+	if c.IsComplete() || rs.LinkExpired() {
+		http.NotFound(w, r)
+		return
+	}
+	a, err := models.GetPageAssetByName(c.VariantFor(&rs).PageId, vars["name"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	b, err := a.Data()
+	if err != nil {
+		log.Error(err)
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", a.Type)
+	http.ServeContent(w, r, a.Name, a.ModifiedDate, bytes.NewReader(b))
+}
+
 // PhishReporter tracks emails as they are reported, updating the status for the given Result
 func PhishReporter(w http.ResponseWriter, r *http.Request) {
 	err, r := setupContext(r)
 	if err != nil {
 		// Log the error if it wasn't something we can safely ignore
-		if err != ErrInvalidRequest && err != ErrCampaignComplete {
+		if !isExpectedLookupError(err) {
 			log.Error(err)
 		}
 		http.NotFound(w, r)
@@ -72,8 +346,12 @@ func PhishReporter(w http.ResponseWriter, r *http.Request) {
 	}
 	rs := ctx.Get(r, "result").(models.Result)
 	d := ctx.Get(r, "details").(models.EventDetails)
-
+	d.Channel = r.Form.Get(models.ChannelParameter)
 	err = rs.HandleEmailReport(d)
+	if err == models.ErrInvalidReportChannel {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Error(err)
 	}
@@ -83,10 +361,14 @@ func PhishReporter(w http.ResponseWriter, r *http.Request) {
 // PhishHandler handles incoming client connections and registers the associated actions performed
 // (such as clicked link, etc.)
 func PhishHandler(w http.ResponseWriter, r *http.Request) {
+	// Proxied landing pages forward the request's body to the proxied site,
+	// so what the form parser reads of it is kept
+	rec := recordBody(r)
+	fromCookie := useProxyCookie(r)
 	err, r := setupContext(r)
 	if err != nil {
 		// Log the error if it wasn't something we can safely ignore
-		if err != ErrInvalidRequest && err != ErrCampaignComplete {
+		if !isExpectedLookupError(err) {
 			log.Error(err)
 		}
 		http.NotFound(w, r)
@@ -95,66 +377,271 @@ func PhishHandler(w http.ResponseWriter, r *http.Request) {
 	rs := ctx.Get(r, "result").(models.Result)
 	c := ctx.Get(r, "campaign").(models.Campaign)
 	d := ctx.Get(r, "details").(models.EventDetails)
-	p, err := models.GetPage(c.PageId, c.UserId)
+	// The position in the campaign's flow is only read from the URL, so that
+	// it can't be confused with a field submitted by the page's form
+	position := 0
+	if sp := r.URL.Query().Get(models.StepParameter); sp != "" {
+		position, err = strconv.Atoi(sp)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	step, ok := c.StepFor(&rs, position)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	p, err := models.GetPage(step.PageId, c.UserId)
 	if err != nil {
 		log.Error(err)
 		http.NotFound(w, r)
 		return
 	}
+	if p.ProxyURL != "" {
+		proxyLandingPage(w, r, p, c, rs, d, rec, fromCookie)
+		return
+	}
+	// The recipient's cookie only identifies them to proxied sites
+	if fromCookie {
+		http.NotFound(w, r)
+		return
+	}
+	// Build the response before recording the event, so that the status
+	// returned to the recipient is stored alongside the click
+	var htmlBuff bytes.Buffer
+	status := http.StatusOK
+	redirectURL := p.RedirectURL
+	if r.Method == "POST" && position < len(c.Steps) {
+		// Recipients who submit a step of the flow are sent to the next one
+		redirectURL = stepURL(r, position+1)
+	} else if r.Method == "POST" && c.TrainingURL != "" {
+		// Recipients who finish the flow are sent to the campaign's training
+		redirectURL, err = c.TrainingRedirectURL(&rs)
+		if err != nil {
+			log.Error(err)
+			redirectURL = p.RedirectURL
+		}
+	}
+	if r.Method == "POST" && redirectURL != "" {
+		status = http.StatusFound
+	} else {
+		err = renderLandingPage(&htmlBuff, p, c, rs)
+		if err != nil {
+			log.Error(err)
+			status = http.StatusNotFound
+		}
+	}
+	d.StatusCode = status
+	switch status {
+	case http.StatusFound:
+		d.LandingURL = redirectURL
+	case http.StatusOK:
+		d.LandingURL = requestURL(r)
+	}
 	switch {
-	case r.Method == "GET":
-		err = rs.HandleClickedLink(d)
+	// Only the landing page is reached by clicking the link, so the later
+	// steps of the flow aren't recorded as clicks
+	case r.Method == "GET" && position == 0:
+		d.Link = r.Form.Get(models.LinkParameter)
+		d.QR = r.Form.Get(models.QRParameter) != ""
+		err = handleClick(rs, c, d)
 		if err != nil {
 			log.Error(err)
 		}
 	case r.Method == "POST":
-		err = rs.HandleFormSubmit(d)
+		d.CapturePolicy = p.CapturePolicy()
+		d.PageHoneypots = p.Honeypots()
+		d.Step = position
+		if step.MFA {
+			err = rs.HandleMFASubmit(d)
+		} else {
+			err = rs.HandleFormSubmit(d)
+		}
 		if err != nil {
 			log.Error(err)
 		}
+	}
+	switch status {
+	case http.StatusFound:
 		// Redirect to the desired page
-		if p.RedirectURL != "" {
-			http.Redirect(w, r, p.RedirectURL, 302)
-			return
+		http.Redirect(w, r, redirectURL, status)
+	case http.StatusNotFound:
+		http.NotFound(w, r)
+	default:
+		w.Write(htmlBuff.Bytes())
+	}
+}
+
+// TrainingHandler serves the built-in training page to recipients redirected
+// to it after submitting data, and records their completion of the training
+// when they submit the page's form.
+func TrainingHandler(w http.ResponseWriter, r *http.Request) {
+	rs, d, err := setupTrainingContext(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == "POST" {
+		err = rs.HandleTrainingCompleted(d)
+		if err != nil {
+			log.Error(err)
 		}
 	}
-	var htmlBuff bytes.Buffer
-	tmpl, err := template.New("html_template").Parse(p.HTML)
+	params := struct {
+		Token     string
+		Completed bool
+	}{
+		r.Form.Get(models.TrainingTokenParameter),
+		rs.TrainingCompleted,
+	}
+	templates := template.New("template")
+	_, err = templates.ParseFiles("templates/training.html")
 	if err != nil {
 		log.Error(err)
 		http.NotFound(w, r)
 		return
 	}
-	f, err := mail.ParseAddress(c.SMTP.FromAddress)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = templates.ExecuteTemplate(w, "base", params)
 	if err != nil {
 		log.Error(err)
 	}
-	fn := f.Name
-	if fn == "" {
-		fn = f.Address
+}
+
+// TrainingCompleteHandler records that a recipient completed their training
+// when an external LMS calls back with the recipient's training token.
+func TrainingCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	rs, d, err := setupTrainingContext(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	err = rs.HandleTrainingCompleted(d)
+	if err != nil {
+		log.Error(err)
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	phishURL, _ := url.Parse(c.URL)
-	q := phishURL.Query()
-	q.Set(models.RecipientParameter, rs.RId)
-	phishURL.RawQuery = q.Encode()
+// setupTrainingContext returns the result identified by the request's
+// training token, and the details of the request to record with the event.
+// Unlike the other phishing events, training can be completed after the
+// campaign has been completed.
+func setupTrainingContext(r *http.Request) (models.Result, models.EventDetails, error) {
+	d := models.EventDetails{Browser: make(map[string]string)}
+	err := r.ParseForm()
+	if err != nil {
+		return models.Result{}, d, err
+	}
+	ip, err := clientIP(r)
+	if err != nil {
+		log.Error(err)
+		return models.Result{}, d, err
+	}
+	var rs models.Result
+	err = guardedLookup(ip, func() error {
+		rs, err = models.GetResultByTrainingToken(r.Form.Get(models.TrainingTokenParameter))
+		if err == models.ErrInvalidTrainingToken {
+			return ErrInvalidRequest
+		}
+		return err
+	})
+	if err != nil {
+		return rs, d, err
+	}
+	d.Browser["address"] = ip
+	d.Browser["user-agent"] = r.Header.Get("User-Agent")
+	return rs, d, nil
+}
 
-	rsf := struct {
-		models.Result
-		URL  string
-		From string
-	}{
-		rs,
-		phishURL.String(),
-		fn,
+// handleClick records the click, filtering out clicks which look like they
+// were made by a scanner or bot unless the campaign has disabled the filter.
+func handleClick(rs models.Result, c models.Campaign, d models.EventDetails) error {
+	if c.DisableBotFilter {
+		return rs.HandleClickedLink(d)
+	}
+	reasons, err := rs.BotClickReasons(d)
+	if err != nil {
+		return err
+	}
+	if len(reasons) > 0 {
+		return rs.HandleBotClick(d, reasons)
+	}
+	return rs.HandleClickedLink(d)
+}
+
+// requestURL returns the absolute URL the client requested, which is the URL
+// the client landed on after following any redirects to reach it.
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: r.Host}
+	return u.String() + r.URL.RequestURI()
+}
+
+// stepURL returns the URL of the request, changed to show the given step of
+// the campaign's flow
+func stepURL(r *http.Request, position int) string {
+	u := *r.URL
+	q := u.Query()
+	q.Set(models.StepParameter, strconv.Itoa(position))
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// renderLandingPage renders the campaign's landing page for the given result
+// into the buffer.
+func renderLandingPage(htmlBuff *bytes.Buffer, p models.Page, c models.Campaign, rs models.Result) error {
+	tmpl, err := template.New("html_template").Option("missingkey=zero").Parse(p.HTML)
+	if err != nil {
+		return err
 	}
-	err = tmpl.Execute(&htmlBuff, rsf)
+	rsf, err := models.NewPageContext(&c, rs)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(htmlBuff, rsf)
+}
+
+// shortCode returns the code of the built-in URL shortener's link requested,
+// if the request was made for one
+func shortCode(r *http.Request) (string, bool) {
+	s, ok := models.URLShortener.(*models.BuiltinShortener)
+	if !ok {
+		return "", false
+	}
+	return s.Code(r.Host, r.URL.Path)
+}
+
+// isShortURL matches requests for the built-in URL shortener's links
+func isShortURL(r *http.Request, rm *mux.RouteMatch) bool {
+	_, ok := shortCode(r)
+	return ok
+}
+
+// ShortURLHandler redirects the recipient from a short link to the phishing
+// URL it was made for, which records the click as usual.
+func ShortURLHandler(w http.ResponseWriter, r *http.Request) {
+	code, _ := shortCode(r)
+	ip, err := clientIP(r)
 	if err != nil {
 		log.Error(err)
 		http.NotFound(w, r)
 		return
 	}
-	w.Write(htmlBuff.Bytes())
+	var su models.ShortURL
+	err = guardedLookup(ip, func() error {
+		su, err = models.GetShortURL(code)
+		return err
+	})
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, su.Target, http.StatusFound)
 }
 
 // RobotsHandler prevents search engines, etc. from indexing phishing materials
@@ -162,6 +649,171 @@ func RobotsHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "User-agent: *\nDisallow: /")
 }
 
+// isTrustedProxy returns whether or not the given address belongs to one of
+// the trusted proxies configured for the phishing server, each of which is
+// either an IP address or a network in CIDR notation.
+func isTrustedProxy(ip net.IP) bool {
+	return inNetworks(ip, config.Conf.PhishConf.TrustedProxies)
+}
+
+// inNetworks returns whether or not the address is one of the given
+// addresses, or belongs to one of the given networks in CIDR notation.
+func inNetworks(ip net.IP, networks []string) bool {
+	for _, network := range networks {
+		if !strings.Contains(network, "/") {
+			if nip := net.ParseIP(network); nip != nil && nip.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		_, n, err := net.ParseCIDR(network)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNetworks returns an error if any of the networks isn't an IP address
+// or a network in CIDR notation
+func checkNetworks(networks []string, kind string) error {
+	for _, network := range networks {
+		if strings.Contains(network, "/") {
+			_, _, err := net.ParseCIDR(network)
+			if err != nil {
+				return err
+			}
+		} else if net.ParseIP(network) == nil {
+			return fmt.Errorf("invalid %s: %s", kind, network)
+		}
+	}
+	return nil
+}
+
+// The headers trusted proxies can give the client's address in
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+	HeaderForwarded     = "Forwarded"
+)
+
+// ErrInvalidClientIPHeader is thrown when the phishing server is configured
+// to take client addresses from a header other than X-Forwarded-For,
+// X-Real-IP or Forwarded
+var ErrInvalidClientIPHeader = errors.New("client_ip_header must be X-Forwarded-For, X-Real-IP or Forwarded")
+
+// clientIPHeader returns the header trusted proxies give the client's address
+// in, which defaults to X-Forwarded-For. Header names are matched regardless
+// of case, and an empty string is returned if the header isn't supported.
+func clientIPHeader() string {
+	h := config.Conf.PhishConf.ClientIPHeader
+	switch {
+	case h == "" || strings.EqualFold(h, HeaderXForwardedFor):
+		return HeaderXForwardedFor
+	case strings.EqualFold(h, HeaderXRealIP):
+		return HeaderXRealIP
+	case strings.EqualFold(h, HeaderForwarded):
+		return HeaderForwarded
+	}
+	return ""
+}
+
+// CheckTrustedProxies returns an error if the phishing server's trusted
+// proxies or client IP header aren't valid, so that a mistake in the
+// configuration is caught when gophish starts rather than silently recording
+// the proxies' addresses.
+func CheckTrustedProxies() error {
+	if clientIPHeader() == "" {
+		return ErrInvalidClientIPHeader
+	}
+	return checkNetworks(config.Conf.PhishConf.TrustedProxies, "trusted proxy")
+}
+
+// forwardedFor returns the addresses in the "for" parameters of the RFC 7239
+// Forwarded header values, from the furthest hop to the closest. Ports and
+// the brackets around IPv6 addresses are removed. Obfuscated identifiers,
+// such as "unknown", are returned as they are, so that they stop the chain.
+func forwardedFor(values []string) []string {
+	hops := []string{}
+	for _, element := range strings.Split(strings.Join(values, ","), ",") {
+		for _, pair := range strings.Split(element, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 || !strings.EqualFold(kv[0], "for") {
+				continue
+			}
+			node := strings.Trim(kv[1], `"`)
+			if strings.HasPrefix(node, "[") {
+				if end := strings.Index(node, "]"); end > 0 {
+					node = node[1:end]
+				}
+			} else if host, _, err := net.SplitHostPort(node); err == nil {
+				node = host
+			}
+			hops = append(hops, node)
+		}
+	}
+	return hops
+}
+
+// forwardedHops returns the chain of addresses the request was forwarded for,
+// from the furthest hop to the closest, taken from the configured client IP
+// header
+func forwardedHops(r *http.Request) []string {
+	switch clientIPHeader() {
+	case HeaderXForwardedFor:
+		return strings.Split(strings.Join(r.Header.Values(HeaderXForwardedFor), ","), ",")
+	case HeaderXRealIP:
+		return []string{r.Header.Get(HeaderXRealIP)}
+	case HeaderForwarded:
+		return forwardedFor(r.Header.Values(HeaderForwarded))
+	}
+	return []string{}
+}
+
+// clientIP returns the IP address of the client making the request. The
+// configured client IP header is only respected when the request comes from a
+// trusted proxy, in which case the chain is walked back to the first address
+// which isn't a trusted proxy, so that clients can't spoof their address.
+// Other forwarding headers are always ignored.
+func clientIP(r *http.Request) (string, error) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+	if !isTrustedProxy(net.ParseIP(ip)) {
+		return ip, nil
+	}
+	hops := forwardedHops(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop.String()
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip, nil
+}
+
+// clientConnection returns the address and source port of the client's
+// connection, which lets us tell when multiple requests were made over the
+// same keep-alive connection. Requests from a trusted proxy arrive over the
+// proxy's own connections, which it reuses for unrelated clients, so no
+// connection is returned for them.
+func clientConnection(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || isTrustedProxy(net.ParseIP(ip)) {
+		return ""
+	}
+	return r.RemoteAddr
+}
+
 // setupContext handles some of the administrative work around receiving a new request, such as checking the result ID, the campaign, etc.
 func setupContext(r *http.Request) (error, *http.Request) {
 	err := r.ParseForm()
@@ -173,7 +825,14 @@ func setupContext(r *http.Request) (error, *http.Request) {
 	if id == "" {
 		return ErrInvalidRequest, r
 	}
-	rs, err := models.GetResult(id)
+	ip, err := clientIP(r)
+	if err != nil {
+		log.Error(err)
+		return err, r
+	}
+	// Stop clients that appear to be enumerating recipient IDs. The response
+	// is the same not found page returned for unknown IDs.
+	rs, err := lookupResult(ip, id)
 	if err != nil {
 		return err, r
 	}
@@ -183,29 +842,46 @@ func setupContext(r *http.Request) (error, *http.Request) {
 		return err, r
 	}
 	// Don't process events for completed campaigns
-	if c.Status == models.CAMPAIGN_COMPLETE {
+	if c.IsComplete() {
 		return ErrCampaignComplete, r
 	}
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		log.Error(err)
-		return err, r
-	}
-	// Respect X-Forwarded headers
-	if fips := r.Header.Get("X-Forwarded-For"); fips != "" {
-		ip = strings.Split(fips, ", ")[0]
+	// Don't process events for expired links, but keep a record of the attempt
+	if rs.LinkExpired() {
+		d := models.EventDetails{Browser: make(map[string]string)}
+		d.Browser["address"] = ip
+		d.Browser["user-agent"] = r.Header.Get("User-Agent")
+		err = rs.HandleLinkExpired(d)
+		if err != nil {
+			log.Error(err)
+		}
+		return ErrLinkExpired, r
 	}
 	// Handle post processing such as GeoIP
 	err = rs.UpdateGeo(ip)
-	if err != nil {
+	// Plenty of addresses aren't in the GeoIP database, which isn't worth
+	// reporting as an error
+	if err == models.ErrGeoNotFound {
+		log.Debug(err)
+	} else if err != nil {
 		log.Error(err)
 	}
 	d := models.EventDetails{
-		Payload: r.Form,
-		Browser: make(map[string]string),
+		Payload:     r.Form,
+		Browser:     make(map[string]string),
+		Latitude:    rs.Latitude,
+		Longitude:   rs.Longitude,
+		Country:     rs.Country,
+		CountryName: rs.CountryName,
+		City:        rs.City,
+		ReverseDNS:  rs.ReverseDNS,
 	}
 	d.Browser["address"] = ip
 	d.Browser["user-agent"] = r.Header.Get("User-Agent")
+	d.Browser["accept-language"] = r.Header.Get("Accept-Language")
+	d.Browser["referer"] = r.Referer()
+	if conn := clientConnection(r); conn != "" {
+		d.Browser["connection"] = conn
+	}
 
 	r = ctx.Set(r, "result", rs)
 	r = ctx.Set(r, "campaign", c)
@@ -1,12 +1,24 @@
 package controllers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/gophish/gophish/config"
 	"github.com/gophish/gophish/models"
+	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func (s *ControllersSuite) getFirstCampaign() models.Campaign {
@@ -40,8 +52,15 @@ func (s *ControllersSuite) openEmail404(rid string) {
 	s.Equal(resp.StatusCode, http.StatusNotFound)
 }
 
+// browserUserAgent is sent with clicks so that they aren't filtered out as
+// bot clicks
+const browserUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/67.0.3396.99 Safari/537.36"
+
 func (s *ControllersSuite) clickLink(rid string, campaign models.Campaign) {
-	resp, err := http.Get(fmt.Sprintf("%s/?%s=%s", ps.URL, models.RecipientParameter, rid))
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/?%s=%s", ps.URL, models.RecipientParameter, rid), nil)
+	s.Nil(err)
+	req.Header.Set("User-Agent", browserUserAgent)
+	resp, err := http.DefaultClient.Do(req)
 	s.Nil(err)
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
@@ -72,6 +91,95 @@ func (s *ControllersSuite) TestOpenedPhishingEmail() {
 	s.Equal(result.ModifiedDate, lastEvent.Time)
 }
 
+func (s *ControllersSuite) TestPhishRequestMetrics() {
+	campaign := s.getFirstCampaign()
+	opened := testutil.ToFloat64(phishRequests.WithLabelValues("track", "200"))
+	notFound := testutil.ToFloat64(phishRequests.WithLabelValues("track", "404"))
+	s.openEmail(campaign.Results[0].RId)
+	s.openEmail404("invalid")
+	s.Equal(opened+1, testutil.ToFloat64(phishRequests.WithLabelValues("track", "200")))
+	s.Equal(notFound+1, testutil.ToFloat64(phishRequests.WithLabelValues("track", "404")))
+}
+
+// streamCampaign opens the campaign's event stream, returning a channel
+// which receives each event sent on it
+func (s *ControllersSuite) streamCampaign(ctx context.Context, id int64, lastEventID string) <-chan models.StreamEvent {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/campaigns/%d/stream", as.URL, id), nil)
+	s.Nil(err)
+	req = req.WithContext(ctx)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.ApiKey))
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	s.Nil(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+	events := make(chan models.StreamEvent, 10)
+	go func() {
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data := strings.TrimPrefix(scanner.Text(), "data: "); data != scanner.Text() {
+				e := models.StreamEvent{}
+				json.Unmarshal([]byte(data), &e)
+				events <- e
+			}
+		}
+	}()
+	return events
+}
+
+func (s *ControllersSuite) TestCampaignStream() {
+	campaign := s.getFirstCampaign()
+	result := campaign.Results[0]
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Equal(http.StatusNotFound, s.apiRequest("GET", "/api/campaigns/1000/stream", s.ApiKey, nil).StatusCode)
+
+	events := s.streamCampaign(ctx, campaign.Id, "")
+	s.openEmail(result.RId)
+	var opened models.StreamEvent
+	select {
+	case opened = <-events:
+	case <-time.After(5 * time.Second):
+		s.FailNow("event was not streamed")
+	}
+	s.Equal(result.RId, opened.RId)
+	s.Equal(result.Email, opened.Email)
+	s.Equal(models.EVENT_OPENED, opened.Message)
+
+	// Reconnecting catches up on the events since the last one received
+	missed := s.streamCampaign(ctx, campaign.Id, fmt.Sprintf("%d", opened.Id-1))
+	select {
+	case e := <-missed:
+		s.Equal(opened.Id, e.Id)
+		s.Equal(result.RId, e.RId)
+	case <-time.After(5 * time.Second):
+		s.FailNow("missed event was not streamed")
+	}
+}
+
+func (s *ControllersSuite) TestOpenedPhishingAttachment() {
+	campaign := s.getFirstCampaign()
+	result := campaign.Results[0]
+
+	resp, err := http.Get(fmt.Sprintf("%s/attachment?%s=%s&%s=%s", ps.URL,
+		models.RecipientParameter, result.RId, models.AttachmentParameter, "Invoice.docx"))
+	s.Nil(err)
+	defer resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusOK)
+
+	campaign = s.getFirstCampaign()
+	result = campaign.Results[0]
+	lastEvent := campaign.Events[len(campaign.Events)-1]
+	s.Equal(result.Status, models.EVENT_OPENED)
+	s.Equal(lastEvent.Message, models.EVENT_ATTACHMENT)
+	names, err := result.OpenedAttachments()
+	s.Nil(err)
+	s.Equal(names, []string{"Invoice.docx"})
+}
+
 func (s *ControllersSuite) TestReportedPhishingEmail() {
 	campaign := s.getFirstCampaign()
 	result := campaign.Results[0]
@@ -87,6 +195,24 @@ func (s *ControllersSuite) TestReportedPhishingEmail() {
 	s.Equal(result.ModifiedDate, lastEvent.Time)
 }
 
+func (s *ControllersSuite) TestReportedPhishingEmailChannel() {
+	campaign := s.getFirstCampaign()
+	result := campaign.Results[0]
+
+	resp, err := http.Get(fmt.Sprintf("%s/report?%s=%s&%s=%s", ps.URL, models.RecipientParameter, result.RId,
+		models.ChannelParameter, models.REPORT_CHANNEL_TICKET))
+	s.Nil(err)
+	s.Equal(resp.StatusCode, http.StatusNoContent)
+	resp, err = http.Get(fmt.Sprintf("%s/report?%s=%s&%s=carrier-pigeon", ps.URL, models.RecipientParameter, result.RId,
+		models.ChannelParameter))
+	s.Nil(err)
+	s.Equal(resp.StatusCode, http.StatusBadRequest)
+
+	breakdown, err := models.GetReportChannelBreakdown(campaign.Id, campaign.UserId)
+	s.Nil(err)
+	s.Equal(breakdown, map[string]int64{models.REPORT_CHANNEL_TICKET: 1})
+}
+
 func (s *ControllersSuite) TestClickedPhishingLinkAfterOpen() {
 	campaign := s.getFirstCampaign()
 	result := campaign.Results[0]
@@ -101,6 +227,237 @@ func (s *ControllersSuite) TestClickedPhishingLinkAfterOpen() {
 	s.Equal(result.Status, models.EVENT_CLICKED)
 	s.Equal(lastEvent.Message, models.EVENT_CLICKED)
 	s.Equal(result.ModifiedDate, lastEvent.Time)
+
+	status, ok := result.LandingPageStatus()
+	s.Equal(ok, true)
+	s.Equal(status, http.StatusOK)
+
+	landing, ok := result.FinalLandingURL()
+	s.Equal(ok, true)
+	s.Equal(landing, fmt.Sprintf("%s/?%s=%s", ps.URL, models.RecipientParameter, result.RId))
+}
+
+func (s *ControllersSuite) TestClickedTaggedQRLink() {
+	campaign := s.getFirstCampaign()
+	result := campaign.Results[0]
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/?%s=%s&%s=invoice&%s=1", ps.URL, models.RecipientParameter, result.RId,
+		models.LinkParameter, models.QRParameter), nil)
+	s.Nil(err)
+	req.Header.Set("User-Agent", browserUserAgent)
+	resp, err := http.DefaultClient.Do(req)
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusOK)
+
+	campaign = s.getFirstCampaign()
+	lastEvent := campaign.Events[len(campaign.Events)-1]
+	s.Equal(lastEvent.Message, models.EVENT_CLICKED)
+	d := models.EventDetails{}
+	s.Nil(json.Unmarshal([]byte(lastEvent.Details), &d))
+	s.Equal(d.Link, "invoice")
+	s.True(d.QR)
+
+	lcs, err := models.GetLinkClicks(campaign.Id)
+	s.Nil(err)
+	s.Equal(lcs, []models.LinkClicks{{Link: "invoice", Clicks: 1, QRClicks: 1, Recipients: 1}})
+}
+
+// launchTrainingCampaign launches a campaign which redirects recipients to
+// the given training URL after they submit data
+func (s *ControllersSuite) launchTrainingCampaign(trainingURL string) models.Campaign {
+	first := s.getFirstCampaign()
+	c := models.Campaign{Name: "Training campaign", TrainingURL: trainingURL}
+	c.Template = first.Template
+	c.Page = first.Page
+	c.SMTP = first.SMTP
+	c.Groups = []models.Group{models.Group{Name: "Test Group"}}
+	s.Nil(models.PostCampaign(&c, 1))
+	return c
+}
+
+func (s *ControllersSuite) TestSubmitRedirectsToTraining() {
+	c := s.launchTrainingCampaign("https://lms.example.com/course")
+	result := c.Results[0]
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.PostForm(fmt.Sprintf("%s/?%s=%s", ps.URL, models.RecipientParameter, result.RId),
+		url.Values{"username": {"user"}})
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusFound)
+	expected, err := c.TrainingRedirectURL(&result)
+	s.Nil(err)
+	s.Equal(resp.Header.Get("Location"), expected)
+
+	// The LMS calls back once the recipient completes the course
+	token := c.TrainingToken(&result)
+	resp, err = http.Get(fmt.Sprintf("%s/training/complete?%s=%s", ps.URL, models.TrainingTokenParameter, token))
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusNoContent)
+	result, err = models.GetResult(result.RId)
+	s.Nil(err)
+	s.True(result.TrainingCompleted)
+	s.Equal(result.Status, models.EVENT_DATA_SUBMIT)
+
+	resp, err = http.Get(fmt.Sprintf("%s/training/complete?%s=%s.bad", ps.URL, models.TrainingTokenParameter, result.RId))
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusNotFound)
+}
+
+func (s *ControllersSuite) TestBuiltInTrainingPage() {
+	c := s.launchTrainingCampaign("/training")
+	result := c.Results[0]
+	token := c.TrainingToken(&result)
+	trainingURL := fmt.Sprintf("%s/training?%s=%s", ps.URL, models.TrainingTokenParameter, token)
+	resp, err := http.Get(trainingURL)
+	s.Nil(err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	s.Nil(err)
+	s.Equal(resp.StatusCode, http.StatusOK)
+	s.Contains(string(body), fmt.Sprintf(`value="%s"`, token))
+
+	resp, err = http.PostForm(trainingURL, nil)
+	s.Nil(err)
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	s.Nil(err)
+	s.Equal(resp.StatusCode, http.StatusOK)
+	s.Contains(string(body), "recorded as complete")
+	result, err = models.GetResult(result.RId)
+	s.Nil(err)
+	s.True(result.TrainingCompleted)
+
+	c, err = models.GetCampaign(c.Id, 1)
+	s.Nil(err)
+	lastEvent := c.Events[len(c.Events)-1]
+	s.Equal(lastEvent.Message, models.EVENT_TRAINING_COMPLETED)
+}
+
+func (s *ControllersSuite) TestPhishAsset() {
+	campaign := s.getFirstCampaign()
+	result := campaign.Results[0]
+	a := models.PageAsset{PageId: campaign.PageId, Name: "css/style.css", Content: "Ym9keSB7fQ=="}
+	s.Nil(models.PostPageAsset(&a))
+
+	resp, err := http.Get(fmt.Sprintf("%s/assets/%s/css/style.css", ps.URL, result.RId))
+	s.Nil(err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	s.Nil(err)
+	s.Equal(resp.StatusCode, http.StatusOK)
+	s.Equal(resp.Header.Get("Content-Type"), "text/css; charset=utf-8")
+	s.Equal(string(body), "body {}")
+
+	resp, err = http.Get(fmt.Sprintf("%s/assets/%s/missing.css", ps.URL, result.RId))
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusNotFound)
+	resp, err = http.Get(fmt.Sprintf("%s/assets/XXXXXXXXXX/css/style.css", ps.URL))
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusNotFound)
+
+	// Requesting assets isn't recorded as an event
+	campaign = s.getFirstCampaign()
+	s.Equal(campaign.Results[0].Status, models.STATUS_SENDING)
+}
+
+func (s *ControllersSuite) TestRenderLandingPageAssetURL() {
+	campaign := s.getFirstCampaign()
+	campaign.URL = "http://phish.example.com/login?lang=en"
+	result := campaign.Results[0]
+	p := models.Page{HTML: `<img src="{{.AssetURL}}/logo.png">`}
+	var b bytes.Buffer
+	s.Nil(renderLandingPage(&b, p, campaign, result))
+	s.Equal(b.String(), fmt.Sprintf(`<img src="http://phish.example.com/assets/%s/logo.png">`, result.RId))
+}
+
+func (s *ControllersSuite) TestMultiStepFlow() {
+	first := s.getFirstCampaign()
+	password := models.Page{Name: "Password Page", HTML: "<html>Password</html>", UserId: 1, CaptureCredentials: true}
+	s.Nil(models.PostPage(&password))
+	mfa := models.Page{Name: "MFA Page", HTML: "<html>Code</html>", UserId: 1, CaptureCredentials: true}
+	s.Nil(models.PostPage(&mfa))
+	c := models.Campaign{Name: "Multi-step campaign"}
+	c.Template = first.Template
+	c.Page = first.Page
+	c.SMTP = first.SMTP
+	c.Groups = []models.Group{models.Group{Name: "Test Group"}}
+	c.Steps = []models.CampaignStep{
+		{Page: models.Page{Name: password.Name}},
+		{Page: models.Page{Name: mfa.Name}, MFA: true},
+	}
+	s.Nil(models.PostCampaign(&c, 1))
+	result := c.Results[0]
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	landing := fmt.Sprintf("%s/login?%s=%s", ps.URL, models.RecipientParameter, result.RId)
+
+	// Each submission is sent on to the next step of the flow
+	resp, err := client.PostForm(landing, url.Values{"username": {"jdoe"}})
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusFound)
+	next := resp.Header.Get("Location")
+	s.Equal(next, fmt.Sprintf("/login?%s=%s&%s=1", models.RecipientParameter, result.RId, models.StepParameter))
+	resp, err = client.Get(ps.URL + next)
+	s.Nil(err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	s.Nil(err)
+	s.Equal(string(body), "<html><head></head><body>Password</body></html>")
+
+	resp, err = client.PostForm(ps.URL+next, url.Values{"password": {"secret"}})
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusFound)
+	next = resp.Header.Get("Location")
+	// The last step has no redirect, so the page is shown again
+	resp, err = client.PostForm(ps.URL+next, url.Values{"code": {"123456"}})
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusOK)
+
+	result, err = models.GetResult(result.RId)
+	s.Nil(err)
+	s.Equal(result.StepsSubmitted, 3)
+	s.Equal(result.Status, models.EVENT_MFA_SUBMIT)
+
+	for _, step := range []string{"3", "-1", "two"} {
+		resp, err = client.Get(fmt.Sprintf("%s&%s=%s", landing, models.StepParameter, step))
+		s.Nil(err)
+		resp.Body.Close()
+		s.Equal(resp.StatusCode, http.StatusNotFound, step)
+	}
+}
+
+func (s *ControllersSuite) TestScannerClickFiltered() {
+	campaign := s.getFirstCampaign()
+	result := campaign.Results[0]
+
+	// The default Go User-Agent looks like a link scanner
+	resp, err := http.Get(fmt.Sprintf("%s/?%s=%s", ps.URL, models.RecipientParameter, result.RId))
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusOK)
+
+	campaign = s.getFirstCampaign()
+	result = campaign.Results[0]
+	lastEvent := campaign.Events[len(campaign.Events)-1]
+	s.Equal(result.Status, models.STATUS_SENDING)
+	s.Equal(lastEvent.Message, models.EVENT_BOT_CLICK)
+
+	// Campaigns can disable the filter
+	campaign.DisableBotFilter = true
+	d := models.EventDetails{Browser: map[string]string{"user-agent": "curl/7.54.0"}}
+	s.Nil(handleClick(result, campaign, d))
+	campaign = s.getFirstCampaign()
+	s.Equal(campaign.Results[0].Status, models.EVENT_CLICKED)
 }
 
 func (s *ControllersSuite) TestNoRecipientID() {
@@ -143,6 +500,27 @@ func (s *ControllersSuite) TestCompletedCampaignClick() {
 	s.Equal(result.Status, models.EVENT_OPENED)
 }
 
+func (s *ControllersSuite) TestExpiredLinkClick() {
+	campaign := s.getFirstCampaign()
+	result := campaign.Results[0]
+	s.openEmail(result.RId)
+
+	result, err := models.GetResult(result.RId)
+	s.Nil(err)
+	expires := time.Now().UTC().Add(-time.Minute)
+	result.LinkExpiresAt = &expires
+	s.Nil(models.ResultStorage.Save(&result))
+
+	s.openEmail404(result.RId)
+	s.clickLink404(result.RId)
+
+	campaign = s.getFirstCampaign()
+	result = campaign.Results[0]
+	s.Equal(result.Status, models.EVENT_OPENED)
+	lastEvent := campaign.Events[len(campaign.Events)-1]
+	s.Equal(lastEvent.Message, models.EVENT_LINK_EXPIRED)
+}
+
 func (s *ControllersSuite) TestRobotsHandler() {
 	expected := []byte("User-agent: *\nDisallow: /\n")
 	resp, err := http.Get(fmt.Sprintf("%s/robots.txt", ps.URL))
@@ -153,3 +531,444 @@ func (s *ControllersSuite) TestRobotsHandler() {
 	s.Nil(err)
 	s.Equal(bytes.Compare(body, expected), 0)
 }
+
+func (s *ControllersSuite) TestShortURLRedirect() {
+	defer func() { models.URLShortener = nil }()
+	base, _ := url.Parse("http://go.example.com")
+	models.URLShortener = &models.BuiltinShortener{BaseURL: base}
+	campaign := s.getFirstCampaign()
+	campaign.ShortenURLs = true
+	campaign.Template.Text = "{{.URL}}"
+	result := campaign.Results[0]
+	e, err := models.PreviewEmail(&campaign, &result)
+	s.Nil(err)
+	s.True(strings.HasPrefix(e.Text, "http://go.example.com/"))
+	code := strings.TrimPrefix(e.Text, "http://go.example.com/")
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(host string, code string) *http.Response {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s", ps.URL, code), nil)
+		s.Nil(err)
+		req.Host = host
+		resp, err := client.Do(req)
+		s.Nil(err)
+		resp.Body.Close()
+		return resp
+	}
+	// The short link redirects to the recipient's phishing URL
+	resp := get("go.example.com", code)
+	s.Equal(resp.StatusCode, http.StatusFound)
+	target, err := url.Parse(resp.Header.Get("Location"))
+	s.Nil(err)
+	s.Equal(target.Query().Get(models.RecipientParameter), result.RId)
+
+	resp = get("go.example.com", "unknown")
+	s.Equal(resp.StatusCode, http.StatusNotFound)
+	// Codes aren't served from the phishing server's own domain
+	resp = get("phish.example.com", code)
+	s.Equal(resp.StatusCode, http.StatusNotFound)
+}
+
+func (s *ControllersSuite) TestInvalidRecipientIDThrottling() {
+	defer func(throttle config.LookupThrottle) {
+		config.Conf.PhishConf.LookupThrottle = throttle
+		guard = newLookupGuard()
+	}(config.Conf.PhishConf.LookupThrottle)
+	config.Conf.PhishConf.LookupThrottle = config.LookupThrottle{
+		MaxInvalidLookups: 3,
+		DelayMilliseconds: 100,
+	}
+	guard = newLookupGuard()
+
+	campaign := s.getFirstCampaign()
+	result := campaign.Results[0]
+	for i := 0; i < 3; i++ {
+		s.openEmail404(fmt.Sprintf("XXXXXXX%d", i))
+	}
+	// Once throttled, even valid recipient IDs get the not found response,
+	// which is delayed
+	start := time.Now()
+	s.openEmail404(result.RId)
+	s.clickLink404(result.RId)
+	s.True(time.Since(start) >= 200*time.Millisecond)
+
+	campaign = s.getFirstCampaign()
+	result = campaign.Results[0]
+	s.Equal(result.Status, models.STATUS_SENDING)
+
+	// Other addresses are unaffected
+	s.False(guard.throttled("192.0.2.1"))
+}
+
+func (s *ControllersSuite) TestThrottledLookupDelayIsBounded() {
+	defer func(throttle config.LookupThrottle, max int32) {
+		config.Conf.PhishConf.LookupThrottle = throttle
+		MaxDelayedLookups = max
+		guard = newLookupGuard()
+	}(config.Conf.PhishConf.LookupThrottle, MaxDelayedLookups)
+	config.Conf.PhishConf.LookupThrottle = config.LookupThrottle{
+		MaxInvalidLookups: 1,
+		DelayMilliseconds: 10000,
+	}
+	MaxDelayedLookups = 0
+	guard = newLookupGuard()
+
+	ip := "203.0.113.10"
+	guard.fail(ip)
+	// With no room left to delay the response, it's sent straight away
+	start := time.Now()
+	err := guardedLookup(ip, func() error { return nil })
+	s.Equal(ErrLookupThrottled, err)
+	s.True(time.Since(start) < time.Second)
+	s.Equal(int32(0), atomic.LoadInt32(&delayedLookups))
+}
+
+func (s *ControllersSuite) TestLookupErrorsNotThrottled() {
+	defer func(throttle config.LookupThrottle) {
+		config.Conf.PhishConf.LookupThrottle = throttle
+		guard = newLookupGuard()
+	}(config.Conf.PhishConf.LookupThrottle)
+	config.Conf.PhishConf.LookupThrottle = config.LookupThrottle{MaxInvalidLookups: 1}
+	guard = newLookupGuard()
+
+	ip := "203.0.113.10"
+	// Errors other than an unknown or invalid ID, such as the database being
+	// unavailable, don't count towards throttling
+	dbErr := errors.New("connection refused")
+	err := guardedLookup(ip, func() error { return dbErr })
+	s.Equal(dbErr, err)
+	s.False(guard.throttled(ip))
+
+	err = guardedLookup(ip, func() error { return gorm.ErrRecordNotFound })
+	s.Equal(gorm.ErrRecordNotFound, err)
+	s.True(guard.throttled(ip))
+}
+
+func (s *ControllersSuite) TestLookupGuardSize() {
+	defer func(max int) { MaxLookupGuardEntries = max }(MaxLookupGuardEntries)
+	MaxLookupGuardEntries = 3
+	g := newLookupGuard()
+	for i := 0; i < 3; i++ {
+		g.fail(fmt.Sprintf("192.0.2.%d", i))
+	}
+	// Addresses whose window has passed are removed first
+	g.failures["192.0.2.1"].start = time.Now().Add(-time.Hour)
+	g.fail("192.0.2.10")
+	s.Equal(3, len(g.failures))
+	s.NotContains(g.failures, "192.0.2.1")
+
+	// Otherwise the address tracked the longest is removed
+	g.failures["192.0.2.0"].start = time.Now().Add(-time.Minute)
+	g.fail("192.0.2.11")
+	s.Equal(3, len(g.failures))
+	s.NotContains(g.failures, "192.0.2.0")
+	s.Contains(g.failures, "192.0.2.11")
+}
+
+func (s *ControllersSuite) TestClientIP() {
+	defer func(proxies []string) { config.Conf.PhishConf.TrustedProxies = proxies }(config.Conf.PhishConf.TrustedProxies)
+	newRequest := func(peer string, xff string) *http.Request {
+		req := httptest.NewRequest("GET", "/track", nil)
+		req.RemoteAddr = peer
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		return req
+	}
+
+	// Without any trusted proxies, the header is ignored
+	config.Conf.PhishConf.TrustedProxies = nil
+	ip, err := clientIP(newRequest("127.0.0.1:51234", "203.0.113.5"))
+	s.Nil(err)
+	s.Equal(ip, "127.0.0.1")
+
+	config.Conf.PhishConf.TrustedProxies = []string{"127.0.0.1", "10.0.0.0/8"}
+	// The first untrusted address in the chain is the client, even if an
+	// earlier entry was spoofed
+	ip, err = clientIP(newRequest("127.0.0.1:51234", "198.51.100.1, 203.0.113.5, 10.1.2.3"))
+	s.Nil(err)
+	s.Equal(ip, "203.0.113.5")
+	// Untrusted peers can't set their own address
+	ip, err = clientIP(newRequest("192.0.2.10:51234", "203.0.113.5"))
+	s.Nil(err)
+	s.Equal(ip, "192.0.2.10")
+	// A trusted peer without the header is used directly
+	ip, err = clientIP(newRequest("10.0.0.4:51234", ""))
+	s.Nil(err)
+	s.Equal(ip, "10.0.0.4")
+	// If every hop is trusted, the furthest one is used
+	ip, err = clientIP(newRequest("127.0.0.1:51234", "10.0.0.7, 10.0.0.8"))
+	s.Nil(err)
+	s.Equal(ip, "10.0.0.7")
+	// Malformed entries aren't trusted
+	ip, err = clientIP(newRequest("127.0.0.1:51234", "garbage, 10.0.0.8"))
+	s.Nil(err)
+	s.Equal(ip, "10.0.0.8")
+}
+
+func (s *ControllersSuite) TestClientConnection() {
+	defer func(proxies []string) { config.Conf.PhishConf.TrustedProxies = proxies }(config.Conf.PhishConf.TrustedProxies)
+	config.Conf.PhishConf.TrustedProxies = []string{"10.0.0.0/8"}
+	req := httptest.NewRequest("GET", "/track", nil)
+	req.RemoteAddr = "192.0.2.10:51234"
+	s.Equal("192.0.2.10:51234", clientConnection(req))
+	// A proxy's connections are shared by unrelated clients
+	req.RemoteAddr = "10.0.0.4:51234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.5")
+	s.Equal("", clientConnection(req))
+}
+
+func (s *ControllersSuite) TestClientIPHeader() {
+	defer func(pc config.PhishServer) { config.Conf.PhishConf = pc }(config.Conf.PhishConf)
+	config.Conf.PhishConf.TrustedProxies = []string{"127.0.0.1", "10.0.0.0/8"}
+	newRequest := func(peer string, headers map[string]string) *http.Request {
+		req := httptest.NewRequest("GET", "/track", nil)
+		req.RemoteAddr = peer
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return req
+	}
+	headers := map[string]string{
+		"X-Forwarded-For": "198.51.100.1",
+		"X-Real-IP":       "203.0.113.5",
+		"Forwarded":       `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711", for=10.0.0.9:8080`,
+	}
+
+	// Only the configured header is used, whatever its case
+	config.Conf.PhishConf.ClientIPHeader = "x-real-ip"
+	ip, err := clientIP(newRequest("127.0.0.1:51234", headers))
+	s.Nil(err)
+	s.Equal("203.0.113.5", ip)
+	ip, err = clientIP(newRequest("192.0.2.10:51234", headers))
+	s.Nil(err)
+	s.Equal("192.0.2.10", ip)
+
+	// The Forwarded chain is walked back to the first untrusted address
+	config.Conf.PhishConf.ClientIPHeader = "Forwarded"
+	ip, err = clientIP(newRequest("127.0.0.1:51234", headers))
+	s.Nil(err)
+	s.Equal("2001:db8:cafe::17", ip)
+	ip, err = clientIP(newRequest("127.0.0.1:51234", map[string]string{"Forwarded": "for=unknown, for=10.0.0.9"}))
+	s.Nil(err)
+	s.Equal("10.0.0.9", ip)
+	s.Equal([]string{"192.0.2.60", "2001:db8:cafe::17", "10.0.0.9"}, forwardedFor([]string{headers["Forwarded"]}))
+
+	s.Nil(CheckTrustedProxies())
+	config.Conf.PhishConf.ClientIPHeader = "True-Client-IP"
+	s.Equal(ErrInvalidClientIPHeader, CheckTrustedProxies())
+	ip, err = clientIP(newRequest("127.0.0.1:51234", headers))
+	s.Nil(err)
+	s.Equal("127.0.0.1", ip)
+	config.Conf.PhishConf.ClientIPHeader = ""
+	config.Conf.PhishConf.TrustedProxies = []string{"10.0.0.0/33"}
+	s.NotNil(CheckTrustedProxies())
+	config.Conf.PhishConf.TrustedProxies = []string{"proxy.local"}
+	s.NotNil(CheckTrustedProxies())
+}
+
+func (s *ControllersSuite) TestOpenedPhishingEmailBehindProxy() {
+	defer func(proxies []string) { config.Conf.PhishConf.TrustedProxies = proxies }(config.Conf.PhishConf.TrustedProxies)
+	config.Conf.PhishConf.TrustedProxies = []string{"127.0.0.1", "::1"}
+	campaign := s.getFirstCampaign()
+	result := campaign.Results[0]
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/track?%s=%s", ps.URL, models.RecipientParameter, result.RId), nil)
+	s.Nil(err)
+	req.Header.Set("X-Forwarded-For", "8.8.8.8")
+	resp, err := http.DefaultClient.Do(req)
+	s.Nil(err)
+	resp.Body.Close()
+
+	campaign = s.getFirstCampaign()
+	result = campaign.Results[0]
+	s.Equal(result.Status, models.EVENT_OPENED)
+	s.Equal(result.IP, "8.8.8.8")
+}
+
+func (s *ControllersSuite) TestRenderLandingPageVariables() {
+	campaign := s.getFirstCampaign()
+	campaign.Page.HTML = "<p>{{.FirstName}}, {{.Variables.department}}{{.Variables.missing}}</p>"
+	result := campaign.Results[0]
+	result.Variables = map[string]string{"department": "Sales"}
+	buff := bytes.Buffer{}
+	s.Nil(renderLandingPage(&buff, campaign.Page, campaign, result))
+	s.Equal(buff.String(), fmt.Sprintf("<p>%s, Sales</p>", result.FirstName))
+}
+
+func (s *ControllersSuite) TestRenderLandingPageCustomFields() {
+	campaign := s.getFirstCampaign()
+	campaign.Page.HTML = "<p>{{.Custom.Department}}, {{.Custom.EmployeeId}}</p>"
+	result := campaign.Results[0]
+	result.Variables = map[string]string{"department": "Sales", "employee_id": "E1"}
+	buff := bytes.Buffer{}
+	s.Nil(renderLandingPage(&buff, campaign.Page, campaign, result))
+	s.Equal("<p>Sales, E1</p>", buff.String())
+}
+
+func (s *ControllersSuite) TestProxyLandingPage() {
+	defer func(networks []string) {
+		config.Conf.PhishConf.ProxyAllowedNetworks = networks
+	}(config.Conf.PhishConf.ProxyAllowedNetworks)
+	config.Conf.PhishConf.ProxyAllowedNetworks = []string{"127.0.0.0/8"}
+	var login url.Values
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start":
+			s.Equal(r.URL.Query().Get(models.RecipientParameter), "")
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Security-Policy", "default-src 'self'")
+			fmt.Fprintf(w, `<html><head><script src="http://%s/app.js"></script></head><body><a href="/help">Help</a><a href="https://example.com">Out</a><form action="/login" method="POST"></form></body></html>`, r.Host)
+		case "/login":
+			_, err := r.Cookie(ProxyCookieName)
+			s.Equal(err, http.ErrNoCookie)
+			r.ParseForm()
+			login = r.PostForm
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Domain: "127.0.0.1", Secure: true})
+			http.Redirect(w, r, "http://"+r.Host+"/account", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+	phishURL, _ := url.Parse(ps.URL)
+
+	first := s.getFirstCampaign()
+	p := models.Page{Name: "Proxy Page", UserId: 1, ProxyURL: target.URL + "/start", ProxyCaptureRules: "/login"}
+	s.Nil(models.PostPage(&p))
+	c := models.Campaign{Name: "Proxy campaign"}
+	c.Template = first.Template
+	c.Page = p
+	c.SMTP = first.SMTP
+	c.Groups = []models.Group{models.Group{Name: "Test Group"}}
+	s.Nil(models.PostCampaign(&c, 1))
+	result := c.Results[0]
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	// The landing page is fetched from the proxied site, with its links
+	// pointed at the phishing server
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/?%s=%s", ps.URL, models.RecipientParameter, result.RId), nil)
+	req.Header.Set("User-Agent", browserUserAgent)
+	resp, err := client.Do(req)
+	s.Nil(err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	s.Nil(err)
+	s.Equal(resp.StatusCode, http.StatusOK)
+	s.Equal(resp.Header.Get("Content-Security-Policy"), "")
+	html := string(body)
+	s.Contains(html, fmt.Sprintf(`src="http://%s/app.js"`, phishURL.Host))
+	s.Contains(html, fmt.Sprintf(`href="http://%s/help?%s=%s"`, phishURL.Host, models.RecipientParameter, result.RId))
+	s.Contains(html, fmt.Sprintf(`action="http://%s/login?%s=%s"`, phishURL.Host, models.RecipientParameter, result.RId))
+	s.Contains(html, `href="https://example.com"`)
+	s.NotContains(html, targetURL.Host)
+	var cookie *http.Cookie
+	for _, ck := range resp.Cookies() {
+		if ck.Name == ProxyCookieName {
+			cookie = ck
+		}
+	}
+	s.NotNil(cookie)
+	s.Equal(cookie.Value, result.RId)
+	result, err = models.GetResult(result.RId)
+	s.Nil(err)
+	s.Equal(result.Status, models.EVENT_CLICKED)
+
+	// Later requests are identified by the cookie, and only the data
+	// submitted to paths matching the capture rules is recorded
+	req, _ = http.NewRequest("POST", ps.URL+"/search", strings.NewReader("q=test"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", browserUserAgent)
+	req.AddCookie(cookie)
+	resp, err = client.Do(req)
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusNoContent)
+	result, err = models.GetResult(result.RId)
+	s.Nil(err)
+	s.Equal(result.Status, models.EVENT_CLICKED)
+
+	req, _ = http.NewRequest("POST", ps.URL+"/login", strings.NewReader("username=jdoe&password=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", browserUserAgent)
+	req.AddCookie(cookie)
+	resp, err = client.Do(req)
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(login.Get("username"), "jdoe")
+	s.Equal(resp.StatusCode, http.StatusFound)
+	s.Equal(resp.Header.Get("Location"), fmt.Sprintf("http://%s/account", phishURL.Host))
+	s.Equal(resp.Header.Get("Set-Cookie"), "session=abc")
+	result, err = models.GetResult(result.RId)
+	s.Nil(err)
+	s.Equal(result.Status, models.EVENT_DATA_SUBMIT)
+
+	// Bodies which are too large to forward are refused rather than cut off
+	defer func(size int) { MaxProxyBodySize = size }(MaxProxyBodySize)
+	MaxProxyBodySize = 16
+	req, _ = http.NewRequest("POST", ps.URL+"/login", strings.NewReader("username=jdoe&password=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", browserUserAgent)
+	req.AddCookie(cookie)
+	resp, err = client.Do(req)
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// Addresses are checked again each time a connection to the site is made
+	config.Conf.PhishConf.ProxyAllowedNetworks = nil
+	proxyTransport.CloseIdleConnections()
+	req, _ = http.NewRequest("GET", ps.URL+"/help", nil)
+	req.Header.Set("User-Agent", browserUserAgent)
+	req.AddCookie(cookie)
+	resp, err = client.Do(req)
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(http.StatusBadGateway, resp.StatusCode)
+
+	// Requests which don't identify the recipient aren't proxied
+	resp, err = client.Get(ps.URL + "/help")
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusNotFound)
+
+	// The cookie doesn't identify recipients to pages which aren't proxied
+	req, _ = http.NewRequest("GET", ps.URL+"/", nil)
+	req.AddCookie(&http.Cookie{Name: ProxyCookieName, Value: first.Results[0].RId})
+	resp, err = client.Do(req)
+	s.Nil(err)
+	resp.Body.Close()
+	s.Equal(resp.StatusCode, http.StatusNotFound)
+}
+
+func (s *ControllersSuite) TestSignedRecipientIds() {
+	defer func(key string) { models.RecipientIdSigningKey = key }(models.RecipientIdSigningKey)
+	models.RecipientIdSigningKey = "secret"
+
+	// Campaigns created before signing was enabled keep accepting the
+	// unsigned IDs in the emails they've already sent
+	legacy := s.getFirstCampaign()
+	s.clickLink(legacy.Results[0].RId, legacy)
+
+	first := s.getFirstCampaign()
+	campaign := models.Campaign{Name: "Signed campaign"}
+	campaign.Template = first.Template
+	campaign.Page = first.Page
+	campaign.SMTP = first.SMTP
+	campaign.Groups = []models.Group{models.Group{Name: "Test Group"}}
+	s.Nil(models.PostCampaign(&campaign, 1))
+	result := campaign.Results[0]
+
+	// Unsigned and forged IDs are rejected for campaigns created since
+	s.clickLink404(result.RId)
+	s.clickLink404(result.RId + ".forged")
+	s.clickLink(models.SignRecipientId(result.RId), campaign)
+	result, err := models.GetResult(result.RId)
+	s.Nil(err)
+	s.Equal(result.Status, models.EVENT_CLICKED)
+}
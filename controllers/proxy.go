@@ -0,0 +1,382 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/gophish/models"
+)
+
+// ProxyCookieName is the name of the cookie which identifies the recipient
+// while they browse a proxied landing page, since most of the requests made
+// by the proxied site don't include the recipient's ID
+var ProxyCookieName = "_rid"
+
+// MaxProxyBodySize is the largest request body, in bytes, which is forwarded
+// to a proxied site, and the largest response which is rewritten to point at
+// the phishing server
+var MaxProxyBodySize = 10 << 20
+
+// rewrittenContentTypes are the types of the responses from proxied sites
+// whose links to the site are rewritten to point at the phishing server
+var rewrittenContentTypes = []string{"text/", "application/javascript", "application/x-javascript", "application/json"}
+
+// errProxyBodyTooLarge is returned when a request to a proxied landing page
+// has a body larger than MaxProxyBodySize
+var errProxyBodyTooLarge = errors.New("request body too large to proxy")
+
+// bodyRecorder keeps the part of a request's body which is read while its
+// form is parsed, so that the whole body can be forwarded if the request is
+// for a proxied landing page. Only urlencoded forms are read by the parser,
+// so other bodies are left unread unless they're forwarded.
+type bodyRecorder struct {
+	body io.ReadCloser
+	read bytes.Buffer
+}
+
+// recordBody records the request's body as it's read, returning nil for
+// requests without a body
+func recordBody(r *http.Request) *bodyRecorder {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	b := &bodyRecorder{body: r.Body}
+	r.Body = b
+	return b
+}
+
+// Read reads from the body, keeping what's been read
+func (b *bodyRecorder) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.read.Write(p[:n])
+	return n, err
+}
+
+// Close closes the body
+func (b *bodyRecorder) Close() error {
+	return b.body.Close()
+}
+
+// bytes returns the whole body, reading whatever hasn't been read yet. It
+// returns errProxyBodyTooLarge if the body is larger than MaxProxyBodySize.
+func (b *bodyRecorder) bytes() ([]byte, error) {
+	if b == nil {
+		return nil, nil
+	}
+	if b.read.Len() > MaxProxyBodySize {
+		return nil, errProxyBodyTooLarge
+	}
+	rest, err := ioutil.ReadAll(io.LimitReader(b.body, int64(MaxProxyBodySize-b.read.Len())+1))
+	if err != nil {
+		return nil, err
+	}
+	if b.read.Len()+len(rest) > MaxProxyBodySize {
+		return nil, errProxyBodyTooLarge
+	}
+	return append(b.read.Bytes(), rest...), nil
+}
+
+// proxyDialer dials the proxied sites
+var proxyDialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+// proxyTransport is used to make requests to proxied sites. It doesn't use
+// the environment's HTTP proxy, so that the addresses it connects to are
+// always checked.
+var proxyTransport = &http.Transport{
+	DialContext:           dialProxiedSite,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// dialProxiedSite connects to a proxied site once its host has been resolved
+// and each of its addresses checked, so that a host which resolves to an
+// internal address after its landing page was saved can't be proxied.
+func dialProxiedSite(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := models.ResolveProxyHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = proxyDialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// useProxyCookie identifies the recipient of requests without a recipient ID
+// by the cookie set when they opened a proxied landing page, returning
+// whether or not the cookie was used.
+func useProxyCookie(r *http.Request) bool {
+	r.ParseForm()
+	if r.Form.Get(models.RecipientParameter) != "" {
+		return false
+	}
+	ck, err := r.Cookie(ProxyCookieName)
+	if err != nil || ck.Value == "" {
+		return false
+	}
+	r.Form.Set(models.RecipientParameter, ck.Value)
+	return true
+}
+
+// proxyLandingPage reverse-proxies the request to the site at the page's
+// proxy URL. Paths are passed through to the site unchanged, except for the
+// root path, which is sent to the proxy URL itself. The recipient clicking
+// the link is recorded once the site responds, along with the data submitted
+// to the paths matching the page's capture rules.
+func proxyLandingPage(w http.ResponseWriter, r *http.Request, p models.Page, c models.Campaign, rs models.Result, d models.EventDetails, rec *bodyRecorder, fromCookie bool) {
+	target, err := url.Parse(p.ProxyURL)
+	if err != nil {
+		log.Error(err)
+		http.NotFound(w, r)
+		return
+	}
+	body, err := rec.bytes()
+	if err == errProxyBodyTooLarge {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	phish := &url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		phish.Scheme = "https"
+	}
+	capture := r.Method == "POST" && p.CapturesProxyRequest(r.URL.Path)
+	proxy := &httputil.ReverseProxy{
+		Transport: proxyTransport,
+		Director: func(req *http.Request) {
+			proxyRequest(req, target, phish, body)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			d.StatusCode = resp.StatusCode
+			d.LandingURL = resp.Request.URL.String()
+			switch {
+			case r.Method == "GET" && !fromCookie:
+				d.Link = r.Form.Get(models.LinkParameter)
+				d.QR = r.Form.Get(models.QRParameter) != ""
+				err := handleClick(rs, c, d)
+				if err != nil {
+					log.Error(err)
+				}
+			case capture:
+				d.Payload = proxiedPayload(r, body)
+				d.CapturePolicy = p.CapturePolicy()
+				d.PageHoneypots = p.Honeypots()
+				err := rs.HandleFormSubmit(d)
+				if err != nil {
+					log.Error(err)
+				}
+			}
+			if !fromCookie {
+				ck := &http.Cookie{Name: ProxyCookieName, Value: models.SignRecipientId(rs.RId), Path: "/", HttpOnly: true, Secure: r.TLS != nil}
+				resp.Header.Add("Set-Cookie", ck.String())
+			}
+			return rewriteProxyResponse(resp, target, phish, models.SignRecipientId(rs.RId))
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// proxyRequest points the request at the proxied site, removing the
+// recipient's ID and the phishing server's cookie
+func proxyRequest(req *http.Request, target *url.URL, phish *url.URL, body []byte) {
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	if req.URL.Path == "" || req.URL.Path == "/" {
+		req.URL.Path = target.Path
+		if req.URL.RawQuery == "" {
+			req.URL.RawQuery = target.RawQuery
+		}
+	}
+	q := req.URL.Query()
+	if _, ok := q[models.RecipientParameter]; ok {
+		q.Del(models.RecipientParameter)
+		req.URL.RawQuery = q.Encode()
+	}
+	req.Host = target.Host
+	// Responses are requested uncompressed so that they can be rewritten
+	req.Header.Del("Accept-Encoding")
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, ck := range cookies {
+		if ck.Name != ProxyCookieName {
+			req.AddCookie(ck)
+		}
+	}
+	for _, h := range []string{"Origin", "Referer"} {
+		if v := req.Header.Get(h); v != "" {
+			req.Header.Set(h, strings.Replace(v, origin(phish), origin(target), 1))
+		}
+	}
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+}
+
+// proxiedPayload returns the data submitted to the proxied site, including
+// the fields of JSON objects
+func proxiedPayload(r *http.Request, body []byte) url.Values {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return r.PostForm
+	}
+	payload := url.Values{}
+	fields := map[string]interface{}{}
+	if json.Unmarshal(body, &fields) != nil {
+		return payload
+	}
+	for k, v := range fields {
+		switch v := v.(type) {
+		case string:
+			payload.Set(k, v)
+		case nil:
+		default:
+			b, _ := json.Marshal(v)
+			payload.Set(k, string(b))
+		}
+	}
+	return payload
+}
+
+// origin returns the scheme and host of the URL
+func origin(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// rewriteProxyResponse points the redirects, cookies and links in a response
+// from the proxied site at the phishing server. Headers which would stop the
+// proxied site from working on the phishing server's domain are removed.
+func rewriteProxyResponse(resp *http.Response, target *url.URL, phish *url.URL, rid string) error {
+	if loc := resp.Header.Get("Location"); loc != "" {
+		if u, err := resp.Request.URL.Parse(loc); err == nil && u.Host == target.Host {
+			u.Scheme = phish.Scheme
+			u.Host = phish.Host
+			resp.Header.Set("Location", u.String())
+		}
+	}
+	cookies := resp.Header["Set-Cookie"]
+	for i, ck := range cookies {
+		cookies[i] = rewriteProxyCookie(ck, phish.Scheme == "https")
+	}
+	resp.Header.Del("Content-Security-Policy")
+	resp.Header.Del("Content-Security-Policy-Report-Only")
+	resp.Header.Del("Strict-Transport-Security")
+
+	ct := resp.Header.Get("Content-Type")
+	rewritable := false
+	for _, t := range rewrittenContentTypes {
+		if strings.HasPrefix(ct, t) {
+			rewritable = true
+		}
+	}
+	if !rewritable || (resp.Header.Get("Content-Encoding") != "" && resp.Header.Get("Content-Encoding") != "identity") {
+		return nil
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(MaxProxyBodySize)+1))
+	if err != nil {
+		return err
+	}
+	// Responses which are too large are passed through unchanged
+	if len(b) > MaxProxyBodySize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(b), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+	if strings.HasPrefix(ct, "text/html") {
+		b = rewriteProxyLinks(b, resp.Request.URL, target, phish, rid)
+	}
+	b = bytes.Replace(b, []byte(origin(target)), []byte(origin(phish)), -1)
+	b = bytes.Replace(b, []byte("//"+target.Host), []byte("//"+phish.Host), -1)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	resp.ContentLength = int64(len(b))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(b)))
+	return nil
+}
+
+// rewriteProxyCookie removes the domain from a cookie set by the proxied
+// site, so that the browser stores it for the phishing server instead. Secure
+// cookies are made insecure when the phishing server isn't using TLS.
+func rewriteProxyCookie(ck string, secure bool) string {
+	parts := []string{}
+	for i, part := range strings.Split(ck, ";") {
+		attr := strings.ToLower(strings.TrimSpace(part))
+		switch {
+		case i == 0:
+		case strings.HasPrefix(attr, "domain="):
+			continue
+		case !secure && (attr == "secure" || attr == "samesite=none"):
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ";")
+}
+
+// rewriteProxyLinks adds the recipient's ID to the links and forms in a page
+// from the proxied site which lead back to the site, so that navigating the
+// site is tracked even if the browser doesn't keep the phishing server's
+// cookie. The page is returned unchanged if it has no such links.
+func rewriteProxyLinks(b []byte, base *url.URL, target *url.URL, phish *url.URL, rid string) []byte {
+	d, err := goquery.NewDocumentFromReader(bytes.NewReader(b))
+	if err != nil {
+		return b
+	}
+	changed := false
+	for _, res := range []struct {
+		selector string
+		attr     string
+	}{{"a[href]", "href"}, {"form[action]", "action"}} {
+		d.Find(res.selector).Each(func(i int, e *goquery.Selection) {
+			u, err := base.Parse(strings.TrimSpace(e.AttrOr(res.attr, "")))
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return
+			}
+			if u.Host != target.Host && u.Host != phish.Host {
+				return
+			}
+			u.Scheme = phish.Scheme
+			u.Host = phish.Host
+			q := u.Query()
+			q.Set(models.RecipientParameter, rid)
+			u.RawQuery = q.Encode()
+			e.SetAttr(res.attr, u.String())
+			changed = true
+		})
+	}
+	if !changed {
+		return b
+	}
+	h, err := d.Html()
+	if err != nil {
+		return b
+	}
+	return []byte(h)
+}
@@ -3,8 +3,11 @@ package controllers
 import (
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gophish/gophish/auth"
 	"github.com/gophish/gophish/config"
@@ -15,8 +18,17 @@ import (
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// TwoFactorTimeout is how long users have to provide a two-factor
+// authentication code after giving a valid username and password
+var TwoFactorTimeout = 5 * time.Minute
+
+// MaxTwoFactorAttempts is the number of invalid two-factor authentication
+// codes a user can provide before they need to log in again
+var MaxTwoFactorAttempts = 5
+
 // CreateAdminRouter creates the routes for handling requests to the web interface.
 // This function returns an http.Handler to be used in http.ListenAndServe().
 func CreateAdminRouter() http.Handler {
@@ -24,6 +36,9 @@ func CreateAdminRouter() http.Handler {
 	// Base Front-end routes
 	router.HandleFunc("/", Use(Base, mid.RequireLogin))
 	router.HandleFunc("/login", Login)
+	router.HandleFunc("/login/2fa", LoginTwoFactor)
+	router.HandleFunc("/login/sso", SSOLogin)
+	router.HandleFunc("/login/sso/callback", SSOCallback)
 	router.HandleFunc("/logout", Use(Logout, mid.RequireLogin))
 	router.HandleFunc("/campaigns", Use(Campaigns, mid.RequireLogin))
 	router.HandleFunc("/campaigns/{id:[0-9]+}", Use(CampaignID, mid.RequireLogin))
@@ -31,33 +46,83 @@ func CreateAdminRouter() http.Handler {
 	router.HandleFunc("/users", Use(Users, mid.RequireLogin))
 	router.HandleFunc("/landing_pages", Use(LandingPages, mid.RequireLogin))
 	router.HandleFunc("/sending_profiles", Use(SendingProfiles, mid.RequireLogin))
-	router.HandleFunc("/register", Use(Register, mid.RequireLogin))
-	router.HandleFunc("/settings", Use(Settings, mid.RequireLogin))
+	router.HandleFunc("/register", Use(Register, mid.Audit, mid.RequireAdmin, mid.RequireLogin))
+	router.HandleFunc("/settings", Use(Settings, mid.Audit, mid.RequireLogin))
+	router.HandleFunc("/settings/2fa", Use(TwoFactorSettings, mid.Audit, mid.RequireLogin))
+	router.HandleFunc("/metrics", Use(promhttp.Handler().ServeHTTP, mid.RequireScope("metrics"), mid.RequireAPIKey))
 	// Create the API routes
 	api := router.PathPrefix("/api").Subrouter()
 	api = api.StrictSlash(true)
 	api.HandleFunc("/", Use(API, mid.RequireLogin))
-	api.HandleFunc("/reset", Use(API_Reset, mid.RequireAPIKey))
-	api.HandleFunc("/campaigns/", Use(API_Campaigns, mid.RequireAPIKey))
-	api.HandleFunc("/campaigns/summary", Use(API_Campaigns_Summary, mid.RequireAPIKey))
-	api.HandleFunc("/campaigns/{id:[0-9]+}", Use(API_Campaigns_Id, mid.RequireAPIKey))
-	api.HandleFunc("/campaigns/{id:[0-9]+}/results", Use(API_Campaigns_Id_Results, mid.RequireAPIKey))
-	api.HandleFunc("/campaigns/{id:[0-9]+}/summary", Use(API_Campaign_Id_Summary, mid.RequireAPIKey))
-	api.HandleFunc("/campaigns/{id:[0-9]+}/complete", Use(API_Campaigns_Id_Complete, mid.RequireAPIKey))
-	api.HandleFunc("/groups/", Use(API_Groups, mid.RequireAPIKey))
-	api.HandleFunc("/groups/summary", Use(API_Groups_Summary, mid.RequireAPIKey))
-	api.HandleFunc("/groups/{id:[0-9]+}", Use(API_Groups_Id, mid.RequireAPIKey))
-	api.HandleFunc("/groups/{id:[0-9]+}/summary", Use(API_Groups_Id_Summary, mid.RequireAPIKey))
-	api.HandleFunc("/templates/", Use(API_Templates, mid.RequireAPIKey))
-	api.HandleFunc("/templates/{id:[0-9]+}", Use(API_Templates_Id, mid.RequireAPIKey))
-	api.HandleFunc("/pages/", Use(API_Pages, mid.RequireAPIKey))
-	api.HandleFunc("/pages/{id:[0-9]+}", Use(API_Pages_Id, mid.RequireAPIKey))
-	api.HandleFunc("/smtp/", Use(API_SMTP, mid.RequireAPIKey))
-	api.HandleFunc("/smtp/{id:[0-9]+}", Use(API_SMTP_Id, mid.RequireAPIKey))
-	api.HandleFunc("/util/send_test_email", Use(API_Send_Test_Email, mid.RequireAPIKey))
-	api.HandleFunc("/import/group", Use(API_Import_Group, mid.RequireAPIKey))
-	api.HandleFunc("/import/email", Use(API_Import_Email, mid.RequireAPIKey))
-	api.HandleFunc("/import/site", Use(API_Import_Site, mid.RequireAPIKey))
+	api.HandleFunc("/reset", Use(API_Reset, mid.Audit, mid.RequireScope("keys"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/", Use(API_Campaigns, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/compare", Use(API_Campaigns_Compare, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/trends", Use(API_Campaigns_Trends, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/trends/attribute", Use(API_Campaigns_Trends_Attribute, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/summary", Use(API_Campaigns_Summary, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}", Use(API_Campaigns_Id, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}/results", Use(API_Campaigns_Id_Results, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}/stream", Use(API_Campaigns_Id_Stream, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}/report", Use(API_Campaigns_Id_Report, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}/summary", Use(API_Campaign_Id_Summary, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}/complete", Use(API_Campaigns_Id_Complete, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}/anonymize", Use(API_Campaigns_Id_Anonymize, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}/archive", Use(API_Campaigns_Id_Archive, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}/pause", Use(API_Campaigns_Id_Pause, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}/resume", Use(API_Campaigns_Id_Resume, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}/previews", Use(API_Campaigns_Id_Previews, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}/copy", Use(API_Campaigns_Id_Copy, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/{id:[0-9]+}/export", Use(API_Campaigns_Id_Export, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/campaigns/import", Use(API_Campaigns_Import, mid.Audit, mid.RequireScope("templates"), mid.RequireScope("pages"), mid.RequireAPIKey))
+	api.HandleFunc("/results/{rid}/tags", Use(API_Results_RId_Tags, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/results/{rid}/notes", Use(API_Results_RId_Notes, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/results/{rid}/notes/{id:[0-9]+}", Use(API_Results_RId_Notes_Id, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/groups/", Use(API_Groups, mid.Audit, mid.RequireScope("groups"), mid.RequireAPIKey))
+	api.HandleFunc("/groups/summary", Use(API_Groups_Summary, mid.Audit, mid.RequireScope("groups"), mid.RequireAPIKey))
+	api.HandleFunc("/groups/{id:[0-9]+}", Use(API_Groups_Id, mid.Audit, mid.RequireScope("groups"), mid.RequireAPIKey))
+	api.HandleFunc("/groups/{id:[0-9]+}/summary", Use(API_Groups_Id_Summary, mid.Audit, mid.RequireScope("groups"), mid.RequireAPIKey))
+	api.HandleFunc("/groups/{id:[0-9]+}/risk", Use(API_Groups_Id_Risk, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/users/risk", Use(API_Users_Risk, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/users/", Use(API_Users, mid.Audit, mid.RequireAdmin, mid.RequireScope("users"), mid.RequireAPIKey))
+	api.HandleFunc("/users/{id:[0-9]+}", Use(API_Users_Id, mid.Audit, mid.RequireAdmin, mid.RequireScope("users"), mid.RequireAPIKey))
+	api.HandleFunc("/teams/", Use(API_Teams, mid.Audit, mid.RequireAdmin, mid.RequireScope("users"), mid.RequireAPIKey))
+	api.HandleFunc("/teams/{id:[0-9]+}", Use(API_Teams_Id, mid.Audit, mid.RequireAdmin, mid.RequireScope("users"), mid.RequireAPIKey))
+	api.HandleFunc("/auditlog", Use(API_AuditLog, mid.RequireRole(models.ROLE_ADMIN, models.ROLE_AUDITOR), mid.RequireScope("audit"), mid.RequireAPIKey))
+	api.HandleFunc("/keys/", Use(API_Keys, mid.Audit, mid.RequireScope("keys"), mid.RequireAPIKey))
+	api.HandleFunc("/keys/{id:[0-9]+}", Use(API_Keys_Id, mid.Audit, mid.RequireScope("keys"), mid.RequireAPIKey))
+	api.HandleFunc("/webhooks/", Use(API_Webhooks, mid.Audit, mid.RequireScope("webhooks"), mid.RequireAPIKey))
+	api.HandleFunc("/webhooks/{id:[0-9]+}", Use(API_Webhooks_Id, mid.Audit, mid.RequireScope("webhooks"), mid.RequireAPIKey))
+	api.HandleFunc("/notifications/", Use(API_Notifications, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/notifications/{id:[0-9]+}", Use(API_Notifications_Id, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/schedules/", Use(API_Schedules, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/schedules/{id:[0-9]+}", Use(API_Schedules_Id, mid.Audit, mid.RequireScope("campaigns"), mid.RequireAPIKey))
+	api.HandleFunc("/directories/", Use(API_Directories, mid.Audit, mid.RequireScope("groups"), mid.RequireAPIKey))
+	api.HandleFunc("/directories/{id:[0-9]+}", Use(API_Directories_Id, mid.Audit, mid.RequireScope("groups"), mid.RequireAPIKey))
+	api.HandleFunc("/directories/{id:[0-9]+}/sync", Use(API_Directories_Id_Sync, mid.Audit, mid.RequireScope("groups"), mid.RequireAPIKey))
+	api.HandleFunc("/directories/{id:[0-9]+}/reports", Use(API_Directories_Id_Reports, mid.RequireScope("groups"), mid.RequireAPIKey))
+	api.HandleFunc("/dead_letters/", Use(API_Dead_Letters, mid.Audit, mid.RequireScope("webhooks"), mid.RequireAPIKey))
+	api.HandleFunc("/dead_letters/{id:[0-9]+}", Use(API_Dead_Letters_Id, mid.Audit, mid.RequireScope("webhooks"), mid.RequireAPIKey))
+	api.HandleFunc("/dead_letters/{id:[0-9]+}/replay", Use(API_Dead_Letters_Id_Replay, mid.Audit, mid.RequireScope("webhooks"), mid.RequireAPIKey))
+	api.HandleFunc("/bounces", Use(API_Bounces, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/replies", Use(API_Replies, mid.Audit, mid.RequireScope("results"), mid.RequireAPIKey))
+	api.HandleFunc("/templates/", Use(API_Templates, mid.Audit, mid.RequireScope("templates"), mid.RequireAPIKey))
+	api.HandleFunc("/templates/{id:[0-9]+}", Use(API_Templates_Id, mid.Audit, mid.RequireScope("templates"), mid.RequireAPIKey))
+	api.HandleFunc("/pages/", Use(API_Pages, mid.Audit, mid.RequireScope("pages"), mid.RequireAPIKey))
+	api.HandleFunc("/pages/{id:[0-9]+}", Use(API_Pages_Id, mid.Audit, mid.RequireScope("pages"), mid.RequireAPIKey))
+	api.HandleFunc("/pages/{id:[0-9]+}/assets", Use(API_Pages_Id_Assets, mid.Audit, mid.RequireScope("pages"), mid.RequireAPIKey))
+	api.HandleFunc("/pages/{id:[0-9]+}/assets/{asset_id:[0-9]+}", Use(API_Pages_Id_Assets_Id, mid.Audit, mid.RequireScope("pages"), mid.RequireAPIKey))
+	api.HandleFunc("/smtp/", Use(API_SMTP, mid.Audit, mid.RequireScope("smtp"), mid.RequireAPIKey))
+	api.HandleFunc("/smtp/{id:[0-9]+}", Use(API_SMTP_Id, mid.Audit, mid.RequireScope("smtp"), mid.RequireAPIKey))
+	api.HandleFunc("/sms/", Use(API_SMS, mid.Audit, mid.RequireScope("sms"), mid.RequireAPIKey))
+	api.HandleFunc("/sms/{id:[0-9]+}", Use(API_SMS_Id, mid.Audit, mid.RequireScope("sms"), mid.RequireAPIKey))
+	api.HandleFunc("/util/send_test_email", Use(API_Send_Test_Email, mid.Audit, mid.RequireScope("smtp"), mid.RequireAPIKey))
+	api.HandleFunc("/util/test_send", Use(API_Test_Send, mid.Audit, mid.RequireScope("smtp"), mid.RequireAPIKey))
+	api.HandleFunc("/util/lint", Use(API_Lint, mid.RequireScope("templates"), mid.RequireAPIKey))
+	api.HandleFunc("/import/group", Use(API_Import_Group, mid.RequireScope("groups"), mid.RequireAPIKey))
+	api.HandleFunc("/import/email", Use(API_Import_Email, mid.RequireScope("templates"), mid.RequireAPIKey))
+	api.HandleFunc("/import/site", Use(API_Import_Site, mid.RequireScope("pages"), mid.RequireAPIKey))
+	api.HandleFunc("/geoip/reload", Use(API_GeoIP_Reload, mid.Audit, mid.RequireAdmin, mid.RequireScope("settings"), mid.RequireAPIKey))
+	api.HandleFunc("/metrics", Use(promhttp.Handler().ServeHTTP, mid.RequireScope("metrics"), mid.RequireAPIKey))
 
 	// Setup static file serving
 	router.PathPrefix("/").Handler(http.FileServer(UnindexedFileSystem{http.Dir("./static/")}))
@@ -67,7 +132,7 @@ func CreateAdminRouter() http.Handler {
 		csrf.FieldName("csrf_token"),
 		csrf.Secure(config.Conf.AdminConf.UseTLS))
 	csrfRouter := csrfHandler(router)
-	return Use(csrfRouter.ServeHTTP, mid.CSRFExceptions, mid.GetContext)
+	return Use(csrfRouter.ServeHTTP, mid.CSRFExceptions, mid.GetContext, RequireAllowedNetwork)
 }
 
 // Use allows us to stack middleware to process the request
@@ -237,55 +302,256 @@ func Settings(w http.ResponseWriter, r *http.Request) {
 // Login handles the authentication flow for a user. If credentials are valid,
 // a session is created
 func Login(w http.ResponseWriter, r *http.Request) {
-	params := struct {
-		User    models.User
-		Title   string
-		Flashes []interface{}
-		Token   string
-	}{Title: "Login", Token: csrf.Token(r)}
 	session := ctx.Get(r, "session").(*sessions.Session)
 	switch {
 	case r.Method == "GET":
-		params.Flashes = session.Flashes()
-		session.Save(r, w)
-		templates := template.New("template")
-		_, err := templates.ParseFiles("templates/login.html", "templates/flashes.html")
-		if err != nil {
-			log.Error(err)
-		}
-		template.Must(templates, err).ExecuteTemplate(w, "base", params)
+		renderLogin(w, r, http.StatusOK)
 	case r.Method == "POST":
+		if config.Conf.AdminConf.SSO.DisableLocalLogin {
+			Flash(w, r, "danger", "Please sign in with single sign-on")
+			renderLogin(w, r, http.StatusForbidden)
+			return
+		}
+		ip := remoteIP(r)
+		username := r.FormValue("username")
+		if d := auth.DefaultLoginGuard.Locked(ip, username); d > 0 {
+			renderLoginLocked(w, r, d)
+			return
+		}
 		//Attempt to login
 		succ, u, err := auth.Login(r)
 		if err != nil {
 			log.Error(err)
 		}
+		if !succ {
+			auth.DefaultLoginGuard.Fail(ip, username)
+		}
+		// If the user has two-factor authentication enabled, they need to
+		// provide a code before they're logged in. Their failures aren't
+		// reset until they do, so that the code can't be brute forced by
+		// logging in again between guesses.
+		if succ && u.TOTPEnabled {
+			session.Values["2fa_id"] = u.Id
+			session.Values["2fa_started"] = time.Now().Unix()
+			session.Values["2fa_attempts"] = 0
+			session.Save(r, w)
+			q := url.Values{}
+			q.Set("next", r.FormValue("next"))
+			http.Redirect(w, r, fmt.Sprintf("/login/2fa?%s", q.Encode()), 302)
+			return
+		}
 		//If we've logged in, save the session and redirect to the dashboard
 		if succ {
+			auth.DefaultLoginGuard.Succeed(username)
 			session.Values["id"] = u.Id
+			delete(session.Values, "sso")
 			session.Save(r, w)
-			next := "/"
-			url, err := url.Parse(r.FormValue("next"))
-			if err == nil {
-				path := url.Path
-				if path != "" {
-					next = path
-				}
-			}
-			http.Redirect(w, r, next, 302)
+			loginRedirect(w, r)
 		} else {
 			Flash(w, r, "danger", "Invalid Username/Password")
-			params.Flashes = session.Flashes()
+			renderLogin(w, r, http.StatusUnauthorized)
+		}
+	}
+}
+
+// loginRedirect redirects a user who has just logged in to the page given by
+// the next parameter, or to the dashboard.
+func loginRedirect(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, nextPath(r.FormValue("next")), 302)
+}
+
+// nextPath returns the path of the next parameter, so that users can only be
+// redirected within gophish after logging in, or the dashboard if it's empty
+// or could lead elsewhere. Browsers treat backslashes as slashes, so paths
+// such as "/\example.com" are rejected along with protocol-relative URLs,
+// including once the path is unescaped.
+func nextPath(next string) string {
+	if !localPath(next) {
+		return "/"
+	}
+	url, err := url.Parse(next)
+	if err != nil || url.Scheme != "" || url.Host != "" || !localPath(url.Path) {
+		return "/"
+	}
+	return url.Path
+}
+
+// localPath returns whether the path is an absolute path on this server
+func localPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.Contains(p, "\\")
+}
+
+// LoginTwoFactor handles the second stage of logging in for users with
+// two-factor authentication enabled, once they've given a valid username and
+// password. Users have a limited time and number of attempts to provide a
+// valid TOTP code or recovery code before they need to log in again.
+func LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	params := struct {
+		User    models.User
+		Title   string
+		Flashes []interface{}
+		Token   string
+	}{Title: "Two-Factor Authentication", Token: csrf.Token(r)}
+	session := ctx.Get(r, "session").(*sessions.Session)
+	id, ok := session.Values["2fa_id"].(int64)
+	started, _ := session.Values["2fa_started"].(int64)
+	if !ok || time.Since(time.Unix(started, 0)) > TwoFactorTimeout {
+		clearTwoFactor(session)
+		Flash(w, r, "danger", "Please log in again")
+		session.Save(r, w)
+		http.Redirect(w, r, "/login", 302)
+		return
+	}
+	status := http.StatusOK
+	if r.Method == "POST" {
+		u, err := models.GetUser(id)
+		if err != nil {
+			log.Error(err)
+			http.Redirect(w, r, "/login", 302)
+			return
+		}
+		ip := remoteIP(r)
+		if d := auth.DefaultLoginGuard.Locked(ip, u.Username); d > 0 {
+			clearTwoFactor(session)
+			session.Save(r, w)
+			renderLoginLocked(w, r, d)
+			return
+		}
+		err = auth.VerifySecondFactor(&u, r.FormValue("code"))
+		if err == nil {
+			auth.DefaultLoginGuard.Succeed(u.Username)
+			clearTwoFactor(session)
+			session.Values["id"] = u.Id
+			delete(session.Values, "sso")
+			session.Save(r, w)
+			loginRedirect(w, r)
+			return
+		}
+		log.Error(err)
+		auth.DefaultLoginGuard.Fail(ip, u.Username)
+		attempts, _ := session.Values["2fa_attempts"].(int)
+		attempts++
+		if attempts >= MaxTwoFactorAttempts {
+			clearTwoFactor(session)
+			Flash(w, r, "danger", "Too many invalid authentication codes, please log in again")
 			session.Save(r, w)
-			templates := template.New("template")
-			_, err := templates.ParseFiles("templates/login.html", "templates/flashes.html")
-			if err != nil {
-				log.Error(err)
-			}
-			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			w.WriteHeader(http.StatusUnauthorized)
-			template.Must(templates, err).ExecuteTemplate(w, "base", params)
+			http.Redirect(w, r, "/login", 302)
+			return
+		}
+		session.Values["2fa_attempts"] = attempts
+		Flash(w, r, "danger", "Invalid authentication code")
+		status = http.StatusUnauthorized
+	}
+	params.Flashes = session.Flashes()
+	session.Save(r, w)
+	templates := template.New("template")
+	_, err := templates.ParseFiles("templates/login_2fa.html", "templates/flashes.html")
+	if err != nil {
+		log.Error(err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	template.Must(templates, err).ExecuteTemplate(w, "base", params)
+}
+
+// remoteIP returns the IP address the request to the admin server was made
+// from, which is used to throttle failed logins.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// renderLoginLocked renders the login page with a 429 response, telling the
+// user how long they need to wait before they can try to log in again.
+func renderLoginLocked(w http.ResponseWriter, r *http.Request, d time.Duration) {
+	Flash(w, r, "danger", fmt.Sprintf("Too many failed login attempts. Please try again in %s", d.Round(time.Second)))
+	renderLogin(w, r, http.StatusTooManyRequests)
+}
+
+// renderLogin renders the login page with the given status, showing any
+// flashed messages. The password form is hidden if local logins are
+// disabled, leaving only the single sign-on button.
+func renderLogin(w http.ResponseWriter, r *http.Request, status int) {
+	params := struct {
+		User              models.User
+		Title             string
+		Flashes           []interface{}
+		Token             string
+		SSOEnabled        bool
+		LocalLoginEnabled bool
+		Next              string
+	}{
+		Title:             "Login",
+		Token:             csrf.Token(r),
+		SSOEnabled:        SSOProvider != nil,
+		LocalLoginEnabled: !config.Conf.AdminConf.SSO.DisableLocalLogin,
+		Next:              r.FormValue("next"),
+	}
+	session := ctx.Get(r, "session").(*sessions.Session)
+	params.Flashes = session.Flashes()
+	session.Save(r, w)
+	templates := template.New("template")
+	_, err := templates.ParseFiles("templates/login.html", "templates/flashes.html")
+	if err != nil {
+		log.Error(err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	template.Must(templates, err).ExecuteTemplate(w, "base", params)
+}
+
+// clearTwoFactor removes a pending two-factor login from the session
+func clearTwoFactor(session *sessions.Session) {
+	delete(session.Values, "2fa_id")
+	delete(session.Values, "2fa_started")
+	delete(session.Values, "2fa_attempts")
+}
+
+// TwoFactorSettings handles two-factor authentication for the logged in user,
+// depending on the "action" form value. The "enroll" action generates a new
+// secret and returns its provisioning URI, "enable" enables two-factor
+// authentication if the code is valid for that secret and returns the user's
+// recovery codes, and "disable" disables it if the code is valid.
+func TwoFactorSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		JSONResponse(w, models.Response{Success: false, Message: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+	u := ctx.Get(r, "user").(models.User)
+	switch r.FormValue("action") {
+	case "enroll":
+		uri, err := auth.EnrollTOTP(&u)
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "Scan the QR code with your authenticator app", Data: map[string]string{
+			"secret": u.TOTPSecret,
+			"uri":    uri,
+		}}, http.StatusOK)
+	case "enable":
+		codes, err := auth.EnableTOTP(&u, r.FormValue("code"))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		JSONResponse(w, models.Response{Success: true, Message: "Two-factor authentication enabled", Data: codes}, http.StatusOK)
+	case "disable":
+		if config.Conf.AdminConf.Require2FA {
+			JSONResponse(w, models.Response{Success: false, Message: "Two-factor authentication is required for all users"}, http.StatusBadRequest)
+			return
+		}
+		err := auth.DisableTOTP(&u, r.FormValue("code"))
+		if err != nil {
+			JSONResponse(w, models.Response{Success: false, Message: err.Error()}, http.StatusBadRequest)
+			return
 		}
+		JSONResponse(w, models.Response{Success: true, Message: "Two-factor authentication disabled"}, http.StatusOK)
+	default:
+		JSONResponse(w, models.Response{Success: false, Message: "Invalid action"}, http.StatusBadRequest)
 	}
 }
 
@@ -293,6 +559,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 func Logout(w http.ResponseWriter, r *http.Request) {
 	session := ctx.Get(r, "session").(*sessions.Session)
 	delete(session.Values, "id")
+	delete(session.Values, "sso")
 	Flash(w, r, "success", "You have successfully logged out")
 	session.Save(r, w)
 	http.Redirect(w, r, "/login", 302)
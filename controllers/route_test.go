@@ -1,12 +1,22 @@
 package controllers
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/gophish/gophish/auth"
+	"github.com/gophish/gophish/config"
+	"github.com/gophish/gophish/models"
+	"github.com/gophish/gophish/util"
 )
 
 func (s *ControllersSuite) TestLoginCSRF() {
@@ -108,3 +118,211 @@ func (s *ControllersSuite) TestSuccessfulRedirect() {
 	s.Equal(err, nil)
 	s.Equal(url.Path, next)
 }
+
+func (s *ControllersSuite) TestNextPath() {
+	for next, want := range map[string]string{
+		"":                          "/",
+		"/campaigns":                "/campaigns",
+		"/campaigns?id=1":           "/campaigns",
+		"campaigns":                 "/",
+		"//example.com":             "/",
+		"/\\example.com":            "/",
+		"/campaigns\\..\\":          "/",
+		"/%2Fexample.com":           "/",
+		"/%5Cexample.com":           "/",
+		"https://example.com/":      "/",
+		"https:/example.com":        "/",
+		"javascript:alert(1)":       "/",
+		"/users?next=//example.com": "/users",
+	} {
+		s.Equal(want, nextPath(next), next)
+	}
+}
+
+func (s *ControllersSuite) TestTwoFactorLogin() {
+	u, err := models.GetUser(1)
+	s.Nil(err)
+	u.TOTPSecret = auth.GenerateTOTPSecret()
+	u.TOTPEnabled = true
+	s.Nil(models.PutUser(&u))
+	defer func() {
+		u.TOTPEnabled = false
+		u.TOTPSecret = ""
+		u.TOTPLastCounter = 0
+		models.PutUser(&u)
+	}()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(fmt.Sprintf("%s/login", as.URL))
+	s.Nil(err)
+	doc, err := goquery.NewDocumentFromResponse(resp)
+	s.Nil(err)
+	token, _ := doc.Find("input[name='csrf_token']").First().Attr("value")
+
+	post := func(path string, values url.Values) *http.Response {
+		values.Set("csrf_token", token)
+		resp, err := client.PostForm(fmt.Sprintf("%s%s", as.URL, path), values)
+		s.Nil(err)
+		return resp
+	}
+
+	// A valid password only leads to the second factor
+	resp = post("/login?next=/campaigns", url.Values{"username": {"admin"}, "password": {"gophish"}})
+	s.Equal(http.StatusFound, resp.StatusCode)
+	loc, err := resp.Location()
+	s.Nil(err)
+	s.Equal("/login/2fa", loc.Path)
+	s.Equal("/campaigns", loc.Query().Get("next"))
+
+	resp = post("/login/2fa?next=/campaigns", url.Values{"code": {"000000"}})
+	s.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	code, err := auth.GenerateTOTPCode(u.TOTPSecret, time.Now())
+	s.Nil(err)
+	resp = post("/login/2fa?next=/campaigns", url.Values{"code": {code}})
+	s.Equal(http.StatusFound, resp.StatusCode)
+	loc, err = resp.Location()
+	s.Nil(err)
+	s.Equal("/campaigns", loc.Path)
+
+	// The pending login is cleared, so the code can't be used again
+	resp = post("/login/2fa", url.Values{"code": {code}})
+	s.Equal(http.StatusFound, resp.StatusCode)
+	loc, err = resp.Location()
+	s.Nil(err)
+	s.Equal("/login", loc.Path)
+}
+
+func (s *ControllersSuite) TestLoginLockout() {
+	defer func(guard *auth.LoginGuard) {
+		auth.DefaultLoginGuard = guard
+	}(auth.DefaultLoginGuard)
+	auth.DefaultLoginGuard = auth.NewLoginGuard()
+
+	resp, err := http.Get(fmt.Sprintf("%s/login", as.URL))
+	s.Nil(err)
+	doc, err := goquery.NewDocumentFromResponse(resp)
+	s.Nil(err)
+	token, _ := doc.Find("input[name='csrf_token']").First().Attr("value")
+	login := func(password string) int {
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s/login", as.URL), strings.NewReader(url.Values{
+			"username":   {"admin"},
+			"password":   {password},
+			"csrf_token": {token},
+		}.Encode()))
+		s.Nil(err)
+		req.Header.Set("Cookie", resp.Header.Get("Set-Cookie"))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		r, err := http.DefaultClient.Do(req)
+		s.Nil(err)
+		r.Body.Close()
+		return r.StatusCode
+	}
+	for i := 0; i < auth.DefaultMaxAccountAttempts; i++ {
+		s.Equal(http.StatusUnauthorized, login("invalid"))
+	}
+	// Even the correct password is rejected while the account is locked out
+	s.Equal(http.StatusTooManyRequests, login("gophish"))
+}
+
+func (s *ControllersSuite) TestTwoFactorLockout() {
+	defer func(guard *auth.LoginGuard) {
+		auth.DefaultLoginGuard = guard
+	}(auth.DefaultLoginGuard)
+	auth.DefaultLoginGuard = auth.NewLoginGuard()
+	u, err := models.GetUser(1)
+	s.Nil(err)
+	u.TOTPSecret = auth.GenerateTOTPSecret()
+	u.TOTPEnabled = true
+	s.Nil(models.PutUser(&u))
+	defer func() {
+		u.TOTPEnabled = false
+		u.TOTPSecret = ""
+		u.TOTPLastCounter = 0
+		models.PutUser(&u)
+	}()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(fmt.Sprintf("%s/login", as.URL))
+	s.Nil(err)
+	doc, err := goquery.NewDocumentFromResponse(resp)
+	s.Nil(err)
+	token, _ := doc.Find("input[name='csrf_token']").First().Attr("value")
+	post := func(path string, values url.Values) int {
+		values.Set("csrf_token", token)
+		resp, err := client.PostForm(fmt.Sprintf("%s%s", as.URL, path), values)
+		s.Nil(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Logging in again with the password doesn't reset the failed codes
+	for i := 0; i < auth.DefaultMaxAccountAttempts; i++ {
+		s.Equal(http.StatusFound, post("/login", url.Values{"username": {"admin"}, "password": {"gophish"}}))
+		s.Equal(http.StatusUnauthorized, post("/login/2fa", url.Values{"code": {"000000"}}))
+	}
+	s.Equal(http.StatusTooManyRequests, post("/login", url.Values{"username": {"admin"}, "password": {"gophish"}}))
+}
+
+func (s *ControllersSuite) TestAdminAllowedNetworks() {
+	defer func(networks []string) {
+		config.Conf.AdminConf.AllowedNetworks = networks
+	}(config.Conf.AdminConf.AllowedNetworks)
+	get := func() int {
+		resp, err := http.Get(fmt.Sprintf("%s/login", as.URL))
+		s.Nil(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	config.Conf.AdminConf.AllowedNetworks = []string{"192.0.2.0/24"}
+	s.Equal(http.StatusForbidden, get())
+	config.Conf.AdminConf.AllowedNetworks = []string{"192.0.2.0/24", "127.0.0.1"}
+	s.Equal(http.StatusOK, get())
+	config.Conf.AdminConf.AllowedNetworks = nil
+	s.Equal(http.StatusOK, get())
+}
+
+func (s *ControllersSuite) TestCheckAdminAccess() {
+	defer func(conf config.AdminServer) {
+		config.Conf.AdminConf = conf
+	}(config.Conf.AdminConf)
+	dir, err := ioutil.TempDir("", "gophish-admin")
+	s.Nil(err)
+	defer os.RemoveAll(dir)
+	ca, key := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	s.Nil(util.CheckAndCreateSSL(ca, key))
+
+	config.Conf.AdminConf.AllowedNetworks = []string{"10.0.0.0/8", "example.com"}
+	s.NotNil(CheckAdminAccess())
+	config.Conf.AdminConf.AllowedNetworks = []string{"10.0.0.0/8", "192.0.2.1"}
+	s.Nil(CheckAdminAccess())
+
+	// Client certificates can only be required over TLS
+	config.Conf.AdminConf.ClientCAPath = ca
+	config.Conf.AdminConf.UseTLS = false
+	s.Equal(ErrClientCARequiresTLS, CheckAdminAccess())
+	config.Conf.AdminConf.UseTLS = true
+	tc, err := AdminTLSConfig()
+	s.Nil(err)
+	s.Equal(tls.RequireAndVerifyClientCert, tc.ClientAuth)
+	s.NotNil(tc.ClientCAs)
+
+	config.Conf.AdminConf.ClientCAPath = key
+	s.Equal(ErrInvalidClientCA, CheckAdminAccess())
+	config.Conf.AdminConf.ClientCAPath = ""
+	tc, err = AdminTLSConfig()
+	s.Nil(err)
+	s.Nil(tc)
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gophish/gophish/auth"
+	"github.com/gophish/gophish/config"
+	ctx "github.com/gophish/gophish/context"
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/gophish/sso"
+	"github.com/gorilla/sessions"
+)
+
+// ErrLocalLoginRequiresSSO is thrown when local logins are disabled without
+// a single sign-on provider to log in through
+var ErrLocalLoginRequiresSSO = errors.New("disable_local_login requires a single sign-on issuer")
+
+// SSOProvider is the OpenID Connect provider users can log in through. Single
+// sign-on is disabled if it's nil, which is the default.
+var SSOProvider *sso.Provider
+
+// SSOTimeout is how long users have to log in to the provider before they
+// need to start again
+var SSOTimeout = 10 * time.Minute
+
+// ConfigureSSO sets up the single sign-on provider, if one is configured,
+// returning an error if its configuration isn't valid.
+func ConfigureSSO() error {
+	conf := config.Conf.AdminConf.SSO
+	SSOProvider = nil
+	if conf.Issuer == "" {
+		if conf.DisableLocalLogin {
+			return ErrLocalLoginRequiresSSO
+		}
+		return nil
+	}
+	err := auth.ValidateSSORoles(conf)
+	if err != nil {
+		return err
+	}
+	p, err := sso.NewProvider(conf)
+	if err != nil {
+		return err
+	}
+	SSOProvider = p
+	return nil
+}
+
+// SSOLogin starts logging a user in through the single sign-on provider,
+// redirecting them to the provider to authenticate.
+func SSOLogin(w http.ResponseWriter, r *http.Request) {
+	if SSOProvider == nil {
+		http.NotFound(w, r)
+		return
+	}
+	session := ctx.Get(r, "session").(*sessions.Session)
+	state, nonce := auth.GenerateSecureKey(), auth.GenerateSecureKey()
+	u, err := SSOProvider.AuthCodeURL(state, nonce)
+	if err != nil {
+		log.Error(err)
+		Flash(w, r, "danger", "Single sign-on is unavailable")
+		session.Save(r, w)
+		http.Redirect(w, r, "/login", 302)
+		return
+	}
+	session.Values["sso_state"] = state
+	session.Values["sso_nonce"] = nonce
+	session.Values["sso_next"] = nextPath(r.FormValue("next"))
+	session.Values["sso_started"] = time.Now().Unix()
+	session.Save(r, w)
+	http.Redirect(w, r, u, 302)
+}
+
+// SSOCallback finishes logging a user in once the provider redirects them
+// back with an authorization code. The state must match the one the login
+// was started with, so that users can't be logged in to an account the
+// provider authenticated someone else as.
+func SSOCallback(w http.ResponseWriter, r *http.Request) {
+	if SSOProvider == nil {
+		http.NotFound(w, r)
+		return
+	}
+	session := ctx.Get(r, "session").(*sessions.Session)
+	state, _ := session.Values["sso_state"].(string)
+	nonce, _ := session.Values["sso_nonce"].(string)
+	next, _ := session.Values["sso_next"].(string)
+	started, _ := session.Values["sso_started"].(int64)
+	clearSSO(session)
+	fail := func(msg string) {
+		Flash(w, r, "danger", msg)
+		session.Save(r, w)
+		http.Redirect(w, r, "/login", 302)
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(r.FormValue("state"))) != 1 ||
+		time.Since(time.Unix(started, 0)) > SSOTimeout {
+		fail("Please log in again")
+		return
+	}
+	if e := r.FormValue("error"); e != "" {
+		log.Warnf("single sign-on provider returned %s: %s", e, r.FormValue("error_description"))
+		fail("Single sign-on failed")
+		return
+	}
+	id, err := SSOProvider.Exchange(r.FormValue("code"), nonce)
+	if err != nil {
+		log.Error(err)
+		fail("Single sign-on failed")
+		return
+	}
+	u, err := auth.LoginSSO(config.Conf.AdminConf.SSO, id)
+	if err != nil {
+		log.Warnf("single sign-on for %s rejected: %s", id.Username, err)
+		switch err {
+		case auth.ErrSSOUserNotFound, auth.ErrSSOSubjectMismatch, auth.ErrSSOUsernameMissing,
+			auth.ErrSSOUserExists, auth.ErrSSOAdminLink:
+			fail(err.Error())
+		default:
+			fail("Single sign-on failed")
+		}
+		return
+	}
+	// Users authenticated by the provider have already met its requirements
+	// for multi-factor authentication, so they aren't asked for a code
+	clearTwoFactor(session)
+	session.Values["id"] = u.Id
+	session.Values["sso"] = true
+	session.Save(r, w)
+	if next == "" {
+		next = "/"
+	}
+	http.Redirect(w, r, next, 302)
+}
+
+// clearSSO removes a pending single sign-on login from the session
+func clearSSO(session *sessions.Session) {
+	delete(session.Values, "sso_state")
+	delete(session.Values, "sso_nonce")
+	delete(session.Values, "sso_next")
+	delete(session.Values, "sso_started")
+}
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gophish/gophish/config"
+	"github.com/gophish/gophish/models"
+)
+
+// testIdP is an OpenID Connect provider which issues ID tokens for whoever
+// it's told is logging in
+type testIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+	nonce  string
+}
+
+func newTestIdP() *testIdP {
+	idp := &testIdP{}
+	idp.key, _ = rsa.GenerateKey(rand.Reader, 2048)
+	b64 := base64.RawURLEncoding.EncodeToString
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   b64(idp.key.N.Bytes()),
+			"e":   b64(big.NewInt(int64(idp.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		claims := map[string]interface{}{
+			"iss":            idp.URL,
+			"aud":            "gophish",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          idp.nonce,
+			"email_verified": true,
+		}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+		payload, _ := json.Marshal(claims)
+		unsigned := b64(header) + "." + b64(payload)
+		digest := sha256.Sum256([]byte(unsigned))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+		json.NewEncoder(w).Encode(map[string]string{"id_token": unsigned + "." + b64(sig)})
+	})
+	idp.Server = httptest.NewTLSServer(mux)
+	return idp
+}
+
+func (s *ControllersSuite) TestSSOLogin() {
+	idp := newTestIdP()
+	defer idp.Close()
+	defer func(conf config.AdminServer) {
+		config.Conf.AdminConf = conf
+		SSOProvider = nil
+	}(config.Conf.AdminConf)
+	config.Conf.AdminConf.SSO = config.SSO{
+		Issuer:            idp.URL,
+		ClientID:          "gophish",
+		ClientSecret:      "secret",
+		RedirectURL:       as.URL + "/login/sso/callback",
+		RoleMapping:       map[string]string{"gophish-admins": models.ROLE_ADMIN, "security": models.ROLE_OPERATOR},
+		AutoProvision:     true,
+		DisableLocalLogin: true,
+	}
+	s.Nil(ConfigureSSO())
+	SSOProvider.Client = idp.Client()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// Only the single sign-on button is shown, and passwords aren't accepted
+	resp, err := client.Get(fmt.Sprintf("%s/login", as.URL))
+	s.Nil(err)
+	doc, err := goquery.NewDocumentFromResponse(resp)
+	s.Nil(err)
+	s.Equal(0, doc.Find("input[name='password']").Length())
+	s.Equal(1, doc.Find("a[href^='/login/sso']").Length())
+	token, _ := doc.Find("input[name='csrf_token']").First().Attr("value")
+	resp, err = client.PostForm(fmt.Sprintf("%s/login", as.URL), url.Values{
+		"username": {"admin"}, "password": {"gophish"}, "csrf_token": {token},
+	})
+	s.Nil(err)
+	s.Equal(http.StatusForbidden, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	s.Contains(string(body), "Please sign in with single sign-on")
+
+	// start begins a login, returning the state the provider is given
+	start := func() string {
+		resp, err := client.Get(fmt.Sprintf("%s/login/sso?next=/campaigns", as.URL))
+		s.Nil(err)
+		s.Equal(http.StatusFound, resp.StatusCode)
+		loc, err := resp.Location()
+		s.Nil(err)
+		s.Equal(idp.URL+"/authorize", fmt.Sprintf("%s://%s%s", loc.Scheme, loc.Host, loc.Path))
+		idp.nonce = loc.Query().Get("nonce")
+		return loc.Query().Get("state")
+	}
+	callback := func(state string) string {
+		resp, err := client.Get(fmt.Sprintf("%s/login/sso/callback?%s", as.URL, url.Values{
+			"state": {state}, "code": {"code"},
+		}.Encode()))
+		s.Nil(err)
+		ioutil.ReadAll(resp.Body)
+		s.Equal(http.StatusFound, resp.StatusCode)
+		loc, err := resp.Location()
+		s.Nil(err)
+		return loc.Path
+	}
+
+	// The state must match the login that was started
+	idp.claims = map[string]interface{}{"sub": "sso-1", "preferred_username": "sso-user", "groups": []string{"security", "gophish-admins"}}
+	start()
+	s.Equal("/login", callback("forged"))
+	_, err = models.GetUserByUsername("sso-user")
+	s.NotNil(err)
+
+	// Users are provisioned with the most privileged role their groups map to
+	s.Equal("/campaigns", callback(start()))
+	u, err := models.GetUserByUsername("sso-user")
+	s.Nil(err)
+	s.Equal(models.ROLE_ADMIN, u.Role)
+	s.Equal("sso-1", u.SSOSubject)
+
+	// The provider's multi-factor authentication is trusted
+	config.Conf.AdminConf.Require2FA = true
+	resp, err = client.Get(fmt.Sprintf("%s/campaigns", as.URL))
+	s.Nil(err)
+	s.Equal(http.StatusOK, resp.StatusCode)
+
+	// Roles follow the user's groups each time they log in
+	idp.claims["groups"] = []string{"everyone"}
+	s.Equal("/campaigns", callback(start()))
+	u, err = models.GetUserByUsername("sso-user")
+	s.Nil(err)
+	s.Equal(models.ROLE_AUDITOR, u.Role)
+
+	// Existing local users aren't linked unless linking is enabled, and
+	// admins are never linked
+	local := models.User{Username: "local-operator", ApiKey: "local-operator-key", Role: models.ROLE_OPERATOR}
+	s.Nil(models.PutUser(&local))
+	idp.claims = map[string]interface{}{"sub": "sso-2", "preferred_username": "local-operator"}
+	s.Equal("/login", callback(start()))
+	idp.claims = map[string]interface{}{"sub": "sso-3", "preferred_username": "admin"}
+	s.Equal("/login", callback(start()))
+	config.Conf.AdminConf.SSO.LinkExistingUsers = true
+	s.Equal("/login", callback(start()))
+	u, err = models.GetUser(1)
+	s.Nil(err)
+	s.Equal("", u.SSOSubject)
+	s.Equal(models.ROLE_ADMIN, u.Role)
+	idp.claims = map[string]interface{}{"sub": "sso-2", "preferred_username": "local-operator"}
+	s.Equal("/campaigns", callback(start()))
+	u, err = models.GetUserByUsername("local-operator")
+	s.Nil(err)
+	s.Equal("sso-2", u.SSOSubject)
+
+	// Users can only be linked to one account
+	idp.claims = map[string]interface{}{"sub": "sso-4", "preferred_username": "sso-user"}
+	s.Equal("/login", callback(start()))
+
+	// Tokens are refused unless the provider verified the email address
+	idp.claims = map[string]interface{}{"sub": "sso-1", "preferred_username": "sso-user", "email_verified": false}
+	s.Equal("/login", callback(start()))
+
+	// Unknown users are refused unless they're provisioned automatically
+	config.Conf.AdminConf.SSO.AutoProvision = false
+	idp.claims = map[string]interface{}{"sub": "sso-5", "preferred_username": "unknown"}
+	s.Equal("/login", callback(start()))
+	_, err = models.GetUserByUsername("unknown")
+	s.NotNil(err)
+}
+
+func (s *ControllersSuite) TestConfigureSSO() {
+	defer func(conf config.AdminServer) {
+		config.Conf.AdminConf = conf
+		SSOProvider = nil
+	}(config.Conf.AdminConf)
+	config.Conf.AdminConf.SSO = config.SSO{DisableLocalLogin: true}
+	s.Equal(ErrLocalLoginRequiresSSO, ConfigureSSO())
+	config.Conf.AdminConf.SSO = config.SSO{
+		Issuer:      "https://idp.example.com",
+		ClientID:    "gophish",
+		RedirectURL: "https://gophish.example.com/login/sso/callback",
+		RoleMapping: map[string]string{"everyone": "superuser"},
+	}
+	s.Equal(models.ErrInvalidRole, ConfigureSSO())
+	config.Conf.AdminConf.SSO.RoleMapping = nil
+	s.Nil(ConfigureSSO())
+	s.NotNil(SSOProvider)
+}
@@ -0,0 +1,201 @@
+// Package cron parses cron expressions, which describe when recurring
+// campaigns are launched.
+//
+// Expressions have the five standard fields: minute, hour, day of the month,
+// month and day of the week. Each field is either "*", a value, a range such
+// as "1-5", or a comma separated list of these, optionally followed by a step
+// such as "*/15". Months and days of the week can also be given by their
+// three letter names. The descriptors "@hourly", "@daily", "@weekly",
+// "@monthly" and "@yearly" are accepted as shorthand.
+package cron
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidExpression is returned when a cron expression can't be parsed
+var ErrInvalidExpression = errors.New("Invalid cron expression")
+
+// descriptors are the shorthand expressions which can be used in place of the
+// five fields
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field describes the values allowed in one of an expression's fields
+type field struct {
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	minutes = field{min: 0, max: 59}
+	hours   = field{min: 0, max: 23}
+	days    = field{min: 1, max: 31}
+	months  = field{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday can be given as either 0 or 7
+	weekdays = field{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// Schedule is a parsed cron expression. Each field is a bitset of the values
+// it matches.
+type Schedule struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	anyDom  bool
+	anyDow  bool
+	literal string
+}
+
+// Parse parses the given cron expression.
+func Parse(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+	fs := strings.Fields(spec)
+	if len(fs) != 5 {
+		return nil, ErrInvalidExpression
+	}
+	s := &Schedule{literal: spec}
+	var err error
+	for i, dst := range []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow} {
+		*dst, err = parseField(fs[i], []field{minutes, hours, days, months, weekdays}[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Sunday may have been given as 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.anyDom = fs[2] == "*"
+	s.anyDow = fs[4] == "*"
+	return s, nil
+}
+
+// String returns the expression the schedule was parsed from
+func (s *Schedule) String() string {
+	return s.literal
+}
+
+// parseValue parses a single value of the field, which may be a name
+func parseValue(v string, f field) (int, error) {
+	if n, ok := f.names[strings.ToLower(v)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < f.min || n > f.max {
+		return 0, ErrInvalidExpression
+	}
+	return n, nil
+}
+
+// parseField returns the bitset of values matched by the field
+func parseField(s string, f field) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, ErrInvalidExpression
+			}
+			part = part[:i]
+		}
+		start, end := f.min, f.max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			start, err = parseValue(bounds[0], f)
+			if err != nil {
+				return 0, err
+			}
+			end, err = parseValue(bounds[1], f)
+			if err != nil {
+				return 0, err
+			}
+			if end < start {
+				return 0, ErrInvalidExpression
+			}
+		default:
+			var err error
+			start, err = parseValue(part, f)
+			if err != nil {
+				return 0, err
+			}
+			// A single value with a step, such as "5/15", runs from the
+			// value to the end of the range
+			end = start
+			if step > 1 {
+				end = f.max
+			}
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// matchesDay returns whether the schedule runs on the day of the given time.
+// As with cron, when both the day of the month and the day of the week are
+// restricted, a day matching either runs.
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time the schedule runs after the given time, in the
+// time's location. The zero time is returned if the schedule never runs,
+// such as on the 31st of February.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	// Give up on schedules which can't be satisfied
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
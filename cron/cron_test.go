@@ -0,0 +1,73 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CronSuite struct {
+	suite.Suite
+}
+
+func (s *CronSuite) TestParseInvalid() {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@fortnightly",
+	} {
+		_, err := Parse(spec)
+		s.Equal(ErrInvalidExpression, err, spec)
+	}
+}
+
+func (s *CronSuite) TestNext() {
+	// Thursday, July 19th 2018
+	now := time.Date(2018, 7, 19, 10, 30, 15, 0, time.UTC)
+	for _, tc := range []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2018, 7, 19, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2018, 7, 19, 10, 45, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2018, 7, 19, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2018, 7, 20, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2018, 7, 22, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2018, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2018, 7, 20, 9, 0, 0, 0, time.UTC)},
+		{"30 14 1,15 * *", time.Date(2018, 8, 1, 14, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2018, 7, 22, 0, 0, 0, 0, time.UTC)},
+		{"0 9 1 jan,jul *", time.Date(2019, 1, 1, 9, 0, 0, 0, time.UTC)},
+		// The day of the month or the day of the week can match
+		{"0 0 1 * fri", time.Date(2018, 7, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	} {
+		sched, err := Parse(tc.spec)
+		s.Nil(err, tc.spec)
+		s.Equal(tc.expected, sched.Next(now), tc.spec)
+	}
+}
+
+func (s *CronSuite) TestNextLocation() {
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	sched, err := Parse("0 9 * * *")
+	s.Nil(err)
+	next := sched.Next(time.Date(2018, 7, 19, 10, 0, 0, 0, loc))
+	s.Equal(time.Date(2018, 7, 20, 9, 0, 0, 0, loc), next)
+	s.Equal("0 9 * * *", sched.String())
+}
+
+func TestCronSuite(t *testing.T) {
+	suite.Run(t, new(CronSuite))
+}
@@ -0,0 +1,8 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE results ADD COLUMN client_tz_offset INTEGER;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
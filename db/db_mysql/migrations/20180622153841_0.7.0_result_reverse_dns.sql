@@ -0,0 +1,7 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE results ADD COLUMN reverse_dns VARCHAR(255);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
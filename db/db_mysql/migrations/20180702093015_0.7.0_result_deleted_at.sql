@@ -0,0 +1,7 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE results ADD COLUMN deleted_at DATETIME;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,19 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS sms (
+    id integer primary key auto_increment,
+    user_id bigint,
+    name varchar(255),
+    gateway_url varchar(255),
+    username varchar(255),
+    password varchar(255),
+    from_number varchar(255),
+    modified_date datetime);
+ALTER TABLE campaigns ADD COLUMN sms_id BIGINT;
+ALTER TABLE targets ADD COLUMN phone VARCHAR(255);
+ALTER TABLE results ADD COLUMN phone VARCHAR(255);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE sms;
@@ -0,0 +1,9 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE pages ADD COLUMN capture_mode VARCHAR(255);
+ALTER TABLE pages ADD COLUMN capture_password_length INTEGER DEFAULT 0;
+ALTER TABLE pages ADD COLUMN capture_drop_fields VARCHAR(255);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
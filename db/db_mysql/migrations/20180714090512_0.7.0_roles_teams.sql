@@ -0,0 +1,10 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE users ADD COLUMN role varchar(255) NOT NULL DEFAULT 'admin';
+ALTER TABLE users ADD COLUMN team_id bigint NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS teams (id integer primary key auto_increment,name varchar(255) NOT NULL);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE teams;
@@ -0,0 +1,11 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS notifications (id integer primary key auto_increment,user_id bigint,campaign_id bigint,name varchar(255),type varchar(255),url varchar(255),email varchar(255),milestones text,click_rate integer,modified_date datetime);
+CREATE TABLE IF NOT EXISTS notification_deliveries (id integer primary key auto_increment,notification_id bigint,campaign_id bigint,milestone varchar(255),sent_date datetime);
+CREATE UNIQUE INDEX notification_deliveries_milestone ON notification_deliveries (notification_id,campaign_id,milestone);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE notification_deliveries;
+DROP TABLE notifications;
@@ -0,0 +1,9 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS campaign_schedules (id integer primary key auto_increment,user_id bigint,name varchar(255),template_id bigint,page_id bigint,smtp_id bigint,group_id bigint,url varchar(255),recurrence varchar(255),sample_percent integer,is_active boolean,next_run_date datetime,last_run_date datetime,modified_date datetime);
+ALTER TABLE campaigns ADD COLUMN schedule_id bigint DEFAULT 0;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE campaign_schedules;
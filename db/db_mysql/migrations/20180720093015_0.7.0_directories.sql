@@ -0,0 +1,10 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS directories (id integer primary key auto_increment,user_id bigint,group_id bigint,name varchar(255),url varchar(255),start_tls boolean,ignore_cert_errors boolean,bind_dn varchar(255),bind_password varchar(255),base_dn varchar(255),filter text,first_name_attribute varchar(255),last_name_attribute varchar(255),email_attribute varchar(255),position_attribute varchar(255),phone_attribute varchar(255),custom_attributes text,recurrence varchar(255),next_sync_date datetime,last_sync_date datetime,modified_date datetime);
+CREATE TABLE IF NOT EXISTS directory_sync_reports (id integer primary key auto_increment,directory_id bigint,group_id bigint,num_targets integer,added longtext,removed longtext,updated longtext,error text,sync_date datetime);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE directory_sync_reports;
+DROP TABLE directories;
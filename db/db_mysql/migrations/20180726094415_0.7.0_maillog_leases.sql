@@ -0,0 +1,8 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE mail_logs ADD COLUMN locked_by varchar(255);
+ALTER TABLE mail_logs ADD COLUMN lease_expires datetime;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
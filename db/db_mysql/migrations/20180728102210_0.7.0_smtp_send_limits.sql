@@ -0,0 +1,8 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE smtp ADD COLUMN max_messages_per_minute integer DEFAULT 0;
+ALTER TABLE smtp ADD COLUMN max_messages_per_hour integer DEFAULT 0;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
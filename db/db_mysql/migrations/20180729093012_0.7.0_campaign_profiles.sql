@@ -0,0 +1,17 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS campaign_profiles (
+    id integer primary key auto_increment,
+    campaign_id bigint,
+    smtp_id bigint,
+    weight integer);
+ALTER TABLE campaigns ADD COLUMN profile_rotation varchar(255);
+ALTER TABLE results ADD COLUMN smtp_id bigint;
+ALTER TABLE mail_logs ADD COLUMN smtp_id bigint;
+UPDATE results SET smtp_id = (SELECT smtp_id FROM campaigns WHERE campaigns.id = results.campaign_id);
+UPDATE mail_logs SET smtp_id = (SELECT smtp_id FROM campaigns WHERE campaigns.id = mail_logs.campaign_id);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE campaign_profiles;
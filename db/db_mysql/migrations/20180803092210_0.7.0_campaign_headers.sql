@@ -0,0 +1,8 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS campaign_headers (id integer primary key auto_increment,campaign_id bigint,`key` varchar(255),`value` varchar(255));
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE campaign_headers;
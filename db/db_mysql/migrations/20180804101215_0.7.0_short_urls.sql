@@ -0,0 +1,11 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE campaigns ADD COLUMN shorten_urls BOOLEAN DEFAULT 0;
+CREATE TABLE IF NOT EXISTS short_urls (id integer primary key auto_increment,campaign_id bigint,r_id varchar(255),code varchar(255),url varchar(255),target text,created_date datetime);
+CREATE INDEX short_urls_code ON short_urls (code);
+CREATE INDEX short_urls_result ON short_urls (r_id);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE short_urls;
@@ -0,0 +1,393 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+-- PostgreSQL databases start from the schema of this release, so the earlier
+-- migrations don't exist for PostgreSQL
+CREATE TABLE IF NOT EXISTS users (
+    id serial primary key,
+    username varchar(255) NOT NULL UNIQUE,
+    hash varchar(255),
+    api_key varchar(255) NOT NULL UNIQUE,
+    role varchar(255) NOT NULL DEFAULT 'admin',
+    team_id bigint NOT NULL DEFAULT 0,
+    totp_enabled boolean DEFAULT false,
+    totp_secret varchar(255),
+    totp_recovery_codes text,
+    totp_last_counter bigint DEFAULT 0);
+CREATE TABLE IF NOT EXISTS templates (
+    id serial primary key,
+    user_id bigint,
+    name varchar(255),
+    subject varchar(255),
+    text text,
+    html text,
+    modified_date timestamp with time zone);
+CREATE TABLE IF NOT EXISTS targets (
+    id serial primary key,
+    first_name varchar(255),
+    last_name varchar(255),
+    email varchar(255),
+    position varchar(255),
+    phone varchar(255),
+    locale varchar(255));
+CREATE TABLE IF NOT EXISTS results (
+    id serial primary key,
+    campaign_id bigint,
+    user_id bigint,
+    r_id varchar(255),
+    email varchar(255),
+    first_name varchar(255),
+    last_name varchar(255),
+    status varchar(255) NOT NULL,
+    ip varchar(255),
+    latitude real,
+    longitude real,
+    position varchar(255),
+    send_date timestamp with time zone,
+    reported boolean DEFAULT false,
+    modified_date timestamp with time zone,
+    accept_language varchar(255),
+    message_id varchar(255),
+    delivered boolean DEFAULT false,
+    subject varchar(255),
+    send_position integer DEFAULT 0,
+    client_tz_offset integer,
+    excluded_from_report boolean DEFAULT false,
+    tracking_domain varchar(255),
+    provider_type varchar(255),
+    country varchar(255),
+    on_hold boolean DEFAULT false,
+    link_expires_at timestamp with time zone,
+    reverse_dns varchar(255),
+    inbox_placement varchar(255),
+    attributes text,
+    password_breached boolean DEFAULT false,
+    country_name varchar(255),
+    city varchar(255),
+    retry_attempts integer DEFAULT 0,
+    deleted_at timestamp with time zone,
+    phone varchar(255),
+    variant_id bigint,
+    training_completed boolean DEFAULT false,
+    steps_submitted integer DEFAULT 0,
+    smtp_id bigint,
+    locale varchar(255));
+CREATE TABLE IF NOT EXISTS pages (
+    id serial primary key,
+    user_id bigint,
+    name varchar(255),
+    html text,
+    modified_date timestamp with time zone,
+    capture_credentials boolean,
+    capture_passwords boolean,
+    redirect_url varchar(255),
+    capture_mode varchar(255),
+    capture_password_length integer DEFAULT 0,
+    capture_drop_fields varchar(255),
+    proxy_url varchar(255),
+    proxy_capture_rules text);
+CREATE TABLE IF NOT EXISTS groups (
+    id serial primary key,
+    user_id bigint,
+    name varchar(255),
+    modified_date timestamp with time zone);
+CREATE TABLE IF NOT EXISTS group_targets (
+    group_id bigint,
+    target_id bigint);
+CREATE TABLE IF NOT EXISTS events (
+    id serial primary key,
+    campaign_id bigint,
+    email varchar(255),
+    time timestamp with time zone,
+    message varchar(255),
+    details text);
+CREATE TABLE IF NOT EXISTS campaigns (
+    id serial primary key,
+    user_id bigint,
+    name varchar(255) NOT NULL,
+    created_date timestamp with time zone,
+    completed_date timestamp with time zone,
+    template_id bigint,
+    page_id bigint,
+    status varchar(255),
+    url varchar(255),
+    smtp_id bigint,
+    launch_date timestamp with time zone,
+    send_window_start varchar(255),
+    send_window_end varchar(255),
+    send_window_days varchar(255),
+    send_window_timezone varchar(255),
+    sms_id bigint,
+    paused_date timestamp with time zone,
+    disable_bot_filter boolean DEFAULT false,
+    schedule_id bigint DEFAULT 0,
+    training_url varchar(255),
+    training_key varchar(255),
+    profile_rotation varchar(255));
+CREATE TABLE IF NOT EXISTS attachments (
+    id serial primary key,
+    template_id bigint,
+    content text,
+    type varchar(255),
+    name varchar(255));
+CREATE TABLE IF NOT EXISTS smtp (
+    id serial primary key,
+    user_id bigint,
+    interface_type varchar(255),
+    name varchar(255),
+    host varchar(255),
+    username varchar(255),
+    password varchar(255),
+    from_address varchar(255),
+    modified_date timestamp with time zone,
+    ignore_cert_errors boolean,
+    dkim_domain varchar(255),
+    dkim_selector varchar(255),
+    dkim_private_key text,
+    tenant varchar(255),
+    region varchar(255),
+    max_messages_per_connection integer DEFAULT 0,
+    connection_idle_timeout integer DEFAULT 0,
+    max_messages_per_minute integer DEFAULT 0,
+    max_messages_per_hour integer DEFAULT 0);
+CREATE TABLE IF NOT EXISTS headers (
+    id serial primary key,
+    key varchar(255),
+    value varchar(255),
+    smtp_id bigint);
+CREATE TABLE IF NOT EXISTS mail_logs (
+    id serial primary key,
+    campaign_id integer,
+    user_id integer,
+    send_date timestamp with time zone,
+    send_attempt integer,
+    r_id varchar(255),
+    processing boolean,
+    locked_by varchar(255),
+    lease_expires timestamp with time zone,
+    smtp_id bigint);
+CREATE TABLE IF NOT EXISTS send_attempts (
+    id serial primary key,
+    campaign_id integer,
+    r_id varchar(255),
+    time timestamp with time zone,
+    profile varchar(255),
+    success boolean,
+    code integer,
+    category varchar(255),
+    error text);
+CREATE TABLE IF NOT EXISTS snapshots (
+    id serial primary key,
+    campaign_id integer,
+    user_id integer,
+    time timestamp with time zone,
+    created_date timestamp with time zone,
+    data text);
+CREATE TABLE IF NOT EXISTS target_attributes (
+    id serial primary key,
+    target_id integer,
+    name varchar(255),
+    value varchar(255));
+CREATE TABLE IF NOT EXISTS sms (
+    id serial primary key,
+    user_id bigint,
+    name varchar(255),
+    gateway_url varchar(255),
+    username varchar(255),
+    password varchar(255),
+    from_number varchar(255),
+    modified_date timestamp with time zone);
+CREATE TABLE IF NOT EXISTS campaign_variants (
+    id serial primary key,
+    campaign_id bigint,
+    name varchar(255),
+    template_id bigint,
+    page_id bigint,
+    weight integer);
+CREATE TABLE IF NOT EXISTS api_keys (
+    id serial primary key,
+    user_id bigint,
+    name varchar(255),
+    key_hash varchar(255) NOT NULL UNIQUE,
+    key_prefix varchar(255),
+    scopes text,
+    created_date timestamp with time zone,
+    expires_at timestamp with time zone,
+    revoked_date timestamp with time zone);
+CREATE TABLE IF NOT EXISTS teams (
+    id serial primary key,
+    name varchar(255) NOT NULL);
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id serial primary key,
+    user_id bigint,
+    username varchar(255),
+    action varchar(255),
+    resource varchar(255),
+    resource_id bigint,
+    method varchar(255),
+    path varchar(255),
+    status integer,
+    ip varchar(255),
+    before_state text,
+    after_state text,
+    diff text,
+    time timestamp with time zone);
+CREATE TABLE IF NOT EXISTS webhooks (
+    id serial primary key,
+    user_id bigint,
+    name varchar(255),
+    url varchar(255),
+    secret varchar(255),
+    is_active boolean,
+    campaign_ids text,
+    event_types text,
+    modified_date timestamp with time zone);
+CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+    id serial primary key,
+    user_id bigint,
+    webhook_id bigint,
+    url varchar(255),
+    payload text,
+    attempts integer,
+    last_error text,
+    created_date timestamp with time zone);
+CREATE TABLE IF NOT EXISTS notifications (
+    id serial primary key,
+    user_id bigint,
+    campaign_id bigint,
+    name varchar(255),
+    type varchar(255),
+    url varchar(255),
+    email varchar(255),
+    milestones text,
+    click_rate integer,
+    modified_date timestamp with time zone);
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+    id serial primary key,
+    notification_id bigint,
+    campaign_id bigint,
+    milestone varchar(255),
+    sent_date timestamp with time zone);
+CREATE TABLE IF NOT EXISTS campaign_schedules (
+    id serial primary key,
+    user_id bigint,
+    name varchar(255),
+    template_id bigint,
+    page_id bigint,
+    smtp_id bigint,
+    group_id bigint,
+    url varchar(255),
+    recurrence varchar(255),
+    sample_percent integer,
+    is_active boolean,
+    next_run_date timestamp with time zone,
+    last_run_date timestamp with time zone,
+    modified_date timestamp with time zone);
+CREATE TABLE IF NOT EXISTS directories (
+    id serial primary key,
+    user_id bigint,
+    group_id bigint,
+    name varchar(255),
+    url varchar(255),
+    start_tls boolean,
+    ignore_cert_errors boolean,
+    bind_dn varchar(255),
+    bind_password varchar(255),
+    base_dn varchar(255),
+    filter text,
+    first_name_attribute varchar(255),
+    last_name_attribute varchar(255),
+    email_attribute varchar(255),
+    position_attribute varchar(255),
+    phone_attribute varchar(255),
+    custom_attributes text,
+    recurrence varchar(255),
+    next_sync_date timestamp with time zone,
+    last_sync_date timestamp with time zone,
+    modified_date timestamp with time zone,
+    provider varchar(255) DEFAULT 'ldap',
+    tenant varchar(255),
+    client_id varchar(255),
+    client_secret text,
+    admin_email varchar(255),
+    source_group varchar(255),
+    org_unit varchar(255),
+    delta_link text);
+CREATE TABLE IF NOT EXISTS directory_sync_reports (
+    id serial primary key,
+    directory_id bigint,
+    group_id bigint,
+    num_targets integer,
+    added text,
+    removed text,
+    updated text,
+    error text,
+    sync_date timestamp with time zone);
+CREATE TABLE IF NOT EXISTS directory_members (
+    id serial primary key,
+    directory_id bigint,
+    external_id varchar(255),
+    email varchar(255));
+CREATE TABLE IF NOT EXISTS page_assets (
+    id serial primary key,
+    page_id bigint,
+    name varchar(255),
+    type varchar(255),
+    content text,
+    modified_date timestamp with time zone);
+CREATE TABLE IF NOT EXISTS campaign_steps (
+    id serial primary key,
+    campaign_id bigint,
+    position integer,
+    page_id bigint,
+    mfa boolean);
+CREATE TABLE IF NOT EXISTS campaign_profiles (
+    id serial primary key,
+    campaign_id bigint,
+    smtp_id bigint,
+    weight integer);
+CREATE TABLE IF NOT EXISTS template_localizations (
+    id serial primary key,
+    template_id bigint,
+    locale varchar(255),
+    subject varchar(255),
+    text text,
+    html text);
+CREATE INDEX audit_logs_time ON audit_logs (time);
+CREATE UNIQUE INDEX notification_deliveries_milestone ON notification_deliveries (notification_id,campaign_id,milestone);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE template_localizations;
+DROP TABLE campaign_profiles;
+DROP TABLE campaign_steps;
+DROP TABLE page_assets;
+DROP TABLE directory_members;
+DROP TABLE directory_sync_reports;
+DROP TABLE directories;
+DROP TABLE campaign_schedules;
+DROP TABLE notification_deliveries;
+DROP TABLE notifications;
+DROP TABLE webhook_dead_letters;
+DROP TABLE webhooks;
+DROP TABLE audit_logs;
+DROP TABLE teams;
+DROP TABLE api_keys;
+DROP TABLE campaign_variants;
+DROP TABLE sms;
+DROP TABLE target_attributes;
+DROP TABLE snapshots;
+DROP TABLE send_attempts;
+DROP TABLE mail_logs;
+DROP TABLE headers;
+DROP TABLE smtp;
+DROP TABLE attachments;
+DROP TABLE campaigns;
+DROP TABLE events;
+DROP TABLE group_targets;
+DROP TABLE groups;
+DROP TABLE pages;
+DROP TABLE results;
+DROP TABLE targets;
+DROP TABLE templates;
+DROP TABLE users;
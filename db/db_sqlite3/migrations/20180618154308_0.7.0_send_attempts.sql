@@ -0,0 +1,17 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "send_attempts" (
+    "id" integer primary key autoincrement,
+    "campaign_id" integer,
+    "r_id" varchar(255),
+    "time" datetime,
+    "profile" varchar(255),
+    "success" boolean,
+    "code" integer,
+    "category" varchar(255),
+    "error" text);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "send_attempts";
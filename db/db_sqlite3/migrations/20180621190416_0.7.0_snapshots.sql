@@ -0,0 +1,14 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "snapshots" (
+    "id" integer primary key autoincrement,
+    "campaign_id" integer,
+    "user_id" integer,
+    "time" datetime,
+    "created_date" datetime,
+    "data" text);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "snapshots";
@@ -0,0 +1,13 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "target_attributes" (
+    "id" integer primary key autoincrement,
+    "target_id" integer,
+    "name" varchar(255),
+    "value" varchar(255));
+ALTER TABLE results ADD COLUMN attributes text;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "target_attributes";
@@ -0,0 +1,10 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE campaigns ADD COLUMN send_window_start VARCHAR(255);
+ALTER TABLE campaigns ADD COLUMN send_window_end VARCHAR(255);
+ALTER TABLE campaigns ADD COLUMN send_window_days VARCHAR(255);
+ALTER TABLE campaigns ADD COLUMN send_window_timezone VARCHAR(255);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,15 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "campaign_variants" (
+    "id" integer primary key autoincrement,
+    "campaign_id" bigint,
+    "name" varchar(255),
+    "template_id" bigint,
+    "page_id" bigint,
+    "weight" integer);
+ALTER TABLE results ADD COLUMN variant_id BIGINT;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "campaign_variants";
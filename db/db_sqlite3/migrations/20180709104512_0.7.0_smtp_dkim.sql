@@ -0,0 +1,9 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE smtp ADD COLUMN dkim_domain VARCHAR(255);
+ALTER TABLE smtp ADD COLUMN dkim_selector VARCHAR(255);
+ALTER TABLE smtp ADD COLUMN dkim_private_key TEXT;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
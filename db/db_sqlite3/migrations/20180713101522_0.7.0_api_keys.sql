@@ -0,0 +1,8 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "api_keys" ("id" integer primary key autoincrement,"user_id" bigint,"name" varchar(255),"key_hash" varchar(255) NOT NULL UNIQUE,"key_prefix" varchar(255),"scopes" text,"created_date" datetime,"expires_at" datetime,"revoked_date" datetime);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "api_keys";
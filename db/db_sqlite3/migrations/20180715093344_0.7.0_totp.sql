@@ -0,0 +1,10 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN DEFAULT 0;
+ALTER TABLE users ADD COLUMN totp_secret varchar(255);
+ALTER TABLE users ADD COLUMN totp_recovery_codes text;
+ALTER TABLE users ADD COLUMN totp_last_counter bigint DEFAULT 0;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,9 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "audit_logs" ("id" integer primary key autoincrement,"user_id" bigint,"username" varchar(255),"action" varchar(255),"resource" varchar(255),"resource_id" bigint,"method" varchar(255),"path" varchar(255),"status" integer,"ip" varchar(255),"before_state" text,"after_state" text,"diff" text,"time" datetime);
+CREATE INDEX IF NOT EXISTS "audit_logs_time" ON "audit_logs" ("time");
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "audit_logs";
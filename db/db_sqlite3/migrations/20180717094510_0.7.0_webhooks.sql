@@ -0,0 +1,10 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "webhooks" ("id" integer primary key autoincrement,"user_id" bigint,"name" varchar(255),"url" varchar(255),"secret" varchar(255),"is_active" boolean,"campaign_ids" text,"event_types" text,"modified_date" datetime);
+CREATE TABLE IF NOT EXISTS "webhook_dead_letters" ("id" integer primary key autoincrement,"user_id" bigint,"webhook_id" bigint,"url" varchar(255),"payload" text,"attempts" integer,"last_error" text,"created_date" datetime);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "webhook_dead_letters";
+DROP TABLE "webhooks";
@@ -0,0 +1,16 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE directories ADD COLUMN provider varchar(255) DEFAULT 'ldap';
+ALTER TABLE directories ADD COLUMN tenant varchar(255);
+ALTER TABLE directories ADD COLUMN client_id varchar(255);
+ALTER TABLE directories ADD COLUMN client_secret text;
+ALTER TABLE directories ADD COLUMN admin_email varchar(255);
+ALTER TABLE directories ADD COLUMN source_group varchar(255);
+ALTER TABLE directories ADD COLUMN org_unit varchar(255);
+ALTER TABLE directories ADD COLUMN delta_link text;
+CREATE TABLE IF NOT EXISTS "directory_members" ("id" integer primary key autoincrement,"directory_id" bigint,"external_id" varchar(255),"email" varchar(255));
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "directory_members";
@@ -0,0 +1,9 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE campaigns ADD COLUMN training_url VARCHAR(255);
+ALTER TABLE campaigns ADD COLUMN training_key VARCHAR(255);
+ALTER TABLE results ADD COLUMN training_completed BOOLEAN DEFAULT 0;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,8 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "page_assets" ("id" integer primary key autoincrement,"page_id" bigint,"name" varchar(255),"type" varchar(255),"content" text,"modified_date" datetime);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "page_assets";
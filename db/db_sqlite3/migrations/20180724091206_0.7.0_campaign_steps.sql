@@ -0,0 +1,9 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "campaign_steps" ("id" integer primary key autoincrement,"campaign_id" bigint,"position" integer,"page_id" bigint,"mfa" boolean);
+ALTER TABLE results ADD COLUMN steps_submitted integer DEFAULT 0;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "campaign_steps";
@@ -0,0 +1,8 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE pages ADD COLUMN proxy_url varchar(255);
+ALTER TABLE pages ADD COLUMN proxy_capture_rules text;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
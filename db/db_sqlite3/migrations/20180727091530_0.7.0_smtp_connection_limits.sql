@@ -0,0 +1,8 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE smtp ADD COLUMN max_messages_per_connection integer DEFAULT 0;
+ALTER TABLE smtp ADD COLUMN connection_idle_timeout integer DEFAULT 0;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,16 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "template_localizations" (
+    "id" integer primary key autoincrement,
+    "template_id" bigint,
+    "locale" varchar(255),
+    "subject" varchar(255),
+    "text" text,
+    "html" text);
+ALTER TABLE targets ADD COLUMN locale varchar(255);
+ALTER TABLE results ADD COLUMN locale varchar(255);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "template_localizations";
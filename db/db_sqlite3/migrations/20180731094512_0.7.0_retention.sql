@@ -0,0 +1,9 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE campaigns ADD COLUMN retention_days integer;
+ALTER TABLE campaigns ADD COLUMN retention_action varchar(255);
+ALTER TABLE campaigns ADD COLUMN retention_date DATETIME;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
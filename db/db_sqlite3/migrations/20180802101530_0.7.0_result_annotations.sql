@@ -0,0 +1,12 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "result_tags" ("id" integer primary key autoincrement,"campaign_id" bigint,"r_id" varchar(255),"user_id" bigint,"tag" varchar(255),"created_date" datetime);
+CREATE INDEX IF NOT EXISTS "result_tags_result" ON "result_tags" ("campaign_id","r_id");
+CREATE TABLE IF NOT EXISTS "result_notes" ("id" integer primary key autoincrement,"campaign_id" bigint,"r_id" varchar(255),"user_id" bigint,"text" text,"created_date" datetime,"modified_date" datetime);
+CREATE INDEX IF NOT EXISTS "result_notes_result" ON "result_notes" ("campaign_id","r_id");
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "result_notes";
+DROP TABLE "result_tags";
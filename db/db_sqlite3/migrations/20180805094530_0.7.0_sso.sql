@@ -0,0 +1,9 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE users ADD COLUMN sso_subject varchar(255);
+CREATE INDEX IF NOT EXISTS "users_sso_subject" ON "users" ("sso_subject");
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP INDEX IF EXISTS "users_sso_subject";
@@ -0,0 +1,8 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+-- Deliveries to the global webhook have no owner, so that only admins can see them
+UPDATE webhook_dead_letters SET user_id = 0 WHERE webhook_id = 0;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
@@ -0,0 +1,8 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE campaigns ADD COLUMN send_positions INTEGER DEFAULT 0;
+UPDATE campaigns SET send_positions = (SELECT COALESCE(MAX(send_position), 0) FROM results WHERE results.campaign_id = campaigns.id);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
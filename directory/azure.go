@@ -0,0 +1,240 @@
+package directory
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AzureTokenURL is the Microsoft identity platform endpoint used to request
+// access tokens, formatted with the tenant
+var AzureTokenURL = "https://login.microsoftonline.com/%s/oauth2/v2.0/token"
+
+// AzureGraphEndpoint is the base URL of the Microsoft Graph API
+var AzureGraphEndpoint = "https://graph.microsoft.com/v1.0"
+
+// ErrDeltaExpired is returned when a delta link is too old to be used, so
+// every user has to be synced again
+var ErrDeltaExpired = errors.New("The directory's delta link has expired")
+
+// azureUserFields are the user properties requested from Microsoft Graph
+const azureUserFields = "id,givenName,surname,mail,jobTitle,businessPhones,mobilePhone,department,officeLocation,companyName,employeeId,accountEnabled"
+
+// AzureClient lists users in Azure AD through Microsoft Graph, using an
+// application registered in the tenant with the User.Read.All permission,
+// and GroupMember.Read.All if a group is synced. Access tokens are requested
+// with the client credentials grant.
+type AzureClient struct {
+	Tenant       string
+	ClientID     string
+	ClientSecret string
+	// Group is the object id of the group whose members, including the
+	// members of nested groups, are synced. Every user is synced if it's
+	// empty.
+	Group  string
+	Client *http.Client
+
+	token   string
+	expires time.Time
+}
+
+// NewAzureClient returns a client for the given tenant and application
+func NewAzureClient(tenant, clientID, clientSecret, group string) *AzureClient {
+	return &AzureClient{
+		Tenant:       tenant,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Group:        group,
+		Client:       &http.Client{Timeout: APITimeout},
+	}
+}
+
+// azureUser is a user returned by Microsoft Graph
+type azureUser struct {
+	Id             string   `json:"id"`
+	GivenName      string   `json:"givenName"`
+	Surname        string   `json:"surname"`
+	Mail           string   `json:"mail"`
+	JobTitle       string   `json:"jobTitle"`
+	BusinessPhones []string `json:"businessPhones"`
+	MobilePhone    string   `json:"mobilePhone"`
+	Department     string   `json:"department"`
+	OfficeLocation string   `json:"officeLocation"`
+	CompanyName    string   `json:"companyName"`
+	EmployeeId     string   `json:"employeeId"`
+	AccountEnabled *bool    `json:"accountEnabled"`
+	// Removed is set in delta responses for deleted users
+	Removed *azureRemoved `json:"@removed"`
+}
+
+// azureRemoved describes why a user was removed from a delta response
+type azureRemoved struct {
+	Reason string `json:"reason"`
+}
+
+// azurePage is a page of users returned by Microsoft Graph
+type azurePage struct {
+	Value     []azureUser `json:"value"`
+	NextLink  string      `json:"@odata.nextLink"`
+	DeltaLink string      `json:"@odata.deltaLink"`
+}
+
+// azureToken is the response to an access token request
+type azureToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// removed returns whether the user has been deleted or disabled
+func (u azureUser) removed() bool {
+	return u.Removed != nil || (u.AccountEnabled != nil && !*u.AccountEnabled)
+}
+
+// user converts the Graph user into a User
+func (u azureUser) user() User {
+	usr := User{
+		Id:         u.Id,
+		FirstName:  u.GivenName,
+		LastName:   u.Surname,
+		Email:      u.Mail,
+		Position:   u.JobTitle,
+		Phone:      u.MobilePhone,
+		Attributes: map[string]string{},
+	}
+	if len(u.BusinessPhones) > 0 {
+		usr.Phone = u.BusinessPhones[0]
+	}
+	for name, value := range map[string]string{
+		"department":     u.Department,
+		"officeLocation": u.OfficeLocation,
+		"companyName":    u.CompanyName,
+		"employeeId":     u.EmployeeId,
+	} {
+		if value != "" {
+			usr.Attributes[name] = value
+		}
+	}
+	return usr
+}
+
+// accessToken returns a valid access token, requesting a new one if needed
+func (c *AzureClient) accessToken() (string, error) {
+	if c.token != "" && time.Now().Add(time.Minute).Before(c.expires) {
+		return c.token, nil
+	}
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", c.ClientID)
+	form.Set("client_secret", c.ClientSecret)
+	form.Set("scope", "https://graph.microsoft.com/.default")
+	req, err := http.NewRequest("POST", fmt.Sprintf(AzureTokenURL, url.PathEscape(c.Tenant)), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	t := azureToken{}
+	err = doJSONRequest(c.Client, "Microsoft identity platform", req, &t)
+	if err != nil {
+		return "", err
+	}
+	c.token = t.AccessToken
+	c.expires = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+	return c.token, nil
+}
+
+// get requests the Graph URL, decoding the response into v
+func (c *AzureClient) get(u string, v interface{}) error {
+	token, err := c.accessToken()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	// Casting transitive members to users is an advanced query
+	req.Header.Set("ConsistencyLevel", "eventual")
+	return doJSONRequest(c.Client, "Microsoft Graph", req, v)
+}
+
+// Users returns the enabled members of the client's group, or every enabled
+// user if it doesn't have one.
+func (c *AzureClient) Users() ([]User, error) {
+	u := fmt.Sprintf("%s/users?$select=%s", AzureGraphEndpoint, azureUserFields)
+	if c.Group != "" {
+		u = fmt.Sprintf("%s/groups/%s/transitiveMembers/microsoft.graph.user?$count=true&$select=%s",
+			AzureGraphEndpoint, url.PathEscape(c.Group), azureUserFields)
+	}
+	users := []User{}
+	for u != "" {
+		p := azurePage{}
+		err := c.get(u, &p)
+		if err != nil {
+			return users, err
+		}
+		for _, au := range p.Value {
+			if !au.removed() {
+				users = append(users, au.user())
+			}
+		}
+		u = p.NextLink
+	}
+	return users, nil
+}
+
+// Delta returns the users who have changed since the delta link was
+// returned, using a delta query over every user in the tenant. Users who
+// have been deleted or disabled are returned with Removed set. Since delta
+// responses only include the properties which changed, the full record of
+// each changed user is requested. A delta query can't be limited to a
+// group's transitive members, so the client's group is ignored.
+func (c *AzureClient) Delta(link string) ([]User, string, error) {
+	full := link == ""
+	u := link
+	if full {
+		u = fmt.Sprintf("%s/users/delta?$select=%s", AzureGraphEndpoint, azureUserFields)
+	}
+	users := []User{}
+	for {
+		p := azurePage{}
+		err := c.get(u, &p)
+		if ae, ok := err.(*APIError); ok && ae.StatusCode == http.StatusGone {
+			return users, "", ErrDeltaExpired
+		}
+		if err != nil {
+			return users, "", err
+		}
+		for _, au := range p.Value {
+			if !au.removed() && !full {
+				au, err = c.user(au.Id)
+				if err != nil {
+					return users, "", err
+				}
+			}
+			if au.removed() {
+				users = append(users, User{Id: au.Id, Removed: true})
+				continue
+			}
+			users = append(users, au.user())
+		}
+		if p.NextLink == "" {
+			return users, p.DeltaLink, nil
+		}
+		u = p.NextLink
+	}
+}
+
+// user returns the full record of the user with the given id. A user who
+// has since been deleted is returned as removed.
+func (c *AzureClient) user(id string) (azureUser, error) {
+	au := azureUser{}
+	err := c.get(fmt.Sprintf("%s/users/%s?$select=%s", AzureGraphEndpoint, url.PathEscape(id), azureUserFields), &au)
+	if ae, ok := err.(*APIError); ok && ae.StatusCode == http.StatusNotFound {
+		return azureUser{Id: id, Removed: &azureRemoved{Reason: "deleted"}}, nil
+	}
+	return au, err
+}
@@ -0,0 +1,124 @@
+package directory
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AzureSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	tokenURL string
+	endpoint string
+	tokens   int
+}
+
+func (s *AzureSuite) SetupTest() {
+	s.tokens = 0
+	s.tokenURL, s.endpoint = AzureTokenURL, AzureGraphEndpoint
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		s.Nil(r.ParseForm())
+		s.Equal("client_credentials", r.Form.Get("grant_type"))
+		s.Equal("secret", r.Form.Get("client_secret"))
+		s.tokens++
+		fmt.Fprint(w, `{"access_token": "token", "expires_in": 3600}`)
+	})
+	page := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			s.Equal("Bearer token", r.Header.Get("Authorization"))
+			fmt.Fprint(w, strings.Replace(body, "$SERVER", s.server.URL, -1))
+		}
+	}
+	mux.HandleFunc("/v1.0/groups/sales/transitiveMembers/microsoft.graph.user", page(`{
+		"value": [{"id": "1", "givenName": "Alice", "surname": "Smith", "mail": "alice@example.com",
+			"jobTitle": "Manager", "businessPhones": ["555-0100"], "department": "Sales", "accountEnabled": true}],
+		"@odata.nextLink": "$SERVER/v1.0/page2"}`))
+	mux.HandleFunc("/v1.0/page2", page(`{"value": [
+		{"id": "2", "givenName": "Bob", "mail": "bob@example.com", "mobilePhone": "555-0101", "accountEnabled": true},
+		{"id": "3", "givenName": "Carol", "mail": "carol@example.com", "accountEnabled": false}]}`))
+	mux.HandleFunc("/v1.0/users/delta", page(`{"value": [
+		{"id": "1", "givenName": "Alice", "mail": "alice@example.com", "accountEnabled": true},
+		{"id": "2", "givenName": "Bob", "mail": "bob@example.com", "accountEnabled": true}],
+		"@odata.deltaLink": "$SERVER/v1.0/delta1"}`))
+	mux.HandleFunc("/v1.0/delta1", page(`{"value": [
+		{"id": "1", "jobTitle": "Director"},
+		{"id": "2", "@removed": {"reason": "deleted"}},
+		{"id": "4"}],
+		"@odata.deltaLink": "$SERVER/v1.0/delta2"}`))
+	mux.HandleFunc("/v1.0/users/1", page(`{"id": "1", "givenName": "Alice", "mail": "alice@example.com", "jobTitle": "Director", "accountEnabled": true}`))
+	mux.HandleFunc("/v1.0/users/4", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"code": "Request_ResourceNotFound"}}`, http.StatusNotFound)
+	})
+	mux.HandleFunc("/v1.0/expired", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"code": "syncStateNotFound"}}`, http.StatusGone)
+	})
+	s.server = httptest.NewServer(mux)
+	AzureTokenURL = s.server.URL + "/%s/oauth2/v2.0/token"
+	AzureGraphEndpoint = s.server.URL + "/v1.0"
+}
+
+func (s *AzureSuite) TearDownTest() {
+	s.server.Close()
+	AzureTokenURL, AzureGraphEndpoint = s.tokenURL, s.endpoint
+}
+
+func (s *AzureSuite) TestGroupUsers() {
+	c := NewAzureClient("tenant", "client", "secret", "sales")
+	users, err := c.Users()
+	s.Nil(err)
+	s.Len(users, 2)
+	s.Equal(User{
+		Id: "1", FirstName: "Alice", LastName: "Smith", Email: "alice@example.com",
+		Position: "Manager", Phone: "555-0100", Attributes: map[string]string{"department": "Sales"},
+	}, users[0])
+	s.Equal("555-0101", users[1].Phone)
+	// The access token is reused across pages
+	s.Equal(1, s.tokens)
+}
+
+func (s *AzureSuite) TestDelta() {
+	c := NewAzureClient("tenant", "client", "secret", "")
+	users, link, err := c.Delta("")
+	s.Nil(err)
+	s.Len(users, 2)
+	s.Equal(s.server.URL+"/v1.0/delta1", link)
+
+	users, link, err = c.Delta(link)
+	s.Nil(err)
+	s.Equal(s.server.URL+"/v1.0/delta2", link)
+	s.Len(users, 3)
+	// Changed users are returned with their full record
+	s.Equal("alice@example.com", users[0].Email)
+	s.Equal("Director", users[0].Position)
+	s.Equal(User{Id: "2", Removed: true}, users[1])
+	s.Equal(User{Id: "4", Removed: true}, users[2])
+
+	_, _, err = c.Delta(s.server.URL + "/v1.0/expired")
+	s.Equal(ErrDeltaExpired, err)
+}
+
+func (s *AzureSuite) TestTokenError() {
+	AzureTokenURL = s.server.URL + "/missing/%s"
+	_, err := NewAzureClient("tenant", "client", "secret", "").Users()
+	ae, ok := err.(*APIError)
+	s.True(ok)
+	s.Equal(http.StatusNotFound, ae.StatusCode)
+	s.Equal("Microsoft identity platform", ae.Provider)
+}
+
+func (s *AzureSuite) TestUserAttributes() {
+	au := azureUser{}
+	s.Nil(json.Unmarshal([]byte(`{"id": "1", "officeLocation": "London", "companyName": "", "employeeId": "42"}`), &au))
+	s.Equal(map[string]string{"officeLocation": "London", "employeeId": "42"}, au.user().Attributes)
+}
+
+func TestAzureSuite(t *testing.T) {
+	suite.Run(t, new(AzureSuite))
+}
@@ -0,0 +1,95 @@
+// Package directory imports users from cloud directories, such as Azure AD
+// and Google Workspace, so that groups of targets can be synced with them by
+// organizations without an LDAP server.
+package directory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// APITimeout is the timeout used for requests to a directory's API
+var APITimeout = 30 * time.Second
+
+// User is a user found in a directory
+type User struct {
+	Id        string
+	FirstName string
+	LastName  string
+	Email     string
+	Position  string
+	Phone     string
+	// Attributes holds the user's other properties, such as their
+	// department, which can be kept as a target's custom fields
+	Attributes map[string]string
+	// Removed is set by incremental syncs for users who have been deleted
+	// or disabled since the previous sync
+	Removed bool
+}
+
+// Client lists the users in a directory
+type Client interface {
+	// Users returns the enabled users in the part of the directory being
+	// synced
+	Users() ([]User, error)
+}
+
+// DeltaClient is a Client which can also return only the users who have
+// changed since a previous sync
+type DeltaClient interface {
+	Client
+	// Delta returns the users who have changed since the given delta link
+	// was returned, or every user if it's empty, along with the delta link
+	// to use for the next sync.
+	Delta(link string) ([]User, string, error)
+}
+
+// APIError is returned when a directory's API responds with an error
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+// Error returns the response from the directory's API
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s returned %d %s", e.Provider, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Message)
+	}
+	return msg
+}
+
+// maxErrorBody is the most of an error response which is included in the
+// returned APIError
+const maxErrorBody = 512
+
+// doJSONRequest sends the request to the provider's API and decodes the JSON
+// response into v, returning an APIError if the response doesn't have a 2xx
+// status.
+func doJSONRequest(client *http.Client, provider string, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(body) > maxErrorBody {
+			body = body[:maxErrorBody]
+		}
+		return &APIError{
+			Provider:   provider,
+			StatusCode: resp.StatusCode,
+			Message:    string(bytes.TrimSpace(body)),
+		}
+	}
+	return json.Unmarshal(body, v)
+}
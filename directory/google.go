@@ -0,0 +1,318 @@
+package directory
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// GoogleDirectoryEndpoint is the base URL of the Admin SDK Directory API
+var GoogleDirectoryEndpoint = "https://admin.googleapis.com/admin/directory/v1"
+
+// GoogleTokenURL is the endpoint used to request access tokens if the
+// service account key doesn't name one
+var GoogleTokenURL = "https://oauth2.googleapis.com/token"
+
+// googleScopes are the read-only scopes needed to list users and the members
+// of groups
+const googleScopes = "https://www.googleapis.com/auth/admin.directory.user.readonly https://www.googleapis.com/auth/admin.directory.group.member.readonly"
+
+// ErrInvalidServiceAccountKey is returned when a Google service account key
+// isn't a JSON key with an RSA private key
+var ErrInvalidServiceAccountKey = errors.New("Invalid Google service account key")
+
+// GoogleClient lists users in Google Workspace through the Admin SDK
+// Directory API. It authenticates as a service account with domain-wide
+// delegation, acting as an administrator of the domain.
+type GoogleClient struct {
+	// Key is the service account's JSON key
+	Key []byte
+	// AdminEmail is the administrator the service account acts as
+	AdminEmail string
+	// OrgUnit is the path of the organizational unit, such as "/Sales",
+	// whose users, including those in its sub-units, are synced
+	OrgUnit string
+	// Group is the email address of the group whose members, including the
+	// members of nested groups, are synced
+	Group  string
+	Client *http.Client
+
+	token   string
+	expires time.Time
+}
+
+// NewGoogleClient returns a client for the given service account
+func NewGoogleClient(key []byte, adminEmail, orgUnit, group string) *GoogleClient {
+	return &GoogleClient{
+		Key:        key,
+		AdminEmail: adminEmail,
+		OrgUnit:    orgUnit,
+		Group:      group,
+		Client:     &http.Client{Timeout: APITimeout},
+	}
+}
+
+// googleKey holds the fields used from a service account's JSON key
+type googleKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// googleToken is the response to an access token request
+type googleToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// googleUser is a user returned by the Directory API
+type googleUser struct {
+	Id           string `json:"id"`
+	PrimaryEmail string `json:"primaryEmail"`
+	Name         struct {
+		GivenName  string `json:"givenName"`
+		FamilyName string `json:"familyName"`
+	} `json:"name"`
+	Suspended     bool   `json:"suspended"`
+	Archived      bool   `json:"archived"`
+	OrgUnitPath   string `json:"orgUnitPath"`
+	Organizations []struct {
+		Title      string `json:"title"`
+		Department string `json:"department"`
+		CostCenter string `json:"costCenter"`
+		Primary    bool   `json:"primary"`
+	} `json:"organizations"`
+	Phones []struct {
+		Value   string `json:"value"`
+		Primary bool   `json:"primary"`
+	} `json:"phones"`
+	ExternalIds []struct {
+		Value string `json:"value"`
+		Type  string `json:"type"`
+	} `json:"externalIds"`
+}
+
+// googleUsersPage is a page of users returned by the Directory API
+type googleUsersPage struct {
+	Users         []googleUser `json:"users"`
+	NextPageToken string       `json:"nextPageToken"`
+}
+
+// googleMembersPage is a page of group members returned by the Directory API
+type googleMembersPage struct {
+	Members []struct {
+		Email string `json:"email"`
+		Type  string `json:"type"`
+	} `json:"members"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// user converts the Directory API user into a User, using their primary
+// organization and phone number
+func (u googleUser) user() User {
+	usr := User{
+		Id:         u.Id,
+		FirstName:  u.Name.GivenName,
+		LastName:   u.Name.FamilyName,
+		Email:      u.PrimaryEmail,
+		Attributes: map[string]string{},
+	}
+	if u.OrgUnitPath != "" {
+		usr.Attributes["orgUnitPath"] = u.OrgUnitPath
+	}
+	for i, o := range u.Organizations {
+		if i == 0 || o.Primary {
+			usr.Position = o.Title
+			delete(usr.Attributes, "department")
+			delete(usr.Attributes, "costCenter")
+			if o.Department != "" {
+				usr.Attributes["department"] = o.Department
+			}
+			if o.CostCenter != "" {
+				usr.Attributes["costCenter"] = o.CostCenter
+			}
+		}
+	}
+	for i, p := range u.Phones {
+		if i == 0 || p.Primary {
+			usr.Phone = p.Value
+		}
+	}
+	for _, id := range u.ExternalIds {
+		if id.Type == "organization" && id.Value != "" {
+			usr.Attributes["employeeId"] = id.Value
+		}
+	}
+	return usr
+}
+
+// base64URL encodes b as unpadded base64url, as used by JWTs
+func base64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// accessToken returns a valid access token, requesting a new one with a JWT
+// signed by the service account if needed
+func (c *GoogleClient) accessToken() (string, error) {
+	if c.token != "" && time.Now().Add(time.Minute).Before(c.expires) {
+		return c.token, nil
+	}
+	k := googleKey{}
+	if err := json.Unmarshal(c.Key, &k); err != nil || k.ClientEmail == "" {
+		return "", ErrInvalidServiceAccountKey
+	}
+	key, err := parseRSAKey(k.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	tokenURL := k.TokenURI
+	if tokenURL == "" {
+		tokenURL = GoogleTokenURL
+	}
+	now := time.Now()
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   k.ClientEmail,
+		"sub":   c.AdminEmail,
+		"scope": googleScopes,
+		"aud":   tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64URL([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + base64URL(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", unsigned+"."+base64URL(sig))
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	t := googleToken{}
+	err = doJSONRequest(c.Client, "Google OAuth", req, &t)
+	if err != nil {
+		return "", err
+	}
+	c.token = t.AccessToken
+	c.expires = now.Add(time.Duration(t.ExpiresIn) * time.Second)
+	return c.token, nil
+}
+
+// parseRSAKey parses the PEM encoded PKCS #8 or PKCS #1 RSA private key
+func parseRSAKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, ErrInvalidServiceAccountKey
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidServiceAccountKey
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidServiceAccountKey
+	}
+	return key, nil
+}
+
+// get requests the Directory API URL, decoding the response into v
+func (c *GoogleClient) get(u string, v interface{}) error {
+	token, err := c.accessToken()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return doJSONRequest(c.Client, "Google Directory API", req, v)
+}
+
+// Users returns the active users in the client's organizational unit, or in
+// the whole domain if it doesn't have one. If the client has a group, only
+// its members are returned.
+func (c *GoogleClient) Users() ([]User, error) {
+	var members map[string]bool
+	if c.Group != "" {
+		var err error
+		members, err = c.members()
+		if err != nil {
+			return nil, err
+		}
+	}
+	q := url.Values{}
+	q.Set("customer", "my_customer")
+	q.Set("maxResults", "500")
+	if c.OrgUnit != "" {
+		q.Set("query", fmt.Sprintf("orgUnitPath='%s'", strings.Replace(c.OrgUnit, "'", `\'`, -1)))
+	}
+	users := []User{}
+	for {
+		p := googleUsersPage{}
+		err := c.get(GoogleDirectoryEndpoint+"/users?"+q.Encode(), &p)
+		if err != nil {
+			return users, err
+		}
+		for _, gu := range p.Users {
+			if gu.Suspended || gu.Archived {
+				continue
+			}
+			if members != nil && !members[strings.ToLower(gu.PrimaryEmail)] {
+				continue
+			}
+			users = append(users, gu.user())
+		}
+		if p.NextPageToken == "" {
+			return users, nil
+		}
+		q.Set("pageToken", p.NextPageToken)
+	}
+}
+
+// members returns the lowercased email addresses of the users in the
+// client's group, including those in nested groups
+func (c *GoogleClient) members() (map[string]bool, error) {
+	q := url.Values{}
+	q.Set("includeDerivedMembership", "true")
+	q.Set("maxResults", "200")
+	members := map[string]bool{}
+	for {
+		p := googleMembersPage{}
+		u := fmt.Sprintf("%s/groups/%s/members?%s", GoogleDirectoryEndpoint, url.PathEscape(c.Group), q.Encode())
+		err := c.get(u, &p)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range p.Members {
+			if m.Type == "USER" {
+				members[strings.ToLower(m.Email)] = true
+			}
+		}
+		if p.NextPageToken == "" {
+			return members, nil
+		}
+		q.Set("pageToken", p.NextPageToken)
+	}
+}
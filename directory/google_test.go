@@ -0,0 +1,130 @@
+package directory
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type GoogleSuite struct {
+	suite.Suite
+	key      *rsa.PrivateKey
+	server   *httptest.Server
+	endpoint string
+}
+
+func (s *GoogleSuite) SetupSuite() {
+	var err error
+	s.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	s.Nil(err)
+}
+
+func (s *GoogleSuite) SetupTest() {
+	s.endpoint = GoogleDirectoryEndpoint
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		s.Nil(r.ParseForm())
+		s.Equal("urn:ietf:params:oauth:grant-type:jwt-bearer", r.Form.Get("grant_type"))
+		// The assertion is signed by the service account's key
+		parts := strings.Split(r.Form.Get("assertion"), ".")
+		s.Len(parts, 3)
+		sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+		s.Nil(err)
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		s.Nil(rsa.VerifyPKCS1v15(&s.key.PublicKey, crypto.SHA256, digest[:], sig))
+		b, err := base64.RawURLEncoding.DecodeString(parts[1])
+		s.Nil(err)
+		claims := map[string]interface{}{}
+		s.Nil(json.Unmarshal(b, &claims))
+		s.Equal("sync@project.iam.gserviceaccount.com", claims["iss"])
+		s.Equal("admin@example.com", claims["sub"])
+		fmt.Fprint(w, `{"access_token": "token", "expires_in": 3600}`)
+	})
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("Bearer token", r.Header.Get("Authorization"))
+		s.Equal("my_customer", r.URL.Query().Get("customer"))
+		s.Equal("orgUnitPath='/Sales'", r.URL.Query().Get("query"))
+		if r.URL.Query().Get("pageToken") == "" {
+			fmt.Fprint(w, `{"users": [
+				{"id": "1", "primaryEmail": "alice@example.com", "name": {"givenName": "Alice", "familyName": "Smith"},
+				 "orgUnitPath": "/Sales/EMEA",
+				 "organizations": [{"title": "Rep", "department": "Field"}, {"title": "Manager", "department": "Sales", "primary": true}],
+				 "phones": [{"value": "555-0100"}], "externalIds": [{"value": "42", "type": "organization"}]},
+				{"id": "2", "primaryEmail": "bob@example.com", "suspended": true}],
+				"nextPageToken": "next"}`)
+			return
+		}
+		fmt.Fprint(w, `{"users": [{"id": "3", "primaryEmail": "Carol@example.com", "name": {"givenName": "Carol"}}]}`)
+	})
+	mux.HandleFunc("/groups/sales@example.com/members", func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("true", r.URL.Query().Get("includeDerivedMembership"))
+		fmt.Fprint(w, `{"members": [{"email": "carol@example.com", "type": "USER"}, {"email": "alice@example.com", "type": "GROUP"}]}`)
+	})
+	s.server = httptest.NewServer(mux)
+	GoogleDirectoryEndpoint = s.server.URL
+}
+
+func (s *GoogleSuite) TearDownTest() {
+	s.server.Close()
+	GoogleDirectoryEndpoint = s.endpoint
+}
+
+// serviceAccountKey returns a JSON key for the test's service account
+func (s *GoogleSuite) serviceAccountKey() []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(s.key)
+	s.Nil(err)
+	key, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "sync@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    s.server.URL + "/token",
+	})
+	return key
+}
+
+func (s *GoogleSuite) TestOrgUnitUsers() {
+	c := NewGoogleClient(s.serviceAccountKey(), "admin@example.com", "/Sales", "")
+	users, err := c.Users()
+	s.Nil(err)
+	s.Len(users, 2)
+	s.Equal(User{
+		Id: "1", FirstName: "Alice", LastName: "Smith", Email: "alice@example.com",
+		Position: "Manager", Phone: "555-0100",
+		Attributes: map[string]string{"department": "Sales", "orgUnitPath": "/Sales/EMEA", "employeeId": "42"},
+	}, users[0])
+	s.Equal("Carol@example.com", users[1].Email)
+}
+
+func (s *GoogleSuite) TestGroupUsers() {
+	c := NewGoogleClient(s.serviceAccountKey(), "admin@example.com", "/Sales", "sales@example.com")
+	users, err := c.Users()
+	s.Nil(err)
+	s.Len(users, 1)
+	s.Equal("Carol@example.com", users[0].Email)
+}
+
+func (s *GoogleSuite) TestInvalidKey() {
+	for _, key := range []string{
+		"",
+		`{"client_email": "sync@project.iam.gserviceaccount.com", "private_key": "not a key"}`,
+	} {
+		_, err := NewGoogleClient([]byte(key), "admin@example.com", "", "").Users()
+		s.Equal(ErrInvalidServiceAccountKey, err, key)
+	}
+}
+
+func TestGoogleSuite(t *testing.T) {
+	suite.Run(t, new(GoogleSuite))
+}
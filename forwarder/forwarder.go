@@ -0,0 +1,259 @@
+// Package forwarder streams campaign events to a SIEM, such as Splunk or
+// Sentinel, as they're recorded. Events are sent over TCP, UDP or TLS as
+// RFC 5424 syslog messages with a JSON body, as CEF messages over syslog, or
+// as newline delimited JSON.
+package forwarder
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	log "github.com/gophish/gophish/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The formats events can be forwarded in
+const (
+	FormatSyslog = "syslog"
+	FormatCEF    = "cef"
+	FormatJSON   = "json"
+)
+
+// BufferSize is the number of events which are held while the destination
+// is slow or unavailable. Events recorded while the buffer is full are
+// dropped, so that recording them is never blocked.
+var BufferSize = 1000
+
+// DialTimeout is how long connecting to the destination may take
+var DialTimeout = 10 * time.Second
+
+// RetryInterval is how long to wait before reconnecting to the destination
+// after it fails
+var RetryInterval = 5 * time.Second
+
+// syslogPriority is the priority of the syslog messages, for the local0
+// facility with the informational severity
+const syslogPriority = 16*8 + 6
+
+// ErrInvalidNetwork is thrown when the network isn't tcp, udp or tls
+var ErrInvalidNetwork = errors.New("Event forwarding network must be tcp, udp or tls")
+
+// ErrInvalidFormat is thrown when the format isn't syslog, cef or json
+var ErrInvalidFormat = errors.New("Event forwarding format must be syslog, cef or json")
+
+// ErrAddressNotSpecified is thrown when no destination address is given
+var ErrAddressNotSpecified = errors.New("Event forwarding address not specified")
+
+// forwardedEvents counts the events handled by the forwarder, labeled as
+// "sent", "dropped" or "error".
+var forwardedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "gophish_forwarded_events_total",
+	Help: "The number of campaign events forwarded to the SIEM, by result.",
+}, []string{"result"})
+
+// RegisterMetrics registers the collectors for the forwarder's metrics with
+// the given registerer. It should be called once at startup.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	return reg.Register(forwardedEvents)
+}
+
+// Event is a campaign event recorded for a result
+type Event struct {
+	CampaignId   int64           `json:"campaign_id"`
+	CampaignName string          `json:"campaign_name"`
+	RId          string          `json:"rid"`
+	Email        string          `json:"email"`
+	Message      string          `json:"message"`
+	Time         time.Time       `json:"time"`
+	Details      json.RawMessage `json:"details,omitempty"`
+}
+
+// Forwarder sends events to the configured destination in the background.
+type Forwarder struct {
+	network  string
+	address  string
+	format   string
+	hostname string
+	events   chan Event
+	conn     net.Conn
+}
+
+// New returns a Forwarder for the given configuration. Start must be called
+// for the events to be sent.
+func New(c config.EventForwarding) (*Forwarder, error) {
+	switch c.Network {
+	case "tcp", "udp", "tls":
+	default:
+		return nil, ErrInvalidNetwork
+	}
+	switch c.Format {
+	case FormatSyslog, FormatCEF, FormatJSON:
+	default:
+		return nil, ErrInvalidFormat
+	}
+	if c.Address == "" {
+		return nil, ErrAddressNotSpecified
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	return &Forwarder{
+		network:  c.Network,
+		address:  c.Address,
+		format:   c.Format,
+		hostname: hostname,
+		events:   make(chan Event, BufferSize),
+	}, nil
+}
+
+// Forward queues the event to be sent. It never blocks, and drops the event
+// if the buffer is full.
+func (f *Forwarder) Forward(e Event) {
+	select {
+	case f.events <- e:
+	default:
+		forwardedEvents.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Start sends the queued events until the context is cancelled, connecting
+// to the destination as needed.
+func (f *Forwarder) Start(ctx context.Context) {
+	defer func() {
+		if f.conn != nil {
+			f.conn.Close()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-f.events:
+			msg, err := Format(f.format, e, f.hostname)
+			if err != nil {
+				log.Error(err)
+				forwardedEvents.WithLabelValues("error").Inc()
+				continue
+			}
+			for {
+				err = f.send(msg)
+				if err == nil {
+					forwardedEvents.WithLabelValues("sent").Inc()
+					break
+				}
+				log.Warnf("error forwarding event to %s: %s", f.address, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(RetryInterval):
+				}
+			}
+		}
+	}
+}
+
+// send writes the message to the destination, connecting first if needed.
+// The connection is closed if the write fails, so that the next send
+// reconnects.
+func (f *Forwarder) send(msg []byte) error {
+	if f.conn == nil {
+		conn, err := f.dial()
+		if err != nil {
+			return err
+		}
+		f.conn = conn
+	}
+	_, err := f.conn.Write(msg)
+	if err != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+	return err
+}
+
+func (f *Forwarder) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: DialTimeout}
+	if f.network == "tls" {
+		return tls.DialWithDialer(d, "tcp", f.address, &tls.Config{})
+	}
+	return d.Dial(f.network, f.address)
+}
+
+// Format returns the event formatted in the given format, terminated by a
+// newline.
+func Format(format string, e Event, hostname string) ([]byte, error) {
+	var msg string
+	switch format {
+	case FormatJSON, FormatSyslog:
+		j, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		msg = string(j)
+		if format == FormatSyslog {
+			msg = syslogHeader(e, hostname) + msg
+		}
+	case FormatCEF:
+		msg = syslogHeader(e, hostname) + cefMessage(e)
+	default:
+		return nil, ErrInvalidFormat
+	}
+	return []byte(msg + "\n"), nil
+}
+
+// syslogHeader returns the RFC 5424 header for the event
+func syslogHeader(e Event, hostname string) string {
+	return fmt.Sprintf("<%d>1 %s %s gophish - - - ", syslogPriority,
+		e.Time.UTC().Format(time.RFC3339Nano), hostname)
+}
+
+// cefSeverities are the CEF severities of the events which show the
+// recipient falling for the simulation. Other events have a severity of 3.
+var cefSeverities = map[string]int{
+	"Email Opened":      4,
+	"Opened Attachment": 6,
+	"Clicked Link":      6,
+	"Submitted Data":    9,
+	"Submitted MFA":     10,
+	"Email Reported":    1,
+}
+
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+
+// cefMessage returns the event as a CEF message. The signature ID is the
+// event's message in lower case with underscores, such as "clicked_link".
+func cefMessage(e Event) string {
+	severity, ok := cefSeverities[e.Message]
+	if !ok {
+		severity = 3
+	}
+	signature := strings.ToLower(strings.Replace(e.Message, " ", "_", -1))
+	ext := []string{
+		fmt.Sprintf("rt=%d", e.Time.UnixNano()/int64(time.Millisecond)),
+		"duser=" + cefValueEscaper.Replace(e.Email),
+		"cs1Label=campaignId",
+		fmt.Sprintf("cs1=%d", e.CampaignId),
+		"cs2Label=campaignName",
+		"cs2=" + cefValueEscaper.Replace(e.CampaignName),
+		"cs3Label=rid",
+		"cs3=" + cefValueEscaper.Replace(e.RId),
+	}
+	if len(e.Details) > 0 {
+		ext = append(ext, "msg="+cefValueEscaper.Replace(string(e.Details)))
+	}
+	return fmt.Sprintf("CEF:0|Gophish|Gophish|%s|%s|%s|%d|%s",
+		cefHeaderEscaper.Replace(strings.TrimSpace(config.Version)),
+		cefHeaderEscaper.Replace(signature),
+		cefHeaderEscaper.Replace(e.Message),
+		severity, strings.Join(ext, " "))
+}
@@ -0,0 +1,127 @@
+package forwarder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type ForwarderSuite struct {
+	suite.Suite
+}
+
+var testEvent = Event{
+	CampaignId:   1,
+	CampaignName: "Q3 | Finance",
+	RId:          "abc123",
+	Email:        "test@example.com",
+	Message:      "Clicked Link",
+	Time:         time.Date(2018, 7, 19, 10, 30, 0, 0, time.UTC),
+	Details:      json.RawMessage(`{"payload":{"a=b":["c"]}}`),
+}
+
+func (s *ForwarderSuite) TestNewValidation() {
+	_, err := New(config.EventForwarding{Network: "http", Address: "localhost:514", Format: FormatCEF})
+	s.Equal(ErrInvalidNetwork, err)
+	_, err = New(config.EventForwarding{Network: "udp", Address: "localhost:514", Format: "xml"})
+	s.Equal(ErrInvalidFormat, err)
+	_, err = New(config.EventForwarding{Network: "udp", Format: FormatJSON})
+	s.Equal(ErrAddressNotSpecified, err)
+	_, err = New(config.EventForwarding{Network: "tls", Address: "localhost:6514", Format: FormatSyslog})
+	s.Nil(err)
+}
+
+func (s *ForwarderSuite) TestFormatJSON() {
+	msg, err := Format(FormatJSON, testEvent, "host")
+	s.Nil(err)
+	s.True(strings.HasSuffix(string(msg), "\n"))
+	got := Event{}
+	s.Nil(json.Unmarshal(msg, &got))
+	s.Equal(testEvent.RId, got.RId)
+	s.Equal(testEvent.Message, got.Message)
+	s.True(testEvent.Time.Equal(got.Time))
+}
+
+func (s *ForwarderSuite) TestFormatSyslog() {
+	msg, err := Format(FormatSyslog, testEvent, "host")
+	s.Nil(err)
+	s.True(strings.HasPrefix(string(msg), "<134>1 2018-07-19T10:30:00Z host gophish - - - {"))
+}
+
+func (s *ForwarderSuite) TestFormatCEF() {
+	defer func(version string) {
+		config.Version = version
+	}(config.Version)
+	config.Version = "0.7.0\n"
+	msg, err := Format(FormatCEF, testEvent, "host")
+	s.Nil(err)
+	s.Equal("<134>1 2018-07-19T10:30:00Z host gophish - - - "+
+		"CEF:0|Gophish|Gophish|0.7.0|clicked_link|Clicked Link|6|"+
+		"rt=1531996200000 duser=test@example.com cs1Label=campaignId cs1=1 "+
+		"cs2Label=campaignName cs2=Q3 | Finance cs3Label=rid cs3=abc123 "+
+		`msg={"payload":{"a\=b":["c"]}}`+"\n", string(msg))
+}
+
+func (s *ForwarderSuite) TestForwardTCP() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Nil(err)
+	defer l.Close()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	f, err := New(config.EventForwarding{Network: "tcp", Address: l.Addr().String(), Format: FormatJSON})
+	s.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Start(ctx)
+
+	for _, rid := range []string{"first", "second"} {
+		e := testEvent
+		e.RId = rid
+		f.Forward(e)
+	}
+	for _, rid := range []string{"first", "second"} {
+		select {
+		case line := <-lines:
+			got := Event{}
+			s.Nil(json.Unmarshal([]byte(line), &got))
+			s.Equal(rid, got.RId)
+		case <-time.After(5 * time.Second):
+			s.FailNow("event was not forwarded")
+		}
+	}
+}
+
+func (s *ForwarderSuite) TestForwardDropsWhenFull() {
+	defer func(size int) {
+		BufferSize = size
+	}(BufferSize)
+	BufferSize = 1
+	f, err := New(config.EventForwarding{Network: "udp", Address: "127.0.0.1:514", Format: FormatJSON})
+	s.Nil(err)
+	// The forwarder isn't started, so the second event doesn't fit
+	f.Forward(testEvent)
+	f.Forward(testEvent)
+	s.Equal(1, len(f.events))
+}
+
+func TestForwarderSuite(t *testing.T) {
+	suite.Run(t, new(ForwarderSuite))
+}
@@ -31,7 +31,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 
@@ -39,13 +43,20 @@ import (
 	"github.com/gophish/gophish/auth"
 	"github.com/gophish/gophish/config"
 	"github.com/gophish/gophish/controllers"
+	"github.com/gophish/gophish/forwarder"
 	log "github.com/gophish/gophish/logger"
 	"github.com/gophish/gophish/mailer"
 	"github.com/gophish/gophish/models"
+	"github.com/gophish/gophish/processor"
 	"github.com/gophish/gophish/util"
 	"github.com/gorilla/handlers"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+// shutdownTimeout is how long the web servers are given to finish handling
+// requests when shutting down.
+const shutdownTimeout = 10 * time.Second
+
 var (
 	configPath    = kingpin.Flag("config", "Location of config.json.").Default("./config.json").String()
 	disableMailer = kingpin.Flag("disable-mailer", "Disable the mailer (for use with multi-system deployments)").Bool()
@@ -75,50 +86,152 @@ func main() {
 	if !*disableMailer {
 		go mailer.Mailer.Start(ctx)
 	}
+	err = controllers.CheckTrustedProxies()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = controllers.CheckAdminAccess()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = controllers.ConfigureSSO()
+	if err != nil {
+		log.Fatal(err)
+	}
 	// Setup the global variables and settings
 	err = models.Setup()
 	if err != nil {
 		log.Fatal(err)
 	}
+	// Forward campaign events to a SIEM, if configured
+	if config.Conf.EventForwarding.Address != "" {
+		f, err := forwarder.New(config.Conf.EventForwarding)
+		if err != nil {
+			log.Fatal(err)
+		}
+		models.EventForwarder = f
+		go f.Start(ctx)
+	}
+	// Pass result events through the event processors, if configured
+	if len(config.Conf.EventProcessors) > 0 {
+		c, err := processor.New(config.Conf.EventProcessors)
+		if err != nil {
+			log.Fatal(err)
+		}
+		models.EventProcessors = c
+	}
+	if config.Conf.GeoIPReload > 0 {
+		go models.WatchGeoIPDatabase(ctx, time.Duration(config.Conf.GeoIPReload)*time.Minute)
+	}
+	for _, register := range []func(prometheus.Registerer) error{
+		models.RegisterMetrics, mailer.RegisterMetrics, controllers.RegisterMetrics, forwarder.RegisterMetrics,
+		processor.RegisterMetrics,
+	} {
+		err = register(prometheus.DefaultRegisterer)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	// Unlock any maillogs that may have been locked for processing
-	// when Gophish was last shutdown.
-	err = models.UnlockAllMailLogs()
+	// when Gophish was last shutdown. Maillogs being sent by other
+	// instances are left locked.
+	err = models.ReleaseMailLogs(models.InstanceId)
 	if err != nil {
 		log.Fatal(err)
 	}
+	gzipWrapper, _ := gziphandler.NewGzipLevelHandler(gzip.BestCompression)
+	adminRouter := controllers.CreateAdminRouter()
+	compressed := gzipWrapper(adminRouter)
+	// Event streams are flushed as each event occurs, which compression
+	// would hold back
+	adminHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/stream") {
+			adminRouter.ServeHTTP(w, r)
+			return
+		}
+		compressed.ServeHTTP(w, r)
+	})
+	adminServer := &http.Server{
+		Addr:    config.Conf.AdminConf.ListenURL,
+		Handler: handlers.CombinedLoggingHandler(os.Stdout, adminHandler),
+	}
+	phishHandler := gziphandler.GzipHandler(controllers.CreatePhishingRouter())
+	phishServer := &http.Server{
+		Addr:    config.Conf.PhishConf.ListenURL,
+		Handler: handlers.CombinedLoggingHandler(os.Stdout, phishHandler),
+	}
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 	// Start the web servers
 	go func() {
 		defer wg.Done()
-		gzipWrapper, _ := gziphandler.NewGzipLevelHandler(gzip.BestCompression)
-		adminHandler := gzipWrapper(controllers.CreateAdminRouter())
 		auth.Store.Options.Secure = config.Conf.AdminConf.UseTLS
 		if config.Conf.AdminConf.UseTLS { // use TLS for Admin web server if available
 			err := util.CheckAndCreateSSL(config.Conf.AdminConf.CertPath, config.Conf.AdminConf.KeyPath)
 			if err != nil {
 				log.Fatal(err)
 			}
+			// Require client certificates, if configured
+			tlsConfig, err := controllers.AdminTLSConfig()
+			if err != nil {
+				log.Fatal(err)
+			}
+			adminServer.Handler = handlers.CombinedLoggingHandler(log.Writer(), adminHandler)
+			adminServer.TLSConfig = tlsConfig
 			log.Infof("Starting admin server at https://%s", config.Conf.AdminConf.ListenURL)
-			log.Info(http.ListenAndServeTLS(config.Conf.AdminConf.ListenURL, config.Conf.AdminConf.CertPath, config.Conf.AdminConf.KeyPath,
-				handlers.CombinedLoggingHandler(log.Writer(), adminHandler)))
+			log.Info(adminServer.ListenAndServeTLS(config.Conf.AdminConf.CertPath, config.Conf.AdminConf.KeyPath))
 		} else {
 			log.Infof("Starting admin server at http://%s", config.Conf.AdminConf.ListenURL)
-			log.Info(http.ListenAndServe(config.Conf.AdminConf.ListenURL, handlers.CombinedLoggingHandler(os.Stdout, adminHandler)))
+			log.Info(adminServer.ListenAndServe())
 		}
 	}()
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		phishHandler := gziphandler.GzipHandler(controllers.CreatePhishingRouter())
 		if config.Conf.PhishConf.UseTLS { // use TLS for Phish web server if available
+			phishServer.Handler = handlers.CombinedLoggingHandler(log.Writer(), phishHandler)
 			log.Infof("Starting phishing server at https://%s", config.Conf.PhishConf.ListenURL)
-			log.Info(http.ListenAndServeTLS(config.Conf.PhishConf.ListenURL, config.Conf.PhishConf.CertPath, config.Conf.PhishConf.KeyPath,
-				handlers.CombinedLoggingHandler(log.Writer(), phishHandler)))
+			log.Info(phishServer.ListenAndServeTLS(config.Conf.PhishConf.CertPath, config.Conf.PhishConf.KeyPath))
 		} else {
 			log.Infof("Starting phishing server at http://%s", config.Conf.PhishConf.ListenURL)
-			log.Fatal(http.ListenAndServe(config.Conf.PhishConf.ListenURL, handlers.CombinedLoggingHandler(os.Stdout, phishHandler)))
+			err := phishServer.ListenAndServe()
+			if err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
 		}
 	}()
-	wg.Wait()
+
+	// Shut down cleanly on SIGINT or SIGTERM, or once both servers have
+	// stopped, so that held results are written and the GeoIP database is
+	// closed before exiting
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-sigs:
+		log.Info("Shutting down")
+	case <-stopped:
+	}
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+	for _, server := range []*http.Server{adminServer, phishServer} {
+		err = server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	<-stopped
+	err = models.FlushResults()
+	if err != nil {
+		log.Error(err)
+	}
+	err = models.CloseGeoIPDatabase()
+	if err != nil {
+		log.Error(err)
+	}
 }
@@ -0,0 +1,169 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// ErrMalformedPacket is returned when a response from the directory can't be
+// decoded
+var ErrMalformedPacket = errors.New("Malformed LDAP packet")
+
+// maxPacketSize is the largest response accepted from the directory
+const maxPacketSize = 16 << 20
+
+// The universal BER tags used by LDAP
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+)
+
+// element is a decoded BER element. The value of a constructed element holds
+// its encoded children.
+type element struct {
+	tag   byte
+	value []byte
+}
+
+// tlv encodes the content with the given tag
+func tlv(tag byte, content []byte) []byte {
+	b := []byte{tag}
+	n := len(content)
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	default:
+		var l []byte
+		for ; n > 0; n >>= 8 {
+			l = append([]byte{byte(n)}, l...)
+		}
+		b = append(b, 0x80|byte(len(l)))
+		b = append(b, l...)
+	}
+	return append(b, content...)
+}
+
+// encodeInt encodes the integer in two's complement with the given tag
+func encodeInt(tag byte, n int64) []byte {
+	b := []byte{byte(n)}
+	for n >>= 8; n != 0 && n != -1; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	// Add a leading byte if the sign bit doesn't match the sign
+	if n == 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	} else if n == -1 && b[0]&0x80 == 0 {
+		b = append([]byte{0xff}, b...)
+	}
+	return tlv(tag, b)
+}
+
+// encodeString encodes the string with the given tag
+func encodeString(tag byte, s string) []byte {
+	return tlv(tag, []byte(s))
+}
+
+// encodeBool encodes the boolean with the given tag
+func encodeBool(tag byte, v bool) []byte {
+	if v {
+		return tlv(tag, []byte{0xff})
+	}
+	return tlv(tag, []byte{0})
+}
+
+// decodeLength decodes the length of an element from the start of b,
+// returning the length and the number of bytes it was encoded in
+func decodeLength(b []byte) (int, int, error) {
+	if len(b) == 0 {
+		return 0, 0, ErrMalformedPacket
+	}
+	if b[0] < 0x80 {
+		return int(b[0]), 1, nil
+	}
+	// The indefinite form isn't used by LDAP
+	size := int(b[0] & 0x7f)
+	if size == 0 || size > 4 || len(b) < size+1 {
+		return 0, 0, ErrMalformedPacket
+	}
+	n := 0
+	for _, c := range b[1 : size+1] {
+		n = n<<8 | int(c)
+	}
+	if n > maxPacketSize {
+		return 0, 0, ErrMalformedPacket
+	}
+	return n, size + 1, nil
+}
+
+// decodeElement decodes the element at the start of b, returning the rest
+func decodeElement(b []byte) (element, []byte, error) {
+	if len(b) < 2 {
+		return element{}, nil, ErrMalformedPacket
+	}
+	n, size, err := decodeLength(b[1:])
+	if err != nil {
+		return element{}, nil, err
+	}
+	start := 1 + size
+	if len(b) < start+n {
+		return element{}, nil, ErrMalformedPacket
+	}
+	return element{tag: b[0], value: b[start : start+n]}, b[start+n:], nil
+}
+
+// children decodes the children of a constructed element
+func (e element) children() ([]element, error) {
+	es := []element{}
+	b := e.value
+	for len(b) > 0 {
+		var c element
+		var err error
+		c, b, err = decodeElement(b)
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, c)
+	}
+	return es, nil
+}
+
+// int decodes the element's value as a two's complement integer
+func (e element) int() (int64, error) {
+	if len(e.value) == 0 || len(e.value) > 8 {
+		return 0, ErrMalformedPacket
+	}
+	n := int64(int8(e.value[0]))
+	for _, c := range e.value[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+// readElement reads a single element from the connection
+func readElement(r *bufio.Reader) (element, error) {
+	header := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return element{}, err
+	}
+	if header[1]&0x80 != 0 {
+		extra := make([]byte, header[1]&0x7f)
+		if _, err := io.ReadFull(r, extra); err != nil {
+			return element{}, err
+		}
+		header = append(header, extra...)
+	}
+	n, _, err := decodeLength(header[1:])
+	if err != nil {
+		return element{}, err
+	}
+	value := make([]byte, n)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return element{}, err
+	}
+	return element{tag: header[0], value: value}, nil
+}
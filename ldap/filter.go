@@ -0,0 +1,241 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidFilter is returned when a search filter isn't a valid RFC 4515
+// string filter
+var ErrInvalidFilter = errors.New("Invalid LDAP filter")
+
+// The context-specific tags of each filter choice
+const (
+	filterAnd        = 0xa0
+	filterOr         = 0xa1
+	filterNot        = 0xa2
+	filterEquality   = 0xa3
+	filterSubstrings = 0xa4
+	filterGreater    = 0xa5
+	filterLess       = 0xa6
+	filterPresent    = 0x87
+	filterApprox     = 0xa8
+	filterExtensible = 0xa9
+)
+
+// CompileFilter encodes the string filter, such as
+// "(&(objectClass=user)(department=Sales))", for use in a search request. The
+// enclosing parentheses may be left out of a filter with a single item, and
+// an empty filter matches every entry.
+func CompileFilter(f string) ([]byte, error) {
+	f = strings.TrimSpace(f)
+	if f == "" {
+		f = "(objectClass=*)"
+	}
+	if !strings.HasPrefix(f, "(") {
+		f = "(" + f + ")"
+	}
+	b, pos, err := parseFilter(f, 0)
+	if err != nil {
+		return nil, err
+	}
+	if pos != len(f) {
+		return nil, ErrInvalidFilter
+	}
+	return b, nil
+}
+
+// parseFilter parses the parenthesized filter starting at pos, returning its
+// encoding and the position after it
+func parseFilter(f string, pos int) ([]byte, int, error) {
+	if pos+1 >= len(f) || f[pos] != '(' {
+		return nil, 0, ErrInvalidFilter
+	}
+	pos++
+	switch f[pos] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if f[pos] == '|' {
+			tag = filterOr
+		}
+		pos++
+		content := []byte{}
+		for pos < len(f) && f[pos] == '(' {
+			b, next, err := parseFilter(f, pos)
+			if err != nil {
+				return nil, 0, err
+			}
+			content = append(content, b...)
+			pos = next
+		}
+		if len(content) == 0 || pos >= len(f) || f[pos] != ')' {
+			return nil, 0, ErrInvalidFilter
+		}
+		return tlv(tag, content), pos + 1, nil
+	case '!':
+		b, next, err := parseFilter(f, pos+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		if next >= len(f) || f[next] != ')' {
+			return nil, 0, ErrInvalidFilter
+		}
+		return tlv(filterNot, b), next + 1, nil
+	}
+	// Values can't contain unescaped parentheses, so the item ends at the
+	// next closing parenthesis
+	end := strings.IndexByte(f[pos:], ')')
+	if end == -1 {
+		return nil, 0, ErrInvalidFilter
+	}
+	b, err := parseItem(f[pos : pos+end])
+	if err != nil {
+		return nil, 0, err
+	}
+	return b, pos + end + 1, nil
+}
+
+// parseItem encodes a single comparison, such as "mail=*@example.com"
+func parseItem(s string) ([]byte, error) {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 || strings.ContainsAny(s, "(") {
+		return nil, ErrInvalidFilter
+	}
+	attr, value := s[:i], s[i+1:]
+	tag := byte(filterEquality)
+	switch attr[len(attr)-1] {
+	case '~':
+		tag = filterApprox
+	case '>':
+		tag = filterGreater
+	case '<':
+		tag = filterLess
+	case ':':
+		return parseExtensible(attr[:len(attr)-1], value)
+	}
+	if tag != filterEquality {
+		attr = attr[:len(attr)-1]
+	}
+	if !validAttribute(attr) {
+		return nil, ErrInvalidFilter
+	}
+	if tag == filterEquality && value == "*" {
+		return encodeString(filterPresent, attr), nil
+	}
+	if tag == filterEquality && strings.Contains(value, "*") {
+		return parseSubstrings(attr, value)
+	}
+	v, err := unescapeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return tlv(tag, append(encodeString(tagOctetString, attr), encodeString(tagOctetString, v)...)), nil
+}
+
+// parseSubstrings encodes a substring match, such as "cn=J*n*s"
+func parseSubstrings(attr string, value string) ([]byte, error) {
+	parts := strings.Split(value, "*")
+	subs := []byte{}
+	for i, p := range parts {
+		if p == "" {
+			continue
+		}
+		v, err := unescapeValue(p)
+		if err != nil {
+			return nil, err
+		}
+		tag := byte(0x81)
+		switch i {
+		case 0:
+			tag = 0x80
+		case len(parts) - 1:
+			tag = 0x82
+		}
+		subs = append(subs, encodeString(tag, v)...)
+	}
+	if len(subs) == 0 {
+		return nil, ErrInvalidFilter
+	}
+	return tlv(filterSubstrings, append(encodeString(tagOctetString, attr), tlv(tagSequence, subs)...)), nil
+}
+
+// parseExtensible encodes an extensible match, such as Active Directory's
+// "memberOf:1.2.840.113556.1.4.1941:=<group DN>" for nested group
+// membership. The attribute part is given without the trailing ":".
+func parseExtensible(attr string, value string) ([]byte, error) {
+	parts := strings.Split(attr, ":")
+	attr, parts = parts[0], parts[1:]
+	dn := false
+	if len(parts) > 0 && strings.EqualFold(parts[0], "dn") {
+		dn = true
+		parts = parts[1:]
+	}
+	rule := ""
+	if len(parts) > 0 {
+		rule, parts = parts[0], parts[1:]
+	}
+	if len(parts) > 0 || (attr == "" && rule == "") ||
+		(attr != "" && !validAttribute(attr)) || (rule != "" && !validAttribute(rule)) {
+		return nil, ErrInvalidFilter
+	}
+	v, err := unescapeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	content := []byte{}
+	if rule != "" {
+		content = append(content, encodeString(0x81, rule)...)
+	}
+	if attr != "" {
+		content = append(content, encodeString(0x82, attr)...)
+	}
+	content = append(content, encodeString(0x83, v)...)
+	if dn {
+		content = append(content, encodeBool(0x84, true)...)
+	}
+	return tlv(filterExtensible, content), nil
+}
+
+// validAttribute returns whether the attribute description or matching rule
+// only contains the characters allowed in names and OIDs, as well as the
+// ";" used for options
+func validAttribute(a string) bool {
+	if a == "" {
+		return false
+	}
+	for _, c := range a {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '.' || c == ';':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// unescapeValue replaces the "\XX" hex escapes in a filter value with the
+// bytes they represent
+func unescapeValue(v string) (string, error) {
+	if !strings.Contains(v, `\`) {
+		return v, nil
+	}
+	b := []byte{}
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			b = append(b, v[i])
+			continue
+		}
+		if i+2 >= len(v) {
+			return "", ErrInvalidFilter
+		}
+		c, err := hex.DecodeString(v[i+1 : i+3])
+		if err != nil {
+			return "", ErrInvalidFilter
+		}
+		b = append(b, c...)
+		i += 2
+	}
+	return string(b), nil
+}
@@ -0,0 +1,378 @@
+// Package ldap is a minimal LDAPv3 client, used to synchronize groups of
+// targets with a directory such as Active Directory.
+//
+// It supports simple binds, StartTLS and LDAPS, and subtree searches. Searches
+// request paged results, so that directories which limit the size of a
+// response, as Active Directory does, return every matching entry.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrInvalidURL is returned when a directory URL isn't an ldap:// or ldaps://
+// URL
+var ErrInvalidURL = errors.New("Directory URL must be an ldap:// or ldaps:// URL")
+
+// ErrUnexpectedResponse is returned when the directory responds with a
+// message other than the one expected
+var ErrUnexpectedResponse = errors.New("Unexpected LDAP response")
+
+// Timeout is how long the directory is given to respond to each request
+var Timeout = 30 * time.Second
+
+// PageSize is the number of entries requested in each page of a search
+var PageSize = 500
+
+// The application tags of the protocol operations used
+const (
+	appBindRequest     = 0x60
+	appBindResponse    = 0x61
+	appUnbindRequest   = 0x42
+	appSearchRequest   = 0x63
+	appSearchEntry     = 0x64
+	appSearchDone      = 0x65
+	appSearchReference = 0x73
+	appExtendedRequest = 0x77
+	appExtendedResp    = 0x78
+	tagControls        = 0xa0
+)
+
+// oidStartTLS identifies the StartTLS extended operation
+const oidStartTLS = "1.3.6.1.4.1.1466.20037"
+
+// oidPagedResults identifies the simple paged results control from RFC 2696
+const oidPagedResults = "1.2.840.113556.1.4.319"
+
+// Error is an unsuccessful result returned by the directory, such as when a
+// bind's credentials are invalid
+type Error struct {
+	Code    int64
+	Message string
+}
+
+// Error returns the result code and the directory's diagnostic message
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("LDAP error %d", e.Code)
+	}
+	return fmt.Sprintf("LDAP error %d: %s", e.Code, e.Message)
+}
+
+// Entry is an entry returned by a search
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Get returns the first value of the attribute, matching its name without
+// regard to case, or an empty string if the entry doesn't have it.
+func (e Entry) Get(attr string) string {
+	for name, vs := range e.Attributes {
+		if strings.EqualFold(name, attr) && len(vs) > 0 {
+			return vs[0]
+		}
+	}
+	return ""
+}
+
+// Conn is a connection to a directory
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	id   int64
+}
+
+// Dial connects to the directory at the given ldap:// or ldaps:// URL. The TLS
+// configuration is used for ldaps:// URLs, and should have its ServerName
+// set.
+func Dial(rawurl string, config *tls.Config) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Hostname() == "" {
+		return nil, ErrInvalidURL
+	}
+	host := u.Host
+	d := &net.Dialer{Timeout: Timeout}
+	var conn net.Conn
+	switch strings.ToLower(u.Scheme) {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = d.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(d, "tcp", host, config)
+	default:
+		return nil, ErrInvalidURL
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn), nil
+}
+
+// NewConn returns an LDAP connection over an established network connection
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// Close unbinds from the directory and closes the connection
+func (c *Conn) Close() error {
+	c.send(tlv(appUnbindRequest, nil), nil)
+	return c.conn.Close()
+}
+
+// send sends a request with the given controls, returning its message id
+func (c *Conn) send(op []byte, controls []byte) (int64, error) {
+	c.id++
+	msg := append(encodeInt(tagInteger, c.id), op...)
+	if controls != nil {
+		msg = append(msg, tlv(tagControls, controls)...)
+	}
+	c.conn.SetDeadline(time.Now().Add(Timeout))
+	_, err := c.conn.Write(tlv(tagSequence, msg))
+	return c.id, err
+}
+
+// receive reads the next response to the given message, returning its
+// protocol operation and controls
+func (c *Conn) receive(id int64) (element, []element, error) {
+	for {
+		c.conn.SetDeadline(time.Now().Add(Timeout))
+		msg, err := readElement(c.r)
+		if err != nil {
+			return element{}, nil, err
+		}
+		if msg.tag != tagSequence {
+			return element{}, nil, ErrMalformedPacket
+		}
+		es, err := msg.children()
+		if err != nil || len(es) < 2 {
+			return element{}, nil, ErrMalformedPacket
+		}
+		msgID, err := es[0].int()
+		if err != nil {
+			return element{}, nil, err
+		}
+		// A message id of zero is an unsolicited notification, such as the
+		// directory disconnecting
+		if msgID == 0 {
+			if err := parseResult(es[1]); err != nil {
+				return element{}, nil, err
+			}
+			return element{}, nil, ErrUnexpectedResponse
+		}
+		if msgID != id {
+			continue
+		}
+		var controls []element
+		if len(es) > 2 && es[2].tag == tagControls {
+			controls, err = es[2].children()
+			if err != nil {
+				return element{}, nil, err
+			}
+		}
+		return es[1], controls, nil
+	}
+}
+
+// parseResult returns the error described by an LDAPResult, if it isn't
+// successful
+func parseResult(op element) error {
+	es, err := op.children()
+	if err != nil || len(es) < 3 {
+		return ErrMalformedPacket
+	}
+	code, err := es[0].int()
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return &Error{Code: code, Message: string(es[2].value)}
+	}
+	return nil
+}
+
+// StartTLS upgrades the connection to TLS using the StartTLS extended
+// operation
+func (c *Conn) StartTLS(config *tls.Config) error {
+	id, err := c.send(tlv(appExtendedRequest, encodeString(0x80, oidStartTLS)), nil)
+	if err != nil {
+		return err
+	}
+	op, _, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != appExtendedResp {
+		return ErrUnexpectedResponse
+	}
+	if err := parseResult(op); err != nil {
+		return err
+	}
+	conn := tls.Client(c.conn, config)
+	conn.SetDeadline(time.Now().Add(Timeout))
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+	return nil
+}
+
+// Bind authenticates to the directory with a simple bind
+func (c *Conn) Bind(dn string, password string) error {
+	req := encodeInt(tagInteger, 3)
+	req = append(req, encodeString(tagOctetString, dn)...)
+	req = append(req, encodeString(0x80, password)...)
+	id, err := c.send(tlv(appBindRequest, req), nil)
+	if err != nil {
+		return err
+	}
+	op, _, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != appBindResponse {
+		return ErrUnexpectedResponse
+	}
+	return parseResult(op)
+}
+
+// Search returns the entries beneath the base DN which match the filter,
+// with the given attributes. Every attribute is returned if none are given.
+func (c *Conn) Search(base string, filter string, attributes []string) ([]Entry, error) {
+	f, err := CompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []byte{}
+	for _, a := range attributes {
+		attrs = append(attrs, encodeString(tagOctetString, a)...)
+	}
+	req := encodeString(tagOctetString, base)
+	// A subtree search which never dereferences aliases
+	req = append(req, encodeInt(tagEnumerated, 2)...)
+	req = append(req, encodeInt(tagEnumerated, 0)...)
+	// No size or time limit, and return values as well as types
+	req = append(req, encodeInt(tagInteger, 0)...)
+	req = append(req, encodeInt(tagInteger, 0)...)
+	req = append(req, encodeBool(tagBoolean, false)...)
+	req = append(req, f...)
+	req = append(req, tlv(tagSequence, attrs)...)
+	req = tlv(appSearchRequest, req)
+
+	entries := []Entry{}
+	var cookie []byte
+	for {
+		id, err := c.send(req, pagingControl(cookie))
+		if err != nil {
+			return entries, err
+		}
+		cookie, err = c.receiveEntries(id, &entries)
+		if err != nil {
+			return entries, err
+		}
+		if len(cookie) == 0 {
+			return entries, nil
+		}
+	}
+}
+
+// receiveEntries reads the entries returned for a page of the search,
+// returning the cookie used to request the next page, if there is one
+func (c *Conn) receiveEntries(id int64, entries *[]Entry) ([]byte, error) {
+	for {
+		op, controls, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case appSearchEntry:
+			e, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			*entries = append(*entries, e)
+		case appSearchReference:
+			// Referrals to other directories aren't followed
+		case appSearchDone:
+			if err := parseResult(op); err != nil {
+				return nil, err
+			}
+			return pagingCookie(controls), nil
+		default:
+			return nil, ErrUnexpectedResponse
+		}
+	}
+}
+
+// parseEntry decodes a search result entry
+func parseEntry(op element) (Entry, error) {
+	es, err := op.children()
+	if err != nil || len(es) != 2 {
+		return Entry{}, ErrMalformedPacket
+	}
+	e := Entry{DN: string(es[0].value), Attributes: map[string][]string{}}
+	attrs, err := es[1].children()
+	if err != nil {
+		return e, ErrMalformedPacket
+	}
+	for _, a := range attrs {
+		parts, err := a.children()
+		if err != nil || len(parts) != 2 {
+			return e, ErrMalformedPacket
+		}
+		vals, err := parts[1].children()
+		if err != nil {
+			return e, ErrMalformedPacket
+		}
+		name := string(parts[0].value)
+		for _, v := range vals {
+			e.Attributes[name] = append(e.Attributes[name], string(v.value))
+		}
+	}
+	return e, nil
+}
+
+// pagingControl encodes the paged results control requesting the page after
+// the given cookie. The control isn't critical, so directories which don't
+// support it return every entry at once.
+func pagingControl(cookie []byte) []byte {
+	value := tlv(tagSequence, append(encodeInt(tagInteger, int64(PageSize)), tlv(tagOctetString, cookie)...))
+	control := encodeString(tagOctetString, oidPagedResults)
+	control = append(control, tlv(tagOctetString, value)...)
+	return tlv(tagSequence, control)
+}
+
+// pagingCookie returns the cookie from the paged results control in the
+// response, which is empty once the last page has been returned
+func pagingCookie(controls []element) []byte {
+	for _, ctrl := range controls {
+		parts, err := ctrl.children()
+		if err != nil || len(parts) < 2 || string(parts[0].value) != oidPagedResults {
+			continue
+		}
+		value := parts[len(parts)-1]
+		v, _, err := decodeElement(value.value)
+		if err != nil {
+			return nil
+		}
+		fields, err := v.children()
+		if err != nil || len(fields) != 2 {
+			return nil
+		}
+		return fields[1].value
+	}
+	return nil
+}
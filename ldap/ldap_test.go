@@ -0,0 +1,189 @@
+package ldap
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LDAPSuite struct {
+	suite.Suite
+}
+
+// message encodes a response from the fake directory
+func message(id int64, op []byte, controls []byte) []byte {
+	msg := append(encodeInt(tagInteger, id), op...)
+	if controls != nil {
+		msg = append(msg, tlv(tagControls, controls)...)
+	}
+	return tlv(tagSequence, msg)
+}
+
+// result encodes an LDAPResult with the given application tag
+func result(tag byte, code int64, diagnostic string) []byte {
+	r := encodeInt(tagEnumerated, code)
+	r = append(r, encodeString(tagOctetString, "")...)
+	r = append(r, encodeString(tagOctetString, diagnostic)...)
+	return tlv(tag, r)
+}
+
+// entry encodes a search result entry
+func entry(dn string, attrs map[string][]string) []byte {
+	as := []byte{}
+	for name, vs := range attrs {
+		vals := []byte{}
+		for _, v := range vs {
+			vals = append(vals, encodeString(tagOctetString, v)...)
+		}
+		as = append(as, tlv(tagSequence, append(encodeString(tagOctetString, name), tlv(tagSet, vals)...))...)
+	}
+	return tlv(appSearchEntry, append(encodeString(tagOctetString, dn), tlv(tagSequence, as)...))
+}
+
+// request reads a request sent to the fake directory, returning its message
+// id, protocol operation and controls
+func request(s *LDAPSuite, r *bufio.Reader) (int64, element, []element) {
+	msg, err := readElement(r)
+	s.Nil(err)
+	es, err := msg.children()
+	s.Nil(err)
+	id, err := es[0].int()
+	s.Nil(err)
+	var controls []element
+	if len(es) > 2 {
+		controls, err = es[2].children()
+		s.Nil(err)
+	}
+	return id, es[1], controls
+}
+
+func (s *LDAPSuite) TestCompileFilter() {
+	for _, tc := range []struct {
+		filter   string
+		expected []byte
+	}{
+		// The example from RFC 4511
+		{"(cn=Babs Jensen)", []byte("\xa3\x11\x04\x02cn\x04\x0bBabs Jensen")},
+		{"cn=Babs Jensen", []byte("\xa3\x11\x04\x02cn\x04\x0bBabs Jensen")},
+		{"(mail=*)", []byte("\x87\x04mail")},
+		{"", []byte("\x87\x0bobjectClass")},
+		{"(cn=J*n*s)", []byte("\xa4\x0f\x04\x02cn\x30\x09\x80\x01J\x81\x01n\x82\x01s")},
+		{"(mail=*@example.com)", []byte("\xa4\x16\x04\x04mail\x30\x0e\x82\x0c@example.com")},
+		{"(uid>=5)", []byte("\xa5\x08\x04\x03uid\x04\x015")},
+		{"(cn=a\\2ab)", []byte("\xa3\x09\x04\x02cn\x04\x03a*b")},
+		{"(!(cn=a))", []byte("\xa2\x09\xa3\x07\x04\x02cn\x04\x01a")},
+		{"(&(cn=a)(|(sn=b)(sn=c)))", []byte("\xa0\x1d\xa3\x07\x04\x02cn\x04\x01a\xa1\x12\xa3\x07\x04\x02sn\x04\x01b\xa3\x07\x04\x02sn\x04\x01c")},
+		{"(memberOf:1.2.3:=cn=g)", []byte("\xa9\x17\x81\x051.2.3\x82\x08memberOf\x83\x04cn=g")},
+		{"(ou:dn:=Sales)", []byte("\xa9\x0e\x82\x02ou\x83\x05Sales\x84\x01\xff")},
+	} {
+		b, err := CompileFilter(tc.filter)
+		s.Nil(err, tc.filter)
+		s.Equal(tc.expected, b, tc.filter)
+	}
+}
+
+func (s *LDAPSuite) TestCompileFilterInvalid() {
+	for _, f := range []string{
+		"(cn=a",
+		"(=a)",
+		"(&)",
+		"(&(cn=a)",
+		"(!(cn=a)(sn=b))",
+		"(cn=a)(sn=b)",
+		"(c n=a)",
+		"(cn=a\\2)",
+		"(cn=a\\zz)",
+		"(cn=**)",
+		"(:=a)",
+		"(cn:a:b:=c)",
+	} {
+		_, err := CompileFilter(f)
+		s.Equal(ErrInvalidFilter, err, f)
+	}
+}
+
+func (s *LDAPSuite) TestBindAndPagedSearch() {
+	client, server := net.Pipe()
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		r := bufio.NewReader(server)
+		id, op, _ := request(s, r)
+		s.Equal(byte(appBindRequest), op.tag)
+		fields, _ := op.children()
+		s.Equal("cn=admin,dc=example,dc=com", string(fields[1].value))
+		s.Equal("secret", string(fields[2].value))
+		server.Write(message(id, result(appBindResponse, 0, ""), nil))
+
+		// The first page is returned with a cookie for the next
+		id, op, controls := request(s, r)
+		s.Equal(byte(appSearchRequest), op.tag)
+		fields, _ = op.children()
+		s.Equal("dc=example,dc=com", string(fields[0].value))
+		s.Equal(byte(filterEquality), fields[6].tag)
+		s.Len(pagingCookie(controls), 0)
+		server.Write(message(id, entry("cn=a,dc=example,dc=com", map[string][]string{"mail": {"a@example.com"}}), nil))
+		server.Write(message(id, tlv(appSearchReference, encodeString(tagOctetString, "ldap://other/")), nil))
+		server.Write(message(id, result(appSearchDone, 0, ""), pagingControl([]byte("next"))))
+
+		id, _, controls = request(s, r)
+		s.Equal([]byte("next"), pagingCookie(controls))
+		server.Write(message(id, entry("cn=b,dc=example,dc=com", map[string][]string{"Mail": {"b@example.com"}, "title": {"x", "y"}}), nil))
+		server.Write(message(id, result(appSearchDone, 0, ""), pagingControl(nil)))
+
+		_, op, _ = request(s, r)
+		s.Equal(byte(appUnbindRequest), op.tag)
+	}()
+	conn := NewConn(client)
+	err := conn.Bind("cn=admin,dc=example,dc=com", "secret")
+	s.Nil(err)
+	es, err := conn.Search("dc=example,dc=com", "(objectClass=person)", []string{"mail", "title"})
+	s.Nil(err)
+	s.Len(es, 2)
+	s.Equal("cn=a,dc=example,dc=com", es[0].DN)
+	s.Equal("a@example.com", es[0].Get("mail"))
+	s.Equal("b@example.com", es[1].Get("mail"))
+	s.Equal([]string{"x", "y"}, es[1].Attributes["title"])
+	s.Equal("", es[1].Get("sn"))
+	conn.Close()
+	<-done
+}
+
+func (s *LDAPSuite) TestBindError() {
+	client, server := net.Pipe()
+	go func() {
+		r := bufio.NewReader(server)
+		id, _, _ := request(s, r)
+		server.Write(message(id, result(appBindResponse, 49, "Invalid credentials"), nil))
+		server.Close()
+	}()
+	conn := NewConn(client)
+	err := conn.Bind("cn=admin,dc=example,dc=com", "wrong")
+	s.Equal(&Error{Code: 49, Message: "Invalid credentials"}, err)
+	s.Equal("LDAP error 49: Invalid credentials", err.Error())
+}
+
+func (s *LDAPSuite) TestEncodeInt() {
+	for n, expected := range map[int64][]byte{
+		0:    {0x02, 0x01, 0x00},
+		127:  {0x02, 0x01, 0x7f},
+		128:  {0x02, 0x02, 0x00, 0x80},
+		256:  {0x02, 0x02, 0x01, 0x00},
+		-1:   {0x02, 0x01, 0xff},
+		-129: {0x02, 0x02, 0xff, 0x7f},
+	} {
+		b := encodeInt(tagInteger, n)
+		s.Equal(expected, b, n)
+		e, _, err := decodeElement(b)
+		s.Nil(err)
+		v, err := e.int()
+		s.Nil(err)
+		s.Equal(n, v)
+	}
+}
+
+func TestLDAPSuite(t *testing.T) {
+	suite.Run(t, new(LDAPSuite))
+}
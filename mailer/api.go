@@ -0,0 +1,118 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// APITimeout is how long to wait for a sending API to accept a message
+var APITimeout = 30 * time.Second
+
+// APIError is returned when a sending API doesn't accept a message.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+// Error returns the response from the sending API
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s returned %d %s", e.Provider, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Message)
+	}
+	return msg
+}
+
+// Temporary returns whether or not the message may be accepted if it's sent
+// again later, such as when the API is rate limiting requests or is
+// unavailable.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// APIProvider sends a raw MIME message through an HTTP API, such as Microsoft
+// Graph or Amazon SES, instead of SMTP.
+type APIProvider interface {
+	SendRaw(client *http.Client, from string, to []string, msg []byte) error
+}
+
+// APIDialer is a Dialer for sending profiles which send their messages
+// through an APIProvider. There's no connection to set up, so dialing always
+// succeeds.
+type APIDialer struct {
+	Provider APIProvider
+	Client   *http.Client
+}
+
+// NewAPIDialer returns an APIDialer for the given provider which waits up to
+// APITimeout for each message to be accepted.
+func NewAPIDialer(p APIProvider) *APIDialer {
+	return &APIDialer{
+		Provider: p,
+		Client:   &http.Client{Timeout: APITimeout},
+	}
+}
+
+// Dial returns a Sender which sends messages through the dialer's provider
+func (d *APIDialer) Dial() (Sender, error) {
+	return &apiSender{dialer: d}, nil
+}
+
+// apiSender sends messages through an APIProvider
+type apiSender struct {
+	dialer *APIDialer
+}
+
+// Send sends the message through the provider
+func (s *apiSender) Send(from string, to []string, msg io.WriterTo) error {
+	buff := &bytes.Buffer{}
+	_, err := msg.WriteTo(buff)
+	if err != nil {
+		return err
+	}
+	return s.dialer.Provider.SendRaw(s.dialer.Client, from, to, buff.Bytes())
+}
+
+// Close is a no-op, since there's no connection to close
+func (s *apiSender) Close() error {
+	return nil
+}
+
+// Reset is a no-op, since there's no connection to reset
+func (s *apiSender) Reset() error {
+	return nil
+}
+
+// maxErrorBody is the most of an error response from a sending API which is
+// included in the returned APIError
+const maxErrorBody = 512
+
+// doAPIRequest sends the request to the provider's API, returning an APIError
+// if the response doesn't have a 2xx status.
+func doAPIRequest(client *http.Client, provider string, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(body) > maxErrorBody {
+			body = body[:maxErrorBody]
+		}
+		return nil, &APIError{
+			Provider:   provider,
+			StatusCode: resp.StatusCode,
+			Message:    string(bytes.TrimSpace(body)),
+		}
+	}
+	return body, nil
+}
@@ -0,0 +1,257 @@
+package mailer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+var testRawMessage = []byte("From: Sender <sender@example.com>\r\n" +
+	"To: to@example.com\r\n" +
+	"Reply-To: reply@example.com\r\n" +
+	"Subject: Test Subject\r\n" +
+	"X-Mailer: gophish\r\n" +
+	"Content-Type: text/html; charset=UTF-8\r\n" +
+	"\r\n" +
+	"<p>Hello</p>\r\n")
+
+type mockAPIProvider struct {
+	err  error
+	sent [][]byte
+}
+
+func (p *mockAPIProvider) SendRaw(client *http.Client, from string, to []string, msg []byte) error {
+	p.sent = append(p.sent, msg)
+	return p.err
+}
+
+func (ms *MailerSuite) TestAPIDialerSend() {
+	p := &mockAPIProvider{}
+	d := NewAPIDialer(p)
+	ms.Equal(APITimeout, d.Client.Timeout)
+	sender, err := d.Dial()
+	ms.Nil(err)
+	err = sender.Send("from@example.com", []string{"to@example.com"}, strings.NewReader(string(testRawMessage)))
+	ms.Nil(err)
+	ms.Equal([][]byte{testRawMessage}, p.sent)
+	ms.Nil(sender.Reset())
+	ms.Nil(sender.Close())
+}
+
+func (ms *MailerSuite) TestAPIErrorTemporary() {
+	ms.True((&APIError{StatusCode: http.StatusTooManyRequests}).Temporary())
+	ms.True((&APIError{StatusCode: http.StatusServiceUnavailable}).Temporary())
+	ms.False((&APIError{StatusCode: http.StatusBadRequest}).Temporary())
+	ms.False((&APIError{StatusCode: http.StatusUnauthorized}).Temporary())
+}
+
+func (ms *MailerSuite) TestDoAPIRequestError() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(strings.Repeat("a", maxErrorBody*2)))
+	}))
+	defer ts.Close()
+	req, _ := http.NewRequest("POST", ts.URL, nil)
+	_, err := doAPIRequest(http.DefaultClient, "Test", req)
+	ae, ok := err.(*APIError)
+	ms.True(ok)
+	ms.Equal(http.StatusBadRequest, ae.StatusCode)
+	ms.Equal("Test", ae.Provider)
+	ms.Equal(maxErrorBody, len(ae.Message))
+}
+
+func (ms *MailerSuite) TestSendMailAPIError() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, tc := range []struct {
+		status  int
+		backoff bool
+	}{
+		{http.StatusTooManyRequests, true},
+		{http.StatusBadGateway, true},
+		{http.StatusBadRequest, false},
+	} {
+		expectedError := &APIError{Provider: "Test", StatusCode: tc.status}
+		dialer := NewAPIDialer(&mockAPIProvider{err: expectedError})
+		messages := generateMessages(dialer)
+		sendMail(ctx, dialer, messages)
+		for _, m := range messages {
+			mm := m.(*mockMessage)
+			ms.Equal(expectedError, mm.err)
+			if tc.backoff {
+				ms.Equal(1, mm.backoffCount)
+				ms.False(mm.finished)
+			} else {
+				ms.Equal(0, mm.backoffCount)
+				ms.True(mm.finished)
+			}
+		}
+	}
+}
+
+func (ms *MailerSuite) TestGraphProvider() {
+	tokenRequests := 0
+	rejectToken := ""
+	sent := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/tenant-id/token":
+			r.ParseForm()
+			ms.Equal("client_credentials", r.Form.Get("grant_type"))
+			ms.Equal("client-id", r.Form.Get("client_id"))
+			ms.Equal("secret", r.Form.Get("client_secret"))
+			tokenRequests++
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token": fmt.Sprintf("token-%d", tokenRequests),
+				"expires_in":   3600,
+			})
+		case r.URL.Path == "/users/sender@example.com/sendMail":
+			if r.Header.Get("Authorization") == "Bearer "+rejectToken {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			ms.Equal("text/plain", r.Header.Get("Content-Type"))
+			body, _ := ioutil.ReadAll(r.Body)
+			msg, err := base64.StdEncoding.DecodeString(string(body))
+			ms.Nil(err)
+			sent = append(sent, string(msg))
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	defer func(tokenURL, endpoint string) {
+		GraphTokenURL = tokenURL
+		GraphEndpoint = endpoint
+	}(GraphTokenURL, GraphEndpoint)
+	GraphTokenURL = ts.URL + "/%s/token"
+	GraphEndpoint = ts.URL
+
+	p := &GraphProvider{Tenant: "tenant-id", ClientID: "client-id", ClientSecret: "secret"}
+	defer p.invalidateToken()
+	to := []string{"to@example.com"}
+
+	// The token should be reused between messages
+	ms.Nil(p.SendRaw(http.DefaultClient, "sender@example.com", to, testRawMessage))
+	ms.Nil(p.SendRaw(http.DefaultClient, "sender@example.com", to, testRawMessage))
+	ms.Equal(1, tokenRequests)
+	ms.Equal([]string{string(testRawMessage), string(testRawMessage)}, sent)
+
+	// A rejected token should be replaced, and the message sent again
+	rejectToken = "token-1"
+	ms.Nil(p.SendRaw(http.DefaultClient, "sender@example.com", to, testRawMessage))
+	ms.Equal(2, tokenRequests)
+	ms.Equal(3, len(sent))
+
+	// An expired token should be replaced
+	graphTokens.Lock()
+	graphTokens.tokens[p.cacheKey()] = graphCachedToken{token: "token-2", expires: time.Now()}
+	graphTokens.Unlock()
+	ms.Nil(p.SendRaw(http.DefaultClient, "sender@example.com", to, testRawMessage))
+	ms.Equal(3, tokenRequests)
+}
+
+func (ms *MailerSuite) TestSESProvider() {
+	var got sesRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ms.Equal("/eu-west-1/v2/email/outbound-emails", r.URL.Path)
+		ms.True(strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access-key/"))
+		ms.Contains(r.Header.Get("Authorization"), "/eu-west-1/ses/aws4_request")
+		ms.NotEqual("", r.Header.Get("X-Amz-Date"))
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+	defer func(endpoint string) {
+		SESEndpoint = endpoint
+	}(SESEndpoint)
+	SESEndpoint = ts.URL + "/%s"
+
+	p := &SESProvider{AccessKeyID: "access-key", SecretAccessKey: "secret", Region: "eu-west-1"}
+	err := p.SendRaw(http.DefaultClient, "sender@example.com", []string{"to@example.com"}, testRawMessage)
+	ms.Nil(err)
+	ms.Equal("sender@example.com", got.FromEmailAddress)
+	ms.Equal([]string{"to@example.com"}, got.Destination.ToAddresses)
+	ms.Equal(testRawMessage, got.Content.Raw.Data)
+}
+
+func (ms *MailerSuite) TestSignV4() {
+	// The get-vanilla case from the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	t, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	signV4(req, []byte{}, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", t)
+	ms.Equal("20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	ms.Equal("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, "+
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
+
+func (ms *MailerSuite) TestMailgunProvider() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ms.Equal("/example.com/messages.mime", r.URL.Path)
+		user, pass, _ := r.BasicAuth()
+		ms.Equal("api", user)
+		ms.Equal("key", pass)
+		err := r.ParseMultipartForm(1 << 20)
+		ms.Nil(err)
+		ms.Equal([]string{"to@example.com", "cc@example.com"}, r.MultipartForm.Value["to"])
+		f, _, err := r.FormFile("message")
+		ms.Nil(err)
+		msg, _ := ioutil.ReadAll(f)
+		ms.Equal(testRawMessage, msg)
+	}))
+	defer ts.Close()
+	defer func(endpoint string) {
+		MailgunEndpoints["eu"] = endpoint
+	}(MailgunEndpoints["eu"])
+	MailgunEndpoints["eu"] = ts.URL
+
+	p := &MailgunProvider{APIKey: "key", Region: "EU"}
+	err := p.SendRaw(http.DefaultClient, "sender@example.com", []string{"to@example.com", "cc@example.com"}, testRawMessage)
+	ms.Nil(err)
+}
+
+func (ms *MailerSuite) TestSendGridProvider() {
+	var got sendGridRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ms.Equal("Bearer key", r.Header.Get("Authorization"))
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	defer func(endpoint string) {
+		SendGridEndpoint = endpoint
+	}(SendGridEndpoint)
+	SendGridEndpoint = ts.URL
+
+	p := &SendGridProvider{APIKey: "key"}
+	err := p.SendRaw(http.DefaultClient, "sender@example.com", []string{"to@example.com"}, testRawMessage)
+	ms.Nil(err)
+	ms.Equal(sendGridAddress{Email: "sender@example.com", Name: "Sender"}, got.From)
+	ms.Equal(&sendGridAddress{Email: "reply@example.com"}, got.ReplyTo)
+	ms.Equal([]sendGridPersonalization{{To: []sendGridAddress{{Email: "to@example.com"}}}}, got.Personalizations)
+	ms.Equal("Test Subject", got.Subject)
+	ms.Equal([]sendGridContent{{Type: "text/html", Value: "<p>Hello</p>\r\n"}}, got.Content)
+	ms.Equal("gophish", got.Headers["X-Mailer"])
+	_, ok := got.Headers["Content-Type"]
+	ms.False(ok)
+
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errors":[{"message":"forbidden"}]}`))
+	})
+	err = p.SendRaw(http.DefaultClient, "sender@example.com", []string{"to@example.com"}, testRawMessage)
+	ae, ok := err.(*APIError)
+	ms.True(ok)
+	ms.Equal(http.StatusForbidden, ae.StatusCode)
+	ms.False(ae.Temporary())
+	ms.Contains(ae.Message, "forbidden")
+}
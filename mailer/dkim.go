@@ -0,0 +1,213 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrInvalidDKIMKey is thrown when a DKIM private key isn't a PEM encoded RSA
+// or Ed25519 key
+var ErrInvalidDKIMKey = errors.New("Invalid DKIM private key")
+
+// DKIMHeaders are the headers included in the DKIM signature, if they're
+// present in the message.
+var DKIMHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-Id",
+	"Mime-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// SigningDialer is a Dialer whose messages are signed before they're handed
+// to the Sender, such as with DKIM.
+type SigningDialer interface {
+	Dialer
+	Sign(msg []byte) ([]byte, error)
+}
+
+// signingSender signs each message before passing it to the wrapped Sender.
+type signingSender struct {
+	Sender
+	dialer SigningDialer
+}
+
+// Send signs the message and sends it with the wrapped Sender.
+func (s *signingSender) Send(from string, to []string, msg io.WriterTo) error {
+	buff := &bytes.Buffer{}
+	_, err := msg.WriteTo(buff)
+	if err != nil {
+		return err
+	}
+	signed, err := s.dialer.Sign(buff.Bytes())
+	if err != nil {
+		return err
+	}
+	return s.Sender.Send(from, to, bytes.NewBuffer(signed))
+}
+
+// signSender returns a Sender which signs messages if the dialer supports it,
+// or the given sender if it doesn't.
+func signSender(sender Sender, dialer Dialer) Sender {
+	sd, ok := dialer.(SigningDialer)
+	if !ok {
+		return sender
+	}
+	return &signingSender{Sender: sender, dialer: sd}
+}
+
+// ParseDKIMKey parses a PEM encoded RSA or Ed25519 private key for signing
+// messages with DKIM.
+func ParseDKIMKey(key string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, ErrInvalidDKIMKey
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidDKIMKey
+	}
+	switch k := k.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+	return nil, ErrInvalidDKIMKey
+}
+
+// DKIMSigner signs messages with a DKIM-Signature header (RFC 6376), using
+// relaxed canonicalization for both the headers and the body.
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	Key      crypto.Signer
+}
+
+// wsp matches runs of whitespace within a header or body line
+var wsp = regexp.MustCompile(`[ \t]+`)
+
+// relaxedHeader returns the header in the relaxed canonical form, without
+// the trailing CRLF.
+func relaxedHeader(name, value string) string {
+	value = strings.Replace(value, "\r\n", "", -1)
+	value = wsp.ReplaceAllString(value, " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(value)
+}
+
+// relaxedBody returns the body in the relaxed canonical form.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(wsp.ReplaceAllString(l, " "), " ")
+	}
+	// Trailing empty lines are ignored
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// header is a single, possibly folded, header field of a message
+type header struct {
+	name  string
+	value string
+}
+
+// splitMessage returns the header fields and body of the message, which must
+// use CRLF line endings.
+func splitMessage(msg []byte) ([]header, []byte) {
+	raw := string(msg)
+	body := ""
+	if i := strings.Index(raw, "\r\n\r\n"); i != -1 {
+		raw, body = raw[:i+2], raw[i+4:]
+	}
+	hs := []header{}
+	for _, line := range strings.SplitAfter(raw, "\r\n") {
+		if line == "" {
+			continue
+		}
+		// Folded lines continue the previous header
+		if (line[0] == ' ' || line[0] == '\t') && len(hs) > 0 {
+			hs[len(hs)-1].value += line
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i == -1 {
+			continue
+		}
+		hs = append(hs, header{name: line[:i], value: line[i+1:]})
+	}
+	for i := range hs {
+		hs[i].value = strings.TrimSuffix(hs[i].value, "\r\n")
+	}
+	return hs, []byte(body)
+}
+
+// Sign returns the message with a DKIM-Signature header prepended.
+func (s *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	// Make sure every line ends with a CRLF
+	msg = bytes.Replace(bytes.Replace(msg, []byte("\r\n"), []byte("\n"), -1), []byte("\n"), []byte("\r\n"), -1)
+	hs, body := splitMessage(msg)
+	bh := sha256.Sum256(relaxedBody(body))
+
+	var algorithm string
+	switch s.Key.(type) {
+	case *rsa.PrivateKey:
+		algorithm = "rsa-sha256"
+	case ed25519.PrivateKey:
+		algorithm = "ed25519-sha256"
+	default:
+		return nil, ErrInvalidDKIMKey
+	}
+
+	// Headers which appear more than once are signed from the bottom up
+	h := sha256.New()
+	names := []string{}
+	for _, name := range DKIMHeaders {
+		for i := len(hs) - 1; i >= 0; i-- {
+			if !strings.EqualFold(strings.TrimSpace(hs[i].name), name) {
+				continue
+			}
+			names = append(names, strings.ToLower(name))
+			io.WriteString(h, relaxedHeader(hs[i].name, hs[i].value)+"\r\n")
+		}
+	}
+	sig := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		algorithm, s.Domain, s.Selector, time.Now().Unix(), strings.Join(names, ":"),
+		base64.StdEncoding.EncodeToString(bh[:]))
+	io.WriteString(h, relaxedHeader("DKIM-Signature", sig))
+	digest := h.Sum(nil)
+
+	var b []byte
+	var err error
+	switch k := s.Key.(type) {
+	case *rsa.PrivateKey:
+		b, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest)
+	case ed25519.PrivateKey:
+		b = ed25519.Sign(k, digest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signed := &bytes.Buffer{}
+	signed.WriteString("DKIM-Signature: " + sig + base64.StdEncoding.EncodeToString(b) + "\r\n")
+	signed.Write(msg)
+	return signed.Bytes(), nil
+}
@@ -0,0 +1,145 @@
+package mailer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"strings"
+)
+
+// mockSigningDialer is a mockDialer which prefixes each message it signs
+type mockSigningDialer struct {
+	*mockDialer
+}
+
+func (md *mockSigningDialer) Sign(msg []byte) ([]byte, error) {
+	return append([]byte("Signed: yes\r\n"), msg...), nil
+}
+
+// dkimTags parses the tags of the DKIM-Signature header at the start of the
+// signed message, returning them along with the rest of the message.
+func dkimTags(signed string) (map[string]string, string) {
+	i := strings.Index(signed, "\r\n")
+	value := strings.TrimPrefix(signed[:i], "DKIM-Signature: ")
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(value, "; ") {
+		kv := strings.SplitN(tag, "=", 2)
+		tags[kv[0]] = kv[1]
+	}
+	return tags, signed[i+2:]
+}
+
+// verifyDKIM returns the digest which the signature in the signed message
+// should cover, along with the signature itself.
+func verifyDKIM(signed string) ([]byte, []byte, map[string]string) {
+	tags, msg := dkimTags(signed)
+	hs, _ := splitMessage([]byte(msg))
+	h := sha256.New()
+	counts := make(map[string]int)
+	for _, name := range strings.Split(tags["h"], ":") {
+		// The nth instance of a header is taken from the bottom up
+		seen := 0
+		for i := len(hs) - 1; i >= 0; i-- {
+			if strings.ToLower(hs[i].name) != name {
+				continue
+			}
+			if seen == counts[name] {
+				io.WriteString(h, relaxedHeader(hs[i].name, hs[i].value)+"\r\n")
+				break
+			}
+			seen++
+		}
+		counts[name]++
+	}
+	sig, _ := base64.StdEncoding.DecodeString(tags["b"])
+	unsigned := strings.TrimPrefix(signed[:strings.Index(signed, "\r\n")], "DKIM-Signature: ")
+	unsigned = unsigned[:strings.LastIndex(unsigned, "; b=")+4]
+	io.WriteString(h, relaxedHeader("DKIM-Signature", unsigned))
+	return h.Sum(nil), sig, tags
+}
+
+func (ms *MailerSuite) TestDKIMCanonicalization() {
+	// The examples from RFC 6376 section 3.4.5
+	ms.Equal("a:X", relaxedHeader("A", " X"))
+	ms.Equal("b:Y Z", relaxedHeader("B ", " Y\t\r\n\tZ  "))
+	ms.Equal(" C\r\nD E\r\n", string(relaxedBody([]byte(" C \r\nD \t E\r\n\r\n\r\n"))))
+	ms.Equal("", string(relaxedBody([]byte("\r\n\r\n"))))
+}
+
+func (ms *MailerSuite) TestDKIMSign() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	ms.Nil(err)
+	signer := &DKIMSigner{Domain: "example.com", Selector: "gophish", Key: key}
+	msg := "From: Foo <foo@example.com>\nTo: bar@example.com\nSubject: Hello\n  World\n\nHi there  \n\n"
+	signed, err := signer.Sign([]byte(msg))
+	ms.Nil(err)
+
+	digest, sig, tags := verifyDKIM(string(signed))
+	ms.Equal("rsa-sha256", tags["a"])
+	ms.Equal("example.com", tags["d"])
+	ms.Equal("gophish", tags["s"])
+	ms.Equal("from:subject:to", tags["h"])
+	bh := sha256.Sum256([]byte("Hi there\r\n"))
+	ms.Equal(base64.StdEncoding.EncodeToString(bh[:]), tags["bh"])
+	ms.Nil(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest, sig))
+
+	// The original message follows the signature, with CRLF line endings
+	_, rest := dkimTags(string(signed))
+	ms.Equal(strings.Replace(msg, "\n", "\r\n", -1), rest)
+
+	// Ed25519 keys are supported too
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	ms.Nil(err)
+	signer.Key = priv
+	signed, err = signer.Sign([]byte(msg))
+	ms.Nil(err)
+	digest, sig, tags = verifyDKIM(string(signed))
+	ms.Equal("ed25519-sha256", tags["a"])
+	ms.True(ed25519.Verify(pub, digest, sig))
+}
+
+func (ms *MailerSuite) TestParseDKIMKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	ms.Nil(err)
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	k, err := ParseDKIMKey(string(pkcs1))
+	ms.Nil(err)
+	ms.IsType(&rsa.PrivateKey{}, k)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	ms.Nil(err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	ms.Nil(err)
+	pkcs8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	k, err = ParseDKIMKey(string(pkcs8))
+	ms.Nil(err)
+	ms.IsType(ed25519.PrivateKey{}, k)
+
+	_, err = ParseDKIMKey("not a key")
+	ms.Equal(ErrInvalidDKIMKey, err)
+}
+
+func (ms *MailerSuite) TestSendMailSigned() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sender := newMockSender()
+	dialer := &mockSigningDialer{newMockDialer()}
+	dialer.setDial(func() (Sender, error) {
+		return sender, nil
+	})
+	messages := generateMessages(dialer)
+	go sendMail(ctx, dialer, messages)
+
+	for message := range sender.messageChan {
+		ms.True(strings.HasPrefix(string(message.message), "Signed: yes\r\n"))
+	}
+	ms.Equal(len(messages), len(sender.messages))
+}
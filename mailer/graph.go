@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GraphTokenURL is the Microsoft identity platform endpoint used to request
+// access tokens, formatted with the tenant
+var GraphTokenURL = "https://login.microsoftonline.com/%s/oauth2/v2.0/token"
+
+// GraphEndpoint is the base URL of the Microsoft Graph API
+var GraphEndpoint = "https://graph.microsoft.com/v1.0"
+
+// tokenExpiryMargin is how long before an access token expires that a new one
+// is requested, so that it doesn't expire mid-request
+const tokenExpiryMargin = time.Minute
+
+// GraphProvider sends messages through the Microsoft Graph sendMail API as
+// the mailbox of the sender, using an application registered in the tenant
+// with the Mail.Send permission. Access tokens are requested with the client
+// credentials grant and reused until they expire.
+type GraphProvider struct {
+	Tenant       string
+	ClientID     string
+	ClientSecret string
+}
+
+// graphCachedToken is an access token along with when it expires
+type graphCachedToken struct {
+	token   string
+	expires time.Time
+}
+
+// graphTokens caches the access tokens for each application, so that they're
+// shared by every dialer using the same credentials
+var graphTokens = struct {
+	sync.Mutex
+	tokens map[string]graphCachedToken
+}{tokens: make(map[string]graphCachedToken)}
+
+// cacheKey returns the key the provider's access token is cached under
+func (p *GraphProvider) cacheKey() string {
+	return strings.Join([]string{p.Tenant, p.ClientID, p.ClientSecret}, "\x00")
+}
+
+// graphToken is the response to an access token request
+type graphToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// accessToken returns a valid access token, requesting a new one if needed.
+func (p *GraphProvider) accessToken(client *http.Client) (string, error) {
+	graphTokens.Lock()
+	defer graphTokens.Unlock()
+	cached, ok := graphTokens.tokens[p.cacheKey()]
+	if ok && time.Now().Add(tokenExpiryMargin).Before(cached.expires) {
+		return cached.token, nil
+	}
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("scope", "https://graph.microsoft.com/.default")
+	tokenURL := fmt.Sprintf(GraphTokenURL, url.PathEscape(p.Tenant))
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := doAPIRequest(client, "Microsoft identity platform", req)
+	if err != nil {
+		return "", err
+	}
+	t := graphToken{}
+	err = json.Unmarshal(body, &t)
+	if err != nil {
+		return "", err
+	}
+	graphTokens.tokens[p.cacheKey()] = graphCachedToken{
+		token:   t.AccessToken,
+		expires: time.Now().Add(time.Duration(t.ExpiresIn) * time.Second),
+	}
+	return t.AccessToken, nil
+}
+
+// invalidateToken discards the cached access token, such as when the API
+// rejects it.
+func (p *GraphProvider) invalidateToken() {
+	graphTokens.Lock()
+	delete(graphTokens.tokens, p.cacheKey())
+	graphTokens.Unlock()
+}
+
+// SendRaw sends the MIME message from the sender's mailbox. The recipients
+// are taken from the message's headers. If the access token is rejected, a
+// new token is requested and the message is sent again.
+func (p *GraphProvider) SendRaw(client *http.Client, from string, to []string, msg []byte) error {
+	err := p.sendRaw(client, from, msg)
+	if ae, ok := err.(*APIError); ok && ae.StatusCode == http.StatusUnauthorized {
+		p.invalidateToken()
+		err = p.sendRaw(client, from, msg)
+	}
+	return err
+}
+
+func (p *GraphProvider) sendRaw(client *http.Client, from string, msg []byte) error {
+	token, err := p.accessToken(client)
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/users/%s/sendMail", GraphEndpoint, url.PathEscape(from))
+	req, err := http.NewRequest("POST", u, strings.NewReader(base64.StdEncoding.EncodeToString(msg)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "text/plain")
+	_, err = doAPIRequest(client, "Microsoft Graph", req)
+	return err
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"net/textproto"
+	"strings"
+	"time"
 
 	"github.com/gophish/gomail"
 	log "github.com/gophish/gophish/logger"
@@ -117,10 +119,13 @@ func dialHost(ctx context.Context, dialer Dialer) (Sender, error) {
 		default:
 			break
 		}
+		start := time.Now()
 		sender, err = dialer.Dial()
 		if err == nil {
+			dialDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
 			break
 		}
+		dialDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
 		sendAttempt++
 		if sendAttempt == MaxReconnectAttempts {
 			err = &ErrMaxConnectAttempts{
@@ -132,17 +137,46 @@ func dialHost(ctx context.Context, dialer Dialer) (Sender, error) {
 	return sender, err
 }
 
+// returnPathSender sends messages with the given envelope sender instead of
+// the message's From address.
+type returnPathSender struct {
+	Sender
+	returnPath string
+}
+
+// Send sends the message from the return path
+func (s *returnPathSender) Send(from string, to []string, msg io.WriterTo) error {
+	return s.Sender.Send(s.returnPath, to, msg)
+}
+
+// withReturnPath returns a Sender which uses the message's Return-Path header
+// as the envelope sender, so that bounces are delivered to it rather than the
+// From address. Sending APIs pick the mailbox or domain to send from using
+// the envelope sender, so their senders are returned as-is and the header is
+// left for the API to handle.
+func withReturnPath(sender Sender, message *gomail.Message) Sender {
+	rp := message.GetHeader("Return-Path")
+	if len(rp) == 0 || rp[0] == "" {
+		return sender
+	}
+	if _, ok := sender.(*apiSender); ok {
+		return sender
+	}
+	return &returnPathSender{Sender: sender, returnPath: strings.Trim(rp[0], "<> ")}
+}
+
 // sendMail attempts to send the provided Mail instances.
 // If the context is cancelled before all of the mail are sent,
 // sendMail just returns and does not modify those emails.
 func sendMail(ctx context.Context, dialer Dialer, ms []Mail) {
-	sender, err := dialHost(ctx, dialer)
+	conn, err := openConnection(ctx, dialer)
 	if err != nil {
 		log.Warn(err)
 		errorMail(err, ms)
 		return
 	}
-	defer sender.Close()
+	// The connection is kept for the next batch, if it can be reused
+	defer func() { releaseConnection(dialer, conn) }()
 	message := gomail.NewMessage()
 	for i, m := range ms {
 		select {
@@ -151,6 +185,23 @@ func sendMail(ctx context.Context, dialer Dialer, ms []Mail) {
 		default:
 			break
 		}
+		if conn == nil {
+			return
+		}
+		// Connections which have sent as many messages as they're allowed
+		// to are replaced
+		if conn.exhausted() {
+			conn.Close()
+			conn, err = openConnection(ctx, dialer)
+			if err != nil {
+				log.Warn(err)
+				errorMail(err, ms[i:])
+				return
+			}
+			if conn == nil {
+				return
+			}
+		}
 		message.Reset()
 
 		err = m.Generate(message)
@@ -160,8 +211,23 @@ func sendMail(ctx context.Context, dialer Dialer, ms []Mail) {
 			continue
 		}
 
-		err = gomail.Send(sender, message)
+		err = gomail.Send(signSender(withReturnPath(conn.Sender, message), dialer), message)
 		if err != nil {
+			if ae, ok := err.(*APIError); ok {
+				log.WithFields(logrus.Fields{
+					"code":  ae.StatusCode,
+					"email": message.GetHeader("To")[0],
+				}).Warn(err)
+				// Rate limiting and server errors from a sending API are
+				// temporary, so we'll backoff and try again later. Anything
+				// else means the API won't accept the message.
+				if ae.Temporary() {
+					m.Backoff(err)
+				} else {
+					m.Error(err)
+				}
+				continue
+			}
 			if te, ok := err.(*textproto.Error); ok {
 				switch {
 				// If it's a temporary error, we should backoff and try again later.
@@ -173,7 +239,7 @@ func sendMail(ctx context.Context, dialer Dialer, ms []Mail) {
 						"email": message.GetHeader("To")[0],
 					}).Warn(err)
 					m.Backoff(err)
-					sender.Reset()
+					conn.Reset()
 					continue
 				// Otherwise, if it's a permanent error, we shouldn't backoff this message,
 				// since the RFC specifies that running the same commands won't work next time.
@@ -184,7 +250,7 @@ func sendMail(ctx context.Context, dialer Dialer, ms []Mail) {
 						"email": message.GetHeader("To")[0],
 					}).Warn(err)
 					m.Error(err)
-					sender.Reset()
+					conn.Reset()
 					continue
 				// If something else happened, let's just error out and reset the
 				// sender
@@ -194,7 +260,7 @@ func sendMail(ctx context.Context, dialer Dialer, ms []Mail) {
 						"email": message.GetHeader("To")[0],
 					}).Warn(err)
 					m.Error(err)
-					sender.Reset()
+					conn.Reset()
 					continue
 				}
 			} else {
@@ -205,7 +271,7 @@ func sendMail(ctx context.Context, dialer Dialer, ms []Mail) {
 					"email": message.GetHeader("To")[0],
 				}).Warn(err)
 				origErr := err
-				sender, err = dialHost(ctx, dialer)
+				conn, err = openConnection(ctx, dialer)
 				if err != nil {
 					errorMail(err, ms[i:])
 					break
@@ -217,6 +283,7 @@ func sendMail(ctx context.Context, dialer Dialer, ms []Mail) {
 		log.WithFields(logrus.Fields{
 			"email": message.GetHeader("To")[0],
 		}).Info("Email sent")
+		conn.sent++
 		m.Success()
 	}
 }
@@ -9,6 +9,9 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/gophish/gomail"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -286,6 +289,56 @@ func (ms *MailerSuite) TestUnknownError() {
 	}
 }
 
+func (ms *MailerSuite) TestReturnPath() {
+	message := gomail.NewMessage()
+	message.SetHeader("From", "from@example.com")
+	message.SetHeader("To", "to@example.com")
+
+	// Messages without a Return-Path are sent from their From address
+	sender := newMockSender()
+	go func() {
+		ms.Nil(gomail.Send(withReturnPath(sender, message), message))
+		sender.Close()
+	}()
+	got := <-sender.messageChan
+	ms.Equal("from@example.com", got.from)
+
+	message.SetHeader("Return-Path", "<bounces+abc1234@example.com>")
+	sender = newMockSender()
+	go func() {
+		ms.Nil(gomail.Send(withReturnPath(sender, message), message))
+		sender.Close()
+	}()
+	got = <-sender.messageChan
+	ms.Equal("bounces+abc1234@example.com", got.from)
+
+	// Sending APIs are left to handle the Return-Path themselves
+	api := &apiSender{dialer: NewAPIDialer(&mockAPIProvider{})}
+	ms.Equal(Sender(api), withReturnPath(api, message))
+}
+
 func TestMailerSuite(t *testing.T) {
 	suite.Run(t, new(MailerSuite))
 }
+
+// dialCount returns the number of dials observed with the given result
+func dialCount(result string) uint64 {
+	m := &dto.Metric{}
+	dialDuration.WithLabelValues(result).(prometheus.Histogram).Write(m)
+	return m.GetHistogram().GetSampleCount()
+}
+
+func (ms *MailerSuite) TestDialMetrics() {
+	succeeded := dialCount("success")
+	failed := dialCount("error")
+	dialer := newMockDialer()
+	_, err := dialHost(context.Background(), dialer)
+	ms.Nil(err)
+	ms.Equal(succeeded+1, dialCount("success"))
+
+	dialer.setDial(dialer.unreachableDial)
+	_, err = dialHost(context.Background(), dialer)
+	ms.NotNil(err)
+	ms.Equal(succeeded+1, dialCount("success"))
+	ms.Equal(failed+uint64(MaxReconnectAttempts), dialCount("error"))
+}
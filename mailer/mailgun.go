@@ -0,0 +1,62 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MailgunEndpoints are the base URLs of the Mailgun API for each region
+var MailgunEndpoints = map[string]string{
+	"us": "https://api.mailgun.net/v3",
+	"eu": "https://api.eu.mailgun.net/v3",
+}
+
+// MailgunProvider sends raw MIME messages through the Mailgun messages API.
+// The sending domain is taken from the sender's address. The region defaults
+// to "us".
+type MailgunProvider struct {
+	APIKey string
+	Region string
+}
+
+// SendRaw sends the MIME message to the given recipients
+func (p *MailgunProvider) SendRaw(client *http.Client, from string, to []string, msg []byte) error {
+	endpoint, ok := MailgunEndpoints[strings.ToLower(p.Region)]
+	if !ok {
+		endpoint = MailgunEndpoints["us"]
+	}
+	domain := from[strings.LastIndex(from, "@")+1:]
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	for _, addr := range to {
+		err := w.WriteField("to", addr)
+		if err != nil {
+			return err
+		}
+	}
+	part, err := w.CreateFormFile("message", "message.mime")
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(msg)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/%s/messages.mime", endpoint, url.PathEscape(domain))
+	req, err := http.NewRequest("POST", u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.SetBasicAuth("api", p.APIKey)
+	_, err = doAPIRequest(client, "Mailgun", req)
+	return err
+}
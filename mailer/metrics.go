@@ -0,0 +1,36 @@
+package mailer
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// dialDuration observes how long each connection to a mail server or sending
+// API takes to establish, labeled by whether it succeeded.
+var dialDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "gophish_smtp_dial_duration_seconds",
+	Help:    "How long connecting to the sending profile's server took, by result.",
+	Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+}, []string{"result"})
+
+// connections counts the connections batches of mail are sent over, labeled
+// by whether the connection was dialed or reused from the pool.
+var connections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "gophish_smtp_connections_total",
+	Help: "The number of connections emails were sent over, by whether they were dialed or reused.",
+}, []string{"source"})
+
+// idleConnections is the number of pooled connections waiting to be reused.
+var idleConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "gophish_smtp_idle_connections",
+	Help: "The number of connections to mail servers kept open for reuse.",
+})
+
+// RegisterMetrics registers the collectors for the mailer's metrics with the
+// given registerer. It should be called once at startup.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{dialDuration, connections, idleConnections} {
+		err := reg.Register(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
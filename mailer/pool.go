@@ -0,0 +1,162 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultMaxMessagesPerConnection is the most messages sent over a pooled
+// connection before it's closed, for sending profiles which don't set their
+// own limit. Connections are used for any number of messages if it's 0.
+var DefaultMaxMessagesPerConnection = 0
+
+// DefaultConnectionIdleTimeout is how long a pooled connection is kept open
+// once it's idle, for sending profiles which don't set their own timeout.
+var DefaultConnectionIdleTimeout = 30 * time.Second
+
+// PoolSettings are the limits on how a sending profile's connections are
+// reused. Zero values use the defaults.
+type PoolSettings struct {
+	// MaxMessages is the most messages sent over a single connection
+	MaxMessages int
+	// IdleTimeout is how long an idle connection is kept open
+	IdleTimeout time.Duration
+}
+
+// PooledDialer is a Dialer whose connections are kept open once a batch of
+// mail has been sent, so that later batches for the same server reuse them
+// instead of connecting again.
+type PooledDialer interface {
+	Dialer
+	// PoolKey identifies the server and credentials the dialer connects
+	// with, since connections are only reused by dialers with the same key.
+	// Connections aren't pooled if it's empty.
+	PoolKey() string
+	// PoolSettings returns the limits on reusing the dialer's connections
+	PoolSettings() PoolSettings
+}
+
+// pooledConn is a connection which can be returned to the pool, along with
+// the number of messages sent over it
+type pooledConn struct {
+	Sender
+	key   string
+	max   int
+	sent  int
+	timer *time.Timer
+}
+
+// exhausted returns whether the connection has sent as many messages as it's
+// allowed to
+func (c *pooledConn) exhausted() bool {
+	return c.max > 0 && c.sent >= c.max
+}
+
+// connPool holds the idle connections to each server
+type connPool struct {
+	mu   sync.Mutex
+	idle map[string][]*pooledConn
+}
+
+// pool is the pool of idle connections shared by every batch of mail
+var pool = &connPool{idle: make(map[string][]*pooledConn)}
+
+// get returns an idle connection with the given key which is still usable,
+// or nil if there aren't any
+func (p *connPool) get(key string) *pooledConn {
+	for {
+		p.mu.Lock()
+		conns := p.idle[key]
+		if len(conns) == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		c := conns[len(conns)-1]
+		p.idle[key] = conns[:len(conns)-1]
+		idleConnections.Dec()
+		p.mu.Unlock()
+		c.timer.Stop()
+		// The server may have closed the connection while it was idle
+		if c.Reset() == nil {
+			return c
+		}
+		c.Close()
+	}
+}
+
+// put adds the connection to the pool, closing it once it's been idle for
+// the given timeout
+func (p *connPool) put(c *pooledConn, timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle[c.key] = append(p.idle[c.key], c)
+	idleConnections.Inc()
+	c.timer = time.AfterFunc(timeout, func() { p.expire(c) })
+}
+
+// expire closes the connection if it's still idle
+func (p *connPool) expire(c *pooledConn) {
+	p.mu.Lock()
+	conns := p.idle[c.key]
+	for i, ic := range conns {
+		if ic == c {
+			p.idle[c.key] = append(conns[:i], conns[i+1:]...)
+			idleConnections.Dec()
+			p.mu.Unlock()
+			c.Close()
+			return
+		}
+	}
+	p.mu.Unlock()
+}
+
+// poolSettings returns the dialer's pool key and limits, with the defaults
+// applied. The key is empty for dialers whose connections aren't pooled.
+func poolSettings(dialer Dialer) (string, PoolSettings) {
+	settings := PoolSettings{}
+	pd, ok := dialer.(PooledDialer)
+	if !ok {
+		return "", settings
+	}
+	settings = pd.PoolSettings()
+	if settings.MaxMessages == 0 {
+		settings.MaxMessages = DefaultMaxMessagesPerConnection
+	}
+	if settings.IdleTimeout == 0 {
+		settings.IdleTimeout = DefaultConnectionIdleTimeout
+	}
+	return pd.PoolKey(), settings
+}
+
+// openConnection returns a connection to the dialer's server, reusing an idle
+// one from the pool if there is one.
+func openConnection(ctx context.Context, dialer Dialer) (*pooledConn, error) {
+	key, settings := poolSettings(dialer)
+	if key != "" {
+		if c := pool.get(key); c != nil {
+			connections.WithLabelValues("reused").Inc()
+			return c, nil
+		}
+	}
+	sender, err := dialHost(ctx, dialer)
+	if err != nil || sender == nil {
+		return nil, err
+	}
+	connections.WithLabelValues("dialed").Inc()
+	return &pooledConn{Sender: sender, key: key, max: settings.MaxMessages}, nil
+}
+
+// releaseConnection returns the connection to the pool once a batch of mail
+// has been sent, or closes it if it can't be reused.
+func releaseConnection(dialer Dialer, c *pooledConn) {
+	if c == nil {
+		return
+	}
+	_, settings := poolSettings(dialer)
+	if c.key == "" || c.exhausted() {
+		c.Close()
+		return
+	}
+	pool.put(c, settings.IdleTimeout)
+}
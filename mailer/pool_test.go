@@ -0,0 +1,113 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+)
+
+// mockPooledDialer is a mockDialer whose connections are pooled
+type mockPooledDialer struct {
+	*mockDialer
+	key      string
+	settings PoolSettings
+	senders  []*mockSender
+}
+
+func newMockPooledDialer(key string, settings PoolSettings) *mockPooledDialer {
+	pd := &mockPooledDialer{mockDialer: newMockDialer(), key: key, settings: settings}
+	pd.setDial(func() (Sender, error) {
+		sender := newMockSender()
+		sender.setSend(func(*mockMessage) error { return nil })
+		pd.senders = append(pd.senders, sender)
+		return sender, nil
+	})
+	return pd
+}
+
+func (pd *mockPooledDialer) PoolKey() string {
+	return pd.key
+}
+
+func (pd *mockPooledDialer) PoolSettings() PoolSettings {
+	return pd.settings
+}
+
+// generatePooledMessages returns n messages to send with the dialer
+func generatePooledMessages(n int) []Mail {
+	ms := []Mail{}
+	for i := 0; i < n; i++ {
+		ms = append(ms, newMockMessage("from@example.com", []string{"to@example.com"}, bytes.NewBuffer([]byte("Email"))))
+	}
+	return ms
+}
+
+func (ms *MailerSuite) TestConnectionPoolReuse() {
+	ctx := context.Background()
+	dialer := newMockPooledDialer("reuse", PoolSettings{IdleTimeout: time.Hour})
+
+	// Later batches are sent over the connection opened by the first
+	sendMail(ctx, dialer, generatePooledMessages(2))
+	sendMail(ctx, dialer, generatePooledMessages(2))
+	ms.Equal(dialer.dialCount, 1)
+	ms.Equal(len(dialer.senders[0].messages), 4)
+	ms.Equal(dialer.senders[0].resetCount, 1)
+
+	// Dialers with a different key don't share the connection
+	other := newMockPooledDialer("other", PoolSettings{IdleTimeout: time.Hour})
+	sendMail(ctx, other, generatePooledMessages(1))
+	ms.Equal(other.dialCount, 1)
+
+	// Dialers which aren't pooled close their connection
+	sender := newMockSender()
+	sender.setSend(func(*mockMessage) error { return nil })
+	unpooled := newMockDialer()
+	unpooled.setDial(func() (Sender, error) { return sender, nil })
+	sendMail(ctx, unpooled, generatePooledMessages(1))
+	ms.Equal(sender.status, "closed")
+}
+
+func (ms *MailerSuite) TestConnectionPoolLimits() {
+	ctx := context.Background()
+	dialer := newMockPooledDialer("limits", PoolSettings{MaxMessages: 3, IdleTimeout: 20 * time.Millisecond})
+
+	// Connections are replaced once they've sent the most messages they're
+	// allowed to
+	sendMail(ctx, dialer, generatePooledMessages(4))
+	ms.Equal(dialer.dialCount, 2)
+	ms.Equal(len(dialer.senders[0].messages), 3)
+	ms.Equal(dialer.senders[0].status, "closed")
+	ms.Equal(len(dialer.senders[1].messages), 1)
+
+	// Idle connections are closed after the timeout
+	deadline := time.Now().Add(time.Second)
+	for dialer.senders[1].status != "closed" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	ms.Equal(dialer.senders[1].status, "closed")
+	sendMail(ctx, dialer, generatePooledMessages(1))
+	ms.Equal(dialer.dialCount, 3)
+}
+
+// brokenSender is a mockSender whose connection was closed by the server
+type brokenSender struct {
+	*mockSender
+}
+
+func (bs *brokenSender) Reset() error {
+	return io.EOF
+}
+
+func (ms *MailerSuite) TestConnectionPoolDropsBrokenConnections() {
+	ctx := context.Background()
+	dialer := newMockPooledDialer("broken", PoolSettings{IdleTimeout: time.Hour})
+	sendMail(ctx, dialer, generatePooledMessages(1))
+	// Swap the idle connection for one the server has since closed
+	ms.NotNil(pool.get("broken"))
+	pool.put(&pooledConn{Sender: &brokenSender{dialer.senders[0]}, key: "broken"}, time.Hour)
+
+	sendMail(ctx, dialer, generatePooledMessages(1))
+	ms.Equal(dialer.dialCount, 2)
+	ms.Equal(dialer.senders[0].status, "closed")
+}
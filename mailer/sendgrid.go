@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/jordan-wright/email"
+)
+
+// SendGridEndpoint is the URL of the SendGrid v3 mail send API
+var SendGridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+
+// sendGridExcludedHeaders are the headers which SendGrid sets itself, and so
+// aren't passed along with the message
+var sendGridExcludedHeaders = map[string]bool{
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"Date":                      true,
+	"Dkim-Signature":            true,
+}
+
+// SendGridProvider sends messages through the SendGrid v3 mail send API.
+// SendGrid doesn't accept raw MIME messages, so the message is parsed into
+// its subject, bodies, attachments and custom headers.
+type SendGridProvider struct {
+	APIKey string
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridAttachment struct {
+	Content     []byte `json:"content"`
+	Filename    string `json:"filename"`
+	Type        string `json:"type,omitempty"`
+	Disposition string `json:"disposition"`
+}
+
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+}
+
+type sendGridRequest struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+// newSendGridAddress parses the address, falling back to using it as-is
+func newSendGridAddress(addr string) sendGridAddress {
+	a, err := mail.ParseAddress(addr)
+	if err != nil {
+		return sendGridAddress{Email: strings.TrimSpace(addr)}
+	}
+	return sendGridAddress{Email: a.Address, Name: a.Name}
+}
+
+// SendRaw sends the MIME message to the given recipients
+func (p *SendGridProvider) SendRaw(client *http.Client, from string, to []string, msg []byte) error {
+	e, err := email.NewEmailFromReader(bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	sr := sendGridRequest{
+		From:    newSendGridAddress(e.From),
+		Subject: e.Subject,
+		Headers: make(map[string]string),
+	}
+	if e.From == "" {
+		sr.From = sendGridAddress{Email: from}
+	}
+	pz := sendGridPersonalization{}
+	for _, addr := range to {
+		pz.To = append(pz.To, sendGridAddress{Email: addr})
+	}
+	sr.Personalizations = []sendGridPersonalization{pz}
+	if len(e.ReplyTo) > 0 {
+		rt := newSendGridAddress(e.ReplyTo[0])
+		sr.ReplyTo = &rt
+	}
+	// SendGrid requires the plain text body to come first
+	if len(e.Text) > 0 {
+		sr.Content = append(sr.Content, sendGridContent{Type: "text/plain", Value: string(e.Text)})
+	}
+	if len(e.HTML) > 0 {
+		sr.Content = append(sr.Content, sendGridContent{Type: "text/html", Value: string(e.HTML)})
+	}
+	for _, a := range e.Attachments {
+		sr.Attachments = append(sr.Attachments, sendGridAttachment{
+			Content:     a.Content,
+			Filename:    a.Filename,
+			Type:        strings.TrimSpace(strings.Split(a.Header.Get("Content-Type"), ";")[0]),
+			Disposition: "attachment",
+		})
+	}
+	for key, values := range e.Headers {
+		if sendGridExcludedHeaders[key] || len(values) == 0 {
+			continue
+		}
+		sr.Headers[key] = values[0]
+	}
+	body, err := json.Marshal(sr)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", SendGridEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	_, err = doAPIRequest(client, "SendGrid", req)
+	return err
+}
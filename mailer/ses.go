@@ -0,0 +1,124 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SESEndpoint is the base URL of the Amazon SES v2 API, formatted with the
+// region
+var SESEndpoint = "https://email.%s.amazonaws.com"
+
+// SESProvider sends messages through the Amazon SES v2 SendEmail API as raw
+// MIME messages, signing each request with AWS Signature Version 4.
+type SESProvider struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+}
+
+// sesRequest is the body of a SendEmail request with raw content
+type sesRequest struct {
+	FromEmailAddress string `json:"FromEmailAddress"`
+	Destination      struct {
+		ToAddresses []string `json:"ToAddresses"`
+	} `json:"Destination"`
+	Content struct {
+		Raw struct {
+			Data []byte `json:"Data"`
+		} `json:"Raw"`
+	} `json:"Content"`
+}
+
+// SendRaw sends the MIME message to the given recipients
+func (p *SESProvider) SendRaw(client *http.Client, from string, to []string, msg []byte) error {
+	sr := sesRequest{FromEmailAddress: from}
+	sr.Destination.ToAddresses = to
+	sr.Content.Raw.Data = msg
+	body, err := json.Marshal(sr)
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf(SESEndpoint, p.Region) + "/v2/email/outbound-emails"
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signV4(req, body, p.AccessKeyID, p.SecretAccessKey, p.Region, "ses", time.Now())
+	_, err = doAPIRequest(client, "Amazon SES", req)
+	return err
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// signV4 adds the X-Amz-Date and Authorization headers to the request, signing
+// its host, date and body with AWS Signature Version 4 for the given service.
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region, service string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{
+		"host":       req.URL.Host,
+		"x-amz-date": amzDate,
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + strings.TrimSpace(headers[name]) + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
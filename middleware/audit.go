@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	ctx "github.com/gophish/gophish/context"
+	"github.com/gophish/gophish/models"
+	"github.com/gorilla/mux"
+)
+
+// maxAuditResponse is the largest response body kept to record a created
+// resource in the audit log
+const maxAuditResponse = 1 << 20
+
+// AuditActionNames maps the actions derived from requests to the names they're
+// recorded under, where they differ.
+var AuditActionNames = map[string]string{
+	"campaigns.create": "campaigns.launch",
+	"register.create":  "users.create",
+	"reset.create":     "keys.reset",
+}
+
+// auditLoaders load the current state of each kind of resource, which is
+// recorded in the audit log before and after it's changed.
+var auditLoaders = map[string]func(id int64, uid int64) (interface{}, error){
+	"campaigns": func(id int64, uid int64) (interface{}, error) {
+		c, err := models.GetCampaign(id, uid)
+		// Results and events change as the campaign runs, and would make
+		// each entry very large
+		c.Results = nil
+		c.Events = nil
+		return c, err
+	},
+	"groups": func(id int64, uid int64) (interface{}, error) {
+		return models.GetGroup(id, uid)
+	},
+	"templates": func(id int64, uid int64) (interface{}, error) {
+		return models.GetTemplate(id, uid)
+	},
+	"pages": func(id int64, uid int64) (interface{}, error) {
+		return models.GetPage(id, uid)
+	},
+	"smtp": func(id int64, uid int64) (interface{}, error) {
+		return models.GetSMTP(id, uid)
+	},
+	"sms": func(id int64, uid int64) (interface{}, error) {
+		return models.GetSMSProfile(id, uid)
+	},
+	"keys": func(id int64, uid int64) (interface{}, error) {
+		return models.GetAPIKey(id, uid)
+	},
+	"users": func(id int64, uid int64) (interface{}, error) {
+		return models.GetUser(id)
+	},
+	"teams": func(id int64, uid int64) (interface{}, error) {
+		return models.GetTeam(id)
+	},
+	"webhooks": func(id int64, uid int64) (interface{}, error) {
+		return models.GetWebhook(id, uid)
+	},
+	"dead_letters": func(id int64, uid int64) (interface{}, error) {
+		return models.GetWebhookDeadLetter(id, uid)
+	},
+	"notifications": func(id int64, uid int64) (interface{}, error) {
+		return models.GetNotification(id, uid)
+	},
+	"schedules": func(id int64, uid int64) (interface{}, error) {
+		return models.GetCampaignSchedule(id, uid)
+	},
+	"directories": func(id int64, uid int64) (interface{}, error) {
+		return models.GetDirectory(id, uid)
+	},
+}
+
+// auditResponseWriter records the status and body of a response
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.body.Len()+len(b) <= maxAuditResponse {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// auditAction returns the resource and action for the request, such as
+// "campaigns" and "campaigns.complete" for a POST to
+// /api/campaigns/1/complete.
+func auditAction(r *http.Request) (string, string) {
+	segments := []string{}
+	for _, s := range strings.Split(strings.TrimPrefix(r.URL.Path, "/api"), "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	if len(segments) == 0 {
+		return "", ""
+	}
+	resource := segments[0]
+	names := []string{}
+	for _, s := range segments[1:] {
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			names = append(names, s)
+		}
+	}
+	verb := strings.Join(names, ".")
+	if verb == "" {
+		switch r.Method {
+		case "POST":
+			verb = "create"
+		case "PUT":
+			verb = "update"
+		case "DELETE":
+			verb = "delete"
+		default:
+			verb = strings.ToLower(r.Method)
+		}
+	}
+	action := resource + "." + verb
+	if name, ok := AuditActionNames[action]; ok {
+		action = name
+		resource = strings.Split(name, ".")[0]
+	}
+	return resource, action
+}
+
+// Audit records every state-changing request in the audit log, with the
+// user who made it, the IP address it came from and, for resources which
+// can be loaded, the resource before and after the change. GET and HEAD
+// requests aren't recorded. It must be used after RequireAPIKey or
+// RequireLogin, so that the user is known.
+func Audit(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		a := models.AuditLog{Method: r.Method, Path: r.URL.Path, IP: r.RemoteAddr}
+		if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			a.IP = ip
+		}
+		if uid, ok := ctx.Get(r, "user_id").(int64); ok {
+			a.UserId = uid
+		} else if u, ok := ctx.Get(r, "user").(models.User); ok {
+			a.UserId = u.Id
+		}
+		if u, err := models.GetUser(a.UserId); err == nil {
+			a.Username = u.Username
+		}
+		a.Resource, a.Action = auditAction(r)
+		a.ResourceId, _ = strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		load, canLoad := auditLoaders[a.Resource]
+
+		var before interface{}
+		if canLoad && a.ResourceId != 0 {
+			if b, err := load(a.ResourceId, a.UserId); err == nil {
+				before = b
+			}
+		}
+		aw := &auditResponseWriter{ResponseWriter: w}
+		handler.ServeHTTP(aw, r)
+		a.Status = aw.status
+		if a.Status == 0 {
+			a.Status = http.StatusOK
+		}
+
+		// Resources created by the request are identified by the response
+		var after interface{}
+		body := map[string]interface{}{}
+		isObject := json.Unmarshal(aw.body.Bytes(), &body) == nil
+		_, isMessage := body["success"]
+		if a.ResourceId == 0 && isObject && !isMessage {
+			if id, ok := body["id"].(float64); ok {
+				a.ResourceId = int64(id)
+			}
+		}
+		if a.Status < 400 {
+			switch {
+			case canLoad && a.ResourceId != 0:
+				if af, err := load(a.ResourceId, a.UserId); err == nil {
+					after = af
+				}
+			case isObject && !isMessage:
+				after = aw.body.Bytes()
+			}
+		}
+		models.RecordAuditLog(&a, before, after)
+	}
+}
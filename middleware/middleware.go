@@ -2,14 +2,17 @@ package middleware
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/gophish/gophish/auth"
+	"github.com/gophish/gophish/config"
 	ctx "github.com/gophish/gophish/context"
 	"github.com/gophish/gophish/models"
 	"github.com/gorilla/csrf"
+	"github.com/gorilla/sessions"
 )
 
 var CSRFExemptPrefixes = []string{
@@ -85,21 +88,152 @@ func RequireAPIKey(handler http.Handler) http.HandlerFunc {
 			return
 		}
 		u, err := models.GetUserByAPIKey(ak)
+		if err == nil {
+			if twoFactorMissing(u) {
+				JSONError(w, 403, ErrTwoFactorRequired.Error())
+				return
+			}
+			r = ctx.Set(r, "user_id", u.Id)
+			r = ctx.Set(r, "user_role", u.Role)
+			r = ctx.Set(r, "api_key", ak)
+			handler.ServeHTTP(w, r)
+			return
+		}
+		// Otherwise, it may be one of the user's scoped API keys
+		k, err := models.GetAPIKeyByKey(ak)
+		if err == models.ErrAPIKeyExpired || err == models.ErrAPIKeyRevoked {
+			JSONError(w, 401, err.Error())
+			return
+		}
 		if err != nil {
 			JSONError(w, 400, "Invalid API Key")
 			return
 		}
-		r = ctx.Set(r, "user_id", u.Id)
+		u, err = models.GetUser(k.UserId)
+		if err != nil {
+			JSONError(w, 400, "Invalid API Key")
+			return
+		}
+		if twoFactorMissing(u) {
+			JSONError(w, 403, ErrTwoFactorRequired.Error())
+			return
+		}
+		r = ctx.Set(r, "user_id", k.UserId)
+		r = ctx.Set(r, "user_role", u.Role)
 		r = ctx.Set(r, "api_key", ak)
+		r = ctx.Set(r, "scoped_api_key", k)
 		handler.ServeHTTP(w, r)
 	}
 }
 
+// ErrTwoFactorRequired is returned to API requests made with the key of a user
+// who hasn't set up two-factor authentication when it's required
+var ErrTwoFactorRequired = errors.New("Two-factor authentication must be enabled to use the API")
+
+// twoFactorMissing returns whether or not two-factor authentication is
+// required and the user hasn't set it up. Users linked to the single sign-on
+// provider are left to its multi-factor authentication, as they are when
+// they log in.
+func twoFactorMissing(u models.User) bool {
+	return config.Conf.AdminConf.Require2FA && !u.TOTPEnabled && u.SSOSubject == ""
+}
+
+// RequireScope returns a middleware which checks that scoped API keys have
+// access to the given resource. GET requests need the resource's read scope,
+// such as "campaigns:read", and any other requests need its write scope. The
+// user's own API key has access to every resource. Auditors are only allowed
+// to make GET requests, whichever key they use. It must be used after
+// RequireAPIKey.
+func RequireScope(resource string) func(http.Handler) http.HandlerFunc {
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			read := r.Method == "GET" || r.Method == "HEAD"
+			if ctx.Get(r, "user_role") == models.ROLE_AUDITOR && !read {
+				JSONError(w, 403, "Auditors have read-only access")
+				return
+			}
+			k, ok := ctx.Get(r, "scoped_api_key").(models.APIKey)
+			if !ok {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			scope := resource + ":write"
+			if read {
+				scope = resource + ":read"
+			}
+			if !k.HasScope(scope) {
+				JSONError(w, 403, fmt.Sprintf("API key doesn't have the %s scope", scope))
+				return
+			}
+			handler.ServeHTTP(w, r)
+		}
+	}
+}
+
+// RequireRole returns a middleware which checks that the user making the
+// request has one of the given roles. API requests are checked using the
+// role set by RequireAPIKey, and other requests using the logged in user, so
+// it must be used after RequireAPIKey or RequireLogin.
+func RequireRole(roles ...string) func(http.Handler) http.HandlerFunc {
+	allowed := func(role string) bool {
+		for _, r := range roles {
+			if r == role {
+				return true
+			}
+		}
+		return false
+	}
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if role, ok := ctx.Get(r, "user_role").(string); ok {
+				if !allowed(role) {
+					JSONError(w, 403, "You don't have permission to access this resource")
+					return
+				}
+				handler.ServeHTTP(w, r)
+				return
+			}
+			u, ok := ctx.Get(r, "user").(models.User)
+			if !ok || !allowed(u.Role) {
+				http.Error(w, "You don't have permission to access this page", http.StatusForbidden)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		}
+	}
+}
+
+// RequireAdmin checks that the user making the request is an administrator.
+func RequireAdmin(handler http.Handler) http.HandlerFunc {
+	return RequireRole(models.ROLE_ADMIN)(handler)
+}
+
+// TwoFactorEnrollmentPaths are the pages users without two-factor
+// authentication can still access when it's required, so that they can
+// set it up.
+var TwoFactorEnrollmentPaths = []string{
+	"/settings",
+	"/settings/2fa",
+	"/logout",
+}
+
 // RequireLogin is a simple middleware which checks to see if the user is currently logged in.
-// If not, the function returns a 302 redirect to the login page.
+// If not, the function returns a 302 redirect to the login page. If two-factor authentication
+// is required and the user hasn't set it up, they're redirected to the settings page. Users
+// who logged in through single sign-on are left to the provider's multi-factor authentication.
 func RequireLogin(handler http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if u := ctx.Get(r, "user"); u != nil {
+		if u, ok := ctx.Get(r, "user").(models.User); ok {
+			if config.Conf.AdminConf.Require2FA && !u.TOTPEnabled && !ssoSession(r) {
+				for _, path := range TwoFactorEnrollmentPaths {
+					if r.URL.Path == path {
+						handler.ServeHTTP(w, r)
+						return
+					}
+				}
+				http.Redirect(w, r, "/settings", 302)
+				return
+			}
 			handler.ServeHTTP(w, r)
 		} else {
 			q := r.URL.Query()
@@ -109,6 +243,16 @@ func RequireLogin(handler http.Handler) http.HandlerFunc {
 	}
 }
 
+// ssoSession returns whether or not the user logged in through single sign-on
+func ssoSession(r *http.Request) bool {
+	session, ok := ctx.Get(r, "session").(*sessions.Session)
+	if !ok {
+		return false
+	}
+	sso, _ := session.Values["sso"].(bool)
+	return sso
+}
+
 // JSONError returns an error in JSON format with the given
 // status code and message
 func JSONError(w http.ResponseWriter, c int, m string) {
@@ -0,0 +1,917 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gophish/gophish/config"
+)
+
+// IPActivityThreshold is the number of distinct results a single IP address
+// can engage with before it is flagged as suspicious. A single address
+// interacting with many different targets typically indicates a shared proxy,
+// a researcher, or someone testing the links.
+var IPActivityThreshold = 5
+
+// IPActivity contains the engagement counts recorded for a single IP address
+// within a campaign.
+type IPActivity struct {
+	Results int  `json:"results"`
+	Events  int  `json:"events"`
+	Flagged bool `json:"flagged"`
+}
+
+// parseDetails unmarshals the event details stored with the event. Events
+// without any details return an empty EventDetails.
+func (e *Event) parseDetails() (EventDetails, error) {
+	d := EventDetails{}
+	if e.Details == "" {
+		return d, nil
+	}
+	err := json.Unmarshal([]byte(e.Details), &d)
+	return d, err
+}
+
+// GetCampaignIPActivity returns the engagement counts for each IP address
+// that interacted with the campaign specified by the given id and user_id.
+// Addresses that engaged with more distinct results than IPActivityThreshold
+// are flagged.
+func GetCampaignIPActivity(cid int64, uid int64) (map[string]IPActivity, error) {
+	c, err := GetCampaign(cid, uid)
+	if err != nil {
+		return make(map[string]IPActivity), err
+	}
+	return getIPActivity(c.Events)
+}
+
+// getIPActivity returns the engagement counts for each IP address found in
+// the given events.
+func getIPActivity(es []Event) (map[string]IPActivity, error) {
+	activity := make(map[string]IPActivity)
+	seen := make(map[string]map[string]bool)
+	for _, e := range es {
+		d, err := e.parseDetails()
+		if err != nil {
+			return activity, err
+		}
+		ip := d.Browser["address"]
+		if ip == "" {
+			continue
+		}
+		if _, ok := seen[ip]; !ok {
+			seen[ip] = make(map[string]bool)
+		}
+		seen[ip][e.Email] = true
+		a := activity[ip]
+		a.Events++
+		a.Results = len(seen[ip])
+		a.Flagged = a.Results > IPActivityThreshold
+		activity[ip] = a
+	}
+	return activity, nil
+}
+
+// getOpenRates returns both the raw open rate, based only on the tracking
+// pixel, and the adjusted open rate which also treats clicking the link or
+// submitting data as evidence the email was opened. The adjusted rate
+// accounts for clients that block images.
+func (c *Campaign) getOpenRates() (float64, float64) {
+	if len(c.Results) == 0 {
+		return 0, 0
+	}
+	opened := make(map[string]bool)
+	engaged := make(map[string]bool)
+	for _, e := range c.Events {
+		switch e.Message {
+		case EVENT_OPENED:
+			opened[e.Email] = true
+			engaged[e.Email] = true
+		case EVENT_CLICKED, EVENT_DATA_SUBMIT:
+			engaged[e.Email] = true
+		}
+	}
+	total := float64(len(c.Results))
+	return float64(len(opened)) / total, float64(len(engaged)) / total
+}
+
+// reportedActivity returns the results and events in the campaign which are
+// counted in reports. Events belonging to a result which was excluded from
+// reporting are dropped along with the result.
+func (c *Campaign) reportedActivity() ([]Result, []Event) {
+	if IncludeExcludedResults {
+		return c.Results, c.Events
+	}
+	excluded := make(map[string]bool)
+	for _, r := range c.Results {
+		if !r.inReport() {
+			excluded[r.Email] = true
+		}
+	}
+	if len(excluded) == 0 {
+		return c.Results, c.Events
+	}
+	es := []Event{}
+	for _, e := range c.Events {
+		if !excluded[e.Email] {
+			es = append(es, e)
+		}
+	}
+	return reportedResults(c.Results), es
+}
+
+// GetCampaignAdjustedOpenRate returns the fraction of results in the campaign
+// that opened the email, counting a result as opened if it either requested
+// the tracking pixel or clicked the link.
+func GetCampaignAdjustedOpenRate(cid int64, uid int64) (float64, error) {
+	c, err := GetCampaign(cid, uid)
+	if err != nil {
+		return 0, err
+	}
+	_, adjusted := c.getOpenRates()
+	return adjusted, nil
+}
+
+// GetCampaignHumanOpenRate returns the fraction of results in the campaign
+// that were opened by a person rather than an automated scanner. Like the
+// adjusted open rate, clicking the link counts as opening the email, but
+// events are ignored if they were part of a keep-alive burst, came from a
+// known proxy, or came from an address flagged for engaging with too many
+// results. Recipients whose only engagement was from a scanner still count
+// toward the total, since they received the email.
+func GetCampaignHumanOpenRate(cid int64, uid int64) (float64, error) {
+	c, err := GetCampaign(cid, uid)
+	if err != nil {
+		return 0, err
+	}
+	rs, es := c.reportedActivity()
+	if len(rs) == 0 {
+		return 0, nil
+	}
+	activity, err := getIPActivity(es)
+	if err != nil {
+		return 0, err
+	}
+	opened := make(map[string]bool)
+	for _, e := range es {
+		switch e.Message {
+		case EVENT_OPENED, EVENT_CLICKED, EVENT_DATA_SUBMIT:
+		default:
+			continue
+		}
+		d, err := e.parseDetails()
+		if err != nil {
+			return 0, err
+		}
+		addr := d.Browser["address"]
+		if d.Browser["keepalive-burst"] == "true" || isKnownProxy(addr) || activity[addr].Flagged {
+			continue
+		}
+		opened[e.Email] = true
+	}
+	return float64(len(opened)) / float64(len(rs)), nil
+}
+
+// SubjectStats contains the engagement recorded for a single rendered email
+// subject line across all of a user's campaigns.
+type SubjectStats struct {
+	Subject   string  `json:"subject"`
+	Campaigns int     `json:"campaigns"`
+	Total     int64   `json:"total"`
+	Opened    int64   `json:"opened"`
+	Clicked   int64   `json:"clicked"`
+	OpenRate  float64 `json:"open_rate"`
+	ClickRate float64 `json:"click_rate"`
+}
+
+// hasOpened returns whether or not the result's status indicates the
+// recipient opened the email.
+func (r *Result) hasOpened() bool {
+	return r.Status == EVENT_OPENED || r.hasClicked()
+}
+
+// hasClicked returns whether or not the result's status indicates the
+// recipient clicked the link in the email.
+func (r *Result) hasClicked() bool {
+	return r.Status == EVENT_CLICKED || r.hasSubmitted()
+}
+
+// hasSubmitted returns whether or not the result's status indicates the
+// recipient submitted data to the landing page, including a second factor.
+func (r *Result) hasSubmitted() bool {
+	return r.Status == EVENT_DATA_SUBMIT || r.Status == EVENT_MFA_SUBMIT
+}
+
+// GetSubjectPerformance returns the open and click rates for each subject line
+// sent in the campaigns owned by the given user, ordered from the highest click
+// rate to the lowest. Results that haven't been sent an email yet are ignored.
+func GetSubjectPerformance(uid int64) ([]SubjectStats, error) {
+	ss := []SubjectStats{}
+	rs := []Result{}
+	err := FlushResults()
+	if err != nil {
+		return ss, err
+	}
+	err = reportScope(db).Where("user_id in (?) and subject <> ''", teamUserIds(uid)).Find(&rs).Error
+	if err != nil {
+		return ss, err
+	}
+	index := make(map[string]int)
+	campaigns := make(map[string]map[int64]bool)
+	for _, r := range rs {
+		i, ok := index[r.Subject]
+		if !ok {
+			i = len(ss)
+			index[r.Subject] = i
+			ss = append(ss, SubjectStats{Subject: r.Subject})
+			campaigns[r.Subject] = make(map[int64]bool)
+		}
+		campaigns[r.Subject][r.CampaignId] = true
+		ss[i].Total++
+		if r.hasOpened() {
+			ss[i].Opened++
+		}
+		if r.hasClicked() {
+			ss[i].Clicked++
+		}
+	}
+	for i := range ss {
+		ss[i].Campaigns = len(campaigns[ss[i].Subject])
+		ss[i].OpenRate = float64(ss[i].Opened) / float64(ss[i].Total)
+		ss[i].ClickRate = float64(ss[i].Clicked) / float64(ss[i].Total)
+	}
+	sort.SliceStable(ss, func(i, j int) bool {
+		if ss[i].ClickRate == ss[j].ClickRate {
+			return ss[i].OpenRate > ss[j].OpenRate
+		}
+		return ss[i].ClickRate > ss[j].ClickRate
+	})
+	return ss, nil
+}
+
+// ErrInvalidBucketCount is thrown when a non-positive number of buckets is
+// requested.
+var ErrInvalidBucketCount = errors.New("Number of buckets must be greater than zero")
+
+// PositionBucket contains the engagement for a range of send positions in a
+// campaign. Start and End are the (inclusive) send positions in the bucket.
+type PositionBucket struct {
+	Start     int64   `json:"start"`
+	End       int64   `json:"end"`
+	Total     int64   `json:"total"`
+	Opened    int64   `json:"opened"`
+	Clicked   int64   `json:"clicked"`
+	ClickRate float64 `json:"click_rate"`
+}
+
+// GetCampaignEngagementBySendPosition splits the sent results of a campaign
+// into the given number of buckets by the order they were sent in, and
+// returns the engagement for each bucket. This can be used to detect if
+// early recipients alerted those who received the email later.
+func GetCampaignEngagementBySendPosition(cid int64, uid int64, buckets int) ([]PositionBucket, error) {
+	pbs := []PositionBucket{}
+	if buckets <= 0 {
+		return pbs, ErrInvalidBucketCount
+	}
+	rs := []Result{}
+	err := FlushResults()
+	if err != nil {
+		return pbs, err
+	}
+	err = reportScope(db).Where("campaign_id=? and user_id in (?) and send_position > 0", cid, teamUserIds(uid)).
+		Order("send_position asc").Find(&rs).Error
+	if err != nil || len(rs) == 0 {
+		return pbs, err
+	}
+	if buckets > len(rs) {
+		buckets = len(rs)
+	}
+	for i := 0; i < buckets; i++ {
+		lo := i * len(rs) / buckets
+		hi := (i + 1) * len(rs) / buckets
+		pb := PositionBucket{
+			Start: rs[lo].SendPosition,
+			End:   rs[hi-1].SendPosition,
+		}
+		for _, r := range rs[lo:hi] {
+			pb.Total++
+			if r.hasOpened() {
+				pb.Opened++
+			}
+			if r.hasClicked() {
+				pb.Clicked++
+			}
+		}
+		pb.ClickRate = float64(pb.Clicked) / float64(pb.Total)
+		pbs = append(pbs, pb)
+	}
+	return pbs, nil
+}
+
+// CostWeights contains the estimated cost of each kind of action a recipient
+// can take in a campaign.
+type CostWeights struct {
+	Opened    float64 `json:"opened"`
+	Clicked   float64 `json:"clicked"`
+	Submitted float64 `json:"submitted_data"`
+}
+
+// GetCampaignCostEstimate returns the estimated cost of the engagement in the
+// campaign using the given weights. Each result is weighted by the furthest
+// action the recipient took, so a recipient who submitted data only counts
+// the Submitted weight rather than the weights of every action leading to it.
+func GetCampaignCostEstimate(cid int64, uid int64, weights CostWeights) (float64, error) {
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return 0, err
+	}
+	cost := 0.0
+	for _, r := range reportedResults(rs) {
+		switch r.Status {
+		case EVENT_DATA_SUBMIT, EVENT_MFA_SUBMIT:
+			cost += weights.Submitted
+		case EVENT_CLICKED:
+			cost += weights.Clicked
+		case EVENT_OPENED:
+			cost += weights.Opened
+		}
+	}
+	return cost, nil
+}
+
+// DefaultResilienceWeight is the weight given to both the report rate and the
+// fraction of recipients who didn't click the link if no weights are
+// configured.
+const DefaultResilienceWeight = 0.5
+
+// ResilienceReportWeight is the weight given to the report rate when
+// computing a campaign's resilience score.
+var ResilienceReportWeight = DefaultResilienceWeight
+
+// ResilienceNoClickWeight is the weight given to the fraction of recipients
+// who didn't click the link when computing a campaign's resilience score.
+var ResilienceNoClickWeight = DefaultResilienceWeight
+
+// ReportGraceWindow is how soon after clicking the link a recipient must
+// report the email for the click to be partially forgiven when computing a
+// campaign's resilience score. A zero value disables the grace window.
+var ReportGraceWindow time.Duration
+
+// DefaultReportGraceCredit is the ReportGraceCredit used if none is
+// configured.
+const DefaultReportGraceCredit = 0.5
+
+// ReportGraceCredit is the fraction of a click which is forgiven when the
+// recipient reports the email within ReportGraceWindow of clicking.
+var ReportGraceCredit = DefaultReportGraceCredit
+
+// ErrInvalidResilienceWeight is thrown when one of the configured resilience
+// weights is negative, or the grace credit isn't between 0 and 1.
+var ErrInvalidResilienceWeight = errors.New("Resilience weights can't be negative and the grace credit must be between 0 and 1")
+
+// configureResilience sets the weights and the grace window used to compute
+// resilience scores, using the defaults if neither weight or no grace credit
+// is given.
+func configureResilience(conf config.Resilience) error {
+	if conf.ReportWeight < 0 || conf.NoClickWeight < 0 || conf.GraceCredit < 0 || conf.GraceCredit > 1 {
+		return ErrInvalidResilienceWeight
+	}
+	ResilienceReportWeight, ResilienceNoClickWeight = DefaultResilienceWeight, DefaultResilienceWeight
+	if conf.ReportWeight != 0 || conf.NoClickWeight != 0 {
+		ResilienceReportWeight, ResilienceNoClickWeight = conf.ReportWeight, conf.NoClickWeight
+	}
+	ReportGraceWindow = 0
+	if conf.GraceMinutes > 0 {
+		ReportGraceWindow = time.Duration(conf.GraceMinutes) * time.Minute
+	}
+	ReportGraceCredit = DefaultReportGraceCredit
+	if conf.GraceCredit > 0 {
+		ReportGraceCredit = conf.GraceCredit
+	}
+	return nil
+}
+
+// GetCampaignResilienceScore returns a score from 0 to 100 describing how
+// resilient the recipients of the campaign were, combining the report rate
+// with the fraction of recipients who didn't click the link. Clicks which were
+// reported within ReportGraceWindow only partially count against the score.
+// Campaigns without any results have a score of 0.
+func GetCampaignResilienceScore(cid int64, uid int64) (float64, error) {
+	cs, err := GetCampaignSummary(cid, uid)
+	if err != nil {
+		return 0, err
+	}
+	total := ResilienceReportWeight + ResilienceNoClickWeight
+	if cs.Stats.Total == 0 || total <= 0 {
+		return 0, nil
+	}
+	clicks := float64(cs.Stats.ClickedLink)
+	if ReportGraceWindow > 0 {
+		rs, err := ResultStorage.List(cid, uid)
+		if err != nil {
+			return 0, err
+		}
+		for _, r := range reportedResults(rs) {
+			ok, err := r.ReportedWithinGraceAfterClick(ReportGraceWindow)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				clicks -= ReportGraceCredit
+			}
+		}
+	}
+	reportRate := float64(cs.Stats.EmailReported) / float64(cs.Stats.Total)
+	clickRate := clicks / float64(cs.Stats.Total)
+	score := ResilienceReportWeight*reportRate + ResilienceNoClickWeight*(1-clickRate)
+	return 100 * score / total, nil
+}
+
+// PersonCampaignResult contains how a single person responded to one of the
+// campaigns they were a target of.
+type PersonCampaignResult struct {
+	CampaignId   int64     `json:"campaign_id"`
+	CampaignName string    `json:"campaign_name"`
+	LaunchDate   time.Time `json:"launch_date"`
+	Status       string    `json:"status"`
+	Reported     bool      `json:"reported"`
+	Improved     bool      `json:"improved"`
+}
+
+// severity ranks how far the recipient fell for the campaign, from 0 for not
+// engaging at all to 3 for submitting data.
+func (r *Result) severity() int {
+	switch r.Status {
+	case EVENT_DATA_SUBMIT, EVENT_MFA_SUBMIT:
+		return 3
+	case EVENT_CLICKED:
+		return 2
+	case EVENT_OPENED:
+		return 1
+	}
+	return 0
+}
+
+// normalizeEmail returns the email address in the form used to match the same
+// person across campaigns.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// GetPersonTrajectory returns the results for the person with the given email
+// address across all of the campaigns owned by the given user, ordered from
+// the earliest campaign to the latest. Each entry is marked as improved if the
+// person took a less severe action, or reported the email when they previously
+// hadn't, compared to the campaign before it.
+func GetPersonTrajectory(email string, uid int64) ([]PersonCampaignResult, error) {
+	pcs := []PersonCampaignResult{}
+	rs := []Result{}
+	err := FlushResults()
+	if err != nil {
+		return pcs, err
+	}
+	err = db.Where("user_id in (?) and lower(email)=?", teamUserIds(uid), normalizeEmail(email)).
+		Order("campaign_id asc").Find(&rs).Error
+	if err != nil {
+		return pcs, err
+	}
+	severities := make(map[int64]int)
+	for _, r := range rs {
+		c := Campaign{}
+		err = db.Where("id=? and user_id in (?)", r.CampaignId, teamUserIds(uid)).First(&c).Error
+		if err != nil {
+			return pcs, err
+		}
+		severities[c.Id] = r.severity()
+		pcs = append(pcs, PersonCampaignResult{
+			CampaignId:   c.Id,
+			CampaignName: c.Name,
+			LaunchDate:   c.LaunchDate,
+			Status:       r.Status,
+			Reported:     r.Reported,
+		})
+	}
+	sort.SliceStable(pcs, func(i, j int) bool {
+		return pcs[i].LaunchDate.Before(pcs[j].LaunchDate)
+	})
+	for i := 1; i < len(pcs); i++ {
+		prev, cur := pcs[i-1], pcs[i]
+		pcs[i].Improved = severities[cur.CampaignId] < severities[prev.CampaignId] ||
+			(cur.Reported && !prev.Reported)
+	}
+	return pcs, nil
+}
+
+// GetCampaignAttachmentBreakdown returns the number of distinct results in the
+// campaign specified by the given id and user_id that opened each attachment,
+// keyed by the attachment name.
+func GetCampaignAttachmentBreakdown(cid int64, uid int64) (map[string]int64, error) {
+	breakdown := make(map[string]int64)
+	c, err := GetCampaign(cid, uid)
+	if err != nil {
+		return breakdown, err
+	}
+	seen := make(map[string]map[string]bool)
+	for _, e := range c.Events {
+		if e.Message != EVENT_ATTACHMENT {
+			continue
+		}
+		d, err := e.parseDetails()
+		if err != nil {
+			return breakdown, err
+		}
+		if d.AttachmentName == "" {
+			continue
+		}
+		if _, ok := seen[d.AttachmentName]; !ok {
+			seen[d.AttachmentName] = make(map[string]bool)
+		}
+		if !seen[d.AttachmentName][e.Email] {
+			seen[d.AttachmentName][e.Email] = true
+			breakdown[d.AttachmentName]++
+		}
+	}
+	return breakdown, nil
+}
+
+// GetReportChannelBreakdown returns the number of distinct results in the
+// campaign specified by the given id and user_id that reported the email
+// through each channel. Reports recorded without a channel were made with the
+// report button.
+func GetReportChannelBreakdown(cid int64, uid int64) (map[string]int64, error) {
+	breakdown := make(map[string]int64)
+	c, err := GetCampaign(cid, uid)
+	if err != nil {
+		return breakdown, err
+	}
+	seen := make(map[string]map[string]bool)
+	for _, e := range c.Events {
+		if e.Message != EVENT_REPORTED {
+			continue
+		}
+		d, err := e.parseDetails()
+		if err != nil {
+			return breakdown, err
+		}
+		channel := d.Channel
+		if channel == "" {
+			channel = REPORT_CHANNEL_BUTTON
+		}
+		if _, ok := seen[channel]; !ok {
+			seen[channel] = make(map[string]bool)
+		}
+		if !seen[channel][e.Email] {
+			seen[channel][e.Email] = true
+			breakdown[channel]++
+		}
+	}
+	return breakdown, nil
+}
+
+// ErrInvalidBucketDuration is thrown when a non-positive bucket duration is
+// requested.
+var ErrInvalidBucketDuration = errors.New("Bucket duration must be greater than zero")
+
+// TrendPoint contains the aggregated reporting rate for the campaigns
+// launched within a single time bucket.
+type TrendPoint struct {
+	Start      time.Time `json:"start"`
+	Campaigns  int       `json:"campaigns"`
+	Total      int64     `json:"total"`
+	Reported   int64     `json:"reported"`
+	ReportRate float64   `json:"report_rate"`
+}
+
+// GetReportingRateTrend returns the reporting rate of the campaigns owned by
+// the given user, grouped into consecutive buckets of the given duration by
+// launch date, starting at since and ending with the bucket containing the
+// current time. Buckets without any launched campaigns have a rate of 0.
+func GetReportingRateTrend(uid int64, since time.Time, bucket time.Duration) ([]TrendPoint, error) {
+	tps := []TrendPoint{}
+	if bucket <= 0 {
+		return tps, ErrInvalidBucketDuration
+	}
+	now := time.Now().UTC()
+	if since.After(now) {
+		return tps, nil
+	}
+	for start := since; !start.After(now); start = start.Add(bucket) {
+		tps = append(tps, TrendPoint{Start: start})
+	}
+	cs := []Campaign{}
+	err := db.Where("user_id in (?) and launch_date >= ? and launch_date <= ?", teamUserIds(uid), since, now).
+		Find(&cs).Error
+	if err != nil {
+		return tps, err
+	}
+	for _, c := range cs {
+		i := int(c.LaunchDate.Sub(since) / bucket)
+		if i < 0 || i >= len(tps) {
+			continue
+		}
+		s, err := ResultStorage.Summary(c.Id)
+		if err != nil {
+			return tps, err
+		}
+		tps[i].Campaigns++
+		tps[i].Total += s.Total
+		tps[i].Reported += s.EmailReported
+	}
+	for i := range tps {
+		if tps[i].Total > 0 {
+			tps[i].ReportRate = float64(tps[i].Reported) / float64(tps[i].Total)
+		}
+	}
+	return tps, nil
+}
+
+// WeekdayStats contains the number of engagement events which occurred on a
+// single day of the week.
+type WeekdayStats struct {
+	Opened        int64 `json:"opened"`
+	Clicked       int64 `json:"clicked"`
+	SubmittedData int64 `json:"submitted_data"`
+	Reported      int64 `json:"reported"`
+}
+
+// GetCampaignEngagementByWeekday returns the engagement events in the campaign
+// specified by the given id and user_id, grouped by the day of the week they
+// occurred in the given location. If no location is given, each recipient's
+// reported timezone offset is used when available, falling back to UTC.
+func GetCampaignEngagementByWeekday(cid int64, uid int64, tz *time.Location) (map[time.Weekday]WeekdayStats, error) {
+	stats := make(map[time.Weekday]WeekdayStats)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		stats[d] = WeekdayStats{}
+	}
+	c, err := GetCampaign(cid, uid)
+	if err != nil {
+		return stats, err
+	}
+	rs, es := c.reportedActivity()
+	locations := make(map[string]*time.Location)
+	for _, r := range rs {
+		switch {
+		case tz != nil:
+			locations[r.Email] = tz
+		case r.ClientTZOffset != nil:
+			locations[r.Email] = time.FixedZone("", *r.ClientTZOffset*60)
+		}
+	}
+	for _, e := range es {
+		loc, ok := locations[e.Email]
+		if !ok {
+			loc = time.UTC
+		}
+		day := e.Time.In(loc).Weekday()
+		ws := stats[day]
+		switch e.Message {
+		case EVENT_OPENED:
+			ws.Opened++
+		case EVENT_CLICKED:
+			ws.Clicked++
+		case EVENT_DATA_SUBMIT:
+			ws.SubmittedData++
+		case EVENT_REPORTED:
+			ws.Reported++
+		default:
+			continue
+		}
+		stats[day] = ws
+	}
+	return stats, nil
+}
+
+// GetSendVsOpenHourMatrix returns a 24x24 matrix for the campaign specified
+// by the given id and user_id, where the value at [s][o] is the number of
+// recipients whose email was sent during hour s and first opened during hour
+// o of the day, in the given location. If no location is given, UTC is used.
+// Recipients who never opened the email aren't counted.
+func GetSendVsOpenHourMatrix(cid int64, uid int64, tz *time.Location) ([][]int, error) {
+	matrix := make([][]int, 24)
+	for i := range matrix {
+		matrix[i] = make([]int, 24)
+	}
+	if tz == nil {
+		tz = time.UTC
+	}
+	c, err := GetCampaign(cid, uid)
+	if err != nil {
+		return matrix, err
+	}
+	_, es := c.reportedActivity()
+	sent := make(map[string]time.Time)
+	opened := make(map[string]time.Time)
+	for _, e := range es {
+		var first map[string]time.Time
+		switch e.Message {
+		case EVENT_SENT:
+			first = sent
+		case EVENT_OPENED:
+			first = opened
+		default:
+			continue
+		}
+		if t, ok := first[e.Email]; !ok || e.Time.Before(t) {
+			first[e.Email] = e.Time
+		}
+	}
+	for email, o := range opened {
+		s, ok := sent[email]
+		if !ok {
+			continue
+		}
+		matrix[s.In(tz).Hour()][o.In(tz).Hour()]++
+	}
+	return matrix, nil
+}
+
+// GetNoInteractionCount returns the number of results in the campaign
+// specified by the given id and user_id whose email was successfully sent, but
+// who never opened it, clicked the link, or reported it. Note that the email
+// being accepted by the remote server doesn't mean it reached the inbox, so a
+// large number of these results may indicate the email was filtered as spam
+// rather than ignored.
+func GetNoInteractionCount(cid int64, uid int64) (int, error) {
+	count := 0
+	err := FlushResults()
+	if err != nil {
+		return count, err
+	}
+	err = reportScope(db.Model(&Result{})).
+		Where("campaign_id=? and user_id in (?) and status=? and reported=?", cid, teamUserIds(uid), EVENT_SENT, false).
+		Count(&count).Error
+	return count, err
+}
+
+// AttritionStage is the number of recipients who reached a single stage of a
+// campaign, and how many of those who reached the previous stage dropped off
+// before reaching it.
+type AttritionStage struct {
+	Count       int64   `json:"count"`
+	DropOff     int64   `json:"drop_off"`
+	DropOffRate float64 `json:"drop_off_rate"`
+}
+
+// Attrition describes how many recipients dropped off between each stage of a
+// campaign, from opening the email to submitting data.
+type Attrition struct {
+	Opened    AttritionStage `json:"opened"`
+	Clicked   AttritionStage `json:"clicked"`
+	Rendered  AttritionStage `json:"rendered"`
+	Submitted AttritionStage `json:"submitted"`
+}
+
+// newAttritionStage returns the stage reached by count of the prev recipients
+// who reached the previous stage. The drop off rate is a percentage, and is 0
+// if nobody reached the previous stage.
+func newAttritionStage(prev int64, count int64) AttritionStage {
+	s := AttritionStage{Count: count, DropOff: prev - count}
+	if prev > 0 {
+		s.DropOffRate = 100 * float64(s.DropOff) / float64(prev)
+	}
+	return s
+}
+
+// GetCampaignAttrition returns the drop off between each stage of the campaign
+// specified by the given id and user_id: opening the email, clicking the link,
+// the landing page rendering, and submitting data. A landing page is considered
+// rendered unless it was recorded as failing with an error status, so clicks
+// recorded before statuses were tracked still count. The opened stage drops
+// off from the number of emails sent.
+func GetCampaignAttrition(cid int64, uid int64) (Attrition, error) {
+	a := Attrition{}
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return a, err
+	}
+	var sent, opened, clicked, rendered, submitted int64
+	for _, r := range reportedResults(rs) {
+		if r.Status != EVENT_SENT && !r.hasOpened() {
+			continue
+		}
+		sent++
+		if r.Status == EVENT_SENT {
+			continue
+		}
+		opened++
+		if !r.hasClicked() {
+			continue
+		}
+		clicked++
+		if status, ok := r.LandingPageStatus(); ok && status >= 400 {
+			continue
+		}
+		rendered++
+		if r.hasSubmitted() {
+			submitted++
+		}
+	}
+	a.Opened = newAttritionStage(sent, opened)
+	a.Clicked = newAttritionStage(opened, clicked)
+	a.Rendered = newAttritionStage(clicked, rendered)
+	a.Submitted = newAttritionStage(rendered, submitted)
+	return a, nil
+}
+
+// FirstMover is the result which was the first to trigger an engagement event
+// in a campaign, and when they triggered it.
+type FirstMover struct {
+	RId   string    `json:"id"`
+	Email string    `json:"email"`
+	Time  time.Time `json:"time"`
+}
+
+// FirstMovers contains the first result to open the email, click the link,
+// and submit data in a campaign. Engagement types which nobody triggered are
+// nil.
+type FirstMovers struct {
+	Opened        *FirstMover `json:"opened"`
+	Clicked       *FirstMover `json:"clicked"`
+	SubmittedData *FirstMover `json:"submitted_data"`
+}
+
+// GetCampaignFirstMovers returns the results in the campaign specified by the
+// given id and user_id which were the earliest to open the email, click the
+// link, and submit data.
+func GetCampaignFirstMovers(cid int64, uid int64) (FirstMovers, error) {
+	fm := FirstMovers{}
+	c, err := GetCampaign(cid, uid)
+	if err != nil {
+		return fm, err
+	}
+	rs, es := c.reportedActivity()
+	rids := make(map[string]string)
+	for _, r := range rs {
+		rids[r.Email] = r.RId
+	}
+	for _, e := range es {
+		var first **FirstMover
+		switch e.Message {
+		case EVENT_OPENED:
+			first = &fm.Opened
+		case EVENT_CLICKED:
+			first = &fm.Clicked
+		case EVENT_DATA_SUBMIT:
+			first = &fm.SubmittedData
+		default:
+			continue
+		}
+		rid, ok := rids[e.Email]
+		if !ok {
+			continue
+		}
+		if *first == nil || e.Time.Before((*first).Time) {
+			*first = &FirstMover{RId: rid, Email: e.Email, Time: e.Time}
+		}
+	}
+	return fm, nil
+}
+
+// getEngagementCounts returns the number of results in the campaign which
+// opened the email, clicked the link, and submitted data. Each stage includes
+// the results which went on to reach a later stage.
+func getEngagementCounts(cid int64, uid int64) (int64, int64, int64, error) {
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var opened, clicked, submitted int64
+	for _, r := range reportedResults(rs) {
+		if r.hasOpened() {
+			opened++
+		}
+		if r.hasClicked() {
+			clicked++
+		}
+		if r.hasSubmitted() {
+			submitted++
+		}
+	}
+	return opened, clicked, submitted, nil
+}
+
+// GetCampaignOpenToClickRatio returns the fraction of results in the campaign
+// specified by the given id and user_id which clicked the link after opening
+// the email, describing how effective the lure was. The ratio is 0 if nobody
+// opened the email.
+func GetCampaignOpenToClickRatio(cid int64, uid int64) (float64, error) {
+	opened, clicked, _, err := getEngagementCounts(cid, uid)
+	if err != nil || opened == 0 {
+		return 0, err
+	}
+	return float64(clicked) / float64(opened), nil
+}
+
+// GetCampaignClickToSubmitRatio returns the fraction of results in the
+// campaign specified by the given id and user_id which submitted data after
+// clicking the link, describing how effective the landing page was. The ratio
+// is 0 if nobody clicked the link.
+func GetCampaignClickToSubmitRatio(cid int64, uid int64) (float64, error) {
+	_, clicked, submitted, err := getEngagementCounts(cid, uid)
+	if err != nil || clicked == 0 {
+		return 0, err
+	}
+	return float64(submitted) / float64(clicked), nil
+}
@@ -0,0 +1,750 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestGetCampaignIPActivity(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	shared := EventDetails{Browser: map[string]string{"address": "10.0.0.1"}}
+	for _, r := range campaign.Results {
+		ch.Assert(r.HandleEmailOpened(shared), check.Equals, nil)
+		ch.Assert(r.HandleClickedLink(shared), check.Equals, nil)
+	}
+	single := EventDetails{Browser: map[string]string{"address": "10.0.0.2"}}
+	result := campaign.Results[0]
+	ch.Assert(result.HandleFormSubmit(single), check.Equals, nil)
+
+	defer func(t int) { IPActivityThreshold = t }(IPActivityThreshold)
+	IPActivityThreshold = 1
+
+	activity, err := GetCampaignIPActivity(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(activity), check.Equals, 2)
+	ch.Assert(activity["10.0.0.1"], check.Equals, IPActivity{
+		Results: len(campaign.Results),
+		Events:  len(campaign.Results) * 2,
+		Flagged: true,
+	})
+	ch.Assert(activity["10.0.0.2"], check.Equals, IPActivity{
+		Results: 1,
+		Events:  1,
+		Flagged: false,
+	})
+}
+
+func (s *ModelsSuite) TestGetCampaignAdjustedOpenRate(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	rate, err := GetCampaignAdjustedOpenRate(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(rate, check.Equals, 0.0)
+
+	// The first result fires the pixel, while the second clicks without
+	// the pixel ever being loaded.
+	opened := campaign.Results[0]
+	ch.Assert(opened.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	clicked := campaign.Results[1]
+	ch.Assert(clicked.HandleClickedLink(EventDetails{}), check.Equals, nil)
+
+	c, err := GetCampaign(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	raw, adjusted := c.getOpenRates()
+	ch.Assert(raw, check.Equals, 0.5)
+	ch.Assert(adjusted, check.Equals, 1.0)
+
+	rate, err = GetCampaignAdjustedOpenRate(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(rate, check.Equals, adjusted)
+}
+
+func (s *ModelsSuite) TestGetCampaignHumanOpenRate(ch *check.C) {
+	defer func(hosting []string) { HostingNetworks = hosting }(HostingNetworks)
+	HostingNetworks = []string{"203.0.113.0/24"}
+	from := func(addr string) EventDetails {
+		return EventDetails{Browser: map[string]string{"address": addr}}
+	}
+	campaign := s.createCampaignWithTargets(ch, generateTargets(10))
+	rs := campaign.Results
+
+	// Two people open the email from home
+	ch.Assert(rs[0].HandleEmailOpened(from("192.0.2.1")), check.Equals, nil)
+	ch.Assert(rs[1].HandleClickedLink(from("192.0.2.2")), check.Equals, nil)
+	// A scanner in a hosting network
+	ch.Assert(rs[2].HandleEmailOpened(from("203.0.113.10")), check.Equals, nil)
+	// A scanner that fetched the pixel in a keep-alive burst
+	burst := from("192.0.2.3")
+	burst.Browser["keepalive-burst"] = "true"
+	ch.Assert(rs[3].HandleEmailOpened(burst), check.Equals, nil)
+	// A gateway which opened every remaining email from a single address
+	for _, r := range rs[4:] {
+		ch.Assert(r.HandleEmailOpened(from("198.51.100.1")), check.Equals, nil)
+	}
+	rate, err := GetCampaignHumanOpenRate(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(rate, check.Equals, 0.2)
+
+	// One of the gateway's recipients later clicks from home
+	ch.Assert(rs[9].HandleClickedLink(from("192.0.2.4")), check.Equals, nil)
+	rate, err = GetCampaignHumanOpenRate(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(rate, check.Equals, 0.3)
+
+	// Every open is counted by the adjusted rate
+	adjusted, err := GetCampaignAdjustedOpenRate(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(adjusted, check.Equals, 1.0)
+}
+
+func (s *ModelsSuite) setResultSubject(ch *check.C, r Result, subject string) {
+	r.Subject = subject
+	ch.Assert(db.Save(&r).Error, check.Equals, nil)
+}
+
+func (s *ModelsSuite) TestGetSubjectPerformance(ch *check.C) {
+	// Two campaigns share the "Invoice" subject, while a third uses
+	// "Password Reset".
+	first := s.createCampaign(ch)
+	second := s.createCampaign(ch)
+	third := s.createCampaign(ch)
+	for _, r := range append(first.Results, second.Results...) {
+		s.setResultSubject(ch, r, "Invoice")
+	}
+	for _, r := range third.Results {
+		s.setResultSubject(ch, r, "Password Reset")
+	}
+	r, _ := GetResult(first.Results[0].RId)
+	ch.Assert(r.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	for _, r := range third.Results {
+		r, _ = GetResult(r.RId)
+		ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	}
+
+	ss, err := GetSubjectPerformance(1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ss, check.DeepEquals, []SubjectStats{
+		SubjectStats{
+			Subject:   "Password Reset",
+			Campaigns: 1,
+			Total:     2,
+			Opened:    2,
+			Clicked:   2,
+			OpenRate:  1,
+			ClickRate: 1,
+		},
+		SubjectStats{
+			Subject:   "Invoice",
+			Campaigns: 2,
+			Total:     4,
+			Opened:    1,
+			Clicked:   0,
+			OpenRate:  0.25,
+			ClickRate: 0,
+		},
+	})
+
+	ss, err = GetSubjectPerformance(2)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ss), check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestGetCampaignEngagementBySendPosition(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(5))
+	// Send the emails in a known order. Only the first two recipients click
+	// the link.
+	for i, r := range campaign.Results {
+		ch.Assert(r.HandleEmailSent(), check.Equals, nil)
+		ch.Assert(r.SendPosition, check.Equals, int64(i+1))
+		if i < 2 {
+			ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+		}
+	}
+	// Resending shouldn't change the position
+	r, err := GetResult(campaign.Results[4].RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(r.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(r.SendPosition, check.Equals, int64(5))
+
+	pbs, err := GetCampaignEngagementBySendPosition(campaign.Id, campaign.UserId, 2)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(pbs, check.DeepEquals, []PositionBucket{
+		PositionBucket{Start: 1, End: 2, Total: 2, Opened: 2, Clicked: 2, ClickRate: 1},
+		PositionBucket{Start: 3, End: 5, Total: 3, Opened: 0, Clicked: 0, ClickRate: 0},
+	})
+
+	pbs, err = GetCampaignEngagementBySendPosition(campaign.Id, campaign.UserId, 10)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(pbs), check.Equals, 5)
+
+	_, err = GetCampaignEngagementBySendPosition(campaign.Id, campaign.UserId, 0)
+	ch.Assert(err, check.Equals, ErrInvalidBucketCount)
+}
+
+func (s *ModelsSuite) TestSendPositionConcurrentSends(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(10))
+	// Emails sent at the same time, such as by different sending profiles,
+	// are each given their own position
+	errs := make(chan error, len(campaign.Results))
+	var wg sync.WaitGroup
+	for i := range campaign.Results {
+		wg.Add(1)
+		go func(r Result) {
+			defer wg.Done()
+			errs <- r.HandleEmailSent()
+		}(campaign.Results[i])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		ch.Assert(err, check.Equals, nil)
+	}
+	seen := map[int64]bool{}
+	for _, r := range campaign.Results {
+		got, err := GetResult(r.RId)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(seen[got.SendPosition], check.Equals, false, check.Commentf("duplicate position %d", got.SendPosition))
+		seen[got.SendPosition] = true
+		ch.Assert(got.SendPosition >= 1 && got.SendPosition <= 10, check.Equals, true)
+	}
+}
+
+func (s *ModelsSuite) TestGetCampaignCostEstimate(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(4))
+	weights := CostWeights{Opened: 1, Clicked: 10, Submitted: 100}
+	cost, err := GetCampaignCostEstimate(campaign.Id, campaign.UserId, weights)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(cost, check.Equals, 0.0)
+
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	cost, err = GetCampaignCostEstimate(campaign.Id, campaign.UserId, weights)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(cost, check.Equals, 111.0)
+}
+
+func (s *ModelsSuite) TestGetCampaignResilienceScore(ch *check.C) {
+	// Every recipient clicked and nobody reported
+	clicked := s.createCampaign(ch)
+	for _, r := range clicked.Results {
+		ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	}
+	score, err := GetCampaignResilienceScore(clicked.Id, clicked.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(score, check.Equals, 0.0)
+
+	// Every recipient reported and nobody clicked
+	reported := s.createCampaign(ch)
+	for _, r := range reported.Results {
+		ch.Assert(r.HandleEmailReport(EventDetails{}), check.Equals, nil)
+	}
+	score, err = GetCampaignResilienceScore(reported.Id, reported.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(score, check.Equals, 100.0)
+
+	// Nobody did anything, so only the no-click half counts
+	defer func(rw, nw float64) {
+		ResilienceReportWeight, ResilienceNoClickWeight = rw, nw
+	}(ResilienceReportWeight, ResilienceNoClickWeight)
+	ResilienceReportWeight, ResilienceNoClickWeight = 3, 1
+	idle := s.createCampaign(ch)
+	score, err = GetCampaignResilienceScore(idle.Id, idle.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(score, check.Equals, 25.0)
+}
+
+func (s *ModelsSuite) TestConfigureResilience(ch *check.C) {
+	defer configureResilience(config.Resilience{})
+	err := configureResilience(config.Resilience{ReportWeight: -1})
+	ch.Assert(err, check.Equals, ErrInvalidResilienceWeight)
+
+	ch.Assert(configureResilience(config.Resilience{}), check.Equals, nil)
+	ch.Assert(ResilienceReportWeight, check.Equals, DefaultResilienceWeight)
+	ch.Assert(ResilienceNoClickWeight, check.Equals, DefaultResilienceWeight)
+
+	// Only counting reports
+	ch.Assert(configureResilience(config.Resilience{ReportWeight: 1}), check.Equals, nil)
+	ch.Assert(ResilienceReportWeight, check.Equals, 1.0)
+	ch.Assert(ResilienceNoClickWeight, check.Equals, 0.0)
+	idle := s.createCampaign(ch)
+	score, err := GetCampaignResilienceScore(idle.Id, idle.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(score, check.Equals, 0.0)
+}
+
+func (s *ModelsSuite) TestGetCampaignResilienceScoreGraceWindow(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	rs := campaign.Results
+	for i := range rs {
+		ch.Assert(rs[i].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	}
+	ch.Assert(rs[0].HandleEmailReport(EventDetails{}), check.Equals, nil)
+	score, err := GetCampaignResilienceScore(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(score, check.Equals, 25.0)
+
+	// The quick self-report forgives half of that recipient's click
+	defer configureResilience(config.Resilience{})
+	ch.Assert(configureResilience(config.Resilience{GraceMinutes: 60}), check.Equals, nil)
+	ch.Assert(ReportGraceWindow, check.Equals, time.Hour)
+	ch.Assert(ReportGraceCredit, check.Equals, DefaultReportGraceCredit)
+	score, err = GetCampaignResilienceScore(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(score, check.Equals, 37.5)
+
+	// Or all of it, if configured
+	ch.Assert(configureResilience(config.Resilience{GraceMinutes: 60, GraceCredit: 1}), check.Equals, nil)
+	score, err = GetCampaignResilienceScore(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(score, check.Equals, 50.0)
+
+	err = configureResilience(config.Resilience{GraceMinutes: 60, GraceCredit: 2})
+	ch.Assert(err, check.Equals, ErrInvalidResilienceWeight)
+}
+
+func (s *ModelsSuite) TestGetCampaignResilienceScoreEmpty(ch *check.C) {
+	c := Campaign{Name: "Empty", UserId: 1}
+	ch.Assert(db.Save(&c).Error, check.Equals, nil)
+	score, err := GetCampaignResilienceScore(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(score, check.Equals, 0.0)
+}
+
+func (s *ModelsSuite) TestGetPersonTrajectory(ch *check.C) {
+	// The person only appears in a single campaign
+	first := s.createCampaign(ch)
+	target := first.Results[0]
+	ch.Assert(target.HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	pcs, err := GetPersonTrajectory(target.Email, first.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(pcs), check.Equals, 1)
+	ch.Assert(pcs[0].CampaignId, check.Equals, first.Id)
+	ch.Assert(pcs[0].Status, check.Equals, EVENT_DATA_SUBMIT)
+	ch.Assert(pcs[0].Improved, check.Equals, false)
+
+	second := s.createCampaign(ch)
+	for _, r := range second.Results {
+		if r.Email == target.Email {
+			ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+		}
+	}
+	third := s.createCampaign(ch)
+	for _, r := range third.Results {
+		if r.Email == target.Email {
+			ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+		}
+	}
+
+	// Emails are matched regardless of case
+	pcs, err = GetPersonTrajectory(strings.ToUpper(target.Email), first.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(pcs), check.Equals, 3)
+	ch.Assert(pcs[0].CampaignId, check.Equals, first.Id)
+	ch.Assert(pcs[1].CampaignId, check.Equals, second.Id)
+	ch.Assert(pcs[2].CampaignId, check.Equals, third.Id)
+	ch.Assert(pcs[1].Status, check.Equals, EVENT_CLICKED)
+	ch.Assert(pcs[1].Improved, check.Equals, true)
+	ch.Assert(pcs[2].Improved, check.Equals, false)
+
+	// Other users can't see the person's results
+	pcs, err = GetPersonTrajectory(target.Email, 2)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(pcs), check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestNormalizeRates(ch *check.C) {
+	// A small and a large campaign with the same proportion of clicks should
+	// have the same normalized rates
+	small := s.createCampaignWithTargets(ch, generateTargets(4))
+	ch.Assert(small.Results[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	large := s.createCampaignWithTargets(ch, generateTargets(40))
+	for _, r := range large.Results[:10] {
+		ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	}
+	for _, c := range []Campaign{small, large} {
+		cs, err := GetCampaignSummary(c.Id, c.UserId)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(cs.Normalized.ClickedLink, check.Equals, 25.0)
+		ch.Assert(cs.Normalized.OpenedEmail, check.Equals, 25.0)
+		ch.Assert(cs.Normalized.SubmittedData, check.Equals, 0.0)
+	}
+	summaries, err := GetCampaignSummaries(small.UserId)
+	ch.Assert(err, check.Equals, nil)
+	for _, cs := range summaries.Campaigns {
+		ch.Assert(cs.Normalized, check.Equals, NormalizeRates(cs.Stats))
+	}
+
+	ch.Assert(NormalizeRates(CampaignStats{}), check.Equals, NormalizedRates{})
+	ch.Assert(NormalizeRates(CampaignStats{Total: 8, EmailReported: 2, Error: 1}), check.Equals,
+		NormalizedRates{EmailReported: 25, Error: 12.5})
+}
+
+func (s *ModelsSuite) TestGetReportingRateTrend(ch *check.C) {
+	week := 7 * 24 * time.Hour
+	since := time.Now().UTC().Add(-4 * week)
+	launch := func(c Campaign, at time.Time) {
+		err := db.Model(&Campaign{}).Where("id=?", c.Id).Update("launch_date", at).Error
+		ch.Assert(err, check.Equals, nil)
+	}
+
+	// Nobody reports the first week's campaign, half of the recipients
+	// report in the third week, and everybody reports in the fourth.
+	// Nothing is launched in the second week.
+	first := s.createCampaign(ch)
+	launch(first, since.Add(time.Hour))
+	third := s.createCampaign(ch)
+	launch(third, since.Add(2*week+time.Hour))
+	ch.Assert(third.Results[0].HandleEmailReport(EventDetails{}), check.Equals, nil)
+	fourth := s.createCampaign(ch)
+	launch(fourth, since.Add(3*week+time.Hour))
+	for _, r := range fourth.Results {
+		ch.Assert(r.HandleEmailReport(EventDetails{}), check.Equals, nil)
+	}
+	// Campaigns before the start of the trend are ignored
+	old := s.createCampaign(ch)
+	launch(old, since.Add(-week))
+
+	tps, err := GetReportingRateTrend(first.UserId, since, week)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(tps), check.Equals, 5)
+	expected := []struct {
+		campaigns int
+		rate      float64
+	}{{1, 0}, {0, 0}, {1, 0.5}, {1, 1}, {0, 0}}
+	for i, tp := range tps {
+		ch.Assert(tp.Start, check.Equals, since.Add(time.Duration(i)*week))
+		ch.Assert(tp.Campaigns, check.Equals, expected[i].campaigns)
+		ch.Assert(tp.ReportRate, check.Equals, expected[i].rate)
+	}
+	ch.Assert(tps[1].Total, check.Equals, int64(0))
+
+	_, err = GetReportingRateTrend(first.UserId, since, 0)
+	ch.Assert(err, check.Equals, ErrInvalidBucketDuration)
+}
+
+func (s *ModelsSuite) TestGetCampaignEngagementByWeekday(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	first, second := campaign.Results[0], campaign.Results[1]
+	ch.Assert(first.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(first.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(second.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(second.HandleEmailReport(EventDetails{}), check.Equals, nil)
+
+	// Monday at 23:30 UTC, which is already Tuesday east of UTC
+	monday := time.Date(2018, 6, 4, 23, 30, 0, 0, time.UTC)
+	setTime := func(r Result, message string, t time.Time) {
+		err := db.Model(&Event{}).Where("campaign_id=? and email=? and message=?",
+			campaign.Id, r.Email, message).Update("time", t).Error
+		ch.Assert(err, check.Equals, nil)
+	}
+	setTime(first, EVENT_OPENED, monday)
+	setTime(first, EVENT_CLICKED, monday)
+	setTime(second, EVENT_CLICKED, monday.Add(48*time.Hour))
+	setTime(second, EVENT_REPORTED, monday.Add(48*time.Hour))
+
+	stats, err := GetCampaignEngagementByWeekday(campaign.Id, campaign.UserId, time.UTC)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(stats), check.Equals, 7)
+	ch.Assert(stats[time.Monday], check.Equals, WeekdayStats{Opened: 1, Clicked: 1})
+	ch.Assert(stats[time.Wednesday], check.Equals, WeekdayStats{Clicked: 1, Reported: 1})
+	ch.Assert(stats[time.Tuesday], check.Equals, WeekdayStats{})
+
+	tokyo := time.FixedZone("JST", 9*60*60)
+	stats, err = GetCampaignEngagementByWeekday(campaign.Id, campaign.UserId, tokyo)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(stats[time.Tuesday], check.Equals, WeekdayStats{Opened: 1, Clicked: 1})
+	ch.Assert(stats[time.Thursday], check.Equals, WeekdayStats{Clicked: 1, Reported: 1})
+
+	// Without a location, the recipient's own offset is used if it was
+	// reported, otherwise UTC
+	offset := 120
+	first.ClientTZOffset = &offset
+	ch.Assert(db.Save(&first).Error, check.Equals, nil)
+	stats, err = GetCampaignEngagementByWeekday(campaign.Id, campaign.UserId, nil)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(stats[time.Tuesday], check.Equals, WeekdayStats{Opened: 1, Clicked: 1})
+	ch.Assert(stats[time.Wednesday], check.Equals, WeekdayStats{Clicked: 1, Reported: 1})
+}
+
+func (s *ModelsSuite) TestGetNoInteractionCount(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(5))
+	rs := campaign.Results
+	// Two recipients ignore the email, one opens it, and one reports it
+	// without opening it. The last email is never sent.
+	for _, r := range rs[:4] {
+		ch.Assert(r.HandleEmailSent(), check.Equals, nil)
+	}
+	ch.Assert(rs[2].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[3].HandleEmailReport(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[4].HandleEmailError(errors.New("Recipient rejected")), check.Equals, nil)
+
+	count, err := GetNoInteractionCount(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(count, check.Equals, 2)
+
+	count, err = GetNoInteractionCount(campaign.Id, 2)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(count, check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestGetSendVsOpenHourMatrix(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(3))
+	rs := campaign.Results
+	for i := range rs {
+		ch.Assert(rs[i].HandleEmailSent(), check.Equals, nil)
+	}
+	ch.Assert(rs[0].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+
+	setTime := func(r Result, message string, t time.Time) {
+		err := db.Model(&Event{}).Where("campaign_id=? and email=? and message=?",
+			campaign.Id, r.Email, message).Update("time", t).Error
+		ch.Assert(err, check.Equals, nil)
+	}
+	// Sent late at night and opened the next morning
+	night := time.Date(2018, 6, 4, 23, 15, 0, 0, time.UTC)
+	setTime(rs[0], EVENT_SENT, night)
+	setTime(rs[0], EVENT_OPENED, night.Add(9*time.Hour))
+	// Sent and opened within the same hour
+	morning := time.Date(2018, 6, 5, 9, 5, 0, 0, time.UTC)
+	setTime(rs[1], EVENT_SENT, morning)
+	setTime(rs[1], EVENT_OPENED, morning.Add(10*time.Minute))
+	// Never opened
+	setTime(rs[2], EVENT_SENT, morning)
+
+	matrix, err := GetSendVsOpenHourMatrix(campaign.Id, campaign.UserId, nil)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(matrix), check.Equals, 24)
+	total := 0
+	for _, row := range matrix {
+		ch.Assert(len(row), check.Equals, 24)
+		for _, n := range row {
+			total += n
+		}
+	}
+	ch.Assert(total, check.Equals, 2)
+	ch.Assert(matrix[23][8], check.Equals, 1)
+	ch.Assert(matrix[9][9], check.Equals, 1)
+
+	tokyo := time.FixedZone("JST", 9*60*60)
+	matrix, err = GetSendVsOpenHourMatrix(campaign.Id, campaign.UserId, tokyo)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(matrix[8][17], check.Equals, 1)
+	ch.Assert(matrix[18][18], check.Equals, 1)
+}
+
+func (s *ModelsSuite) TestGetCampaignAttrition(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(7))
+	rs := campaign.Results
+	for i := 0; i < 6; i++ {
+		ch.Assert(rs[i].HandleEmailSent(), check.Equals, nil)
+	}
+	// Sent but never opened
+	// Opened but never clicked
+	ch.Assert(rs[1].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	// Clicked but the landing page failed to load
+	ch.Assert(rs[2].HandleClickedLink(EventDetails{StatusCode: 500}), check.Equals, nil)
+	// Clicked and the page loaded, but nothing was submitted
+	ch.Assert(rs[3].HandleClickedLink(EventDetails{StatusCode: 200}), check.Equals, nil)
+	// Clicked without a recorded status, which counts as rendered
+	ch.Assert(rs[4].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	// Submitted data
+	ch.Assert(rs[5].HandleClickedLink(EventDetails{StatusCode: 200}), check.Equals, nil)
+	ch.Assert(rs[5].HandleFormSubmit(EventDetails{StatusCode: 302}), check.Equals, nil)
+	// rs[6] was never sent, so isn't counted at all
+
+	a, err := GetCampaignAttrition(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(a.Opened, check.Equals, AttritionStage{Count: 5, DropOff: 1, DropOffRate: 100.0 / 6})
+	ch.Assert(a.Clicked, check.Equals, AttritionStage{Count: 4, DropOff: 1, DropOffRate: 20})
+	ch.Assert(a.Rendered, check.Equals, AttritionStage{Count: 3, DropOff: 1, DropOffRate: 25})
+	ch.Assert(a.Submitted.Count, check.Equals, int64(1))
+	ch.Assert(a.Submitted.DropOff, check.Equals, int64(2))
+
+	// Campaigns without any engagement don't divide by zero
+	empty := s.createCampaign(ch)
+	a, err = GetCampaignAttrition(empty.Id, empty.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(a, check.Equals, Attrition{})
+}
+
+func (s *ModelsSuite) TestGetReportChannelBreakdown(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(4))
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleEmailReport(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleEmailReport(EventDetails{Channel: REPORT_CHANNEL_FORWARD}), check.Equals, nil)
+	ch.Assert(rs[2].HandleEmailReport(EventDetails{Channel: REPORT_CHANNEL_TICKET}), check.Equals, nil)
+	// Reporting twice through the same channel only counts once
+	ch.Assert(rs[2].HandleEmailReport(EventDetails{Channel: REPORT_CHANNEL_TICKET}), check.Equals, nil)
+	ch.Assert(rs[3].HandleEmailReport(EventDetails{Channel: "fax"}), check.Equals, ErrInvalidReportChannel)
+
+	breakdown, err := GetReportChannelBreakdown(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(breakdown, check.DeepEquals, map[string]int64{
+		REPORT_CHANNEL_BUTTON:  1,
+		REPORT_CHANNEL_FORWARD: 1,
+		REPORT_CHANNEL_TICKET:  1,
+	})
+	got, err := GetResult(rs[3].RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Reported, check.Equals, false)
+}
+
+func (s *ModelsSuite) TestGetCampaignFirstMovers(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(3))
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleClickedLink(EventDetails{}), check.Equals, nil)
+
+	setTime := func(r Result, message string, t time.Time) {
+		err := db.Model(&Event{}).Where("campaign_id=? and email=? and message=?",
+			campaign.Id, r.Email, message).Update("time", t).Error
+		ch.Assert(err, check.Equals, nil)
+	}
+	base := time.Date(2018, 6, 4, 9, 0, 0, 0, time.UTC)
+	// The second result opened first, even though their event was recorded
+	// after the first result's
+	setTime(rs[0], EVENT_OPENED, base.Add(10*time.Minute))
+	setTime(rs[1], EVENT_OPENED, base.Add(5*time.Minute))
+	setTime(rs[1], EVENT_CLICKED, base.Add(30*time.Minute))
+	setTime(rs[2], EVENT_CLICKED, base.Add(20*time.Minute))
+
+	fm, err := GetCampaignFirstMovers(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(fm.Opened, check.NotNil)
+	ch.Assert(fm.Opened.RId, check.Equals, rs[1].RId)
+	ch.Assert(fm.Opened.Time.Equal(base.Add(5*time.Minute)), check.Equals, true)
+	ch.Assert(fm.Clicked, check.NotNil)
+	ch.Assert(fm.Clicked.RId, check.Equals, rs[2].RId)
+	ch.Assert(fm.Clicked.Email, check.Equals, rs[2].Email)
+	// Nobody submitted data
+	ch.Assert(fm.SubmittedData, check.IsNil)
+}
+
+func (s *ModelsSuite) TestGetCampaignEngagementRatios(ch *check.C) {
+	// Nobody has opened the email or clicked the link
+	campaign := s.createCampaignWithTargets(ch, generateTargets(5))
+	ratio, err := GetCampaignOpenToClickRatio(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ratio, check.Equals, 0.0)
+	ratio, err = GetCampaignClickToSubmitRatio(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ratio, check.Equals, 0.0)
+
+	// Opens without any clicks
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ratio, err = GetCampaignOpenToClickRatio(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ratio, check.Equals, 0.0)
+	ratio, err = GetCampaignClickToSubmitRatio(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ratio, check.Equals, 0.0)
+
+	// 4 opened (clicking counts as opening), 3 clicked, 1 submitted
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[3].HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	ratio, err = GetCampaignOpenToClickRatio(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ratio, check.Equals, 0.75)
+	ratio, err = GetCampaignClickToSubmitRatio(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ratio, check.Equals, 1.0/3.0)
+}
+
+func (s *ModelsSuite) TestGetResultStatusCounts(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(6))
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleEmailSent(), check.Equals, nil)
+	ch.Assert(rs[1].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleEmailReport(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[3].HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[4].HandleEmailError(errors.New("Recipient rejected")), check.Equals, nil)
+	ch.Assert(rs[0].HandleEmailReport(EventDetails{}), check.Equals, nil)
+
+	counts, err := GetResultStatusCounts(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(counts, check.DeepEquals, map[string]int{
+		EVENT_SENT:        1,
+		EVENT_OPENED:      1,
+		EVENT_CLICKED:     1,
+		EVENT_DATA_SUBMIT: 1,
+		ERROR:             1,
+		STATUS_RETRY:      0,
+		STATUS_SENDING:    1,
+		EVENT_REPORTED:    2,
+	})
+
+	// Excluded results aren't counted
+	ch.Assert(rs[1].ExcludeFromReport("Wrong recipient"), check.Equals, nil)
+	counts, err = GetResultStatusCounts(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(counts[EVENT_OPENED], check.Equals, 0)
+
+	// Other users can't see the campaign's counts
+	counts, err = GetResultStatusCounts(campaign.Id, campaign.UserId+1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(counts[EVENT_SENT], check.Equals, 0)
+	ch.Assert(counts[EVENT_REPORTED], check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestExcludedResultsLeaveAnalytics(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(2))
+	excluded, kept := &campaign.Results[0], &campaign.Results[1]
+	ch.Assert(excluded.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(kept.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(excluded.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(excluded.HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	ch.Assert(kept.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(excluded.ExcludeFromReport("Wrong recipient"), check.Equals, nil)
+
+	cost, err := GetCampaignCostEstimate(campaign.Id, campaign.UserId, CostWeights{Opened: 1, Clicked: 10, Submitted: 100})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(cost, check.Equals, 1.0)
+
+	a, err := GetCampaignAttrition(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(a.Opened.Count, check.Equals, int64(1))
+	ch.Assert(a.Clicked.Count, check.Equals, int64(0))
+
+	ratio, err := GetCampaignOpenToClickRatio(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ratio, check.Equals, 0.0)
+
+	rate, err := GetCampaignHumanOpenRate(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(rate, check.Equals, 1.0)
+
+	fm, err := GetCampaignFirstMovers(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(fm.Opened.RId, check.Equals, kept.RId)
+	ch.Assert(fm.Clicked, check.IsNil)
+
+	pbs, err := GetCampaignEngagementBySendPosition(campaign.Id, campaign.UserId, 1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(pbs), check.Equals, 1)
+	ch.Assert(pbs[0].Total, check.Equals, int64(1))
+	ch.Assert(pbs[0].Clicked, check.Equals, int64(0))
+
+	// Excluded results are counted again when configured to be included
+	defer func(include bool) { IncludeExcludedResults = include }(IncludeExcludedResults)
+	IncludeExcludedResults = true
+	cost, err = GetCampaignCostEstimate(campaign.Id, campaign.UserId, CostWeights{Opened: 1, Clicked: 10, Submitted: 100})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(cost, check.Equals, 101.0)
+	fm, err = GetCampaignFirstMovers(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(fm.Clicked.RId, check.Equals, excluded.RId)
+}
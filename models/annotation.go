@@ -0,0 +1,241 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// MaxTagLength is the most characters a result tag can have
+const MaxTagLength = 64
+
+// ErrTagTooLong is thrown when a result tag is longer than MaxTagLength
+var ErrTagTooLong = errors.New("Tags must be 64 characters or fewer")
+
+// ErrNoteTextNotSpecified is thrown when a result note has no text
+var ErrNoteTextNotSpecified = errors.New("Note text not specified")
+
+// ResultTag is a label an analyst has attached to a result, such as
+// "followed up" or "false positive", used to track remediation.
+type ResultTag struct {
+	Id          int64     `json:"-"`
+	CampaignId  int64     `json:"-"`
+	RId         string    `json:"-"`
+	UserId      int64     `json:"-"`
+	Tag         string    `json:"tag"`
+	CreatedDate time.Time `json:"-"`
+}
+
+// ResultNote is a free-text note an analyst has written about a result
+type ResultNote struct {
+	Id           int64     `json:"id"`
+	CampaignId   int64     `json:"-"`
+	RId          string    `json:"-"`
+	UserId       int64     `json:"user_id"`
+	Text         string    `json:"text"`
+	CreatedDate  time.Time `json:"created_date"`
+	ModifiedDate time.Time `json:"modified_date"`
+}
+
+// Validate checks that the note has some text
+func (n *ResultNote) Validate() error {
+	n.Text = strings.TrimSpace(n.Text)
+	if n.Text == "" {
+		return ErrNoteTextNotSpecified
+	}
+	return nil
+}
+
+// normalizeTags trims the tags and removes any which are empty or repeat an
+// earlier tag, ignoring case. The tags are returned sorted.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	ts := []string{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[strings.ToLower(t)] {
+			continue
+		}
+		if len([]rune(t)) > MaxTagLength {
+			return ts, ErrTagTooLong
+		}
+		seen[strings.ToLower(t)] = true
+		ts = append(ts, t)
+	}
+	sort.Strings(ts)
+	return ts, nil
+}
+
+// getTeamResult returns the result with the given result ID if it's owned by
+// the given user or their team. Otherwise, gorm.ErrRecordNotFound is
+// returned.
+func getTeamResult(rid string, uid int64) (Result, error) {
+	r, err := ResultStorage.Get(rid)
+	if err != nil {
+		return r, err
+	}
+	for _, id := range teamUserIds(uid) {
+		if r.UserId == id {
+			return r, nil
+		}
+	}
+	return Result{}, gorm.ErrRecordNotFound
+}
+
+// tags returns the result's tags in alphabetical order
+func (r *Result) tags() ([]string, error) {
+	ts := []string{}
+	err := db.Model(&ResultTag{}).Where("campaign_id=? and r_id=?", r.CampaignId, r.RId).
+		Order("tag asc").Pluck("tag", &ts).Error
+	return ts, err
+}
+
+// notes returns the result's notes, from the earliest written to the latest
+func (r *Result) notes() ([]ResultNote, error) {
+	ns := []ResultNote{}
+	err := db.Where("campaign_id=? and r_id=?", r.CampaignId, r.RId).
+		Order("created_date asc, id asc").Find(&ns).Error
+	return ns, err
+}
+
+// GetResultTags returns the tags of the result with the given result ID,
+// owned by the given user.
+func GetResultTags(rid string, uid int64) ([]string, error) {
+	r, err := getTeamResult(rid, uid)
+	if err != nil {
+		return []string{}, err
+	}
+	return r.tags()
+}
+
+// PutResultTags replaces the tags of the result with the given result ID,
+// owned by the given user, returning the tags saved. Tags are trimmed, and
+// those which repeat another tag, ignoring case, are dropped.
+func PutResultTags(rid string, uid int64, tags []string) ([]string, error) {
+	ts, err := normalizeTags(tags)
+	if err != nil {
+		return ts, err
+	}
+	r, err := getTeamResult(rid, uid)
+	if err != nil {
+		return ts, err
+	}
+	tx := db.Begin()
+	err = tx.Where("campaign_id=? and r_id=?", r.CampaignId, r.RId).Delete(&ResultTag{}).Error
+	if err != nil {
+		tx.Rollback()
+		return ts, err
+	}
+	now := time.Now().UTC()
+	for _, t := range ts {
+		err = tx.Save(&ResultTag{CampaignId: r.CampaignId, RId: r.RId, UserId: uid, Tag: t, CreatedDate: now}).Error
+		if err != nil {
+			tx.Rollback()
+			return ts, err
+		}
+	}
+	return ts, tx.Commit().Error
+}
+
+// GetResultNotes returns the notes written about the result with the given
+// result ID, owned by the given user.
+func GetResultNotes(rid string, uid int64) ([]ResultNote, error) {
+	r, err := getTeamResult(rid, uid)
+	if err != nil {
+		return []ResultNote{}, err
+	}
+	return r.notes()
+}
+
+// PostResultNote adds the note to the result with the given result ID, owned
+// by the given user, recording the given user as its author.
+func PostResultNote(rid string, uid int64, n *ResultNote) error {
+	err := n.Validate()
+	if err != nil {
+		return err
+	}
+	r, err := getTeamResult(rid, uid)
+	if err != nil {
+		return err
+	}
+	n.Id = 0
+	n.CampaignId = r.CampaignId
+	n.RId = r.RId
+	n.UserId = uid
+	n.CreatedDate = time.Now().UTC()
+	n.ModifiedDate = n.CreatedDate
+	return db.Save(n).Error
+}
+
+// getResultNote returns the note with the given id written about the result
+// with the given result ID, owned by the given user.
+func getResultNote(rid string, id int64, uid int64) (ResultNote, error) {
+	n := ResultNote{}
+	r, err := getTeamResult(rid, uid)
+	if err != nil {
+		return n, err
+	}
+	err = db.Where("id=? and campaign_id=? and r_id=?", id, r.CampaignId, r.RId).First(&n).Error
+	return n, err
+}
+
+// PutResultNote changes the text of the note with the given id written about
+// the result with the given result ID, owned by the given user.
+func PutResultNote(rid string, uid int64, n *ResultNote) error {
+	err := n.Validate()
+	if err != nil {
+		return err
+	}
+	current, err := getResultNote(rid, n.Id, uid)
+	if err != nil {
+		return err
+	}
+	current.Text = n.Text
+	current.ModifiedDate = time.Now().UTC()
+	err = db.Save(&current).Error
+	if err != nil {
+		return err
+	}
+	*n = current
+	return nil
+}
+
+// DeleteResultNote deletes the note with the given id written about the
+// result with the given result ID, owned by the given user.
+func DeleteResultNote(rid string, id int64, uid int64) error {
+	n, err := getResultNote(rid, id, uid)
+	if err != nil {
+		return err
+	}
+	return db.Delete(&n).Error
+}
+
+// loadAnnotations fills in the tags and notes of the campaign's results
+func loadAnnotations(cid int64, rs []Result) error {
+	ts := []ResultTag{}
+	err := db.Where("campaign_id=?", cid).Order("tag asc").Find(&ts).Error
+	if err != nil {
+		return err
+	}
+	ns := []ResultNote{}
+	err = db.Where("campaign_id=?", cid).Order("created_date asc, id asc").Find(&ns).Error
+	if err != nil {
+		return err
+	}
+	tags := make(map[string][]string)
+	for _, t := range ts {
+		tags[t.RId] = append(tags[t.RId], t.Tag)
+	}
+	notes := make(map[string][]ResultNote)
+	for _, n := range ns {
+		notes[n.RId] = append(notes[n.RId], n)
+	}
+	for i := range rs {
+		rs[i].Tags = tags[rs[i].RId]
+		rs[i].Notes = notes[rs[i].RId]
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestPutResultTags(ch *check.C) {
+	c := s.createCampaign(ch)
+	r := c.Results[0]
+	ts, err := PutResultTags(r.RId, c.UserId, []string{" VIP ", "followed up", "vip", ""})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ts, check.DeepEquals, []string{"VIP", "followed up"})
+	ts, err = GetResultTags(r.RId, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ts, check.DeepEquals, []string{"VIP", "followed up"})
+
+	// The tags are replaced, rather than added to
+	_, err = PutResultTags(r.RId, c.UserId, []string{"false positive"})
+	ch.Assert(err, check.Equals, nil)
+	ts, err = GetResultTags(r.RId, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ts, check.DeepEquals, []string{"false positive"})
+
+	_, err = PutResultTags(r.RId, c.UserId, []string{strings.Repeat("x", MaxTagLength+1)})
+	ch.Assert(err, check.Equals, ErrTagTooLong)
+	_, err = PutResultTags(r.RId, c.UserId+1, []string{"VIP"})
+	ch.Assert(err, check.Equals, gorm.ErrRecordNotFound)
+}
+
+func (s *ModelsSuite) TestResultNotes(ch *check.C) {
+	c := s.createCampaign(ch)
+	r := c.Results[0]
+	n := ResultNote{Text: "  "}
+	ch.Assert(PostResultNote(r.RId, c.UserId, &n), check.Equals, ErrNoteTextNotSpecified)
+	n = ResultNote{Text: "Called to follow up"}
+	ch.Assert(PostResultNote(r.RId, c.UserId, &n), check.Equals, nil)
+	ch.Assert(n.UserId, check.Equals, c.UserId)
+	second := ResultNote{Text: "Completed training"}
+	ch.Assert(PostResultNote(r.RId, c.UserId, &second), check.Equals, nil)
+
+	n.Text = "Called twice to follow up"
+	ch.Assert(PutResultNote(r.RId, c.UserId, &n), check.Equals, nil)
+	ns, err := GetResultNotes(r.RId, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ns), check.Equals, 2)
+	ch.Assert(ns[0].Text, check.Equals, "Called twice to follow up")
+
+	// Notes can only be changed through the result they were written about
+	second.Text = "Moved"
+	ch.Assert(PutResultNote(c.Results[1].RId, c.UserId, &second), check.Equals, gorm.ErrRecordNotFound)
+	ch.Assert(DeleteResultNote(r.RId, second.Id, c.UserId+1), check.Equals, gorm.ErrRecordNotFound)
+	ch.Assert(DeleteResultNote(r.RId, second.Id, c.UserId), check.Equals, nil)
+	ns, err = GetResultNotes(r.RId, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ns), check.Equals, 1)
+}
+
+func (s *ModelsSuite) TestResultAnnotationsLoaded(ch *check.C) {
+	c := s.createCampaign(ch)
+	r := c.Results[0]
+	_, err := PutResultTags(r.RId, c.UserId, []string{"VIP"})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(PostResultNote(r.RId, c.UserId, &ResultNote{Text: "Reported by phone"}), check.Equals, nil)
+
+	cr, err := GetCampaignResults(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	for _, res := range cr.Results {
+		if res.RId != r.RId {
+			ch.Assert(len(res.Tags), check.Equals, 0)
+			continue
+		}
+		ch.Assert(res.Tags, check.DeepEquals, []string{"VIP"})
+		ch.Assert(res.Notes[0].Text, check.Equals, "Reported by phone")
+	}
+
+	var buf bytes.Buffer
+	ch.Assert(ExportResults(&buf, c.Id, c.UserId, false), check.Equals, nil)
+	records, err := csv.NewReader(&buf).ReadAll()
+	ch.Assert(err, check.Equals, nil)
+	n := len(resultExportColumns)
+	ch.Assert(records[0][n-2:], check.DeepEquals, []string{"tags", "notes"})
+	found := false
+	for _, record := range records[1:] {
+		if record[0] == r.Email {
+			ch.Assert(record[n-2:], check.DeepEquals, []string{"VIP", "Reported by phone"})
+			found = true
+		}
+	}
+	ch.Assert(found, check.Equals, true)
+
+	// Notes can contain personal information, so they're removed when the
+	// result is anonymized
+	ch.Assert(r.Anonymize(), check.Equals, nil)
+	ns, err := GetResultNotes(r.RId, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ns), check.Equals, 0)
+}
@@ -0,0 +1,197 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// hashEmail returns the HMAC-SHA256 of the normalized email address, keyed
+// with RetentionHashKey, so that the same address gives the same hash while
+// the key is unchanged. Since the key is kept on the server, the hash can't be
+// reversed by hashing a list of known addresses. Empty addresses stay empty.
+func hashEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(RetentionHashKey))
+	mac.Write([]byte(email))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// scrub blanks the personal information stored on the result and its events,
+// and deletes its notes, using the given transaction, replacing the email
+// address with the given one. The result's status, timestamps and whether it
+// was reported are kept so that campaign statistics don't change.
+func (r *Result) scrub(tx *gorm.DB, email string) error {
+	if r.Email != "" {
+		err := tx.Model(&Event{}).Where("campaign_id=? and email=?", r.CampaignId, r.Email).
+			Updates(map[string]interface{}{"email": email, "details": ""}).Error
+		if err != nil {
+			return err
+		}
+	}
+	err := tx.Where("campaign_id=? and r_id=?", r.CampaignId, r.RId).Delete(&ResultNote{}).Error
+	if err != nil {
+		return err
+	}
+	r.Email = email
+	r.FirstName = ""
+	r.LastName = ""
+	r.Position = ""
+	r.IP = ""
+	r.Latitude = 0
+	r.Longitude = 0
+	r.ReverseDNS = ""
+	r.City = ""
+	r.AcceptLanguage = ""
+	r.AttributesJSON = ""
+	r.Variables = map[string]string{}
+	// Removed results are scrubbed too, so the soft delete scope is skipped
+	return tx.Unscoped().Save(r).Error
+}
+
+// scrubSnapshots blanks the personal information of the results with the
+// given ids from the campaign's snapshots using the given transaction,
+// replacing their email addresses with those the ids map to.
+func scrubSnapshots(tx *gorm.DB, cid int64, emails map[string]string) error {
+	ss := []Snapshot{}
+	err := tx.Where("campaign_id=?", cid).Find(&ss).Error
+	if err != nil {
+		return err
+	}
+	for i := range ss {
+		sd := snapshotData{}
+		err = json.Unmarshal([]byte(ss[i].Data), &sd)
+		if err != nil {
+			return err
+		}
+		for j, sr := range sd.Results {
+			email, ok := emails[sr.Id]
+			if !ok {
+				continue
+			}
+			sd.Results[j].Email = email
+			sd.Results[j].FirstName = ""
+			sd.Results[j].LastName = ""
+			sd.Results[j].Position = ""
+		}
+		data, err := json.Marshal(sd)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&ss[i]).UpdateColumn("data", string(data)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// anonymizedEmailPrefix is the prefix of the placeholder email addresses given
+// to anonymized results.
+const anonymizedEmailPrefix = "anonymized+"
+
+// anonymizedEmail returns the placeholder stored in place of the result's
+// email address once it's anonymized. Events are matched to their result by
+// email address, so each result is given its own placeholder to keep their
+// events apart.
+func anonymizedEmail(r *Result) string {
+	return anonymizedEmailPrefix + r.RId
+}
+
+// isAnonymized returns whether or not the result's email address has been
+// replaced by anonymizedEmail.
+func (r *Result) isAnonymized() bool {
+	return strings.HasPrefix(r.Email, anonymizedEmailPrefix)
+}
+
+// anonymizeResults scrubs the given results, along with their events and
+// snapshot entries, in a single transaction. The email addresses are replaced
+// with a placeholder, or with their hashes if hash is true.
+func anonymizeResults(cid int64, rs []*Result, hash bool) error {
+	// Held saves are written first, so that they can't restore the personal
+	// information later
+	err := FlushResults()
+	if err != nil {
+		return err
+	}
+	tx := db.Begin()
+	emails := make(map[string]string)
+	for _, r := range rs {
+		email := anonymizedEmail(r)
+		if hash && r.Email != "" {
+			email = hashEmail(r.Email)
+		}
+		err := r.scrub(tx, email)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		emails[r.RId] = email
+	}
+	err = scrubSnapshots(tx, cid, emails)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// Anonymize removes the personal information stored for the result, such as
+// for a data erasure request. The email address is replaced with a
+// placeholder unique to the result, and the name, position, IP address,
+// location and client details are blanked. The email address and details of
+// the result's events are scrubbed too, since the details can contain
+// submitted credentials, and any notes written about the result are deleted.
+// The result's id, status, timestamps and whether it was reported are kept so
+// that campaign statistics don't change.
+func (r *Result) Anonymize() error {
+	// Load the result through the store, so that a pending save can't restore
+	// the scrubbed information later
+	current, err := ResultStorage.Get(r.RId)
+	if err != nil {
+		return err
+	}
+	err = anonymizeResults(current.CampaignId, []*Result{&current}, false)
+	if err != nil {
+		return err
+	}
+	*r = current
+	return nil
+}
+
+// AnonymizeCampaignResults removes the personal information stored for every
+// result in the campaign specified by the given id and user_id, including
+// results removed with DeleteResult, as described by Anonymize.
+func AnonymizeCampaignResults(cid int64, uid int64) error {
+	rs, err := allCampaignResults(cid, uid)
+	if err != nil {
+		return err
+	}
+	return anonymizeResults(cid, rs, false)
+}
+
+// allCampaignResults returns every result in the campaign specified by the
+// given id and user_id, including results removed with DeleteResult.
+func allCampaignResults(cid int64, uid int64) ([]*Result, error) {
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := GetDeletedResults(cid, uid)
+	if err != nil {
+		return nil, err
+	}
+	rs = append(rs, deleted...)
+	ptrs := make([]*Result, len(rs))
+	for i := range rs {
+		ptrs[i] = &rs[i]
+	}
+	return ptrs, nil
+}
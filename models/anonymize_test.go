@@ -0,0 +1,142 @@
+package models
+
+import (
+	"net/url"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestResultAnonymize(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	victim, other := campaign.Results[0], campaign.Results[1]
+	victim.IP = "128.101.101.101"
+	victim.Latitude, victim.Longitude = 44.9759, -93.2166
+	victim.City = "Minneapolis"
+	ch.Assert(db.Save(&victim).Error, check.Equals, nil)
+	ch.Assert(victim.HandleFormSubmit(EventDetails{
+		Payload: url.Values{"username": {"victim"}, "password": {"hunter2"}},
+	}), check.Equals, nil)
+	ch.Assert(victim.HandleEmailReport(EventDetails{}), check.Equals, nil)
+	ch.Assert(other.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	_, err := SnapshotCampaignResults(campaign.Id, campaign.UserId, time.Now().UTC())
+	ch.Assert(err, check.Equals, nil)
+	before, err := getCampaignStats(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	email := victim.Email
+
+	ch.Assert(victim.Anonymize(), check.Equals, nil)
+	got, err := GetResult(victim.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Email, check.Equals, "anonymized+"+victim.RId)
+	ch.Assert(got.FirstName, check.Equals, "")
+	ch.Assert(got.LastName, check.Equals, "")
+	ch.Assert(got.Position, check.Equals, "")
+	ch.Assert(got.IP, check.Equals, "")
+	ch.Assert(got.Latitude, check.Equals, 0.0)
+	ch.Assert(got.Longitude, check.Equals, 0.0)
+	ch.Assert(got.City, check.Equals, "")
+	// The result's outcome is kept
+	ch.Assert(got.Status, check.Equals, EVENT_DATA_SUBMIT)
+	ch.Assert(got.Reported, check.Equals, true)
+	ch.Assert(got.ModifiedDate.IsZero(), check.Equals, false)
+	after, err := getCampaignStats(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(after, check.Equals, before)
+
+	// The submitted credentials are removed from the events, which are kept
+	count := 0
+	ch.Assert(db.Model(&Event{}).Where("email=?", email).Count(&count).Error, check.Equals, nil)
+	ch.Assert(count, check.Equals, 0)
+	es := []Event{}
+	ch.Assert(db.Where("campaign_id=? and message=?", campaign.Id, EVENT_DATA_SUBMIT).Find(&es).Error, check.Equals, nil)
+	ch.Assert(len(es), check.Equals, 1)
+	ch.Assert(es[0].Details, check.Equals, "")
+
+	// Other results are untouched
+	got, err = GetResult(other.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Email, check.Equals, other.Email)
+	ch.Assert(db.Model(&Event{}).Where("email=?", other.Email).Count(&count).Error, check.Equals, nil)
+	ch.Assert(count, check.Equals, 1)
+
+	// Snapshots no longer contain the personal information
+	snap := Snapshot{}
+	ch.Assert(db.Where("campaign_id=?", campaign.Id).First(&snap).Error, check.Equals, nil)
+	snap, err = GetSnapshot(snap.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	for _, sr := range snap.Results {
+		if sr.Id == victim.RId {
+			ch.Assert(sr.Email, check.Equals, "anonymized+"+victim.RId)
+			ch.Assert(sr.FirstName, check.Equals, "")
+			ch.Assert(sr.Status, check.Equals, EVENT_DATA_SUBMIT)
+		} else {
+			ch.Assert(sr.Email, check.Equals, other.Email)
+		}
+	}
+}
+
+func (s *ModelsSuite) TestAnonymizeCampaignResults(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(4))
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	before, err := getCampaignStats(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+
+	ch.Assert(AnonymizeCampaignResults(campaign.Id, campaign.UserId), check.Equals, nil)
+	got, err := ResultStorage.List(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(got), check.Equals, 4)
+	for _, r := range got {
+		ch.Assert(r.Email, check.Equals, "anonymized+"+r.RId)
+		ch.Assert(r.FirstName, check.Equals, "")
+	}
+	after, err := getCampaignStats(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(after, check.Equals, before)
+}
+
+func (s *ModelsSuite) TestAnonymizeCampaignResultsDeleted(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(2))
+	rs := campaign.Results
+	ch.Assert(DeleteResult(rs[1].RId), check.Equals, nil)
+
+	ch.Assert(AnonymizeCampaignResults(campaign.Id, campaign.UserId), check.Equals, nil)
+	deleted, err := GetDeletedResults(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(deleted), check.Equals, 1)
+	ch.Assert(deleted[0].Email, check.Equals, "anonymized+"+deleted[0].RId)
+	ch.Assert(deleted[0].DeletedAt, check.NotNil)
+	count := 0
+	ch.Assert(db.Unscoped().Model(&Result{}).Where("campaign_id=?", campaign.Id).Count(&count).Error, check.Equals, nil)
+	ch.Assert(count, check.Equals, 2)
+}
+
+func (s *ModelsSuite) TestAnonymizeKeepsEventsSeparate(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(2))
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	want := make([]int, len(rs))
+	for i := range rs {
+		es, err := rs[i].GetEvents()
+		ch.Assert(err, check.Equals, nil)
+		want[i] = len(es)
+	}
+
+	ch.Assert(AnonymizeCampaignResults(campaign.Id, campaign.UserId), check.Equals, nil)
+	for i := range rs {
+		r, err := GetResult(rs[i].RId)
+		ch.Assert(err, check.Equals, nil)
+		es, err := r.GetEvents()
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(len(es), check.Equals, want[i])
+	}
+	r, err := GetResult(rs[1].RId)
+	ch.Assert(err, check.Equals, nil)
+	es, err := r.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(es[len(es)-1].Message, check.Equals, EVENT_DATA_SUBMIT)
+}
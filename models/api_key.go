@@ -0,0 +1,219 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+)
+
+// The resources an API key can be scoped to. Each resource has a read scope,
+// such as "campaigns:read", and a write scope, such as "campaigns:write".
+// Write scopes don't imply read scopes.
+var APIKeyResources = []string{
+	"campaigns", "results", "groups", "templates", "pages", "smtp", "sms",
+	"keys", "settings", "metrics", "users", "audit", "webhooks",
+}
+
+// SCOPE_ALL grants an API key access to every resource, as the user's own
+// API key has.
+const SCOPE_ALL = "*"
+
+// apiKeyPrefixLength is the number of characters of a key that are stored
+// in the clear, so that users can tell their keys apart
+const apiKeyPrefixLength = 8
+
+// APIKey is an additional API key for a user, which only grants access to
+// the resources in its scopes, and optionally expires. Only a hash of the key
+// is stored, so the key itself is only returned when it's created.
+type APIKey struct {
+	Id          int64      `json:"id"`
+	UserId      int64      `json:"-"`
+	Name        string     `json:"name"`
+	Key         string     `json:"key,omitempty" sql:"-"`
+	KeyHash     string     `json:"-"`
+	KeyPrefix   string     `json:"key_prefix"`
+	Scopes      []string   `json:"scopes" sql:"-"`
+	ScopeList   string     `json:"-" gorm:"column:scopes"`
+	CreatedDate time.Time  `json:"created_date"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	RevokedDate *time.Time `json:"revoked_date,omitempty"`
+}
+
+// ErrAPIKeyNameNotSpecified is thrown when an API key has no name
+var ErrAPIKeyNameNotSpecified = errors.New("API key name not specified")
+
+// ErrAPIKeyScopesNotSpecified is thrown when an API key has no scopes
+var ErrAPIKeyScopesNotSpecified = errors.New("No API key scopes specified")
+
+// ErrInvalidAPIKeyScope is thrown when an API key has a scope for an unknown
+// resource or access level
+var ErrInvalidAPIKeyScope = errors.New("Invalid API key scope")
+
+// ErrAPIKeyExpiryInPast is thrown when an API key is created already expired
+var ErrAPIKeyExpiryInPast = errors.New("API key expiry must be in the future")
+
+// ErrAPIKeyExpired is thrown when an expired API key is used
+var ErrAPIKeyExpired = errors.New("API key has expired")
+
+// ErrAPIKeyRevoked is thrown when a revoked API key is used
+var ErrAPIKeyRevoked = errors.New("API key has been revoked")
+
+// TableName specifies the database tablename for Gorm to use
+func (k APIKey) TableName() string {
+	return "api_keys"
+}
+
+// validScope returns whether or not the scope is SCOPE_ALL, or a read or
+// write scope for one of the APIKeyResources.
+func validScope(scope string) bool {
+	if scope == SCOPE_ALL {
+		return true
+	}
+	parts := strings.Split(scope, ":")
+	if len(parts) != 2 || (parts[1] != "read" && parts[1] != "write") {
+		return false
+	}
+	for _, r := range APIKeyResources {
+		if parts[0] == r {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate ensures that the API key has a name and valid scopes, and doesn't
+// expire in the past.
+func (k *APIKey) Validate() error {
+	switch {
+	case k.Name == "":
+		return ErrAPIKeyNameNotSpecified
+	case len(k.Scopes) == 0:
+		return ErrAPIKeyScopesNotSpecified
+	case k.ExpiresAt != nil && !k.ExpiresAt.After(time.Now()):
+		return ErrAPIKeyExpiryInPast
+	}
+	for _, s := range k.Scopes {
+		if !validScope(s) {
+			return ErrInvalidAPIKeyScope
+		}
+	}
+	return nil
+}
+
+// HasScope returns whether or not the key grants the given scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == SCOPE_ALL || s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAPIKey returns the hex encoded SHA-256 hash the key is stored as
+func hashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// loadScopes fills in the key's scopes from the stored list
+func (k *APIKey) loadScopes() {
+	k.Scopes = []string{}
+	if k.ScopeList != "" {
+		k.Scopes = strings.Split(k.ScopeList, ",")
+	}
+}
+
+// GetAPIKeys returns the additional API keys belonging to the given user,
+// including those which have expired or been revoked.
+func GetAPIKeys(uid int64) ([]APIKey, error) {
+	ks := []APIKey{}
+	err := db.Where("user_id=?", uid).Order("id asc").Find(&ks).Error
+	if err != nil {
+		log.Error(err)
+		return ks, err
+	}
+	for i := range ks {
+		ks[i].loadScopes()
+	}
+	return ks, nil
+}
+
+// GetAPIKey returns the API key, if it exists, specified by the given id and
+// user_id.
+func GetAPIKey(id int64, uid int64) (APIKey, error) {
+	k := APIKey{}
+	err := db.Where("user_id=? and id=?", uid, id).First(&k).Error
+	if err != nil {
+		return k, err
+	}
+	k.loadScopes()
+	return k, nil
+}
+
+// GetAPIKeyByKey returns the API key matching the given key. An error is
+// returned if there's no such key, or if it has expired or been revoked.
+func GetAPIKeyByKey(key string) (APIKey, error) {
+	k := APIKey{}
+	err := db.Where("key_hash=?", hashAPIKey(key)).First(&k).Error
+	if err != nil {
+		return k, err
+	}
+	k.loadScopes()
+	switch {
+	case k.RevokedDate != nil:
+		return k, ErrAPIKeyRevoked
+	case k.ExpiresAt != nil && !k.ExpiresAt.After(time.Now()):
+		return k, ErrAPIKeyExpired
+	}
+	return k, nil
+}
+
+// PostAPIKey creates a new API key for the user. The generated key is set on
+// the APIKey, and can't be retrieved again once it's returned to the user.
+func PostAPIKey(k *APIKey) error {
+	err := k.Validate()
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return err
+	}
+	k.Key = fmt.Sprintf("%x", b)
+	k.KeyHash = hashAPIKey(k.Key)
+	k.KeyPrefix = k.Key[:apiKeyPrefixLength]
+	k.ScopeList = strings.Join(k.Scopes, ",")
+	k.CreatedDate = time.Now().UTC()
+	k.RevokedDate = nil
+	err = db.Save(k).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// RevokeAPIKey revokes the API key specified by the given id and user_id, so
+// that it can no longer be used. Revoked keys are kept for auditing.
+func RevokeAPIKey(id int64, uid int64) error {
+	k, err := GetAPIKey(id, uid)
+	if err != nil {
+		return err
+	}
+	if k.RevokedDate != nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	err = db.Model(&k).Update("revoked_date", now).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
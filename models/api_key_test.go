@@ -0,0 +1,93 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestAPIKeyValidation(c *check.C) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	for _, tc := range []struct {
+		key APIKey
+		err error
+	}{
+		{APIKey{Scopes: []string{"campaigns:read"}}, ErrAPIKeyNameNotSpecified},
+		{APIKey{Name: "Test"}, ErrAPIKeyScopesNotSpecified},
+		{APIKey{Name: "Test", Scopes: []string{"campaigns"}}, ErrInvalidAPIKeyScope},
+		{APIKey{Name: "Test", Scopes: []string{"campaigns:delete"}}, ErrInvalidAPIKeyScope},
+		{APIKey{Name: "Test", Scopes: []string{"accounts:read"}}, ErrInvalidAPIKeyScope},
+		{APIKey{Name: "Test", Scopes: []string{"campaigns:read"}, ExpiresAt: &past}, ErrAPIKeyExpiryInPast},
+		{APIKey{Name: "Test", Scopes: []string{"campaigns:read", "groups:write"}, ExpiresAt: &future}, nil},
+		{APIKey{Name: "Test", Scopes: []string{SCOPE_ALL}}, nil},
+	} {
+		c.Assert(tc.key.Validate(), check.Equals, tc.err)
+	}
+}
+
+func (s *ModelsSuite) TestAPIKeyHasScope(c *check.C) {
+	k := APIKey{Scopes: []string{"campaigns:read"}}
+	c.Assert(k.HasScope("campaigns:read"), check.Equals, true)
+	c.Assert(k.HasScope("campaigns:write"), check.Equals, false)
+	c.Assert(k.HasScope("groups:read"), check.Equals, false)
+	k.Scopes = []string{SCOPE_ALL}
+	c.Assert(k.HasScope("groups:write"), check.Equals, true)
+}
+
+func (s *ModelsSuite) TestPostAPIKey(c *check.C) {
+	k := APIKey{UserId: 1, Name: "Test", Scopes: []string{"campaigns:read", "results:read"}}
+	c.Assert(PostAPIKey(&k), check.Equals, nil)
+	c.Assert(len(k.Key), check.Equals, 64)
+	c.Assert(k.KeyPrefix, check.Equals, k.Key[:apiKeyPrefixLength])
+
+	// Only the hash of the key should be stored
+	got, err := GetAPIKey(k.Id, 1)
+	c.Assert(err, check.Equals, nil)
+	c.Assert(got.Key, check.Equals, "")
+	c.Assert(got.KeyHash, check.Equals, hashAPIKey(k.Key))
+	c.Assert(got.Scopes, check.DeepEquals, k.Scopes)
+
+	got, err = GetAPIKeyByKey(k.Key)
+	c.Assert(err, check.Equals, nil)
+	c.Assert(got.Id, check.Equals, k.Id)
+
+	_, err = GetAPIKeyByKey("bogus")
+	c.Assert(err, check.Equals, gorm.ErrRecordNotFound)
+
+	// Keys belonging to other users shouldn't be returned
+	_, err = GetAPIKey(k.Id, 2)
+	c.Assert(err, check.Equals, gorm.ErrRecordNotFound)
+	ks, err := GetAPIKeys(2)
+	c.Assert(err, check.Equals, nil)
+	c.Assert(len(ks), check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestAPIKeyExpired(c *check.C) {
+	expires := time.Now().Add(time.Hour)
+	k := APIKey{UserId: 1, Name: "Test", Scopes: []string{"campaigns:read"}, ExpiresAt: &expires}
+	c.Assert(PostAPIKey(&k), check.Equals, nil)
+	_, err := GetAPIKeyByKey(k.Key)
+	c.Assert(err, check.Equals, nil)
+
+	db.Model(&k).Update("expires_at", time.Now().Add(-time.Minute))
+	_, err = GetAPIKeyByKey(k.Key)
+	c.Assert(err, check.Equals, ErrAPIKeyExpired)
+}
+
+func (s *ModelsSuite) TestRevokeAPIKey(c *check.C) {
+	k := APIKey{UserId: 1, Name: "Test", Scopes: []string{"campaigns:read"}}
+	c.Assert(PostAPIKey(&k), check.Equals, nil)
+	c.Assert(RevokeAPIKey(k.Id, 2), check.Equals, gorm.ErrRecordNotFound)
+	c.Assert(RevokeAPIKey(k.Id, 1), check.Equals, nil)
+
+	_, err := GetAPIKeyByKey(k.Key)
+	c.Assert(err, check.Equals, ErrAPIKeyRevoked)
+
+	// Revoked keys are still listed
+	ks, err := GetAPIKeys(1)
+	c.Assert(err, check.Equals, nil)
+	c.Assert(len(ks), check.Equals, 1)
+	c.Assert(ks[0].RevokedDate, check.NotNil)
+}
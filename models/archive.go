@@ -0,0 +1,220 @@
+package models
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// ArchiveVersion is the version of the format campaign archives are written
+// in
+const ArchiveVersion = 1
+
+// ArchivePath is the directory campaign archives are written to.
+var ArchivePath = "archives"
+
+// ErrCampaignArchived is thrown when a campaign which has already been
+// archived is archived again
+var ErrCampaignArchived = errors.New("Campaign has already been archived")
+
+// ErrCampaignNotArchivable is thrown when a campaign which hasn't been
+// completed is archived
+var ErrCampaignNotArchivable = errors.New("Only completed campaigns can be archived")
+
+// ErrArchiveNotFound is thrown when a campaign's archive can't be found
+var ErrArchiveNotFound = errors.New("Campaign archive not found")
+
+// CampaignArchive is the record of a campaign written to its archive when
+// it's archived, including every result and event.
+type CampaignArchive struct {
+	Version      int           `json:"version"`
+	ArchivedDate time.Time     `json:"archived_date"`
+	Campaign     Campaign      `json:"campaign"`
+	Stats        CampaignStats `json:"stats"`
+}
+
+// archiveEventColumns are the columns written to an archive's events.csv
+var archiveEventColumns = []string{"email", "time", "message", "details"}
+
+// ArchiveFile returns the path of the archive of the campaign with the given
+// id.
+func ArchiveFile(cid int64) string {
+	return filepath.Join(ArchivePath, fmt.Sprintf("campaign-%d.zip", cid))
+}
+
+// IsComplete returns whether or not the campaign has been completed, which
+// includes campaigns that have since been archived.
+func (c *Campaign) IsComplete() bool {
+	return c.Status == CAMPAIGN_COMPLETE || c.Status == CAMPAIGN_ARCHIVED
+}
+
+// writeArchive writes the campaign's archive to w as a zip file containing
+// the campaign with its results and timeline as campaign.json, along with
+// results.csv and events.csv.
+func writeArchive(w io.Writer, c *Campaign, t time.Time) error {
+	stats, err := getCampaignStats(c.Id)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	f, err := zw.Create("campaign.json")
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(CampaignArchive{
+		Version:      ArchiveVersion,
+		ArchivedDate: t,
+		Campaign:     *c,
+		Stats:        stats,
+	})
+	if err != nil {
+		return err
+	}
+	f, err = zw.Create("results.csv")
+	if err != nil {
+		return err
+	}
+	err = ExportResults(f, c.Id, c.UserId, false)
+	if err != nil {
+		return err
+	}
+	f, err = zw.Create("events.csv")
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	err = cw.Write(archiveEventColumns)
+	if err != nil {
+		return err
+	}
+	for _, e := range c.Events {
+		err = cw.Write([]string{e.Email, e.Time.UTC().Format(time.RFC3339Nano), e.Message, e.Details})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	err = cw.Error()
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ArchiveCampaign moves the completed campaign specified by the given id and
+// user_id to cold storage. Its results and events are written to an archive
+// in ArchivePath, and its events, send attempts and maillogs are then
+// deleted. The results are kept, so that the campaign's summary statistics
+// can still be queried, but its timeline is only available from the archive.
+func ArchiveCampaign(id int64, uid int64) (Campaign, error) {
+	// The archive is built from the database, so held saves are written first
+	err := FlushResults()
+	if err != nil {
+		return Campaign{}, err
+	}
+	c, err := GetCampaign(id, uid)
+	if err != nil {
+		return c, err
+	}
+	switch c.Status {
+	case CAMPAIGN_ARCHIVED:
+		return c, ErrCampaignArchived
+	case CAMPAIGN_COMPLETE:
+	default:
+		return c, ErrCampaignNotArchivable
+	}
+	t := time.Now().UTC()
+	err = os.MkdirAll(ArchivePath, 0700)
+	if err != nil {
+		log.Error(err)
+		return c, err
+	}
+	// The archive is written to a temporary file first, so that a partly
+	// written archive is never mistaken for a complete one
+	path := ArchiveFile(c.Id)
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Error(err)
+		return c, err
+	}
+	err = writeArchive(f, &c, t)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		log.Error(err)
+		os.Remove(path + ".tmp")
+		return c, err
+	}
+	tx := db.Begin()
+	for _, m := range []interface{}{&Event{}, &SendAttempt{}, &MailLog{}} {
+		err = tx.Where("campaign_id=?", c.Id).Delete(m).Error
+		if err != nil {
+			tx.Rollback()
+			log.Error(err)
+			return c, err
+		}
+	}
+	err = tx.Model(&c).UpdateColumns(map[string]interface{}{
+		"status":        CAMPAIGN_ARCHIVED,
+		"archived_date": t,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		log.Error(err)
+		return c, err
+	}
+	err = tx.Commit().Error
+	if err != nil {
+		log.Error(err)
+		return c, err
+	}
+	log.WithFields(logrus.Fields{
+		"campaign_id": c.Id,
+		"archive":     path,
+	}).Info("Archived campaign")
+	c.Status = CAMPAIGN_ARCHIVED
+	c.ArchivedDate = t
+	c.Events = []Event{}
+	return c, nil
+}
+
+// OpenCampaignArchive opens the archive of the campaign specified by the
+// given id and user_id for reading. The caller is responsible for closing it.
+func OpenCampaignArchive(id int64, uid int64) (*os.File, error) {
+	c := Campaign{}
+	err := db.Where("id=? and user_id in (?)", id, teamUserIds(uid)).First(&c).Error
+	if err != nil {
+		return nil, err
+	}
+	if c.Status != CAMPAIGN_ARCHIVED {
+		return nil, ErrArchiveNotFound
+	}
+	f, err := os.Open(ArchiveFile(c.Id))
+	if os.IsNotExist(err) {
+		return nil, ErrArchiveNotFound
+	}
+	return f, err
+}
+
+// removeArchive deletes the archive of the campaign with the given id, if it
+// has one.
+func removeArchive(cid int64) error {
+	err := os.Remove(ArchiveFile(cid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestArchiveCampaign(ch *check.C) {
+	defer func(path string) { ArchivePath = path }(ArchivePath)
+	ArchivePath = ch.MkDir()
+	c := s.createCampaign(ch)
+	ch.Assert(c.Results[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	_, err := ArchiveCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, ErrCampaignNotArchivable)
+
+	ch.Assert(CompleteCampaign(c.Id, c.UserId), check.Equals, nil)
+	before, err := getCampaignStats(c.Id)
+	ch.Assert(err, check.Equals, nil)
+	events := 0
+	ch.Assert(db.Model(&Event{}).Where("campaign_id=?", c.Id).Count(&events).Error, check.Equals, nil)
+	archived, err := ArchiveCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(archived.Status, check.Equals, CAMPAIGN_ARCHIVED)
+	_, err = ArchiveCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, ErrCampaignArchived)
+
+	// The events are removed, but the summary statistics are kept
+	count := 0
+	ch.Assert(db.Model(&Event{}).Where("campaign_id=?", c.Id).Count(&count).Error, check.Equals, nil)
+	ch.Assert(count, check.Equals, 0)
+	after, err := getCampaignStats(c.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(after, check.Equals, before)
+	got, err := GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.IsComplete(), check.Equals, true)
+	ch.Assert(got.ArchivedDate.IsZero(), check.Equals, false)
+
+	// The archive has every result and event
+	f, err := OpenCampaignArchive(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	fi, err := f.Stat()
+	ch.Assert(err, check.Equals, nil)
+	zr, err := zip.NewReader(f, fi.Size())
+	ch.Assert(err, check.Equals, nil)
+	files := make(map[string]*zip.File)
+	for _, zf := range zr.File {
+		files[zf.Name] = zf
+	}
+	ch.Assert(len(files), check.Equals, 3)
+	rc, err := files["campaign.json"].Open()
+	ch.Assert(err, check.Equals, nil)
+	ca := CampaignArchive{}
+	ch.Assert(json.NewDecoder(rc).Decode(&ca), check.Equals, nil)
+	rc.Close()
+	ch.Assert(ca.Version, check.Equals, ArchiveVersion)
+	ch.Assert(ca.Campaign.Id, check.Equals, c.Id)
+	ch.Assert(len(ca.Campaign.Results), check.Equals, len(c.Results))
+	ch.Assert(len(ca.Campaign.Events), check.Equals, events)
+	ch.Assert(ca.Stats, check.Equals, before)
+	rc, err = files["events.csv"].Open()
+	ch.Assert(err, check.Equals, nil)
+	records, err := csv.NewReader(rc).ReadAll()
+	ch.Assert(err, check.Equals, nil)
+	rc.Close()
+	ch.Assert(len(records), check.Equals, events+1)
+	f.Close()
+
+	// Deleting the campaign deletes its archive
+	ch.Assert(DeleteCampaign(c.Id), check.Equals, nil)
+	_, err = os.Stat(ArchiveFile(c.Id))
+	ch.Assert(os.IsNotExist(err), check.Equals, true)
+}
+
+func (s *ModelsSuite) TestApplyRetentionArchived(ch *check.C) {
+	defer func(path string) { ArchivePath = path }(ArchivePath)
+	ArchivePath = ch.MkDir()
+	c := s.createCampaign(ch)
+	ch.Assert(CompleteCampaign(c.Id, c.UserId), check.Equals, nil)
+	c, err := ArchiveCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+
+	// The archive can't be anonymized, so it's removed
+	ch.Assert(c.ApplyRetention(RETENTION_ANONYMIZE, c.ArchivedDate), check.Equals, nil)
+	_, err = OpenCampaignArchive(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, ErrArchiveNotFound)
+	got, err := GetResult(c.Results[0].RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Email, check.Equals, hashEmail(c.Results[0].Email))
+}
@@ -1,5 +1,23 @@
 package models
 
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/gophish/gomail"
+	log "github.com/gophish/gophish/logger"
+)
+
 // Attachment contains the fields and methods for
 // an email attachment
 type Attachment struct {
@@ -9,3 +27,201 @@ type Attachment struct {
 	Type       string `json:"type"`
 	Name       string `json:"name"`
 }
+
+// textAttachmentExtensions are the extensions of the attachments whose
+// content is rendered as a template, so that they can include the
+// recipient's details and an {{.AttachmentTracker}}
+var textAttachmentExtensions = map[string]bool{
+	".txt":  true,
+	".htm":  true,
+	".html": true,
+}
+
+// officeAttachmentExtensions are the extensions of the Office Open XML
+// documents whose XML parts are rendered as templates. A document can link a
+// remote image to {{.AttachmentURL}}, or a macro can read it from the
+// document's custom properties.
+var officeAttachmentExtensions = map[string]bool{
+	".docx": true,
+	".docm": true,
+	".xlsx": true,
+	".xlsm": true,
+	".pptx": true,
+	".pptm": true,
+}
+
+// attachmentContext is the data attachments are rendered with, which is the
+// data used for the email along with the recipient's tracking URL for the
+// attachment
+type attachmentContext struct {
+	templateContext
+	AttachmentURL     string
+	AttachmentTracker string
+}
+
+// attachmentURL returns the URL which records the recipient opening the
+// named attachment, served alongside the given tracking URL
+func attachmentURL(trackingURL *url.URL, name string) string {
+	if trackingURL == nil {
+		return ""
+	}
+	u := *trackingURL
+	u.Path = path.Join(path.Dir(u.Path), "/attachment")
+	q := u.Query()
+	q.Set(AttachmentParameter, name)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// attachmentTracker returns the hidden image which requests the given
+// attachment URL
+func attachmentTracker(u string) string {
+	if u == "" {
+		return ""
+	}
+	return "<img alt='' style='display: none' src='" + u + "'/>"
+}
+
+// Render returns the decoded content of the attachment. The template
+// variables in text and Office attachments are replaced using the given data.
+func (a Attachment) Render(data interface{}) ([]byte, error) {
+	content, err := base64.StdEncoding.DecodeString(a.Content)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(a.Name))
+	switch {
+	case textAttachmentExtensions[ext]:
+		if !bytes.Contains(content, []byte("{{")) {
+			return content, nil
+		}
+		rendered, err := buildTemplate(string(content), data)
+		return []byte(rendered), err
+	case officeAttachmentExtensions[ext]:
+		return renderOfficeDocument(content, data)
+	}
+	return content, nil
+}
+
+// renderOfficeDocument renders the XML parts of the Office Open XML document
+// which use template variables. Values are escaped, so that they can't break
+// the document's XML.
+func renderOfficeDocument(content []byte, data interface{}) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+	buff := &bytes.Buffer{}
+	zw := zip.NewWriter(buff)
+	for _, f := range zr.File {
+		part, err := readZipFile(f)
+		if err != nil {
+			return nil, err
+		}
+		ext := strings.ToLower(path.Ext(f.Name))
+		if (ext == ".xml" || ext == ".rels") && bytes.Contains(part, []byte("{{")) {
+			part, err = buildXMLTemplate(string(part), data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", f.Name, err)
+			}
+		}
+		fh := f.FileHeader
+		w, err := zw.CreateHeader(&fh)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write(part)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = zw.Close()
+	return buff.Bytes(), err
+}
+
+// readZipFile returns the uncompressed content of the file in a zip archive
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b := &bytes.Buffer{}
+	_, err = io.Copy(b, rc)
+	return b.Bytes(), err
+}
+
+// xmlEscape returns the value printed the way a template would print it,
+// escaped for use in XML text or attributes
+func xmlEscape(args ...interface{}) string {
+	b := &bytes.Buffer{}
+	xml.EscapeText(b, []byte(fmt.Sprint(args...)))
+	return b.String()
+}
+
+// buildXMLTemplate renders the template like buildTemplate, except that the
+// output of every action is XML escaped
+func buildXMLTemplate(text string, data interface{}) ([]byte, error) {
+	buff := bytes.Buffer{}
+	tmpl, err := template.New("template").Option("missingkey=zero").
+		Funcs(template.FuncMap{"xmlEscape": xmlEscape}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			escapeActions(t.Tree.Root)
+		}
+	}
+	err = tmpl.Execute(&buff, data)
+	return buff.Bytes(), err
+}
+
+// escapeActions pipes the output of the actions in the node through
+// xmlEscape
+func escapeActions(n parse.Node) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			escapeActions(c)
+		}
+	case *parse.ActionNode:
+		// Variable declarations don't print anything
+		if len(n.Pipe.Decl) > 0 {
+			return
+		}
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      n.Pos,
+			Args:     []parse.Node{parse.NewIdentifier("xmlEscape").SetTree(nil).SetPos(n.Pos)},
+		})
+	case *parse.IfNode:
+		escapeActions(n.List)
+		escapeActions(n.ElseList)
+	case *parse.RangeNode:
+		escapeActions(n.List)
+		escapeActions(n.ElseList)
+	case *parse.WithNode:
+		escapeActions(n.List)
+		escapeActions(n.ElseList)
+	}
+}
+
+// attachFile attaches the attachment to the message, rendered with the given
+// data. If the attachment can't be rendered, it's attached as it was
+// uploaded.
+func attachFile(msg *gomail.Message, a Attachment, data interface{}) {
+	content, err := a.Render(data)
+	if err != nil {
+		log.Warn(err)
+		content, _ = base64.StdEncoding.DecodeString(a.Content)
+	}
+	h := map[string][]string{"Content-ID": {fmt.Sprintf("<%s>", a.Name)}}
+	msg.Attach(a.Name, gomail.SetCopyFunc(func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	}), gomail.SetHeader(h))
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+
+	"github.com/gophish/gomail"
+	"github.com/jordan-wright/email"
+	"gopkg.in/check.v1"
+)
+
+// newOfficeAttachment returns an attachment with a Word document made up of
+// the given parts
+func newOfficeAttachment(ch *check.C, name string, parts map[string]string) Attachment {
+	buff := &bytes.Buffer{}
+	zw := zip.NewWriter(buff)
+	for n, content := range parts {
+		w, err := zw.Create(n)
+		ch.Assert(err, check.Equals, nil)
+		_, err = w.Write([]byte(content))
+		ch.Assert(err, check.Equals, nil)
+	}
+	ch.Assert(zw.Close(), check.Equals, nil)
+	return Attachment{
+		Name:    name,
+		Type:    "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		Content: base64.StdEncoding.EncodeToString(buff.Bytes()),
+	}
+}
+
+// readOfficeParts returns the parts of the given Office document
+func readOfficeParts(ch *check.C, content []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	ch.Assert(err, check.Equals, nil)
+	parts := map[string]string{}
+	for _, f := range zr.File {
+		b, err := readZipFile(f)
+		ch.Assert(err, check.Equals, nil)
+		parts[f.Name] = string(b)
+	}
+	return parts
+}
+
+func newAttachmentContext(firstName string) attachmentContext {
+	u, _ := url.Parse("http://example.com/track?rid=1234")
+	td := templateContext{Result: Result{FirstName: firstName, RId: "1234"}}
+	au := attachmentURL(u, "Invoice.docx")
+	return attachmentContext{td, au, attachmentTracker(au)}
+}
+
+func (s *ModelsSuite) TestAttachmentURL(ch *check.C) {
+	for _, tc := range []struct {
+		trackingURL string
+		expected    string
+	}{
+		{"http://example.com/track?rid=1234", "http://example.com/attachment?attachment=Invoice+Q3.docx&rid=1234"},
+		{"http://example.com/billing/track?rid=1234", "http://example.com/billing/attachment?attachment=Invoice+Q3.docx&rid=1234"},
+	} {
+		u, _ := url.Parse(tc.trackingURL)
+		ch.Assert(attachmentURL(u, "Invoice Q3.docx"), check.Equals, tc.expected)
+	}
+	ch.Assert(attachmentURL(nil, "Invoice.docx"), check.Equals, "")
+}
+
+func (s *ModelsSuite) TestRenderTextAttachment(ch *check.C) {
+	a := Attachment{
+		Name:    "Invoice.HTML",
+		Content: base64.StdEncoding.EncodeToString([]byte("Hi {{.FirstName}}{{.AttachmentTracker}}")),
+	}
+	content, err := a.Render(newAttachmentContext("Alice"))
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(string(content), check.Equals,
+		"Hi Alice<img alt='' style='display: none' src='http://example.com/attachment?attachment=Invoice.docx&rid=1234'/>")
+
+	// Other attachments are left as they are
+	a.Name = "Invoice.pdf"
+	content, err = a.Render(newAttachmentContext("Alice"))
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(string(content), check.Equals, "Hi {{.FirstName}}{{.AttachmentTracker}}")
+}
+
+func (s *ModelsSuite) TestRenderOfficeAttachment(ch *check.C) {
+	a := newOfficeAttachment(ch, "Invoice.docx", map[string]string{
+		"word/document.xml": `<w:document><w:t>Dear {{.FirstName}}</w:t>{{if .RId}}<w:t>{{.RId}}</w:t>{{end}}</w:document>`,
+		"word/_rels/document.xml.rels": `<Relationships><Relationship Id="rId9" TargetMode="External" ` +
+			`Target="{{.AttachmentURL}}"/></Relationships>`,
+		"word/media/image1.png": "not {{ a template",
+	})
+	content, err := a.Render(newAttachmentContext("Tom & Jerry"))
+	ch.Assert(err, check.Equals, nil)
+	parts := readOfficeParts(ch, content)
+	ch.Assert(parts["word/document.xml"], check.Equals, `<w:document><w:t>Dear Tom &amp; Jerry</w:t><w:t>1234</w:t></w:document>`)
+	ch.Assert(parts["word/_rels/document.xml.rels"], check.Equals, `<Relationships><Relationship Id="rId9" TargetMode="External" `+
+		`Target="http://example.com/attachment?attachment=Invoice.docx&amp;rid=1234"/></Relationships>`)
+	ch.Assert(parts["word/media/image1.png"], check.Equals, "not {{ a template")
+
+	// Documents which aren't valid Office documents can't be rendered
+	a.Content = base64.StdEncoding.EncodeToString([]byte("not a zip"))
+	_, err = a.Render(newAttachmentContext("Alice"))
+	ch.Assert(err, check.NotNil)
+}
+
+func (s *ModelsSuite) TestTemplateValidateAttachments(ch *check.C) {
+	t := Template{Name: "Attachments", Text: "{{.URL}}"}
+	t.Attachments = []Attachment{newOfficeAttachment(ch, "Invoice.docx", map[string]string{
+		"word/document.xml": `<w:t>{{.FirstName}}</w:t>`,
+	})}
+	ch.Assert(t.Validate(), check.Equals, nil)
+	// Word can split a template variable across runs of text
+	t.Attachments = []Attachment{newOfficeAttachment(ch, "Invoice.docx", map[string]string{
+		"word/document.xml": `<w:t>{{.First</w:t><w:t>Name}}</w:t>`,
+	})}
+	ch.Assert(t.Validate(), check.NotNil)
+}
+
+func (s *ModelsSuite) TestMailLogGenerateTrackedAttachment(ch *check.C) {
+	template := Template{
+		Name:    "AttachmentTemplate",
+		UserId:  1,
+		Text:    "See attached",
+		Subject: "Invoice",
+		Attachments: []Attachment{{
+			Name:    "invoice.html",
+			Type:    "text/html",
+			Content: base64.StdEncoding.EncodeToString([]byte(`{{.FirstName}}{{.AttachmentTracker}}`)),
+		}},
+	}
+	ch.Assert(PostTemplate(&template), check.Equals, nil)
+	campaign := s.createCampaignDependencies(ch)
+	campaign.URL = "http://127.0.0.1/"
+	campaign.Template = template
+	ch.Assert(PostCampaign(&campaign, campaign.UserId), check.Equals, nil)
+	result := campaign.Results[0]
+
+	m := &MailLog{}
+	err := db.Where("r_id=? AND campaign_id=?", result.RId, campaign.Id).Find(m).Error
+	ch.Assert(err, check.Equals, nil)
+	msg := gomail.NewMessage()
+	ch.Assert(m.Generate(msg), check.Equals, nil)
+	msgBuff := &bytes.Buffer{}
+	_, err = msg.WriteTo(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	got, err := email.NewEmailFromReader(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(got.Attachments), check.Equals, 1)
+	expected := fmt.Sprintf("%s<img alt='' style='display: none' src='http://127.0.0.1/attachment?%s=invoice.html&%s=%s'/>",
+		result.FirstName, AttachmentParameter, RecipientParameter, result.RId)
+	ch.Assert(string(got.Attachments[0].Content), check.Equals, expected)
+}
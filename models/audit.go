@@ -0,0 +1,226 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+)
+
+// AuditRedactedFields are the fields whose values are replaced before a
+// resource is recorded in the audit log, at any depth, so that credentials
+// aren't stored in it.
+var AuditRedactedFields = []string{
+	"api_key", "key", "password", "secret", "client_secret", "token",
+	"access_key_id", "secret_access_key", "auth_token", "hash", "bind_password",
+}
+
+// auditRedacted is the value redacted fields are replaced with
+const auditRedacted = "[redacted]"
+
+// DefaultAuditLogLimit is the number of audit log entries returned when no
+// limit is given
+const DefaultAuditLogLimit = 100
+
+// MaxAuditLogLimit is the largest number of audit log entries returned at once
+const MaxAuditLogLimit = 1000
+
+// AuditLog records a state-changing action made by a user through the admin
+// UI or the API, along with the resource before and after the change.
+type AuditLog struct {
+	Id         int64           `json:"id"`
+	UserId     int64           `json:"user_id"`
+	Username   string          `json:"username"`
+	Action     string          `json:"action"`
+	Resource   string          `json:"resource"`
+	ResourceId int64           `json:"resource_id"`
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	Status     int             `json:"status"`
+	IP         string          `json:"ip" gorm:"column:ip"`
+	Before     json.RawMessage `json:"before,omitempty" sql:"-"`
+	After      json.RawMessage `json:"after,omitempty" sql:"-"`
+	Diff       json.RawMessage `json:"diff,omitempty" sql:"-"`
+	BeforeJSON string          `json:"-" gorm:"column:before_state"`
+	AfterJSON  string          `json:"-" gorm:"column:after_state"`
+	DiffJSON   string          `json:"-" gorm:"column:diff"`
+	Time       time.Time       `json:"time"`
+}
+
+// AuditChange is the value of a field before and after a change
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditLogFilter limits the audit log entries returned by GetAuditLogs.
+// Zero values match every entry.
+type AuditLogFilter struct {
+	UserId     int64
+	Action     string
+	Resource   string
+	ResourceId int64
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+	Offset     int
+}
+
+// TableName specifies the database tablename for Gorm to use
+func (a AuditLog) TableName() string {
+	return "audit_logs"
+}
+
+// redact replaces the values of AuditRedactedFields in the decoded JSON value
+func redact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, value := range t {
+			redacted := false
+			for _, f := range AuditRedactedFields {
+				if strings.ToLower(k) == f {
+					redacted = true
+					break
+				}
+			}
+			if redacted {
+				if value != nil && value != "" {
+					t[k] = auditRedacted
+				}
+				continue
+			}
+			t[k] = redact(value)
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = redact(t[i])
+		}
+	}
+	return v
+}
+
+// auditState returns the resource as redacted JSON, decoded so that it can
+// be compared, or nil if there is no resource.
+func auditState(resource interface{}) (interface{}, error) {
+	if resource == nil {
+		return nil, nil
+	}
+	raw, ok := resource.([]byte)
+	if !ok {
+		var err error
+		raw, err = json.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var v interface{}
+	err := json.Unmarshal(raw, &v)
+	if err != nil {
+		return nil, err
+	}
+	return redact(v), nil
+}
+
+// auditDiff returns the top-level fields which differ between the before
+// and after states of a resource.
+func auditDiff(before, after interface{}) map[string]AuditChange {
+	diff := map[string]AuditChange{}
+	b, _ := before.(map[string]interface{})
+	a, _ := after.(map[string]interface{})
+	for k, bv := range b {
+		if av, ok := a[k]; !ok || !reflect.DeepEqual(av, bv) {
+			diff[k] = AuditChange{Before: bv, After: a[k]}
+		}
+	}
+	for k, av := range a {
+		if _, ok := b[k]; !ok {
+			diff[k] = AuditChange{After: av}
+		}
+	}
+	return diff
+}
+
+// RecordAuditLog stores the audit log entry, with the given states of the
+// resource before and after the action. Either state can be nil, such as
+// when a resource is created or deleted, and can be a value to encode as
+// JSON or already encoded JSON.
+func RecordAuditLog(a *AuditLog, before interface{}, after interface{}) error {
+	b, err := auditState(before)
+	if err != nil {
+		log.Error(err)
+	}
+	af, err := auditState(after)
+	if err != nil {
+		log.Error(err)
+	}
+	if b != nil {
+		a.Before, _ = json.Marshal(b)
+		a.BeforeJSON = string(a.Before)
+	}
+	if af != nil {
+		a.After, _ = json.Marshal(af)
+		a.AfterJSON = string(a.After)
+	}
+	if b != nil || af != nil {
+		a.Diff, _ = json.Marshal(auditDiff(b, af))
+		a.DiffJSON = string(a.Diff)
+	}
+	if a.Time.IsZero() {
+		a.Time = time.Now().UTC()
+	}
+	err = db.Save(a).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// GetAuditLogs returns the audit log entries matching the filter, newest
+// first.
+func GetAuditLogs(f AuditLogFilter) ([]AuditLog, error) {
+	as := []AuditLog{}
+	query := db.Order("time desc, id desc")
+	if f.UserId != 0 {
+		query = query.Where("user_id=?", f.UserId)
+	}
+	if f.Action != "" {
+		query = query.Where("action=?", f.Action)
+	}
+	if f.Resource != "" {
+		query = query.Where("resource=?", f.Resource)
+	}
+	if f.ResourceId != 0 {
+		query = query.Where("resource_id=?", f.ResourceId)
+	}
+	if !f.Since.IsZero() {
+		query = query.Where("time >= ?", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		query = query.Where("time <= ?", f.Until.UTC())
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultAuditLogLimit
+	}
+	if f.Limit > MaxAuditLogLimit {
+		f.Limit = MaxAuditLogLimit
+	}
+	err := query.Limit(f.Limit).Offset(f.Offset).Find(&as).Error
+	if err != nil {
+		log.Error(err)
+		return as, err
+	}
+	for i := range as {
+		if as[i].BeforeJSON != "" {
+			as[i].Before = json.RawMessage(as[i].BeforeJSON)
+		}
+		if as[i].AfterJSON != "" {
+			as[i].After = json.RawMessage(as[i].AfterJSON)
+		}
+		if as[i].DiffJSON != "" {
+			as[i].Diff = json.RawMessage(as[i].DiffJSON)
+		}
+	}
+	return as, nil
+}
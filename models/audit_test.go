@@ -0,0 +1,74 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestRecordAuditLog(c *check.C) {
+	before := SMTP{Id: 1, Name: "Old", Host: "example.com", Password: "secret"}
+	after := SMTP{Id: 1, Name: "New", Host: "example.com", Password: "changed"}
+	a := AuditLog{UserId: 1, Action: "smtp.update", Resource: "smtp", ResourceId: 1}
+	c.Assert(RecordAuditLog(&a, before, after), check.Equals, nil)
+
+	as, err := GetAuditLogs(AuditLogFilter{})
+	c.Assert(err, check.Equals, nil)
+	c.Assert(len(as), check.Equals, 1)
+	got := as[0]
+	c.Assert(got.Action, check.Equals, "smtp.update")
+
+	// Credentials are redacted, so they don't appear in the changes either
+	b := map[string]interface{}{}
+	c.Assert(json.Unmarshal(got.Before, &b), check.Equals, nil)
+	c.Assert(b["password"], check.Equals, auditRedacted)
+	diff := map[string]AuditChange{}
+	c.Assert(json.Unmarshal(got.Diff, &diff), check.Equals, nil)
+	c.Assert(diff, check.DeepEquals, map[string]AuditChange{
+		"name": AuditChange{Before: "Old", After: "New"},
+	})
+}
+
+func (s *ModelsSuite) TestRecordAuditLogCreate(c *check.C) {
+	a := AuditLog{UserId: 1, Action: "keys.create", Resource: "keys"}
+	after := []byte(`{"id":2,"name":"Reporting","key":"abcdef"}`)
+	c.Assert(RecordAuditLog(&a, nil, after), check.Equals, nil)
+	c.Assert(string(a.Before), check.Equals, "")
+	c.Assert(string(a.After), check.Equals, `{"id":2,"key":"[redacted]","name":"Reporting"}`)
+	diff := map[string]AuditChange{}
+	c.Assert(json.Unmarshal(a.Diff, &diff), check.Equals, nil)
+	c.Assert(len(diff), check.Equals, 3)
+}
+
+func (s *ModelsSuite) TestGetAuditLogsFilter(c *check.C) {
+	now := time.Now().UTC()
+	for i, a := range []AuditLog{
+		{UserId: 1, Action: "campaigns.launch", Resource: "campaigns", ResourceId: 1, Time: now.Add(-2 * time.Hour)},
+		{UserId: 2, Action: "groups.delete", Resource: "groups", ResourceId: 3, Time: now.Add(-time.Hour)},
+		{UserId: 1, Action: "campaigns.complete", Resource: "campaigns", ResourceId: 1, Time: now},
+	} {
+		a := a
+		c.Assert(RecordAuditLog(&a, nil, nil), check.Equals, nil, check.Commentf("entry %d", i))
+	}
+	for _, tc := range []struct {
+		filter  AuditLogFilter
+		actions []string
+	}{
+		{AuditLogFilter{}, []string{"campaigns.complete", "groups.delete", "campaigns.launch"}},
+		{AuditLogFilter{UserId: 1}, []string{"campaigns.complete", "campaigns.launch"}},
+		{AuditLogFilter{Action: "groups.delete"}, []string{"groups.delete"}},
+		{AuditLogFilter{Resource: "campaigns", ResourceId: 1}, []string{"campaigns.complete", "campaigns.launch"}},
+		{AuditLogFilter{Since: now.Add(-90 * time.Minute)}, []string{"campaigns.complete", "groups.delete"}},
+		{AuditLogFilter{Until: now.Add(-90 * time.Minute)}, []string{"campaigns.launch"}},
+		{AuditLogFilter{Limit: 1, Offset: 1}, []string{"groups.delete"}},
+	} {
+		as, err := GetAuditLogs(tc.filter)
+		c.Assert(err, check.Equals, nil)
+		actions := []string{}
+		for _, a := range as {
+			actions = append(actions, a.Action)
+		}
+		c.Assert(actions, check.DeepEquals, tc.actions)
+	}
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// The reasons a click is suspected of being made by a scanner or bot rather
+// than the recipient.
+const (
+	BOT_REASON_USER_AGENT string = "user_agent"
+	BOT_REASON_NETWORK    string = "network"
+	BOT_REASON_ASN        string = "asn"
+	BOT_REASON_TIMING     string = "timing"
+)
+
+// BotUserAgents are case-insensitive substrings of the User-Agent headers
+// sent by the link scanners of email security gateways. Clicks from these,
+// or from any of the ScannerUserAgents, are suspected bot clicks.
+var BotUserAgents = []string{
+	"barracuda", "mimecast", "proofpoint", "forcepoint", "trendmicro",
+	"symantec", "fireeye", "ironport", "safelinks", "urldefense",
+}
+
+// BotNetworks are the IP networks, in CIDR notation, whose clicks are
+// suspected bot clicks, such as the egress addresses of a security gateway.
+var BotNetworks = []string{}
+
+// HumanNetworks are the IP networks, in CIDR notation, whose clicks are never
+// filtered, even if they'd otherwise look automated. They take precedence over
+// every other signal.
+var HumanNetworks = []string{}
+
+// BotASNs are the autonomous system numbers whose clicks are suspected bot
+// clicks, such as those of cloud providers.
+var BotASNs = []uint{}
+
+// HumanASNs are the autonomous system numbers whose clicks are never filtered,
+// such as the organization's own.
+var HumanASNs = []uint{}
+
+// BotClickDelay is the time after the email was sent within which a click is
+// too fast to have been made by a person reading the email.
+var BotClickDelay = 5 * time.Second
+
+// GeoIPASNDatabasePath is the location of the MaxMind database used to look
+// up the autonomous system of IP addresses, with the GeoLite2 ASN schema. An
+// empty value disables the ASN lists.
+var GeoIPASNDatabasePath = ""
+
+type mmASN struct {
+	Number uint `maxminddb:"autonomous_system_number"`
+}
+
+// asnReader is the shared reader for the ASN database, opened the first time
+// an address is looked up
+var asnReader struct {
+	sync.Mutex
+	reader *maxminddb.Reader
+}
+
+// lookupASN returns the autonomous system number of the IP address, and
+// whether or not it was found. It's a variable so that it can be replaced in
+// tests.
+var lookupASN = func(ip net.IP) (uint, bool) {
+	if GeoIPASNDatabasePath == "" {
+		return 0, false
+	}
+	asnReader.Lock()
+	defer asnReader.Unlock()
+	if asnReader.reader == nil {
+		mmdb, err := maxminddb.Open(GeoIPASNDatabasePath)
+		if err != nil {
+			log.Error(err)
+			return 0, false
+		}
+		asnReader.reader = mmdb
+	}
+	asn := mmASN{}
+	_, ok, err := asnReader.reader.LookupNetwork(ip, &asn)
+	if err != nil {
+		log.Error(err)
+		return 0, false
+	}
+	return asn.Number, ok && asn.Number != 0
+}
+
+func containsASN(asns []uint, asn uint) bool {
+	for _, a := range asns {
+		if a == asn {
+			return true
+		}
+	}
+	return false
+}
+
+// botClickReasons returns the reasons the click described by the given
+// details looks like it was made by a scanner or bot, given how long after
+// the email was sent it happened. Clicks from the HumanNetworks or HumanASNs
+// are never suspected.
+func botClickReasons(d EventDetails, sinceSent time.Duration, sent bool) []string {
+	addr := d.Browser["address"]
+	ip := net.ParseIP(addr)
+	asn, hasASN := uint(0), false
+	if ip != nil {
+		asn, hasASN = lookupASN(ip)
+	}
+	if inNetworks(addr, HumanNetworks) || (hasASN && containsASN(HumanASNs, asn)) {
+		return nil
+	}
+	reasons := []string{}
+	ua := strings.ToLower(d.Browser["user-agent"])
+	if ua == "" || matchesAny(ua, ScannerUserAgents) || matchesAny(ua, BotUserAgents) {
+		reasons = append(reasons, BOT_REASON_USER_AGENT)
+	}
+	if inNetworks(addr, BotNetworks) {
+		reasons = append(reasons, BOT_REASON_NETWORK)
+	}
+	if hasASN && containsASN(BotASNs, asn) {
+		reasons = append(reasons, BOT_REASON_ASN)
+	}
+	if sent && sinceSent < BotClickDelay {
+		reasons = append(reasons, BOT_REASON_TIMING)
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	return reasons
+}
+
+// BotClickReasons returns the reasons the click described by the given details
+// looks like it was made by a scanner or bot rather than the recipient. An
+// empty list means the click looks like it was made by a person.
+func (r *Result) BotClickReasons(details EventDetails) ([]string, error) {
+	sent, wasSent, err := r.sentTime()
+	if err != nil {
+		return nil, err
+	}
+	return botClickReasons(details, time.Since(sent), wasSent), nil
+}
+
+// HandleBotClick records a click suspected of being made by a scanner or bot
+// for the given reasons. The result's status is left unchanged, so that the
+// click doesn't count as the recipient clicking the link.
+func (r *Result) HandleBotClick(details EventDetails, reasons []string) error {
+	details.Bot = true
+	details.BotReasons = reasons
+	_, err := r.createEvent(EVENT_BOT_CLICK, details)
+	return ignoreSuppressed(err)
+}
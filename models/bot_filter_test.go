@@ -0,0 +1,69 @@
+package models
+
+import (
+	"net"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func newBotDetails(addr, ua string) EventDetails {
+	return EventDetails{Browser: map[string]string{"address": addr, "user-agent": ua}}
+}
+
+const testBrowserUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/67.0.3396.99 Safari/537.36"
+
+func (s *ModelsSuite) TestBotClickReasons(ch *check.C) {
+	defer func(bots, humans []string, botASNs, humanASNs []uint, lookup func(net.IP) (uint, bool)) {
+		BotNetworks = bots
+		HumanNetworks = humans
+		BotASNs = botASNs
+		HumanASNs = humanASNs
+		lookupASN = lookup
+	}(BotNetworks, HumanNetworks, BotASNs, HumanASNs, lookupASN)
+	BotNetworks = []string{"203.0.113.0/24"}
+	HumanNetworks = []string{"198.51.100.0/24"}
+	BotASNs = []uint{64500}
+	HumanASNs = []uint{64501}
+	asns := map[string]uint{"192.0.2.1": 64500, "192.0.2.2": 64501}
+	lookupASN = func(ip net.IP) (uint, bool) {
+		asn, ok := asns[ip.String()]
+		return asn, ok
+	}
+
+	ch.Assert(botClickReasons(newBotDetails("192.0.2.10", testBrowserUA), time.Hour, true), check.IsNil)
+	ch.Assert(botClickReasons(newBotDetails("192.0.2.10", testBrowserUA), 0, false), check.IsNil)
+	ch.Assert(botClickReasons(newBotDetails("192.0.2.10", "Mimecast URL Protect"), time.Hour, true),
+		check.DeepEquals, []string{BOT_REASON_USER_AGENT})
+	ch.Assert(botClickReasons(newBotDetails("192.0.2.10", ""), time.Hour, true),
+		check.DeepEquals, []string{BOT_REASON_USER_AGENT})
+	ch.Assert(botClickReasons(newBotDetails("203.0.113.5", testBrowserUA), time.Hour, true),
+		check.DeepEquals, []string{BOT_REASON_NETWORK})
+	ch.Assert(botClickReasons(newBotDetails("192.0.2.1", testBrowserUA), time.Second, true),
+		check.DeepEquals, []string{BOT_REASON_ASN, BOT_REASON_TIMING})
+
+	// The allow lists take precedence over every other signal
+	ch.Assert(botClickReasons(newBotDetails("198.51.100.7", "python-requests/2.19"), 0, true), check.IsNil)
+	ch.Assert(botClickReasons(newBotDetails("192.0.2.2", "python-requests/2.19"), 0, true), check.IsNil)
+}
+
+func (s *ModelsSuite) TestHandleBotClick(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	ch.Assert(result.HandleEmailSent(), check.Equals, nil)
+
+	// Clicking right after the email was sent is too fast to be a person
+	d := newBotDetails("192.0.2.10", testBrowserUA)
+	reasons, err := result.BotClickReasons(d)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(reasons, check.DeepEquals, []string{BOT_REASON_TIMING})
+	ch.Assert(result.HandleBotClick(d, reasons), check.Equals, nil)
+
+	result, err = GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(result.Status, check.Equals, EVENT_SENT)
+	e := Event{}
+	err = db.Where("campaign_id=? and message=?", campaign.Id, EVENT_BOT_CLICK).Find(&e).Error
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(e.Details, check.Matches, `.*"bot":true.*"bot_reasons":\["timing"\].*`)
+}
@@ -0,0 +1,294 @@
+package models
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/gophish/gophish/config"
+)
+
+// BounceDomain is the domain of the VERP return path addresses that campaign
+// emails are sent from, so that bounces can be mapped back to the result
+// they were sent to. The domain's MX should deliver to a mailbox whose
+// messages are passed to ProcessBounce. An empty value sends emails from the
+// sending profile's address, and bounces are only matched by Message-Id.
+var BounceDomain = ""
+
+// BounceLocalPart is the local part of the VERP return path addresses, which
+// is followed by a "+" and the result's RId.
+var BounceLocalPart = "bounces"
+
+// The types of bounce recorded for a result. Hard bounces are permanent
+// failures, such as an unknown mailbox. Soft bounces are temporary failures
+// which the sending MTA gave up retrying, such as a full mailbox.
+const (
+	BOUNCE_HARD string = "hard"
+	BOUNCE_SOFT string = "soft"
+)
+
+// ErrNotBounce is thrown when a message passed to ProcessBounce isn't a
+// delivery status notification reporting a failed delivery
+var ErrNotBounce = errors.New("Message isn't a failed delivery status notification")
+
+// ErrBounceNotMatched is thrown when a bounce can't be mapped back to a result
+var ErrBounceNotMatched = errors.New("Bounce doesn't match any result")
+
+// ErrInvalidBounceAddress is thrown when the configured bounce domain or local
+// part can't form a valid return path address
+var ErrInvalidBounceAddress = errors.New("Invalid bounce domain or local part")
+
+// EventBounce is a struct that wraps the details of a bounced email, taken
+// from the delivery status notification
+type EventBounce struct {
+	Type       string `json:"type"`
+	Status     string `json:"status"`
+	Recipient  string `json:"recipient"`
+	Diagnostic string `json:"diagnostic,omitempty"`
+}
+
+// configureBounce sets the domain and local part of the VERP return path
+// addresses, returning an error if they can't form a valid address.
+func configureBounce(conf config.Bounce) error {
+	local := conf.LocalPart
+	if local == "" {
+		local = "bounces"
+	}
+	if conf.Domain != "" {
+		addr := fmt.Sprintf("%s+rid@%s", local, conf.Domain)
+		a, err := mail.ParseAddress(addr)
+		if err != nil || a.Address != addr || strings.Contains(local, "+") {
+			return ErrInvalidBounceAddress
+		}
+	}
+	BounceDomain = conf.Domain
+	BounceLocalPart = local
+	return nil
+}
+
+// ReturnPath returns the VERP address that the result's email is sent from,
+// or an empty string if BounceDomain isn't set.
+func (r *Result) ReturnPath() string {
+	if BounceDomain == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s+%s@%s", BounceLocalPart, r.RId, BounceDomain)
+}
+
+// parseReturnPath returns the RId from the VERP address, if it is one.
+func parseReturnPath(addr string) (string, bool) {
+	a, err := mail.ParseAddress(addr)
+	if err != nil {
+		return "", false
+	}
+	at := strings.LastIndex(a.Address, "@")
+	if at == -1 || BounceDomain == "" || !strings.EqualFold(a.Address[at+1:], BounceDomain) {
+		return "", false
+	}
+	local := a.Address[:at]
+	if !strings.HasPrefix(local, BounceLocalPart+"+") {
+		return "", false
+	}
+	rid := strings.TrimPrefix(local, BounceLocalPart+"+")
+	return rid, rid != ""
+}
+
+// parseMessageId returns the RId from a Message-Id generated by
+// setMessageId, which has the form <timestamp.rid@hostname>.
+func parseMessageId(id string) (string, bool) {
+	id = normalizeMessageId(id)
+	dot := strings.Index(id, ".")
+	at := strings.LastIndex(id, "@")
+	if dot == -1 || at < dot+2 {
+		return "", false
+	}
+	return id[dot+1 : at], true
+}
+
+// dsn holds the parts of a delivery status notification (RFC 3464) used to
+// find the result the bounced email was sent to
+type dsn struct {
+	// returnPaths are the addresses the notification was delivered to,
+	// which will be the VERP address if one was used
+	returnPaths []string
+	// original is the header of the bounced email, if it was returned
+	original textproto.MIMEHeader
+	bounce   EventBounce
+	failed   bool
+}
+
+// decodePart returns a reader for the part's content, decoding its transfer
+// encoding.
+func decodePart(p *multipart.Part) io.Reader {
+	switch strings.ToLower(p.Header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, p)
+	case "quoted-printable":
+		return quotedprintable.NewReader(p)
+	}
+	return p
+}
+
+// stripAddressType removes the address type, such as "rfc822;", from a
+// Final-Recipient or Original-Recipient field.
+func stripAddressType(v string) string {
+	if i := strings.Index(v, ";"); i != -1 {
+		v = v[i+1:]
+	}
+	return strings.TrimSpace(v)
+}
+
+// parseDeliveryStatus reads the per-recipient fields of a message/delivery-status
+// part, using the first recipient whose delivery failed.
+func (d *dsn) parseDeliveryStatus(r io.Reader) {
+	tp := textproto.NewReader(bufio.NewReader(r))
+	first := true
+	for {
+		h, err := tp.ReadMIMEHeader()
+		// The first group of fields is about the message, rather than a
+		// recipient
+		if !first && len(h) > 0 && !d.failed {
+			action := strings.ToLower(strings.TrimSpace(h.Get("Action")))
+			// The status may be followed by a comment
+			status := ""
+			if f := strings.Fields(h.Get("Status")); len(f) > 0 {
+				status = f[0]
+			}
+			if action == "failed" {
+				d.failed = true
+				d.bounce = EventBounce{
+					Type:       BOUNCE_HARD,
+					Status:     status,
+					Recipient:  stripAddressType(h.Get("Final-Recipient")),
+					Diagnostic: stripAddressType(h.Get("Diagnostic-Code")),
+				}
+				if strings.HasPrefix(status, "4") {
+					d.bounce.Type = BOUNCE_SOFT
+				}
+			}
+		}
+		first = false
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseDSN parses the delivery status notification, returning ErrNotBounce if
+// it doesn't report a failed delivery.
+func parseDSN(raw io.Reader) (*dsn, error) {
+	m, err := mail.ReadMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	d := &dsn{}
+	for _, key := range []string{"To", "Delivered-To", "X-Original-To", "Envelope-To"} {
+		d.returnPaths = append(d.returnPaths, m.Header[textproto.CanonicalMIMEHeaderKey(key)]...)
+	}
+	mt, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mt, "multipart/") {
+		return nil, ErrNotBounce
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		pt, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		switch pt {
+		case "message/delivery-status":
+			d.parseDeliveryStatus(decodePart(p))
+		case "message/rfc822", "text/rfc822-headers":
+			// Only the header is needed, and it may not be followed by a
+			// blank line if the body wasn't returned
+			h, _ := textproto.NewReader(bufio.NewReader(decodePart(p))).ReadMIMEHeader()
+			if len(h) > 0 {
+				d.original = h
+			}
+		}
+	}
+	if !d.failed {
+		return nil, ErrNotBounce
+	}
+	return d, nil
+}
+
+// findResult returns the result the bounced email was sent to, matching the
+// VERP address, or the Message-Id of the returned email.
+func (d *dsn) findResult() (Result, error) {
+	paths := d.returnPaths
+	if d.original != nil {
+		paths = append(paths, d.original["Return-Path"]...)
+	}
+	for _, addr := range paths {
+		rid, ok := parseReturnPath(addr)
+		if !ok {
+			continue
+		}
+		r, err := GetResult(rid)
+		if err == nil {
+			return r, nil
+		}
+	}
+	if d.original == nil {
+		return Result{}, ErrBounceNotMatched
+	}
+	// Other mail may use the same Message-Id format, so the result is only
+	// matched if its whole Message-Id does.
+	id := d.original.Get("Message-Id")
+	rid, ok := parseMessageId(id)
+	if !ok {
+		return Result{}, ErrBounceNotMatched
+	}
+	r, err := GetResult(rid)
+	if err != nil || normalizeMessageId(r.MessageId) != normalizeMessageId(id) {
+		return Result{}, ErrBounceNotMatched
+	}
+	return r, nil
+}
+
+// ProcessBounce records the bounce reported by the given delivery status
+// notification against the result the email was sent to, which must belong
+// to the user with the given id or their team. The result is found using the VERP address
+// the notification was delivered to, falling back to the Message-Id of the
+// returned email.
+func ProcessBounce(raw io.Reader, uid int64) (Result, error) {
+	d, err := parseDSN(raw)
+	if err != nil {
+		return Result{}, err
+	}
+	r, err := d.findResult()
+	if err != nil {
+		return r, err
+	}
+	if !userInTeam(r.UserId, uid) {
+		return Result{}, ErrBounceNotMatched
+	}
+	err = r.HandleEmailBounce(d.bounce)
+	return r, err
+}
+
+// HandleEmailBounce updates a Result to indicate that the email bounced after
+// the SMTP server accepted it. Results which have already been opened or
+// clicked keep their status, since the recipient evidently received the email.
+func (r *Result) HandleEmailBounce(details EventBounce) error {
+	event, err := r.createEvent(EVENT_BOUNCED, details)
+	if err != nil {
+		return err
+	}
+	if statusPrecedence[r.Status] > statusPrecedence[EVENT_SENT] {
+		return nil
+	}
+	r.Status = EVENT_BOUNCED
+	r.ModifiedDate = event.Time
+	return ResultStorage.Save(r)
+}
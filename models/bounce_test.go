@@ -0,0 +1,179 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gophish/gomail"
+	"github.com/gophish/gophish/config"
+	"gopkg.in/check.v1"
+)
+
+// newDSN returns a delivery status notification delivered to the given
+// address, reporting the given action and status for the returned email
+// with the given header.
+func newDSN(to, action, status, original string) string {
+	return fmt.Sprintf("From: Mail Delivery System <MAILER-DAEMON@mx.example.com>\r\n"+
+		"To: %s\r\n"+
+		"Subject: Undelivered Mail Returned to Sender\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: multipart/report; report-type=delivery-status; boundary=\"BOUNDARY\"\r\n"+
+		"\r\n"+
+		"--BOUNDARY\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"\r\n"+
+		"Your message could not be delivered.\r\n"+
+		"--BOUNDARY\r\n"+
+		"Content-Type: message/delivery-status\r\n"+
+		"\r\n"+
+		"Reporting-MTA: dns; mx.example.com\r\n"+
+		"\r\n"+
+		"Final-Recipient: rfc822; test1@example.com\r\n"+
+		"Action: %s\r\n"+
+		"Status: %s\r\n"+
+		"Diagnostic-Code: smtp; 550 5.1.1 User unknown\r\n"+
+		"--BOUNDARY\r\n"+
+		"Content-Type: text/rfc822-headers\r\n"+
+		"\r\n"+
+		"%s"+
+		"--BOUNDARY--\r\n", to, action, status, original)
+}
+
+func (s *ModelsSuite) TestResultReturnPath(ch *check.C) {
+	r := Result{RId: "abc1234"}
+	ch.Assert(r.ReturnPath(), check.Equals, "")
+
+	defer func(domain string) {
+		BounceDomain = domain
+	}(BounceDomain)
+	BounceDomain = "bounces.example.com"
+	ch.Assert(r.ReturnPath(), check.Equals, "bounces+abc1234@bounces.example.com")
+
+	rid, ok := parseReturnPath("<bounces+abc1234@Bounces.Example.com>")
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(rid, check.Equals, "abc1234")
+	_, ok = parseReturnPath("bounces+abc1234@example.com")
+	ch.Assert(ok, check.Equals, false)
+	_, ok = parseReturnPath("someone@bounces.example.com")
+	ch.Assert(ok, check.Equals, false)
+}
+
+func (s *ModelsSuite) TestConfigureBounce(ch *check.C) {
+	defer func(domain, local string) {
+		BounceDomain, BounceLocalPart = domain, local
+	}(BounceDomain, BounceLocalPart)
+	ch.Assert(configureBounce(config.Bounce{Domain: "bounces.example.com", LocalPart: "verp"}), check.Equals, nil)
+	r := Result{RId: "abc1234"}
+	ch.Assert(r.ReturnPath(), check.Equals, "verp+abc1234@bounces.example.com")
+	ch.Assert(configureBounce(config.Bounce{Domain: "bounces.example.com"}), check.Equals, nil)
+	ch.Assert(r.ReturnPath(), check.Equals, "bounces+abc1234@bounces.example.com")
+
+	for _, conf := range []config.Bounce{
+		{Domain: "bounces.example.com", LocalPart: "a+b"},
+		{Domain: "bounces.example.com", LocalPart: "a b"},
+		{Domain: "bounces@example.com"},
+	} {
+		ch.Assert(configureBounce(conf), check.Equals, ErrInvalidBounceAddress)
+	}
+}
+
+func (s *ModelsSuite) TestMailLogGenerateReturnPath(ch *check.C) {
+	defer func(domain string) {
+		BounceDomain = domain
+	}(BounceDomain)
+	BounceDomain = "bounces.example.com"
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	m := &MailLog{}
+	err := db.Where("r_id=? AND campaign_id=?", result.RId, campaign.Id).Find(m).Error
+	ch.Assert(err, check.Equals, nil)
+
+	msg := gomail.NewMessage()
+	ch.Assert(m.Generate(msg), check.Equals, nil)
+	ch.Assert(msg.GetHeader("Return-Path"), check.DeepEquals, []string{result.ReturnPath()})
+}
+
+func (s *ModelsSuite) TestProcessBounceVERP(ch *check.C) {
+	defer func(domain string) {
+		BounceDomain = domain
+	}(BounceDomain)
+	BounceDomain = "bounces.example.com"
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	ch.Assert(result.HandleEmailSent(), check.Equals, nil)
+
+	dsn := newDSN(result.ReturnPath(), "failed", "5.1.1", "Subject: Test\r\n")
+	got, err := ProcessBounce(strings.NewReader(dsn), campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.RId, check.Equals, result.RId)
+
+	result, err = GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(result.Status, check.Equals, EVENT_BOUNCED)
+	e := Event{}
+	err = db.Where("campaign_id=? and email=? and message=?", campaign.Id, result.Email, EVENT_BOUNCED).
+		Find(&e).Error
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(e.Details, check.Equals,
+		`{"type":"hard","status":"5.1.1","recipient":"test1@example.com","diagnostic":"550 5.1.1 User unknown"}`)
+
+	stats, err := getCampaignStats(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(stats.Bounced, check.Equals, int64(1))
+	ch.Assert(stats.EmailsSent, check.Equals, int64(0))
+
+	// Other users can't record bounces against the result
+	_, err = ProcessBounce(strings.NewReader(dsn), campaign.UserId+1)
+	ch.Assert(err, check.Equals, ErrBounceNotMatched)
+
+	// Members of the owner's team can
+	t := Team{Name: "Red Team"}
+	ch.Assert(PostTeam(&t), check.Equals, nil)
+	ch.Assert(db.Model(&User{}).Where("id=?", campaign.UserId).Update("team_id", t.Id).Error, check.Equals, nil)
+	teammate := s.createTeamUser(ch, "operator", ROLE_OPERATOR, t.Id)
+	got, err = ProcessBounce(strings.NewReader(dsn), teammate.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.RId, check.Equals, result.RId)
+}
+
+func (s *ModelsSuite) TestProcessBounceMessageId(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	ch.Assert(result.setMessageId(), check.Equals, nil)
+	ch.Assert(ResultStorage.Save(&result), check.Equals, nil)
+	ch.Assert(result.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+
+	// Without a VERP address, the result is matched by the returned
+	// email's Message-Id
+	original := fmt.Sprintf("Message-Id: %s\r\nSubject: Test\r\n", result.MessageId)
+	dsn := newDSN("foo@example.com", "failed", "4.2.2 (mailbox full)", original)
+	got, err := ProcessBounce(strings.NewReader(dsn), campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.RId, check.Equals, result.RId)
+
+	// The recipient opened the email, so the status isn't changed
+	result, err = GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(result.Status, check.Equals, EVENT_OPENED)
+	e := Event{}
+	err = db.Where("campaign_id=? and message=?", campaign.Id, EVENT_BOUNCED).Find(&e).Error
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(strings.Contains(e.Details, `"type":"soft","status":"4.2.2"`), check.Equals, true)
+
+	// A Message-Id which only looks like one of ours isn't matched
+	original = fmt.Sprintf("Message-Id: <1.%s@other.example.com>\r\n", result.RId)
+	dsn = newDSN("foo@example.com", "failed", "5.1.1", original)
+	_, err = ProcessBounce(strings.NewReader(dsn), campaign.UserId)
+	ch.Assert(err, check.Equals, ErrBounceNotMatched)
+}
+
+func (s *ModelsSuite) TestProcessBounceNotBounce(ch *check.C) {
+	msg := "From: foo@example.com\r\nTo: bar@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"
+	_, err := ProcessBounce(strings.NewReader(msg), 1)
+	ch.Assert(err, check.Equals, ErrNotBounce)
+
+	// Delayed deliveries are still being retried, so they aren't bounces
+	dsn := newDSN("foo@example.com", "delayed", "4.4.7", "Subject: Test\r\n")
+	_, err = ProcessBounce(strings.NewReader(dsn), 1)
+	ch.Assert(err, check.Equals, ErrNotBounce)
+}
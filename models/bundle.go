@@ -0,0 +1,260 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/jinzhu/gorm"
+)
+
+// CampaignBundleVersion is the version of the campaign bundle format written
+// by ExportCampaignBundle
+const CampaignBundleVersion = 1
+
+// CampaignBundle is a portable copy of a campaign's content and settings,
+// without its results or recipients, so that it can be imported into another
+// gophish instance, such as when promoting content from a development server
+// to production. The sending profile is only referenced by name, since its
+// credentials are specific to each instance.
+type CampaignBundle struct {
+	Version            int               `json:"version"`
+	Name               string            `json:"name"`
+	URL                string            `json:"url"`
+	Template           Template          `json:"template"`
+	Page               Page              `json:"page"`
+	Variants           []CampaignVariant `json:"variants,omitempty"`
+	Steps              []CampaignStep    `json:"steps,omitempty"`
+	Headers            []CampaignHeader  `json:"headers,omitempty"`
+	SMTPName           string            `json:"smtp_name"`
+	SendWindowStart    string            `json:"send_window_start"`
+	SendWindowEnd      string            `json:"send_window_end"`
+	SendWindowDays     string            `json:"send_window_days"`
+	SendWindowTimezone string            `json:"send_window_timezone"`
+	DisableBotFilter   bool              `json:"disable_bot_filter"`
+	TrainingURL        string            `json:"training_url"`
+	ShortenURLs        bool              `json:"shorten_urls"`
+	ExportedDate       time.Time         `json:"exported_date"`
+}
+
+// ErrInvalidBundleVersion is thrown when a campaign bundle was written in a
+// format this version of gophish can't import
+var ErrInvalidBundleVersion = errors.New("Unsupported campaign bundle version")
+
+// portableTemplate returns a copy of the template without the fields which
+// are specific to this instance
+func portableTemplate(t Template) Template {
+	t.Id = 0
+	t.ModifiedDate = time.Time{}
+	as := []Attachment{}
+	for _, a := range t.Attachments {
+		as = append(as, Attachment{Content: a.Content, Type: a.Type, Name: a.Name})
+	}
+	t.Attachments = as
+	ls := []TemplateLocalization{}
+	for _, l := range t.Localizations {
+		ls = append(ls, TemplateLocalization{Locale: l.Locale, Subject: l.Subject, Text: l.Text, HTML: l.HTML})
+	}
+	t.Localizations = ls
+	return t
+}
+
+// portablePage returns a copy of the page without the fields which are
+// specific to this instance
+func portablePage(p Page) Page {
+	p.Id = 0
+	p.ModifiedDate = time.Time{}
+	return p
+}
+
+// ExportCampaignBundle returns the bundle of the campaign specified by the
+// given id and user_id.
+func ExportCampaignBundle(id int64, uid int64) (CampaignBundle, error) {
+	c, err := GetCampaign(id, uid)
+	if err != nil {
+		return CampaignBundle{}, err
+	}
+	b := CampaignBundle{
+		Version:            CampaignBundleVersion,
+		Name:               c.Name,
+		URL:                c.URL,
+		Template:           portableTemplate(c.Template),
+		Page:               portablePage(c.Page),
+		SMTPName:           c.SMTP.Name,
+		SendWindowStart:    c.SendWindowStart,
+		SendWindowEnd:      c.SendWindowEnd,
+		SendWindowDays:     c.SendWindowDays,
+		SendWindowTimezone: c.SendWindowTimezone,
+		DisableBotFilter:   c.DisableBotFilter,
+		TrainingURL:        c.TrainingURL,
+		ShortenURLs:        c.ShortenURLs,
+		ExportedDate:       time.Now().UTC(),
+	}
+	for _, v := range c.Variants {
+		b.Variants = append(b.Variants, CampaignVariant{
+			Name:     v.Name,
+			Template: portableTemplate(v.Template),
+			Page:     portablePage(v.Page),
+			Weight:   v.Weight,
+		})
+	}
+	for _, st := range c.Steps {
+		b.Steps = append(b.Steps, CampaignStep{
+			Page: portablePage(st.Page),
+			MFA:  st.MFA,
+		})
+	}
+	for _, h := range c.Headers {
+		b.Headers = append(b.Headers, CampaignHeader{Key: h.Key, Value: h.Value})
+	}
+	return b, nil
+}
+
+// importTemplate creates the template, or replaces the content of the
+// existing template with the same name, so that importing a bundle again
+// keeps the content in sync.
+func importTemplate(t *Template, uid int64) error {
+	t.ModifiedDate = time.Now().UTC()
+	for i := range t.Attachments {
+		t.Attachments[i].Id = 0
+	}
+	existing, err := GetTemplateByName(t.Name, uid)
+	if err == nil {
+		t.Id = existing.Id
+		t.UserId = existing.UserId
+		return PutTemplate(t)
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	t.Id = 0
+	t.UserId = uid
+	return PostTemplate(t)
+}
+
+// importPage creates the landing page, or replaces the content of the
+// existing page with the same name.
+func importPage(p *Page, uid int64) error {
+	p.ModifiedDate = time.Now().UTC()
+	existing, err := GetPageByName(p.Name, uid)
+	if err == nil {
+		p.Id = existing.Id
+		p.UserId = existing.UserId
+		return PutPage(p)
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	p.Id = 0
+	p.UserId = uid
+	return PostPage(p)
+}
+
+// ImportCampaignBundle creates the templates and landing pages in the bundle
+// for the given user, replacing those which already exist with the same
+// names. The bundle is updated with their ids. A campaign isn't created,
+// since its recipients and launch date are chosen when it's created.
+func ImportCampaignBundle(b *CampaignBundle, uid int64) error {
+	if b.Version < 1 || b.Version > CampaignBundleVersion {
+		return ErrInvalidBundleVersion
+	}
+	for _, h := range b.Headers {
+		err := validateHeader(h.Key, h.Value)
+		if err != nil {
+			return err
+		}
+	}
+	err := importTemplate(&b.Template, uid)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = importPage(&b.Page, uid)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	for i := range b.Variants {
+		v := &b.Variants[i]
+		err = importTemplate(&v.Template, uid)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		err = importPage(&v.Page, uid)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	for i := range b.Steps {
+		err = importPage(&b.Steps[i].Page, uid)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyCampaign creates a new campaign using the content and settings of the
+// campaign specified by the given id and user_id. The new campaign's groups
+// are taken from c, since the original campaign's recipients aren't kept,
+// along with its name and launch date if they're given.
+func CopyCampaign(id int64, uid int64, c *Campaign) error {
+	src, err := GetCampaign(id, uid)
+	if err != nil {
+		return err
+	}
+	if c.Name == "" {
+		c.Name = "Copy of " + src.Name
+	}
+	c.URL = src.URL
+	c.Template = Template{Name: src.Template.Name}
+	c.Page = Page{Name: src.Page.Name}
+	c.SMTP = SMTP{Name: src.SMTP.Name}
+	c.SMS = SMS{}
+	c.SendingProfiles = []CampaignProfile{}
+	for _, p := range src.SendingProfiles {
+		c.SendingProfiles = append(c.SendingProfiles, CampaignProfile{
+			SMTP:   SMTP{Name: p.SMTP.Name},
+			Weight: p.Weight,
+		})
+	}
+	c.ProfileRotation = src.ProfileRotation
+	if src.SMSId != 0 {
+		c.SMTP = SMTP{}
+		c.SMS = SMS{Name: src.SMS.Name}
+	}
+	c.Variants = []CampaignVariant{}
+	for _, v := range src.Variants {
+		c.Variants = append(c.Variants, CampaignVariant{
+			Name:     v.Name,
+			Template: Template{Name: v.Template.Name},
+			Page:     Page{Name: v.Page.Name},
+			Weight:   v.Weight,
+		})
+	}
+	c.Steps = []CampaignStep{}
+	for _, st := range src.Steps {
+		c.Steps = append(c.Steps, CampaignStep{
+			Page: Page{Name: st.Page.Name},
+			MFA:  st.MFA,
+		})
+	}
+	c.Headers = []CampaignHeader{}
+	for _, h := range src.Headers {
+		c.Headers = append(c.Headers, CampaignHeader{Key: h.Key, Value: h.Value})
+	}
+	c.SendWindowStart = src.SendWindowStart
+	c.SendWindowEnd = src.SendWindowEnd
+	c.SendWindowDays = src.SendWindowDays
+	c.SendWindowTimezone = src.SendWindowTimezone
+	c.DisableBotFilter = src.DisableBotFilter
+	c.TrainingURL = src.TrainingURL
+	c.ShortenURLs = src.ShortenURLs
+	c.RetentionDays = src.RetentionDays
+	c.RetentionAction = src.RetentionAction
+	c.ScheduleId = 0
+	return PostCampaign(c, uid)
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestCampaignBundle(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	t := campaign.Template
+	t.Attachments = []Attachment{{Name: "invoice.txt", Type: "text/plain", Content: "dGVzdA=="}}
+	ch.Assert(PutTemplate(&t), check.Equals, nil)
+
+	b, err := ExportCampaignBundle(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(b.Version, check.Equals, CampaignBundleVersion)
+	ch.Assert(b.Template.Id, check.Equals, int64(0))
+	ch.Assert(b.Template.HTML, check.Equals, t.HTML)
+	ch.Assert(len(b.Template.Attachments), check.Equals, 1)
+	ch.Assert(b.Page.Id, check.Equals, int64(0))
+	ch.Assert(b.SMTPName, check.Equals, campaign.SMTP.Name)
+
+	// Importing the bundle again restores the content of the existing
+	// template, rather than creating another
+	t.HTML = "<html>Changed</html>"
+	t.Attachments = []Attachment{}
+	ch.Assert(PutTemplate(&t), check.Equals, nil)
+	before, err := GetTemplates(campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ImportCampaignBundle(&b, campaign.UserId), check.Equals, nil)
+	ch.Assert(b.Template.Id, check.Equals, t.Id)
+	ch.Assert(b.Page.Id, check.Equals, campaign.Page.Id)
+	got, err := GetTemplate(t.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.HTML, check.Equals, b.Template.HTML)
+	ch.Assert(len(got.Attachments), check.Equals, 1)
+	ts, err := GetTemplates(campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ts), check.Equals, len(before))
+
+	// Other users get their own copies
+	other := User{Username: "bundle-importer", ApiKey: "bundle-importer-key", Role: ROLE_ADMIN}
+	ch.Assert(PutUser(&other), check.Equals, nil)
+	b.Template.Id = 0
+	ch.Assert(ImportCampaignBundle(&b, other.Id), check.Equals, nil)
+	ch.Assert(b.Template.Id, check.Not(check.Equals), t.Id)
+	ts, err = GetTemplates(other.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ts), check.Equals, 1)
+
+	b.Version = CampaignBundleVersion + 1
+	ch.Assert(ImportCampaignBundle(&b, campaign.UserId), check.Equals, ErrInvalidBundleVersion)
+}
+
+func (s *ModelsSuite) TestCopyCampaign(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	c := Campaign{}
+	ch.Assert(CopyCampaign(campaign.Id, campaign.UserId, &c), check.Equals, ErrGroupNotSpecified)
+
+	c = Campaign{Groups: []Group{{Name: campaign.Groups[0].Name}}}
+	ch.Assert(CopyCampaign(campaign.Id, campaign.UserId, &c), check.Equals, nil)
+	ch.Assert(c.Id, check.Not(check.Equals), campaign.Id)
+	ch.Assert(c.Name, check.Equals, "Copy of "+campaign.Name)
+	ch.Assert(c.TemplateId, check.Equals, campaign.TemplateId)
+	ch.Assert(c.PageId, check.Equals, campaign.PageId)
+	ch.Assert(c.SMTPId, check.Equals, campaign.SMTPId)
+	ch.Assert(len(c.Results), check.Equals, 2)
+}
@@ -2,7 +2,9 @@ package models
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
+	"strings"
 	"time"
 
 	log "github.com/gophish/gophish/logger"
@@ -18,6 +20,7 @@ type Campaign struct {
 	CreatedDate   time.Time `json:"created_date"`
 	LaunchDate    time.Time `json:"launch_date"`
 	CompletedDate time.Time `json:"completed_date"`
+	PausedDate    time.Time `json:"paused_date"`
 	TemplateId    int64     `json:"-"`
 	Template      Template  `json:"template"`
 	PageId        int64     `json:"-"`
@@ -28,17 +31,88 @@ type Campaign struct {
 	Events        []Event   `json:"timeline,omitemtpy"`
 	SMTPId        int64     `json:"-"`
 	SMTP          SMTP      `json:"smtp"`
-	URL           string    `json:"url"`
+	// SendingProfiles are the sending profiles the campaign's emails are
+	// spread across, assigned to the targets according to the
+	// ProfileRotation. When given, they replace the campaign's sending
+	// profile with the first of them.
+	SendingProfiles []CampaignProfile `json:"sending_profiles,omitempty" sql:"-"`
+	ProfileRotation string            `json:"profile_rotation"`
+	// Campaigns with an SMS profile send the text of their template to each
+	// target's phone number instead of sending emails.
+	SMSId int64  `json:"-"`
+	SMS   SMS    `json:"sms"`
+	URL   string `json:"url"`
+	// Variants are the combinations of template and landing page assigned to
+	// the campaign's targets at random. When given, they replace the
+	// campaign's template and landing page with those of the first variant.
+	Variants []CampaignVariant `json:"variants,omitempty" sql:"-"`
+	// Steps are the landing pages shown, in order, after the recipient
+	// submits the campaign's landing page. See CampaignStep for details.
+	Steps []CampaignStep `json:"steps,omitempty" sql:"-"`
+	// Headers are custom headers added to each of the campaign's emails,
+	// after those of the sending profile. See CampaignHeader for details.
+	Headers []CampaignHeader `json:"headers,omitempty" sql:"-"`
+	// The send window limits when the campaign's emails are sent, such as
+	// "09:00" to "17:00" on "mon,tue,wed,thu,fri" in "America/Chicago". Each
+	// target's timezone attribute overrides the window's timezone, which
+	// defaults to UTC.
+	SendWindowStart    string `json:"send_window_start"`
+	SendWindowEnd      string `json:"send_window_end"`
+	SendWindowDays     string `json:"send_window_days"`
+	SendWindowTimezone string `json:"send_window_timezone"`
+	// DuplicatesSkipped is the number of targets which weren't given a
+	// result when the campaign was created, since another target in the
+	// campaign had the same email address.
+	DuplicatesSkipped int `json:"duplicates_skipped" sql:"-"`
+	// DisableBotFilter records clicks from suspected scanners and bots as
+	// clicks, rather than filtering them out as bot clicks.
+	DisableBotFilter bool `json:"disable_bot_filter"`
+	// ScheduleId is the recurring schedule which launched the campaign, if
+	// any.
+	ScheduleId int64 `json:"schedule_id"`
+	// SamplePercent limits the campaign to a random sample of the given
+	// percentage of each group's targets. The whole group is used when it's 0.
+	SamplePercent int `json:"sample_percent,omitempty" sql:"-"`
+	// TrainingURL is the education page or LMS course recipients are
+	// redirected to after submitting data, in place of the landing page's
+	// redirect URL. Paths, such as "/training", are served by the phishing
+	// server, which hosts a built-in training page at /training.
+	TrainingURL string `json:"training_url"`
+	// TrainingKey signs the tokens which identify recipients to the
+	// training page.
+	TrainingKey string `json:"-"`
+	// SignedIds records whether or not the recipient IDs in the campaign's
+	// tracking URLs were signed when it was created. The unsigned IDs sent by
+	// campaigns created before signing was enabled are still accepted.
+	SignedIds bool `json:"-"`
+	// DryRun creates the campaign's results and maillogs without ever
+	// sending them, so that the emails can be previewed for every recipient.
+	DryRun bool `json:"dry_run" sql:"-"`
+	// RetentionDays and RetentionAction override the global retention
+	// policy for the campaign, anonymizing or purging its results the given
+	// number of days after it's completed. RetentionDate is when the policy
+	// was applied. See ApplyRetention for details.
+	RetentionDays   int       `json:"retention_days"`
+	RetentionAction string    `json:"retention_action"`
+	RetentionDate   time.Time `json:"retention_date"`
+	// ArchivedDate is when the campaign was moved to cold storage. See
+	// ArchiveCampaign for details.
+	ArchivedDate time.Time `json:"archived_date"`
+	// ShortenURLs sends each recipient short links, made by the configured
+	// URL shortener, in place of their phishing URLs. See ShortURL for
+	// details.
+	ShortenURLs bool `json:"shorten_urls"`
 }
 
 // CampaignResults is a struct representing the results from a campaign
 type CampaignResults struct {
-	Id       int64    `json:"id"`
-	Name     string   `json:"name"`
-	Status   string   `json:"status"`
-	Reported string   `json:"reported"`
-	Results  []Result `json:"results, omitempty"`
-	Events   []Event  `json:"timeline,omitempty"`
+	Id         int64        `json:"id"`
+	Name       string       `json:"name"`
+	Status     string       `json:"status"`
+	Reported   string       `json:"reported"`
+	Results    []Result     `json:"results,omitempty"`
+	Events     []Event      `json:"timeline,omitempty"`
+	LinkClicks []LinkClicks `json:"link_clicks" sql:"-"`
 }
 
 // CampaignSummaries is a struct representing the overview of campaigns
@@ -49,13 +123,14 @@ type CampaignSummaries struct {
 
 // CampaignSummary is a struct representing the overview of a single camaign
 type CampaignSummary struct {
-	Id            int64         `json:"id"`
-	CreatedDate   time.Time     `json:"created_date"`
-	LaunchDate    time.Time     `json:"launch_date"`
-	CompletedDate time.Time     `json:"completed_date"`
-	Status        string        `json:"status"`
-	Name          string        `json:"name"`
-	Stats         CampaignStats `json:"stats"`
+	Id            int64           `json:"id"`
+	CreatedDate   time.Time       `json:"created_date"`
+	LaunchDate    time.Time       `json:"launch_date"`
+	CompletedDate time.Time       `json:"completed_date"`
+	Status        string          `json:"status"`
+	Name          string          `json:"name"`
+	Stats         CampaignStats   `json:"stats"`
+	Normalized    NormalizedRates `json:"normalized_stats"`
 }
 
 // CampaignStats is a struct representing the statistics for a single campaign
@@ -65,8 +140,46 @@ type CampaignStats struct {
 	OpenedEmail   int64 `json:"opened"`
 	ClickedLink   int64 `json:"clicked"`
 	SubmittedData int64 `json:"submitted_data"`
+	SubmittedMFA  int64 `json:"submitted_mfa"`
 	EmailReported int64 `json:"email_reported"`
+	Bounced       int64 `json:"bounced"`
 	Error         int64 `json:"error"`
+	// TrainingCompleted is the number of recipients who completed the
+	// training they were redirected to.
+	TrainingCompleted int64 `json:"training_completed"`
+}
+
+// NormalizedRates is a struct representing the statistics for a single
+// campaign per 100 targets, so that campaigns of different sizes can be
+// compared
+type NormalizedRates struct {
+	EmailsSent        float64 `json:"sent"`
+	OpenedEmail       float64 `json:"opened"`
+	ClickedLink       float64 `json:"clicked"`
+	SubmittedData     float64 `json:"submitted_data"`
+	EmailReported     float64 `json:"email_reported"`
+	Error             float64 `json:"error"`
+	TrainingCompleted float64 `json:"training_completed"`
+}
+
+// NormalizeRates returns the given campaign statistics per 100 targets.
+// Campaigns without any targets have all rates set to zero.
+func NormalizeRates(s CampaignStats) NormalizedRates {
+	if s.Total == 0 {
+		return NormalizedRates{}
+	}
+	per100 := func(n int64) float64 {
+		return 100 * float64(n) / float64(s.Total)
+	}
+	return NormalizedRates{
+		EmailsSent:        per100(s.EmailsSent),
+		OpenedEmail:       per100(s.OpenedEmail),
+		ClickedLink:       per100(s.ClickedLink),
+		SubmittedData:     per100(s.SubmittedData),
+		EmailReported:     per100(s.EmailReported),
+		Error:             per100(s.Error),
+		TrainingCompleted: per100(s.TrainingCompleted),
+	}
 }
 
 // Event contains the fields for an event
@@ -80,11 +193,40 @@ type Event struct {
 	Details    string    `json:"details"`
 }
 
+// AfterFind normalizes the event's time to UTC, since some database drivers
+// return times in local time.
+func (e *Event) AfterFind() error {
+	e.Time = e.Time.UTC()
+	return nil
+}
+
 // EventDetails is a struct that wraps common attributes we want to store
 // in an event
 type EventDetails struct {
-	Payload url.Values        `json:"payload"`
-	Browser map[string]string `json:"browser"`
+	Payload          url.Values        `json:"payload"`
+	Browser          map[string]string `json:"browser"`
+	Latitude         float64           `json:"latitude,omitempty"`
+	Longitude        float64           `json:"longitude,omitempty"`
+	StatusCode       int               `json:"status_code,omitempty"`
+	Country          string            `json:"country,omitempty"`
+	CountryName      string            `json:"country_name,omitempty"`
+	City             string            `json:"city,omitempty"`
+	ReverseDNS       string            `json:"reverse_dns,omitempty"`
+	AttachmentName   string            `json:"attachment_name,omitempty"`
+	Honeypots        []string          `json:"honeypots,omitempty"`
+	PageHoneypots    []string          `json:"-"`
+	Bot              bool              `json:"bot,omitempty"`
+	Channel          string            `json:"channel,omitempty"`
+	Fingerprint      string            `json:"fingerprint,omitempty"`
+	PasswordBreached bool              `json:"password_breached,omitempty"`
+	LandingURL       string            `json:"landing_url,omitempty"`
+	HumanConfidence  *float64          `json:"human_confidence,omitempty"`
+	VariantId        int64             `json:"variant_id,omitempty"`
+	CapturePolicy    *CapturePolicy    `json:"capture_policy,omitempty"`
+	BotReasons       []string          `json:"bot_reasons,omitempty"`
+	Link             string            `json:"link,omitempty"`
+	QR               bool              `json:"qr,omitempty"`
+	Step             int               `json:"step,omitempty"`
 }
 
 // EventError is a struct that wraps an error that occurs when sending an
@@ -123,6 +265,30 @@ var ErrSMTPNotFound = errors.New("Sending profile not found")
 // RecipientParameter is the URL parameter that points to the result ID for a recipient.
 const RecipientParameter = "rid"
 
+// AttachmentParameter is the URL parameter that names the attachment a
+// recipient opened.
+const AttachmentParameter = "attachment"
+
+// ChannelParameter is the URL parameter that names the channel a recipient
+// used to report an email.
+const ChannelParameter = "channel"
+
+// LinkParameter is the URL parameter that names the link in the email a
+// recipient clicked, for links tagged in the template as {{.URL "name"}}.
+const LinkParameter = "link"
+
+// QRParameter is the URL parameter that marks the URLs in QR codes, so that
+// clicks made by scanning a QR code can be told apart from clicked links.
+const QRParameter = "qr"
+
+// TrainingTokenParameter is the URL parameter that holds the signed token
+// identifying a recipient to the training page they were redirected to.
+const TrainingTokenParameter = "token"
+
+// StepParameter is the URL parameter that gives the position of the landing
+// page shown in a campaign's multi-step flow. See CampaignStep for details.
+const StepParameter = "step"
+
 // Validate checks to make sure there are no invalid fields in a submitted campaign
 func (c *Campaign) Validate() error {
 	switch {
@@ -130,20 +296,54 @@ func (c *Campaign) Validate() error {
 		return ErrCampaignNameNotSpecified
 	case len(c.Groups) == 0:
 		return ErrGroupNotSpecified
-	case c.Template.Name == "":
+	case c.Template.Name == "" && len(c.Variants) == 0:
 		return ErrTemplateNotSpecified
-	case c.Page.Name == "":
+	case c.Page.Name == "" && len(c.Variants) == 0:
 		return ErrPageNotSpecified
-	case c.SMTP.Name == "":
+	case c.SMTP.Name == "" && c.SMS.Name == "" && len(c.SendingProfiles) == 0:
 		return ErrSMTPNotSpecified
+	case c.SamplePercent < 0 || c.SamplePercent > 100:
+		return ErrInvalidSamplePercent
 	}
-	return nil
+	err := validateTrainingURL(c.TrainingURL)
+	if err != nil {
+		return err
+	}
+	err = validateRetention(c.RetentionDays, c.RetentionAction)
+	if err != nil {
+		return err
+	}
+	err = c.validateVariants()
+	if err != nil {
+		return err
+	}
+	err = c.validateSteps()
+	if err != nil {
+		return err
+	}
+	err = c.validateHeaders()
+	if err != nil {
+		return err
+	}
+	err = c.validateProfiles()
+	if err != nil {
+		return err
+	}
+	if c.ShortenURLs && URLShortener == nil {
+		return ErrShortenerNotConfigured
+	}
+	_, err = c.sendWindow()
+	return err
 }
 
 // UpdateStatus changes the campaign status appropriately
 func (c *Campaign) UpdateStatus(s string) error {
 	// This could be made simpler, but I think there's a bug in gorm
-	return db.Table("campaigns").Where("id=?", c.Id).Update("status", s).Error
+	err := db.Table("campaigns").Where("id=?", c.Id).Update("status", s).Error
+	if err == nil && s == CAMPAIGN_IN_PROGRESS {
+		notifyCampaignLaunched(c)
+	}
+	return err
 }
 
 // AddEvent creates a new campaign event in the database
@@ -158,7 +358,7 @@ func (c *Campaign) AddEvent(e *Event) error {
 // an error is returned. Otherwise, the attribute name is set to [Deleted],
 // indicating the user deleted the attribute (template, smtp, etc.)
 func (c *Campaign) getDetails() error {
-	err = db.Model(c).Related(&c.Results).Error
+	c.Results, err = ResultStorage.List(c.Id, c.UserId)
 	if err != nil {
 		log.Warnf("%s: results not found for campaign", err)
 		return err
@@ -181,6 +381,11 @@ func (c *Campaign) getDetails() error {
 		log.Warn(err)
 		return err
 	}
+	err = c.Template.getLocalizations()
+	if err != nil {
+		log.Warn(err)
+		return err
+	}
 	err = db.Table("pages").Where("id=?", c.PageId).Find(&c.Page).Error
 	if err != nil {
 		if err != gorm.ErrRecordNotFound {
@@ -203,6 +408,38 @@ func (c *Campaign) getDetails() error {
 		log.Warn(err)
 		return err
 	}
+	err = c.getVariants()
+	if err != nil {
+		log.Warn(err)
+		return err
+	}
+	err = c.getSteps()
+	if err != nil {
+		log.Warn(err)
+		return err
+	}
+	err = c.getHeaders()
+	if err != nil {
+		log.Warn(err)
+		return err
+	}
+	err = c.getProfiles()
+	if err != nil {
+		log.Warn(err)
+		return err
+	}
+	if c.SMSId == 0 {
+		return nil
+	}
+	err = db.Table("sms").Where("id=?", c.SMSId).Find(&c.SMS).Error
+	if err != nil {
+		// Check if the SMS profile was deleted
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+		c.SMS = SMS{Name: "[Deleted]"}
+		log.Warnf("%s: SMS profile not found for campaign", err)
+	}
 	return nil
 }
 
@@ -210,15 +447,21 @@ func (c *Campaign) getDetails() error {
 // It also backfills numbers as appropriate with a running total, so that the values are aggregated.
 func getCampaignStats(cid int64) (CampaignStats, error) {
 	s := CampaignStats{}
-	query := db.Table("results").Where("campaign_id = ?", cid)
+	query := reportScope(db.Model(&Result{}).Where("campaign_id = ?", cid))
 	err := query.Count(&s.Total).Error
 	if err != nil {
 		return s, err
 	}
+	query.Where("status=?", EVENT_MFA_SUBMIT).Count(&s.SubmittedMFA)
+	if err != nil {
+		return s, err
+	}
 	query.Where("status=?", EVENT_DATA_SUBMIT).Count(&s.SubmittedData)
 	if err != nil {
 		return s, err
 	}
+	// Every submitted second factor implies they submitted data
+	s.SubmittedData += s.SubmittedMFA
 	query.Where("status=?", EVENT_CLICKED).Count(&s.ClickedLink)
 	if err != nil {
 		return s, err
@@ -227,6 +470,10 @@ func getCampaignStats(cid int64) (CampaignStats, error) {
 	if err != nil {
 		return s, err
 	}
+	err = query.Where("training_completed=?", true).Count(&s.TrainingCompleted).Error
+	if err != nil {
+		return s, err
+	}
 	// Every submitted data event implies they clicked the link
 	s.ClickedLink += s.SubmittedData
 	err = query.Where("status=?", EVENT_OPENED).Count(&s.OpenedEmail).Error
@@ -241,14 +488,108 @@ func getCampaignStats(cid int64) (CampaignStats, error) {
 	}
 	// Every opened email event implies the email was sent
 	s.EmailsSent += s.OpenedEmail
+	err = query.Where("status=?", EVENT_BOUNCED).Count(&s.Bounced).Error
+	if err != nil {
+		return s, err
+	}
 	err = query.Where("status=?", ERROR).Count(&s.Error).Error
 	return s, err
 }
 
+// GetResultStatusCounts returns the number of results in the campaign
+// specified by the given id and user_id with each status, computed by the
+// database rather than by loading every result. Unlike the campaign stats, the
+// counts aren't cumulative, so a result which clicked the link is only counted
+// as clicked. The sent, opened, clicked, submitted data, error and retrying
+// statuses are always included, and the number of results which reported the
+// email is keyed by EVENT_REPORTED.
+func GetResultStatusCounts(cid int64, uid int64) (map[string]int, error) {
+	counts := map[string]int{
+		EVENT_SENT:        0,
+		EVENT_OPENED:      0,
+		EVENT_CLICKED:     0,
+		EVENT_DATA_SUBMIT: 0,
+		ERROR:             0,
+		STATUS_RETRY:      0,
+	}
+	err := FlushResults()
+	if err != nil {
+		return counts, err
+	}
+	query := reportScope(db.Model(&Result{}).Where("campaign_id = ? and user_id in (?)", cid, teamUserIds(uid)))
+	rows := []struct {
+		Status string
+		Count  int
+	}{}
+	err = query.Select("status, count(*) as count").Group("status").Scan(&rows).Error
+	if err != nil {
+		return counts, err
+	}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	reported := 0
+	err = query.Where("reported = ?", true).Count(&reported).Error
+	counts[EVENT_REPORTED] = reported
+	return counts, err
+}
+
+// CampaignFilter narrows and orders the campaigns returned from
+// GetFilteredCampaigns. The name is matched as a case-insensitive substring
+// and the date range against when each campaign was created.
+type CampaignFilter struct {
+	ScheduleId int64
+	Status     string
+	Name       string
+	Since      time.Time
+	Until      time.Time
+	Sort       string
+	Limit      int
+	Offset     int
+}
+
+// campaignSortColumns are the fields campaigns can be sorted by
+var campaignSortColumns = map[string]string{
+	"id":             "id",
+	"name":           "name",
+	"status":         "status",
+	"created_date":   "created_date",
+	"launch_date":    "launch_date",
+	"completed_date": "completed_date",
+}
+
 // GetCampaigns returns the campaigns owned by the given user.
 func GetCampaigns(uid int64) ([]Campaign, error) {
+	cs, _, err := GetFilteredCampaigns(uid, CampaignFilter{})
+	return cs, err
+}
+
+// GetFilteredCampaigns returns the campaigns owned by the given user which
+// match the filter, along with the total number of matching campaigns.
+func GetFilteredCampaigns(uid int64, f CampaignFilter) ([]Campaign, int64, error) {
 	cs := []Campaign{}
-	err := db.Model(&User{Id: uid}).Related(&cs).Error
+	query := db.Model(&Campaign{}).Where("user_id in (?)", teamUserIds(uid))
+	if f.ScheduleId != 0 {
+		query = query.Where("schedule_id=?", f.ScheduleId)
+	}
+	if f.Status != "" {
+		query = query.Where("status=?", f.Status)
+	}
+	if f.Name != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(f.Name)) + "%"
+		query = query.Where("lower(name) like ? escape '!'", pattern)
+	}
+	if !f.Since.IsZero() {
+		query = query.Where("created_date >= ?", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		query = query.Where("created_date <= ?", f.Until.UTC())
+	}
+	query, total, err := paginate(query, f.Sort, campaignSortColumns, f.Limit, f.Offset)
+	if err != nil {
+		return cs, total, err
+	}
+	err = query.Find(&cs).Error
 	if err != nil {
 		log.Error(err)
 	}
@@ -258,7 +599,7 @@ func GetCampaigns(uid int64) ([]Campaign, error) {
 			log.Error(err)
 		}
 	}
-	return cs, err
+	return cs, total, err
 }
 
 // GetCampaignSummaries gets the summary objects for all the campaigns
@@ -267,7 +608,7 @@ func GetCampaignSummaries(uid int64) (CampaignSummaries, error) {
 	overview := CampaignSummaries{}
 	cs := []CampaignSummary{}
 	// Get the basic campaign information
-	query := db.Table("campaigns").Where("user_id = ?", uid)
+	query := db.Table("campaigns").Where("user_id in (?)", teamUserIds(uid))
 	query = query.Select("id, name, created_date, launch_date, completed_date, status")
 	err := query.Scan(&cs).Error
 	if err != nil {
@@ -275,12 +616,13 @@ func GetCampaignSummaries(uid int64) (CampaignSummaries, error) {
 		return overview, err
 	}
 	for i := range cs {
-		s, err := getCampaignStats(cs[i].Id)
+		s, err := ResultStorage.Summary(cs[i].Id)
 		if err != nil {
 			log.Error(err)
 			return overview, err
 		}
 		cs[i].Stats = s
+		cs[i].Normalized = NormalizeRates(s)
 	}
 	overview.Total = int64(len(cs))
 	overview.Campaigns = cs
@@ -290,26 +632,27 @@ func GetCampaignSummaries(uid int64) (CampaignSummaries, error) {
 // GetCampaignSummary gets the summary object for a campaign specified by the campaign ID
 func GetCampaignSummary(id int64, uid int64) (CampaignSummary, error) {
 	cs := CampaignSummary{}
-	query := db.Table("campaigns").Where("user_id = ? AND id = ?", uid, id)
+	query := db.Table("campaigns").Where("user_id in (?) AND id = ?", teamUserIds(uid), id)
 	query = query.Select("id, name, created_date, launch_date, completed_date, status")
 	err := query.Scan(&cs).Error
 	if err != nil {
 		log.Error(err)
 		return cs, err
 	}
-	s, err := getCampaignStats(cs.Id)
+	s, err := ResultStorage.Summary(cs.Id)
 	if err != nil {
 		log.Error(err)
 		return cs, err
 	}
 	cs.Stats = s
+	cs.Normalized = NormalizeRates(s)
 	return cs, nil
 }
 
 // GetCampaign returns the campaign, if it exists, specified by the given id and user_id.
 func GetCampaign(id int64, uid int64) (Campaign, error) {
 	c := Campaign{}
-	err := db.Where("id = ?", id).Where("user_id = ?", uid).Find(&c).Error
+	err := db.Where("id = ?", id).Where("user_id in (?)", teamUserIds(uid)).Find(&c).Error
 	if err != nil {
 		log.Errorf("%s: campaign not found", err)
 		return c, err
@@ -321,7 +664,7 @@ func GetCampaign(id int64, uid int64) (Campaign, error) {
 // GetCampaignResults returns just the campaign results for the given campaign
 func GetCampaignResults(id int64, uid int64) (CampaignResults, error) {
 	cr := CampaignResults{}
-	err := db.Table("campaigns").Where("id=? and user_id=?", id, uid).Find(&cr).Error
+	err := db.Table("campaigns").Where("id=? and user_id in (?)", id, teamUserIds(uid)).Find(&cr).Error
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"campaign_id": id,
@@ -329,19 +672,61 @@ func GetCampaignResults(id int64, uid int64) (CampaignResults, error) {
 		}).Error(err)
 		return cr, err
 	}
-	err = db.Table("results").Where("campaign_id=? and user_id=?", cr.Id, uid).Find(&cr.Results).Error
+	cr.Results, err = ResultStorage.List(cr.Id, uid)
 	if err != nil {
 		log.Errorf("%s: results not found for campaign", err)
 		return cr, err
 	}
+	err = loadAnnotations(cr.Id, cr.Results)
+	if err != nil {
+		return cr, err
+	}
 	err = db.Table("events").Where("campaign_id=?", cr.Id).Find(&cr.Events).Error
 	if err != nil {
 		log.Errorf("%s: events not found for campaign", err)
 		return cr, err
 	}
+	cr.LinkClicks, err = GetLinkClicks(cr.Id)
 	return cr, err
 }
 
+// GetCampaignResultsPage returns a page of the campaign results for the given
+// campaign which match the query, along with the total number of matching
+// results. The timeline only includes the events for the returned results and
+// those for the campaign itself.
+func GetCampaignResultsPage(id int64, uid int64, q ResultQuery) (CampaignResults, int64, error) {
+	cr := CampaignResults{}
+	err := db.Table("campaigns").Where("id=? and user_id in (?)", id, teamUserIds(uid)).Find(&cr).Error
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"campaign_id": id,
+			"error":       err,
+		}).Error(err)
+		return cr, 0, err
+	}
+	rs, total, err := GetResultsPage(cr.Id, uid, q)
+	if err != nil {
+		return cr, total, err
+	}
+	err = loadAnnotations(cr.Id, rs)
+	if err != nil {
+		return cr, total, err
+	}
+	cr.Results = rs
+	emails := []string{""}
+	for _, r := range rs {
+		emails = append(emails, r.Email)
+	}
+	err = db.Table("events").Where("campaign_id=? and email in (?)", cr.Id, emails).Find(&cr.Events).Error
+	if err != nil {
+		log.Errorf("%s: events not found for campaign", err)
+		return cr, total, err
+	}
+	// Link clicks are counted across the whole campaign, not just the page
+	cr.LinkClicks, err = GetLinkClicks(cr.Id)
+	return cr, total, err
+}
+
 // GetQueuedCampaigns returns the campaigns that are queued up for this given minute
 func GetQueuedCampaigns(t time.Time) ([]Campaign, error) {
 	cs := []Campaign{}
@@ -369,7 +754,11 @@ func PostCampaign(c *Campaign, uid int64) error {
 	c.UserId = uid
 	c.CreatedDate = time.Now().UTC()
 	c.CompletedDate = time.Time{}
+	c.RetentionDate = time.Time{}
+	c.ArchivedDate = time.Time{}
 	c.Status = CAMPAIGN_QUEUED
+	c.TrainingKey = generateSecureKey()
+	c.SignedIds = RecipientIdSigningKey != ""
 	if c.LaunchDate.IsZero() {
 		c.LaunchDate = c.CreatedDate
 	} else {
@@ -378,6 +767,9 @@ func PostCampaign(c *Campaign, uid int64) error {
 	if c.LaunchDate.Before(c.CreatedDate) || c.LaunchDate.Equal(c.CreatedDate) {
 		c.Status = CAMPAIGN_IN_PROGRESS
 	}
+	if c.DryRun {
+		c.Status = CAMPAIGN_DRY_RUN
+	}
 	// Check to make sure all the groups already exist
 	for i, g := range c.Groups {
 		c.Groups[i], err = GetGroupByName(g.Name, uid)
@@ -391,6 +783,20 @@ func PostCampaign(c *Campaign, uid int64) error {
 			return err
 		}
 	}
+	c.sampleTargets()
+	// Check to make sure the variants' templates and pages exist
+	if len(c.Variants) > 0 {
+		err = c.lookupVariants(uid)
+		if err != nil {
+			return err
+		}
+		c.Template = Template{Name: c.Variants[0].Template.Name}
+		c.Page = Page{Name: c.Variants[0].Page.Name}
+	}
+	err = c.lookupSteps(uid)
+	if err != nil {
+		return err
+	}
 	// Check to make sure the template exists
 	t, err := GetTemplateByName(c.Template.Name, uid)
 	if err == gorm.ErrRecordNotFound {
@@ -417,19 +823,71 @@ func PostCampaign(c *Campaign, uid int64) error {
 	}
 	c.Page = p
 	c.PageId = p.Id
-	// Check to make sure the sending profile exists
-	s, err := GetSMTPByName(c.SMTP.Name, uid)
-	if err == gorm.ErrRecordNotFound {
-		log.WithFields(logrus.Fields{
-			"smtp": s.Name,
-		}).Error("Sending profile does not exist")
-		return ErrSMTPNotFound
-	} else if err != nil {
-		log.Error(err)
-		return err
+	if c.SMS.Name != "" {
+		// Check to make sure the SMS profile exists
+		s, err := GetSMSProfileByName(c.SMS.Name, uid)
+		if err == gorm.ErrRecordNotFound {
+			log.WithFields(logrus.Fields{
+				"sms": c.SMS.Name,
+			}).Error("SMS profile does not exist")
+			return ErrSMSNotFound
+		} else if err != nil {
+			log.Error(err)
+			return err
+		}
+		c.SMS = s
+		c.SMSId = s.Id
+		c.SMTP = SMTP{}
+		c.SendingProfiles = []CampaignProfile{}
+	} else {
+		// Check to make sure the sending profiles being rotated exist
+		if len(c.SendingProfiles) > 0 {
+			err = c.lookupProfiles(uid)
+			if err != nil {
+				return err
+			}
+			c.SMTP = SMTP{Name: c.SendingProfiles[0].SMTP.Name}
+		}
+		// Check to make sure the sending profile exists
+		s, err := GetSMTPByName(c.SMTP.Name, uid)
+		if err == gorm.ErrRecordNotFound {
+			log.WithFields(logrus.Fields{
+				"smtp": s.Name,
+			}).Error("Sending profile does not exist")
+			return ErrSMTPNotFound
+		} else if err != nil {
+			log.Error(err)
+			return err
+		}
+		c.SMTP = s
+		c.SMTPId = s.Id
+	}
+	// Check to make sure every recipient can be sent to before creating any
+	// of the results
+	for _, g := range c.Groups {
+		for i, t := range g.Targets {
+			r := Result{Email: t.Email, FirstName: t.FirstName, LastName: t.LastName}
+			if err := r.Validate(); err != nil {
+				log.WithFields(logrus.Fields{
+					"group": g.Name,
+					"row":   i + 1,
+					"email": t.Email,
+				}).Error(err)
+				return fmt.Errorf("%s: group %q, row %d (%q)", err, g.Name, i+1, t.Email)
+			}
+			if c.SMSId == 0 {
+				continue
+			}
+			if err := validatePhone(t.Phone); err != nil {
+				log.WithFields(logrus.Fields{
+					"group": g.Name,
+					"row":   i + 1,
+					"phone": t.Phone,
+				}).Error(err)
+				return fmt.Errorf("%s: group %q, row %d (%q)", err, g.Name, i+1, t.Phone)
+			}
+		}
 	}
-	c.SMTP = s
-	c.SMTPId = s.Id
 	// Insert into the DB
 	err = db.Save(c).Error
 	if err != nil {
@@ -440,62 +898,142 @@ func PostCampaign(c *Campaign, uid int64) error {
 	if err != nil {
 		log.Error(err)
 	}
-	// Insert all the results
+	for i := range c.Variants {
+		c.Variants[i].CampaignId = c.Id
+		err = db.Save(&c.Variants[i]).Error
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	for i := range c.Steps {
+		c.Steps[i].CampaignId = c.Id
+		err = db.Save(&c.Steps[i]).Error
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	for i := range c.Headers {
+		c.Headers[i].Id = 0
+		c.Headers[i].CampaignId = c.Id
+		err = db.Save(&c.Headers[i]).Error
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	for i := range c.SendingProfiles {
+		c.SendingProfiles[i].CampaignId = c.Id
+		err = db.Save(&c.SendingProfiles[i]).Error
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	// Remove duplicate results - we should only send emails to unique email
+	// addresses. The first target with an address wins, regardless of case.
 	resultMap := make(map[string]bool)
+	targets := []Target{}
 	for _, g := range c.Groups {
-		// Insert a result for each target in the group
 		for _, t := range g.Targets {
-			// Remove duplicate results - we should only
-			// send emails to unique email addresses.
-			if _, ok := resultMap[t.Email]; ok {
+			email := normalizeEmail(t.Email)
+			if _, ok := resultMap[email]; ok {
+				c.DuplicatesSkipped++
 				continue
 			}
-			resultMap[t.Email] = true
-			r := &Result{
-				Email:        t.Email,
-				Position:     t.Position,
-				Status:       STATUS_SCHEDULED,
-				CampaignId:   c.Id,
-				UserId:       c.UserId,
-				FirstName:    t.FirstName,
-				LastName:     t.LastName,
-				SendDate:     c.LaunchDate,
-				Reported:     false,
-				ModifiedDate: c.CreatedDate,
-			}
-			if c.Status == CAMPAIGN_IN_PROGRESS {
-				r.Status = STATUS_SENDING
-			}
-			err = r.GenerateId()
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			err = db.Save(r).Error
-			if err != nil {
-				log.WithFields(logrus.Fields{
-					"email": t.Email,
-				}).Error(err)
-			}
-			c.Results = append(c.Results, *r)
-			err = GenerateMailLog(c, r)
-			if err != nil {
-				log.Error(err)
-				continue
+			resultMap[email] = true
+			targets = append(targets, t)
+		}
+	}
+	// The IDs are generated together, so that they're checked for collisions
+	// in bulk rather than once for each target
+	ids, err := GenerateIds(len(targets))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	sendDate := c.LaunchDate
+	window, err := c.sendWindow()
+	if err != nil {
+		return err
+	}
+	// Sends within a window are spaced out separately for each timezone
+	windowDates := make(map[string]time.Time)
+	results := make([]*Result, 0, len(targets))
+	for i, t := range targets {
+		r := &Result{
+			Email:        t.Email,
+			Position:     t.Position,
+			Phone:        normalizePhone(t.Phone),
+			VariantId:    c.pickVariant(),
+			SMTPId:       c.pickProfile(i),
+			Status:       STATUS_SCHEDULED,
+			CampaignId:   c.Id,
+			UserId:       c.UserId,
+			RId:          ids[i],
+			FirstName:    t.FirstName,
+			LastName:     t.LastName,
+			SendDate:     sendDate,
+			Reported:     false,
+			ModifiedDate: c.CreatedDate,
+		}
+		if c.Status == CAMPAIGN_IN_PROGRESS {
+			r.Status = STATUS_SENDING
+		}
+		// Send each target the localization of their template which
+		// matches their locale
+		r.Locale = c.VariantFor(r).Template.MatchLocale(t.Locale)
+		r.classifyProvider()
+		err = r.setAttributes(t.Attributes)
+		if err != nil {
+			log.Error(err)
+		}
+		// Space out the following sends, if configured
+		if window != nil {
+			loc := window.location(r)
+			next, ok := windowDates[loc.String()]
+			if !ok {
+				next = c.LaunchDate
 			}
+			r.SendDate = window.next(next, loc)
+			windowDates[loc.String()] = r.SendDate.Add(r.NextSendJitter(SendInterval))
+		} else {
+			sendDate = sendDate.Add(r.NextSendJitter(SendInterval))
 		}
+		results = append(results, r)
+	}
+	// Insert all the results
+	err = insertResults(c, results)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	for _, r := range results {
+		c.Results = append(c.Results, *r)
+	}
+	// The results have already been inserted, so they aren't saved again
+	// along with the campaign
+	err = db.Omit("Results").Save(c).Error
+	if err == nil && c.Status == CAMPAIGN_IN_PROGRESS {
+		notifyCampaignLaunched(c)
 	}
-	err = db.Save(c).Error
 	return err
 }
 
-//DeleteCampaign deletes the specified campaign
+// DeleteCampaign deletes the specified campaign
 func DeleteCampaign(id int64) error {
 	log.WithFields(logrus.Fields{
 		"campaign_id": id,
 	}).Info("Deleting campaign")
+	// Write any held saves first, so that they can't recreate the results
+	err := FlushResults()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
 	// Delete all the campaign results
-	err := db.Where("campaign_id=?", id).Delete(&Result{}).Error
+	err = db.Unscoped().Where("campaign_id=?", id).Delete(&Result{}).Error
 	if err != nil {
 		log.Error(err)
 		return err
@@ -505,6 +1043,61 @@ func DeleteCampaign(id int64) error {
 		log.Error(err)
 		return err
 	}
+	err = db.Where("campaign_id=?", id).Delete(&SendAttempt{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Where("campaign_id=?", id).Delete(&Snapshot{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Where("campaign_id=?", id).Delete(&ResultTag{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Where("campaign_id=?", id).Delete(&ResultNote{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Where("campaign_id=?", id).Delete(&CampaignVariant{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Where("campaign_id=?", id).Delete(&CampaignStep{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Where("campaign_id=?", id).Delete(&CampaignHeader{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Where("campaign_id=?", id).Delete(&ShortURL{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Where("campaign_id=?", id).Delete(&NotificationDelivery{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Where("campaign_id=?", id).Delete(&Notification{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = removeArchive(id)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
 	// Delete the campaign
 	err = db.Delete(&Campaign{Id: id}).Error
 	if err != nil {
@@ -524,15 +1117,17 @@ func CompleteCampaign(id int64, uid int64) error {
 		return err
 	}
 	// Don't overwrite original completed time
-	if c.Status == CAMPAIGN_COMPLETE {
+	if c.IsComplete() {
 		return nil
 	}
 	// Mark the campaign as complete
 	c.CompletedDate = time.Now().UTC()
 	c.Status = CAMPAIGN_COMPLETE
-	err = db.Where("id=? and user_id=?", id, uid).Save(&c).Error
+	err = db.Where("id=? and user_id in (?)", id, teamUserIds(uid)).Save(&c).Error
 	if err != nil {
 		log.Error(err)
+		return err
 	}
-	return err
+	notifyCampaignCompleted(&c)
+	return nil
 }
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrCampaignNotPausable is thrown when pausing a campaign which isn't queued
+// or in progress
+var ErrCampaignNotPausable = errors.New("Only queued or in progress campaigns can be paused")
+
+// ErrCampaignNotPaused is thrown when resuming a campaign which isn't paused
+var ErrCampaignNotPaused = errors.New("Campaign is not paused")
+
+// PauseCampaign stops the campaign specified by the given id and user_id from
+// sending any more emails until it's resumed. Emails which have already been
+// handed to the mailer may still be sent.
+func PauseCampaign(id int64, uid int64) error {
+	c := Campaign{}
+	err := db.Where("id=? and user_id in (?)", id, teamUserIds(uid)).Find(&c).Error
+	if err != nil {
+		return err
+	}
+	if c.Status != CAMPAIGN_QUEUED && c.Status != CAMPAIGN_IN_PROGRESS {
+		return ErrCampaignNotPausable
+	}
+	log.WithFields(logrus.Fields{
+		"campaign_id": id,
+	}).Info("Pausing campaign")
+	err = db.Model(&c).Updates(map[string]interface{}{
+		"status":      CAMPAIGN_PAUSED,
+		"paused_date": time.Now().UTC(),
+	}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return c.AddEvent(&Event{Message: "Campaign Paused"})
+}
+
+// ResumeCampaign continues sending the emails of the paused campaign specified
+// by the given id and user_id. The remaining emails are pushed back by the
+// time the campaign was paused for, keeping their original spacing, and are
+// kept within the campaign's send window.
+func ResumeCampaign(id int64, uid int64) error {
+	c := Campaign{}
+	err := db.Where("id=? and user_id in (?)", id, teamUserIds(uid)).Find(&c).Error
+	if err != nil {
+		return err
+	}
+	if c.Status != CAMPAIGN_PAUSED {
+		return ErrCampaignNotPaused
+	}
+	log.WithFields(logrus.Fields{
+		"campaign_id": id,
+	}).Info("Resuming campaign")
+	now := time.Now().UTC()
+	offset := now.Sub(c.PausedDate)
+	ms, err := GetMailLogsByCampaign(c.Id)
+	if err != nil {
+		return err
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].SendDate.Before(ms[j].SendDate) })
+	for _, m := range ms {
+		r, err := ResultStorage.Get(m.RId)
+		if err != nil {
+			return err
+		}
+		m.SendDate, err = c.scheduleSend(&r, m.SendDate.Add(offset))
+		if err != nil {
+			return err
+		}
+		err = db.Save(m).Error
+		if err != nil {
+			return err
+		}
+		r.SendDate = m.SendDate
+		err = ResultStorage.Save(&r)
+		if err != nil {
+			return err
+		}
+	}
+	status := CAMPAIGN_IN_PROGRESS
+	if c.LaunchDate.After(now) {
+		status = CAMPAIGN_QUEUED
+	}
+	err = db.Model(&c).Updates(map[string]interface{}{
+		"status":      status,
+		"paused_date": time.Time{},
+	}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return c.AddEvent(&Event{Message: "Campaign Resumed"})
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestPauseResumeCampaign(ch *check.C) {
+	c := s.createCampaign(ch)
+	before, err := GetMailLogsByCampaign(c.Id)
+	ch.Assert(err, check.Equals, nil)
+
+	ch.Assert(PauseCampaign(c.Id, c.UserId), check.Equals, nil)
+	got, err := GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, CAMPAIGN_PAUSED)
+	ch.Assert(got.PausedDate.IsZero(), check.Equals, false)
+	ch.Assert(PauseCampaign(c.Id, c.UserId), check.Equals, ErrCampaignNotPausable)
+
+	// Paused campaigns don't have their maillogs picked up
+	ms, err := GetQueuedMailLogs(time.Now().UTC().Add(time.Hour))
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ms), check.Equals, 0)
+
+	// Resuming pushes the remaining sends back by the time spent paused
+	pause := 2 * time.Hour
+	err = db.Model(&Campaign{}).Where("id=?", c.Id).
+		UpdateColumn("paused_date", got.PausedDate.Add(-pause)).Error
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ResumeCampaign(c.Id, c.UserId), check.Equals, nil)
+	got, err = GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, CAMPAIGN_IN_PROGRESS)
+	ch.Assert(got.PausedDate.IsZero(), check.Equals, true)
+	ch.Assert(ResumeCampaign(c.Id, c.UserId), check.Equals, ErrCampaignNotPaused)
+
+	after, err := GetMailLogsByCampaign(c.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(after), check.Equals, len(before))
+	for i, m := range after {
+		shift := m.SendDate.Sub(before[i].SendDate)
+		ch.Assert(shift >= pause, check.Equals, true, check.Commentf("shifted by %s", shift))
+		ch.Assert(shift < pause+time.Minute, check.Equals, true, check.Commentf("shifted by %s", shift))
+		r, err := GetResult(m.RId)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(r.SendDate.Equal(m.SendDate), check.Equals, true)
+	}
+	ms, err = GetQueuedMailLogs(time.Now().UTC().Add(3 * time.Hour))
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ms), check.Equals, len(after))
+
+	// Completed campaigns can't be paused
+	ch.Assert(CompleteCampaign(c.Id, c.UserId), check.Equals, nil)
+	ch.Assert(PauseCampaign(c.Id, c.UserId), check.Equals, ErrCampaignNotPausable)
+}
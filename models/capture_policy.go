@@ -0,0 +1,141 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// The ways a landing page can store the values of submitted fields. Pages
+// without a capture mode store the values as submitted.
+const (
+	CAPTURE_VALUES string = "values"
+	CAPTURE_NAMES  string = "names"
+	CAPTURE_HASH   string = "hash"
+)
+
+// ErrInvalidCaptureMode is thrown when a page's capture mode isn't supported
+var ErrInvalidCaptureMode = errors.New("Invalid capture mode")
+
+// ErrInvalidCaptureDropFields is thrown when a page's pattern of fields to
+// drop isn't a valid regular expression
+var ErrInvalidCaptureDropFields = errors.New("Invalid pattern of fields to drop")
+
+// ErrInvalidCapturePasswordLength is thrown when a page's password length is
+// negative
+var ErrInvalidCapturePasswordLength = errors.New("Password length can't be negative")
+
+// CapturePolicy controls what is stored of the data submitted to a landing
+// page. It's enforced before the submission is recorded, and is stored in the
+// event details so that auditors can verify what was kept.
+//
+// Fields whose names match DropFields are removed entirely. The values of
+// password fields are truncated to PasswordLength characters, if it's set.
+// Then the remaining values are kept, replaced by their SHA-256 hash, or
+// removed so that only the field names are kept, depending on the Mode. The
+// recipient parameter is always kept as-is.
+type CapturePolicy struct {
+	Mode           string `json:"mode"`
+	PasswordLength int    `json:"password_length,omitempty"`
+	DropFields     string `json:"drop_fields,omitempty"`
+	// passwordFields are the names of the page's password inputs
+	passwordFields map[string]bool
+}
+
+// CapturePolicy returns the page's policy for storing submitted data.
+func (p *Page) CapturePolicy() *CapturePolicy {
+	cp := &CapturePolicy{
+		Mode:           p.CaptureMode,
+		PasswordLength: p.CapturePasswordLength,
+		DropFields:     p.CaptureDropFields,
+		passwordFields: make(map[string]bool),
+	}
+	if cp.Mode == "" {
+		cp.Mode = CAPTURE_VALUES
+	}
+	d, err := goquery.NewDocumentFromReader(strings.NewReader(p.HTML))
+	if err != nil {
+		return cp
+	}
+	d.Find("input").Each(func(i int, input *goquery.Selection) {
+		t, _ := input.Attr("type")
+		name, ok := input.Attr("name")
+		if ok && strings.EqualFold(t, "password") {
+			cp.passwordFields[name] = true
+		}
+	})
+	return cp
+}
+
+// validateCapturePolicy ensures that the page's capture policy can be
+// enforced
+func (p *Page) validateCapturePolicy() error {
+	switch p.CaptureMode {
+	case "", CAPTURE_VALUES, CAPTURE_NAMES, CAPTURE_HASH:
+	default:
+		return ErrInvalidCaptureMode
+	}
+	if p.CapturePasswordLength < 0 {
+		return ErrInvalidCapturePasswordLength
+	}
+	if p.CaptureDropFields != "" {
+		if _, err := regexp.Compile(p.CaptureDropFields); err != nil {
+			return ErrInvalidCaptureDropFields
+		}
+	}
+	return nil
+}
+
+// truncateValue cuts the value down to at most n characters
+func truncateValue(v string, n int) string {
+	if utf8.RuneCountInString(v) <= n {
+		return v
+	}
+	return string([]rune(v)[:n])
+}
+
+// hashValue returns the hex encoded SHA-256 hash of the value
+func hashValue(v string) string {
+	h := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(h[:])
+}
+
+// Apply returns a copy of the submitted payload with the policy enforced.
+func (cp *CapturePolicy) Apply(payload url.Values) url.Values {
+	var drop *regexp.Regexp
+	if cp.DropFields != "" {
+		// The pattern is checked when the page is saved
+		drop, _ = regexp.Compile(cp.DropFields)
+	}
+	redacted := url.Values{}
+	for name, values := range payload {
+		if name == RecipientParameter {
+			redacted[name] = values
+			continue
+		}
+		if drop != nil && drop.MatchString(name) {
+			continue
+		}
+		vs := []string{}
+		for _, v := range values {
+			if cp.PasswordLength > 0 && cp.passwordFields[name] {
+				v = truncateValue(v, cp.PasswordLength)
+			}
+			switch cp.Mode {
+			case CAPTURE_NAMES:
+				continue
+			case CAPTURE_HASH:
+				v = hashValue(v)
+			}
+			vs = append(vs, v)
+		}
+		redacted[name] = vs
+	}
+	return redacted
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"net/url"
+
+	"gopkg.in/check.v1"
+)
+
+const capturePolicyHTML = `<html><body><form>
+	<input name="username"/>
+	<input name="password" type="password"/>
+	<input name="token" type="hidden"/>
+</form></body></html>`
+
+func newCapturePayload() url.Values {
+	return url.Values{
+		RecipientParameter: []string{"abc1234"},
+		"username":         []string{"jdoe"},
+		"password":         []string{"hunter22"},
+		"token":            []string{"secret-token"},
+	}
+}
+
+func (s *ModelsSuite) TestCapturePolicyValidate(c *check.C) {
+	p := Page{Name: "Test Page", HTML: capturePolicyHTML, CaptureCredentials: true}
+	p.CaptureMode = "everything"
+	c.Assert(p.Validate(), check.Equals, ErrInvalidCaptureMode)
+	p.CaptureMode = CAPTURE_HASH
+	p.CapturePasswordLength = -1
+	c.Assert(p.Validate(), check.Equals, ErrInvalidCapturePasswordLength)
+	p.CapturePasswordLength = 2
+	p.CaptureDropFields = "("
+	c.Assert(p.Validate(), check.Equals, ErrInvalidCaptureDropFields)
+	p.CaptureDropFields = "^tok"
+	c.Assert(p.Validate(), check.Equals, nil)
+
+	// Invalid policies can't be saved when editing a page either
+	c.Assert(PostPage(&p), check.Equals, nil)
+	p.CaptureMode = "everything"
+	c.Assert(PutPage(&p), check.Equals, ErrInvalidCaptureMode)
+}
+
+func (s *ModelsSuite) TestCapturePolicyApply(c *check.C) {
+	p := Page{Name: "Test Page", HTML: capturePolicyHTML, CaptureCredentials: true, CapturePasswords: true}
+	c.Assert(p.Validate(), check.Equals, nil)
+
+	// Pages without a policy keep the values as submitted
+	cp := p.CapturePolicy()
+	c.Assert(cp.Mode, check.Equals, CAPTURE_VALUES)
+	c.Assert(cp.Apply(newCapturePayload()), check.DeepEquals, newCapturePayload())
+
+	p.CaptureMode = CAPTURE_NAMES
+	p.CaptureDropFields = "^tok"
+	c.Assert(p.CapturePolicy().Apply(newCapturePayload()), check.DeepEquals, url.Values{
+		RecipientParameter: []string{"abc1234"},
+		"username":         []string{},
+		"password":         []string{},
+	})
+
+	p.CaptureMode = CAPTURE_VALUES
+	p.CaptureDropFields = ""
+	p.CapturePasswordLength = 3
+	c.Assert(p.CapturePolicy().Apply(newCapturePayload()), check.DeepEquals, url.Values{
+		RecipientParameter: []string{"abc1234"},
+		"username":         []string{"jdoe"},
+		"password":         []string{"hun"},
+		"token":            []string{"secret-token"},
+	})
+
+	p.CaptureMode = CAPTURE_HASH
+	p.CapturePasswordLength = 0
+	got := p.CapturePolicy().Apply(newCapturePayload())
+	c.Assert(got[RecipientParameter], check.DeepEquals, []string{"abc1234"})
+	c.Assert(got["password"], check.DeepEquals, []string{hashValue("hunter22")})
+	c.Assert(len(got["password"][0]), check.Equals, 64)
+	c.Assert(got["username"], check.DeepEquals, []string{hashValue("jdoe")})
+}
+
+func (s *ModelsSuite) TestHandleFormSubmitCapturePolicy(c *check.C) {
+	campaign := s.createCampaign(c)
+	result := campaign.Results[0]
+	p := Page{HTML: capturePolicyHTML, CaptureMode: CAPTURE_NAMES}
+	details := EventDetails{Payload: newCapturePayload(), CapturePolicy: p.CapturePolicy()}
+	c.Assert(result.HandleFormSubmit(details), check.Equals, nil)
+
+	e := Event{}
+	err := db.Where("campaign_id=? and message=?", campaign.Id, EVENT_DATA_SUBMIT).Find(&e).Error
+	c.Assert(err, check.Equals, nil)
+	c.Assert(e.Details, check.Matches, `.*"payload":\{"password":\[\],"rid":\["abc1234"\],"token":\[\],"username":\[\]\}.*`)
+	c.Assert(e.Details, check.Matches, `.*"capture_policy":\{"mode":"names"\}.*`)
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"bitbucket.org/liamstask/goose/lib/goose"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestChooseDBDriver(ch *check.C) {
+	d := chooseDBDriver("postgres", "postgres://localhost/gophish")
+	ch.Assert(d.Import, check.Equals, "github.com/lib/pq")
+	_, ok := d.Dialect.(*goose.PostgresDialect)
+	ch.Assert(ok, check.Equals, true)
+	d = chooseDBDriver("sqlite3", "gophish.db")
+	_, ok = d.Dialect.(*goose.Sqlite3Dialect)
+	ch.Assert(ok, check.Equals, true)
+}
+
+// Each database's migrations should bring it to the same schema version, so
+// that migrations aren't left out for one of them.
+func (s *ModelsSuite) TestMigrationVersions(ch *check.C) {
+	latest, err := goose.GetMostRecentDBVersion("../db/db_sqlite3/migrations")
+	ch.Assert(err, check.Equals, nil)
+	for _, dir := range []string{"../db/db_mysql/migrations", "../db/db_postgres/migrations"} {
+		v, err := goose.GetMostRecentDBVersion(dir)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(v, check.Equals, latest, check.Commentf("%s", dir))
+	}
+}
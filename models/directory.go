@@ -0,0 +1,739 @@
+package models
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gophish/gophish/cron"
+	"github.com/gophish/gophish/directory"
+	"github.com/gophish/gophish/ldap"
+	log "github.com/gophish/gophish/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// The directories which groups can be synced from. LDAP directories, such as
+// Active Directory, are searched beneath a base DN. Azure AD and Google
+// Workspace are read through their APIs, for organizations without an LDAP
+// server.
+const (
+	DIRECTORY_LDAP   = "ldap"
+	DIRECTORY_AZURE  = "azure"
+	DIRECTORY_GOOGLE = "google"
+)
+
+// Directory is a directory which a group's targets are synchronized from.
+// Each sync lists the directory's users, maps them onto targets, and replaces
+// the group's targets with them. If the directory has a recurrence, the
+// worker syncs it on that schedule.
+//
+// LDAP directories are searched beneath the base DN for entries matching the
+// filter, with the attribute mapping used to fill in the targets. Azure AD
+// directories use the Tenant, ClientId and ClientSecret of an application
+// registered in the tenant, and Google Workspace directories use a service
+// account's JSON key as the ClientSecret, acting as the AdminEmail. Either
+// can be limited to the members of a SourceGroup, which is a group's object
+// id in Azure AD and its email address in Google Workspace, and Google
+// Workspace directories to an OrgUnit. Azure AD directories which aren't
+// limited to a group are synced incrementally, only applying the changes
+// since the previous sync.
+type Directory struct {
+	Id                 int64     `json:"id"`
+	UserId             int64     `json:"-"`
+	GroupId            int64     `json:"group_id"`
+	Name               string    `json:"name"`
+	Provider           string    `json:"provider"`
+	URL                string    `json:"url"`
+	StartTLS           bool      `json:"start_tls"`
+	IgnoreCertErrors   bool      `json:"ignore_cert_errors"`
+	BindDN             string    `json:"bind_dn"`
+	BindPassword       string    `json:"bind_password,omitempty"`
+	BaseDN             string    `json:"base_dn"`
+	Filter             string    `json:"filter"`
+	FirstNameAttribute string    `json:"first_name_attribute"`
+	LastNameAttribute  string    `json:"last_name_attribute"`
+	EmailAttribute     string    `json:"email_attribute"`
+	PositionAttribute  string    `json:"position_attribute"`
+	PhoneAttribute     string    `json:"phone_attribute"`
+	Tenant             string    `json:"tenant"`
+	ClientId           string    `json:"client_id"`
+	ClientSecret       string    `json:"client_secret,omitempty"`
+	AdminEmail         string    `json:"admin_email"`
+	SourceGroup        string    `json:"source_group"`
+	OrgUnit            string    `json:"org_unit"`
+	DeltaLink          string    `json:"-"`
+	CustomAttributes   string    `json:"custom_attributes"`
+	Recurrence         string    `json:"recurrence"`
+	NextSyncDate       time.Time `json:"next_sync_date"`
+	LastSyncDate       time.Time `json:"last_sync_date"`
+	ModifiedDate       time.Time `json:"modified_date"`
+}
+
+// DirectorySyncReport records the result of syncing a directory, including
+// the targets it added to, removed from and updated in the group.
+type DirectorySyncReport struct {
+	Id          int64     `json:"id"`
+	DirectoryId int64     `json:"directory_id"`
+	GroupId     int64     `json:"group_id"`
+	NumTargets  int       `json:"num_targets"`
+	Added       []Target  `json:"added" sql:"-"`
+	Removed     []Target  `json:"removed" sql:"-"`
+	Updated     []Target  `json:"updated" sql:"-"`
+	AddedJSON   string    `json:"-" gorm:"column:added"`
+	RemovedJSON string    `json:"-" gorm:"column:removed"`
+	UpdatedJSON string    `json:"-" gorm:"column:updated"`
+	Error       string    `json:"error"`
+	SyncDate    time.Time `json:"sync_date"`
+}
+
+// DirectoryMember maps a user's id in a cloud directory to the email address
+// of their target, so that users deleted between incremental syncs, which are
+// only identified by their id, can be removed from the group.
+type DirectoryMember struct {
+	Id          int64  `json:"-"`
+	DirectoryId int64  `json:"-"`
+	ExternalId  string `json:"external_id"`
+	Email       string `json:"email"`
+}
+
+// ErrInvalidDirectoryProvider is thrown when a directory's provider isn't
+// LDAP, Azure AD or Google Workspace
+var ErrInvalidDirectoryProvider = errors.New("Directory provider must be ldap, azure or google")
+
+// ErrDirectoryCredentialsNotSpecified is thrown when a cloud directory is
+// missing the credentials used to read it
+var ErrDirectoryCredentialsNotSpecified = errors.New("Directory credentials not specified")
+
+// ErrDirectoryNameNotSpecified is thrown when a directory has no name
+var ErrDirectoryNameNotSpecified = errors.New("Directory name not specified")
+
+// ErrInvalidDirectoryURL is thrown when a directory's URL isn't an ldap:// or
+// ldaps:// URL
+var ErrInvalidDirectoryURL = errors.New("Directory URL must be an ldap:// or ldaps:// URL")
+
+// ErrBaseDNNotSpecified is thrown when a directory has no base DN to search
+// beneath
+var ErrBaseDNNotSpecified = errors.New("Base DN not specified")
+
+// ErrDirectoryEmpty is thrown when a directory search returns no targets.
+// The group is left unchanged, so that a misconfigured filter or an outage
+// doesn't remove every target.
+var ErrDirectoryEmpty = errors.New("The directory search returned no targets")
+
+// The attributes targets are mapped from by default, which are those used
+// by Active Directory and inetOrgPerson
+const (
+	defaultFirstNameAttribute = "givenName"
+	defaultLastNameAttribute  = "sn"
+	defaultEmailAttribute     = "mail"
+	defaultPositionAttribute  = "title"
+	defaultPhoneAttribute     = "telephoneNumber"
+	defaultDirectoryFilter    = "(objectClass=person)"
+)
+
+// directorySearch returns the entries found by an LDAP directory's search.
+// It can be replaced in tests.
+var directorySearch = searchDirectory
+
+// directoryClient returns the client used to read a cloud directory. It can
+// be replaced in tests.
+var directoryClient = newDirectoryClient
+
+// TableName specifies the database tablename for Gorm to use
+func (d Directory) TableName() string {
+	return "directories"
+}
+
+// applyDefaults fills in the provider, as well as the filter and attribute
+// mapping of LDAP directories, if they're empty
+func (d *Directory) applyDefaults() {
+	if d.Provider == "" {
+		d.Provider = DIRECTORY_LDAP
+	}
+	if d.Provider != DIRECTORY_LDAP {
+		return
+	}
+	defaults := []struct {
+		field *string
+		value string
+	}{
+		{&d.Filter, defaultDirectoryFilter},
+		{&d.FirstNameAttribute, defaultFirstNameAttribute},
+		{&d.LastNameAttribute, defaultLastNameAttribute},
+		{&d.EmailAttribute, defaultEmailAttribute},
+		{&d.PositionAttribute, defaultPositionAttribute},
+		{&d.PhoneAttribute, defaultPhoneAttribute},
+	}
+	for _, df := range defaults {
+		if strings.TrimSpace(*df.field) == "" {
+			*df.field = df.value
+		}
+	}
+}
+
+// Validate ensures that the directory has a name and the settings needed to
+// read it, such as an LDAP directory's URL, base DN and filter, and that its
+// recurrence and group are valid if given.
+func (d *Directory) Validate() error {
+	if d.Name == "" {
+		return ErrDirectoryNameNotSpecified
+	}
+	switch d.Provider {
+	case DIRECTORY_LDAP:
+		if d.BaseDN == "" {
+			return ErrBaseDNNotSpecified
+		}
+		u, err := url.Parse(d.URL)
+		if err != nil || u.Hostname() == "" || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+			return ErrInvalidDirectoryURL
+		}
+		if _, err := ldap.CompileFilter(d.Filter); err != nil {
+			return err
+		}
+	case DIRECTORY_AZURE:
+		if d.Tenant == "" || d.ClientId == "" || d.ClientSecret == "" {
+			return ErrDirectoryCredentialsNotSpecified
+		}
+	case DIRECTORY_GOOGLE:
+		if d.ClientSecret == "" || d.AdminEmail == "" {
+			return ErrDirectoryCredentialsNotSpecified
+		}
+	default:
+		return ErrInvalidDirectoryProvider
+	}
+	if d.Recurrence != "" {
+		// A recurrence which never runs, such as on the 31st of February,
+		// would otherwise be due on every tick of the worker
+		sched, err := cron.Parse(d.Recurrence)
+		if err != nil || sched.Next(time.Now()).IsZero() {
+			return ErrInvalidRecurrence
+		}
+	}
+	if d.GroupId != 0 {
+		if _, err := GetGroup(d.GroupId, d.UserId); err != nil {
+			return ErrGroupNotFound
+		}
+	}
+	return nil
+}
+
+// next returns when the directory is next due to be synced after the given
+// time, or the zero time if it's only synced on demand
+func (d *Directory) next(t time.Time) time.Time {
+	if d.Recurrence == "" {
+		return time.Time{}
+	}
+	sched, err := cron.Parse(d.Recurrence)
+	if err != nil {
+		return time.Time{}
+	}
+	return sched.Next(t.UTC())
+}
+
+// attributes returns the attributes requested from the directory
+func (d *Directory) attributes() []string {
+	attrs := []string{d.FirstNameAttribute, d.LastNameAttribute, d.EmailAttribute, d.PositionAttribute, d.PhoneAttribute}
+	return append(attrs, d.customAttributes()...)
+}
+
+// customAttributes returns the directory attributes which are kept as the
+// targets' custom fields
+func (d *Directory) customAttributes() []string {
+	attrs := []string{}
+	for _, a := range strings.Split(d.CustomAttributes, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			attrs = append(attrs, a)
+		}
+	}
+	return attrs
+}
+
+// ldapUsers maps the LDAP entries onto users using the attribute mapping
+func (d *Directory) ldapUsers(es []ldap.Entry) []directory.User {
+	users := []directory.User{}
+	for _, e := range es {
+		u := directory.User{
+			Id:         e.DN,
+			FirstName:  e.Get(d.FirstNameAttribute),
+			LastName:   e.Get(d.LastNameAttribute),
+			Email:      e.Get(d.EmailAttribute),
+			Position:   e.Get(d.PositionAttribute),
+			Phone:      e.Get(d.PhoneAttribute),
+			Attributes: map[string]string{},
+		}
+		for _, a := range d.customAttributes() {
+			u.Attributes[a] = e.Get(a)
+		}
+		users = append(users, u)
+	}
+	return users
+}
+
+// target maps the directory user onto a target, returning false if they
+// don't have a valid email address
+func (d *Directory) target(u directory.User) (Target, bool) {
+	email := strings.TrimSpace(u.Email)
+	if _, err := mail.ParseAddress(email); err != nil {
+		return Target{}, false
+	}
+	t := Target{
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Email:     email,
+		Position:  u.Position,
+		Phone:     u.Phone,
+	}
+	// The targets' custom fields are only replaced if some are mapped, so
+	// that fields imported by other means are kept
+	custom := d.customAttributes()
+	if len(custom) > 0 {
+		t.Attributes = map[string]string{}
+		for _, a := range custom {
+			if v := u.Attributes[a]; v != "" {
+				t.Attributes[a] = v
+			}
+		}
+	}
+	return t, true
+}
+
+// targets maps the directory users onto targets. Users without a valid email
+// address are skipped, as are later users with the same address.
+func (d *Directory) targets(users []directory.User) []Target {
+	ts := []Target{}
+	seen := map[string]bool{}
+	for _, u := range users {
+		t, ok := d.target(u)
+		if !ok || u.Removed || seen[strings.ToLower(t.Email)] {
+			continue
+		}
+		seen[strings.ToLower(t.Email)] = true
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+// applyDelta applies the changed users returned by an incremental sync to
+// the group's current targets. The members map, from the users' ids to
+// their email addresses, is updated with the changes.
+func (d *Directory) applyDelta(current []Target, users []directory.User, members map[string]string) []Target {
+	changed := map[string]Target{}
+	removed := map[string]bool{}
+	order := []string{}
+	for _, u := range users {
+		// A changed user's email address may have changed too
+		if email, ok := members[u.Id]; ok {
+			removed[strings.ToLower(email)] = true
+			delete(members, u.Id)
+		}
+		if u.Removed {
+			continue
+		}
+		t, ok := d.target(u)
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(t.Email)
+		delete(removed, key)
+		if _, ok := changed[key]; !ok {
+			order = append(order, key)
+		}
+		changed[key] = t
+		members[u.Id] = t.Email
+	}
+	ts := []Target{}
+	for _, t := range current {
+		key := strings.ToLower(t.Email)
+		if removed[key] {
+			continue
+		}
+		if nt, ok := changed[key]; ok {
+			t = nt
+			delete(changed, key)
+		}
+		ts = append(ts, t)
+	}
+	for _, key := range order {
+		if t, ok := changed[key]; ok {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+// diffTargets compares a group's current targets with those found in the
+// directory, returning the targets which will be added, removed and updated.
+// Targets are matched by email address, as they are by PutGroup.
+func diffTargets(current []Target, found []Target) ([]Target, []Target, []Target) {
+	added, removed, updated := []Target{}, []Target{}, []Target{}
+	existing := map[string]Target{}
+	for _, t := range current {
+		existing[t.Email] = t
+	}
+	for _, t := range found {
+		old, ok := existing[t.Email]
+		if !ok {
+			added = append(added, t)
+			continue
+		}
+		delete(existing, t.Email)
+		changed := old.FirstName != t.FirstName || old.LastName != t.LastName ||
+			old.Position != t.Position || old.Phone != t.Phone
+		if t.Attributes != nil && (len(old.Attributes) > 0 || len(t.Attributes) > 0) {
+			changed = changed || !reflect.DeepEqual(old.Attributes, t.Attributes)
+		}
+		if changed {
+			updated = append(updated, t)
+		}
+	}
+	for _, t := range current {
+		if _, ok := existing[t.Email]; ok {
+			removed = append(removed, t)
+		}
+	}
+	return added, removed, updated
+}
+
+// searchDirectory connects to the directory, binding with its credentials if
+// it has any, and returns the entries matching its filter.
+func searchDirectory(d *Directory) ([]ldap.Entry, error) {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return nil, ErrInvalidDirectoryURL
+	}
+	config := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: d.IgnoreCertErrors}
+	conn, err := ldap.Dial(d.URL, config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if d.StartTLS && u.Scheme == "ldap" {
+		err = conn.StartTLS(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d.BindDN != "" {
+		err = conn.Bind(d.BindDN, d.BindPassword)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return conn.Search(d.BaseDN, d.Filter, d.attributes())
+}
+
+// newDirectoryClient returns the client for the directory's cloud provider
+func newDirectoryClient(d *Directory) directory.Client {
+	if d.Provider == DIRECTORY_AZURE {
+		return directory.NewAzureClient(d.Tenant, d.ClientId, d.ClientSecret, d.SourceGroup)
+	}
+	return directory.NewGoogleClient([]byte(d.ClientSecret), d.AdminEmail, d.OrgUnit, d.SourceGroup)
+}
+
+// fetch returns the targets the directory's group should have, given its
+// current targets, along with a function which saves the state used by
+// incremental syncs once the group has been updated.
+func (d *Directory) fetch(current []Target) ([]Target, func() error, error) {
+	none := func() error { return nil }
+	if d.Provider == DIRECTORY_LDAP {
+		es, err := directorySearch(d)
+		if err != nil {
+			return nil, none, err
+		}
+		return d.targets(d.ldapUsers(es)), none, nil
+	}
+	c := directoryClient(d)
+	dc, ok := c.(directory.DeltaClient)
+	if !ok || d.SourceGroup != "" {
+		users, err := c.Users()
+		if err != nil {
+			return nil, none, err
+		}
+		return d.targets(users), none, nil
+	}
+	link := d.DeltaLink
+	users, next, err := dc.Delta(link)
+	if err == directory.ErrDeltaExpired {
+		link = ""
+		users, next, err = dc.Delta(link)
+	}
+	if err != nil {
+		return nil, none, err
+	}
+	members := map[string]string{}
+	var ts []Target
+	if link == "" {
+		ts = d.targets(users)
+		for _, u := range users {
+			if t, ok := d.target(u); ok && !u.Removed {
+				members[u.Id] = t.Email
+			}
+		}
+	} else {
+		members, err = d.members()
+		if err != nil {
+			return nil, none, err
+		}
+		ts = d.applyDelta(current, users, members)
+	}
+	return ts, func() error { return d.saveDelta(next, members) }, nil
+}
+
+// members returns the directory's users' ids and email addresses, as of the
+// previous incremental sync
+func (d *Directory) members() (map[string]string, error) {
+	ms := []DirectoryMember{}
+	err := db.Where("directory_id=?", d.Id).Find(&ms).Error
+	members := map[string]string{}
+	for _, m := range ms {
+		members[m.ExternalId] = m.Email
+	}
+	return members, err
+}
+
+// saveDelta stores the delta link and members to use for the next
+// incremental sync
+func (d *Directory) saveDelta(link string, members map[string]string) error {
+	tx := db.Begin()
+	err := tx.Model(d).Update("delta_link", link).Error
+	if err == nil {
+		err = tx.Where("directory_id=?", d.Id).Delete(&DirectoryMember{}).Error
+	}
+	for id, email := range members {
+		if err != nil {
+			break
+		}
+		err = tx.Save(&DirectoryMember{DirectoryId: d.Id, ExternalId: id, Email: email}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Error(err)
+		return err
+	}
+	d.DeltaLink = link
+	return tx.Commit().Error
+}
+
+// GetDirectories returns the directories visible to the given user
+func GetDirectories(uid int64) ([]Directory, error) {
+	ds := []Directory{}
+	err := db.Where("user_id in (?)", teamUserIds(uid)).Order("id asc").Find(&ds).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return ds, err
+}
+
+// GetDirectory returns the directory, if it exists, specified by the given id
+// and user_id.
+func GetDirectory(id int64, uid int64) (Directory, error) {
+	d := Directory{}
+	err := db.Where("id=? and user_id in (?)", id, teamUserIds(uid)).First(&d).Error
+	return d, err
+}
+
+// PostDirectory creates a new directory in the database.
+func PostDirectory(d *Directory) error {
+	return PutDirectory(d)
+}
+
+// PutDirectory edits an existing directory in the database. The next sync is
+// recalculated from the current time, so that a changed recurrence takes
+// effect immediately, and the next sync of an incrementally synced directory
+// is a full sync, since its settings may have changed.
+func PutDirectory(d *Directory) error {
+	d.applyDefaults()
+	err := d.Validate()
+	if err != nil {
+		return err
+	}
+	d.ModifiedDate = time.Now().UTC()
+	d.NextSyncDate = d.next(d.ModifiedDate)
+	err = db.Save(d).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// DeleteDirectory deletes the directory specified by the given id and
+// user_id, along with its sync reports and members. The group it synced is
+// kept.
+func DeleteDirectory(id int64, uid int64) error {
+	d, err := GetDirectory(id, uid)
+	if err != nil {
+		return err
+	}
+	err = db.Where("directory_id=?", d.Id).Delete(&DirectorySyncReport{}).Error
+	if err == nil {
+		err = db.Where("directory_id=?", d.Id).Delete(&DirectoryMember{}).Error
+	}
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Delete(&d).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// GetDueDirectories returns the directories which are due to be synced at
+// the given time.
+func GetDueDirectories(t time.Time) ([]Directory, error) {
+	ds := []Directory{}
+	err := db.Where("recurrence <> ? and next_sync_date <= ?", "", t).Find(&ds).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return ds, err
+}
+
+// Sync replaces the targets in the directory's group with those found in
+// the directory, creating the group on the first sync if the directory
+// doesn't have one, and records a report of the changes. The report is
+// returned even if the sync fails, with the error recorded in it. A dry run
+// only returns the changes which would be made.
+func (d *Directory) Sync(t time.Time, dryRun bool) (DirectorySyncReport, error) {
+	r := DirectorySyncReport{DirectoryId: d.Id, GroupId: d.GroupId, SyncDate: t.UTC()}
+	if !dryRun {
+		// The schedule is advanced even if the sync fails, so that it isn't
+		// retried every minute
+		d.LastSyncDate = t.UTC()
+		d.NextSyncDate = d.next(t)
+		err := db.Model(d).Updates(map[string]interface{}{
+			"last_sync_date": d.LastSyncDate,
+			"next_sync_date": d.NextSyncDate,
+		}).Error
+		if err != nil {
+			log.Error(err)
+			return r, err
+		}
+	}
+	err := d.sync(&r, dryRun)
+	if err != nil {
+		r.Error = err.Error()
+		log.WithFields(logrus.Fields{
+			"directory_id": d.Id,
+		}).Error(err)
+	}
+	if dryRun {
+		return r, err
+	}
+	if serr := r.save(); serr != nil {
+		return r, serr
+	}
+	return r, err
+}
+
+// sync reads the directory and updates its group, filling in the report
+func (d *Directory) sync(r *DirectorySyncReport, dryRun bool) error {
+	g := Group{Name: d.Name, UserId: d.UserId}
+	if d.GroupId != 0 {
+		var err error
+		g, err = GetGroup(d.GroupId, d.UserId)
+		if err != nil {
+			return ErrGroupNotFound
+		}
+	}
+	ts, commit, err := d.fetch(g.Targets)
+	if err != nil {
+		return err
+	}
+	if len(ts) == 0 {
+		return ErrDirectoryEmpty
+	}
+	r.NumTargets = len(ts)
+	r.Added, r.Removed, r.Updated = diffTargets(g.Targets, ts)
+	if dryRun {
+		return nil
+	}
+	g.Targets = ts
+	g.ModifiedDate = time.Now().UTC()
+	if g.Id != 0 {
+		err = PutGroup(&g)
+	} else {
+		err = PostGroup(&g)
+		if err == nil {
+			d.GroupId = g.Id
+			r.GroupId = g.Id
+			err = db.Model(d).Update("group_id", g.Id).Error
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return commit()
+}
+
+// save stores the report in the database
+func (r *DirectorySyncReport) save() error {
+	for _, f := range []struct {
+		targets []Target
+		dst     *string
+	}{
+		{r.Added, &r.AddedJSON},
+		{r.Removed, &r.RemovedJSON},
+		{r.Updated, &r.UpdatedJSON},
+	} {
+		if f.targets == nil {
+			f.targets = []Target{}
+		}
+		b, err := json.Marshal(f.targets)
+		if err != nil {
+			return err
+		}
+		*f.dst = string(b)
+	}
+	err := db.Save(r).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// AfterFind decodes the targets recorded in the report
+func (r *DirectorySyncReport) AfterFind() error {
+	r.SyncDate = r.SyncDate.UTC()
+	for _, f := range []struct {
+		src string
+		dst *[]Target
+	}{
+		{r.AddedJSON, &r.Added},
+		{r.RemovedJSON, &r.Removed},
+		{r.UpdatedJSON, &r.Updated},
+	} {
+		*f.dst = []Target{}
+		if f.src == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(f.src), f.dst); err != nil {
+			log.Error(err)
+		}
+	}
+	return nil
+}
+
+// GetDirectorySyncReports returns the most recent sync reports of the
+// directory specified by the given id and user_id, newest first.
+func GetDirectorySyncReports(id int64, uid int64, limit int) ([]DirectorySyncReport, error) {
+	rs := []DirectorySyncReport{}
+	d, err := GetDirectory(id, uid)
+	if err != nil {
+		return rs, err
+	}
+	if limit <= 0 || limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	err = db.Where("directory_id=?", d.Id).Order("sync_date desc, id desc").Limit(limit).Find(&rs).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return rs, err
+}
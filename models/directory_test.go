@@ -0,0 +1,336 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gophish/gophish/directory"
+	"github.com/gophish/gophish/ldap"
+	"gopkg.in/check.v1"
+)
+
+// stubDirectory replaces the directory search with one returning the given
+// entries, until the returned function is called
+func stubDirectory(es []ldap.Entry, err error) func() {
+	search := directorySearch
+	directorySearch = func(d *Directory) ([]ldap.Entry, error) {
+		return es, err
+	}
+	return func() { directorySearch = search }
+}
+
+// fakeDirectoryClient is a cloud directory which returns the given users,
+// and the given changes for each delta link
+type fakeDirectoryClient struct {
+	users  []directory.User
+	deltas map[string][]directory.User
+	links  []string
+}
+
+func (c *fakeDirectoryClient) Users() ([]directory.User, error) {
+	return c.users, nil
+}
+
+func (c *fakeDirectoryClient) Delta(link string) ([]directory.User, string, error) {
+	c.links = append(c.links, link)
+	if link == "" {
+		return c.users, "link1", nil
+	}
+	users, ok := c.deltas[link]
+	if !ok {
+		return nil, "", directory.ErrDeltaExpired
+	}
+	return users, link + "+", nil
+}
+
+// stubDirectoryClient replaces the cloud directory client with the given
+// client, until the returned function is called
+func stubDirectoryClient(c directory.Client) func() {
+	client := directoryClient
+	directoryClient = func(d *Directory) directory.Client {
+		return c
+	}
+	return func() { directoryClient = client }
+}
+
+func cloudUser(id, first, email string) directory.User {
+	return directory.User{Id: id, FirstName: first, Email: email, Attributes: map[string]string{"department": "Sales"}}
+}
+
+func directoryEntry(first, last, email, title, department string) ldap.Entry {
+	return ldap.Entry{
+		DN: "cn=" + first + ",dc=example,dc=com",
+		Attributes: map[string][]string{
+			"givenName":  {first},
+			"sn":         {last},
+			"mail":       {email},
+			"title":      {title},
+			"department": {department},
+		},
+	}
+}
+
+func newDirectory() Directory {
+	return Directory{
+		UserId:           1,
+		Name:             "Sales",
+		URL:              "ldaps://dc.example.com",
+		BaseDN:           "ou=Sales,dc=example,dc=com",
+		Filter:           "(&(objectClass=user)(mail=*))",
+		CustomAttributes: "department",
+		Recurrence:       "@daily",
+	}
+}
+
+func (s *ModelsSuite) TestPostDirectoryValidation(ch *check.C) {
+	for _, tc := range []struct {
+		modify   func(*Directory)
+		expected error
+	}{
+		{func(d *Directory) { d.Name = "" }, ErrDirectoryNameNotSpecified},
+		{func(d *Directory) { d.BaseDN = "" }, ErrBaseDNNotSpecified},
+		{func(d *Directory) { d.URL = "http://dc.example.com" }, ErrInvalidDirectoryURL},
+		{func(d *Directory) { d.URL = "ldap://" }, ErrInvalidDirectoryURL},
+		{func(d *Directory) { d.Filter = "(mail=*" }, ldap.ErrInvalidFilter},
+		{func(d *Directory) { d.Recurrence = "daily" }, ErrInvalidRecurrence},
+		{func(d *Directory) { d.Recurrence = "0 0 31 2 *" }, ErrInvalidRecurrence},
+		{func(d *Directory) { d.GroupId = 1234 }, ErrGroupNotFound},
+		{func(d *Directory) { d.Provider = "okta" }, ErrInvalidDirectoryProvider},
+		{func(d *Directory) { d.Provider = DIRECTORY_AZURE; d.Tenant = "tenant" }, ErrDirectoryCredentialsNotSpecified},
+		{func(d *Directory) { d.Provider = DIRECTORY_GOOGLE; d.ClientSecret = "{}" }, ErrDirectoryCredentialsNotSpecified},
+	} {
+		d := newDirectory()
+		tc.modify(&d)
+		ch.Assert(PostDirectory(&d), check.Equals, tc.expected)
+	}
+	d := newDirectory()
+	d.Filter = ""
+	ch.Assert(PostDirectory(&d), check.Equals, nil)
+	ch.Assert(d.Provider, check.Equals, DIRECTORY_LDAP)
+	ch.Assert(d.Filter, check.Equals, defaultDirectoryFilter)
+	ch.Assert(d.EmailAttribute, check.Equals, defaultEmailAttribute)
+	ch.Assert(d.NextSyncDate.After(time.Now()), check.Equals, true)
+}
+
+func (s *ModelsSuite) TestDirectorySync(ch *check.C) {
+	d := newDirectory()
+	ch.Assert(PostDirectory(&d), check.Equals, nil)
+	restore := stubDirectory([]ldap.Entry{
+		directoryEntry("Alice", "Smith", "alice@example.com", "Manager", "Sales"),
+		directoryEntry("Bob", "Jones", "bob@example.com", "Rep", "Sales"),
+		// Entries without an email or with a duplicate email are skipped
+		directoryEntry("Printer", "", "", "", ""),
+		directoryEntry("Alice", "Smith", "ALICE@example.com", "Manager", "Sales"),
+	}, nil)
+	defer restore()
+
+	// The first sync creates the group
+	now := time.Date(2018, 7, 20, 9, 0, 0, 0, time.UTC)
+	r, err := d.Sync(now, false)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(d.GroupId, check.Not(check.Equals), int64(0))
+	ch.Assert(r.GroupId, check.Equals, d.GroupId)
+	ch.Assert(r.NumTargets, check.Equals, 2)
+	ch.Assert(len(r.Added), check.Equals, 2)
+	g, err := GetGroup(d.GroupId, 1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(g.Name, check.Equals, "Sales")
+	ch.Assert(len(g.Targets), check.Equals, 2)
+	ch.Assert(g.Targets[0].Attributes["department"], check.Equals, "Sales")
+
+	stored, err := GetDirectory(d.Id, 1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(stored.GroupId, check.Equals, d.GroupId)
+	ch.Assert(stored.LastSyncDate.Equal(now), check.Equals, true)
+	ch.Assert(stored.NextSyncDate.Equal(time.Date(2018, 7, 21, 0, 0, 0, 0, time.UTC)), check.Equals, true)
+
+	// Later syncs report the differences
+	restore()
+	restore = stubDirectory([]ldap.Entry{
+		directoryEntry("Alice", "Smith", "alice@example.com", "Director", "Sales"),
+		directoryEntry("Carol", "White", "carol@example.com", "Rep", "Sales"),
+	}, nil)
+	r, err = d.Sync(now.Add(24*time.Hour), false)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(r.Added), check.Equals, 1)
+	ch.Assert(r.Added[0].Email, check.Equals, "carol@example.com")
+	ch.Assert(len(r.Removed), check.Equals, 1)
+	ch.Assert(r.Removed[0].Email, check.Equals, "bob@example.com")
+	ch.Assert(len(r.Updated), check.Equals, 1)
+	ch.Assert(r.Updated[0].Position, check.Equals, "Director")
+	g, err = GetGroup(d.GroupId, 1)
+	ch.Assert(err, check.Equals, nil)
+	emails := []string{}
+	for _, t := range g.Targets {
+		emails = append(emails, t.Email)
+	}
+	ch.Assert(emails, check.DeepEquals, []string{"alice@example.com", "carol@example.com"})
+
+	rs, err := GetDirectorySyncReports(d.Id, 1, 0)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(rs), check.Equals, 2)
+	ch.Assert(rs[0].Removed[0].Email, check.Equals, "bob@example.com")
+	ch.Assert(len(rs[1].Added), check.Equals, 2)
+	ch.Assert(len(rs[1].Removed), check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestDirectorySyncDryRun(ch *check.C) {
+	d := newDirectory()
+	ch.Assert(PostDirectory(&d), check.Equals, nil)
+	next := d.NextSyncDate
+	defer stubDirectory([]ldap.Entry{
+		directoryEntry("Alice", "Smith", "alice@example.com", "Manager", "Sales"),
+	}, nil)()
+	r, err := d.Sync(time.Now().UTC(), true)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(r.Added), check.Equals, 1)
+	ch.Assert(d.GroupId, check.Equals, int64(0))
+	stored, err := GetDirectory(d.Id, 1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(stored.NextSyncDate.Equal(next), check.Equals, true)
+	rs, err := GetDirectorySyncReports(d.Id, 1, 0)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(rs), check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestDirectorySyncFailureKeepsGroup(ch *check.C) {
+	g := Group{Name: "Sales", UserId: 1, Targets: generateTargets(3)}
+	ch.Assert(PostGroup(&g), check.Equals, nil)
+	d := newDirectory()
+	d.GroupId = g.Id
+	ch.Assert(PostDirectory(&d), check.Equals, nil)
+
+	searchErr := errors.New("connection refused")
+	for _, tc := range []struct {
+		es       []ldap.Entry
+		err      error
+		expected error
+	}{
+		{nil, searchErr, searchErr},
+		{[]ldap.Entry{}, nil, ErrDirectoryEmpty},
+	} {
+		restore := stubDirectory(tc.es, tc.err)
+		r, err := d.Sync(time.Now().UTC(), false)
+		restore()
+		ch.Assert(err, check.Equals, tc.expected)
+		ch.Assert(r.Error, check.Equals, tc.expected.Error())
+		stored, err := GetGroup(g.Id, 1)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(len(stored.Targets), check.Equals, 3)
+	}
+	rs, err := GetDirectorySyncReports(d.Id, 1, 1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(rs), check.Equals, 1)
+	ch.Assert(rs[0].Error, check.Equals, ErrDirectoryEmpty.Error())
+}
+
+func (s *ModelsSuite) TestGetDueDirectories(ch *check.C) {
+	d := newDirectory()
+	ch.Assert(PostDirectory(&d), check.Equals, nil)
+	manual := newDirectory()
+	manual.Recurrence = ""
+	ch.Assert(PostDirectory(&manual), check.Equals, nil)
+
+	ds, err := GetDueDirectories(time.Now().UTC())
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ds), check.Equals, 0)
+	ds, err = GetDueDirectories(d.NextSyncDate)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ds), check.Equals, 1)
+	ch.Assert(ds[0].Id, check.Equals, d.Id)
+}
+
+func newCloudDirectory(ch *check.C, sourceGroup string) Directory {
+	d := Directory{
+		UserId:           1,
+		Name:             "Everyone",
+		Provider:         DIRECTORY_AZURE,
+		Tenant:           "tenant",
+		ClientId:         "client",
+		ClientSecret:     "secret",
+		SourceGroup:      sourceGroup,
+		CustomAttributes: "department",
+	}
+	ch.Assert(PostDirectory(&d), check.Equals, nil)
+	// The LDAP attribute mapping isn't used
+	ch.Assert(d.EmailAttribute, check.Equals, "")
+	return d
+}
+
+func (s *ModelsSuite) TestCloudDirectorySyncGroup(ch *check.C) {
+	d := newCloudDirectory(ch, "sales")
+	c := &fakeDirectoryClient{users: []directory.User{
+		cloudUser("1", "Alice", "alice@example.com"),
+		cloudUser("2", "Bob", "not an email"),
+	}}
+	defer stubDirectoryClient(c)()
+	r, err := d.Sync(time.Now().UTC(), false)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(r.Added), check.Equals, 1)
+	ch.Assert(r.Added[0].Attributes, check.DeepEquals, map[string]string{"department": "Sales"})
+	// Groups are synced in full, so no delta query is made
+	ch.Assert(len(c.links), check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestCloudDirectorySyncIncremental(ch *check.C) {
+	d := newCloudDirectory(ch, "")
+	c := &fakeDirectoryClient{
+		users: []directory.User{
+			cloudUser("1", "Alice", "alice@example.com"),
+			cloudUser("2", "Bob", "bob@example.com"),
+			cloudUser("3", "Carol", "carol@example.com"),
+		},
+		deltas: map[string][]directory.User{
+			"link1": {
+				// Alice's address changed, Bob was deleted and Dave joined
+				cloudUser("1", "Alice", "alice.smith@example.com"),
+				{Id: "2", Removed: true},
+				cloudUser("4", "Dave", "dave@example.com"),
+			},
+		},
+	}
+	defer stubDirectoryClient(c)()
+	_, err := d.Sync(time.Now().UTC(), false)
+	ch.Assert(err, check.Equals, nil)
+	stored, err := GetDirectory(d.Id, 1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(stored.DeltaLink, check.Equals, "link1")
+
+	r, err := stored.Sync(time.Now().UTC(), false)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(c.links, check.DeepEquals, []string{"", "link1"})
+	ch.Assert(len(r.Added), check.Equals, 2)
+	ch.Assert(len(r.Removed), check.Equals, 2)
+	g, err := GetGroup(d.GroupId, 1)
+	ch.Assert(err, check.Equals, nil)
+	emails := []string{}
+	for _, t := range g.Targets {
+		emails = append(emails, t.Email)
+	}
+	ch.Assert(emails, check.DeepEquals, []string{"carol@example.com", "alice.smith@example.com", "dave@example.com"})
+	members, err := stored.members()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(members, check.DeepEquals, map[string]string{
+		"1": "alice.smith@example.com",
+		"3": "carol@example.com",
+		"4": "dave@example.com",
+	})
+
+	// An expired delta link falls back to a full sync
+	r, err = stored.Sync(time.Now().UTC(), false)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(c.links, check.DeepEquals, []string{"", "link1", "link1+", ""})
+	ch.Assert(r.NumTargets, check.Equals, 3)
+	ch.Assert(stored.DeltaLink, check.Equals, "link1")
+}
+
+func (s *ModelsSuite) TestCloudDirectoryDryRunKeepsDelta(ch *check.C) {
+	d := newCloudDirectory(ch, "")
+	c := &fakeDirectoryClient{users: []directory.User{cloudUser("1", "Alice", "alice@example.com")}}
+	defer stubDirectoryClient(c)()
+	_, err := d.Sync(time.Now().UTC(), true)
+	ch.Assert(err, check.Equals, nil)
+	stored, err := GetDirectory(d.Id, 1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(stored.DeltaLink, check.Equals, "")
+}
@@ -1,11 +1,8 @@
 package models
 
 import (
-	"encoding/base64"
-	"fmt"
-	"io"
 	"net/mail"
-	"strings"
+	"net/url"
 
 	"github.com/gophish/gomail"
 	log "github.com/gophish/gophish/logger"
@@ -70,30 +67,50 @@ func (s *SendTestEmailRequest) Generate(msg *gomail.Message) error {
 	}
 	msg.SetAddressHeader("From", f.Address, f.Name)
 
-	url, err := buildTemplate(s.URL, s)
+	link, err := buildTemplate(s.URL, s)
 	if err != nil {
 		return err
 	}
-	s.URL = url
+	s.URL = link
+	phishURL, _ := url.Parse(link)
+	// Test emails don't have a result to record attachments being opened
+	// against, so the attachments' tracking URLs are left empty
+	td := struct {
+		Target
+		phishingURL
+		TrackingURL       string
+		Tracker           string
+		From              string
+		AttachmentURL     string
+		AttachmentTracker string
+	}{
+		s.Target,
+		phishingURL{url: phishURL, qr: &qrCodes{}},
+		s.TrackingURL,
+		s.Tracker,
+		s.From,
+		"",
+		"",
+	}
 
 	// Parse the customHeader templates
 	for _, header := range s.SMTP.Headers {
-		key, err := buildTemplate(header.Key, s)
+		key, err := buildTemplate(header.Key, td)
 		if err != nil {
 			log.Error(err)
 		}
 
-		value, err := buildTemplate(header.Value, s)
+		value, err := buildTemplate(header.Value, td)
 		if err != nil {
 			log.Error(err)
 		}
 
 		// Add our header immediately
-		msg.SetHeader(key, value)
+		msg.SetHeader(key, headerValue(value))
 	}
 
 	// Parse remaining templates
-	subject, err := buildTemplate(s.Template.Subject, s)
+	subject, err := buildTemplate(s.Template.Subject, td)
 	if err != nil {
 		log.Error(err)
 	}
@@ -104,14 +121,14 @@ func (s *SendTestEmailRequest) Generate(msg *gomail.Message) error {
 
 	msg.SetHeader("To", s.FormatAddress())
 	if s.Template.Text != "" {
-		text, err := buildTemplate(s.Template.Text, s)
+		text, err := buildTemplate(s.Template.Text, td)
 		if err != nil {
 			log.Error(err)
 		}
 		msg.SetBody("text/plain", text)
 	}
 	if s.Template.HTML != "" {
-		html, err := buildTemplate(s.Template.HTML, s)
+		html, err := buildTemplate(s.Template.HTML, td)
 		if err != nil {
 			log.Error(err)
 		}
@@ -123,15 +140,9 @@ func (s *SendTestEmailRequest) Generate(msg *gomail.Message) error {
 	}
 	// Attach the files
 	for _, a := range s.Template.Attachments {
-		msg.Attach(func(a Attachment) (string, gomail.FileSetting, gomail.FileSetting) {
-			h := map[string][]string{"Content-ID": {fmt.Sprintf("<%s>", a.Name)}}
-			return a.Name, gomail.SetCopyFunc(func(w io.Writer) error {
-				decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(a.Content))
-				_, err = io.Copy(w, decoder)
-				return err
-			}), gomail.SetHeader(h)
-		}(a))
+		attachFile(msg, a, td)
 	}
+	td.qr.embed(msg)
 
 	return nil
 }
@@ -0,0 +1,684 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/parquet-go/parquet-go"
+)
+
+// ErrInvalidExportColumn is thrown when an export requests a column that
+// isn't supported.
+var ErrInvalidExportColumn = errors.New("Invalid export column")
+
+// DefaultExportColumns are the columns exported when no columns are given.
+var DefaultExportColumns = []string{"email", "first_name", "last_name", "position"}
+
+// timeTo returns how long after the email was sent the recipient first
+// triggered the given event, and whether or not they triggered it at all.
+// Events timestamped before the send, such as from clock skew, are clamped to
+// zero rather than producing a negative duration.
+func (r *Result) timeTo(message string) (time.Duration, bool, error) {
+	es, err := r.GetEvents()
+	if err != nil {
+		return 0, false, err
+	}
+	var sent, triggered time.Time
+	for _, e := range es {
+		switch {
+		case e.Message == EVENT_SENT && sent.IsZero():
+			sent = e.Time
+		case e.Message == message && triggered.IsZero():
+			triggered = e.Time
+		}
+	}
+	if sent.IsZero() || triggered.IsZero() {
+		return 0, false, nil
+	}
+	d := triggered.Sub(sent)
+	if d < 0 {
+		d = 0
+	}
+	return d, true, nil
+}
+
+// exportTimeTo returns an export column with the number of seconds between
+// the email being sent and the recipient first triggering the given event.
+func exportTimeTo(message string) func(r *Result) (string, error) {
+	return func(r *Result) (string, error) {
+		d, ok, err := r.timeTo(message)
+		if err != nil || !ok {
+			return "", err
+		}
+		return strconv.FormatInt(int64(d/time.Second), 10), nil
+	}
+}
+
+// exportColumns maps the supported export columns to the function used to
+// render each result's value.
+var exportColumns = map[string]func(r *Result) (string, error){
+	"id":           func(r *Result) (string, error) { return r.RId, nil },
+	"email":        func(r *Result) (string, error) { return r.Email, nil },
+	"first_name":   func(r *Result) (string, error) { return r.FirstName, nil },
+	"last_name":    func(r *Result) (string, error) { return r.LastName, nil },
+	"position":     func(r *Result) (string, error) { return r.Position, nil },
+	"status":       func(r *Result) (string, error) { return r.Status, nil },
+	"reported":     func(r *Result) (string, error) { return strconv.FormatBool(r.Reported), nil },
+	"ip":           func(r *Result) (string, error) { return r.IP, nil },
+	"reverse_dns":  func(r *Result) (string, error) { return r.ReverseDNS, nil },
+	"subject":      func(r *Result) (string, error) { return r.Subject, nil },
+	"country":      func(r *Result) (string, error) { return r.Country, nil },
+	"country_name": func(r *Result) (string, error) { return r.CountryName, nil },
+	"city":         func(r *Result) (string, error) { return r.City, nil },
+	"variant_id": func(r *Result) (string, error) {
+		return strconv.FormatInt(r.VariantId, 10), nil
+	},
+	"smtp_id": func(r *Result) (string, error) {
+		return strconv.FormatInt(r.SMTPId, 10), nil
+	},
+	"latitude": func(r *Result) (string, error) {
+		return strconv.FormatFloat(r.Latitude, 'f', -1, 64), nil
+	},
+	"longitude": func(r *Result) (string, error) {
+		return strconv.FormatFloat(r.Longitude, 'f', -1, 64), nil
+	},
+	"modified_date": func(r *Result) (string, error) {
+		return r.ModifiedDate.Format(time.RFC3339), nil
+	},
+	"send_date": func(r *Result) (string, error) {
+		return r.SendDate.Format(time.RFC3339), nil
+	},
+	"time_to_open":   exportTimeTo(EVENT_OPENED),
+	"time_to_click":  exportTimeTo(EVENT_CLICKED),
+	"time_to_submit": exportTimeTo(EVENT_DATA_SUBMIT),
+	"tags": func(r *Result) (string, error) {
+		ts, err := r.tags()
+		return strings.Join(ts, "; "), err
+	},
+	"notes": func(r *Result) (string, error) {
+		ns, err := r.notes()
+		texts := make([]string, len(ns))
+		for i, n := range ns {
+			texts[i] = n.Text
+		}
+		return strings.Join(texts, "\n"), err
+	},
+}
+
+// ExportForMailMerge writes the results in the campaign matching the filter
+// to w as a CSV file with the given columns, suitable for a mail merge to
+// follow up with the recipients. The time_to_* columns contain the number of
+// seconds after the email was sent, and are empty if the recipient never
+// triggered the event.
+func ExportForMailMerge(w io.Writer, cid int64, uid int64, f ResultFilter, columns []string) error {
+	if len(columns) == 0 {
+		columns = DefaultExportColumns
+	}
+	fns := []func(r *Result) (string, error){}
+	for _, c := range columns {
+		fn, ok := exportColumns[c]
+		if !ok {
+			return ErrInvalidExportColumn
+		}
+		fns = append(fns, fn)
+	}
+	rs, err := QueryResults(cid, uid, f)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	err = cw.Write(columns)
+	if err != nil {
+		return err
+	}
+	for i := range rs {
+		record := make([]string, len(fns))
+		for j, fn := range fns {
+			record[j], err = fn(&rs[i])
+			if err != nil {
+				return err
+			}
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// AuditReport is a record of every event Gophish observed in a campaign,
+// grouped by target, suitable for archival.
+type AuditReport struct {
+	CampaignId   int64         `json:"campaign_id"`
+	CampaignName string        `json:"campaign_name"`
+	Generated    time.Time     `json:"generated"`
+	Events       []AuditEntry  `json:"events"`
+	Results      []AuditResult `json:"results"`
+}
+
+// AuditResult contains the events observed for a single target.
+type AuditResult struct {
+	Id     string       `json:"id"`
+	Email  string       `json:"email"`
+	Events []AuditEntry `json:"events"`
+}
+
+// AuditEntry is a single event in an audit report. Each entry's hash covers
+// the entry and the hash of the entry before it, forming a chain across the
+// whole report so that modified, removed, or reordered entries are detected.
+type AuditEntry struct {
+	Email   string    `json:"email,omitempty"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+	IP      string    `json:"ip,omitempty"`
+	Hash    string    `json:"hash"`
+}
+
+// hash returns the chained hash of the entry given the previous hash.
+func (a AuditEntry) hash(prev string) string {
+	h := sha256.Sum256([]byte(strings.Join([]string{
+		prev, a.Email, a.Message, a.Time.UTC().Format(time.RFC3339Nano), a.IP,
+	}, "\n")))
+	return hex.EncodeToString(h[:])
+}
+
+// ErrAuditChainBroken is thrown when an audit report's hash chain doesn't
+// match its entries.
+var ErrAuditChainBroken = errors.New("Audit report hash chain is broken")
+
+// entries returns every entry in the report, in the order they are chained.
+func (ar *AuditReport) entries() []*AuditEntry {
+	es := []*AuditEntry{}
+	for i := range ar.Events {
+		es = append(es, &ar.Events[i])
+	}
+	for i := range ar.Results {
+		for j := range ar.Results[i].Events {
+			es = append(es, &ar.Results[i].Events[j])
+		}
+	}
+	return es
+}
+
+// Verify checks the report's hash chain, returning ErrAuditChainBroken if any
+// entry doesn't match.
+func (ar *AuditReport) Verify() error {
+	prev := ""
+	for _, e := range ar.entries() {
+		if e.Hash != e.hash(prev) {
+			return ErrAuditChainBroken
+		}
+		prev = e.Hash
+	}
+	return nil
+}
+
+// ExportAuditReport writes an AuditReport for the campaign specified by the
+// given id and user_id to w as JSON. Campaign-level events, such as the
+// campaign being created, are listed first, followed by each result's events
+// in the order they occurred.
+func ExportAuditReport(w io.Writer, cid int64, uid int64) error {
+	c := Campaign{}
+	err := db.Where("id=? and user_id in (?)", cid, teamUserIds(uid)).First(&c).Error
+	if err != nil {
+		return err
+	}
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return err
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Id < rs[j].Id })
+	es := []Event{}
+	err = db.Where("campaign_id=?", cid).Order("time asc, id asc").Find(&es).Error
+	if err != nil {
+		return err
+	}
+	ar := AuditReport{
+		CampaignId:   c.Id,
+		CampaignName: c.Name,
+		Generated:    time.Now().UTC(),
+		Events:       []AuditEntry{},
+		Results:      []AuditResult{},
+	}
+	byEmail := make(map[string][]AuditEntry)
+	for _, e := range es {
+		d, err := e.parseDetails()
+		if err != nil {
+			return err
+		}
+		entry := AuditEntry{
+			Email:   e.Email,
+			Message: e.Message,
+			Time:    e.Time.UTC(),
+			IP:      d.Browser["address"],
+		}
+		if e.Email == "" {
+			ar.Events = append(ar.Events, entry)
+			continue
+		}
+		byEmail[e.Email] = append(byEmail[e.Email], entry)
+	}
+	for _, r := range rs {
+		entries := byEmail[r.Email]
+		if entries == nil {
+			entries = []AuditEntry{}
+		}
+		ar.Results = append(ar.Results, AuditResult{
+			Id:     r.RId,
+			Email:  r.Email,
+			Events: entries,
+		})
+	}
+	prev := ""
+	for _, e := range ar.entries() {
+		e.Hash = e.hash(prev)
+		prev = e.Hash
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ar)
+}
+
+// ExportForLMS writes the results in the campaign specified by the given id
+// and user_id to w as a CSV file for upload to a learning management system.
+// Each row contains the target's email, first and last names, and whether or
+// not they need training, which is true if their status is one of the given
+// statuses.
+func ExportForLMS(w io.Writer, cid int64, uid int64, needsTrainingStatuses []string) error {
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return err
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Id < rs[j].Id })
+	needsTraining := make(map[string]bool)
+	for _, s := range needsTrainingStatuses {
+		needsTraining[s] = true
+	}
+	cw := csv.NewWriter(w)
+	err = cw.Write([]string{"email", "first_name", "last_name", "needs_training"})
+	if err != nil {
+		return err
+	}
+	for _, r := range rs {
+		err = cw.Write([]string{
+			r.Email,
+			r.FirstName,
+			r.LastName,
+			strconv.FormatBool(needsTraining[r.Status]),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportBatchSize is the number of results loaded from the database at a time
+// when streaming an export.
+const exportBatchSize = 500
+
+// forEachResultBatch loads the results in the campaign specified by the given
+// id and user_id in batches ordered by id, calling fn with each batch, so that
+// large campaigns aren't held in memory all at once.
+func forEachResultBatch(cid int64, uid int64, fn func(rs []Result) error) error {
+	err := FlushResults()
+	if err != nil {
+		return err
+	}
+	var lastId int64
+	for {
+		rs := []Result{}
+		err := db.Table("results").Where("campaign_id=? and user_id in (?) and id > ?", cid, teamUserIds(uid), lastId).
+			Order("id asc").Limit(exportBatchSize).Find(&rs).Error
+		if err != nil {
+			return err
+		}
+		if len(rs) == 0 {
+			return nil
+		}
+		err = fn(rs)
+		if err != nil {
+			return err
+		}
+		if len(rs) < exportBatchSize {
+			return nil
+		}
+		lastId = rs[len(rs)-1].Id
+	}
+}
+
+// ParquetResult is a single row in a Parquet export of campaign results. The
+// time_to_* columns contain the number of seconds after the email was sent,
+// and are null if the recipient never triggered the event. The target's
+// attributes are written as additional columns after these, as described by
+// ExportResultsParquet.
+type ParquetResult struct {
+	Id             string    `parquet:"id"`
+	Email          string    `parquet:"email"`
+	FirstName      string    `parquet:"first_name"`
+	LastName       string    `parquet:"last_name"`
+	Position       string    `parquet:"position"`
+	Status         string    `parquet:"status"`
+	Reported       bool      `parquet:"reported"`
+	IP             string    `parquet:"ip"`
+	Latitude       float64   `parquet:"latitude"`
+	Longitude      float64   `parquet:"longitude"`
+	Country        string    `parquet:"country"`
+	CountryName    string    `parquet:"country_name"`
+	City           string    `parquet:"city"`
+	ReverseDNS     string    `parquet:"reverse_dns"`
+	ProviderType   string    `parquet:"provider_type"`
+	AcceptLanguage string    `parquet:"accept_language"`
+	SendDate       time.Time `parquet:"send_date,timestamp"`
+	ModifiedDate   time.Time `parquet:"modified_date,timestamp"`
+	TimeToOpen     *int64    `parquet:"time_to_open,optional"`
+	TimeToClick    *int64    `parquet:"time_to_click,optional"`
+	TimeToSubmit   *int64    `parquet:"time_to_submit,optional"`
+}
+
+// columns returns the row's values keyed by their column names.
+func (pr ParquetResult) columns() map[string]interface{} {
+	return map[string]interface{}{
+		"id":              pr.Id,
+		"email":           pr.Email,
+		"first_name":      pr.FirstName,
+		"last_name":       pr.LastName,
+		"position":        pr.Position,
+		"status":          pr.Status,
+		"reported":        pr.Reported,
+		"ip":              pr.IP,
+		"latitude":        pr.Latitude,
+		"longitude":       pr.Longitude,
+		"country":         pr.Country,
+		"country_name":    pr.CountryName,
+		"city":            pr.City,
+		"reverse_dns":     pr.ReverseDNS,
+		"provider_type":   pr.ProviderType,
+		"accept_language": pr.AcceptLanguage,
+		"send_date":       pr.SendDate,
+		"modified_date":   pr.ModifiedDate,
+		"time_to_open":    optionalColumn(pr.TimeToOpen),
+		"time_to_click":   optionalColumn(pr.TimeToClick),
+		"time_to_submit":  optionalColumn(pr.TimeToSubmit),
+	}
+}
+
+// optionalColumn returns the value of an optional column, which is nil if
+// the column is null.
+func optionalColumn(v *int64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+// parquetTimeTo returns the number of seconds between the email being sent
+// and the recipient first triggering the given event, or nil if they never
+// triggered it.
+func (r *Result) parquetTimeTo(message string) (*int64, error) {
+	d, ok, err := r.timeTo(message)
+	if err != nil || !ok {
+		return nil, err
+	}
+	secs := int64(d / time.Second)
+	return &secs, nil
+}
+
+// newParquetResult flattens the given result into a Parquet row.
+func newParquetResult(r *Result) (ParquetResult, error) {
+	var err error
+	pr := ParquetResult{
+		Id:             r.RId,
+		Email:          r.Email,
+		FirstName:      r.FirstName,
+		LastName:       r.LastName,
+		Position:       r.Position,
+		Status:         r.Status,
+		Reported:       r.Reported,
+		IP:             r.IP,
+		Latitude:       r.Latitude,
+		Longitude:      r.Longitude,
+		Country:        r.Country,
+		CountryName:    r.CountryName,
+		City:           r.City,
+		ReverseDNS:     r.ReverseDNS,
+		ProviderType:   r.ProviderType,
+		AcceptLanguage: r.AcceptLanguage,
+		SendDate:       r.SendDate.UTC(),
+		ModifiedDate:   r.ModifiedDate.UTC(),
+	}
+	pr.TimeToOpen, err = r.parquetTimeTo(EVENT_OPENED)
+	if err != nil {
+		return pr, err
+	}
+	pr.TimeToClick, err = r.parquetTimeTo(EVENT_CLICKED)
+	if err != nil {
+		return pr, err
+	}
+	pr.TimeToSubmit, err = r.parquetTimeTo(EVENT_DATA_SUBMIT)
+	return pr, err
+}
+
+// parquetAttributeColumns returns the Parquet column used for each attribute
+// of the results in the campaign specified by the given id and user_id.
+// Attributes whose names clash with one of the ParquetResult columns are
+// prefixed with "attribute_".
+func parquetAttributeColumns(cid int64, uid int64) (map[string]string, error) {
+	columns := make(map[string]string)
+	reserved := ParquetResult{}.columns()
+	err := forEachResultBatch(cid, uid, func(rs []Result) error {
+		for i := range rs {
+			attrs, err := rs[i].Attributes()
+			if err != nil {
+				return err
+			}
+			for name := range attrs {
+				column := name
+				if _, ok := reserved[name]; ok {
+					column = "attribute_" + name
+				}
+				columns[name] = column
+			}
+		}
+		return nil
+	})
+	return columns, err
+}
+
+// ExportResultsParquet writes the results in the campaign specified by the
+// given id and user_id to w as a Parquet file. Each of the targets' custom
+// attributes is flattened into its own optional string column, which is null
+// for results without that attribute. Results are loaded and written in
+// batches, so that large campaigns aren't held in memory all at once.
+func ExportResultsParquet(w io.Writer, cid int64, uid int64) error {
+	attrColumns, err := parquetAttributeColumns(cid, uid)
+	if err != nil {
+		return err
+	}
+	group := parquet.Group{}
+	for _, f := range parquet.SchemaOf(ParquetResult{}).Fields() {
+		group[f.Name()] = f
+	}
+	for _, column := range attrColumns {
+		group[column] = parquet.Optional(parquet.String())
+	}
+	pw := parquet.NewWriter(w, parquet.NewSchema("ParquetResult", group))
+	err = forEachResultBatch(cid, uid, func(rs []Result) error {
+		for i := range rs {
+			pr, err := newParquetResult(&rs[i])
+			if err != nil {
+				return err
+			}
+			attrs, err := rs[i].Attributes()
+			if err != nil {
+				return err
+			}
+			row := pr.columns()
+			for name, column := range attrColumns {
+				if v, ok := attrs[name]; ok {
+					row[column] = v
+				} else {
+					row[column] = nil
+				}
+			}
+			err = pw.Write(row)
+			if err != nil {
+				return err
+			}
+		}
+		return pw.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	return pw.Close()
+}
+
+// attributeExportColumns are the result columns included before the target's
+// attributes when exporting results with attributes.
+var attributeExportColumns = []string{"id", "email", "first_name", "last_name", "position", "status", "reported", "send_date"}
+
+// ExportResultsWithAttributes writes the results in the campaign specified by
+// the given id and user_id to w as a CSV file, including the custom attributes
+// each target had when the campaign was launched. The attribute columns are
+// the sorted union of every result's attribute names, and are left empty for
+// results without that attribute. Attributes whose names clash with one of
+// the result columns are prefixed with "attribute_".
+func ExportResultsWithAttributes(w io.Writer, cid int64, uid int64) error {
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return err
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Id < rs[j].Id })
+	attrs := make([]map[string]string, len(rs))
+	names := make(map[string]bool)
+	for i := range rs {
+		attrs[i], err = rs[i].Attributes()
+		if err != nil {
+			return err
+		}
+		for name := range attrs[i] {
+			names[name] = true
+		}
+	}
+	attrNames := make([]string, 0, len(names))
+	for name := range names {
+		attrNames = append(attrNames, name)
+	}
+	sort.Strings(attrNames)
+
+	header := append([]string{}, attributeExportColumns...)
+	for _, name := range attrNames {
+		if _, ok := exportColumns[name]; ok {
+			name = "attribute_" + name
+		}
+		header = append(header, name)
+	}
+	cw := csv.NewWriter(w)
+	err = cw.Write(header)
+	if err != nil {
+		return err
+	}
+	for i := range rs {
+		record := make([]string, 0, len(header))
+		for _, c := range attributeExportColumns {
+			v, err := exportColumns[c](&rs[i])
+			if err != nil {
+				return err
+			}
+			record = append(record, v)
+		}
+		for _, name := range attrNames {
+			record = append(record, attrs[i][name])
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// resultExportColumns are the columns written when exporting a campaign's
+// results.
+var resultExportColumns = []string{
+	"email", "first_name", "last_name", "position", "status", "ip", "country",
+	"country_name", "city", "latitude", "longitude", "modified_date", "tags",
+	"notes",
+}
+
+// latestEvent returns the most recent event recorded for the result, and
+// whether or not one was found.
+func (r *Result) latestEvent() (Event, bool, error) {
+	e := Event{}
+	err := db.Where("campaign_id=? and email=?", r.CampaignId, r.Email).
+		Order("time desc, id desc").First(&e).Error
+	if err == gorm.ErrRecordNotFound {
+		return e, false, nil
+	}
+	return e, err == nil, err
+}
+
+// ExportResults streams the results in the campaign specified by the given id
+// and user_id to w as a CSV file. If withEvents is true, the message, time
+// and details of each result's latest event are included. Results are loaded
+// and written in batches, so that large campaigns aren't held in memory all
+// at once.
+func ExportResults(w io.Writer, cid int64, uid int64, withEvents bool) error {
+	header := append([]string{}, resultExportColumns...)
+	if withEvents {
+		header = append(header, "last_event", "last_event_time", "last_event_details")
+	}
+	cw := csv.NewWriter(w)
+	err := cw.Write(header)
+	if err != nil {
+		return err
+	}
+	err = forEachResultBatch(cid, uid, func(rs []Result) error {
+		for i := range rs {
+			record := make([]string, 0, len(header))
+			for _, c := range resultExportColumns {
+				v, err := exportColumns[c](&rs[i])
+				if err != nil {
+					return err
+				}
+				record = append(record, v)
+			}
+			if withEvents {
+				e, ok, err := rs[i].latestEvent()
+				if err != nil {
+					return err
+				}
+				if ok {
+					record = append(record, e.Message, e.Time.Format(time.RFC3339), e.Details)
+				} else {
+					record = append(record, "", "", "")
+				}
+			}
+			err := cw.Write(record)
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
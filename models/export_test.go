@@ -0,0 +1,309 @@
+package models
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestExportForMailMerge(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, []Target{
+		{Email: "clicker@example.com", FirstName: "Click", LastName: "Er"},
+		{Email: "ignorer@example.com", FirstName: "Ignore", LastName: "Er"},
+	})
+	for _, r := range campaign.Results {
+		ch.Assert(r.HandleEmailSent(), check.Equals, nil)
+		if r.Email == "clicker@example.com" {
+			ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+		}
+	}
+	// Pin the event times so the time to click is predictable
+	sent := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	err := db.Model(&Event{}).Where("campaign_id=? and message=?", campaign.Id, EVENT_SENT).
+		Update("time", sent).Error
+	ch.Assert(err, check.Equals, nil)
+	err = db.Model(&Event{}).Where("campaign_id=? and message=?", campaign.Id, EVENT_CLICKED).
+		Update("time", sent.Add(90*time.Second)).Error
+	ch.Assert(err, check.Equals, nil)
+
+	buff := &bytes.Buffer{}
+	f := ResultFilter{IncludeStatuses: []string{EVENT_CLICKED}}
+	columns := []string{"email", "first_name", "status", "time_to_click", "time_to_open"}
+	err = ExportForMailMerge(buff, campaign.Id, campaign.UserId, f, columns)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(buff.String(), check.Equals,
+		"email,first_name,status,time_to_click,time_to_open\n"+
+			"clicker@example.com,Click,Clicked Link,90,\n")
+
+	// The default columns are used when none are given
+	buff.Reset()
+	f = ResultFilter{IncludeStatuses: []string{EVENT_SENT}}
+	err = ExportForMailMerge(buff, campaign.Id, campaign.UserId, f, nil)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(buff.String(), check.Equals,
+		"email,first_name,last_name,position\n"+
+			"ignorer@example.com,Ignore,Er,\n")
+
+	buff.Reset()
+	err = ExportForMailMerge(buff, campaign.Id, campaign.UserId, f, []string{"email", "password"})
+	ch.Assert(err, check.Equals, ErrInvalidExportColumn)
+	ch.Assert(buff.Len(), check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestExportAuditReport(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	first, second := campaign.Results[0], campaign.Results[1]
+	from := EventDetails{Browser: map[string]string{"address": "192.0.2.1"}}
+	ch.Assert(first.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(first.HandleEmailOpened(from), check.Equals, nil)
+	ch.Assert(first.HandleClickedLink(from), check.Equals, nil)
+	ch.Assert(second.HandleEmailSent(), check.Equals, nil)
+
+	buff := &bytes.Buffer{}
+	ch.Assert(ExportAuditReport(buff, campaign.Id, campaign.UserId), check.Equals, nil)
+	ar := AuditReport{}
+	ch.Assert(json.Unmarshal(buff.Bytes(), &ar), check.Equals, nil)
+	ch.Assert(ar.Verify(), check.Equals, nil)
+	ch.Assert(ar.CampaignId, check.Equals, campaign.Id)
+	ch.Assert(len(ar.Events), check.Equals, 1)
+	ch.Assert(ar.Events[0].Message, check.Equals, "Campaign Created")
+
+	ch.Assert(len(ar.Results), check.Equals, 2)
+	ch.Assert(ar.Results[0].Id, check.Equals, first.RId)
+	messages := []string{}
+	for _, e := range ar.Results[0].Events {
+		messages = append(messages, e.Message)
+	}
+	ch.Assert(messages, check.DeepEquals, []string{EVENT_SENT, EVENT_OPENED, EVENT_CLICKED})
+	ch.Assert(ar.Results[0].Events[0].IP, check.Equals, "")
+	ch.Assert(ar.Results[0].Events[1].IP, check.Equals, "192.0.2.1")
+	ch.Assert(ar.Results[1].Id, check.Equals, second.RId)
+	ch.Assert(len(ar.Results[1].Events), check.Equals, 1)
+
+	// Tampering with, reordering, or removing entries breaks the chain
+	tampered := ar
+	tampered.Results = append([]AuditResult{}, ar.Results...)
+	tampered.Results[0].Events = append([]AuditEntry{}, ar.Results[0].Events...)
+	tampered.Results[0].Events[2].Message = EVENT_OPENED
+	ch.Assert(tampered.Verify(), check.Equals, ErrAuditChainBroken)
+	tampered.Results[0].Events = []AuditEntry{ar.Results[0].Events[1], ar.Results[0].Events[0], ar.Results[0].Events[2]}
+	ch.Assert(tampered.Verify(), check.Equals, ErrAuditChainBroken)
+	tampered.Results[0].Events = ar.Results[0].Events[1:]
+	ch.Assert(tampered.Verify(), check.Equals, ErrAuditChainBroken)
+}
+
+func (s *ModelsSuite) TestExportForLMS(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, []Target{
+		{Email: "submitter@example.com", FirstName: "Sub", LastName: "Mitter"},
+		{Email: "clicker@example.com", FirstName: "Click", LastName: "Er"},
+		{Email: "opener@example.com", FirstName: "Open", LastName: "Er"},
+	})
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+
+	buff := &bytes.Buffer{}
+	err := ExportForLMS(buff, campaign.Id, campaign.UserId, []string{EVENT_CLICKED, EVENT_DATA_SUBMIT})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(buff.String(), check.Equals,
+		"email,first_name,last_name,needs_training\n"+
+			"submitter@example.com,Sub,Mitter,true\n"+
+			"clicker@example.com,Click,Er,true\n"+
+			"opener@example.com,Open,Er,false\n")
+
+	buff.Reset()
+	err = ExportForLMS(buff, campaign.Id, campaign.UserId, nil)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(strings.Count(buff.String(), ",false\n"), check.Equals, 3)
+}
+
+func (s *ModelsSuite) TestExportResultsParquet(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(3))
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleEmailSent(), check.Equals, nil)
+	ch.Assert(rs[0].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	rs[1].Country = "NZ"
+	rs[1].CountryName = "New Zealand"
+	rs[1].City = "Wellington"
+	ch.Assert(ResultStorage.Save(&rs[1]), check.Equals, nil)
+
+	buff := &bytes.Buffer{}
+	err := ExportResultsParquet(buff, campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+
+	pr := parquet.NewReader(bytes.NewReader(buff.Bytes()))
+	defer pr.Close()
+	ch.Assert(pr.NumRows(), check.Equals, int64(len(rs)))
+	rows := []ParquetResult{}
+	for {
+		row := ParquetResult{}
+		err := pr.Read(&row)
+		if err == io.EOF {
+			break
+		}
+		ch.Assert(err, check.Equals, nil)
+		rows = append(rows, row)
+	}
+	ch.Assert(len(rows), check.Equals, len(rs))
+	for i, row := range rows {
+		ch.Assert(row.Id, check.Equals, rs[i].RId)
+		ch.Assert(row.Email, check.Equals, rs[i].Email)
+	}
+	ch.Assert(rows[0].Status, check.Equals, EVENT_CLICKED)
+	ch.Assert(rows[0].TimeToOpen, check.NotNil)
+	ch.Assert(rows[0].TimeToClick, check.NotNil)
+	ch.Assert(rows[0].TimeToSubmit, check.IsNil)
+	ch.Assert(rows[1].Country, check.Equals, "NZ")
+	ch.Assert(rows[1].CountryName, check.Equals, "New Zealand")
+	ch.Assert(rows[1].City, check.Equals, "Wellington")
+	ch.Assert(rows[1].TimeToOpen, check.IsNil)
+}
+
+func (s *ModelsSuite) TestExportResultsParquetAttributes(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, []Target{
+		{Email: "sales@example.com", FirstName: "Sal", LastName: "Es",
+			Attributes: map[string]string{"department": "Sales", "manager": "Boss"}},
+		{Email: "it@example.com", FirstName: "Ian", LastName: "Tee",
+			Attributes: map[string]string{"department": "IT", "status": "contractor"}},
+	})
+
+	buff := &bytes.Buffer{}
+	err := ExportResultsParquet(buff, campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+
+	f, err := parquet.OpenFile(bytes.NewReader(buff.Bytes()), int64(buff.Len()))
+	ch.Assert(err, check.Equals, nil)
+	columns := make(map[string]bool)
+	for _, c := range f.Schema().Columns() {
+		columns[strings.Join(c, ".")] = true
+	}
+	ch.Assert(columns["department"], check.Equals, true)
+	ch.Assert(columns["manager"], check.Equals, true)
+	// Attributes which clash with a column are prefixed
+	ch.Assert(columns["attribute_status"], check.Equals, true)
+
+	pr := parquet.NewReader(bytes.NewReader(buff.Bytes()))
+	defer pr.Close()
+	rows := []map[string]interface{}{}
+	for {
+		row := map[string]interface{}{}
+		err := pr.Read(&row)
+		if err == io.EOF {
+			break
+		}
+		ch.Assert(err, check.Equals, nil)
+		rows = append(rows, row)
+	}
+	ch.Assert(len(rows), check.Equals, 2)
+	ch.Assert(rows[0]["email"], check.Equals, "sales@example.com")
+	ch.Assert(rows[0]["department"], check.Equals, "Sales")
+	ch.Assert(rows[0]["manager"], check.Equals, "Boss")
+	ch.Assert(rows[0]["attribute_status"], check.IsNil)
+	ch.Assert(rows[1]["department"], check.Equals, "IT")
+	ch.Assert(rows[1]["manager"], check.IsNil)
+	ch.Assert(rows[1]["attribute_status"], check.Equals, "contractor")
+	ch.Assert(rows[1]["status"], check.Equals, STATUS_SENDING)
+}
+
+func (s *ModelsSuite) TestExportResultsWithAttributes(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, []Target{
+		{Email: "sales@example.com", FirstName: "Sal", LastName: "Es",
+			Attributes: map[string]string{"department": "Sales", "manager": "Boss"}},
+		{Email: "it@example.com", FirstName: "Ian", LastName: "Tee",
+			Attributes: map[string]string{"department": "IT", "office": "HQ", "status": "contractor"}},
+		{Email: "plain@example.com", FirstName: "Plain", LastName: "Target"},
+	})
+	// The attributes are kept with the group's targets
+	g, err := GetGroup(campaign.Groups[0].Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	for _, t := range g.Targets {
+		if t.Email == "sales@example.com" {
+			ch.Assert(t.Attributes, check.DeepEquals, map[string]string{"department": "Sales", "manager": "Boss"})
+		}
+	}
+
+	buff := &bytes.Buffer{}
+	err = ExportResultsWithAttributes(buff, campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	rows, err := csv.NewReader(buff).ReadAll()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(rows[0], check.DeepEquals, []string{
+		"id", "email", "first_name", "last_name", "position", "status", "reported", "send_date",
+		"department", "manager", "office", "attribute_status",
+	})
+	ch.Assert(len(rows), check.Equals, 4)
+	byEmail := make(map[string][]string)
+	for _, row := range rows[1:] {
+		byEmail[row[1]] = row
+	}
+	ch.Assert(byEmail["sales@example.com"][8:], check.DeepEquals, []string{"Sales", "Boss", "", ""})
+	ch.Assert(byEmail["it@example.com"][8:], check.DeepEquals, []string{"IT", "", "HQ", "contractor"})
+	ch.Assert(byEmail["plain@example.com"][8:], check.DeepEquals, []string{"", "", "", ""})
+
+	// Changing the group afterwards doesn't change the campaign's attributes
+	for i := range g.Targets {
+		if g.Targets[i].Email == "sales@example.com" {
+			g.Targets[i].Attributes = map[string]string{"department": "Marketing"}
+		}
+	}
+	ch.Assert(PutGroup(&g), check.Equals, nil)
+	buff.Reset()
+	ch.Assert(ExportResultsWithAttributes(buff, campaign.Id, campaign.UserId), check.Equals, nil)
+	ch.Assert(strings.Contains(buff.String(), "Sales,Boss"), check.Equals, true)
+}
+
+func (s *ModelsSuite) TestExportResults(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, []Target{
+		{Email: "jane@example.com", FirstName: "Jane", LastName: "Doe, Jr.", Position: `Director, "Finance"`},
+		{Email: "john@example.com", FirstName: "John", LastName: "Smith", Position: "Engineer"},
+	})
+	jane := campaign.Results[0]
+	jane.IP = "128.101.101.101"
+	jane.Country = "US"
+	jane.CountryName = "United States"
+	jane.City = "Minneapolis"
+	jane.Latitude = 44.9759
+	jane.Longitude = -93.2166
+	ch.Assert(db.Save(&jane).Error, check.Equals, nil)
+	ch.Assert(jane.HandleClickedLink(EventDetails{Browser: map[string]string{"user-agent": "test, agent"}}), check.Equals, nil)
+
+	var buf bytes.Buffer
+	ch.Assert(ExportResults(&buf, campaign.Id, campaign.UserId, false), check.Equals, nil)
+	records, err := csv.NewReader(&buf).ReadAll()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(records), check.Equals, 3)
+	ch.Assert(records[0], check.DeepEquals, resultExportColumns)
+	// Names and positions containing commas and quotes survive the round trip
+	ch.Assert(records[1][:9], check.DeepEquals, []string{
+		"jane@example.com", "Jane", "Doe, Jr.", `Director, "Finance"`, EVENT_CLICKED, "128.101.101.101", "US",
+		"United States", "Minneapolis",
+	})
+	ch.Assert(records[1][9], check.Equals, "44.9759")
+	ch.Assert(records[1][10], check.Equals, "-93.2166")
+	modified, err := time.Parse(time.RFC3339, records[1][11])
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(modified.IsZero(), check.Equals, false)
+
+	buf.Reset()
+	ch.Assert(ExportResults(&buf, campaign.Id, campaign.UserId, true), check.Equals, nil)
+	records, err = csv.NewReader(&buf).ReadAll()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(records), check.Equals, 3)
+	n := len(resultExportColumns)
+	ch.Assert(records[0][n:], check.DeepEquals, []string{"last_event", "last_event_time", "last_event_details"})
+	ch.Assert(records[1][n], check.Equals, EVENT_CLICKED)
+	d := EventDetails{}
+	ch.Assert(json.Unmarshal([]byte(records[1][n+2]), &d), check.Equals, nil)
+	ch.Assert(d.Browser["user-agent"], check.Equals, "test, agent")
+	// Results without any events have empty event columns
+	ch.Assert(records[2][0], check.Equals, "john@example.com")
+	ch.Assert(records[2][n:], check.DeepEquals, []string{"", "", ""})
+}
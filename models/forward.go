@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/gophish/gophish/forwarder"
+)
+
+// EventForwarder streams each event recorded for a result to a SIEM, if
+// event forwarding is configured.
+var EventForwarder *forwarder.Forwarder
+
+// forwardEvent queues the event recorded for the result to be forwarded.
+// Forwarding never blocks or fails the event itself.
+func forwardEvent(c *Campaign, r *Result, e *Event) {
+	if EventForwarder == nil {
+		return
+	}
+	fe := forwarder.Event{
+		CampaignId:   c.Id,
+		CampaignName: c.Name,
+		RId:          r.RId,
+		Email:        e.Email,
+		Message:      e.Message,
+		Time:         e.Time,
+	}
+	if e.Details != "" {
+		fe.Details = json.RawMessage(e.Details)
+	}
+	EventForwarder.Forward(fe)
+}
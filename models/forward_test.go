@@ -0,0 +1,54 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	"github.com/gophish/gophish/forwarder"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestForwardEvents(ch *check.C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ch.Assert(err, check.Equals, nil)
+	defer l.Close()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	f, err := forwarder.New(config.EventForwarding{Network: "tcp", Address: l.Addr().String(), Format: forwarder.FormatCEF})
+	ch.Assert(err, check.Equals, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Start(ctx)
+	defer func() {
+		EventForwarder = nil
+	}()
+
+	campaign := s.createCampaign(ch)
+	EventForwarder = f
+	result := campaign.Results[0]
+	ch.Assert(result.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+
+	select {
+	case line := <-lines:
+		ch.Assert(strings.Contains(line, "|email_opened|Email Opened|"), check.Equals, true)
+		ch.Assert(strings.Contains(line, "duser="+result.Email), check.Equals, true)
+		ch.Assert(strings.Contains(line, "cs3="+result.RId), check.Equals, true)
+	case <-time.After(5 * time.Second):
+		ch.Fatal("event was not forwarded")
+	}
+}
@@ -0,0 +1,241 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIPDatabasePath is the location of the MaxMind database used to look up
+// the location of IP addresses. Any database with the GeoLite2 City schema,
+// such as the commercial GeoIP2 City database, can be used.
+var GeoIPDatabasePath = "static/db/geolite2-city.mmdb"
+
+// geoIPReader is the shared reader for the MaxMind database. It's opened the
+// first time an address is looked up and reused until CloseGeoIPDatabase is
+// called.
+var geoIPReader *maxminddb.Reader
+
+// geoIPLock guards geoIPReader
+var geoIPLock sync.RWMutex
+
+// cgnatNetwork is the shared address space used for carrier-grade NAT
+var cgnatNetwork = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP returns whether or not the given IP address is publicly routable,
+// and so can be meaningfully geolocated. Private, loopback, link-local,
+// unspecified, multicast and CGNAT addresses aren't public.
+func isPublicIP(ip net.IP) bool {
+	switch {
+	case ip.IsPrivate(), ip.IsLoopback(), ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast(),
+		ip.IsInterfaceLocalMulticast(), ip.IsMulticast(), ip.IsUnspecified():
+		return false
+	case cgnatNetwork.Contains(ip):
+		return false
+	}
+	return true
+}
+
+// ErrGeoNotFound is thrown when an IP address isn't in the MaxMind database,
+// so its location is unknown
+var ErrGeoNotFound = errors.New("IP address not found in the GeoIP database")
+
+// lookupGeoIP looks up the given IP address in the MaxMind database, opening
+// the database if it hasn't been opened yet. If the address isn't in the
+// database, ErrGeoNotFound is returned.
+func lookupGeoIP(ip net.IP, city *mmCity) error {
+	geoIPLock.RLock()
+	if geoIPReader == nil {
+		geoIPLock.RUnlock()
+		err := openGeoIPDatabase()
+		if err != nil {
+			return err
+		}
+		geoIPLock.RLock()
+	}
+	defer geoIPLock.RUnlock()
+	// The database may have been closed while we waited for the lock
+	if geoIPReader == nil {
+		return nil
+	}
+	_, ok, err := geoIPReader.LookupNetwork(ip, city)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrGeoNotFound
+	}
+	return nil
+}
+
+// configureGeoIP uses the MaxMind database at the given path, keeping the
+// default path if it's empty. An error is returned if the database doesn't
+// exist, though lookups are still attempted in case it's added later.
+func configureGeoIP(path string) error {
+	if path != "" {
+		GeoIPDatabasePath = path
+	}
+	_, err := os.Stat(GeoIPDatabasePath)
+	return err
+}
+
+// openGeoIPDatabase opens the MaxMind database at GeoIPDatabasePath if it
+// isn't already open.
+func openGeoIPDatabase() error {
+	geoIPLock.Lock()
+	defer geoIPLock.Unlock()
+	if geoIPReader != nil {
+		return nil
+	}
+	mmdb, err := maxminddb.Open(GeoIPDatabasePath)
+	if err != nil {
+		return err
+	}
+	geoIPReader = mmdb
+	return nil
+}
+
+// ReloadGeoIPDatabase opens the MaxMind database at GeoIPDatabasePath and
+// swaps it in for the shared reader, so that an updated database can be used
+// without restarting Gophish. If the database can't be opened, the current
+// reader is kept.
+func ReloadGeoIPDatabase() error {
+	mmdb, err := maxminddb.Open(GeoIPDatabasePath)
+	if err != nil {
+		return err
+	}
+	geoIPLock.Lock()
+	old := geoIPReader
+	geoIPReader = mmdb
+	geoIPLock.Unlock()
+	if old == nil {
+		return nil
+	}
+	return old.Close()
+}
+
+// WatchGeoIPDatabase reloads the MaxMind database every interval until the
+// given context is cancelled. Failed reloads are logged, and the current
+// database is kept.
+func WatchGeoIPDatabase(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := ReloadGeoIPDatabase()
+			if err != nil {
+				log.Warnf("unable to reload the GeoIP database: %s", err)
+			}
+		}
+	}
+}
+
+// CloseGeoIPDatabase closes the shared MaxMind database reader, if it's open.
+// The database is reopened the next time an address is looked up.
+func CloseGeoIPDatabase() error {
+	geoIPLock.Lock()
+	defer geoIPLock.Unlock()
+	if geoIPReader == nil {
+		return nil
+	}
+	err := geoIPReader.Close()
+	geoIPReader = nil
+	return err
+}
+
+// setLocation sets the result's location from the given MaxMind record
+func (r *Result) setLocation(city mmCity) {
+	r.Latitude = city.GeoPoint.Latitude
+	r.Longitude = city.GeoPoint.Longitude
+	r.Country = city.Country.ISOCode
+	r.CountryName = city.Country.Names["en"]
+	r.City = city.City.Names["en"]
+}
+
+// BackfillGeo looks up the location of every result in the campaign specified
+// by the given id which has an IP address recorded but no coordinates, such as
+// results imported from a version of Gophish which didn't geolocate them. The
+// located results are updated in a single transaction, and the number updated
+// is returned. Results with a private or unresolvable address are skipped.
+func BackfillGeo(cid int64) (int, error) {
+	c := Campaign{}
+	err := db.Where("id=?", cid).Find(&c).Error
+	if err != nil {
+		return 0, err
+	}
+	// Without the database nothing can be located, so there's no point
+	// checking each result
+	err = openGeoIPDatabase()
+	if err != nil {
+		return 0, err
+	}
+	rs, err := ResultStorage.List(cid, c.UserId)
+	if err != nil {
+		return 0, err
+	}
+	located := []*Result{}
+	for i := range rs {
+		r := &rs[i]
+		if r.IP == "" || r.Latitude != 0 || r.Longitude != 0 {
+			continue
+		}
+		ip := net.ParseIP(r.IP)
+		if ip == nil || !isPublicIP(ip) {
+			continue
+		}
+		var city mmCity
+		err := lookupGeoIP(ip, &city)
+		if err == ErrGeoNotFound {
+			continue
+		}
+		if err != nil {
+			log.Warnf("unable to geolocate %s: %s", r.IP, err)
+			continue
+		}
+		r.setLocation(city)
+		located = append(located, r)
+	}
+	err = updateLocations(located)
+	if err != nil {
+		return 0, err
+	}
+	return len(located), nil
+}
+
+// updateLocations stores the location of each of the given results in a
+// single transaction. Only the location columns are updated, so that events
+// recorded while the results were being located aren't overwritten, and held
+// saves are written first so that they can't overwrite the locations later.
+func updateLocations(rs []*Result) error {
+	if len(rs) == 0 {
+		return nil
+	}
+	err := FlushResults()
+	if err != nil {
+		return err
+	}
+	tx := db.Begin()
+	for _, r := range rs {
+		err := tx.Model(&Result{}).Where("id = ?", r.Id).UpdateColumns(map[string]interface{}{
+			"latitude":     r.Latitude,
+			"longitude":    r.Longitude,
+			"country":      r.Country,
+			"country_name": r.CountryName,
+			"city":         r.City,
+		}).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,257 @@
+package models
+
+import (
+	"net"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestUpdateGeoReusesReader(ch *check.C) {
+	defer func(path string) { GeoIPDatabasePath = path }(GeoIPDatabasePath)
+	defer CloseGeoIPDatabase()
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+
+	// A missing database is reported as an error rather than exiting
+	GeoIPDatabasePath = "../static/db/missing.mmdb"
+	ch.Assert(r.UpdateGeo("8.8.8.8"), check.NotNil)
+	ch.Assert(geoIPReader, check.IsNil)
+
+	GeoIPDatabasePath = "../static/db/geolite2-city.mmdb"
+	ch.Assert(r.UpdateGeo("8.8.8.8"), check.Equals, nil)
+	reader := geoIPReader
+	ch.Assert(reader, check.NotNil)
+	ch.Assert(r.UpdateGeo("8.8.4.4"), check.Equals, nil)
+	ch.Assert(geoIPReader, check.Equals, reader)
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.IP, check.Equals, "8.8.4.4")
+
+	ch.Assert(CloseGeoIPDatabase(), check.Equals, nil)
+	ch.Assert(geoIPReader, check.IsNil)
+	// The database is reopened on the next lookup
+	ch.Assert(r.UpdateGeo("8.8.8.8"), check.Equals, nil)
+	ch.Assert(geoIPReader, check.NotNil)
+}
+
+func (s *ModelsSuite) TestUpdateGeoIPv6(ch *check.C) {
+	defer func(path string) { GeoIPDatabasePath = path }(GeoIPDatabasePath)
+	defer CloseGeoIPDatabase()
+	GeoIPDatabasePath = "../static/db/geolite2-city.mmdb"
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+
+	ch.Assert(r.UpdateGeo("2001:4860:4860::8888"), check.Equals, nil)
+	ch.Assert(r.IP, check.Equals, "2001:4860:4860::8888")
+	ch.Assert(r.Latitude == 0 && r.Longitude == 0, check.Equals, false)
+
+	// IPv4-mapped addresses are normalized and resolve like IPv4
+	ch.Assert(r.UpdateGeo("::ffff:8.8.8.8"), check.Equals, nil)
+	ch.Assert(r.IP, check.Equals, "8.8.8.8")
+	ch.Assert(r.Latitude == 0 && r.Longitude == 0, check.Equals, false)
+
+	// Unparseable addresses don't overwrite the stored location
+	lat, lon := r.Latitude, r.Longitude
+	ch.Assert(r.UpdateGeo("not-an-ip"), check.Equals, ErrInvalidIPAddress)
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.IP, check.Equals, "8.8.8.8")
+	ch.Assert(got.Latitude, check.Equals, lat)
+	ch.Assert(got.Longitude, check.Equals, lon)
+}
+
+func (s *ModelsSuite) TestUpdateGeoNotFound(ch *check.C) {
+	defer func(path string) { GeoIPDatabasePath = path }(GeoIPDatabasePath)
+	defer CloseGeoIPDatabase()
+	GeoIPDatabasePath = "../static/db/geolite2-city.mmdb"
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	ch.Assert(r.UpdateGeo("8.8.8.8"), check.Equals, nil)
+	lat, lon, country := r.Latitude, r.Longitude, r.Country
+
+	// Addresses outside of the database don't get a location at (0,0)
+	ch.Assert(r.UpdateGeo("192.0.2.1"), check.Equals, ErrGeoNotFound)
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.IP, check.Equals, "192.0.2.1")
+	ch.Assert(got.Latitude, check.Equals, lat)
+	ch.Assert(got.Longitude, check.Equals, lon)
+	ch.Assert(got.Country, check.Equals, country)
+}
+
+func (s *ModelsSuite) TestIsPublicIP(ch *check.C) {
+	public := []string{"8.8.8.8", "100.63.255.255", "100.128.0.1", "2001:4860:4860::8888"}
+	for _, addr := range public {
+		ch.Assert(isPublicIP(net.ParseIP(addr)), check.Equals, true, check.Commentf(addr))
+	}
+	private := []string{
+		"10.1.2.3", "172.16.0.1", "192.168.1.1", "127.0.0.1", "169.254.1.1",
+		"100.64.0.1", "100.127.255.254", "0.0.0.0", "::1", "fe80::1", "fd00::1",
+		"::ffff:192.168.1.1",
+	}
+	for _, addr := range private {
+		ch.Assert(isPublicIP(net.ParseIP(addr)), check.Equals, false, check.Commentf(addr))
+	}
+}
+
+func (s *ModelsSuite) TestUpdateGeoSkipsPrivateIP(ch *check.C) {
+	defer func(path string) { GeoIPDatabasePath = path }(GeoIPDatabasePath)
+	defer CloseGeoIPDatabase()
+	GeoIPDatabasePath = "../static/db/missing.mmdb"
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+
+	// The database isn't needed for internal addresses
+	ch.Assert(r.UpdateGeo("192.168.1.20"), check.Equals, nil)
+	ch.Assert(r.Latitude, check.Equals, 0.0)
+	ch.Assert(r.Longitude, check.Equals, 0.0)
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.IP, check.Equals, "192.168.1.20")
+	ch.Assert(got.Latitude, check.Equals, 0.0)
+	ch.Assert(got.Longitude, check.Equals, 0.0)
+	ch.Assert(r.UpdateGeo("100.64.3.4"), check.Equals, nil)
+	ch.Assert(geoIPReader, check.IsNil)
+}
+
+func (s *ModelsSuite) TestUpdateGeoNames(ch *check.C) {
+	defer func(path string) { GeoIPDatabasePath = path }(GeoIPDatabasePath)
+	defer CloseGeoIPDatabase()
+	GeoIPDatabasePath = "../static/db/geolite2-city.mmdb"
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+
+	ch.Assert(r.UpdateGeo("128.101.101.101"), check.Equals, nil)
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Country, check.Equals, "US")
+	ch.Assert(got.CountryName, check.Equals, "United States")
+	ch.Assert(got.City, check.Equals, "Minneapolis")
+
+	// Addresses without a known city clear the previous one
+	ch.Assert(r.UpdateGeo("2001:4860:4860::8888"), check.Equals, nil)
+	got, err = GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.CountryName, check.Equals, "United States")
+	ch.Assert(got.City, check.Equals, "")
+}
+
+func (s *ModelsSuite) TestConfigureGeoIP(ch *check.C) {
+	defer func(path string) { GeoIPDatabasePath = path }(GeoIPDatabasePath)
+	GeoIPDatabasePath = "../static/db/geolite2-city.mmdb"
+
+	// An empty path keeps the default
+	ch.Assert(configureGeoIP(""), check.Equals, nil)
+	ch.Assert(GeoIPDatabasePath, check.Equals, "../static/db/geolite2-city.mmdb")
+
+	// A missing database is reported, but the path is still used
+	ch.Assert(configureGeoIP("../static/db/missing.mmdb"), check.NotNil)
+	ch.Assert(GeoIPDatabasePath, check.Equals, "../static/db/missing.mmdb")
+
+	ch.Assert(configureGeoIP("../static/db/geolite2-city.mmdb"), check.Equals, nil)
+	ch.Assert(GeoIPDatabasePath, check.Equals, "../static/db/geolite2-city.mmdb")
+}
+
+func (s *ModelsSuite) TestHandleEmailReportGeolocates(ch *check.C) {
+	defer func(path string) { GeoIPDatabasePath = path }(GeoIPDatabasePath)
+	defer CloseGeoIPDatabase()
+	GeoIPDatabasePath = "../static/db/geolite2-city.mmdb"
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	ch.Assert(r.UpdateGeo("128.101.101.101"), check.Equals, nil)
+	_, ok := r.ReporterAddress()
+	ch.Assert(ok, check.Equals, false)
+
+	// The email is reported from a different network than it was opened on
+	ch.Assert(r.HandleEmailReport(EventDetails{
+		Browser: map[string]string{"address": "8.8.8.8"},
+	}), check.Equals, nil)
+	addr, ok := r.ReporterAddress()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(addr, check.Equals, "8.8.8.8")
+	es, err := r.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	d, err := es[len(es)-1].parseDetails()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(d.Country, check.Equals, "US")
+	ch.Assert(d.CountryName, check.Equals, "United States")
+	ch.Assert(d.Latitude == 0 && d.Longitude == 0, check.Equals, false)
+
+	// The result's own location and reported flag are unchanged
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Reported, check.Equals, true)
+	ch.Assert(got.IP, check.Equals, "128.101.101.101")
+	ch.Assert(got.City, check.Equals, "Minneapolis")
+
+	// Reports without an address, such as forwarded emails, aren't located
+	ch.Assert(r.HandleEmailReport(EventDetails{Channel: REPORT_CHANNEL_FORWARD}), check.Equals, nil)
+	es, err = r.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	d, err = es[len(es)-1].parseDetails()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(d.Latitude, check.Equals, 0.0)
+	addr, ok = r.ReporterAddress()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(addr, check.Equals, "8.8.8.8")
+}
+
+func (s *ModelsSuite) TestBackfillGeo(ch *check.C) {
+	defer func(path string) { GeoIPDatabasePath = path }(GeoIPDatabasePath)
+	defer CloseGeoIPDatabase()
+	campaign := s.createCampaignWithTargets(ch, generateTargets(6))
+	rs := campaign.Results
+	// Simulate results imported before they were geolocated
+	ips := []string{"8.8.8.8", "2001:4860:4860::8888", "10.0.0.1", "192.0.2.1", "not-an-ip", ""}
+	for i, ip := range ips {
+		ch.Assert(db.Model(&rs[i]).UpdateColumn("ip", ip).Error, check.Equals, nil)
+	}
+	ch.Assert(db.Model(&rs[5]).UpdateColumn("latitude", 1.5).Error, check.Equals, nil)
+
+	GeoIPDatabasePath = "../static/db/missing.mmdb"
+	n, err := BackfillGeo(campaign.Id)
+	ch.Assert(err, check.NotNil)
+	ch.Assert(n, check.Equals, 0)
+
+	GeoIPDatabasePath = "../static/db/geolite2-city.mmdb"
+	n, err = BackfillGeo(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(n, check.Equals, 2)
+	for i, ip := range ips {
+		got, err := GetResult(rs[i].RId)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(got.IP, check.Equals, ip)
+		located := got.Latitude != 0 || got.Longitude != 0
+		ch.Assert(located, check.Equals, i < 2 || i == 5)
+	}
+	got, err := GetResult(rs[0].RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Country, check.Equals, "US")
+
+	// Results which are already located aren't looked up again
+	n, err = BackfillGeo(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(n, check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestReloadGeoIPDatabase(ch *check.C) {
+	defer func(path string) { GeoIPDatabasePath = path }(GeoIPDatabasePath)
+	defer CloseGeoIPDatabase()
+	GeoIPDatabasePath = "../static/db/geolite2-city.mmdb"
+	ch.Assert(ReloadGeoIPDatabase(), check.Equals, nil)
+	reader := geoIPReader
+	ch.Assert(reader, check.NotNil)
+	ch.Assert(ReloadGeoIPDatabase(), check.Equals, nil)
+	// The previous reader is closed, so only compare the pointers
+	ch.Assert(geoIPReader != reader, check.Equals, true)
+
+	// A failed reload keeps the current database
+	reader = geoIPReader
+	GeoIPDatabasePath = "../static/db/missing.mmdb"
+	ch.Assert(ReloadGeoIPDatabase(), check.NotNil)
+	ch.Assert(geoIPReader == reader, check.Equals, true)
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	ch.Assert(r.UpdateGeo("8.8.8.8"), check.Equals, nil)
+	ch.Assert(r.Latitude == 0 && r.Longitude == 0, check.Equals, false)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/mail"
+	"strings"
 	"time"
 
 	log "github.com/gophish/gophish/logger"
@@ -46,11 +47,29 @@ type GroupTarget struct {
 // Target contains the fields needed for individual targets specified by the user
 // Groups contain 1..* Targets, but 1 Target may belong to 1..* Groups
 type Target struct {
-	Id        int64  `json:"-"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Email     string `json:"email"`
-	Position  string `json:"position"`
+	Id         int64             `json:"-"`
+	FirstName  string            `json:"first_name"`
+	LastName   string            `json:"last_name"`
+	Email      string            `json:"email"`
+	Position   string            `json:"position"`
+	Phone      string            `json:"phone"`
+	Locale     string            `json:"locale"`
+	Attributes map[string]string `json:"attributes,omitempty" sql:"-"`
+}
+
+// TargetAttribute is a custom attribute imported with a target, such as their
+// department or manager
+type TargetAttribute struct {
+	Id       int64  `json:"-"`
+	TargetId int64  `json:"-"`
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+}
+
+// Custom returns the target's custom attributes for use in templates, such
+// as in test emails. See Result.Custom.
+func (t Target) Custom() map[string]string {
+	return customFields(t.Attributes)
 }
 
 // Returns the email address to use in the "To" header of the email
@@ -86,13 +105,45 @@ func (g *Group) Validate() error {
 	return nil
 }
 
+// GroupFilter narrows and orders the groups returned from
+// GetFilteredGroups. The name is matched as a case-insensitive substring.
+type GroupFilter struct {
+	Name   string
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// groupSortColumns are the fields groups can be sorted by
+var groupSortColumns = map[string]string{
+	"id":            "id",
+	"name":          "name",
+	"modified_date": "modified_date",
+}
+
 // GetGroups returns the groups owned by the given user.
 func GetGroups(uid int64) ([]Group, error) {
+	gs, _, err := GetFilteredGroups(uid, GroupFilter{})
+	return gs, err
+}
+
+// GetFilteredGroups returns the groups owned by the given user which match
+// the filter, along with the total number of matching groups.
+func GetFilteredGroups(uid int64, f GroupFilter) ([]Group, int64, error) {
 	gs := []Group{}
-	err := db.Where("user_id=?", uid).Find(&gs).Error
+	query := db.Model(&Group{}).Where("user_id in (?)", teamUserIds(uid))
+	if f.Name != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(f.Name)) + "%"
+		query = query.Where("lower(name) like ? escape '!'", pattern)
+	}
+	query, total, err := paginate(query, f.Sort, groupSortColumns, f.Limit, f.Offset)
+	if err != nil {
+		return gs, total, err
+	}
+	err = query.Find(&gs).Error
 	if err != nil {
 		log.Error(err)
-		return gs, err
+		return gs, total, err
 	}
 	for i := range gs {
 		gs[i].Targets, err = GetTargets(gs[i].Id)
@@ -100,14 +151,14 @@ func GetGroups(uid int64) ([]Group, error) {
 			log.Error(err)
 		}
 	}
-	return gs, nil
+	return gs, total, nil
 }
 
 // GetGroupSummaries returns the summaries for the groups
 // created by the given uid.
 func GetGroupSummaries(uid int64) (GroupSummaries, error) {
 	gs := GroupSummaries{}
-	query := db.Table("groups").Where("user_id=?", uid)
+	query := db.Table("groups").Where("user_id in (?)", teamUserIds(uid))
 	err := query.Select("id, name, modified_date").Scan(&gs.Groups).Error
 	if err != nil {
 		log.Error(err)
@@ -127,7 +178,7 @@ func GetGroupSummaries(uid int64) (GroupSummaries, error) {
 // GetGroup returns the group, if it exists, specified by the given id and user_id.
 func GetGroup(id int64, uid int64) (Group, error) {
 	g := Group{}
-	err := db.Where("user_id=? and id=?", uid, id).Find(&g).Error
+	err := db.Where("user_id in (?) and id=?", teamUserIds(uid), id).Find(&g).Error
 	if err != nil {
 		log.Error(err)
 		return g, err
@@ -142,7 +193,7 @@ func GetGroup(id int64, uid int64) (Group, error) {
 // GetGroupSummary returns the summary for the requested group
 func GetGroupSummary(id int64, uid int64) (GroupSummary, error) {
 	g := GroupSummary{}
-	query := db.Table("groups").Where("user_id=? and id=?", uid, id)
+	query := db.Table("groups").Where("user_id in (?) and id=?", teamUserIds(uid), id)
 	err := query.Select("id, name, modified_date").Scan(&g).Error
 	if err != nil {
 		log.Error(err)
@@ -159,7 +210,7 @@ func GetGroupSummary(id int64, uid int64) (GroupSummary, error) {
 // GetGroupByName returns the group, if it exists, specified by the given name and user_id.
 func GetGroupByName(n string, uid int64) (Group, error) {
 	g := Group{}
-	err := db.Where("user_id=? and name=?", uid, n).Find(&g).Error
+	err := db.Where("user_id in (?) and name=?", teamUserIds(uid), n).Find(&g).Error
 	if err != nil {
 		log.Error(err)
 		return g, err
@@ -281,6 +332,14 @@ func insertTargetIntoGroup(t Target, gid int64) error {
 		}).Error("Error adding target")
 		return err
 	}
+	err = saveTargetAttributes(trans, t)
+	if err != nil {
+		trans.Rollback()
+		log.WithFields(logrus.Fields{
+			"email": t.Email,
+		}).Error("Error saving target attributes")
+		return err
+	}
 	err = trans.Where("group_id=? and target_id=?", gid, t.Id).Find(&GroupTarget{}).Error
 	if err == gorm.ErrRecordNotFound {
 		err = trans.Save(&GroupTarget{GroupId: gid, TargetId: t.Id}).Error
@@ -309,8 +368,13 @@ func UpdateTarget(target Target) error {
 		"first_name": target.FirstName,
 		"last_name":  target.LastName,
 		"position":   target.Position,
+		"phone":      target.Phone,
+		"locale":     target.Locale,
 	}
 	err := db.Model(&target).Where("id = ?", target.Id).Updates(targetInfo).Error
+	if err == nil {
+		err = saveTargetAttributes(db, target)
+	}
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"email": target.Email,
@@ -319,9 +383,51 @@ func UpdateTarget(target Target) error {
 	return err
 }
 
+// saveTargetAttributes replaces the stored attributes of the given target with
+// its current attributes. If the target has no attributes set, the stored
+// attributes are left unchanged.
+func saveTargetAttributes(tx *gorm.DB, t Target) error {
+	if t.Attributes == nil {
+		return nil
+	}
+	err := tx.Where("target_id=?", t.Id).Delete(&TargetAttribute{}).Error
+	if err != nil {
+		return err
+	}
+	for name, value := range t.Attributes {
+		err = tx.Save(&TargetAttribute{TargetId: t.Id, Name: name, Value: value}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetTargets performs a many-to-many select to get all the Targets for a Group
 func GetTargets(gid int64) ([]Target, error) {
 	ts := []Target{}
-	err := db.Table("targets").Select("targets.id, targets.email, targets.first_name, targets.last_name, targets.position").Joins("left join group_targets gt ON targets.id = gt.target_id").Where("gt.group_id=?", gid).Scan(&ts).Error
-	return ts, err
+	err := db.Table("targets").Select("targets.id, targets.email, targets.first_name, targets.last_name, targets.position, targets.phone, targets.locale").Joins("left join group_targets gt ON targets.id = gt.target_id").Where("gt.group_id=?", gid).Scan(&ts).Error
+	if err != nil || len(ts) == 0 {
+		return ts, err
+	}
+	// Load the custom attributes of each target
+	ids := make([]int64, len(ts))
+	idx := make(map[int64]int)
+	for i, t := range ts {
+		ids[i] = t.Id
+		idx[t.Id] = i
+	}
+	tas := []TargetAttribute{}
+	err = db.Where("target_id in (?)", ids).Find(&tas).Error
+	if err != nil {
+		return ts, err
+	}
+	for _, ta := range tas {
+		t := &ts[idx[ta.TargetId]]
+		if t.Attributes == nil {
+			t.Attributes = make(map[string]string)
+		}
+		t.Attributes[ta.Name] = ta.Value
+	}
+	return ts, nil
 }
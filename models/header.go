@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// ErrHeaderNameNotSpecified is thrown when a custom header has no name
+var ErrHeaderNameNotSpecified = errors.New("Header name not specified")
+
+// ErrInvalidHeaderName is thrown when a custom header's name contains
+// characters which can't be used in a header field name
+var ErrInvalidHeaderName = errors.New("Invalid header name")
+
+// ErrInvalidHeaderValue is thrown when a custom header's value contains a
+// line break
+var ErrInvalidHeaderValue = errors.New("Header values can't contain line breaks")
+
+// ErrReservedHeader is thrown when a custom header would replace one of the
+// headers gophish sets itself for every email
+var ErrReservedHeader = errors.New("Header is set by gophish and can't be overridden")
+
+// reservedHeaders are the headers written for every email, which custom
+// headers can't replace
+var reservedHeaders = map[string]bool{
+	"To":                        true,
+	"Subject":                   true,
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+}
+
+// CampaignHeader is a custom header added to every email sent by a campaign,
+// such as one identifying the simulation to a mail gateway. The key and value
+// are templates rendered with the same context as the email's body, so
+// "X-Ticket: {{.RId}}" sends each recipient's result id. Campaign headers
+// replace the sending profile's headers with the same name.
+type CampaignHeader struct {
+	Id         int64  `json:"-"`
+	CampaignId int64  `json:"-"`
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+}
+
+// validHeaderName returns whether the name only contains the printable
+// characters allowed in a header field name
+func validHeaderName(name string) bool {
+	for _, c := range []byte(name) {
+		if c < 33 || c > 126 || c == ':' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// headerValue replaces the line breaks in a rendered header value with
+// spaces, so that a target's attributes can't add headers of their own
+func headerValue(value string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(value)
+}
+
+// validateHeader checks that the custom header's key and value are valid
+// templates, and that the header they render is one gophish can send.
+func validateHeader(key string, value string) error {
+	if strings.TrimSpace(key) == "" {
+		return ErrHeaderNameNotSpecified
+	}
+	td := exampleTemplateContext()
+	name, err := buildTemplate(key, td)
+	if err != nil {
+		return fmt.Errorf("%s: %s", key, err)
+	}
+	if !validHeaderName(name) {
+		return fmt.Errorf("%s: %s", ErrInvalidHeaderName, key)
+	}
+	if reservedHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+		return fmt.Errorf("%s: %s", ErrReservedHeader, key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("%s: %s", ErrInvalidHeaderValue, key)
+	}
+	_, err = buildTemplate(value, td)
+	if err != nil {
+		return fmt.Errorf("%s: %s", key, err)
+	}
+	return nil
+}
+
+// validateHeaders checks each of the campaign's custom headers
+func (c *Campaign) validateHeaders() error {
+	for _, h := range c.Headers {
+		err := validateHeader(h.Key, h.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getHeaders retrieves the campaign's custom headers from the database
+func (c *Campaign) getHeaders() error {
+	c.Headers = []CampaignHeader{}
+	return db.Where("campaign_id=?", c.Id).Order("id asc").Find(&c.Headers).Error
+}
+
+// addHeader renders the custom header and adds it to the email, replacing any
+// header added before it with the same name. Headers which render to an
+// invalid name are skipped, and recorded as a problem with the email.
+func (e *renderedEmail) addHeader(key string, value string) {
+	name := e.build(key)
+	if !validHeaderName(name) {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q", ErrInvalidHeaderName, name))
+		return
+	}
+	h := [2]string{name, headerValue(e.build(value))}
+	canonical := textproto.CanonicalMIMEHeaderKey(name)
+	for i := range e.headers {
+		if textproto.CanonicalMIMEHeaderKey(e.headers[i][0]) == canonical {
+			e.headers[i] = h
+			return
+		}
+	}
+	e.headers = append(e.headers, h)
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/gophish/gomail"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestValidateHeader(ch *check.C) {
+	ch.Assert(validateHeader("X-Ticket", "{{.RId}}"), check.Equals, nil)
+	ch.Assert(validateHeader("List-Unsubscribe", "<{{.URL}}&unsubscribe=1>"), check.Equals, nil)
+	ch.Assert(validateHeader("X-{{.FirstName}}", "yes"), check.Equals, nil)
+	ch.Assert(validateHeader(" ", "yes"), check.Equals, ErrHeaderNameNotSpecified)
+	for _, h := range [][2]string{
+		{"X Ticket", "1"},
+		{"X-Ticket:", "1"},
+		{"X-{{.Position}} Name", "1"},
+		{"subject", "Overridden"},
+		{"Content-Type", "text/plain"},
+		{"X-Ticket", "1\r\nBcc: attacker@example.com"},
+		{"X-Ticket", "{{.Missing"},
+		{"X-Ticket", "{{.NoSuchField}}"},
+	} {
+		ch.Assert(validateHeader(h[0], h[1]), check.NotNil, check.Commentf("%s: %s", h[0], h[1]))
+	}
+
+	smtp := SMTP{
+		Name:        "Test SMTP",
+		Host:        "1.1.1.1:25",
+		FromAddress: "foo@example.com",
+		UserId:      1,
+		Headers:     []Header{Header{Key: "To", Value: "someone@example.com"}},
+	}
+	ch.Assert(PostSMTP(&smtp), check.NotNil)
+}
+
+func (s *ModelsSuite) TestCampaignHeaders(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	c.SMTP.Headers = []Header{
+		Header{Key: "X-Mailer", Value: "gophish"},
+		Header{Key: "X-Simulation", Value: "profile"},
+	}
+	ch.Assert(PutSMTP(&c.SMTP), check.Equals, nil)
+	c.Headers = []CampaignHeader{
+		CampaignHeader{Key: "X-Ticket", Value: "{{.RId}}"},
+		CampaignHeader{Key: "x-simulation", Value: "campaign {{.Position}}"},
+	}
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+
+	got, err := GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(got.Headers), check.Equals, 2)
+	ch.Assert(got.Headers[0].Key, check.Equals, "X-Ticket")
+
+	// Values rendered from a target's details can't add headers of their own
+	result := c.Results[0]
+	result.Position = "Engineer\r\nBcc: attacker@example.com"
+	ch.Assert(db.Save(&result).Error, check.Equals, nil)
+	m := &MailLog{}
+	ch.Assert(db.Where("r_id=? AND campaign_id=?", result.RId, c.Id).Find(m).Error, check.Equals, nil)
+	msg := gomail.NewMessage()
+	ch.Assert(m.Generate(msg), check.Equals, nil)
+	ch.Assert(msg.GetHeader("X-Ticket"), check.DeepEquals, []string{result.RId})
+	ch.Assert(msg.GetHeader("X-Mailer"), check.DeepEquals, []string{"gophish"})
+	// The campaign's header replaces the profile's header with the same name
+	ch.Assert(msg.GetHeader("X-Simulation"), check.HasLen, 0)
+	ch.Assert(msg.GetHeader("x-simulation"), check.DeepEquals, []string{"campaign Engineer Bcc: attacker@example.com"})
+	b := &bytes.Buffer{}
+	_, err = msg.WriteTo(b)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(strings.Contains(b.String(), "\r\nBcc:"), check.Equals, false)
+
+	c.Headers = []CampaignHeader{CampaignHeader{Key: "Subject", Value: "Overridden"}}
+	ch.Assert(PostCampaign(&c, c.UserId), check.ErrorMatches, fmt.Sprintf("%s.*", ErrReservedHeader))
+
+	// Headers are kept when the campaign is copied
+	cp := Campaign{Groups: c.Groups}
+	ch.Assert(CopyCampaign(got.Id, got.UserId, &cp), check.Equals, nil)
+	ch.Assert(len(cp.Headers), check.Equals, 2)
+	ch.Assert(cp.Headers[0].CampaignId, check.Equals, cp.Id)
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+
+	log "github.com/gophish/gophish/logger"
+)
+
+// ErrTooManyLinkNames is returned when a template tags a link with more than
+// one name, such as {{.URL "invoice" "unsubscribe"}}
+var ErrTooManyLinkNames = errors.New("Links can only be tagged with a single name")
+
+// LinkClicks is the number of times a tagged link in a campaign's email was
+// clicked, and the number of recipients who clicked it. Clicks on untagged
+// links, made through {{.URL}}, are counted under an empty link name.
+// QRClicks is the number of the clicks made by scanning a QR code.
+type LinkClicks struct {
+	Link       string `json:"link"`
+	Clicks     int64  `json:"clicks"`
+	QRClicks   int64  `json:"qr_clicks"`
+	Recipients int64  `json:"recipients"`
+}
+
+// phishingURL renders the {{.URL}} and {{.QR}} template variables. Links can
+// be tagged with a name, such as {{.URL "invoice"}}, so that the click
+// records which of the links in the email the recipient followed. Each link
+// is shortened if the campaign shortens its links.
+type phishingURL struct {
+	url   *url.URL
+	qr    *qrCodes
+	short *shortLinks
+}
+
+// URL returns the recipient's phishing URL, tagged with the given link name
+func (p phishingURL) URL(link ...string) (string, error) {
+	return p.tag(false, link)
+}
+
+// QR returns an inline image of a QR code for the recipient's phishing URL,
+// tagged with the given link name. Clicks made by scanning the code are
+// recorded as coming from a QR code.
+func (p phishingURL) QR(link ...string) (string, error) {
+	u, err := p.tag(true, link)
+	if err != nil || u == "" || p.qr == nil {
+		return "", err
+	}
+	name, err := p.qr.add(u)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`<img src="cid:%s" alt="QR code"/>`, name), nil
+}
+
+// tag returns the phishing URL tagged with the link name, and marked as
+// coming from a QR code if qr is set
+func (p phishingURL) tag(qr bool, link []string) (string, error) {
+	if len(link) > 1 {
+		return "", ErrTooManyLinkNames
+	}
+	if p.url == nil {
+		return "", nil
+	}
+	name := ""
+	if len(link) == 1 {
+		name = link[0]
+	}
+	u := *p.url
+	if name != "" || qr {
+		q := u.Query()
+		if name != "" {
+			q.Set(LinkParameter, name)
+		}
+		if qr {
+			q.Set(QRParameter, "1")
+		}
+		u.RawQuery = q.Encode()
+	}
+	if p.short != nil {
+		return p.short.shorten(u.String())
+	}
+	return u.String(), nil
+}
+
+// GetLinkClicks returns the clicks on each of the links in the given
+// campaign's emails, ordered by link name
+func GetLinkClicks(cid int64) ([]LinkClicks, error) {
+	lcs := []LinkClicks{}
+	es := []Event{}
+	err := db.Where("campaign_id=? and message=?", cid, EVENT_CLICKED).Find(&es).Error
+	if err != nil {
+		log.Error(err)
+		return lcs, err
+	}
+	clicks := map[string]*LinkClicks{}
+	recipients := map[string]map[string]bool{}
+	for _, e := range es {
+		d := EventDetails{}
+		if e.Details != "" {
+			err = json.Unmarshal([]byte(e.Details), &d)
+			if err != nil {
+				log.Warn(err)
+				continue
+			}
+		}
+		lc, ok := clicks[d.Link]
+		if !ok {
+			lc = &LinkClicks{Link: d.Link}
+			clicks[d.Link] = lc
+			recipients[d.Link] = map[string]bool{}
+		}
+		lc.Clicks++
+		if d.QR {
+			lc.QRClicks++
+		}
+		if !recipients[d.Link][e.Email] {
+			recipients[d.Link][e.Email] = true
+			lc.Recipients++
+		}
+	}
+	for _, lc := range clicks {
+		lcs = append(lcs, *lc)
+	}
+	sort.Slice(lcs, func(i, j int) bool { return lcs[i].Link < lcs[j].Link })
+	return lcs, nil
+}
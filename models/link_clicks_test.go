@@ -0,0 +1,64 @@
+package models
+
+import (
+	"net/url"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestPhishingURL(ch *check.C) {
+	u, _ := url.Parse("http://example.com/?rid=1234")
+	p := phishingURL{url: u}
+	got, err := p.URL()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got, check.Equals, "http://example.com/?rid=1234")
+	got, err = p.URL("invoice")
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got, check.Equals, "http://example.com/?link=invoice&rid=1234")
+	// Tagging a link doesn't change the untagged URL
+	got, _ = p.URL()
+	ch.Assert(got, check.Equals, "http://example.com/?rid=1234")
+	_, err = p.URL("invoice", "unsubscribe")
+	ch.Assert(err, check.Equals, ErrTooManyLinkNames)
+}
+
+func (s *ModelsSuite) TestTemplateValidateLinks(ch *check.C) {
+	t := Template{Name: "Links", HTML: `<a href="{{.URL "invoice"}}">Invoice</a>`}
+	ch.Assert(t.Validate(), check.Equals, nil)
+	t.HTML = `{{.URL "invoice" "unsubscribe"}}`
+	ch.Assert(t.Validate(), check.NotNil)
+}
+
+func (s *ModelsSuite) TestGetLinkClicks(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(3))
+	clicks := []struct {
+		result int
+		link   string
+		qr     bool
+	}{
+		{0, "invoice", false},
+		{0, "invoice", true},
+		{1, "invoice", false},
+		{1, "", false},
+		{2, "unsubscribe", false},
+	}
+	for _, c := range clicks {
+		r := campaign.Results[c.result]
+		ch.Assert(r.HandleClickedLink(EventDetails{Link: c.link, QR: c.qr}), check.Equals, nil)
+	}
+	// Other events aren't counted as clicks
+	r := campaign.Results[0]
+	ch.Assert(r.HandleEmailOpened(EventDetails{Link: "invoice"}), check.Equals, nil)
+
+	lcs, err := GetLinkClicks(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(lcs, check.DeepEquals, []LinkClicks{
+		{Link: "", Clicks: 1, Recipients: 1},
+		{Link: "invoice", Clicks: 3, QRClicks: 1, Recipients: 2},
+		{Link: "unsubscribe", Clicks: 1, Recipients: 1},
+	})
+
+	cr, err := GetCampaignResults(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(cr.LinkClicks, check.DeepEquals, lcs)
+}
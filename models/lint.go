@@ -0,0 +1,379 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	// Register the image formats which embedded images are checked for
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/jinzhu/gorm"
+)
+
+// The types of problems linting a template or landing page can report
+const (
+	PROBLEM_SYNTAX             string = "syntax"
+	PROBLEM_UNDEFINED_VARIABLE string = "undefined_variable"
+	PROBLEM_RENDER             string = "render"
+	PROBLEM_MISSING_URL        string = "missing_url"
+	PROBLEM_MISSING_TRACKER    string = "missing_tracker"
+	PROBLEM_BROKEN_IMAGE       string = "broken_image"
+)
+
+// LintSampleURL is the phishing URL templates are rendered with when linting
+// them against a sample target.
+var LintSampleURL = "http://localhost"
+
+// ErrNothingToLint is thrown when a lint request has neither a template nor
+// a landing page
+var ErrNothingToLint = errors.New("No template or landing page specified")
+
+// ErrResultNotFound is thrown when a result can't be found for the user
+var ErrResultNotFound = errors.New("Result not found")
+
+// LintProblem is a single problem found with a template or landing page. The
+// field is the part of the content the problem was found in, such as
+// "subject", "html" or "page".
+type LintProblem struct {
+	Field   string `json:"field"`
+	Problem string `json:"problem"`
+	Detail  string `json:"detail"`
+}
+
+// LintReport contains a template and landing page rendered for a target,
+// along with every problem found with them.
+type LintReport struct {
+	EmailPreview
+	Problems []LintProblem `json:"problems"`
+}
+
+// Valid returns whether or not no problems were found.
+func (lr *LintReport) Valid() bool {
+	return len(lr.Problems) == 0
+}
+
+// LintRequest is a request to render a template, landing page, or both for a
+// target and check them for problems. The template and page can be given in
+// full or by name. They're rendered for the result with the given ID, if
+// any, or otherwise for a sample result made from the request's target.
+type LintRequest struct {
+	Template Template `json:"template"`
+	Page     Page     `json:"page"`
+	RId      string   `json:"result_id"`
+	URL      string   `json:"url"`
+	Target
+}
+
+// Lint renders the request's template and landing page, looking up any given
+// by name or result ID from those owned by the given user, and reports the
+// problems found with them.
+func (l *LintRequest) Lint(uid int64) (LintReport, error) {
+	lr := LintReport{Problems: []LintProblem{}}
+	templateContent := l.Template.Subject != "" || l.Template.Text != "" || l.Template.HTML != ""
+	hasTemplate := l.Template.Name != "" || templateContent
+	hasPage := l.Page.Name != "" || l.Page.HTML != ""
+	if !hasTemplate && !hasPage {
+		return lr, ErrNothingToLint
+	}
+	var err error
+	if hasTemplate && !templateContent {
+		l.Template, err = GetTemplateByName(l.Template.Name, uid)
+		if err == gorm.ErrRecordNotFound {
+			return lr, ErrTemplateNotFound
+		} else if err != nil {
+			return lr, err
+		}
+	}
+	if hasPage && l.Page.HTML == "" {
+		l.Page, err = GetPageByName(l.Page.Name, uid)
+		if err == gorm.ErrRecordNotFound {
+			return lr, ErrPageNotFound
+		} else if err != nil {
+			return lr, err
+		}
+	}
+	c, r, err := l.target(uid)
+	if err != nil {
+		return lr, err
+	}
+	if hasTemplate {
+		c.Template = l.Template
+		err = lr.lintEmail(&c, &r)
+		if err != nil {
+			return lr, err
+		}
+	}
+	if hasPage {
+		err = lr.lintPage(l.Page, &c, r)
+		if err != nil {
+			return lr, err
+		}
+	}
+	return lr, nil
+}
+
+// target returns the campaign and result the content is rendered for, which
+// are the campaign and result with the request's result ID, if it has one.
+func (l *LintRequest) target(uid int64) (Campaign, Result, error) {
+	if l.RId != "" {
+		r, err := GetResult(l.RId)
+		if err != nil {
+			return Campaign{}, r, ErrResultNotFound
+		}
+		c, err := GetCampaign(r.CampaignId, uid)
+		if err != nil {
+			return c, r, ErrResultNotFound
+		}
+		if l.URL != "" {
+			c.URL = l.URL
+		}
+		// The request's template and page are rendered in place of the
+		// result's variant
+		c.Variants = nil
+		return c, r, nil
+	}
+	rid, err := randomId()
+	if err != nil {
+		return Campaign{}, Result{}, err
+	}
+	r := Result{
+		RId:       rid,
+		UserId:    uid,
+		Email:     l.Email,
+		FirstName: l.FirstName,
+		LastName:  l.LastName,
+		Position:  l.Position,
+		Phone:     l.Phone,
+	}
+	if r.Email == "" {
+		r.Email, r.FirstName, r.LastName, r.Position = "foo@bar.com", "Foo", "Bar", "Test"
+	}
+	err = r.setAttributes(l.Attributes)
+	if err != nil {
+		return Campaign{}, r, err
+	}
+	c := Campaign{
+		UserId: uid,
+		URL:    l.URL,
+		SMTP:   SMTP{FromAddress: "John Doe <foo@bar.com>"},
+	}
+	if c.URL == "" {
+		c.URL = LintSampleURL
+	}
+	return c, r, nil
+}
+
+// problem records a problem found in the given field
+func (lr *LintReport) problem(field string, problem string, detail string) {
+	lr.Problems = append(lr.Problems, LintProblem{Field: field, Problem: problem, Detail: detail})
+}
+
+// lintEmail renders the campaign's template for the result, checking each of
+// its parts for problems and that the email links to the phishing URL and
+// includes the tracker.
+func (lr *LintReport) lintEmail(c *Campaign, r *Result) error {
+	e, err := renderEmail(c, r)
+	if err != nil {
+		return err
+	}
+	lr.EmailPreview = e.EmailPreview
+	fields := map[string]bool{}
+	parsed := true
+	for _, part := range []struct{ field, text string }{
+		{"subject", c.Template.Subject},
+		{"text", c.Template.Text},
+		{"html", c.Template.HTML},
+	} {
+		used, ok := lr.lintText(part.field, part.text, e.context)
+		parsed = parsed && ok
+		for name := range used {
+			fields[name] = true
+		}
+		if ok && part.field == "html" && part.text != "" && !used["Tracker"] && !used["TrackingURL"] {
+			lr.problem("html", PROBLEM_MISSING_TRACKER, "The HTML doesn't include {{.Tracker}} or {{.TrackingURL}}, so opens can't be tracked")
+		}
+	}
+	// The content can't be checked for the URL if it can't all be parsed
+	if parsed && !fields["URL"] && !fields["QR"] {
+		lr.problem("template", PROBLEM_MISSING_URL, "The email doesn't include {{.URL}}, so clicks can't be tracked")
+	}
+	lr.lintImages("html", lr.HTML)
+	return nil
+}
+
+// lintPage renders the landing page for the result, checking it for problems.
+func (lr *LintReport) lintPage(p Page, c *Campaign, r Result) error {
+	pc, err := NewPageContext(c, r)
+	if err != nil {
+		return err
+	}
+	lr.Page, _ = buildTemplate(p.HTML, pc)
+	lr.lintText("page", p.HTML, pc)
+	lr.lintImages("page", lr.Page)
+	return nil
+}
+
+// lintText checks that the text parses, that every variable it uses is
+// defined for the data it's rendered with, and that it renders. It returns
+// the variables the text uses and whether or not it parsed.
+func (lr *LintReport) lintText(field string, text string, data interface{}) (map[string]bool, bool) {
+	used := map[string]bool{}
+	tmpl, err := template.New("template").Parse(text)
+	if err != nil {
+		lr.problem(field, PROBLEM_SYNTAX, err.Error())
+		return used, false
+	}
+	undefined := []string{}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		walkFields(t.Tree.Root, true, func(name string, checked bool) {
+			used[name] = true
+			if checked && !hasTemplateField(reflect.TypeOf(data), name) {
+				undefined = append(undefined, name)
+			}
+		})
+	}
+	seen := map[string]bool{}
+	for _, name := range undefined {
+		if !seen[name] {
+			lr.problem(field, PROBLEM_UNDEFINED_VARIABLE, fmt.Sprintf("{{.%s}} isn't defined", name))
+		}
+		seen[name] = true
+	}
+	if len(undefined) > 0 {
+		return used, true
+	}
+	_, err = buildTemplate(text, data)
+	if err != nil {
+		lr.problem(field, PROBLEM_RENDER, err.Error())
+	}
+	return used, true
+}
+
+// walkFields calls fn with the first name of every field used in the node.
+// Fields inside range and with blocks, where the data they refer to has
+// changed, are reported as not checked.
+func walkFields(n parse.Node, checked bool, fn func(name string, checked bool)) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			walkFields(c, checked, fn)
+		}
+	case *parse.ActionNode:
+		walkFields(n.Pipe, checked, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			walkFields(c, checked, fn)
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			walkFields(a, checked, fn)
+		}
+	case *parse.ChainNode:
+		walkFields(n.Node, checked, fn)
+	case *parse.FieldNode:
+		fn(n.Ident[0], checked)
+	case *parse.VariableNode:
+		// Fields of $ always refer to the data the text is rendered with
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			fn(n.Ident[1], true)
+		}
+	case *parse.IfNode:
+		walkFields(n.Pipe, checked, fn)
+		walkFields(n.List, checked, fn)
+		walkFields(n.ElseList, checked, fn)
+	case *parse.RangeNode:
+		walkFields(n.Pipe, checked, fn)
+		walkFields(n.List, false, fn)
+		walkFields(n.ElseList, checked, fn)
+	case *parse.WithNode:
+		walkFields(n.Pipe, checked, fn)
+		walkFields(n.List, false, fn)
+		walkFields(n.ElseList, checked, fn)
+	case *parse.TemplateNode:
+		walkFields(n.Pipe, checked, fn)
+	}
+}
+
+// hasTemplateField returns whether or not a template can use the field or
+// method with the given name on data of the given type.
+func hasTemplateField(t reflect.Type, name string) bool {
+	if _, ok := t.MethodByName(name); ok {
+		return true
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	f, ok := t.FieldByName(name)
+	return ok && f.PkgPath == ""
+}
+
+// lintImages checks that every image embedded in the HTML as a base64 data
+// URI can be decoded.
+func (lr *LintReport) lintImages(field string, html string) {
+	if html == "" {
+		return
+	}
+	d, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return
+	}
+	d.Find("img[src]").Each(func(i int, img *goquery.Selection) {
+		src, _ := img.Attr("src")
+		if !strings.HasPrefix(strings.ToLower(src), "data:") {
+			return
+		}
+		err := checkDataImage(src)
+		if err != nil {
+			lr.problem(field, PROBLEM_BROKEN_IMAGE, fmt.Sprintf("Image %d: %s", i+1, err))
+		}
+	})
+}
+
+// checkDataImage checks that the data URI is base64 encoded image data which
+// can be decoded.
+func checkDataImage(src string) error {
+	parts := strings.SplitN(src[len("data:"):], ",", 2)
+	if len(parts) != 2 {
+		return errors.New("data URI has no content")
+	}
+	meta, content := strings.ToLower(parts[0]), parts[1]
+	if !strings.HasSuffix(meta, ";base64") {
+		return nil
+	}
+	content = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+			return -1
+		}
+		return r
+	}, content)
+	b, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return fmt.Errorf("invalid base64: %s", err)
+	}
+	switch strings.TrimSuffix(meta, ";base64") {
+	case "image/png", "image/jpeg", "image/jpg", "image/gif":
+		_, _, err = image.DecodeConfig(bytes.NewReader(b))
+		if err != nil {
+			return fmt.Errorf("invalid image: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"gopkg.in/check.v1"
+)
+
+// lintProblems returns the problems in the report, as field/problem pairs
+func lintProblems(lr LintReport) []string {
+	ps := []string{}
+	for _, p := range lr.Problems {
+		ps = append(ps, p.Field+"/"+p.Problem)
+	}
+	return ps
+}
+
+func (s *ModelsSuite) TestLintTemplate(ch *check.C) {
+	l := LintRequest{}
+	_, err := l.Lint(1)
+	ch.Assert(err, check.Equals, ErrNothingToLint)
+
+	l.Template = Template{
+		Subject: "Hello {{.FirstName}}",
+		Text:    "Visit {{.URL}} {{printf \"%s\" .Email}}",
+		HTML:    "<html>{{.Tracker}}<a href='{{.URL}}'>{{.FirstName}}</a></html>",
+	}
+	lr, err := l.Lint(1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(lr.Valid(), check.Equals, true, check.Commentf("%v", lr.Problems))
+	ch.Assert(lr.Subject, check.Equals, "Hello Foo")
+	ch.Assert(lr.Text, check.Matches, "Visit http://localhost\\?rid=.* foo@bar.com")
+
+	l.Template = Template{
+		Subject: "Hello {{.FristName}}",
+		Text:    "{{range .Variables}}{{.Name}}{{end}}",
+		HTML:    "<img src='data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAAAAAA6fptVAAAACklEQVR4nGNgAAAAAgABSK+kcQAAAABJRU5ErkJggg=='><img src='data:image/png;base64,AAAA'><img src='data:image/gif;base64,not base64!'>",
+	}
+	l.Target = Target{Email: "lint@example.com", FirstName: "Lint"}
+	lr, err = l.Lint(1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(lintProblems(lr), check.DeepEquals, []string{
+		"subject/" + PROBLEM_UNDEFINED_VARIABLE,
+		"html/" + PROBLEM_MISSING_TRACKER,
+		"template/" + PROBLEM_MISSING_URL,
+		"html/" + PROBLEM_BROKEN_IMAGE,
+		"html/" + PROBLEM_BROKEN_IMAGE,
+	})
+	ch.Assert(lr.Problems[0].Detail, check.Equals, "{{.FristName}} isn't defined")
+	ch.Assert(lr.Email, check.Equals, "lint@example.com")
+
+	l.Template = Template{Text: "{{.URL"}
+	lr, err = l.Lint(1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(lintProblems(lr), check.DeepEquals, []string{"text/" + PROBLEM_SYNTAX})
+}
+
+func (s *ModelsSuite) TestLintPageAndResult(ch *check.C) {
+	c := s.createCampaign(ch)
+	l := LintRequest{
+		Template: Template{Name: c.Template.Name},
+		Page:     Page{HTML: "<html><form action='{{.URL}}'>{{.FirstName}} {{.Missing}}</form></html>"},
+		RId:      c.Results[0].RId,
+	}
+	lr, err := l.Lint(c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(lr.RId, check.Equals, c.Results[0].RId)
+	ch.Assert(lr.Subject, check.Equals, c.Results[0].RId+" - Subject")
+	ch.Assert(lintProblems(lr), check.DeepEquals, []string{
+		"html/" + PROBLEM_MISSING_TRACKER,
+		"template/" + PROBLEM_MISSING_URL,
+		"page/" + PROBLEM_UNDEFINED_VARIABLE,
+	})
+
+	l.Template = Template{Name: "Missing"}
+	_, err = l.Lint(c.UserId)
+	ch.Assert(err, check.Equals, ErrTemplateNotFound)
+	l.Template = Template{Text: "{{.URL}}"}
+	l.RId = "missing"
+	_, err = l.Lint(c.UserId)
+	ch.Assert(err, check.Equals, ErrResultNotFound)
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	log "github.com/gophish/gophish/logger"
+)
+
+// TemplateLocalization is a translation of a template's subject and content
+// into another language. Each result is sent the localization matching its
+// target's locale, chosen when the campaign is created, and results without
+// a matching localization are sent the template itself.
+type TemplateLocalization struct {
+	Id         int64  `json:"-"`
+	TemplateId int64  `json:"-"`
+	Locale     string `json:"locale"`
+	Subject    string `json:"subject"`
+	Text       string `json:"text"`
+	HTML       string `json:"html" gorm:"column:html"`
+}
+
+// ErrInvalidLocale is thrown when a template localization's locale isn't a
+// language tag, such as "fr" or "pt-BR"
+var ErrInvalidLocale = errors.New("Locale must be a language tag, such as fr or pt-BR")
+
+// ErrDuplicateLocale is thrown when a template has more than one
+// localization for the same locale
+var ErrDuplicateLocale = errors.New("Template has more than one localization for the same locale")
+
+// localePattern matches the language tags used as locales
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}([_-][a-zA-Z0-9]{2,8})*$`)
+
+// normalizeLocale returns the locale in a consistent form, such as "pt-br"
+// for "pt_BR", so that locales can be compared
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+}
+
+// language returns the language of the locale, such as "pt" for "pt-BR"
+func language(locale string) string {
+	return strings.SplitN(normalizeLocale(locale), "-", 2)[0]
+}
+
+// validateLocalizations checks that each of the template's localizations has
+// a valid locale which isn't repeated, and content to send.
+func (t *Template) validateLocalizations() error {
+	seen := make(map[string]bool)
+	for _, l := range t.Localizations {
+		locale := normalizeLocale(l.Locale)
+		switch {
+		case !localePattern.MatchString(locale):
+			return ErrInvalidLocale
+		case seen[locale]:
+			return ErrDuplicateLocale
+		case l.Text == "" && l.HTML == "":
+			return ErrTemplateMissingParameter
+		}
+		seen[locale] = true
+		lt := Template{Name: t.Name, Subject: l.Subject, Text: l.Text, HTML: l.HTML}
+		err := lt.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getLocalizations retrieves the template's localizations from the database.
+func (t *Template) getLocalizations() error {
+	t.Localizations = []TemplateLocalization{}
+	return db.Where("template_id=?", t.Id).Order("id asc").Find(&t.Localizations).Error
+}
+
+// saveLocalizations saves each of the template's localizations.
+func (t *Template) saveLocalizations() error {
+	for i := range t.Localizations {
+		t.Localizations[i].TemplateId = t.Id
+		err := db.Save(&t.Localizations[i]).Error
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchLocale returns the locale of the template's localization for the
+// given locale, which is the localization with the same locale or, failing
+// that, the first in the same language. An empty string is returned if
+// there's no matching localization, in which case the template itself is
+// used.
+func (t Template) MatchLocale(locale string) string {
+	if locale == "" {
+		return ""
+	}
+	for _, l := range t.Localizations {
+		if normalizeLocale(l.Locale) == normalizeLocale(locale) {
+			return l.Locale
+		}
+	}
+	for _, l := range t.Localizations {
+		if language(l.Locale) == language(locale) {
+			return l.Locale
+		}
+	}
+	return ""
+}
+
+// Localized returns a copy of the template with its subject and content
+// replaced by those of the localization for the given locale, if it has one.
+func (t Template) Localized(locale string) Template {
+	if locale == "" {
+		return t
+	}
+	for _, l := range t.Localizations {
+		if l.Locale == locale {
+			t.Subject = l.Subject
+			t.Text = l.Text
+			t.HTML = l.HTML
+			return t
+		}
+	}
+	return t
+}
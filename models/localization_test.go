@@ -0,0 +1,81 @@
+package models
+
+import (
+	"bytes"
+
+	"github.com/gophish/gomail"
+	"github.com/jordan-wright/email"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestTemplateLocalizationValidate(ch *check.C) {
+	t := Template{Name: "Localized", Subject: "Hello", Text: "Hello"}
+	t.Localizations = []TemplateLocalization{TemplateLocalization{Locale: "French", Text: "Bonjour"}}
+	ch.Assert(t.Validate(), check.Equals, ErrInvalidLocale)
+	t.Localizations = []TemplateLocalization{
+		TemplateLocalization{Locale: "pt-BR", Text: "Olá"},
+		TemplateLocalization{Locale: "pt_br", Text: "Olá"},
+	}
+	ch.Assert(t.Validate(), check.Equals, ErrDuplicateLocale)
+	t.Localizations = []TemplateLocalization{TemplateLocalization{Locale: "fr", Subject: "Bonjour"}}
+	ch.Assert(t.Validate(), check.Equals, ErrTemplateMissingParameter)
+	t.Localizations = []TemplateLocalization{TemplateLocalization{Locale: "fr", Text: "{{.Missing"}}
+	ch.Assert(t.Validate(), check.Not(check.Equals), nil)
+}
+
+func (s *ModelsSuite) TestTemplateMatchLocale(ch *check.C) {
+	t := Template{Localizations: []TemplateLocalization{
+		TemplateLocalization{Locale: "fr"},
+		TemplateLocalization{Locale: "pt-BR"},
+		TemplateLocalization{Locale: "pt-PT"},
+	}}
+	ch.Assert(t.MatchLocale("fr-CA"), check.Equals, "fr")
+	ch.Assert(t.MatchLocale("pt_pt"), check.Equals, "pt-PT")
+	ch.Assert(t.MatchLocale("pt"), check.Equals, "pt-BR")
+	ch.Assert(t.MatchLocale("de"), check.Equals, "")
+	ch.Assert(t.MatchLocale(""), check.Equals, "")
+}
+
+func (s *ModelsSuite) TestPostCampaignLocalizedTemplate(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	t, err := GetTemplateByName(c.Template.Name, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	t.Localizations = []TemplateLocalization{
+		TemplateLocalization{Locale: "fr", Subject: "Bonjour {{.FirstName}}", Text: "Texte"},
+	}
+	ch.Assert(PutTemplate(&t), check.Equals, nil)
+	got, err := GetTemplate(t.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(got.Localizations), check.Equals, 1)
+
+	g := c.Groups[0]
+	g.Targets = generateTargets(2)
+	g.Targets[0].Locale = "fr-CA"
+	g.Targets[1].Locale = "de"
+	ch.Assert(PutGroup(&g), check.Equals, nil)
+	c.Groups = []Group{g}
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+
+	// Targets without a matching localization are sent the template
+	locales := make(map[string]string)
+	for _, r := range c.Results {
+		locales[r.Email] = r.Locale
+	}
+	ch.Assert(locales[g.Targets[0].Email], check.Equals, "fr")
+	ch.Assert(locales[g.Targets[1].Email], check.Equals, "")
+
+	m := &MailLog{}
+	ch.Assert(db.Where("r_id=?", c.Results[0].RId).Find(m).Error, check.Equals, nil)
+	r, err := GetResult(m.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(r.Locale, check.Equals, "fr")
+	msg := gomail.NewMessage()
+	ch.Assert(m.Generate(msg), check.Equals, nil)
+	msgBuff := &bytes.Buffer{}
+	_, err = msg.WriteTo(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	e, err := email.NewEmailFromReader(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(e.Subject, check.Equals, "Bonjour "+r.FirstName)
+	ch.Assert(string(e.Text), check.Equals, "Texte")
+}
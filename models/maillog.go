@@ -2,27 +2,51 @@ package models
 
 import (
 	"bytes"
-	"encoding/base64"
 	"errors"
-	"fmt"
-	"io"
 	"math"
-	"net/mail"
 	"net/url"
 	"path"
-	"strings"
 	"text/template"
 	"time"
 
 	"github.com/gophish/gomail"
 	log "github.com/gophish/gophish/logger"
 	"github.com/gophish/gophish/mailer"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
 )
 
 // MaxSendAttempts set to 8 since we exponentially backoff after each failed send
 // attempt. This will give us a maximum send delay of 256 minutes, or about 4.2 hours.
 var MaxSendAttempts = 8
 
+// RetryBackoffBase is the delay before the first retry of an email which
+// received a temporary error. The delay doubles with each attempt.
+var RetryBackoffBase = time.Minute
+
+// RetryBackoffCap is the longest delay between retries, before jitter is
+// added.
+var RetryBackoffCap = 6 * time.Hour
+
+// RetryJitter is the largest random fraction of the backoff delay added to
+// each retry, so that emails which failed together don't all retry at the same
+// moment. A zero value disables the jitter.
+var RetryJitter = 0.25
+
+// nextRetry returns when the given send attempt should be retried, using
+// exponential backoff from RetryBackoffBase up to RetryBackoffCap plus a
+// random amount up to RetryJitter of the delay.
+func nextRetry(attempt int) time.Time {
+	delay := RetryBackoffCap
+	if backoff := float64(RetryBackoffBase) * math.Pow(2, float64(attempt)); backoff < float64(RetryBackoffCap) {
+		delay = time.Duration(backoff)
+	}
+	if jitter := int64(float64(delay) * RetryJitter); jitter > 0 {
+		delay += time.Duration(jitterSource.Int63n(jitter + 1))
+	}
+	return time.Now().UTC().Add(delay)
+}
+
 // ErrMaxSendAttempts is thrown when the maximum number of sending attemps for a given
 // MailLog is exceeded.
 var ErrMaxSendAttempts = errors.New("max send attempts exceeded")
@@ -37,23 +61,41 @@ type MailLog struct {
 	SendDate    time.Time `json:"send_date"`
 	SendAttempt int       `json:"send_attempt"`
 	Processing  bool      `json:"-"`
+	// SMTPId is the sending profile the email is sent through, which is
+	// the one assigned to the result
+	SMTPId int64 `json:"-" gorm:"column:smtp_id"`
+	// LockedBy is the instance sending the maillog, which keeps it locked
+	// until LeaseExpires
+	LockedBy     string     `json:"-"`
+	LeaseExpires *time.Time `json:"-"`
 }
 
 // GenerateMailLog creates a new maillog for the given campaign and
-// result. It sets the initial send date to match the campaign's launch date.
+// result. It sets the initial send date to match the campaign's launch date,
+// unless the result has been scheduled for a later send date.
 func GenerateMailLog(c *Campaign, r *Result) error {
+	err = db.Save(newMailLog(c, r)).Error
+	return err
+}
+
+// newMailLog returns the maillog for the given campaign and result, without
+// saving it.
+func newMailLog(c *Campaign, r *Result) *MailLog {
 	m := &MailLog{
 		UserId:     c.UserId,
 		CampaignId: c.Id,
 		RId:        r.RId,
 		SendDate:   c.LaunchDate,
+		SMTPId:     r.SMTPId,
 	}
-	err = db.Save(m).Error
-	return err
+	if r.SendDate.After(c.LaunchDate) {
+		m.SendDate = r.SendDate
+	}
+	return m
 }
 
-// Backoff sets the MailLog SendDate to be the next entry in an exponential
-// backoff. ErrMaxRetriesExceeded is thrown if this maillog has been retried
+// Backoff sets the MailLog SendDate to be the next entry in a jittered
+// exponential backoff. ErrMaxRetriesExceeded is thrown if this maillog has been retried
 // too many times. Backoff also unlocks the maillog so that it can be processed
 // again in the future.
 func (m *MailLog) Backoff(reason error) error {
@@ -61,6 +103,11 @@ func (m *MailLog) Backoff(reason error) error {
 	if err != nil {
 		return err
 	}
+	emailsProcessed.WithLabelValues("backoff").Inc()
+	err = m.recordSendAttempt(reason)
+	if err != nil {
+		log.Warn(err)
+	}
 	if m.SendAttempt == MaxSendAttempts {
 		r.HandleEmailError(ErrMaxSendAttempts)
 		return ErrMaxSendAttempts
@@ -68,9 +115,7 @@ func (m *MailLog) Backoff(reason error) error {
 	// Add an error, since we had to backoff because of a
 	// temporary error of some sort during the SMTP transaction
 	m.SendAttempt++
-	backoffDuration := math.Pow(2, float64(m.SendAttempt))
-	m.SendDate = m.SendDate.Add(time.Minute * time.Duration(backoffDuration))
-	err = db.Save(m).Error
+	err = m.reschedule(&r, nextRetry(m.SendAttempt))
 	if err != nil {
 		return err
 	}
@@ -82,18 +127,65 @@ func (m *MailLog) Backoff(reason error) error {
 	return err
 }
 
+// Throttle reschedules the maillog for the given time without counting it as
+// a send attempt, since it wasn't sent because its sending profile reached
+// its sending limits. Throttle also unlocks the maillog so that it can be
+// processed again in the future.
+func (m *MailLog) Throttle(sendDate time.Time) error {
+	r, err := GetResult(m.RId)
+	if err != nil {
+		return err
+	}
+	emailsProcessed.WithLabelValues("throttled").Inc()
+	m.setLock(false)
+	err = m.reschedule(&r, sendDate)
+	if err != nil {
+		return err
+	}
+	r.SendDate = m.SendDate
+	return ResultStorage.Save(&r)
+}
+
+// reschedule saves the maillog with the given send date, moved to the next
+// time allowed by the campaign's send window.
+func (m *MailLog) reschedule(r *Result, sendDate time.Time) error {
+	c := Campaign{}
+	err := db.Where("id=?", m.CampaignId).Find(&c).Error
+	if err != nil {
+		return err
+	}
+	m.SendDate, err = c.scheduleSend(r, sendDate)
+	if err != nil {
+		return err
+	}
+	return db.Save(m).Error
+}
+
 // Unlock removes the processing flag so the maillog can be processed again
 func (m *MailLog) Unlock() error {
-	m.Processing = false
+	m.setLock(false)
 	return db.Save(&m).Error
 }
 
 // Lock sets the processing flag so that other processes cannot modify the maillog
 func (m *MailLog) Lock() error {
-	m.Processing = true
+	m.setLock(true)
 	return db.Save(&m).Error
 }
 
+// setLock locks the maillog to this instance, leasing it for MailLogLease,
+// or unlocks it
+func (m *MailLog) setLock(lock bool) {
+	m.Processing = lock
+	m.LockedBy = ""
+	m.LeaseExpires = nil
+	if lock {
+		expires := time.Now().UTC().Add(MailLogLease)
+		m.LockedBy = InstanceId
+		m.LeaseExpires = &expires
+	}
+}
+
 // Error sets the error status on the models.Result that the
 // maillog refers to. Since MailLog errors are permanent,
 // this action also deletes the maillog.
@@ -103,6 +195,11 @@ func (m *MailLog) Error(e error) error {
 		log.Warn(err)
 		return err
 	}
+	emailsProcessed.WithLabelValues("error").Inc()
+	err = m.recordSendAttempt(e)
+	if err != nil {
+		log.Warn(err)
+	}
 	err = r.HandleEmailError(e)
 	if err != nil {
 		log.Warn(err)
@@ -119,6 +216,12 @@ func (m *MailLog) Success() error {
 	if err != nil {
 		return err
 	}
+	emailsProcessed.WithLabelValues("sent").Inc()
+	lastEmailSent.SetToCurrentTime()
+	err = m.recordSendAttempt(nil)
+	if err != nil {
+		log.Warn(err)
+	}
 	err = r.HandleEmailSent()
 	if err != nil {
 		return err
@@ -127,20 +230,27 @@ func (m *MailLog) Success() error {
 	return nil
 }
 
-// GetDialer returns a dialer based on the maillog campaign's SMTP configuration
+// GetDialer returns a dialer based on the SMTP configuration of the sending
+// profile assigned to the maillog's result
 func (m *MailLog) GetDialer() (mailer.Dialer, error) {
 	c, err := GetCampaign(m.CampaignId, m.UserId)
 	if err != nil {
 		return nil, err
 	}
-	return c.SMTP.GetDialer()
+	r, err := GetResult(m.RId)
+	if err != nil {
+		return nil, err
+	}
+	s := c.ProfileFor(&r)
+	return s.GetDialer()
 }
 
 // buildTemplate creates a templated string based on the provided
-// template body and data.
+// template body and data. Variables the result doesn't have render as an
+// empty string.
 func buildTemplate(text string, data interface{}) (string, error) {
 	buff := bytes.Buffer{}
-	tmpl, err := template.New("template").Parse(text)
+	tmpl, err := template.New("template").Option("missingkey=zero").Parse(text)
 	if err != nil {
 		return buff.String(), err
 	}
@@ -148,6 +258,45 @@ func buildTemplate(text string, data interface{}) (string, error) {
 	return buff.String(), err
 }
 
+// recipientURLs returns the campaign's phishing and tracking URLs for the
+// given result.
+func recipientURLs(c *Campaign, r *Result) (*url.URL, *url.URL, error) {
+	campaignURL, err := buildTemplate(c.URL, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Results can be assigned their own tracking domain, which overrides the
+	// domain used in the campaign's URL.
+	if r.TrackingDomain != "" {
+		u, err := url.Parse(campaignURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		u.Host = r.TrackingDomain
+		campaignURL = u.String()
+	}
+
+	phishURL, _ := url.Parse(campaignURL)
+	q := phishURL.Query()
+	q.Set("rid", SignRecipientId(r.RId))
+	phishURL.RawQuery = q.Encode()
+
+	trackingURL, _ := url.Parse(campaignURL)
+	trackingURL.Path = path.Join(trackingURL.Path, "/track")
+	trackingURL.RawQuery = q.Encode()
+	return phishURL, trackingURL, nil
+}
+
+// templateContext is the data email templates are rendered with for a
+// recipient
+type templateContext struct {
+	Result
+	phishingURL
+	TrackingURL string
+	Tracker     string
+	From        string
+}
+
 // Generate fills in the details of a gomail.Message instance with
 // the correct headers and body from the campaign and recipient listed in
 // the maillog. We accept the gomail.Message as an argument so that the caller
@@ -161,108 +310,65 @@ func (m *MailLog) Generate(msg *gomail.Message) error {
 	if err != nil {
 		return err
 	}
-	f, err := mail.ParseAddress(c.SMTP.FromAddress)
-	if err != nil {
-		return err
-	}
-	fn := f.Name
-	if fn == "" {
-		fn = f.Address
+	// Use a stable Message-Id across send attempts so that the result can be
+	// reconciled against the MTA's delivery logs.
+	if r.MessageId == "" {
+		err = r.setMessageId()
+		if err != nil {
+			return err
+		}
 	}
-	msg.SetAddressHeader("From", f.Address, f.Name)
-	campaignURL, err := buildTemplate(c.URL, r)
+	e, err := renderEmail(&c, &r)
 	if err != nil {
 		return err
 	}
-
-	phishURL, _ := url.Parse(campaignURL)
-	q := phishURL.Query()
-	q.Set("rid", r.RId)
-	phishURL.RawQuery = q.Encode()
-
-	trackingURL, _ := url.Parse(campaignURL)
-	trackingURL.Path = path.Join(trackingURL.Path, "/track")
-	trackingURL.RawQuery = q.Encode()
-
-	td := struct {
-		Result
-		URL         string
-		TrackingURL string
-		Tracker     string
-		From        string
-	}{
-		r,
-		phishURL.String(),
-		trackingURL.String(),
-		"<img alt='' style='display: none' src='" + trackingURL.String() + "'/>",
-		fn,
+	for _, err := range e.errs {
+		log.Warn(err)
 	}
-
-	// Parse the customHeader templates
-	for _, header := range c.SMTP.Headers {
-		key, err := buildTemplate(header.Key, td)
-		if err != nil {
-			log.Warn(err)
-		}
-
-		value, err := buildTemplate(header.Value, td)
+	// Don't send the email with a link which couldn't be shortened, since
+	// the recipient's click couldn't be tracked
+	if e.context.short != nil && e.context.short.err != nil {
+		return e.context.short.err
+	}
+	e.write(msg)
+	// Record the rendered subject so that we can later compare how
+	// different subject lines performed.
+	if r.Subject != e.Subject {
+		r.Subject = e.Subject
+		err = ResultStorage.Save(&r)
 		if err != nil {
-			log.Warn(err)
+			return err
 		}
-
-		// Add our header immediately
-		msg.SetHeader(key, value)
 	}
+	return nil
+}
+
+// queuedMailLogs returns a query for the mail logs that are queued up for the
+// given minute.
+func queuedMailLogs(t time.Time) *gorm.DB {
+	return db.Model(&MailLog{}).Where("send_date <= ?", t).
+		Where("processing = ? OR lease_expires < ?", false, time.Now().UTC()).
+		Where("r_id NOT IN (SELECT r_id FROM results WHERE on_hold = ? OR deleted_at IS NOT NULL)", true).
+		Where("campaign_id NOT IN (SELECT id FROM campaigns WHERE status IN (?))", []string{CAMPAIGN_PAUSED, CAMPAIGN_DRY_RUN})
+}
 
-	// Parse remaining templates
-	subject, err := buildTemplate(c.Template.Subject, td)
+// countQueuedMailLogs returns the number of mail logs that are queued up for
+// the given minute.
+func countQueuedMailLogs(t time.Time) int {
+	count := 0
+	err := queuedMailLogs(t).Count(&count).Error
 	if err != nil {
 		log.Warn(err)
 	}
-	// don't set Subject header if the subject is empty
-	if len(subject) != 0 {
-		msg.SetHeader("Subject", subject)
-	}
-
-	msg.SetHeader("To", r.FormatAddress())
-	if c.Template.Text != "" {
-		text, err := buildTemplate(c.Template.Text, td)
-		if err != nil {
-			log.Warn(err)
-		}
-		msg.SetBody("text/plain", text)
-	}
-	if c.Template.HTML != "" {
-		html, err := buildTemplate(c.Template.HTML, td)
-		if err != nil {
-			log.Warn(err)
-		}
-		if c.Template.Text == "" {
-			msg.SetBody("text/html", html)
-		} else {
-			msg.AddAlternative("text/html", html)
-		}
-	}
-	// Attach the files
-	for _, a := range c.Template.Attachments {
-		msg.Attach(func(a Attachment) (string, gomail.FileSetting, gomail.FileSetting) {
-			h := map[string][]string{"Content-ID": {fmt.Sprintf("<%s>", a.Name)}}
-			return a.Name, gomail.SetCopyFunc(func(w io.Writer) error {
-				decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(a.Content))
-				_, err = io.Copy(w, decoder)
-				return err
-			}), gomail.SetHeader(h)
-		}(a))
-	}
-
-	return nil
+	return count
 }
 
 // GetQueuedMailLogs returns the mail logs that are queued up for the given minute.
+// Mail logs for results which are on hold are skipped until they're released,
+// and mail logs for results which have been removed are skipped.
 func GetQueuedMailLogs(t time.Time) ([]*MailLog, error) {
 	ms := []*MailLog{}
-	err := db.Where("send_date <= ? AND processing = ?", t, false).
-		Find(&ms).Error
+	err := queuedMailLogs(t).Find(&ms).Error
 	if err != nil {
 		log.Warn(err)
 	}
@@ -280,7 +386,7 @@ func GetMailLogsByCampaign(cid int64) ([]*MailLog, error) {
 func LockMailLogs(ms []*MailLog, lock bool) error {
 	tx := db.Begin()
 	for i := range ms {
-		ms[i].Processing = lock
+		ms[i].setLock(lock)
 		err := tx.Save(ms[i]).Error
 		if err != nil {
 			tx.Rollback()
@@ -298,3 +404,72 @@ func UnlockAllMailLogs() error {
 	err = db.Model(&MailLog{}).Update("processing", false).Error
 	return err
 }
+
+// GenerateSMS returns the text message for the recipient listed in the
+// maillog, rendered from the text of the campaign's template.
+func (m *MailLog) GenerateSMS() (string, error) {
+	r, err := GetResult(m.RId)
+	if err != nil {
+		return "", err
+	}
+	c, err := GetCampaign(m.CampaignId, m.UserId)
+	if err != nil {
+		return "", err
+	}
+	phishURL, _, err := recipientURLs(&c, &r)
+	if err != nil {
+		return "", err
+	}
+	short := c.shortLinks(&r)
+	td := struct {
+		Result
+		phishingURL
+		From string
+	}{
+		r,
+		phishingURL{url: phishURL, short: short},
+		c.SMS.FromNumber,
+	}
+	text, err := buildTemplate(c.VariantFor(&r).Template.Localized(r.Locale).Text, td)
+	if short != nil && short.err != nil {
+		return "", short.err
+	}
+	return text, err
+}
+
+// SendSMS sends the text message for the recipient listed in the maillog
+// through the campaign's SMS profile, recording the outcome on the result
+// the same way as an email. Messages which the gateway temporarily rejects
+// are retried later.
+func (m *MailLog) SendSMS() error {
+	c, err := GetCampaign(m.CampaignId, m.UserId)
+	if err != nil {
+		return m.Error(err)
+	}
+	r, err := GetResult(m.RId)
+	if err != nil {
+		return err
+	}
+	body, err := m.GenerateSMS()
+	if err != nil {
+		return m.Error(err)
+	}
+	err = c.SMS.Send(r.Phone, body)
+	if ge, ok := err.(*SMSGatewayError); ok && !ge.Temporary() {
+		log.WithFields(logrus.Fields{
+			"code":  ge.StatusCode,
+			"phone": r.Phone,
+		}).Warn(err)
+		return m.Error(err)
+	}
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"phone": r.Phone,
+		}).Warn(err)
+		return m.Backoff(err)
+	}
+	log.WithFields(logrus.Fields{
+		"phone": r.Phone,
+	}).Info("SMS sent")
+	return m.Success()
+}
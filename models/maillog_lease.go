@@ -0,0 +1,109 @@
+package models
+
+import (
+	"os"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	log "github.com/gophish/gophish/logger"
+	"github.com/jinzhu/gorm"
+)
+
+// InstanceId identifies this gophish instance to the others sharing its
+// database, and is recorded on the maillogs it claims.
+var InstanceId = "gophish"
+
+// MailLogLease is how long a claimed maillog stays locked to the instance
+// which claimed it. Instances renew the leases of the maillogs they're
+// sending, so a maillog is only claimed by another instance if the one
+// sending it stops.
+var MailLogLease = 10 * time.Minute
+
+// MailLogClaimLimit is the most maillogs claimed by an instance at once, so
+// that the emails which are due are shared between the instances. Every
+// queued maillog is claimed if it's 0.
+var MailLogClaimLimit = 0
+
+// configureWorker applies the configured settings for sharing the sending of
+// maillogs between instances, using the defaults for those which aren't
+// given.
+func configureWorker(conf config.Worker) {
+	InstanceId = conf.InstanceId
+	if InstanceId == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Error(err)
+			hostname = "gophish"
+		}
+		InstanceId = hostname
+	}
+	if conf.LeaseMinutes > 0 {
+		MailLogLease = time.Duration(conf.LeaseMinutes) * time.Minute
+	}
+	if conf.BatchSize > 0 {
+		MailLogClaimLimit = conf.BatchSize
+	}
+}
+
+// ClaimMailLogs claims the maillogs which are queued up for the given minute
+// for the given instance, returning those it claimed. Up to
+// MailLogClaimLimit maillogs are claimed, starting with those which have been
+// due the longest. Maillogs whose lease has expired are claimed again.
+func ClaimMailLogs(t time.Time, owner string) ([]*MailLog, error) {
+	q := queuedMailLogs(t).Order("send_date asc")
+	if MailLogClaimLimit > 0 {
+		q = q.Limit(MailLogClaimLimit)
+	}
+	return claimMailLogs(q, owner)
+}
+
+// ClaimCampaignMailLogs claims every maillog of the given campaign which
+// isn't already being sent for the given instance, returning those it
+// claimed.
+func ClaimCampaignMailLogs(cid int64, owner string) ([]*MailLog, error) {
+	q := db.Model(&MailLog{}).Where("campaign_id = ?", cid).
+		Where("processing = ? OR lease_expires < ?", false, time.Now().UTC())
+	return claimMailLogs(q, owner)
+}
+
+// claimMailLogs claims the maillogs selected by the given query. The
+// maillogs are claimed with a single conditional update, so when several
+// instances claim the same maillogs at once each is only claimed by one of
+// them.
+func claimMailLogs(q *gorm.DB, owner string) ([]*MailLog, error) {
+	ids := []int64{}
+	err := q.Pluck("mail_logs.id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return []*MailLog{}, err
+	}
+	now := time.Now().UTC()
+	expires := now.Add(MailLogLease)
+	// The update checks that each maillog is still unclaimed, since another
+	// instance may have claimed it since it was selected
+	err = db.Model(&MailLog{}).Where("id IN (?)", ids).
+		Where("processing = ? OR lease_expires < ?", false, now).
+		Updates(map[string]interface{}{"processing": true, "locked_by": owner, "lease_expires": expires}).Error
+	if err != nil {
+		return nil, err
+	}
+	ms := []*MailLog{}
+	err = db.Where("id IN (?) AND processing = ? AND locked_by = ?", ids, true, owner).Find(&ms).Error
+	return ms, err
+}
+
+// RenewMailLogLeases extends the leases of the maillogs the given instance
+// is sending, so that they aren't claimed by another instance.
+func RenewMailLogLeases(owner string) error {
+	return db.Model(&MailLog{}).Where("processing = ? AND locked_by = ?", true, owner).
+		Update("lease_expires", time.Now().UTC().Add(MailLogLease)).Error
+}
+
+// ReleaseMailLogs unlocks the maillogs locked by the given instance, along
+// with those locked before maillogs were leased. This is intended to be
+// called when the instance is started, so that the maillogs it was sending
+// when it was last shut down are sent without waiting for their leases to
+// expire, while those being sent by other instances are left alone.
+func ReleaseMailLogs(owner string) error {
+	return db.Model(&MailLog{}).Where("locked_by = ? OR lease_expires IS NULL", owner).
+		Updates(map[string]interface{}{"processing": false, "locked_by": "", "lease_expires": gorm.Expr("NULL")}).Error
+}
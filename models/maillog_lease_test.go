@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestClaimMailLogs(ch *check.C) {
+	defer func(limit int) { MailLogClaimLimit = limit }(MailLogClaimLimit)
+	campaign := s.createCampaign(ch)
+	total := len(campaign.Results)
+	ch.Assert(total > 1, check.Equals, true)
+
+	// Each instance claims up to the limit, and maillogs claimed by one
+	// instance aren't claimed by another
+	MailLogClaimLimit = 1
+	first, err := ClaimMailLogs(time.Now().UTC(), "first")
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(first), check.Equals, 1)
+	ch.Assert(first[0].LockedBy, check.Equals, "first")
+	ch.Assert(first[0].Processing, check.Equals, true)
+	MailLogClaimLimit = 0
+	second, err := ClaimMailLogs(time.Now().UTC(), "second")
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(second), check.Equals, total-1)
+	for _, m := range second {
+		ch.Assert(m.Id, check.Not(check.Equals), first[0].Id)
+	}
+	ms, err := ClaimCampaignMailLogs(campaign.Id, "third")
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ms), check.Equals, 0)
+
+	// Maillogs are claimed again once their lease expires, unless it's
+	// renewed
+	ch.Assert(db.Model(&MailLog{}).Where("campaign_id=?", campaign.Id).
+		Update("lease_expires", time.Now().UTC().Add(-time.Minute)).Error, check.Equals, nil)
+	ch.Assert(RenewMailLogLeases("second"), check.Equals, nil)
+	ms, err = ClaimCampaignMailLogs(campaign.Id, "third")
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ms), check.Equals, 1)
+	ch.Assert(ms[0].Id, check.Equals, first[0].Id)
+
+	// Restarting an instance only releases its own maillogs
+	ch.Assert(ReleaseMailLogs("third"), check.Equals, nil)
+	ms, err = GetMailLogsByCampaign(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	for _, m := range ms {
+		if m.Id == first[0].Id {
+			ch.Assert(m.Processing, check.Equals, false)
+			ch.Assert(m.LeaseExpires, check.IsNil)
+		} else {
+			ch.Assert(m.Processing, check.Equals, true)
+			ch.Assert(m.LockedBy, check.Equals, "second")
+		}
+	}
+
+	// Unlocked maillogs can be claimed straight away
+	ms, err = ClaimCampaignMailLogs(campaign.Id, "first")
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ms), check.Equals, 1)
+	ch.Assert(ms[0].Unlock(), check.Equals, nil)
+	ch.Assert(ms[0].LockedBy, check.Equals, "")
+	ms, err = ClaimMailLogs(time.Now().UTC(), "first")
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ms), check.Equals, 1)
+}
@@ -35,6 +35,8 @@ func (s *ModelsSuite) TestGetQueuedMailLogs(ch *check.C) {
 }
 
 func (s *ModelsSuite) TestMailLogBackoff(ch *check.C) {
+	defer func(jitter float64) { RetryJitter = jitter }(RetryJitter)
+	RetryJitter = 0
 	campaign := s.createCampaign(ch)
 	result := campaign.Results[0]
 	m := &MailLog{}
@@ -54,15 +56,18 @@ func (s *ModelsSuite) TestMailLogBackoff(ch *check.C) {
 		ch.Assert(m.Processing, check.Equals, true)
 
 		expectedDuration := math.Pow(2, float64(m.SendAttempt+1))
-		expectedSendDate := m.SendDate.Add(time.Minute * time.Duration(expectedDuration))
+		expectedDelay := time.Minute * time.Duration(expectedDuration)
+		before := time.Now().UTC()
 		err = m.Backoff(expectedError)
 		ch.Assert(err, check.Equals, nil)
-		ch.Assert(m.SendDate, check.Equals, expectedSendDate)
+		ch.Assert(m.SendDate.Before(before.Add(expectedDelay)), check.Equals, false)
+		ch.Assert(m.SendDate.After(time.Now().UTC().Add(expectedDelay)), check.Equals, false)
 		ch.Assert(m.Processing, check.Equals, false)
 		result, err := GetResult(m.RId)
 		ch.Assert(err, check.Equals, nil)
-		ch.Assert(result.SendDate, check.Equals, expectedSendDate)
+		ch.Assert(result.SendDate.Equal(m.SendDate), check.Equals, true)
 		ch.Assert(result.Status, check.Equals, STATUS_RETRY)
+		ch.Assert(result.RetryAttempts, check.Equals, m.SendAttempt)
 	}
 	// Get our updated campaign and check for the added event
 	campaign, err = GetCampaign(campaign.Id, int64(1))
@@ -76,6 +81,38 @@ func (s *ModelsSuite) TestMailLogBackoff(ch *check.C) {
 	ch.Assert(err, check.Equals, ErrMaxSendAttempts)
 }
 
+func (s *ModelsSuite) TestNextRetry(ch *check.C) {
+	defer func(base, backoffCap time.Duration, jitter float64) {
+		RetryBackoffBase, RetryBackoffCap, RetryJitter = base, backoffCap, jitter
+	}(RetryBackoffBase, RetryBackoffCap, RetryJitter)
+	RetryBackoffBase = time.Minute
+	RetryBackoffCap = time.Hour
+	RetryJitter = 0.5
+	SeedSendJitter(1)
+
+	delayOf := func(attempt int) time.Duration {
+		return nextRetry(attempt).Sub(time.Now().UTC())
+	}
+	// Delays grow exponentially, with up to half of the delay added as jitter
+	for attempt, base := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute} {
+		delay := delayOf(attempt)
+		ch.Assert(delay > base-time.Second, check.Equals, true, check.Commentf("attempt %d: %s", attempt, delay))
+		ch.Assert(delay <= base+base/2, check.Equals, true, check.Commentf("attempt %d: %s", attempt, delay))
+	}
+	// Delays are capped before the jitter is added
+	delay := delayOf(20)
+	ch.Assert(delay > time.Hour-time.Second, check.Equals, true)
+	ch.Assert(delay <= time.Hour+time.Hour/2, check.Equals, true)
+
+	// Retries failing together are spread out rather than landing on the
+	// same second
+	seen := make(map[int64]bool)
+	for i := 0; i < 20; i++ {
+		seen[nextRetry(3).Unix()] = true
+	}
+	ch.Assert(len(seen) > 1, check.Equals, true)
+}
+
 func (s *ModelsSuite) TestMailLogError(ch *check.C) {
 	campaign := s.createCampaign(ch)
 	result := campaign.Results[0]
@@ -211,6 +248,16 @@ func (s *ModelsSuite) TestMailLogGenerate(ch *check.C) {
 	ch.Assert(got.Subject, check.Equals, expected.Subject)
 	ch.Assert(string(got.Text), check.Equals, string(expected.Text))
 	ch.Assert(string(got.HTML), check.Equals, string(expected.HTML))
+
+	// The Message-Id should be stored and reused across send attempts
+	result, err = GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(result.Subject, check.Equals, expected.Subject)
+	ch.Assert(result.MessageId, check.Not(check.Equals), "")
+	ch.Assert(msg.GetHeader("Message-Id"), check.DeepEquals, []string{result.MessageId})
+	msg = gomail.NewMessage()
+	ch.Assert(m.Generate(msg), check.Equals, nil)
+	ch.Assert(msg.GetHeader("Message-Id"), check.DeepEquals, []string{result.MessageId})
 }
 
 func (s *ModelsSuite) TestUnlockAllMailLogs(ch *check.C) {
@@ -272,6 +319,37 @@ func (s *ModelsSuite) TestURLTemplateRendering(ch *check.C) {
 	ch.Assert(string(got.HTML), check.Equals, expectedURL)
 }
 
+func (s *ModelsSuite) TestURLTemplateLinks(ch *check.C) {
+	template := Template{
+		Name:    "LinksTemplate",
+		UserId:  1,
+		Text:    `{{.URL "invoice"}} {{.URL}}`,
+		HTML:    `<a href="{{.URL "invoice"}}">Invoice</a>`,
+		Subject: "Subject",
+	}
+	ch.Assert(PostTemplate(&template), check.Equals, nil)
+	campaign := s.createCampaignDependencies(ch)
+	campaign.URL = "http://127.0.0.1/"
+	campaign.Template = template
+	ch.Assert(PostCampaign(&campaign, campaign.UserId), check.Equals, nil)
+	result := campaign.Results[0]
+
+	m := &MailLog{}
+	err := db.Where("r_id=? AND campaign_id=?", result.RId, campaign.Id).Find(m).Error
+	ch.Assert(err, check.Equals, nil)
+	msg := gomail.NewMessage()
+	ch.Assert(m.Generate(msg), check.Equals, nil)
+	msgBuff := &bytes.Buffer{}
+	_, err = msg.WriteTo(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	got, err := email.NewEmailFromReader(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	tagged := fmt.Sprintf("http://127.0.0.1/?%s=invoice&%s=%s", LinkParameter, RecipientParameter, result.RId)
+	untagged := fmt.Sprintf("http://127.0.0.1/?%s=%s", RecipientParameter, result.RId)
+	ch.Assert(string(got.Text), check.Equals, tagged+" "+untagged)
+	ch.Assert(string(got.HTML), check.Equals, `<a href="`+tagged+`">Invoice</a>`)
+}
+
 func (s *ModelsSuite) TestMailLogGenerateEmptySubject(ch *check.C) {
 
 	// in place of using createCampaign, we replicate its small code body
@@ -305,3 +383,179 @@ func (s *ModelsSuite) TestMailLogGenerateEmptySubject(ch *check.C) {
 	ch.Assert(err, check.Equals, nil)
 	ch.Assert(got.Subject, check.Equals, expected.Subject)
 }
+
+func (s *ModelsSuite) TestMailLogGenerateTrackingDomain(ch *check.C) {
+	template := Template{
+		Name:    "TrackingDomainTemplate",
+		UserId:  1,
+		Text:    "{{.URL}}",
+		HTML:    "{{.TrackingURL}}",
+		Subject: "Subject",
+	}
+	ch.Assert(PostTemplate(&template), check.Equals, nil)
+	campaign := s.createCampaignDependencies(ch)
+	campaign.URL = "http://127.0.0.1/landing/"
+	campaign.Template = template
+	ch.Assert(PostCampaign(&campaign, campaign.UserId), check.Equals, nil)
+
+	generate := func(r Result) *email.Email {
+		m := &MailLog{}
+		err := db.Where("r_id=? AND campaign_id=?", r.RId, campaign.Id).
+			Find(m).Error
+		ch.Assert(err, check.Equals, nil)
+		msg := gomail.NewMessage()
+		ch.Assert(m.Generate(msg), check.Equals, nil)
+		msgBuff := &bytes.Buffer{}
+		_, err = msg.WriteTo(msgBuff)
+		ch.Assert(err, check.Equals, nil)
+		got, err := email.NewEmailFromReader(msgBuff)
+		ch.Assert(err, check.Equals, nil)
+		return got
+	}
+
+	// Without a tracking domain, the campaign's URL is used
+	result := campaign.Results[0]
+	got := generate(result)
+	ch.Assert(string(got.Text), check.Equals,
+		fmt.Sprintf("http://127.0.0.1/landing/?%s=%s", RecipientParameter, result.RId))
+
+	ch.Assert(result.SetTrackingDomain("not a domain"), check.Equals, ErrInvalidTrackingDomain)
+	ch.Assert(result.SetTrackingDomain("-bad.example.com"), check.Equals, ErrInvalidTrackingDomain)
+	ch.Assert(result.SetTrackingDomain("example.com/path"), check.Equals, ErrInvalidTrackingDomain)
+	ch.Assert(result.SetTrackingDomain("hr.example.com:8080"), check.Equals, nil)
+	got = generate(result)
+	ch.Assert(string(got.Text), check.Equals,
+		fmt.Sprintf("http://hr.example.com:8080/landing/?%s=%s", RecipientParameter, result.RId))
+	ch.Assert(string(got.HTML), check.Equals,
+		fmt.Sprintf("http://hr.example.com:8080/landing/track?%s=%s", RecipientParameter, result.RId))
+}
+
+func (s *ModelsSuite) TestGetQueuedMailLogsOnHold(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	held := campaign.Results[0]
+	ch.Assert(held.Hold(), check.Equals, nil)
+
+	queued := func() map[string]bool {
+		ms, err := GetQueuedMailLogs(campaign.LaunchDate)
+		ch.Assert(err, check.Equals, nil)
+		got := make(map[string]bool)
+		for _, m := range ms {
+			got[m.RId] = true
+		}
+		return got
+	}
+	got := queued()
+	ch.Assert(got[held.RId], check.Equals, false)
+	ch.Assert(len(got), check.Equals, len(campaign.Results)-1)
+
+	ch.Assert(held.Release(), check.Equals, nil)
+	got = queued()
+	ch.Assert(got[held.RId], check.Equals, true)
+	ch.Assert(len(got), check.Equals, len(campaign.Results))
+
+	// Both changes are recorded in the timeline
+	campaign, err := GetCampaign(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	messages := []string{}
+	for _, e := range campaign.Events {
+		if e.Email == held.Email {
+			messages = append(messages, e.Message)
+		}
+	}
+	ch.Assert(messages, check.DeepEquals, []string{EVENT_HELD, EVENT_RELEASED})
+}
+
+func (s *ModelsSuite) TestMailLogGenerateVariables(ch *check.C) {
+	template := Template{
+		Name:    "VariablesTemplate",
+		UserId:  1,
+		Text:    "{{.FirstName}} in {{.Variables.department}}, code {{.Variables.coupon}}{{.Variables.missing}}.",
+		HTML:    "{{.Variables.department}}",
+		Subject: "Subject",
+	}
+	ch.Assert(template.Validate(), check.Equals, nil)
+	ch.Assert(PostTemplate(&template), check.Equals, nil)
+	campaign := s.createCampaignDependencies(ch)
+	campaign.Groups[0].Targets = []Target{
+		{Email: "sales@example.com", FirstName: "Sal",
+			Attributes: map[string]string{"department": "Sales", "coupon": "A1"}},
+		{Email: "it@example.com", FirstName: "Ian",
+			Attributes: map[string]string{"department": "IT", "coupon": "B2"}},
+		{Email: "plain@example.com", FirstName: "Plain"},
+	}
+	ch.Assert(PutGroup(&campaign.Groups[0]), check.Equals, nil)
+	campaign.Template = template
+	ch.Assert(PostCampaign(&campaign, campaign.UserId), check.Equals, nil)
+
+	expected := map[string]string{
+		"sales@example.com": "Sal in Sales, code A1.",
+		"it@example.com":    "Ian in IT, code B2.",
+		"plain@example.com": "Plain in , code .",
+	}
+	ch.Assert(len(campaign.Results), check.Equals, len(expected))
+	for _, r := range campaign.Results {
+		result, err := GetResult(r.RId)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(result.Variables["department"], check.Equals, r.Variables["department"])
+		m := &MailLog{}
+		err = db.Where("r_id=? AND campaign_id=?", r.RId, campaign.Id).Find(m).Error
+		ch.Assert(err, check.Equals, nil)
+		msg := gomail.NewMessage()
+		ch.Assert(m.Generate(msg), check.Equals, nil)
+		msgBuff := &bytes.Buffer{}
+		_, err = msg.WriteTo(msgBuff)
+		ch.Assert(err, check.Equals, nil)
+		got, err := email.NewEmailFromReader(msgBuff)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(string(got.Text), check.Equals, expected[r.Email])
+	}
+}
+
+func (s *ModelsSuite) TestMailLogGenerateCustomFields(ch *check.C) {
+	template := Template{
+		Name:    "CustomFieldsTemplate",
+		UserId:  1,
+		Text:    "{{.Custom.Department}}/{{.Custom.EmployeeId}}/{{.Custom.department}}{{.Custom.Missing}}",
+		HTML:    "{{.Custom.Department}}",
+		Subject: "Subject",
+	}
+	ch.Assert(template.Validate(), check.Equals, nil)
+	ch.Assert(PostTemplate(&template), check.Equals, nil)
+	campaign := s.createCampaignDependencies(ch)
+	campaign.Groups[0].Targets = []Target{
+		{Email: "sales@example.com", FirstName: "Sal",
+			Attributes: map[string]string{"department": "Sales", "employee id": "E1"}},
+	}
+	ch.Assert(PutGroup(&campaign.Groups[0]), check.Equals, nil)
+	campaign.Template = template
+	ch.Assert(PostCampaign(&campaign, campaign.UserId), check.Equals, nil)
+
+	m := &MailLog{}
+	err := db.Where("r_id=? AND campaign_id=?", campaign.Results[0].RId, campaign.Id).Find(m).Error
+	ch.Assert(err, check.Equals, nil)
+	msg := gomail.NewMessage()
+	ch.Assert(m.Generate(msg), check.Equals, nil)
+	msgBuff := &bytes.Buffer{}
+	_, err = msg.WriteTo(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	got, err := email.NewEmailFromReader(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(string(got.Text), check.Equals, "Sales/E1/Sales")
+}
+
+func (s *ModelsSuite) TestCustomFieldName(ch *check.C) {
+	for name, expected := range map[string]string{
+		"department":    "Department",
+		"employee id":   "EmployeeId",
+		"Employee ID":   "EmployeeID",
+		"manager_email": "ManagerEmail",
+		"locale-2":      "Locale2",
+		"--":            "",
+	} {
+		ch.Assert(customFieldName(name), check.Equals, expected)
+	}
+	// An attribute named exactly as a field takes precedence
+	r := Result{Variables: map[string]string{"department": "Sales", "Department": "IT"}}
+	ch.Assert(r.Custom()["Department"], check.Equals, "IT")
+	ch.Assert(r.Custom()["department"], check.Equals, "Sales")
+}
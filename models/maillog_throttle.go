@@ -0,0 +1,122 @@
+package models
+
+import (
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+)
+
+// sendInterval returns how far apart emails need to be sent to stay within
+// the profile's sending limits
+func (s *SMTP) sendInterval() time.Duration {
+	interval := time.Duration(0)
+	if s.MaxMessagesPerMinute > 0 {
+		interval = time.Minute / time.Duration(s.MaxMessagesPerMinute)
+	}
+	if s.MaxMessagesPerHour > 0 {
+		if hourly := time.Hour / time.Duration(s.MaxMessagesPerHour); hourly > interval {
+			interval = hourly
+		}
+	}
+	return interval
+}
+
+// sentSince returns the number of emails sent through the profile since the
+// given time, including those which are still being sent apart from the
+// given maillogs.
+func (s *SMTP) sentSince(t time.Time, exclude []int64) (int, error) {
+	sent := 0
+	err := db.Model(&Event{}).Where("message = ? AND time >= ?", EVENT_SENT, t).
+		Where("EXISTS (SELECT 1 FROM results WHERE results.campaign_id = events.campaign_id AND results.email = events.email AND results.smtp_id = ?)", s.Id).
+		Count(&sent).Error
+	if err != nil {
+		return 0, err
+	}
+	sending := 0
+	err = db.Model(&MailLog{}).Where("processing = ? AND lease_expires >= ?", true, time.Now().UTC()).
+		Where("id NOT IN (?) AND smtp_id = ?", exclude, s.Id).Count(&sending).Error
+	return sent + sending, err
+}
+
+// sendAllowance returns the number of emails which can be sent through the
+// profile at the given time without exceeding its sending limits, apart from
+// the given maillogs.
+func (s *SMTP) sendAllowance(t time.Time, exclude []int64) (int, error) {
+	allowance := -1
+	for _, limit := range []struct {
+		max    int
+		window time.Duration
+	}{{s.MaxMessagesPerMinute, time.Minute}, {s.MaxMessagesPerHour, time.Hour}} {
+		if limit.max == 0 {
+			continue
+		}
+		sent, err := s.sentSince(t.Add(-limit.window), exclude)
+		if err != nil {
+			return 0, err
+		}
+		if remaining := limit.max - sent; allowance == -1 || remaining < allowance {
+			allowance = remaining
+		}
+	}
+	if allowance < 0 {
+		allowance = 0
+	}
+	return allowance, nil
+}
+
+// ThrottleMailLogs returns the maillogs which can be sent at the given time
+// without their sending profiles exceeding their sending limits. The rest are
+// throttled, being rescheduled one sending interval apart so that they're
+// sent as the limits allow, and aren't returned. Maillogs are sent in the
+// order they're given.
+func ThrottleMailLogs(ms []*MailLog, t time.Time) ([]*MailLog, error) {
+	if len(ms) == 0 {
+		return ms, nil
+	}
+	ids := []int64{}
+	sids := []int64{}
+	for _, m := range ms {
+		ids = append(ids, m.Id)
+		sids = append(sids, m.SMTPId)
+	}
+	ss := []SMTP{}
+	err := db.Where("id IN (?) AND (max_messages_per_minute > 0 OR max_messages_per_hour > 0)", sids).
+		Find(&ss).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(ss) == 0 {
+		return ms, nil
+	}
+	profiles := make(map[int64]*SMTP)
+	for i := range ss {
+		profiles[ss[i].Id] = &ss[i]
+	}
+	allowances := make(map[int64]int)
+	for _, s := range ss {
+		allowances[s.Id], err = s.sendAllowance(t, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+	throttled := make(map[int64]int)
+	send := []*MailLog{}
+	for _, m := range ms {
+		s, ok := profiles[m.SMTPId]
+		if !ok || allowances[s.Id] > 0 {
+			if ok {
+				allowances[s.Id]--
+			}
+			send = append(send, m)
+			continue
+		}
+		throttled[s.Id]++
+		// Maillogs which can't be rescheduled stay locked until their
+		// lease expires, so they're tried again later
+		err = m.Throttle(t.Add(time.Duration(throttled[s.Id]) * s.sendInterval()))
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	return send, nil
+}
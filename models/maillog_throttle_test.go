@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestThrottleMailLogs(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	total := len(campaign.Results)
+	ch.Assert(total > 1, check.Equals, true)
+	ch.Assert(db.Table("smtp").Where("id=?", campaign.SMTPId).
+		Update("max_messages_per_hour", 1).Error, check.Equals, nil)
+
+	// Only the profile's hourly limit is sent, and the rest are rescheduled
+	// without counting as a send attempt
+	now := time.Now().UTC()
+	ms, err := ClaimCampaignMailLogs(campaign.Id, InstanceId)
+	ch.Assert(err, check.Equals, nil)
+	send, err := ThrottleMailLogs(ms, now)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(send), check.Equals, 1)
+	ch.Assert(send[0].Id, check.Equals, ms[0].Id)
+	for _, m := range ms[1:] {
+		ch.Assert(m.Processing, check.Equals, false)
+		ch.Assert(m.SendAttempt, check.Equals, 0)
+		ch.Assert(m.SendDate.After(now), check.Equals, true)
+		r, err := GetResult(m.RId)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(r.SendDate.Equal(m.SendDate), check.Equals, true)
+	}
+	// Throttled maillogs are spread over the hour
+	ch.Assert(ms[1].SendDate.Sub(now), check.Equals, time.Hour)
+
+	// Emails which are still being sent count towards the limit
+	later, err := ClaimCampaignMailLogs(campaign.Id, InstanceId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(later), check.Equals, total-1)
+	send, err = ThrottleMailLogs(later, now)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(send), check.Equals, 0)
+
+	// As do the emails which have been sent
+	ch.Assert(ms[0].Success(), check.Equals, nil)
+	later, err = ClaimCampaignMailLogs(campaign.Id, InstanceId)
+	ch.Assert(err, check.Equals, nil)
+	send, err = ThrottleMailLogs(later, now)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(send), check.Equals, 0)
+
+	// Profiles without limits aren't throttled
+	ch.Assert(db.Table("smtp").Where("id=?", campaign.SMTPId).
+		Update("max_messages_per_hour", 0).Error, check.Equals, nil)
+	later, err = ClaimCampaignMailLogs(campaign.Id, InstanceId)
+	ch.Assert(err, check.Equals, nil)
+	send, err = ThrottleMailLogs(later, now)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(send), check.Equals, total-1)
+}
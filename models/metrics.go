@@ -0,0 +1,81 @@
+package models
+
+import (
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resultEvents counts the events recorded for results, labeled by the event's
+// status. The campaign isn't used as a label, since the number of campaigns
+// grows without bound.
+var resultEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "gophish_result_events_total",
+	Help: "The number of events recorded for campaign results, by status.",
+}, []string{"status"})
+
+// emailsProcessed counts the outcome of each attempt to send a campaign's
+// email, labeled as "sent", "error", "backoff" or "throttled".
+var emailsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "gophish_emails_total",
+	Help: "The number of campaign emails processed by the mailer, by outcome.",
+}, []string{"outcome"})
+
+// lastEmailSent is when a campaign's email was last sent successfully, so
+// that an alert can fire when sending stalls.
+var lastEmailSent = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "gophish_last_email_sent_timestamp_seconds",
+	Help: "The Unix time a campaign email was last sent successfully.",
+})
+
+// webhookFailures counts the webhook delivery attempts which failed, and
+// webhookDeadLetters the deliveries which failed every retry and were added
+// to the dead-letter queue.
+var (
+	webhookFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gophish_webhook_delivery_failures_total",
+		Help: "The number of webhook delivery attempts which failed.",
+	})
+	webhookDeadLetters = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gophish_webhook_dead_letters_total",
+		Help: "The number of webhook deliveries added to the dead-letter queue after every retry failed.",
+	})
+)
+
+// mailQueueDepth reports the number of maillogs which are due to be sent but
+// haven't been picked up by the worker, and the number being processed by
+// the mailer. Both are counted when the metrics are collected.
+var mailQueueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "gophish_mail_queue_depth",
+	Help: "The number of emails which are due to be sent but haven't been picked up by the worker.",
+}, func() float64 {
+	return float64(countQueuedMailLogs(time.Now().UTC()))
+})
+
+var mailProcessing = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "gophish_mail_processing",
+	Help: "The number of emails which the mailer is currently sending.",
+}, func() float64 {
+	count := 0
+	err := db.Model(&MailLog{}).Where("processing = ?", true).Count(&count).Error
+	if err != nil {
+		log.Warn(err)
+	}
+	return float64(count)
+})
+
+// RegisterMetrics registers the collectors for Gophish's metrics with the
+// given registerer. It should be called once at startup.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{
+		resultEvents, emailsProcessed, lastEmailSent, webhookFailures, webhookDeadLetters,
+		mailQueueDepth, mailProcessing,
+	} {
+		err := reg.Register(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
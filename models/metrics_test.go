@@ -0,0 +1,51 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestResultEventMetrics(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	opened := testutil.ToFloat64(resultEvents.WithLabelValues(EVENT_OPENED))
+	clicked := testutil.ToFloat64(resultEvents.WithLabelValues(EVENT_CLICKED))
+
+	ch.Assert(r.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(testutil.ToFloat64(resultEvents.WithLabelValues(EVENT_OPENED)), check.Equals, opened+1)
+	ch.Assert(testutil.ToFloat64(resultEvents.WithLabelValues(EVENT_CLICKED)), check.Equals, clicked+2)
+
+	reg := prometheus.NewRegistry()
+	ch.Assert(RegisterMetrics(reg), check.Equals, nil)
+	// Registering twice is reported rather than silently duplicating metrics
+	ch.Assert(RegisterMetrics(reg), check.NotNil)
+	n, err := testutil.GatherAndCount(reg, "gophish_result_events_total")
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(n > 0, check.Equals, true)
+}
+
+func (s *ModelsSuite) TestMailMetrics(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	ch.Assert(countQueuedMailLogs(time.Now().UTC()), check.Equals, len(campaign.Results))
+	ch.Assert(testutil.ToFloat64(mailQueueDepth), check.Equals, float64(len(campaign.Results)))
+
+	ms, err := GetMailLogsByCampaign(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	sent := testutil.ToFloat64(emailsProcessed.WithLabelValues("sent"))
+	errored := testutil.ToFloat64(emailsProcessed.WithLabelValues("error"))
+	ch.Assert(ms[0].Lock(), check.Equals, nil)
+	ch.Assert(testutil.ToFloat64(mailProcessing), check.Equals, float64(1))
+	ch.Assert(ms[0].Success(), check.Equals, nil)
+	ch.Assert(ms[1].Error(errors.New("test error")), check.Equals, nil)
+	ch.Assert(testutil.ToFloat64(emailsProcessed.WithLabelValues("sent")), check.Equals, sent+1)
+	ch.Assert(testutil.ToFloat64(emailsProcessed.WithLabelValues("error")), check.Equals, errored+1)
+	ch.Assert(testutil.ToFloat64(lastEmailSent) > 0, check.Equals, true)
+	ch.Assert(testutil.ToFloat64(mailQueueDepth), check.Equals, float64(0))
+	ch.Assert(testutil.ToFloat64(mailProcessing), check.Equals, float64(0))
+}
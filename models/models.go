@@ -11,6 +11,7 @@ import (
 	"github.com/gophish/gophish/config"
 	log "github.com/gophish/gophish/logger"
 	"github.com/jinzhu/gorm"
+	_ "github.com/lib/pq"           // Blank import needed to import postgres
 	_ "github.com/mattn/go-sqlite3" // Blank import needed to import sqlite3
 )
 
@@ -18,25 +19,39 @@ var db *gorm.DB
 var err error
 
 const (
-	CAMPAIGN_IN_PROGRESS string = "In progress"
-	CAMPAIGN_QUEUED      string = "Queued"
-	CAMPAIGN_CREATED     string = "Created"
-	CAMPAIGN_EMAILS_SENT string = "Emails Sent"
-	CAMPAIGN_COMPLETE    string = "Completed"
-	EVENT_SENT           string = "Email Sent"
-	EVENT_SENDING_ERROR  string = "Error Sending Email"
-	EVENT_OPENED         string = "Email Opened"
-	EVENT_CLICKED        string = "Clicked Link"
-	EVENT_DATA_SUBMIT    string = "Submitted Data"
-	EVENT_REPORTED       string = "Email Reported"
-	EVENT_PROXY_REQUEST  string = "Proxied request"
-	STATUS_SUCCESS       string = "Success"
-	STATUS_QUEUED        string = "Queued"
-	STATUS_SENDING       string = "Sending"
-	STATUS_UNKNOWN       string = "Unknown"
-	STATUS_SCHEDULED     string = "Scheduled"
-	STATUS_RETRY         string = "Retrying"
-	ERROR                string = "Error"
+	CAMPAIGN_IN_PROGRESS     string = "In progress"
+	CAMPAIGN_QUEUED          string = "Queued"
+	CAMPAIGN_CREATED         string = "Created"
+	CAMPAIGN_EMAILS_SENT     string = "Emails Sent"
+	CAMPAIGN_COMPLETE        string = "Completed"
+	CAMPAIGN_PAUSED          string = "Paused"
+	CAMPAIGN_DRY_RUN         string = "Dry Run"
+	CAMPAIGN_ARCHIVED        string = "Archived"
+	EVENT_SENT               string = "Email Sent"
+	EVENT_SENDING_ERROR      string = "Error Sending Email"
+	EVENT_OPENED             string = "Email Opened"
+	EVENT_CLICKED            string = "Clicked Link"
+	EVENT_DATA_SUBMIT        string = "Submitted Data"
+	EVENT_MFA_SUBMIT         string = "Submitted MFA"
+	EVENT_REPORTED           string = "Email Reported"
+	EVENT_PROXY_REQUEST      string = "Proxied request"
+	EVENT_EXCLUDED           string = "Excluded From Report"
+	EVENT_HELD               string = "Sending Held"
+	EVENT_RELEASED           string = "Sending Released"
+	EVENT_ATTACHMENT         string = "Opened Attachment"
+	EVENT_LINK_EXPIRED       string = "Expired Link Accessed"
+	EVENT_PLACEMENT          string = "Inbox Placement Recorded"
+	EVENT_BOUNCED            string = "Email Bounced"
+	EVENT_REPLIED            string = "Email Replied"
+	EVENT_BOT_CLICK          string = "Suspected Bot Click"
+	EVENT_TRAINING_COMPLETED string = "Training Completed"
+	STATUS_SUCCESS           string = "Success"
+	STATUS_QUEUED            string = "Queued"
+	STATUS_SENDING           string = "Sending"
+	STATUS_UNKNOWN           string = "Unknown"
+	STATUS_SCHEDULED         string = "Scheduled"
+	STATUS_RETRY             string = "Retrying"
+	ERROR                    string = "Error"
 )
 
 // Flash is used to hold flash information for use in templates.
@@ -67,6 +82,10 @@ func chooseDBDriver(name, openStr string) goose.DBDriver {
 		d.Import = "github.com/go-sql-driver/mysql"
 		d.Dialect = &goose.MySqlDialect{}
 
+	case "postgres":
+		d.Import = "github.com/lib/pq"
+		d.Dialect = &goose.PostgresDialect{}
+
 	// Default database is sqlite3
 	default:
 		d.Import = "github.com/mattn/go-sqlite3"
@@ -106,12 +125,88 @@ func Setup() error {
 		log.Error(err)
 		return err
 	}
+	configureWorker(config.Conf.WorkerConf)
+	configureSending(config.Conf.Sending)
+	configureResultStore(config.Conf.ResultStore)
+	configureDuplicateOpens(config.Conf.PhishConf.DuplicateOpens)
+	configureLinkExpiry(config.Conf.PhishConf.LinkExpiry)
+	configureHoneypots(config.Conf.PhishConf.HoneypotFields)
+	configureReverseDNS(config.Conf.PhishConf.ReverseDNS)
+	err = configureRecipientIds(config.Conf.RecipientIds)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = configureRetention(config.Conf.Retention)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = configureShortener(config.Conf.URLShortener)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = configureProxy(config.Conf.PhishConf)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = configureWebhook(config.Conf.Webhook)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = configureBounce(config.Conf.Bounce)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = configurePasswordBreach(config.Conf.PasswordBreach)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	configureEmailProviders(config.Conf.EmailProviders)
+	err = configureGeoSuspicion(config.Conf.GeoSuspicion)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = configureResilience(config.Conf.Resilience)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = configureCorporateNetworks(config.Conf.Corporate)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = configureSuppressedEmails(config.Conf.Validation.SuppressedEmails)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if config.Conf.ArchivePath != "" {
+		ArchivePath = config.Conf.ArchivePath
+	}
+	if config.Conf.GeoIPASNPath != "" {
+		GeoIPASNDatabasePath = config.Conf.GeoIPASNPath
+	}
+	// A missing GeoIP database only disables geolocation, so don't fail
+	// to start
+	err = configureGeoIP(config.Conf.GeoIPPath)
+	if err != nil {
+		log.Warnf("GeoIP database unavailable, results won't be geolocated: %s", err)
+	}
 	// Create the admin user if it doesn't exist
 	var userCount int64
 	db.Model(&User{}).Count(&userCount)
 	if userCount == 0 {
 		initUser := User{
 			Username: "admin",
+			Role:     ROLE_ADMIN,
 			Hash:     "$2a$10$IYkPp0.QsM81lYYPrQx6W.U6oQGw7wMpozrKhKAHUBVL4mkm/EvAS", //gophish
 		}
 		initUser.ApiKey = generateSecureKey()
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"fmt"
+	"os"
 	"testing"
 
 	"github.com/gophish/gophish/config"
@@ -14,10 +16,17 @@ type ModelsSuite struct{}
 
 var _ = check.Suite(&ModelsSuite{})
 
+// The suite runs against an in-memory sqlite3 database, unless the
+// GOPHISH_TEST_DB_NAME and GOPHISH_TEST_DB_PATH environment variables name
+// another database to run the migrations and tests against, such as postgres.
 func (s *ModelsSuite) SetUpSuite(c *check.C) {
 	config.Conf.DBName = "sqlite3"
 	config.Conf.DBPath = ":memory:"
-	config.Conf.MigrationsPath = "../db/db_sqlite3/migrations/"
+	if name := os.Getenv("GOPHISH_TEST_DB_NAME"); name != "" {
+		config.Conf.DBName = name
+		config.Conf.DBPath = os.Getenv("GOPHISH_TEST_DB_PATH")
+	}
+	config.Conf.MigrationsPath = fmt.Sprintf("../db/db_%s/migrations/", config.Conf.DBName)
 	err := Setup()
 	if err != nil {
 		c.Fatalf("Failed creating database: %v", err)
@@ -29,16 +38,36 @@ func (s *ModelsSuite) TearDownTest(c *check.C) {
 	// used in this test suite they will need to be cleaned up here.
 	db.Delete(Group{})
 	db.Delete(Target{})
+	db.Delete(TargetAttribute{})
 	db.Delete(GroupTarget{})
 	db.Delete(SMTP{})
+	db.Delete(SMS{})
 	db.Delete(Page{})
-	db.Delete(Result{})
+	db.Unscoped().Delete(Result{})
 	db.Delete(MailLog{})
+	db.Delete(Event{})
+	db.Delete(SendAttempt{})
+	db.Delete(Snapshot{})
 	db.Delete(Campaign{})
+	db.Delete(CampaignVariant{})
+	db.Delete(CampaignStep{})
+	db.Delete(APIKey{})
+	db.Delete(Team{})
+	db.Delete(AuditLog{})
+	db.Delete(Webhook{})
+	db.Delete(WebhookDeadLetter{})
+	db.Delete(Notification{})
+	db.Delete(NotificationDelivery{})
+	db.Delete(CampaignSchedule{})
+	db.Delete(Directory{})
+	db.Delete(DirectorySyncReport{})
+	db.Delete(DirectoryMember{})
+	db.Delete(PageAsset{})
+	db.Delete(ShortURL{})
 
 	// Reset users table to default state.
 	db.Not("id", 1).Delete(User{})
-	db.Model(User{}).Update("username", "admin")
+	db.Model(User{}).Updates(map[string]interface{}{"username": "admin", "role": ROLE_ADMIN, "team_id": 0})
 }
 
 func (s *ModelsSuite) createCampaignDependencies(ch *check.C, optional ...string) Campaign {
@@ -91,3 +120,26 @@ func (s *ModelsSuite) createCampaign(ch *check.C) Campaign {
 	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
 	return c
 }
+
+func (s *ModelsSuite) createCampaignWithTargets(ch *check.C, ts []Target) Campaign {
+	c := s.createCampaignDependencies(ch)
+	g := c.Groups[0]
+	g.Targets = ts
+	ch.Assert(PutGroup(&g), check.Equals, nil)
+	c.Groups = []Group{g}
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+	return c
+}
+
+// generateTargets returns n targets with unique email addresses
+func generateTargets(n int) []Target {
+	ts := []Target{}
+	for i := 0; i < n; i++ {
+		ts = append(ts, Target{
+			Email:     fmt.Sprintf("target%d@example.com", i),
+			FirstName: "Target",
+			LastName:  fmt.Sprintf("%d", i),
+		})
+	}
+	return ts
+}
@@ -0,0 +1,372 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/gophish/notify"
+	"github.com/sirupsen/logrus"
+)
+
+// The campaign milestones which notifications can be sent for
+const (
+	NOTIFY_CAMPAIGN_LAUNCHED  string = "campaign_launched"
+	NOTIFY_FIRST_SUBMISSION   string = "first_submission"
+	NOTIFY_CLICK_RATE         string = "click_rate"
+	NOTIFY_CAMPAIGN_COMPLETED string = "campaign_completed"
+)
+
+// The ways a notification can be sent
+const (
+	NOTIFICATION_SLACK string = "slack"
+	NOTIFICATION_TEAMS string = "teams"
+	NOTIFICATION_EMAIL string = "email"
+)
+
+// NotificationMilestones are the campaign milestones which notifications can
+// be sent for
+var NotificationMilestones = []string{
+	NOTIFY_CAMPAIGN_LAUNCHED, NOTIFY_FIRST_SUBMISSION, NOTIFY_CLICK_RATE, NOTIFY_CAMPAIGN_COMPLETED,
+}
+
+// Notification pushes a message to a Slack or Teams incoming webhook, or
+// emails an operator, when a campaign reaches one of the given milestones.
+// Notifications without a campaign are sent for every campaign visible to
+// the user. Email notifications are sent using the campaign's sending
+// profile.
+//
+// Each notification is only sent once for each milestone of a campaign.
+type Notification struct {
+	Id            int64     `json:"id"`
+	UserId        int64     `json:"-"`
+	CampaignId    int64     `json:"campaign_id"`
+	Name          string    `json:"name"`
+	Type          string    `json:"type"`
+	URL           string    `json:"url"`
+	Email         string    `json:"email"`
+	Milestones    []string  `json:"milestones" sql:"-"`
+	MilestoneList string    `json:"-" gorm:"column:milestones"`
+	ClickRate     int       `json:"click_rate"`
+	ModifiedDate  time.Time `json:"modified_date"`
+}
+
+// NotificationDelivery records that a notification was sent for a milestone
+// of a campaign, so that it isn't sent again.
+type NotificationDelivery struct {
+	Id             int64     `json:"id"`
+	NotificationId int64     `json:"notification_id"`
+	CampaignId     int64     `json:"campaign_id"`
+	Milestone      string    `json:"milestone"`
+	SentDate       time.Time `json:"sent_date"`
+}
+
+// ErrNotificationNameNotSpecified is thrown when a notification has no name
+var ErrNotificationNameNotSpecified = errors.New("Notification name not specified")
+
+// ErrInvalidNotificationType is thrown when a notification isn't sent to
+// Slack, Teams or by email
+var ErrInvalidNotificationType = errors.New("Notification type must be slack, teams or email")
+
+// ErrInvalidNotificationURL is thrown when a Slack or Teams notification's
+// webhook URL isn't an absolute http or https URL
+var ErrInvalidNotificationURL = errors.New("Notification URL must be an http or https URL")
+
+// ErrInvalidNotificationEmail is thrown when an email notification's
+// address is invalid
+var ErrInvalidNotificationEmail = errors.New("Invalid notification email address")
+
+// ErrInvalidMilestone is thrown when a notification has no milestones, or
+// has one which doesn't exist
+var ErrInvalidMilestone = errors.New("Notification milestones must be one or more of campaign_launched, first_submission, click_rate or campaign_completed")
+
+// ErrInvalidClickRate is thrown when a click rate notification's threshold
+// isn't a percentage
+var ErrInvalidClickRate = errors.New("Click rate must be between 1 and 100")
+
+// ErrNotificationCampaignNotFound is thrown when a notification is sent for
+// a campaign which doesn't exist
+var ErrNotificationCampaignNotFound = errors.New("Notification campaign not found")
+
+// ErrNotificationNoSMTP is thrown when an email notification is sent for a
+// campaign without a sending profile
+var ErrNotificationNoSMTP = errors.New("Email notifications require a campaign with a sending profile")
+
+// Validate ensures that the notification has a name, a valid destination
+// and valid milestones, and that its campaign belongs to the user.
+func (n *Notification) Validate() error {
+	if n.Name == "" {
+		return ErrNotificationNameNotSpecified
+	}
+	switch n.Type {
+	case NOTIFICATION_SLACK, NOTIFICATION_TEAMS:
+		u, err := url.Parse(n.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidNotificationURL
+		}
+	case NOTIFICATION_EMAIL:
+		if _, err := mail.ParseAddress(n.Email); err != nil {
+			return ErrInvalidNotificationEmail
+		}
+	default:
+		return ErrInvalidNotificationType
+	}
+	if len(n.Milestones) == 0 {
+		return ErrInvalidMilestone
+	}
+	for _, m := range n.Milestones {
+		valid := false
+		for _, vm := range NotificationMilestones {
+			if m == vm {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return ErrInvalidMilestone
+		}
+		if m == NOTIFY_CLICK_RATE && (n.ClickRate < 1 || n.ClickRate > 100) {
+			return ErrInvalidClickRate
+		}
+	}
+	if n.CampaignId != 0 {
+		count := 0
+		err := db.Table("campaigns").Where("id=? and user_id in (?)", n.CampaignId, teamUserIds(n.UserId)).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNotificationCampaignNotFound
+		}
+	}
+	return nil
+}
+
+// HasMilestone returns whether or not the notification is sent for the
+// given milestone
+func (n *Notification) HasMilestone(milestone string) bool {
+	for _, m := range n.Milestones {
+		if m == milestone {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifier returns the notifier used to send the notification for the given
+// campaign.
+func (n *Notification) Notifier(c *Campaign) (notify.Notifier, error) {
+	switch n.Type {
+	case NOTIFICATION_SLACK:
+		return &notify.SlackNotifier{URL: n.URL}, nil
+	case NOTIFICATION_TEAMS:
+		return &notify.TeamsNotifier{URL: n.URL}, nil
+	case NOTIFICATION_EMAIL:
+		if c.SMTPId == 0 {
+			return nil, ErrNotificationNoSMTP
+		}
+		s, err := GetSMTP(c.SMTPId, c.UserId)
+		if err != nil {
+			return nil, err
+		}
+		d, err := s.GetDialer()
+		if err != nil {
+			return nil, err
+		}
+		return &notify.EmailNotifier{Dialer: d, From: s.FromAddress, To: n.Email}, nil
+	}
+	return nil, ErrInvalidNotificationType
+}
+
+// send sends the notification for the campaign's milestone in the
+// background, unless it's already been sent.
+func (n *Notification) send(c *Campaign, milestone string, subject string, text string) {
+	d := NotificationDelivery{
+		NotificationId: n.Id,
+		CampaignId:     c.Id,
+		Milestone:      milestone,
+		SentDate:       time.Now().UTC(),
+	}
+	// The unique index on the deliveries ensures only one of any concurrent
+	// events sends the notification
+	if err := db.Create(&d).Error; err != nil {
+		return
+	}
+	go func(n Notification, c Campaign) {
+		nt, err := n.Notifier(&c)
+		if err == nil {
+			err = nt.Notify(subject, text)
+		}
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"notification_id": n.Id,
+				"campaign_id":     c.Id,
+				"milestone":       milestone,
+			}).Error(err)
+		}
+	}(*n, *c)
+}
+
+// loadMilestones fills in the notification's milestones from the stored list
+func (n *Notification) loadMilestones() {
+	n.Milestones = []string{}
+	if n.MilestoneList != "" {
+		n.Milestones = strings.Split(n.MilestoneList, ",")
+	}
+}
+
+// GetNotifications returns the notifications visible to the given user. If a
+// campaign id is given, only the notifications sent for that campaign are
+// returned, including those sent for every campaign.
+func GetNotifications(uid int64, cid int64) ([]Notification, error) {
+	ns := []Notification{}
+	query := db.Where("user_id in (?)", teamUserIds(uid))
+	if cid != 0 {
+		query = query.Where("campaign_id in (?)", []int64{0, cid})
+	}
+	err := query.Order("id asc").Find(&ns).Error
+	if err != nil {
+		log.Error(err)
+		return ns, err
+	}
+	for i := range ns {
+		ns[i].loadMilestones()
+	}
+	return ns, nil
+}
+
+// GetNotification returns the notification, if it exists, specified by the
+// given id and user_id.
+func GetNotification(id int64, uid int64) (Notification, error) {
+	n := Notification{}
+	err := db.Where("id=? and user_id in (?)", id, teamUserIds(uid)).First(&n).Error
+	if err != nil {
+		return n, err
+	}
+	n.loadMilestones()
+	return n, nil
+}
+
+// PostNotification creates a new notification in the database.
+func PostNotification(n *Notification) error {
+	return PutNotification(n)
+}
+
+// PutNotification edits an existing notification in the database.
+func PutNotification(n *Notification) error {
+	err := n.Validate()
+	if err != nil {
+		return err
+	}
+	n.MilestoneList = strings.Join(n.Milestones, ",")
+	n.ModifiedDate = time.Now().UTC()
+	err = db.Save(n).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// DeleteNotification deletes the notification specified by the given id and
+// user_id, along with the record of where it's been sent.
+func DeleteNotification(id int64, uid int64) error {
+	n, err := GetNotification(id, uid)
+	if err != nil {
+		return err
+	}
+	err = db.Where("notification_id=?", n.Id).Delete(&NotificationDelivery{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Delete(&n).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// milestoneNotifications returns the notifications for the milestone of the
+// campaign owned by the given user.
+func milestoneNotifications(uid int64, cid int64, milestone string) []Notification {
+	ns, err := GetNotifications(uid, cid)
+	if err != nil {
+		return nil
+	}
+	matches := []Notification{}
+	for _, n := range ns {
+		if n.HasMilestone(milestone) {
+			matches = append(matches, n)
+		}
+	}
+	return matches
+}
+
+// notifyCampaignLaunched sends the notifications for the campaign launching
+func notifyCampaignLaunched(c *Campaign) {
+	for _, n := range milestoneNotifications(c.UserId, c.Id, NOTIFY_CAMPAIGN_LAUNCHED) {
+		n.send(c, NOTIFY_CAMPAIGN_LAUNCHED, fmt.Sprintf("Campaign launched: %s", c.Name),
+			fmt.Sprintf("The campaign \"%s\" has launched.", c.Name))
+	}
+}
+
+// notifyCampaignCompleted sends the notifications for the campaign being
+// completed
+func notifyCampaignCompleted(c *Campaign) {
+	for _, n := range milestoneNotifications(c.UserId, c.Id, NOTIFY_CAMPAIGN_COMPLETED) {
+		n.send(c, NOTIFY_CAMPAIGN_COMPLETED, fmt.Sprintf("Campaign completed: %s", c.Name),
+			fmt.Sprintf("The campaign \"%s\" has been completed.", c.Name))
+	}
+}
+
+// notifyResultMilestones sends the notifications for the first submission
+// and click rate milestones the campaign may have reached when the result's
+// status changed. It's called once the new status has been saved, so that
+// it's included in the campaign's stats.
+func notifyResultMilestones(r *Result) {
+	if r.Status != EVENT_CLICKED && r.Status != EVENT_DATA_SUBMIT {
+		return
+	}
+	clicks := milestoneNotifications(r.UserId, r.CampaignId, NOTIFY_CLICK_RATE)
+	submissions := []Notification{}
+	if r.Status == EVENT_DATA_SUBMIT {
+		submissions = milestoneNotifications(r.UserId, r.CampaignId, NOTIFY_FIRST_SUBMISSION)
+	}
+	if len(clicks) == 0 && len(submissions) == 0 {
+		return
+	}
+	c := Campaign{}
+	err := db.Where("id=?", r.CampaignId).First(&c).Error
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, n := range submissions {
+		n.send(&c, NOTIFY_FIRST_SUBMISSION, fmt.Sprintf("First submission: %s", c.Name),
+			fmt.Sprintf("%s was the first recipient to submit data in the campaign \"%s\".", r.Email, c.Name))
+	}
+	if len(clicks) == 0 {
+		return
+	}
+	s, err := getCampaignStats(c.Id)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if s.Total == 0 {
+		return
+	}
+	for _, n := range clicks {
+		if s.ClickedLink*100 < int64(n.ClickRate)*s.Total {
+			continue
+		}
+		n.send(&c, NOTIFY_CLICK_RATE, fmt.Sprintf("Click rate reached: %s", c.Name),
+			fmt.Sprintf("%d%% of recipients (%d of %d) have clicked the link in the campaign \"%s\".",
+				s.ClickedLink*100/s.Total, s.ClickedLink, s.Total, c.Name))
+	}
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestNotificationValidation(ch *check.C) {
+	n := Notification{UserId: 1, Type: NOTIFICATION_SLACK, URL: "https://hooks.slack.com/services/test"}
+	ch.Assert(PostNotification(&n), check.Equals, ErrNotificationNameNotSpecified)
+	n.Name = "Test Notification"
+	n.Type = "pager"
+	ch.Assert(PostNotification(&n), check.Equals, ErrInvalidNotificationType)
+	n.Type = NOTIFICATION_TEAMS
+	n.URL = "hooks.slack.com"
+	ch.Assert(PostNotification(&n), check.Equals, ErrInvalidNotificationURL)
+	n.Type = NOTIFICATION_EMAIL
+	ch.Assert(PostNotification(&n), check.Equals, ErrInvalidNotificationEmail)
+	n.Email = "operator@example.com"
+	ch.Assert(PostNotification(&n), check.Equals, ErrInvalidMilestone)
+	n.Milestones = []string{NOTIFY_CAMPAIGN_LAUNCHED, "campaign_paused"}
+	ch.Assert(PostNotification(&n), check.Equals, ErrInvalidMilestone)
+	n.Milestones = []string{NOTIFY_CAMPAIGN_LAUNCHED, NOTIFY_CLICK_RATE}
+	ch.Assert(PostNotification(&n), check.Equals, ErrInvalidClickRate)
+	n.ClickRate = 25
+	n.CampaignId = 1000
+	ch.Assert(PostNotification(&n), check.Equals, ErrNotificationCampaignNotFound)
+	n.CampaignId = 0
+	ch.Assert(PostNotification(&n), check.Equals, nil)
+
+	got, err := GetNotification(n.Id, 1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Milestones, check.DeepEquals, []string{NOTIFY_CAMPAIGN_LAUNCHED, NOTIFY_CLICK_RATE})
+}
+
+func (s *ModelsSuite) TestNotificationMilestones(ch *check.C) {
+	messages := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		messages <- strings.SplitN(payload["text"], "\n", 2)[0]
+	}))
+	defer ts.Close()
+
+	n := Notification{
+		UserId:     1,
+		Name:       "Milestones",
+		Type:       NOTIFICATION_SLACK,
+		URL:        ts.URL,
+		Milestones: NotificationMilestones,
+		ClickRate:  50,
+	}
+	ch.Assert(PostNotification(&n), check.Equals, nil)
+	expect := func(subject string) {
+		select {
+		case m := <-messages:
+			ch.Assert(m, check.Equals, subject)
+		case <-time.After(5 * time.Second):
+			ch.Fatalf("notification %q was not sent", subject)
+		}
+	}
+
+	campaign := s.createCampaign(ch)
+	expect("*Campaign launched: Test campaign*")
+
+	// Half of the recipients clicking the link reaches the click rate, which
+	// is only notified once
+	clicked := campaign.Results[0]
+	ch.Assert(clicked.HandleClickedLink(EventDetails{Browser: map[string]string{"user-agent": "test-agent"}}), check.Equals, nil)
+	expect("*Click rate reached: Test campaign*")
+
+	ch.Assert(clicked.HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	expect("*First submission: Test campaign*")
+	submitted := campaign.Results[1]
+	ch.Assert(submitted.HandleFormSubmit(EventDetails{}), check.Equals, nil)
+
+	ch.Assert(CompleteCampaign(campaign.Id, 1), check.Equals, nil)
+	expect("*Campaign completed: Test campaign*")
+
+	select {
+	case m := <-messages:
+		ch.Fatalf("unexpected notification %q", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ViewportParameter is the event payload parameter that tracking requests can
+// use to report the client's viewport, formatted as WIDTHxHEIGHT.
+const ViewportParameter = "viewport"
+
+// ScannerOpenDelay is the time after the email was sent within which an open
+// is more likely to be a mail scanner fetching the tracking pixel on delivery
+// than the recipient reading the email.
+var ScannerOpenDelay = 10 * time.Second
+
+// ScannerUserAgents are case-insensitive substrings of the User-Agent headers
+// sent by link scanners, crawlers and HTTP libraries rather than mail clients.
+var ScannerUserAgents = []string{
+	"bot", "crawler", "spider", "scanner", "python", "curl", "wget",
+	"go-http-client", "java/", "okhttp", "headless", "phantomjs",
+}
+
+// viewportPattern matches a viewport reported as WIDTHxHEIGHT
+var viewportPattern = regexp.MustCompile(`^[1-9][0-9]{1,4}x[1-9][0-9]{1,4}$`)
+
+// The adjustments made to the neutral human confidence score of an open by
+// each signal.
+const (
+	confidenceNeutral     = 0.5
+	confidenceFastOpen    = -0.3
+	confidenceSlowOpen    = 0.1
+	confidenceNoUserAgent = -0.3
+	confidenceScannerUA   = -0.4
+	confidenceBrowserUA   = 0.1
+	confidenceViewport    = 0.2
+	confidenceKeepAlive   = -0.3
+	confidenceKnownProxy  = -0.3
+)
+
+// openHumanConfidence returns a score from 0 to 1 describing how confident we
+// are that the open described by the given details was made by a person. The
+// score starts neutral and is adjusted by how soon after the email was sent
+// the open happened (if it was sent), the User-Agent, whether a viewport was
+// reported, whether the open was part of a keep-alive burst, and whether the
+// address belongs to a hosting network or Tor exit node.
+func openHumanConfidence(d EventDetails, sinceSent time.Duration, sent bool) float64 {
+	score := confidenceNeutral
+	if sent {
+		switch {
+		case sinceSent < ScannerOpenDelay:
+			score += confidenceFastOpen
+		case sinceSent > time.Minute:
+			score += confidenceSlowOpen
+		}
+	}
+	ua := strings.ToLower(d.Browser["user-agent"])
+	switch {
+	case ua == "":
+		score += confidenceNoUserAgent
+	case matchesAny(ua, ScannerUserAgents):
+		score += confidenceScannerUA
+	case strings.HasPrefix(ua, "mozilla/"):
+		score += confidenceBrowserUA
+	}
+	if viewportPattern.MatchString(d.Payload.Get(ViewportParameter)) {
+		score += confidenceViewport
+	}
+	if d.Browser["keepalive-burst"] == "true" {
+		score += confidenceKeepAlive
+	}
+	if addr := d.Browser["address"]; addr != "" && isKnownProxy(addr) {
+		score += confidenceKnownProxy
+	}
+	switch {
+	case score < 0:
+		return 0
+	case score > 1:
+		return 1
+	}
+	return score
+}
+
+// matchesAny returns whether or not s contains any of the given substrings,
+// ignoring case.
+func matchesAny(s string, substrs []string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, strings.ToLower(sub)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sentTime returns when the email was first sent to the result, and whether
+// or not it has been sent.
+func (r *Result) sentTime() (time.Time, bool, error) {
+	e := Event{}
+	err := db.Where("campaign_id=? and email=? and message=?", r.CampaignId, r.Email, EVENT_SENT).
+		Order("time asc, id asc").First(&e).Error
+	if err == gorm.ErrRecordNotFound {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return e.Time, true, nil
+}
+
+// OpenHumanConfidence returns the highest confidence, from 0 to 1, that any of
+// the result's opens was made by a person. The confidence recorded on each open
+// event is used, and is computed for opens recorded before confidence was
+// stored. Results which were never opened return 0.
+func (r *Result) OpenHumanConfidence() (float64, error) {
+	es, err := r.GetEvents()
+	if err != nil {
+		return 0, err
+	}
+	var sent time.Time
+	wasSent := false
+	best := 0.0
+	for _, e := range es {
+		if e.Message == EVENT_SENT && !wasSent {
+			sent, wasSent = e.Time, true
+		}
+		if e.Message != EVENT_OPENED {
+			continue
+		}
+		d, err := e.parseDetails()
+		if err != nil {
+			return 0, err
+		}
+		confidence := 0.0
+		if d.HumanConfidence != nil {
+			confidence = *d.HumanConfidence
+		} else {
+			confidence = openHumanConfidence(d, e.Time.Sub(sent), wasSent)
+		}
+		if confidence > best {
+			best = confidence
+		}
+	}
+	return best, nil
+}
+
+// GetCampaignConfidentOpenRate returns the fraction of results in the campaign
+// specified by the given id and user_id with an open whose human confidence is
+// at least the given threshold, giving analysts a tunable alternative to the
+// human open rate.
+func GetCampaignConfidentOpenRate(cid int64, uid int64, threshold float64) (float64, error) {
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil || len(rs) == 0 {
+		return 0, err
+	}
+	opened := 0
+	for _, r := range rs {
+		confidence, err := r.OpenHumanConfidence()
+		if err != nil {
+			return 0, err
+		}
+		if confidence > 0 && confidence >= threshold {
+			opened++
+		}
+	}
+	return float64(opened) / float64(len(rs)), nil
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"math"
+	"net/url"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestOpenHumanConfidence(ch *check.C) {
+	defer func(networks []string) { HostingNetworks = networks }(HostingNetworks)
+	HostingNetworks = []string{"203.0.113.0/24"}
+	campaign := s.createCampaignWithTargets(ch, generateTargets(3))
+	human, bot, unopened := campaign.Results[0], campaign.Results[1], campaign.Results[2]
+	for i := range campaign.Results {
+		ch.Assert(campaign.Results[i].HandleEmailSent(), check.Equals, nil)
+	}
+	// The human reads the email an hour after it was delivered
+	err := db.Model(&Event{}).Where("email=? and message=?", human.Email, EVENT_SENT).
+		Update("time", time.Now().UTC().Add(-time.Hour)).Error
+	ch.Assert(err, check.Equals, nil)
+
+	ch.Assert(human.HandleEmailOpened(EventDetails{
+		Payload: url.Values{ViewportParameter: {"1440x900"}},
+		Browser: map[string]string{
+			"address":    "198.51.100.7",
+			"user-agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36",
+		},
+	}), check.Equals, nil)
+	// The scanner fetches the pixel from a hosting network on delivery
+	ch.Assert(bot.HandleEmailOpened(EventDetails{
+		Browser: map[string]string{
+			"address":    "203.0.113.10",
+			"user-agent": "python-requests/2.19.1",
+		},
+	}), check.Equals, nil)
+
+	confidence, err := human.OpenHumanConfidence()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(confidence > 0.8, check.Equals, true, check.Commentf("human scored %f", confidence))
+	confidence, err = bot.OpenHumanConfidence()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(confidence < 0.2, check.Equals, true, check.Commentf("bot scored %f", confidence))
+	confidence, err = unopened.OpenHumanConfidence()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(confidence, check.Equals, 0.0)
+
+	// The score is stored on the open event
+	es, err := human.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	d, err := es[len(es)-1].parseDetails()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(d.HumanConfidence, check.NotNil)
+
+	// Aggregates can be thresholded on the score
+	rate, err := GetCampaignConfidentOpenRate(campaign.Id, campaign.UserId, 0.8)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(rate, check.Equals, 1.0/3.0)
+	rate, err = GetCampaignConfidentOpenRate(campaign.Id, campaign.UserId, 0)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(rate, check.Equals, 1.0/3.0)
+}
+
+func (s *ModelsSuite) TestOpenHumanConfidenceSignals(ch *check.C) {
+	browser := func(ua string) map[string]string {
+		return map[string]string{"user-agent": ua}
+	}
+	score := func(d EventDetails, sinceSent time.Duration, sent bool, expected float64) {
+		got := openHumanConfidence(d, sinceSent, sent)
+		ch.Assert(math.Abs(got-expected) < 1e-9, check.Equals, true, check.Commentf("scored %f, expected %f", got, expected))
+	}
+	mozilla := "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_13_5)"
+	// Unsent results only use the request signals
+	score(EventDetails{Browser: browser(mozilla)}, 0, false, 0.6)
+	score(EventDetails{Browser: browser(mozilla)}, time.Second, true, 0.3)
+	score(EventDetails{Browser: browser("")}, time.Hour, true, 0.3)
+	burst := browser(mozilla)
+	burst["keepalive-burst"] = "true"
+	score(EventDetails{Browser: burst}, time.Hour, true, 0.4)
+	// Invalid viewports are ignored
+	d := EventDetails{Browser: browser(mozilla), Payload: url.Values{ViewportParameter: {"0x0"}}}
+	score(d, time.Hour, true, 0.7)
+	// Scores are clamped
+	d = EventDetails{Browser: browser("curl/7.58.0")}
+	score(d, time.Second, true, 0.0)
+}
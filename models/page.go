@@ -2,8 +2,11 @@ package models
 
 import (
 	"errors"
+	"net/mail"
+	"net/url"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/PuerkitoBio/goquery"
 	log "github.com/gophish/gophish/logger"
@@ -19,6 +22,62 @@ type Page struct {
 	CapturePasswords   bool      `json:"capture_passwords" gorm:"column:capture_passwords"`
 	RedirectURL        string    `json:"redirect_url" gorm:"column:redirect_url"`
 	ModifiedDate       time.Time `json:"modified_date"`
+	// The capture policy controls what is stored of the data submitted to
+	// the page. See CapturePolicy for details.
+	CaptureMode           string `json:"capture_mode" gorm:"column:capture_mode"`
+	CapturePasswordLength int    `json:"capture_password_length" gorm:"column:capture_password_length"`
+	CaptureDropFields     string `json:"capture_drop_fields" gorm:"column:capture_drop_fields"`
+	// Pages with a proxy URL reverse-proxy the site at the URL instead of
+	// serving the page's HTML. Data submitted to the site is only recorded
+	// for the paths matching one of the capture rules, which are path
+	// patterns given one per line, such as /login or /oauth2/*/token. The
+	// phishing server's own paths, such as /track and /assets/, aren't
+	// proxied.
+	ProxyURL          string `json:"proxy_url" gorm:"column:proxy_url"`
+	ProxyCaptureRules string `json:"proxy_capture_rules" gorm:"column:proxy_capture_rules"`
+	// Honeypot fields are the names of hidden inputs on the page, separated
+	// by commas, which people can't see and so are only filled in by bots.
+	HoneypotFields string `json:"honeypot_fields" gorm:"column:honeypot_fields"`
+}
+
+// PageContext is the data landing pages are rendered with for a recipient
+type PageContext struct {
+	Result
+	URL      string
+	AssetURL string
+	From     string
+}
+
+// NewPageContext returns the data the campaign's landing page is rendered
+// with for the given result.
+func NewPageContext(c *Campaign, r Result) (PageContext, error) {
+	f, err := mail.ParseAddress(c.ProfileFor(&r).FromAddress)
+	if err != nil {
+		return PageContext{}, err
+	}
+	fn := f.Name
+	if fn == "" {
+		fn = f.Address
+	}
+
+	phishURL, err := url.Parse(c.URL)
+	if err != nil {
+		return PageContext{}, err
+	}
+	q := phishURL.Query()
+	q.Set(RecipientParameter, SignRecipientId(r.RId))
+	phishURL.RawQuery = q.Encode()
+
+	// Assets are served from the root of the phishing server, under the
+	// recipient's ID
+	assetURL := url.URL{Scheme: phishURL.Scheme, Host: phishURL.Host, Path: "/assets/" + SignRecipientId(r.RId)}
+
+	return PageContext{
+		Result:   r,
+		URL:      phishURL.String(),
+		AssetURL: assetURL.String(),
+		From:     fn,
+	}, nil
 }
 
 // ErrPageNameNotSpecified is thrown if the name of the landing page is blank.
@@ -60,6 +119,13 @@ func (p *Page) parseHTML() error {
 	return err
 }
 
+// Honeypots returns the names of the page's honeypot fields.
+func (p *Page) Honeypots() []string {
+	return strings.FieldsFunc(p.HoneypotFields, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
 // Validate ensures that a page contains the appropriate details
 func (p *Page) Validate() error {
 	if p.Name == "" {
@@ -70,13 +136,21 @@ func (p *Page) Validate() error {
 	if p.CapturePasswords && !p.CaptureCredentials {
 		p.CaptureCredentials = true
 	}
+	err := p.validateCapturePolicy()
+	if err != nil {
+		return err
+	}
+	err = p.validateProxy()
+	if err != nil {
+		return err
+	}
 	return p.parseHTML()
 }
 
 // GetPages returns the pages owned by the given user.
 func GetPages(uid int64) ([]Page, error) {
 	ps := []Page{}
-	err := db.Where("user_id=?", uid).Find(&ps).Error
+	err := db.Where("user_id in (?)", teamUserIds(uid)).Find(&ps).Error
 	if err != nil {
 		log.Error(err)
 		return ps, err
@@ -87,7 +161,7 @@ func GetPages(uid int64) ([]Page, error) {
 // GetPage returns the page, if it exists, specified by the given id and user_id.
 func GetPage(id int64, uid int64) (Page, error) {
 	p := Page{}
-	err := db.Where("user_id=? and id=?", uid, id).Find(&p).Error
+	err := db.Where("user_id in (?) and id=?", teamUserIds(uid), id).Find(&p).Error
 	if err != nil {
 		log.Error(err)
 	}
@@ -97,7 +171,7 @@ func GetPage(id int64, uid int64) (Page, error) {
 // GetPageByName returns the page, if it exists, specified by the given name and user_id.
 func GetPageByName(n string, uid int64) (Page, error) {
 	p := Page{}
-	err := db.Where("user_id=? and name=?", uid, n).Find(&p).Error
+	err := db.Where("user_id in (?) and name=?", teamUserIds(uid), n).Find(&p).Error
 	if err != nil {
 		log.Error(err)
 	}
@@ -123,6 +197,10 @@ func PostPage(p *Page) error {
 // Per the PUT Method RFC, it presumes all data for a page is provided.
 func PutPage(p *Page) error {
 	err := p.Validate()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
 	err = db.Where("id=?", p.Id).Save(p).Error
 	if err != nil {
 		log.Error(err)
@@ -133,7 +211,16 @@ func PutPage(p *Page) error {
 // DeletePage deletes an existing page in the database.
 // An error is returned if a page with the given user id and page id is not found.
 func DeletePage(id int64, uid int64) error {
-	err = db.Where("user_id=?", uid).Delete(Page{Id: id}).Error
+	q := db.Where("user_id in (?)", teamUserIds(uid)).Delete(Page{Id: id})
+	if q.Error != nil {
+		log.Error(q.Error)
+		return q.Error
+	}
+	// Only remove the assets of pages the user was able to delete
+	if q.RowsAffected == 0 {
+		return nil
+	}
+	err := db.Where("page_id=?", id).Delete(&PageAsset{}).Error
 	if err != nil {
 		log.Error(err)
 	}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+)
+
+// MaxPageAssetSize is the largest asset, in bytes, which can be uploaded for
+// a landing page
+var MaxPageAssetSize = 10 << 20
+
+// PageAsset is a static file, such as an image, stylesheet or script, which
+// is hosted by the phishing server for a landing page. Pages link to their
+// assets with {{.AssetURL}}, such as <img src="{{.AssetURL}}/logo.png">, so
+// that they don't need to be hosted elsewhere.
+type PageAsset struct {
+	Id     int64  `json:"id"`
+	PageId int64  `json:"-"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	// Content is the base64 encoded content of the asset. It isn't included
+	// when listing a page's assets.
+	Content      string    `json:"content,omitempty"`
+	ModifiedDate time.Time `json:"modified_date"`
+}
+
+// ErrInvalidPageAssetName is thrown when an asset's name isn't a relative
+// path, such as "css/style.css"
+var ErrInvalidPageAssetName = errors.New("Asset name must be a relative path of letters, numbers, dots, dashes and underscores")
+
+// ErrInvalidPageAssetContent is thrown when an asset's content isn't base64
+// encoded
+var ErrInvalidPageAssetContent = errors.New("Asset content must be base64 encoded")
+
+// ErrPageAssetTooLarge is thrown when an asset is larger than
+// MaxPageAssetSize
+var ErrPageAssetTooLarge = errors.New("Asset is too large")
+
+// pageAssetName matches the names assets can be given, which are served as
+// paths under the page's asset URL
+var pageAssetName = regexp.MustCompile(`^[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)*$`)
+
+// Validate checks the asset's name and content, detecting its content type
+// if one isn't given
+func (a *PageAsset) Validate() error {
+	if !pageAssetName.MatchString(a.Name) {
+		return ErrInvalidPageAssetName
+	}
+	for _, segment := range strings.Split(a.Name, "/") {
+		if segment == "." || segment == ".." {
+			return ErrInvalidPageAssetName
+		}
+	}
+	b, err := a.Data()
+	if err != nil {
+		return ErrInvalidPageAssetContent
+	}
+	if len(b) > MaxPageAssetSize {
+		return ErrPageAssetTooLarge
+	}
+	if a.Type == "" {
+		a.Type = mime.TypeByExtension(path.Ext(a.Name))
+	}
+	if a.Type == "" {
+		a.Type = http.DetectContentType(b)
+	}
+	return nil
+}
+
+// Data returns the decoded content of the asset
+func (a *PageAsset) Data() ([]byte, error) {
+	return base64.StdEncoding.DecodeString(a.Content)
+}
+
+// GetPageAssets returns the assets of the page with the given id, without
+// their content
+func GetPageAssets(pid int64) ([]PageAsset, error) {
+	as := []PageAsset{}
+	err := db.Select("id, page_id, name, type, modified_date").Where("page_id=?", pid).Order("name").Find(&as).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return as, err
+}
+
+// GetPageAsset returns the asset with the given id belonging to the page
+// with the given id
+func GetPageAsset(pid int64, id int64) (PageAsset, error) {
+	a := PageAsset{}
+	err := db.Where("page_id=? and id=?", pid, id).Find(&a).Error
+	return a, err
+}
+
+// GetPageAssetByName returns the asset with the given name belonging to the
+// page with the given id
+func GetPageAssetByName(pid int64, name string) (PageAsset, error) {
+	a := PageAsset{}
+	err := db.Where("page_id=? and name=?", pid, name).Find(&a).Error
+	return a, err
+}
+
+// PostPageAsset adds the asset to its page, replacing any existing asset with
+// the same name
+func PostPageAsset(a *PageAsset) error {
+	err := a.Validate()
+	if err != nil {
+		return err
+	}
+	existing, err := GetPageAssetByName(a.PageId, a.Name)
+	if err == nil {
+		a.Id = existing.Id
+	}
+	a.ModifiedDate = time.Now().UTC()
+	err = db.Save(a).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// DeletePageAsset deletes the asset with the given id from the page with the
+// given id
+func DeletePageAsset(pid int64, id int64) error {
+	err := db.Where("page_id=? and id=?", pid, id).Delete(&PageAsset{}).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
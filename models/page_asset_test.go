@@ -0,0 +1,79 @@
+package models
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestPageAssetValidate(ch *check.C) {
+	content := base64.StdEncoding.EncodeToString([]byte("body { color: red; }"))
+	valid := []string{"style.css", "css/style.css", "cdn.example.com/js/app-1.2_min.js"}
+	for _, name := range valid {
+		a := PageAsset{Name: name, Content: content}
+		ch.Assert(a.Validate(), check.Equals, nil, check.Commentf(name))
+	}
+	invalid := []string{"", "/style.css", "css/", "../style.css", "css/./style.css", "style sheet.css", "css//style.css"}
+	for _, name := range invalid {
+		a := PageAsset{Name: name, Content: content}
+		ch.Assert(a.Validate(), check.Equals, ErrInvalidPageAssetName, check.Commentf(name))
+	}
+
+	a := PageAsset{Name: "style.css", Content: "not base64!"}
+	ch.Assert(a.Validate(), check.Equals, ErrInvalidPageAssetContent)
+
+	// The content type is detected from the name, then the content
+	a = PageAsset{Name: "style.css", Content: content}
+	ch.Assert(a.Validate(), check.Equals, nil)
+	ch.Assert(strings.HasPrefix(a.Type, "text/css"), check.Equals, true)
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	a = PageAsset{Name: "logo", Content: base64.StdEncoding.EncodeToString(png)}
+	ch.Assert(a.Validate(), check.Equals, nil)
+	ch.Assert(a.Type, check.Equals, "image/png")
+	a = PageAsset{Name: "logo", Type: "image/x-icon", Content: base64.StdEncoding.EncodeToString(png)}
+	ch.Assert(a.Validate(), check.Equals, nil)
+	ch.Assert(a.Type, check.Equals, "image/x-icon")
+
+	defer func(max int) { MaxPageAssetSize = max }(MaxPageAssetSize)
+	MaxPageAssetSize = 4
+	a = PageAsset{Name: "style.css", Content: content}
+	ch.Assert(a.Validate(), check.Equals, ErrPageAssetTooLarge)
+}
+
+func (s *ModelsSuite) TestPostPageAsset(ch *check.C) {
+	p := Page{Name: "Asset Page", UserId: 1}
+	ch.Assert(PostPage(&p), check.Equals, nil)
+	a := PageAsset{PageId: p.Id, Name: "css/style.css", Content: base64.StdEncoding.EncodeToString([]byte("a"))}
+	ch.Assert(PostPageAsset(&a), check.Equals, nil)
+	logo := PageAsset{PageId: p.Id, Name: "logo.png", Content: base64.StdEncoding.EncodeToString([]byte("b"))}
+	ch.Assert(PostPageAsset(&logo), check.Equals, nil)
+
+	// Uploading an asset with the same name replaces it
+	replaced := PageAsset{PageId: p.Id, Name: "css/style.css", Content: base64.StdEncoding.EncodeToString([]byte("c"))}
+	ch.Assert(PostPageAsset(&replaced), check.Equals, nil)
+	ch.Assert(replaced.Id, check.Equals, a.Id)
+	got, err := GetPageAssetByName(p.Id, "css/style.css")
+	ch.Assert(err, check.Equals, nil)
+	b, err := got.Data()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(string(b), check.Equals, "c")
+
+	// Assets are listed without their content
+	as, err := GetPageAssets(p.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(as), check.Equals, 2)
+	ch.Assert(as[0].Name, check.Equals, "css/style.css")
+	ch.Assert(as[0].Content, check.Equals, "")
+	ch.Assert(as[1].Name, check.Equals, "logo.png")
+
+	ch.Assert(DeletePageAsset(p.Id, logo.Id), check.Equals, nil)
+	_, err = GetPageAsset(p.Id, logo.Id)
+	ch.Assert(err, check.Equals, gorm.ErrRecordNotFound)
+
+	// Deleting the page deletes its assets
+	ch.Assert(DeletePage(p.Id, 1), check.Equals, nil)
+	_, err = GetPageAsset(p.Id, a.Id)
+	ch.Assert(err, check.Equals, gorm.ErrRecordNotFound)
+}
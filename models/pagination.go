@@ -0,0 +1,68 @@
+package models
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// MaxPageSize is the largest number of campaigns or groups returned in a
+// page.
+var MaxPageSize = 1000
+
+// ErrInvalidSort is thrown when a list is sorted by a field it can't be
+// sorted on.
+var ErrInvalidSort = errors.New("Invalid sort field")
+
+// parseSort converts a comma separated list of fields into an ORDER BY clause
+// using the columns in the given map. Fields prefixed with "-" are sorted in
+// descending order. The id is always used as the final ordering, so that
+// pages don't skip or repeat records which sort equally.
+func parseSort(sort string, columns map[string]string) (string, error) {
+	order := []string{}
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		dir := "asc"
+		if strings.HasPrefix(field, "-") {
+			dir = "desc"
+			field = field[1:]
+		}
+		col, ok := columns[field]
+		if !ok {
+			return "", ErrInvalidSort
+		}
+		order = append(order, col+" "+dir)
+	}
+	order = append(order, "id asc")
+	return strings.Join(order, ", "), nil
+}
+
+// paginate sorts the query and limits it to the requested page, returning the
+// number of records which matched before the page was applied. Every record
+// is returned if neither a limit nor an offset is given.
+func paginate(query *gorm.DB, sort string, columns map[string]string, limit int, offset int) (*gorm.DB, int64, error) {
+	var total int64
+	order, err := parseSort(sort, columns)
+	if err != nil {
+		return query, total, err
+	}
+	err = query.Count(&total).Error
+	if err != nil {
+		return query, total, err
+	}
+	query = query.Order(order)
+	if limit > MaxPageSize || (limit <= 0 && offset > 0) {
+		limit = MaxPageSize
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if offset > 0 {
+		query = query.Offset(offset)
+	}
+	return query, total, nil
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	log "github.com/gophish/gophish/logger"
+)
+
+// PasswordBreachCheck determines whether or not submitted passwords are
+// checked against a breached password set. The check is disabled by default.
+var PasswordBreachCheck = false
+
+// PasswordBreachURL is the range endpoint of the k-anonymity breached
+// password API. The first five characters of the password's SHA-1 hash are
+// appended to it, and the API responds with the suffixes of every breached
+// hash with that prefix.
+var PasswordBreachURL = "https://api.pwnedpasswords.com/range/"
+
+// PasswordBreachTimeout is the maximum amount of time to wait for the
+// breached password API to respond.
+var PasswordBreachTimeout = 2 * time.Second
+
+// ErrInvalidPasswordBreachURL is thrown when the breached password API's URL
+// isn't an absolute http or https URL
+var ErrInvalidPasswordBreachURL = errors.New("Breached password API URL must be an http or https URL")
+
+// configurePasswordBreach sets whether or not submitted passwords are checked
+// against the breached password set, and the API they're checked with,
+// returning an error if its URL isn't valid.
+func configurePasswordBreach(conf config.PasswordBreach) error {
+	if conf.URL != "" {
+		u, err := url.Parse(conf.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidPasswordBreachURL
+		}
+		PasswordBreachURL = conf.URL
+	}
+	if conf.TimeoutSeconds > 0 {
+		PasswordBreachTimeout = time.Duration(conf.TimeoutSeconds) * time.Second
+	}
+	PasswordBreachCheck = conf.Enabled
+	return nil
+}
+
+// PasswordFields are the names of the form fields which contain passwords.
+var PasswordFields = []string{"password", "passwd", "pwd"}
+
+// passwordBreached returns whether or not the given password appears in the
+// breached password set. Only the first five characters of the password's
+// hash are sent to the API, and neither the password nor its hash are kept.
+func passwordBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+	client := &http.Client{Timeout: PasswordBreachTimeout}
+	resp, err := client.Get(PasswordBreachURL + prefix)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status from breached password API: %s", resp.Status)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		// Each line is formatted as SUFFIX:COUNT
+		line := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if strings.EqualFold(line[0], suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// checkBreachedPasswords returns whether or not any of the passwords in the
+// submitted payload appear in the breached password set. The check fails open,
+// so passwords which couldn't be checked aren't considered breached.
+func checkBreachedPasswords(payload url.Values) bool {
+	for k, vs := range payload {
+		if !matchesField(k, PasswordFields) {
+			continue
+		}
+		for _, v := range vs {
+			if v == "" {
+				continue
+			}
+			breached, err := passwordBreached(v)
+			if err != nil {
+				log.Warn(err)
+				continue
+			}
+			if breached {
+				return true
+			}
+		}
+	}
+	return false
+}
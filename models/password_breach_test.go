@@ -0,0 +1,113 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	"gopkg.in/check.v1"
+)
+
+// breachedSuffix is the SHA-1 hash suffix of "password1", which the fake
+// breached password API reports as breached.
+const breachedSuffix = "214943DAAD1D64C102FAEC29DE4AFE9DA3D"
+
+func (s *ModelsSuite) TestHandleFormSubmitPasswordBreached(ch *check.C) {
+	requested := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		fmt.Fprintf(w, "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n%s:2413945\r\n", breachedSuffix)
+	}))
+	defer ts.Close()
+	defer func(enabled bool, u string) {
+		PasswordBreachCheck, PasswordBreachURL = enabled, u
+	}(PasswordBreachCheck, PasswordBreachURL)
+	ch.Assert(configurePasswordBreach(config.PasswordBreach{Enabled: true, URL: ts.URL + "/range/"}), check.Equals, nil)
+
+	campaign := s.createCampaign(ch)
+	breached, clean := campaign.Results[0], campaign.Results[1]
+	ch.Assert(breached.HandleFormSubmit(EventDetails{
+		Payload: url.Values{"username": {"user"}, "password": {"password1"}},
+	}), check.Equals, nil)
+	ch.Assert(clean.HandleFormSubmit(EventDetails{
+		Payload: url.Values{"username": {"user"}, "password": {"correct horse battery staple"}},
+	}), check.Equals, nil)
+
+	// Only the hash prefix is sent to the API
+	ch.Assert(len(requested), check.Equals, 2)
+	ch.Assert(requested[0], check.Equals, "/range/E38AD")
+	for _, p := range requested {
+		ch.Assert(strings.Contains(p, "password"), check.Equals, false)
+	}
+
+	got, err := GetResult(breached.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.PasswordBreached, check.Equals, true)
+	ch.Assert(got.Status, check.Equals, EVENT_DATA_SUBMIT)
+	got, err = GetResult(clean.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.PasswordBreached, check.Equals, false)
+
+	// The full hash is never stored with the event
+	es, err := breached.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	details := es[len(es)-1].Details
+	ch.Assert(strings.Contains(details, breachedSuffix), check.Equals, false)
+	ch.Assert(strings.Contains(details, `"password_breached":true`), check.Equals, true)
+}
+
+func (s *ModelsSuite) TestHandleFormSubmitPasswordBreachFailOpen(ch *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+	defer func(enabled bool, u string, timeout time.Duration) {
+		PasswordBreachCheck, PasswordBreachURL, PasswordBreachTimeout = enabled, u, timeout
+	}(PasswordBreachCheck, PasswordBreachURL, PasswordBreachTimeout)
+	PasswordBreachCheck = true
+	PasswordBreachURL = ts.URL + "/range/"
+	PasswordBreachTimeout = 20 * time.Millisecond
+
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	ch.Assert(r.HandleFormSubmit(EventDetails{
+		Payload: url.Values{"password": {"password1"}},
+	}), check.Equals, nil)
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, EVENT_DATA_SUBMIT)
+	ch.Assert(got.PasswordBreached, check.Equals, false)
+
+	// Nothing is checked unless the check is enabled
+	PasswordBreachCheck = false
+	ch.Assert(campaign.Results[1].HandleFormSubmit(EventDetails{
+		Payload: url.Values{"password": {"password1"}},
+	}), check.Equals, nil)
+}
+
+func (s *ModelsSuite) TestConfigurePasswordBreach(ch *check.C) {
+	defer func(enabled bool, u string, timeout time.Duration) {
+		PasswordBreachCheck, PasswordBreachURL, PasswordBreachTimeout = enabled, u, timeout
+	}(PasswordBreachCheck, PasswordBreachURL, PasswordBreachTimeout)
+	ch.Assert(configurePasswordBreach(config.PasswordBreach{Enabled: true, URL: "ftp://example.com/range/"}),
+		check.Equals, ErrInvalidPasswordBreachURL)
+	ch.Assert(PasswordBreachCheck, check.Equals, false)
+
+	// Settings which aren't given keep their defaults
+	ch.Assert(configurePasswordBreach(config.PasswordBreach{Enabled: true}), check.Equals, nil)
+	ch.Assert(PasswordBreachCheck, check.Equals, true)
+	ch.Assert(PasswordBreachURL, check.Equals, "https://api.pwnedpasswords.com/range/")
+	ch.Assert(PasswordBreachTimeout, check.Equals, 2*time.Second)
+
+	ch.Assert(configurePasswordBreach(config.PasswordBreach{
+		Enabled:        true,
+		URL:            "https://breaches.example.com/range/",
+		TimeoutSeconds: 5,
+	}), check.Equals, nil)
+	ch.Assert(PasswordBreachURL, check.Equals, "https://breaches.example.com/range/")
+	ch.Assert(PasswordBreachTimeout, check.Equals, 5*time.Second)
+}
@@ -0,0 +1,294 @@
+package models
+
+import (
+	"net/mail"
+	"net/url"
+
+	"github.com/gophish/gomail"
+	"github.com/gophish/gophish/mailer"
+	"github.com/jinzhu/gorm"
+)
+
+// EmailPreview is a campaign's email as it's rendered for a recipient,
+// without being sent, along with any problems found rendering it. The
+// rendered landing page is included by the handlers which can render it.
+type EmailPreview struct {
+	RId     string   `json:"id"`
+	Email   string   `json:"email"`
+	From    string   `json:"from"`
+	Subject string   `json:"subject"`
+	Text    string   `json:"text"`
+	HTML    string   `json:"html"`
+	Page    string   `json:"page,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// renderedEmail is a campaign's email rendered for a result, ready to be
+// written to a gomail.Message
+type renderedEmail struct {
+	EmailPreview
+	result      *Result
+	from        *mail.Address
+	headers     [][2]string
+	template    Template
+	context     templateContext
+	trackingURL *url.URL
+	errs        []error
+}
+
+// build renders the text with the email's template context, recording any
+// error as a problem with the email
+func (e *renderedEmail) build(text string) string {
+	s, err := buildTemplate(text, e.context)
+	if err != nil {
+		e.errs = append(e.errs, err)
+	}
+	return s
+}
+
+// renderEmail renders the campaign's email for the given result, using the
+// result's variant, localization and sending profile. Problems with the
+// template are recorded on the rendered email rather than returned, so that
+// the email can still be sent.
+func renderEmail(c *Campaign, r *Result) (*renderedEmail, error) {
+	t := c.VariantFor(r).Template.Localized(r.Locale)
+	profile := c.ProfileFor(r)
+	f, err := mail.ParseAddress(profile.FromAddress)
+	if err != nil {
+		return nil, err
+	}
+	fn := f.Name
+	if fn == "" {
+		fn = f.Address
+	}
+	phishURL, trackingURL, err := recipientURLs(c, r)
+	if err != nil {
+		return nil, err
+	}
+	e := &renderedEmail{
+		EmailPreview: EmailPreview{RId: r.RId, Email: r.Email, From: f.String()},
+		result:       r,
+		from:         f,
+		template:     t,
+		trackingURL:  trackingURL,
+		context: templateContext{
+			*r,
+			phishingURL{phishURL, &qrCodes{}, c.shortLinks(r)},
+			trackingURL.String(),
+			"<img alt='' style='display: none' src='" + trackingURL.String() + "'/>",
+			fn,
+		},
+	}
+	// Parse the customHeader templates, with the campaign's headers
+	// replacing the profile's
+	for _, header := range profile.Headers {
+		e.addHeader(header.Key, header.Value)
+	}
+	for _, header := range c.Headers {
+		e.addHeader(header.Key, header.Value)
+	}
+	e.Subject = e.build(t.Subject)
+	if t.Text != "" {
+		e.Text = e.build(t.Text)
+	}
+	if t.HTML != "" {
+		e.HTML = e.build(t.HTML)
+	}
+	return e, nil
+}
+
+// write fills in the headers and body of the message with the rendered email
+func (e *renderedEmail) write(msg *gomail.Message) {
+	msg.SetAddressHeader("From", e.from.Address, e.from.Name)
+	if e.result.MessageId != "" {
+		msg.SetHeader("Message-Id", e.result.MessageId)
+	}
+	// Send from the result's VERP address, so that bounces can be mapped
+	// back to the result
+	if rp := e.result.ReturnPath(); rp != "" {
+		msg.SetHeader("Return-Path", rp)
+	}
+	for _, h := range e.headers {
+		msg.SetHeader(h[0], h[1])
+	}
+	// don't set Subject header if the subject is empty
+	if len(e.Subject) != 0 {
+		msg.SetHeader("Subject", e.Subject)
+	}
+	msg.SetHeader("To", e.result.FormatAddress())
+	if e.template.Text != "" {
+		msg.SetBody("text/plain", e.Text)
+	}
+	if e.template.HTML != "" {
+		if e.template.Text == "" {
+			msg.SetBody("text/html", e.HTML)
+		} else {
+			msg.AddAlternative("text/html", e.HTML)
+		}
+	}
+	// Attach the files, embedding the recipient's tracking URL for the
+	// attachment in those which use it
+	for _, a := range e.template.Attachments {
+		u := attachmentURL(e.trackingURL, a.Name)
+		attachFile(msg, a, attachmentContext{e.context, u, attachmentTracker(u)})
+	}
+	e.context.qr.embed(msg)
+}
+
+// PreviewEmail returns the campaign's email as it would be sent to the given
+// result, without sending it or changing the result.
+func PreviewEmail(c *Campaign, r *Result) (EmailPreview, error) {
+	e, err := renderEmail(c, r)
+	if err != nil {
+		return EmailPreview{}, err
+	}
+	// Attachments are rendered as they're attached, so they're only checked
+	// for problems here
+	for _, a := range e.template.Attachments {
+		u := attachmentURL(e.trackingURL, a.Name)
+		_, err := a.Render(attachmentContext{e.context, u, attachmentTracker(u)})
+		if err != nil {
+			e.errs = append(e.errs, err)
+		}
+	}
+	for _, err := range e.errs {
+		e.Errors = append(e.Errors, err.Error())
+	}
+	return e.EmailPreview, nil
+}
+
+// TestSendRequest is a request to send a template to a single address,
+// rendered exactly as it would be for a campaign's result, so that the
+// template's variables can be checked before the campaign is launched. The
+// recipient is a synthetic result which isn't saved, so the tracking links in
+// the email aren't recorded. This type implements the mailer.Mail interface.
+type TestSendRequest struct {
+	Template Template `json:"template"`
+	Page     Page     `json:"page"`
+	SMTP     SMTP     `json:"smtp"`
+	URL      string   `json:"url"`
+	Target
+	ErrorChan chan (error) `json:"-"`
+	campaign  Campaign
+	result    Result
+}
+
+// Validate ensures the request names the recipient, template and landing
+// page.
+func (s *TestSendRequest) Validate() error {
+	switch {
+	case s.Email == "":
+		return ErrEmailNotSpecified
+	case s.Template.Name == "":
+		return ErrTemplateNotSpecified
+	case s.Page.Name == "":
+		return ErrPageNotSpecified
+	case s.SMTP.Name == "":
+		return ErrSMTPNotSpecified
+	}
+	return nil
+}
+
+// Prepare looks up the request's template, landing page and sending profile
+// from those owned by the given user, and creates the synthetic campaign and
+// result the email is rendered for.
+func (s *TestSendRequest) Prepare(uid int64) error {
+	t, err := GetTemplateByName(s.Template.Name, uid)
+	if err == gorm.ErrRecordNotFound {
+		return ErrTemplateNotFound
+	} else if err != nil {
+		return err
+	}
+	p, err := GetPageByName(s.Page.Name, uid)
+	if err == gorm.ErrRecordNotFound {
+		return ErrPageNotFound
+	} else if err != nil {
+		return err
+	}
+	smtp, err := GetSMTPByName(s.SMTP.Name, uid)
+	if err == gorm.ErrRecordNotFound {
+		return ErrSMTPNotFound
+	} else if err != nil {
+		return err
+	}
+	s.Template, s.Page, s.SMTP = t, p, smtp
+	rid, err := randomId()
+	if err != nil {
+		return err
+	}
+	s.campaign = Campaign{
+		UserId:     uid,
+		Name:       "Test Send",
+		URL:        s.URL,
+		Template:   t,
+		TemplateId: t.Id,
+		Page:       p,
+		PageId:     p.Id,
+		SMTP:       smtp,
+		SMTPId:     smtp.Id,
+	}
+	s.result = Result{
+		RId:       rid,
+		UserId:    uid,
+		Email:     s.Email,
+		FirstName: s.FirstName,
+		LastName:  s.LastName,
+		Position:  s.Position,
+		Phone:     s.Phone,
+		SMTPId:    smtp.Id,
+		Status:    STATUS_SENDING,
+	}
+	s.result.Locale = t.MatchLocale(s.Locale)
+	return s.result.setAttributes(s.Attributes)
+}
+
+// Campaign returns the synthetic campaign the email is rendered for
+func (s *TestSendRequest) Campaign() Campaign {
+	return s.campaign
+}
+
+// Result returns the synthetic result the email is rendered for
+func (s *TestSendRequest) Result() Result {
+	return s.result
+}
+
+// Preview returns the email as it's sent to the request's address
+func (s *TestSendRequest) Preview() (EmailPreview, error) {
+	return PreviewEmail(&s.campaign, &s.result)
+}
+
+// Backoff treats temporary errors as permanent since this is expected to be a
+// synchronous operation. It returns any errors given back to the ErrorChan
+func (s *TestSendRequest) Backoff(reason error) error {
+	s.ErrorChan <- reason
+	return nil
+}
+
+// Error returns an error on the ErrorChan.
+func (s *TestSendRequest) Error(err error) error {
+	s.ErrorChan <- err
+	return nil
+}
+
+// Success returns nil on the ErrorChan to indicate that the email was sent
+// successfully.
+func (s *TestSendRequest) Success() error {
+	s.ErrorChan <- nil
+	return nil
+}
+
+// Generate fills in the details of a gomail.Message with the email rendered
+// for the synthetic result.
+func (s *TestSendRequest) Generate(msg *gomail.Message) error {
+	e, err := renderEmail(&s.campaign, &s.result)
+	if err != nil {
+		return err
+	}
+	e.write(msg)
+	return nil
+}
+
+// GetDialer returns the mailer.Dialer for the request's sending profile
+func (s *TestSendRequest) GetDialer() (mailer.Dialer, error) {
+	return s.SMTP.GetDialer()
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gophish/gomail"
+	"github.com/jordan-wright/email"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestPreviewEmail(ch *check.C) {
+	c := s.createCampaign(ch)
+	r := c.Results[0]
+	p, err := PreviewEmail(&c, &r)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(p.RId, check.Equals, r.RId)
+	ch.Assert(p.Email, check.Equals, r.Email)
+	ch.Assert(p.From, check.Equals, "<test@test.com>")
+	ch.Assert(p.Subject, check.Equals, r.RId+" - Subject")
+	ch.Assert(p.Text, check.Equals, r.RId+" - Text")
+	ch.Assert(p.HTML, check.Equals, r.RId+" - HTML")
+	ch.Assert(len(p.Errors), check.Equals, 0)
+
+	// Previewing an email doesn't change the result
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.MessageId, check.Equals, "")
+	ch.Assert(got.Subject, check.Equals, "")
+
+	// Problems rendering the attachments are reported with the preview
+	c.Template.Attachments = []Attachment{Attachment{Name: "notes.txt", Type: "text/plain", Content: "e3suTWlzc2luZ30="}}
+	p, err = PreviewEmail(&c, &r)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(p.Errors), check.Equals, 1)
+}
+
+func (s *ModelsSuite) TestPostCampaignDryRun(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	c.DryRun = true
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+	ch.Assert(c.Status, check.Equals, CAMPAIGN_DRY_RUN)
+	for _, r := range c.Results {
+		ch.Assert(r.Status, check.Equals, STATUS_SCHEDULED)
+	}
+
+	// The maillogs are created, but never queued to be sent
+	ms, err := GetMailLogsByCampaign(c.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ms), check.Equals, len(c.Results))
+	ms, err = GetQueuedMailLogs(time.Now().UTC().Add(time.Hour))
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ms), check.Equals, 0)
+	ch.Assert(PauseCampaign(c.Id, c.UserId), check.Equals, ErrCampaignNotPausable)
+}
+
+func (s *ModelsSuite) TestTestSendRequest(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	req := &TestSendRequest{}
+	ch.Assert(req.Validate(), check.Equals, ErrEmailNotSpecified)
+	req.Email = "recipient@example.com"
+	ch.Assert(req.Validate(), check.Equals, ErrTemplateNotSpecified)
+	req.Template.Name = c.Template.Name
+	ch.Assert(req.Validate(), check.Equals, ErrPageNotSpecified)
+	req.Page.Name = c.Page.Name
+	ch.Assert(req.Validate(), check.Equals, ErrSMTPNotSpecified)
+	req.SMTP.Name = "Missing"
+	ch.Assert(req.Validate(), check.Equals, nil)
+	ch.Assert(req.Prepare(c.UserId), check.Equals, ErrSMTPNotFound)
+
+	req.SMTP.Name = c.SMTP.Name
+	req.FirstName = "Test"
+	req.LastName = "Recipient"
+	req.URL = "http://phish.example.com"
+	ch.Assert(req.Prepare(c.UserId), check.Equals, nil)
+	r := req.Result()
+	ch.Assert(r.RId, check.Not(check.Equals), "")
+	ch.Assert(r.Email, check.Equals, req.Email)
+
+	msg := gomail.NewMessage()
+	ch.Assert(req.Generate(msg), check.Equals, nil)
+	msgBuff := &bytes.Buffer{}
+	_, err := msg.WriteTo(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	got, err := email.NewEmailFromReader(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.To, check.DeepEquals, []string{"\"Test Recipient\" <recipient@example.com>"})
+	ch.Assert(got.Subject, check.Equals, r.RId+" - Subject")
+	ch.Assert(string(got.Text), check.Equals, r.RId+" - Text")
+
+	// The synthetic result isn't saved
+	_, err = GetResult(r.RId)
+	ch.Assert(err, check.Not(check.Equals), nil)
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/gophish/processor"
+)
+
+// EventProcessors are given each event recorded for a result before it's
+// saved, if any event processors are configured.
+var EventProcessors *processor.Chain
+
+// errEventSuppressed is returned when creating an event which one of the
+// event processors suppressed
+var errEventSuppressed = errors.New("Event suppressed by an event processor")
+
+// suppressibleEvents are the events which event processors can suppress.
+// These record the recipient's activity, so suppressing them leaves the
+// result as if the activity never happened. Events recording gophish's own
+// actions, such as sending the email, can only be enriched.
+var suppressibleEvents = map[string]bool{
+	EVENT_OPENED:       true,
+	EVENT_CLICKED:      true,
+	EVENT_DATA_SUBMIT:  true,
+	EVENT_MFA_SUBMIT:   true,
+	EVENT_REPORTED:     true,
+	EVENT_ATTACHMENT:   true,
+	EVENT_LINK_EXPIRED: true,
+	EVENT_REPLIED:      true,
+	EVENT_BOT_CLICK:    true,
+}
+
+// processEvent runs the event about to be recorded for the result through the
+// event processors, applying the details they enrich it with. It returns
+// whether or not the event should be saved.
+func processEvent(c *Campaign, r *Result, e *Event) bool {
+	if EventProcessors == nil {
+		return true
+	}
+	pe := processor.Event{
+		CampaignId:   c.Id,
+		CampaignName: c.Name,
+		RId:          r.RId,
+		Email:        e.Email,
+		Message:      e.Message,
+		Time:         time.Now().UTC(),
+	}
+	if e.Details != "" {
+		pe.Details = json.RawMessage(e.Details)
+	}
+	keep := EventProcessors.Process(&pe)
+	e.Details = string(pe.Details)
+	if !keep && !suppressibleEvents[e.Message] {
+		log.Warnf("event processors can't suppress %q events", e.Message)
+		return true
+	}
+	return keep
+}
+
+// ignoreSuppressed returns nil if the error is because the event was
+// suppressed, which isn't a failure to record it.
+func ignoreSuppressed(err error) error {
+	if err == errEventSuppressed {
+		return nil
+	}
+	return err
+}
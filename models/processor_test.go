@@ -0,0 +1,62 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/gophish/gophish/processor"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestEventProcessors(ch *check.C) {
+	defer func() { EventProcessors = nil }()
+	EventProcessors = &processor.Chain{}
+	EventProcessors.Register("intel", processor.ProcessorFunc(func(e *processor.Event) (string, error) {
+		return processor.ActionKeep, e.Enrich(map[string]interface{}{"threat_score": 90})
+	}))
+	EventProcessors.Register("scanner", processor.ProcessorFunc(func(e *processor.Event) (string, error) {
+		d := EventDetails{}
+		json.Unmarshal(e.Details, &d)
+		if d.Browser["address"] == "192.0.2.1" {
+			return processor.ActionSuppress, nil
+		}
+		return processor.ActionKeep, nil
+	}))
+	c := s.createCampaign(ch)
+	r := c.Results[0]
+
+	// Suppressed events aren't saved, and don't change the result
+	scanner := EventDetails{Browser: map[string]string{"address": "192.0.2.1"}}
+	ch.Assert(r.HandleClickedLink(scanner), check.Equals, nil)
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, r.Status)
+	es, err := got.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	for _, e := range es {
+		ch.Assert(e.Message, check.Not(check.Equals), EVENT_CLICKED)
+	}
+
+	// Kept events are saved with the details they were enriched with
+	person := EventDetails{Browser: map[string]string{"address": "198.51.100.1"}}
+	ch.Assert(r.HandleClickedLink(person), check.Equals, nil)
+	got, err = GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, EVENT_CLICKED)
+	es, err = got.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	last := es[len(es)-1]
+	ch.Assert(last.Message, check.Equals, EVENT_CLICKED)
+	details := map[string]interface{}{}
+	ch.Assert(json.Unmarshal([]byte(last.Details), &details), check.Equals, nil)
+	ch.Assert(details["threat_score"], check.Equals, float64(90))
+
+	// Events recording gophish's own actions can't be suppressed
+	EventProcessors.Register("all", processor.ProcessorFunc(func(e *processor.Event) (string, error) {
+		return processor.ActionSuppress, nil
+	}), EVENT_SENT)
+	r = c.Results[1]
+	ch.Assert(r.HandleEmailSent(), check.Equals, nil)
+	got, err = GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, EVENT_SENT)
+}
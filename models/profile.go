@@ -0,0 +1,153 @@
+package models
+
+import (
+	"errors"
+	mathrand "math/rand"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// CampaignProfile is one of the sending profiles a campaign's emails are
+// spread across, so that no single server or address carries all of them.
+// Each result is assigned one of the profiles when the campaign is created,
+// according to the campaign's profile rotation, and its email is always sent
+// through that profile.
+type CampaignProfile struct {
+	Id         int64 `json:"id"`
+	CampaignId int64 `json:"-"`
+	SMTPId     int64 `json:"-"`
+	SMTP       SMTP  `json:"smtp" sql:"-"`
+	Weight     int   `json:"weight"`
+}
+
+// The ways a campaign's results can be assigned its sending profiles.
+// Campaigns without a rotation use ROTATION_ROUND_ROBIN.
+const (
+	// ROTATION_ROUND_ROBIN assigns each profile in turn
+	ROTATION_ROUND_ROBIN string = "round_robin"
+	// ROTATION_WEIGHTED assigns the profiles at random in proportion to
+	// their weights
+	ROTATION_WEIGHTED string = "weighted"
+)
+
+// ErrInvalidProfileWeight is thrown when a campaign's sending profile has a
+// negative weight
+var ErrInvalidProfileWeight = errors.New("Sending profile weight must not be negative")
+
+// ErrInvalidProfileRotation is thrown when a campaign's profile rotation
+// isn't supported
+var ErrInvalidProfileRotation = errors.New("Profile rotation must be round_robin or weighted")
+
+// profileSource is the source of randomness used to assign weighted sending
+// profiles. It can be reseeded to make the assignments reproducible.
+var profileSource = mathrand.New(newLockedSource())
+
+// validateProfiles checks that every sending profile is named and has a valid
+// weight, and that the rotation is supported. Profiles without a weight are
+// given a weight of 1.
+func (c *Campaign) validateProfiles() error {
+	switch c.ProfileRotation {
+	case "", ROTATION_ROUND_ROBIN, ROTATION_WEIGHTED:
+	default:
+		return ErrInvalidProfileRotation
+	}
+	for i := range c.SendingProfiles {
+		p := &c.SendingProfiles[i]
+		switch {
+		case p.SMTP.Name == "":
+			return ErrSMTPNotSpecified
+		case p.Weight < 0:
+			return ErrInvalidProfileWeight
+		}
+		if p.Weight == 0 {
+			p.Weight = 1
+		}
+	}
+	return nil
+}
+
+// lookupProfiles fills in each of the campaign's sending profiles from those
+// owned by the given user.
+func (c *Campaign) lookupProfiles(uid int64) error {
+	for i := range c.SendingProfiles {
+		p := &c.SendingProfiles[i]
+		s, err := GetSMTPByName(p.SMTP.Name, uid)
+		if err == gorm.ErrRecordNotFound {
+			log.WithFields(logrus.Fields{
+				"smtp": p.SMTP.Name,
+			}).Error("Sending profile does not exist")
+			return ErrSMTPNotFound
+		} else if err != nil {
+			log.Error(err)
+			return err
+		}
+		p.SMTP = s
+		p.SMTPId = s.Id
+	}
+	return nil
+}
+
+// getProfiles retrieves the campaign's sending profiles from the database.
+func (c *Campaign) getProfiles() error {
+	err := db.Where("campaign_id=?", c.Id).Order("id asc").Find(&c.SendingProfiles).Error
+	if err != nil {
+		return err
+	}
+	for i := range c.SendingProfiles {
+		p := &c.SendingProfiles[i]
+		err = db.Table("smtp").Where("id=?", p.SMTPId).Find(&p.SMTP).Error
+		if err != nil {
+			if err != gorm.ErrRecordNotFound {
+				return err
+			}
+			p.SMTP = SMTP{Name: "[Deleted]"}
+			log.Warnf("%s: sending profile not found for campaign", err)
+		}
+		err = db.Where("smtp_id=?", p.SMTP.Id).Find(&p.SMTP.Headers).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// pickProfile returns the id of the sending profile assigned to the result
+// at the given position in the campaign, which is the campaign's own sending
+// profile if it doesn't rotate between profiles.
+func (c *Campaign) pickProfile(position int) int64 {
+	if len(c.SendingProfiles) == 0 {
+		return c.SMTPId
+	}
+	if c.ProfileRotation != ROTATION_WEIGHTED {
+		return c.SendingProfiles[position%len(c.SendingProfiles)].SMTPId
+	}
+	total := 0
+	for _, p := range c.SendingProfiles {
+		total += p.Weight
+	}
+	if total <= 0 {
+		return c.SMTPId
+	}
+	n := profileSource.Intn(total)
+	for _, p := range c.SendingProfiles {
+		if n < p.Weight {
+			return p.SMTPId
+		}
+		n -= p.Weight
+	}
+	return c.SMTPId
+}
+
+// ProfileFor returns the sending profile which sends the email to the given
+// result. Results which weren't assigned one of the campaign's sending
+// profiles use the campaign's sending profile.
+func (c *Campaign) ProfileFor(r *Result) SMTP {
+	for _, p := range c.SendingProfiles {
+		if r.SMTPId != 0 && p.SMTPId == r.SMTPId {
+			return p.SMTP
+		}
+	}
+	return c.SMTP
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"bytes"
+
+	"github.com/gophish/gomail"
+	"github.com/jordan-wright/email"
+	"gopkg.in/check.v1"
+)
+
+// createProfileCampaign creates a campaign which rotates between its
+// sending profile and a second one, using the given rotation and weights.
+func (s *ModelsSuite) createProfileCampaign(ch *check.C, ts []Target, rotation string, weights ...int) Campaign {
+	c := s.createCampaignDependencies(ch)
+	g := c.Groups[0]
+	g.Targets = ts
+	ch.Assert(PutGroup(&g), check.Equals, nil)
+	c.Groups = []Group{g}
+
+	smtp := SMTP{Name: "Second SMTP", UserId: 1, Host: "second.example.com", FromAddress: "Second <second@example.com>"}
+	ch.Assert(PostSMTP(&smtp), check.Equals, nil)
+	c.SMTP = SMTP{}
+	c.ProfileRotation = rotation
+	c.SendingProfiles = []CampaignProfile{
+		CampaignProfile{SMTP: SMTP{Name: "Test Page"}},
+		CampaignProfile{SMTP: SMTP{Name: smtp.Name}},
+	}
+	for i, w := range weights {
+		c.SendingProfiles[i].Weight = w
+	}
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+	return c
+}
+
+func (s *ModelsSuite) TestCampaignProfileValidate(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	c.SMTP = SMTP{}
+	c.SendingProfiles = []CampaignProfile{CampaignProfile{}}
+	ch.Assert(c.Validate(), check.Equals, ErrSMTPNotSpecified)
+	c.SendingProfiles = []CampaignProfile{CampaignProfile{SMTP: SMTP{Name: "Test Page"}, Weight: -1}}
+	ch.Assert(c.Validate(), check.Equals, ErrInvalidProfileWeight)
+	c.SendingProfiles = []CampaignProfile{CampaignProfile{SMTP: SMTP{Name: "Test Page"}}}
+	c.ProfileRotation = "random"
+	ch.Assert(c.Validate(), check.Equals, ErrInvalidProfileRotation)
+
+	c.ProfileRotation = ROTATION_WEIGHTED
+	c.SendingProfiles = []CampaignProfile{CampaignProfile{SMTP: SMTP{Name: "Missing"}}}
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, ErrSMTPNotFound)
+}
+
+func (s *ModelsSuite) TestPostCampaignRoundRobinProfiles(ch *check.C) {
+	c := s.createProfileCampaign(ch, generateTargets(10), "")
+	ch.Assert(c.SMTP.Name, check.Equals, "Test Page")
+
+	got, err := GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(got.SendingProfiles), check.Equals, 2)
+	ch.Assert(got.SendingProfiles[1].SMTP.Name, check.Equals, "Second SMTP")
+	ch.Assert(got.SendingProfiles[1].Weight, check.Equals, 1)
+
+	// The profiles are assigned in turn, and each maillog is sent through
+	// its result's profile
+	counts := make(map[int64]int)
+	for _, r := range got.Results {
+		counts[r.SMTPId]++
+		m := MailLog{}
+		ch.Assert(db.Where("r_id=?", r.RId).Find(&m).Error, check.Equals, nil)
+		ch.Assert(m.SMTPId, check.Equals, r.SMTPId)
+	}
+	ch.Assert(counts[got.SendingProfiles[0].SMTPId], check.Equals, 5)
+	ch.Assert(counts[got.SendingProfiles[1].SMTPId], check.Equals, 5)
+}
+
+func (s *ModelsSuite) TestPostCampaignWeightedProfiles(ch *check.C) {
+	profileSource.Seed(1)
+	c := s.createProfileCampaign(ch, generateTargets(200), ROTATION_WEIGHTED, 1, 3)
+	got, err := GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	counts := make(map[int64]int)
+	for _, r := range got.Results {
+		counts[r.SMTPId]++
+	}
+	second := counts[got.SendingProfiles[1].SMTPId]
+	ch.Assert(second > 120 && second < 180, check.Equals, true, check.Commentf("%d results given the second profile", second))
+}
+
+func (s *ModelsSuite) TestMailLogGenerateProfile(ch *check.C) {
+	c := s.createProfileCampaign(ch, generateTargets(2), ROTATION_ROUND_ROBIN)
+	c, err := GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	var result Result
+	for _, r := range c.Results {
+		if r.SMTPId == c.SendingProfiles[1].SMTPId {
+			result = r
+		}
+	}
+	ch.Assert(result.RId, check.Not(check.Equals), "")
+
+	m := &MailLog{}
+	ch.Assert(db.Where("r_id=?", result.RId).Find(m).Error, check.Equals, nil)
+	msg := gomail.NewMessage()
+	ch.Assert(m.Generate(msg), check.Equals, nil)
+	msgBuff := &bytes.Buffer{}
+	_, err = msg.WriteTo(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	got, err := email.NewEmailFromReader(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.From, check.Equals, "\"Second\" <second@example.com>")
+
+	d, err := m.GetDialer()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(d.(*Dialer).Host, check.Equals, "second.example.com")
+
+	// The profile which sent the email is recorded with the attempt
+	ch.Assert(m.Success(), check.Equals, nil)
+	as, err := result.SendAttempts()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(as), check.Equals, 1)
+	ch.Assert(as[0].Profile, check.Equals, "Second SMTP")
+}
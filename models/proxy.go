@@ -0,0 +1,128 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gophish/gophish/config"
+)
+
+// ProxyResolveTimeout is the maximum amount of time to wait for the host of
+// a landing page's proxy URL to be resolved
+var ProxyResolveTimeout = 5 * time.Second
+
+// ErrInvalidProxyURL is thrown when a landing page's proxy URL isn't an
+// absolute http or https URL
+var ErrInvalidProxyURL = errors.New("Proxy URL must be an http or https URL")
+
+// ErrInvalidProxyCaptureRule is thrown when one of a landing page's capture
+// rules isn't a valid path pattern
+var ErrInvalidProxyCaptureRule = errors.New("Capture rules must be path patterns starting with /, such as /login or /oauth2/*/token")
+
+// ErrProxyAddressNotAllowed is thrown when a landing page's proxy URL is at
+// an address which isn't public, such as a loopback or private address, and
+// isn't in one of the phish server's proxy allowed networks. Otherwise anyone
+// with a recipient ID could use the phish server to reach internal services.
+var ErrProxyAddressNotAllowed = errors.New("Proxy URL must be at a public address, or in one of the phish server's proxy_allowed_networks")
+
+// ErrProxyHostNotFound is thrown when the host of a landing page's proxy URL
+// can't be resolved
+var ErrProxyHostNotFound = errors.New("Proxy URL's host couldn't be resolved")
+
+// ErrInvalidProxyNetwork is thrown when one of the phish server's proxy
+// allowed networks isn't in CIDR notation
+var ErrInvalidProxyNetwork = errors.New("proxy_allowed_networks must be networks in CIDR notation")
+
+// configureProxy checks the networks proxied sites are allowed to be in
+func configureProxy(conf config.PhishServer) error {
+	for _, network := range conf.ProxyAllowedNetworks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return ErrInvalidProxyNetwork
+		}
+	}
+	return nil
+}
+
+// CheckProxyAddress returns ErrProxyAddressNotAllowed unless a landing page
+// can proxy a site at the address, which is the case for public addresses
+// and those in the phish server's proxy allowed networks.
+func CheckProxyAddress(ip net.IP) error {
+	if isPublicIP(ip) || inNetworks(ip.String(), config.Conf.PhishConf.ProxyAllowedNetworks) {
+		return nil
+	}
+	return ErrProxyAddressNotAllowed
+}
+
+// ResolveProxyHost returns the addresses of the host of a proxy URL, or an
+// error if any of them can't be proxied.
+func ResolveProxyHost(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return nil, ErrProxyHostNotFound
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		err = CheckProxyAddress(addr.IP)
+		if err != nil {
+			return nil, err
+		}
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// validateProxy checks the page's proxy URL and capture rules. The proxy
+// URL's host is resolved, so that it can't point at internal services.
+// It's checked again each time the site is proxied, since its addresses can
+// change after the page is saved.
+func (p *Page) validateProxy() error {
+	if p.ProxyURL == "" {
+		return nil
+	}
+	u, err := url.Parse(p.ProxyURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidProxyURL
+	}
+	for _, rule := range p.CaptureRules() {
+		if !strings.HasPrefix(rule, "/") {
+			return ErrInvalidProxyCaptureRule
+		}
+		if _, err := path.Match(rule, "/"); err != nil {
+			return ErrInvalidProxyCaptureRule
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ProxyResolveTimeout)
+	defer cancel()
+	_, err = ResolveProxyHost(ctx, u.Hostname())
+	return err
+}
+
+// CaptureRules returns the page's capture rules, one for each line of
+// ProxyCaptureRules
+func (p *Page) CaptureRules() []string {
+	rules := []string{}
+	for _, rule := range strings.Split(p.ProxyCaptureRules, "\n") {
+		rule = strings.TrimSpace(rule)
+		if rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// CapturesProxyRequest returns whether or not data submitted to the given
+// path of a proxied site is recorded, which is the case when the path
+// matches one of the page's capture rules
+func (p *Page) CapturesProxyRequest(requestPath string) bool {
+	for _, rule := range p.CaptureRules() {
+		if ok, _ := path.Match(rule, requestPath); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"github.com/gophish/gophish/config"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestPageValidateProxy(ch *check.C) {
+	p := Page{Name: "Proxy Page", ProxyURL: "https://203.0.113.10/login", ProxyCaptureRules: "/login\n\n /oauth2/*/token \n"}
+	ch.Assert(p.Validate(), check.Equals, nil)
+	ch.Assert(p.CaptureRules(), check.DeepEquals, []string{"/login", "/oauth2/*/token"})
+	ch.Assert(p.CapturesProxyRequest("/login"), check.Equals, true)
+	ch.Assert(p.CapturesProxyRequest("/oauth2/v2.0/token"), check.Equals, true)
+	ch.Assert(p.CapturesProxyRequest("/oauth2/v2.0/authorize"), check.Equals, false)
+	ch.Assert(p.CapturesProxyRequest("/login/help"), check.Equals, false)
+
+	for _, u := range []string{"portal.example.com", "ftp://portal.example.com", "https://", "http://%zz"} {
+		p = Page{Name: "Proxy Page", ProxyURL: u}
+		ch.Assert(p.Validate(), check.Equals, ErrInvalidProxyURL, check.Commentf(u))
+	}
+	for _, rule := range []string{"login", "/login[", "/login\nsubmit"} {
+		p = Page{Name: "Proxy Page", ProxyURL: "https://portal.example.com", ProxyCaptureRules: rule}
+		ch.Assert(p.Validate(), check.Equals, ErrInvalidProxyCaptureRule, check.Commentf(rule))
+	}
+
+	// Sites at internal addresses can only be proxied if they're allowed
+	defer func(networks []string) {
+		config.Conf.PhishConf.ProxyAllowedNetworks = networks
+	}(config.Conf.PhishConf.ProxyAllowedNetworks)
+	for _, u := range []string{"http://127.0.0.1:8080", "http://localhost", "http://10.1.2.3", "http://169.254.169.254/latest/meta-data", "http://[::1]", "http://[fd00::1]"} {
+		p = Page{Name: "Proxy Page", ProxyURL: u}
+		ch.Assert(p.Validate(), check.Equals, ErrProxyAddressNotAllowed, check.Commentf(u))
+	}
+	config.Conf.PhishConf.ProxyAllowedNetworks = []string{"10.0.0.0/8"}
+	p = Page{Name: "Proxy Page", ProxyURL: "http://10.1.2.3"}
+	ch.Assert(p.Validate(), check.Equals, nil)
+	p = Page{Name: "Proxy Page", ProxyURL: "http://127.0.0.1"}
+	ch.Assert(p.Validate(), check.Equals, ErrProxyAddressNotAllowed)
+	ch.Assert(configureProxy(config.PhishServer{ProxyAllowedNetworks: []string{"10.1.2.3"}}), check.Equals, ErrInvalidProxyNetwork)
+
+	// Capture rules aren't checked on pages without a proxy URL
+	p = Page{Name: "Static Page", ProxyCaptureRules: "/login"}
+	ch.Assert(p.Validate(), check.Equals, nil)
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/gophish/gomail"
+	"github.com/gophish/gophish/qr"
+)
+
+// QRCodeScale is the size, in pixels, of each module of the QR codes
+// embedded in emails
+var QRCodeScale = 6
+
+// qrCodes are the QR codes rendered into an email, which are embedded in the
+// email as inline images
+type qrCodes struct {
+	names  map[string]string
+	images []qrImage
+}
+
+// qrImage is a QR code image and the content ID it's embedded with
+type qrImage struct {
+	name string
+	png  []byte
+}
+
+// add returns the content ID of the QR code for the URL, encoding the code if
+// the email doesn't already include it
+func (q *qrCodes) add(u string) (string, error) {
+	if name, ok := q.names[u]; ok {
+		return name, nil
+	}
+	code, err := qr.Encode(u)
+	if err != nil {
+		return "", err
+	}
+	b, err := code.PNG(QRCodeScale)
+	if err != nil {
+		return "", err
+	}
+	if q.names == nil {
+		q.names = map[string]string{}
+	}
+	name := fmt.Sprintf("qr-%d.png", len(q.images)+1)
+	q.names[u] = name
+	q.images = append(q.images, qrImage{name, b})
+	return name, nil
+}
+
+// embed embeds the QR codes in the message as inline images
+func (q *qrCodes) embed(msg *gomail.Message) {
+	if q == nil {
+		return
+	}
+	for _, img := range q.images {
+		b := img.png
+		msg.Embed(img.name, gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(b)
+			return err
+		}))
+	}
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gophish/gomail"
+	"github.com/gophish/gophish/qr"
+	"github.com/jordan-wright/email"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestPhishingURLQR(ch *check.C) {
+	u, _ := url.Parse("http://example.com/?rid=1234")
+	p := phishingURL{url: u, qr: &qrCodes{}}
+	img, err := p.QR()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(img, check.Equals, `<img src="cid:qr-1.png" alt="QR code"/>`)
+	img, err = p.QR("poster")
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(img, check.Equals, `<img src="cid:qr-2.png" alt="QR code"/>`)
+	// The same code is only embedded once
+	img, err = p.QR()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(img, check.Equals, `<img src="cid:qr-1.png" alt="QR code"/>`)
+	ch.Assert(len(p.qr.images), check.Equals, 2)
+
+	// The codes hold the phishing URL, marked as coming from a QR code
+	code, err := qr.Encode(fmt.Sprintf("http://example.com/?%s=poster&%s=1&rid=1234", LinkParameter, QRParameter))
+	ch.Assert(err, check.Equals, nil)
+	expected, err := code.PNG(QRCodeScale)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(p.qr.images[1].png, check.DeepEquals, expected)
+
+	_, err = p.QR("poster", "flyer")
+	ch.Assert(err, check.Equals, ErrTooManyLinkNames)
+	// Text messages can't include images
+	sms := phishingURL{url: u}
+	img, err = sms.QR()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(img, check.Equals, "")
+}
+
+func (s *ModelsSuite) TestMailLogGenerateQR(ch *check.C) {
+	template := Template{
+		Name:    "QRTemplate",
+		UserId:  1,
+		Text:    "{{.URL}}",
+		HTML:    "<p>Scan to verify</p>{{.QR}}",
+		Subject: "Verify your account",
+	}
+	ch.Assert(template.Validate(), check.Equals, nil)
+	ch.Assert(PostTemplate(&template), check.Equals, nil)
+	campaign := s.createCampaignDependencies(ch)
+	campaign.URL = "http://127.0.0.1/"
+	campaign.Template = template
+	ch.Assert(PostCampaign(&campaign, campaign.UserId), check.Equals, nil)
+	result := campaign.Results[0]
+
+	m := &MailLog{}
+	err := db.Where("r_id=? AND campaign_id=?", result.RId, campaign.Id).Find(m).Error
+	ch.Assert(err, check.Equals, nil)
+	msg := gomail.NewMessage()
+	ch.Assert(m.Generate(msg), check.Equals, nil)
+	msgBuff := &bytes.Buffer{}
+	_, err = msg.WriteTo(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	raw := msgBuff.String()
+	ch.Assert(strings.Contains(raw, "Content-ID: <qr-1.png>"), check.Equals, true)
+	got, err := email.NewEmailFromReader(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(string(got.HTML), check.Equals, `<p>Scan to verify</p><img src="cid:qr-1.png" alt="QR code"/>`)
+}
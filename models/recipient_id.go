@@ -0,0 +1,162 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/gophish/gophish/config"
+)
+
+// RecipientIdLength is the number of characters in generated result IDs
+var RecipientIdLength = 7
+
+// RecipientIdAlphabet is the set of characters generated result IDs are
+// chosen from
+var RecipientIdAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// RecipientIdSigningKey is the secret the result IDs in tracking URLs are
+// signed with. IDs aren't signed if it's empty.
+var RecipientIdSigningKey = ""
+
+// RejectUnsignedRecipientIds determines whether or not unsigned result IDs
+// are rejected without being looked up once IDs are signed, rather than
+// accepted for campaigns created before signing was enabled.
+var RejectUnsignedRecipientIds = false
+
+// MaxRecipientIdLength is the longest result ID which can be generated, since
+// the IDs appear in every tracking URL
+const MaxRecipientIdLength = 64
+
+// MinRecipientIdBits is the least number of random bits a generated result
+// ID may contain
+var MinRecipientIdBits = 32.0
+
+// recipientIdSignatureLength is the number of bytes of the HMAC kept in
+// signed result IDs
+const recipientIdSignatureLength = 12
+
+// ErrInvalidRecipientIdAlphabet is thrown when the configured alphabet for
+// result IDs contains characters which aren't safe to use in URLs, or repeats
+// a character
+var ErrInvalidRecipientIdAlphabet = errors.New("Recipient ID alphabet must only contain unique letters, digits, - or _")
+
+// ErrInvalidRecipientIdLength is thrown when the configured length for result
+// IDs is negative or longer than MaxRecipientIdLength
+var ErrInvalidRecipientIdLength = errors.New("Recipient ID length must be between 1 and 64")
+
+// ErrWeakRecipientIds is thrown when the configured length and alphabet for
+// result IDs would make the IDs easy to guess
+var ErrWeakRecipientIds = errors.New("Recipient IDs are too short to be unguessable, use a longer length or alphabet")
+
+// ErrInvalidRecipientSignature is thrown when the signature of a result ID
+// in a tracking URL doesn't match the ID
+var ErrInvalidRecipientSignature = errors.New("Invalid recipient ID signature")
+
+// ErrUnsignedRecipientId is thrown when a result ID in a tracking URL isn't
+// signed, even though IDs are signed
+var ErrUnsignedRecipientId = errors.New("Recipient ID isn't signed")
+
+// configureRecipientIds applies the configured generation and signing
+// settings for result IDs, using the defaults for those which aren't given.
+func configureRecipientIds(conf config.RecipientIds) error {
+	length := conf.Length
+	if length == 0 {
+		length = RecipientIdLength
+	}
+	if length < 0 || length > MaxRecipientIdLength {
+		return ErrInvalidRecipientIdLength
+	}
+	alphabet := conf.Alphabet
+	if alphabet == "" {
+		alphabet = RecipientIdAlphabet
+	}
+	seen := make(map[rune]bool)
+	for _, c := range alphabet {
+		valid := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_'
+		if !valid || seen[c] {
+			return ErrInvalidRecipientIdAlphabet
+		}
+		seen[c] = true
+	}
+	if float64(length)*math.Log2(float64(len(alphabet))) < MinRecipientIdBits {
+		return ErrWeakRecipientIds
+	}
+	RecipientIdLength = length
+	RecipientIdAlphabet = alphabet
+	RecipientIdSigningKey = conf.SigningKey
+	RejectUnsignedRecipientIds = conf.RejectUnsigned
+	return nil
+}
+
+// recipientIdSignature returns the signature of the result ID
+func recipientIdSignature(rid string) string {
+	mac := hmac.New(sha256.New, []byte(RecipientIdSigningKey))
+	mac.Write([]byte(rid))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:recipientIdSignatureLength])
+}
+
+// SignRecipientId returns the result ID as it appears in tracking URLs,
+// which is followed by its signature if IDs are signed.
+func SignRecipientId(rid string) string {
+	if RecipientIdSigningKey == "" {
+		return rid
+	}
+	return rid + "." + recipientIdSignature(rid)
+}
+
+// VerifyRecipientId returns the result ID from an ID taken from a tracking
+// URL. If IDs are signed, ErrInvalidRecipientSignature is returned for IDs
+// with an invalid signature, so that forged or enumerated IDs are rejected
+// without being looked up. IDs without a signature are returned along with
+// ErrUnsignedRecipientId, since they're still accepted for campaigns created
+// before signing was enabled.
+func VerifyRecipientId(id string) (string, error) {
+	if RecipientIdSigningKey == "" {
+		return id, nil
+	}
+	i := strings.LastIndex(id, ".")
+	if i == -1 {
+		return id, ErrUnsignedRecipientId
+	}
+	rid, sig := id[:i], id[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(recipientIdSignature(rid))) {
+		return "", ErrInvalidRecipientSignature
+	}
+	return rid, nil
+}
+
+// GetResultByRecipientId returns the result identified by an ID taken from a
+// tracking URL, which is verified by VerifyRecipientId before it's looked up.
+// Unsigned IDs are only accepted for results in campaigns created before
+// signing was enabled, so that the emails those campaigns already sent keep
+// working, and ErrUnsignedRecipientId is returned for any others. If
+// RejectUnsignedRecipientIds is set, unsigned IDs are rejected without being
+// looked up.
+func GetResultByRecipientId(id string) (Result, error) {
+	rid, err := VerifyRecipientId(id)
+	if err == ErrUnsignedRecipientId && RejectUnsignedRecipientIds {
+		return Result{}, err
+	}
+	if err != nil && err != ErrUnsignedRecipientId {
+		return Result{}, err
+	}
+	r, lerr := GetResult(rid)
+	if lerr != nil {
+		return r, lerr
+	}
+	if err == ErrUnsignedRecipientId {
+		signed := []bool{}
+		lerr = db.Table("campaigns").Where("id=?", r.CampaignId).Pluck("signed_ids", &signed).Error
+		if lerr != nil {
+			return Result{}, lerr
+		}
+		if len(signed) == 0 || signed[0] {
+			return Result{}, err
+		}
+	}
+	return r, nil
+}
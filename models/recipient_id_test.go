@@ -0,0 +1,126 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/gophish/gophish/config"
+	"github.com/jinzhu/gorm"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestConfigureRecipientIds(ch *check.C) {
+	defer func(length int, alphabet, key string) {
+		RecipientIdLength, RecipientIdAlphabet, RecipientIdSigningKey = length, alphabet, key
+	}(RecipientIdLength, RecipientIdAlphabet, RecipientIdSigningKey)
+
+	ch.Assert(configureRecipientIds(config.RecipientIds{Alphabet: "abc.def"}), check.Equals, ErrInvalidRecipientIdAlphabet)
+	ch.Assert(configureRecipientIds(config.RecipientIds{Alphabet: "abca"}), check.Equals, ErrInvalidRecipientIdAlphabet)
+	ch.Assert(configureRecipientIds(config.RecipientIds{Length: 65}), check.Equals, ErrInvalidRecipientIdLength)
+	ch.Assert(configureRecipientIds(config.RecipientIds{Length: 5}), check.Equals, ErrWeakRecipientIds)
+	ch.Assert(configureRecipientIds(config.RecipientIds{Length: 9, Alphabet: "0123456789"}), check.Equals, ErrWeakRecipientIds)
+	// Invalid settings leave the current ones in place
+	ch.Assert(RecipientIdLength, check.Equals, 7)
+
+	ch.Assert(configureRecipientIds(config.RecipientIds{Length: 20, Alphabet: "0123456789abcdef"}), check.Equals, nil)
+	ids, err := GenerateIds(10)
+	ch.Assert(err, check.Equals, nil)
+	for _, id := range ids {
+		ch.Assert(len(id), check.Equals, 20)
+		ch.Assert(strings.Trim(id, "0123456789abcdef"), check.Equals, "")
+	}
+}
+
+func (s *ModelsSuite) TestSignRecipientId(ch *check.C) {
+	defer func(key string) { RecipientIdSigningKey = key }(RecipientIdSigningKey)
+
+	// IDs are used as they are unless a signing key is configured
+	RecipientIdSigningKey = ""
+	ch.Assert(SignRecipientId("abc1234"), check.Equals, "abc1234")
+	rid, err := VerifyRecipientId("abc1234")
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(rid, check.Equals, "abc1234")
+
+	RecipientIdSigningKey = "secret"
+	signed := SignRecipientId("abc1234")
+	ch.Assert(strings.HasPrefix(signed, "abc1234."), check.Equals, true)
+	rid, err = VerifyRecipientId(signed)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(rid, check.Equals, "abc1234")
+
+	forged := []string{"abc1235" + signed[7:], signed + "x", "abc1234."}
+	for _, id := range forged {
+		_, err = VerifyRecipientId(id)
+		ch.Assert(err, check.Equals, ErrInvalidRecipientSignature, check.Commentf(id))
+	}
+	for _, id := range []string{"abc1234", ""} {
+		_, err = VerifyRecipientId(id)
+		ch.Assert(err, check.Equals, ErrUnsignedRecipientId, check.Commentf(id))
+	}
+
+	// Changing the key invalidates the signatures made with the old one
+	RecipientIdSigningKey = "rotated"
+	_, err = VerifyRecipientId(signed)
+	ch.Assert(err, check.Equals, ErrInvalidRecipientSignature)
+}
+
+func (s *ModelsSuite) TestGetResultByRecipientIdSigningTransition(ch *check.C) {
+	defer func(key string) { RecipientIdSigningKey = key }(RecipientIdSigningKey)
+
+	// A campaign is launched before signing is enabled
+	RecipientIdSigningKey = ""
+	unsigned := s.createCampaign(ch)
+	legacy := unsigned.Results[0]
+	r, err := GetResultByRecipientId(legacy.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(r.RId, check.Equals, legacy.RId)
+
+	// Once signing is enabled, the unsigned IDs it already sent still work,
+	// as do signed ones
+	RecipientIdSigningKey = "secret"
+	r, err = GetResultByRecipientId(legacy.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(r.RId, check.Equals, legacy.RId)
+	r, err = GetResultByRecipientId(SignRecipientId(legacy.RId))
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(r.RId, check.Equals, legacy.RId)
+
+	// Campaigns launched after signing is enabled only accept signed IDs
+	signed := s.createCampaign(ch)
+	current := signed.Results[0]
+	r, err = GetResultByRecipientId(SignRecipientId(current.RId))
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(r.RId, check.Equals, current.RId)
+	_, err = GetResultByRecipientId(current.RId)
+	ch.Assert(err, check.Equals, ErrUnsignedRecipientId)
+	_, err = GetResultByRecipientId(current.RId + ".forged")
+	ch.Assert(err, check.Equals, ErrInvalidRecipientSignature)
+}
+
+func (s *ModelsSuite) TestGetResultByRecipientIdRejectUnsigned(ch *check.C) {
+	defer func(length int, alphabet, key string, reject bool) {
+		RecipientIdLength, RecipientIdAlphabet, RecipientIdSigningKey = length, alphabet, key
+		RejectUnsignedRecipientIds = reject
+	}(RecipientIdLength, RecipientIdAlphabet, RecipientIdSigningKey, RejectUnsignedRecipientIds)
+
+	RecipientIdSigningKey = ""
+	legacy := s.createCampaign(ch).Results[0]
+	err := configureRecipientIds(config.RecipientIds{SigningKey: "secret", RejectUnsigned: true})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(RejectUnsignedRecipientIds, check.Equals, true)
+
+	// Even the unsigned IDs of campaigns created before signing was enabled
+	// are rejected, without looking up the result
+	lookups := 0
+	db.Callback().Query().Before("gorm:query").Register("count_lookups", func(scope *gorm.Scope) {
+		lookups++
+	})
+	defer db.Callback().Query().Remove("count_lookups")
+	_, err = GetResultByRecipientId(legacy.RId)
+	ch.Assert(err, check.Equals, ErrUnsignedRecipientId)
+	ch.Assert(lookups, check.Equals, 0)
+
+	r, err := GetResultByRecipientId(SignRecipientId(legacy.RId))
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(r.RId, check.Equals, legacy.RId)
+	ch.Assert(lookups, check.Not(check.Equals), 0)
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"errors"
+	"io"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jordan-wright/email"
+)
+
+// MaxReplyBodyLength is the most, in bytes, of a reply's body which is stored
+// in the event details
+var MaxReplyBodyLength = 10000
+
+// ErrReplyNotMatched is thrown when a reply can't be mapped back to a result
+var ErrReplyNotMatched = errors.New("Reply doesn't match any result")
+
+// ErrAutoReply is thrown when a reply was sent automatically, such as an out
+// of office message, rather than by the recipient
+var ErrAutoReply = errors.New("Message is an automatic reply")
+
+// EventReply is a struct that wraps the details of a reply to the campaign
+// email
+type EventReply struct {
+	From    string `json:"from"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// isAutoReply returns whether or not the message headers mark it as being
+// sent automatically (RFC 3834), or as a delivery status notification.
+func isAutoReply(e *email.Email) bool {
+	if as := strings.ToLower(e.Headers.Get("Auto-Submitted")); as != "" && as != "no" {
+		return true
+	}
+	if e.Headers.Get("X-Autoreply") != "" || e.Headers.Get("X-Autorespond") != "" {
+		return true
+	}
+	switch strings.ToLower(e.Headers.Get("Precedence")) {
+	case "auto_reply", "bulk", "junk":
+		return true
+	}
+	return strings.HasPrefix(strings.ToLower(e.Headers.Get("Content-Type")), "multipart/report")
+}
+
+// findReplyResult returns the result whose email was replied to, matching the
+// In-Reply-To and References headers against the result's Message-Id, or the
+// recipient addresses against the result's VERP address.
+func findReplyResult(e *email.Email) (Result, error) {
+	ids := []string{}
+	for _, key := range []string{"In-Reply-To", "References"} {
+		for _, v := range e.Headers[key] {
+			ids = append(ids, strings.Fields(v)...)
+		}
+	}
+	for _, id := range ids {
+		rid, ok := parseMessageId(id)
+		if !ok {
+			continue
+		}
+		r, err := GetResult(rid)
+		if err == nil && normalizeMessageId(r.MessageId) == normalizeMessageId(id) {
+			return r, nil
+		}
+	}
+	addrs := append([]string{}, e.To...)
+	addrs = append(addrs, e.Cc...)
+	addrs = append(addrs, e.Headers["Delivered-To"]...)
+	for _, addr := range addrs {
+		rid, ok := parseReturnPath(addr)
+		if !ok {
+			continue
+		}
+		r, err := GetResult(rid)
+		if err == nil {
+			return r, nil
+		}
+	}
+	return Result{}, ErrReplyNotMatched
+}
+
+// ProcessReply records a reply to the campaign email against the result it
+// was sent to, which must belong to the user with the given id or their team.
+// Automatic replies, such as out of office messages, aren't recorded.
+func ProcessReply(raw io.Reader, uid int64) (Result, error) {
+	e, err := email.NewEmailFromReader(raw)
+	if err != nil {
+		return Result{}, err
+	}
+	if isAutoReply(e) {
+		return Result{}, ErrAutoReply
+	}
+	r, err := findReplyResult(e)
+	if err != nil {
+		return r, err
+	}
+	if !userInTeam(r.UserId, uid) {
+		return Result{}, ErrReplyNotMatched
+	}
+	details := EventReply{
+		From:    e.From,
+		Subject: e.Subject,
+		Body:    string(e.Text),
+	}
+	if a, err := mail.ParseAddress(e.From); err == nil {
+		details.From = a.Address
+	}
+	if details.Body == "" {
+		details.Body = string(e.HTML)
+	}
+	err = r.HandleEmailReply(details)
+	return r, err
+}
+
+// HandleEmailReply records that the recipient replied to the email. Replies
+// are tracked separately from the result's status.
+func (r *Result) HandleEmailReply(details EventReply) error {
+	if len(details.Body) > MaxReplyBodyLength {
+		// Don't cut a multi-byte character in half
+		n := MaxReplyBodyLength
+		for n > 0 && !utf8.RuneStart(details.Body[n]) {
+			n--
+		}
+		details.Body = details.Body[:n]
+	}
+	event, err := r.createEvent(EVENT_REPLIED, details)
+	if err != nil {
+		return ignoreSuppressed(err)
+	}
+	r.ModifiedDate = event.Time
+	return ResultStorage.Save(r)
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+func newReply(to, inReplyTo, extraHeaders, body string) string {
+	return fmt.Sprintf("From: Test One <test1@example.com>\r\n"+
+		"To: %s\r\n"+
+		"Subject: Re: Test\r\n"+
+		"In-Reply-To: %s\r\n"+
+		"%s"+
+		"Content-Type: text/plain; charset=UTF-8\r\n"+
+		"\r\n"+
+		"%s\r\n", to, inReplyTo, extraHeaders, body)
+}
+
+func (s *ModelsSuite) TestProcessReplyMessageId(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	ch.Assert(result.setMessageId(), check.Equals, nil)
+	ch.Assert(ResultStorage.Save(&result), check.Equals, nil)
+	ch.Assert(result.HandleEmailSent(), check.Equals, nil)
+
+	reply := newReply("foo@example.com", result.MessageId, "", "Is this legit?")
+	got, err := ProcessReply(strings.NewReader(reply), campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.RId, check.Equals, result.RId)
+
+	e := Event{}
+	err = db.Where("campaign_id=? and message=?", campaign.Id, EVENT_REPLIED).Find(&e).Error
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(e.Email, check.Equals, result.Email)
+	ch.Assert(e.Details, check.Equals,
+		`{"from":"test1@example.com","subject":"Re: Test","body":"Is this legit?\r\n"}`)
+
+	// Replies don't change the result's status
+	result, err = GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(result.Status, check.Equals, EVENT_SENT)
+
+	// Other users can't record replies against the result
+	_, err = ProcessReply(strings.NewReader(reply), campaign.UserId+1)
+	ch.Assert(err, check.Equals, ErrReplyNotMatched)
+
+	// Members of the owner's team can
+	t := Team{Name: "Red Team"}
+	ch.Assert(PostTeam(&t), check.Equals, nil)
+	ch.Assert(db.Model(&User{}).Where("id=?", campaign.UserId).Update("team_id", t.Id).Error, check.Equals, nil)
+	teammate := s.createTeamUser(ch, "operator", ROLE_OPERATOR, t.Id)
+	got, err = ProcessReply(strings.NewReader(reply), teammate.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.RId, check.Equals, result.RId)
+}
+
+func (s *ModelsSuite) TestProcessReplyVERP(ch *check.C) {
+	defer func(domain string) {
+		BounceDomain = domain
+	}(BounceDomain)
+	BounceDomain = "bounces.example.com"
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+
+	reply := newReply(result.ReturnPath(), "<unknown@example.com>", "", "Hello")
+	got, err := ProcessReply(strings.NewReader(reply), campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.RId, check.Equals, result.RId)
+
+	reply = newReply("foo@example.com", "<unknown@example.com>", "", "Hello")
+	_, err = ProcessReply(strings.NewReader(reply), campaign.UserId)
+	ch.Assert(err, check.Equals, ErrReplyNotMatched)
+}
+
+func (s *ModelsSuite) TestProcessReplyAutoReply(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	ch.Assert(result.setMessageId(), check.Equals, nil)
+	ch.Assert(ResultStorage.Save(&result), check.Equals, nil)
+
+	for _, h := range []string{"Auto-Submitted: auto-replied\r\n", "Precedence: bulk\r\n", "X-Autoreply: yes\r\n"} {
+		reply := newReply("foo@example.com", result.MessageId, h, "I'm out of the office")
+		_, err := ProcessReply(strings.NewReader(reply), campaign.UserId)
+		ch.Assert(err, check.Equals, ErrAutoReply)
+	}
+}
+
+func (s *ModelsSuite) TestHandleEmailReplyTruncates(ch *check.C) {
+	defer func(max int) {
+		MaxReplyBodyLength = max
+	}(MaxReplyBodyLength)
+	MaxReplyBodyLength = 4
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	ch.Assert(result.HandleEmailReply(EventReply{Body: "abcé"}), check.Equals, nil)
+	e := Event{}
+	err := db.Where("campaign_id=? and message=?", campaign.Id, EVENT_REPLIED).Find(&e).Error
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(e.Details, check.Equals, `{"from":"","subject":"","body":"abc"}`)
+}
@@ -0,0 +1,239 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gophish/gophish/report"
+)
+
+// CampaignReport is a complete report on a campaign, generated server-side
+// so that it can be delivered to people who don't use gophish.
+type CampaignReport struct {
+	Summary   CampaignSummary  `json:"summary"`
+	Generated time.Time        `json:"generated"`
+	Timeline  []ReportEvent    `json:"timeline"`
+	Targets   []ReportTarget   `json:"targets"`
+	Locations []ReportLocation `json:"locations"`
+}
+
+// ReportEvent is a single event in a campaign report's timeline
+type ReportEvent struct {
+	Time    time.Time `json:"time"`
+	Email   string    `json:"email"`
+	Message string    `json:"message"`
+}
+
+// ReportTarget is the outcome of a campaign for a single target
+type ReportTarget struct {
+	Email        string    `json:"email"`
+	FirstName    string    `json:"first_name"`
+	LastName     string    `json:"last_name"`
+	Position     string    `json:"position"`
+	Status       string    `json:"status"`
+	Opened       bool      `json:"opened"`
+	Clicked      bool      `json:"clicked"`
+	Submitted    bool      `json:"submitted"`
+	Reported     bool      `json:"reported"`
+	SendDate     time.Time `json:"send_date"`
+	ModifiedDate time.Time `json:"modified_date"`
+	Country      string    `json:"country"`
+	City         string    `json:"city"`
+}
+
+// ReportLocation is the number of targets in a campaign who were located in
+// a city, and how many of them opened, clicked or submitted data
+type ReportLocation struct {
+	Country   string `json:"country"`
+	City      string `json:"city"`
+	Targets   int64  `json:"targets"`
+	Opened    int64  `json:"opened"`
+	Clicked   int64  `json:"clicked"`
+	Submitted int64  `json:"submitted"`
+}
+
+// GetCampaignReport returns the report for the campaign specified by the
+// given id and user_id. Results excluded from reporting are listed with the
+// targets, but aren't counted in the summary or location breakdown.
+func GetCampaignReport(id int64, uid int64) (CampaignReport, error) {
+	cr := CampaignReport{
+		Generated: time.Now().UTC(),
+		Timeline:  []ReportEvent{},
+		Targets:   []ReportTarget{},
+		Locations: []ReportLocation{},
+	}
+	cs, err := GetCampaignSummary(id, uid)
+	if err != nil {
+		return cr, err
+	}
+	cr.Summary = cs
+	es := []Event{}
+	err = db.Where("campaign_id=?", cs.Id).Order("time asc, id asc").Find(&es).Error
+	if err != nil {
+		return cr, err
+	}
+	for _, e := range es {
+		cr.Timeline = append(cr.Timeline, ReportEvent{Time: e.Time.UTC(), Email: e.Email, Message: e.Message})
+	}
+	rs, err := ResultStorage.List(cs.Id, uid)
+	if err != nil {
+		return cr, err
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Id < rs[j].Id })
+	locations := make(map[[2]string]*ReportLocation)
+	for i := range rs {
+		r := &rs[i]
+		cr.Targets = append(cr.Targets, ReportTarget{
+			Email:        r.Email,
+			FirstName:    r.FirstName,
+			LastName:     r.LastName,
+			Position:     r.Position,
+			Status:       r.Status,
+			Opened:       r.hasOpened(),
+			Clicked:      r.hasClicked(),
+			Submitted:    r.hasSubmitted(),
+			Reported:     r.Reported,
+			SendDate:     r.SendDate,
+			ModifiedDate: r.ModifiedDate,
+			Country:      r.CountryName,
+			City:         r.City,
+		})
+		if !r.inReport() {
+			continue
+		}
+		k := [2]string{r.CountryName, r.City}
+		l, ok := locations[k]
+		if !ok {
+			l = &ReportLocation{Country: r.CountryName, City: r.City}
+			locations[k] = l
+		}
+		l.Targets++
+		if r.hasOpened() {
+			l.Opened++
+		}
+		if r.hasClicked() {
+			l.Clicked++
+		}
+		if r.hasSubmitted() {
+			l.Submitted++
+		}
+	}
+	for _, l := range locations {
+		cr.Locations = append(cr.Locations, *l)
+	}
+	// Locations are listed from the most targets to the fewest
+	sort.Slice(cr.Locations, func(i, j int) bool {
+		a, b := cr.Locations[i], cr.Locations[j]
+		if a.Targets != b.Targets {
+			return a.Targets > b.Targets
+		}
+		if a.Country != b.Country {
+			return a.Country < b.Country
+		}
+		return a.City < b.City
+	})
+	return cr, nil
+}
+
+// reportTime formats the time for a report, leaving times which aren't set
+// blank
+func reportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// reportRate formats a rate per 100 targets as a percentage
+func reportRate(r float64) string {
+	return strconv.FormatFloat(r, 'f', 1, 64) + "%"
+}
+
+// Tables returns the report's sections as tables: the summary statistics,
+// the per-target outcomes, the location breakdown and the timeline.
+func (cr *CampaignReport) Tables() []report.Table {
+	s, n := cr.Summary.Stats, cr.Summary.Normalized
+	count := func(i int64) string { return strconv.FormatInt(i, 10) }
+	summary := report.Table{
+		Title:  "Summary",
+		Header: []string{"Metric", "Value", "Rate"},
+		Rows: [][]string{
+			{"Campaign", cr.Summary.Name, ""},
+			{"Status", cr.Summary.Status, ""},
+			{"Created", reportTime(cr.Summary.CreatedDate), ""},
+			{"Launched", reportTime(cr.Summary.LaunchDate), ""},
+			{"Completed", reportTime(cr.Summary.CompletedDate), ""},
+			{"Report generated", reportTime(cr.Generated), ""},
+			{"Targets", count(s.Total), ""},
+			{"Emails sent", count(s.EmailsSent), reportRate(n.EmailsSent)},
+			{"Emails opened", count(s.OpenedEmail), reportRate(n.OpenedEmail)},
+			{"Links clicked", count(s.ClickedLink), reportRate(n.ClickedLink)},
+			{"Data submitted", count(s.SubmittedData), reportRate(n.SubmittedData)},
+			{"Emails reported", count(s.EmailReported), reportRate(n.EmailReported)},
+			{"Bounced", count(s.Bounced), ""},
+			{"Errors", count(s.Error), reportRate(n.Error)},
+			{"Training completed", count(s.TrainingCompleted), ""},
+		},
+	}
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	targets := report.Table{
+		Title: "Targets",
+		Header: []string{"Email", "First Name", "Last Name", "Position", "Status", "Opened", "Clicked",
+			"Submitted", "Reported", "Sent", "Last Activity", "Country", "City"},
+		Rows: [][]string{},
+	}
+	for _, t := range cr.Targets {
+		targets.Rows = append(targets.Rows, []string{
+			t.Email, t.FirstName, t.LastName, t.Position, t.Status, yesNo(t.Opened), yesNo(t.Clicked),
+			yesNo(t.Submitted), yesNo(t.Reported), reportTime(t.SendDate), reportTime(t.ModifiedDate), t.Country, t.City,
+		})
+	}
+	locations := report.Table{
+		Title:  "Locations",
+		Header: []string{"Country", "City", "Targets", "Opened", "Clicked", "Submitted"},
+		Rows:   [][]string{},
+	}
+	for _, l := range cr.Locations {
+		country, city := l.Country, l.City
+		if country == "" && city == "" {
+			country = "Unknown"
+		}
+		locations.Rows = append(locations.Rows, []string{
+			country, city, count(l.Targets), count(l.Opened), count(l.Clicked), count(l.Submitted),
+		})
+	}
+	timeline := report.Table{
+		Title:  "Timeline",
+		Header: []string{"Time", "Email", "Event"},
+		Rows:   [][]string{},
+	}
+	for _, e := range cr.Timeline {
+		timeline.Rows = append(timeline.Rows, []string{reportTime(e.Time), e.Email, e.Message})
+	}
+	return []report.Table{summary, targets, locations, timeline}
+}
+
+// WriteCampaignReport writes the report for the campaign specified by the
+// given id and user_id to w in the given format, which is one of the formats
+// supported by the report package.
+func WriteCampaignReport(w io.Writer, id int64, uid int64, format string) error {
+	switch format {
+	case report.FormatCSV, report.FormatXLSX, report.FormatPDF:
+	default:
+		return report.ErrInvalidFormat
+	}
+	cr, err := GetCampaignReport(id, uid)
+	if err != nil {
+		return err
+	}
+	title := fmt.Sprintf("Campaign Report: %s", cr.Summary.Name)
+	return report.Write(w, format, title, cr.Tables())
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/gophish/gophish/report"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestGetCampaignReport(ch *check.C) {
+	c := s.createCampaignWithTargets(ch, generateTargets(3))
+	rs := c.Results
+	rs[0].CountryName, rs[0].City = "United States", "Minneapolis"
+	rs[1].CountryName, rs[1].City = "United States", "Minneapolis"
+	ch.Assert(db.Save(&rs[0]).Error, check.Equals, nil)
+	ch.Assert(db.Save(&rs[1]).Error, check.Equals, nil)
+	ch.Assert(rs[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleFormSubmit(EventDetails{}), check.Equals, nil)
+
+	cr, err := GetCampaignReport(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(cr.Summary.Name, check.Equals, c.Name)
+	ch.Assert(cr.Summary.Stats.ClickedLink, check.Equals, int64(2))
+	ch.Assert(len(cr.Targets), check.Equals, 3)
+	ch.Assert(cr.Targets[0].Email, check.Equals, rs[0].Email)
+	ch.Assert(cr.Targets[0].Clicked, check.Equals, true)
+	ch.Assert(cr.Targets[0].Submitted, check.Equals, false)
+	ch.Assert(cr.Targets[1].Submitted, check.Equals, true)
+	ch.Assert(len(cr.Timeline) > 0, check.Equals, true)
+	ch.Assert(cr.Timeline[len(cr.Timeline)-1].Message, check.Equals, EVENT_DATA_SUBMIT)
+
+	// The targets are broken down by location, with the most targets first
+	ch.Assert(len(cr.Locations), check.Equals, 2)
+	ch.Assert(cr.Locations[0], check.Equals, ReportLocation{
+		Country: "United States", City: "Minneapolis", Targets: 2, Opened: 2, Clicked: 2, Submitted: 1,
+	})
+	ch.Assert(cr.Locations[1].Targets, check.Equals, int64(1))
+
+	ts := cr.Tables()
+	ch.Assert(len(ts), check.Equals, 4)
+	ch.Assert(len(ts[1].Rows), check.Equals, 3)
+	ch.Assert(ts[2].Rows[1][0], check.Equals, "Unknown")
+}
+
+func (s *ModelsSuite) TestWriteCampaignReport(ch *check.C) {
+	c := s.createCampaign(ch)
+	b := &bytes.Buffer{}
+	ch.Assert(WriteCampaignReport(b, c.Id, c.UserId, "docx"), check.Equals, report.ErrInvalidFormat)
+	ch.Assert(WriteCampaignReport(b, c.Id, c.UserId, report.FormatCSV), check.Equals, nil)
+	ch.Assert(strings.HasPrefix(b.String(), "Summary\n"), check.Equals, true)
+	ch.Assert(strings.Contains(b.String(), c.Results[0].Email), check.Equals, true)
+
+	b.Reset()
+	ch.Assert(WriteCampaignReport(b, c.Id, c.UserId, report.FormatPDF), check.Equals, nil)
+	ch.Assert(strings.HasPrefix(b.String(), "%PDF-"), check.Equals, true)
+	ch.Assert(strings.Contains(b.String(), "(Campaign Report: "+c.Name+") Tj"), check.Equals, true)
+}
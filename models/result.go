@@ -2,20 +2,44 @@ package models
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/big"
+	mathrand "math/rand"
 	"net"
 	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 
+	"github.com/gophish/gophish/config"
 	log "github.com/gophish/gophish/logger"
 	"github.com/jinzhu/gorm"
-	"github.com/oschwald/maxminddb-golang"
 )
 
 type mmCity struct {
 	GeoPoint mmGeoPoint `maxminddb:"location"`
+	Country  mmCountry  `maxminddb:"country"`
+	City     mmName     `maxminddb:"city"`
+}
+
+type mmCountry struct {
+	ISOCode string            `maxminddb:"iso_code"`
+	Names   map[string]string `maxminddb:"names"`
+}
+
+type mmName struct {
+	Names map[string]string `maxminddb:"names"`
 }
 
 type mmGeoPoint struct {
@@ -23,32 +47,220 @@ type mmGeoPoint struct {
 	Longitude float64 `maxminddb:"longitude"`
 }
 
+// KeepAliveBurstWindow is the maximum amount of time between two requests
+// made over the same connection for them to be considered an automated burst.
+var KeepAliveBurstWindow = time.Second
+
+// DefaultDuplicateOpenWindow is the DuplicateOpenWindow used if none is
+// configured.
+const DefaultDuplicateOpenWindow = 5 * time.Second
+
+// DuplicateOpenWindow is the amount of time after an open is recorded during
+// which further opens of the same result are ignored, collapsing the bursts of
+// requests made by mail scanners into a single event. A zero value records
+// every open.
+var DuplicateOpenWindow = DefaultDuplicateOpenWindow
+
+// IncludeExcludedResults determines whether or not results which have been
+// excluded from reporting are still counted in campaign summaries.
+var IncludeExcludedResults = false
+
+// reportScope restricts the given query on the results table to the results
+// which are counted in reports, dropping those excluded from reporting unless
+// IncludeExcludedResults is set.
+func reportScope(query *gorm.DB) *gorm.DB {
+	if IncludeExcludedResults {
+		return query
+	}
+	return query.Where("excluded_from_report = ?", false)
+}
+
+// inReport returns whether or not the result is counted in reports.
+func (r *Result) inReport() bool {
+	return IncludeExcludedResults || !r.ExcludedFromReport
+}
+
+// reportedResults returns the results in rs which are counted in reports.
+func reportedResults(rs []Result) []Result {
+	reported := []Result{}
+	for _, r := range rs {
+		if r.inReport() {
+			reported = append(reported, r)
+		}
+	}
+	return reported
+}
+
+// EventExclusion is a struct that wraps the reason a result was excluded from
+// reporting
+type EventExclusion struct {
+	Reason string `json:"reason"`
+}
+
+// LinkExpiry is how long after an email is sent that the links in it stop
+// working. A zero value means links never expire.
+var LinkExpiry time.Duration
+
+// SendInterval is the base amount of time to wait between sending the email
+// to each target in a campaign. A zero value sends every email at launch.
+var SendInterval time.Duration
+
+// SendJitter is the largest random delay added to SendInterval between sends,
+// so that the emails don't arrive as a single blast.
+var SendJitter time.Duration
+
+// lockedSource is a source of randomness which is safe to use from several
+// goroutines, like the one used by the top-level functions of math/rand.
+type lockedSource struct {
+	lock sync.Mutex
+	src  mathrand.Source
+}
+
+// newLockedSource returns a source of randomness seeded with the current time
+// which can be shared by concurrent campaigns.
+func newLockedSource() *lockedSource {
+	return &lockedSource{src: mathrand.NewSource(time.Now().UnixNano())}
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.src.Seed(seed)
+}
+
+// jitterSource is the source of randomness used for send jitter. It can be
+// reseeded to make the delays reproducible.
+var jitterSource = mathrand.New(newLockedSource())
+
+// configureSending sets the delay between sending the email to each target in
+// a campaign, and the largest random delay added to it.
+func configureSending(conf config.Sending) {
+	SendInterval = time.Duration(conf.IntervalSeconds) * time.Second
+	SendJitter = time.Duration(conf.JitterSeconds) * time.Second
+}
+
+// configureDuplicateOpens sets the window during which repeated opens of a
+// result are ignored, falling back to the default if none is set.
+func configureDuplicateOpens(conf config.DuplicateOpens) {
+	switch {
+	case conf.WindowSeconds < 0:
+		DuplicateOpenWindow = 0
+	case conf.WindowSeconds == 0:
+		DuplicateOpenWindow = DefaultDuplicateOpenWindow
+	default:
+		DuplicateOpenWindow = time.Duration(conf.WindowSeconds) * time.Second
+	}
+}
+
+// configureLinkExpiry sets how long after an email is sent that the links in
+// it stop working.
+func configureLinkExpiry(conf config.LinkExpiry) {
+	LinkExpiry = time.Duration(conf.Hours) * time.Hour
+	if LinkExpiry < 0 {
+		LinkExpiry = 0
+	}
+}
+
+// SeedSendJitter seeds the random delays added between sends, so that the
+// same seed produces the same schedule.
+func SeedSendJitter(seed int64) {
+	jitterSource.Seed(seed)
+}
+
+// GeoStep is a single point in the geolocation trail of a result, describing
+// where the recipient was when an event occurred.
+type GeoStep struct {
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+}
+
 // Result contains the fields for a result object,
 // which is a representation of a target in a campaign.
 type Result struct {
-	Id           int64     `json:"-"`
-	CampaignId   int64     `json:"-"`
-	UserId       int64     `json:"-"`
-	RId          string    `json:"id"`
-	Email        string    `json:"email"`
-	FirstName    string    `json:"first_name"`
-	LastName     string    `json:"last_name"`
-	Position     string    `json:"position"`
-	Status       string    `json:"status" sql:"not null"`
-	IP           string    `json:"ip"`
-	Latitude     float64   `json:"latitude"`
-	Longitude    float64   `json:"longitude"`
-	SendDate     time.Time `json:"send_date"`
-	Reported     bool      `json:"reported" sql:"not null"`
-	ModifiedDate time.Time `json:"modified_date"`
+	Id                 int64      `json:"-"`
+	CampaignId         int64      `json:"-"`
+	UserId             int64      `json:"-"`
+	RId                string     `json:"id"`
+	Email              string     `json:"email"`
+	FirstName          string     `json:"first_name"`
+	LastName           string     `json:"last_name"`
+	Position           string     `json:"position"`
+	Phone              string     `json:"phone"`
+	Locale             string     `json:"locale"`
+	VariantId          int64      `json:"variant_id"`
+	SMTPId             int64      `json:"smtp_id" gorm:"column:smtp_id"`
+	Status             string     `json:"status" sql:"not null"`
+	IP                 string     `json:"ip"`
+	Latitude           float64    `json:"latitude"`
+	Longitude          float64    `json:"longitude"`
+	SendDate           time.Time  `json:"send_date"`
+	Reported           bool       `json:"reported" sql:"not null"`
+	ModifiedDate       time.Time  `json:"modified_date"`
+	AcceptLanguage     string     `json:"accept_language"`
+	MessageId          string     `json:"message_id"`
+	Delivered          bool       `json:"delivered" sql:"not null"`
+	Subject            string     `json:"subject"`
+	SendPosition       int64      `json:"send_position"`
+	ClientTZOffset     *int       `json:"client_tz_offset"`
+	ExcludedFromReport bool       `json:"excluded_from_report" sql:"not null"`
+	TrackingDomain     string     `json:"tracking_domain"`
+	ProviderType       string     `json:"provider_type"`
+	Country            string     `json:"country"`
+	OnHold             bool       `json:"on_hold" sql:"not null"`
+	LinkExpiresAt      *time.Time `json:"link_expires_at"`
+	ReverseDNS         string     `json:"reverse_dns"`
+	InboxPlacement     string     `json:"inbox_placement"`
+	AttributesJSON     string     `json:"-" gorm:"column:attributes"`
+	PasswordBreached   bool       `json:"password_breached" sql:"not null"`
+	CountryName        string     `json:"country_name"`
+	City               string     `json:"city"`
+	RetryAttempts      int        `json:"retry_attempts"`
+	TrainingCompleted  bool       `json:"training_completed" sql:"not null"`
+	StepsSubmitted     int        `json:"steps_submitted"`
+	DeletedAt          *time.Time `json:"deleted_at,omitempty"`
+	// Variables are the result's custom attributes, made available to the
+	// email and landing page templates as {{.Variables.name}}, or as
+	// {{.Custom.Name}} (see Custom). Variables the result doesn't have render
+	// as an empty string.
+	Variables map[string]string `json:"variables,omitempty" sql:"-"`
+	// Tags and Notes are the labels and notes analysts have attached to the
+	// result. They're only loaded with the campaign's results.
+	Tags  []string     `json:"tags,omitempty" sql:"-"`
+	Notes []ResultNote `json:"notes,omitempty" sql:"-"`
 }
 
 func (r *Result) createEvent(status string, details interface{}) (*Event, error) {
-	c, err := GetCampaign(r.CampaignId, r.UserId)
+	// Only the campaign itself is needed, so avoid loading all of its results
+	// and events for every event recorded
+	c := Campaign{}
+	err := db.Where("id = ?", r.CampaignId).Where("user_id = ?", r.UserId).Find(&c).Error
 	if err != nil {
+		log.Errorf("%s: campaign not found", err)
 		return nil, err
 	}
 	e := &Event{Email: r.Email, Message: status}
+	if d, ok := details.(EventDetails); ok && d.Fingerprint == "" {
+		d.Fingerprint = engagementFingerprint(d)
+		details = d
+	}
+	// Record which variant of the campaign the recipient received
+	if r.VariantId != 0 {
+		switch d := details.(type) {
+		case EventDetails:
+			d.VariantId = r.VariantId
+			details = d
+		case nil:
+			details = EventDetails{VariantId: r.VariantId}
+		}
+	}
 	if details != nil {
 		dj, err := json.Marshal(details)
 		if err != nil {
@@ -56,10 +268,40 @@ func (r *Result) createEvent(status string, details interface{}) (*Event, error)
 		}
 		e.Details = string(dj)
 	}
-	c.AddEvent(e)
+	if !processEvent(&c, r, e) {
+		return nil, errEventSuppressed
+	}
+	err = c.AddEvent(e)
+	if err != nil {
+		log.Error(err)
+		return e, nil
+	}
+	resultEvents.WithLabelValues(status).Inc()
+	notifyWebhook(r, e)
+	forwardEvent(&c, r, e)
+	publishEvent(r, e)
 	return e, nil
 }
 
+// statusPrecedence ranks the result statuses which reflect the recipient's
+// progress through a campaign. Statuses from before the email was delivered,
+// such as scheduled, sending, retrying or errored, aren't listed and share the
+// lowest rank. Reporting the email is tracked separately from the status.
+var statusPrecedence = map[string]int{
+	EVENT_SENT:        1,
+	EVENT_OPENED:      2,
+	EVENT_CLICKED:     3,
+	EVENT_DATA_SUBMIT: 4,
+	EVENT_MFA_SUBMIT:  5,
+}
+
+// canTransition returns whether or not a result with the from status can be
+// moved to the to status, so that a late-arriving, lower priority event never
+// regresses the result's status.
+func canTransition(from, to string) bool {
+	return statusPrecedence[to] >= statusPrecedence[from]
+}
+
 // HandleEmailSent updates a Result to indicate that the email has been
 // successfully sent to the remote SMTP server
 func (r *Result) HandleEmailSent() error {
@@ -67,9 +309,48 @@ func (r *Result) HandleEmailSent() error {
 	if err != nil {
 		return err
 	}
-	r.Status = EVENT_SENT
-	r.ModifiedDate = event.Time
-	return db.Save(r).Error
+	// Record the order in which emails were sent, so that we can later
+	// check whether the send order affected engagement.
+	if r.SendPosition == 0 {
+		r.SendPosition, err = nextSendPosition(r.CampaignId)
+		if err != nil {
+			return err
+		}
+	}
+	// Apply the link expiry policy from when the email was first sent
+	if LinkExpiry > 0 && r.LinkExpiresAt == nil {
+		expires := event.Time.Add(LinkExpiry)
+		r.LinkExpiresAt = &expires
+	}
+	if canTransition(r.Status, EVENT_SENT) {
+		r.Status = EVENT_SENT
+		r.ModifiedDate = event.Time
+	}
+	return ResultStorage.Save(r)
+}
+
+// nextSendPosition returns the next position in the send order of the
+// campaign with the given id. The campaign's counter is incremented and read
+// back in a single transaction, so that emails sent at the same time, such as
+// by different sending profiles or instances, never share a position.
+func nextSendPosition(cid int64) (int64, error) {
+	tx := db.Begin()
+	err := tx.Exec("UPDATE campaigns SET send_positions = send_positions + 1 WHERE id = ?", cid).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	positions := []int64{}
+	err = tx.Table("campaigns").Where("id = ?", cid).Pluck("send_positions", &positions).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if len(positions) == 0 {
+		tx.Rollback()
+		return 0, gorm.ErrRecordNotFound
+	}
+	return positions[0], tx.Commit().Error
 }
 
 // HandleEmailError updates a Result to indicate that there was an error when
@@ -79,39 +360,97 @@ func (r *Result) HandleEmailError(err error) error {
 	if err != nil {
 		return err
 	}
+	if !canTransition(r.Status, ERROR) {
+		return nil
+	}
 	r.Status = ERROR
 	r.ModifiedDate = event.Time
-	return db.Save(r).Error
+	return ResultStorage.Save(r)
 }
 
 // HandleEmailBackoff updates a Result to indicate that the email received a
-// temporary error and needs to be retried
+// temporary error and needs to be retried at the given send date
 func (r *Result) HandleEmailBackoff(err error, sendDate time.Time) error {
 	event, err := r.createEvent(EVENT_SENDING_ERROR, EventError{Error: err.Error()})
 	if err != nil {
 		return err
 	}
+	if !canTransition(r.Status, STATUS_RETRY) {
+		return nil
+	}
+	r.RetryAttempts++
 	r.Status = STATUS_RETRY
-	r.SendDate = sendDate
+	r.SendDate = sendDate.UTC()
 	r.ModifiedDate = event.Time
-	return db.Save(r).Error
+	return ResultStorage.Save(r)
+}
+
+// NextRetryAt returns the time the email to the result will next be retried,
+// and whether or not the result is waiting to be retried at all.
+func (r *Result) NextRetryAt() (time.Time, bool) {
+	if r.Status != STATUS_RETRY {
+		return time.Time{}, false
+	}
+	return r.SendDate, true
+}
+
+// RetryWaiting returns whether or not the result is waiting for a retry which
+// is scheduled in the future. Retries that are past due are not waiting, since
+// they will be picked up the next time the mailer polls for emails.
+func (r *Result) RetryWaiting() bool {
+	next, ok := r.NextRetryAt()
+	return ok && next.After(time.Now().UTC())
 }
 
 // HandleEmailOpened updates a Result in the case where the recipient opened the
 // email.
 func (r *Result) HandleEmailOpened(details EventDetails) error {
-	event, err := r.createEvent(EVENT_OPENED, details)
+	// Bursts are checked for before duplicates are ignored, since the requests
+	// in a burst are almost always within DuplicateOpenWindow of each other
+	burst, err := r.isKeepAliveBurst(details)
+	if err != nil {
+		return err
+	}
+	duplicate, err := r.isDuplicateEvent(EVENT_OPENED, DuplicateOpenWindow)
+	if err != nil {
+		return err
+	}
+	if duplicate {
+		if burst {
+			return r.flagKeepAliveBurst()
+		}
+		return nil
+	}
+	if burst {
+		// Copy the browser details so we don't modify the caller's map
+		browser := map[string]string{"keepalive-burst": "true"}
+		for k, v := range details.Browser {
+			browser[k] = v
+		}
+		details.Browser = browser
+	}
+	sent, wasSent, err := r.sentTime()
 	if err != nil {
 		return err
 	}
+	confidence := openHumanConfidence(details, time.Since(sent), wasSent)
+	details.HumanConfidence = &confidence
+	event, err := r.createEvent(EVENT_OPENED, details)
+	if err != nil {
+		return ignoreSuppressed(err)
+	}
 	// Don't update the status if the user already clicked the link
 	// or submitted data to the campaign
-	if r.Status == EVENT_CLICKED || r.Status == EVENT_DATA_SUBMIT {
+	if !canTransition(r.Status, EVENT_OPENED) {
+		if r.recordClientDetails(details) {
+			return ResultStorage.Save(r)
+		}
 		return nil
 	}
+	r.recordClientDetails(details)
 	r.Status = EVENT_OPENED
 	r.ModifiedDate = event.Time
-	return db.Save(r).Error
+	return ResultStorage.Save(r)
 }
 
 // HandleClickedLink updates a Result in the case where the recipient clicked
@@ -119,105 +458,2366 @@ func (r *Result) HandleEmailOpened(details EventDetails) error {
 func (r *Result) HandleClickedLink(details EventDetails) error {
 	event, err := r.createEvent(EVENT_CLICKED, details)
 	if err != nil {
-		return err
+		return ignoreSuppressed(err)
 	}
 	// Don't update the status if the user has already submitted data via the
 	// landing page form.
-	if r.Status == EVENT_DATA_SUBMIT {
+	if !canTransition(r.Status, EVENT_CLICKED) {
+		if r.recordClientDetails(details) {
+			return ResultStorage.Save(r)
+		}
 		return nil
 	}
+	r.recordClientDetails(details)
 	r.Status = EVENT_CLICKED
 	r.ModifiedDate = event.Time
-	return db.Save(r).Error
+	err = ResultStorage.Save(r)
+	if err == nil {
+		notifyResultMilestones(r)
+	}
+	return err
 }
 
 // HandleFormSubmit updates a Result in the case where the recipient submitted
-// credentials to the form on a Landing Page.
+// credentials to the form on a Landing Page. If PasswordBreachCheck is enabled,
+// whether or not a submitted password was already breached is recorded.
 func (r *Result) HandleFormSubmit(details EventDetails) error {
+	details.Honeypots, details.Bot = checkHoneypots(details.Payload, details.PageHoneypots)
+	if PasswordBreachCheck && !details.Bot {
+		details.PasswordBreached = checkBreachedPasswords(details.Payload)
+	}
+	// The checks above need the values as submitted, so the page's capture
+	// policy is enforced just before the event is stored
+	if details.CapturePolicy != nil {
+		details.Payload = details.CapturePolicy.Apply(details.Payload)
+	}
 	event, err := r.createEvent(EVENT_DATA_SUBMIT, details)
 	if err != nil {
-		return err
+		return ignoreSuppressed(err)
+	}
+	changed := r.recordClientDetails(details)
+	if details.PasswordBreached && !r.PasswordBreached {
+		r.PasswordBreached = true
+		changed = true
+	}
+	if !details.Bot && r.recordStep(details) {
+		changed = true
+	}
+	// Submissions which only filled in honeypot fields came from a bot, so
+	// they don't count as the recipient submitting data
+	if details.Bot || !canTransition(r.Status, EVENT_DATA_SUBMIT) {
+		if changed {
+			return ResultStorage.Save(r)
+		}
+		return nil
 	}
 	r.Status = EVENT_DATA_SUBMIT
 	r.ModifiedDate = event.Time
-	return db.Save(r).Error
+	err = ResultStorage.Save(r)
+	if err == nil {
+		notifyResultMilestones(r)
+	}
+	return err
+}
+
+// HandleMFASubmit updates a Result in the case where the recipient submitted a
+// second factor, such as a one-time code or a session token captured by a
+// transparent proxy, to the landing page. The submitted fields are recorded in
+// the event details. Capturing a second factor ranks above submitting
+// credentials, so later submissions don't downgrade the result's status.
+func (r *Result) HandleMFASubmit(details EventDetails) error {
+	if details.CapturePolicy != nil {
+		details.Payload = details.CapturePolicy.Apply(details.Payload)
+	}
+	event, err := r.createEvent(EVENT_MFA_SUBMIT, details)
+	if err != nil {
+		return ignoreSuppressed(err)
+	}
+	changed := r.recordClientDetails(details)
+	if r.recordStep(details) {
+		changed = true
+	}
+	if !canTransition(r.Status, EVENT_MFA_SUBMIT) {
+		if changed {
+			return ResultStorage.Save(r)
+		}
+		return nil
+	}
+	r.Status = EVENT_MFA_SUBMIT
+	r.ModifiedDate = event.Time
+	return ResultStorage.Save(r)
+}
+
+// HoneypotFields are the names of hidden form fields on every landing page
+// which people can't see, and so are only filled in by bots. Pages can list
+// their own honeypot fields in addition to these.
+var HoneypotFields = []string{}
+
+// configureHoneypots sets the honeypot fields used for every landing page.
+func configureHoneypots(fields []string) {
+	HoneypotFields = append([]string{}, fields...)
+}
+
+// checkHoneypots returns the honeypot fields which were filled in the form
+// submission, and whether or not the submission only filled honeypot fields.
+// Both the global honeypot fields and the given fields of the page are
+// checked. Parameters added by Gophish, such as the recipient ID, are
+// ignored.
+func checkHoneypots(payload url.Values, pageFields []string) ([]string, bool) {
+	honeypots := []string{}
+	human := false
+	for k, vs := range payload {
+		if k == RecipientParameter || k == TZOffsetParameter || strings.Join(vs, "") == "" {
+			continue
+		}
+		if matchesField(k, HoneypotFields) || matchesField(k, pageFields) {
+			honeypots = append(honeypots, k)
+		} else {
+			human = true
+		}
+	}
+	sort.Strings(honeypots)
+	return honeypots, len(honeypots) > 0 && !human
+}
+
+// matchesField returns whether or not the field is one of the given fields.
+func matchesField(field string, fields []string) bool {
+	for _, f := range fields {
+		if field == f {
+			return true
+		}
+	}
+	return false
+}
+
+// SubmitWasBot returns whether or not every form submission recorded for the
+// result was made by a bot. Results without any submissions return false.
+func (r *Result) SubmitWasBot() (bool, error) {
+	es, err := r.GetEvents()
+	if err != nil {
+		return false, err
+	}
+	bot := false
+	for _, e := range es {
+		if e.Message != EVENT_DATA_SUBMIT {
+			continue
+		}
+		d, err := e.parseDetails()
+		if err != nil {
+			return false, err
+		}
+		if !d.Bot {
+			return false, nil
+		}
+		bot = true
+	}
+	return bot, nil
 }
 
+// The channels a recipient can report a simulated phishing email through
+const (
+	REPORT_CHANNEL_BUTTON  string = "button"
+	REPORT_CHANNEL_FORWARD string = "forward"
+	REPORT_CHANNEL_TICKET  string = "ticket"
+)
+
+// ErrInvalidReportChannel is thrown when a report is made through an unknown
+// channel
+var ErrInvalidReportChannel = errors.New("Invalid report channel")
+
 // HandleEmailReport updates a Result in the case where they report a simulated
-// phishing email using the HTTP handler.
+// phishing email using the HTTP handler. The channel the report was made
+// through is recorded in the event details, defaulting to the report button.
+// If the details include the reporting client's address but no location, the
+// address is geolocated and the location is recorded on the event, leaving
+// the result's own location unchanged.
 func (r *Result) HandleEmailReport(details EventDetails) error {
+	switch details.Channel {
+	case "":
+		details.Channel = REPORT_CHANNEL_BUTTON
+	case REPORT_CHANNEL_BUTTON, REPORT_CHANNEL_FORWARD, REPORT_CHANNEL_TICKET:
+	default:
+		return ErrInvalidReportChannel
+	}
+	if details.Latitude == 0 && details.Longitude == 0 {
+		details.geolocate(details.Browser["address"])
+	}
 	event, err := r.createEvent(EVENT_REPORTED, details)
 	if err != nil {
-		return err
+		return ignoreSuppressed(err)
 	}
 	r.Reported = true
 	r.ModifiedDate = event.Time
-	return db.Save(r).Error
+	return ResultStorage.Save(r)
 }
 
-// UpdateGeo updates the latitude and longitude of the result in
-// the database given an IP address
-func (r *Result) UpdateGeo(addr string) error {
-	// Open a connection to the maxmind db
-	mmdb, err := maxminddb.Open("static/db/geolite2-city.mmdb")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer mmdb.Close()
+// geolocate records the location of the given public IP address in the event
+// details. Addresses which can't be geolocated are ignored.
+func (d *EventDetails) geolocate(addr string) {
 	ip := net.ParseIP(addr)
+	if ip == nil || !isPublicIP(ip) {
+		return
+	}
 	var city mmCity
-	// Get the record
-	err = mmdb.Lookup(ip, &city)
+	err := lookupGeoIP(ip, &city)
+	if err == ErrGeoNotFound {
+		return
+	}
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	d.Latitude = city.GeoPoint.Latitude
+	d.Longitude = city.GeoPoint.Longitude
+	d.Country = city.Country.ISOCode
+	d.CountryName = city.Country.Names["en"]
+	d.City = city.City.Names["en"]
+}
+
+// ReporterAddress returns the IP address of the client which most recently
+// reported the email, so that reports made from the recipient's own machine
+// can be told apart from those made by a security tool. If no report recorded
+// an address, false is returned.
+func (r *Result) ReporterAddress() (string, bool) {
+	es := []Event{}
+	err := db.Where("campaign_id=? and email=? and message=?", r.CampaignId, r.Email, EVENT_REPORTED).
+		Order("time desc, id desc").Find(&es).Error
+	if err != nil {
+		log.Error(err)
+		return "", false
+	}
+	for _, e := range es {
+		d, err := e.parseDetails()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if addr := d.Browser["address"]; addr != "" {
+			return addr, true
+		}
+	}
+	return "", false
+}
+
+// ExcludeFromReport marks the result as excluded from the campaign's reporting,
+// such as when the email reached the wrong person. The result and its events
+// are kept, and the reason is recorded as an event for auditing.
+func (r *Result) ExcludeFromReport(reason string) error {
+	_, err := r.createEvent(EVENT_EXCLUDED, EventExclusion{Reason: reason})
 	if err != nil {
 		return err
 	}
-	// Update the database with the record information
-	r.IP = addr
-	r.Latitude = city.GeoPoint.Latitude
-	r.Longitude = city.GeoPoint.Longitude
-	return db.Save(r).Error
+	r.ExcludedFromReport = true
+	return ResultStorage.Save(r)
 }
 
-// GenerateId generates a unique key to represent the result
-// in the database
-func (r *Result) GenerateId() error {
-	// Keep trying until we generate a unique key (shouldn't take more than one or two iterations)
-	const alphaNum = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	k := make([]byte, 7)
-	for {
-		for i := range k {
-			idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphaNum))))
+// LinkExpired returns whether or not the links sent to the result have
+// expired.
+func (r *Result) LinkExpired() bool {
+	return r.LinkExpiresAt != nil && time.Now().UTC().After(*r.LinkExpiresAt)
+}
+
+// HandleLinkExpired records an attempt to use one of the result's links after
+// it expired. The result's status is left unchanged.
+func (r *Result) HandleLinkExpired(details EventDetails) error {
+	_, err := r.createEvent(EVENT_LINK_EXPIRED, details)
+	return ignoreSuppressed(err)
+}
+
+// HandleAttachmentOpened updates a Result in the case where the recipient
+// opened an attachment in the email. The name of the attachment is recorded in
+// the event details. Opening an attachment implies the email was opened.
+func (r *Result) HandleAttachmentOpened(details EventDetails) error {
+	event, err := r.createEvent(EVENT_ATTACHMENT, details)
+	if err != nil {
+		return ignoreSuppressed(err)
+	}
+	r.recordClientDetails(details)
+	if canTransition(r.Status, EVENT_OPENED) {
+		r.Status = EVENT_OPENED
+	}
+	r.ModifiedDate = event.Time
+	return ResultStorage.Save(r)
+}
+
+// OpenedAttachments returns the names of the attachments the recipient opened,
+// in the order they were first opened.
+func (r *Result) OpenedAttachments() ([]string, error) {
+	names := []string{}
+	es, err := r.GetEvents()
+	if err != nil {
+		return names, err
+	}
+	seen := make(map[string]bool)
+	for _, e := range es {
+		if e.Message != EVENT_ATTACHMENT {
+			continue
+		}
+		d, err := e.parseDetails()
+		if err != nil {
+			return names, err
+		}
+		if d.AttachmentName == "" || seen[d.AttachmentName] {
+			continue
+		}
+		seen[d.AttachmentName] = true
+		names = append(names, d.AttachmentName)
+	}
+	return names, nil
+}
+
+// TZOffsetParameter is the event payload parameter that landing pages can use
+// to report the client's UTC offset, in minutes east of UTC.
+const TZOffsetParameter = "tz_offset"
+
+// recordClientDetails records information about the recipient's client, such
+// as the Accept-Language header and timezone offset, found in the event
+// details. It returns whether or not the result was changed.
+func (r *Result) recordClientDetails(details EventDetails) bool {
+	changed := false
+	al := details.Browser["accept-language"]
+	if al != "" && al != r.AcceptLanguage {
+		r.AcceptLanguage = al
+		changed = true
+	}
+	if tz := details.Payload.Get(TZOffsetParameter); tz != "" {
+		offset, err := strconv.Atoi(tz)
+		// Valid offsets range from UTC-12:00 to UTC+14:00
+		if err == nil && offset >= -12*60 && offset <= 14*60 {
+			if r.ClientTZOffset == nil || *r.ClientTZOffset != offset {
+				r.ClientTZOffset = &offset
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// InferredTimezone returns the recipient's UTC offset formatted as
+// "UTC+hh:mm". The offset reported by the client is preferred. Otherwise, the
+// offset is estimated from the longitude of the result's geolocation. If
+// neither is available, false is returned.
+func (r *Result) InferredTimezone() (string, bool) {
+	offset := 0
+	switch {
+	case r.ClientTZOffset != nil:
+		offset = *r.ClientTZOffset
+	case r.Latitude != 0 || r.Longitude != 0:
+		offset = int(math.Round(r.Longitude/15)) * 60
+	default:
+		return "", false
+	}
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("UTC%s%02d:%02d", sign, offset/60, offset%60), true
+}
+
+// InferredLocale returns the preferred language tag (e.g. "en-US") from the
+// Accept-Language header recorded for the result. If no header was recorded,
+// or the header couldn't be parsed, false is returned.
+func (r *Result) InferredLocale() (string, bool) {
+	locale := ""
+	weight := -1.0
+	for _, part := range strings.Split(r.AcceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if !strings.HasPrefix(f, "q=") {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimPrefix(f, "q="), 64)
 			if err != nil {
-				return err
+				v = 0
 			}
-			k[i] = alphaNum[idx.Int64()]
+			q = v
 		}
-		r.RId = string(k)
-		err := db.Table("results").Where("r_id=?", r.RId).First(&Result{}).Error
-		if err == gorm.ErrRecordNotFound {
-			break
+		if q > weight {
+			locale = tag
+			weight = q
 		}
 	}
-	return nil
+	if locale == "" || weight <= 0 {
+		return "", false
+	}
+	return locale, true
 }
 
-// FormatAddress returns the email address to use in the "To" header of the email
-func (r *Result) FormatAddress() string {
-	addr := r.Email
-	if r.FirstName != "" && r.LastName != "" {
-		a := &mail.Address{
-			Name:    fmt.Sprintf("%s %s", r.FirstName, r.LastName),
-			Address: r.Email,
+// GetEvents returns the events recorded for the result within its campaign,
+// ordered by the time they occurred.
+func (r *Result) GetEvents() ([]Event, error) {
+	es := []Event{}
+	err := db.Where("campaign_id=? and email=?", r.CampaignId, r.Email).
+		Order("time asc, id asc").Find(&es).Error
+	return es, err
+}
+
+// GeoTrail returns the ordered locations recorded for each of the result's
+// events. Events without recorded coordinates, such as the email being sent,
+// are excluded from the trail.
+func (r *Result) GeoTrail() ([]GeoStep, error) {
+	trail := []GeoStep{}
+	es, err := r.GetEvents()
+	if err != nil {
+		return trail, err
+	}
+	for _, e := range es {
+		d, err := e.parseDetails()
+		if err != nil {
+			return trail, err
 		}
-		addr = a.String()
+		if d.Latitude == 0 && d.Longitude == 0 {
+			continue
+		}
+		trail = append(trail, GeoStep{
+			Message:   e.Message,
+			Time:      e.Time,
+			Latitude:  d.Latitude,
+			Longitude: d.Longitude,
+		})
 	}
-	return addr
+	return trail, nil
 }
 
-// GetResult returns the Result object from the database
-// given the ResultId
-func GetResult(rid string) (Result, error) {
-	r := Result{}
-	err := db.Where("r_id=?", rid).First(&r).Error
-	return r, err
+// TravelPath returns the result's geolocation trail along with the total
+// distance, in kilometers, traveled between consecutive steps. Events without
+// recorded coordinates are excluded. Unlike impossible travel, a path of
+// plausible moves between cities suggests the recipient was roaming during the
+// campaign.
+func (r *Result) TravelPath() ([]GeoStep, float64, error) {
+	trail, err := r.GeoTrail()
+	if err != nil {
+		return trail, 0, err
+	}
+	total := 0.0
+	for i := 1; i < len(trail); i++ {
+		prev, cur := trail[i-1], trail[i]
+		total += distance(prev.Latitude, prev.Longitude, cur.Latitude, cur.Longitude)
+	}
+	return trail, total, nil
+}
+
+// isDuplicateEvent returns whether or not an event with the given status was
+// recorded for the result within the given window.
+func (r *Result) isDuplicateEvent(status string, window time.Duration) (bool, error) {
+	if window <= 0 {
+		return false, nil
+	}
+	e := Event{}
+	err := db.Where("campaign_id=? and email=? and message=?", r.CampaignId, r.Email, status).
+		Order("time desc, id desc").First(&e).Error
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return time.Since(e.Time) <= window, nil
+}
+
+// isKeepAliveBurst returns whether or not the request described by the given
+// event details was made over the same connection as one of the result's
+// previous events within KeepAliveBurstWindow. Rapid requests reusing a
+// single keep-alive connection are a strong indicator of a mail scanner.
+func (r *Result) isKeepAliveBurst(details EventDetails) (bool, error) {
+	conn := details.Browser["connection"]
+	if conn == "" {
+		return false, nil
+	}
+	es, err := r.GetEvents()
+	if err != nil {
+		return false, err
+	}
+	for i := len(es) - 1; i >= 0; i-- {
+		d, err := es[i].parseDetails()
+		if err != nil {
+			return false, err
+		}
+		if d.Browser["connection"] != conn {
+			continue
+		}
+		return time.Since(es[i].Time) <= KeepAliveBurstWindow, nil
+	}
+	return false, nil
+}
+
+// flagKeepAliveBurst flags the result's latest open as part of a keep-alive
+// burst, lowering the confidence that it was made by a person. It's used when
+// the open which revealed the burst is ignored as a duplicate.
+func (r *Result) flagKeepAliveBurst() error {
+	e := Event{}
+	err := db.Where("campaign_id=? and email=? and message=?", r.CampaignId, r.Email, EVENT_OPENED).
+		Order("time desc, id desc").First(&e).Error
+	if err != nil {
+		return err
+	}
+	d, err := e.parseDetails()
+	if err != nil {
+		return err
+	}
+	if d.Browser["keepalive-burst"] == "true" {
+		return nil
+	}
+	if d.Browser == nil {
+		d.Browser = map[string]string{}
+	}
+	d.Browser["keepalive-burst"] = "true"
+	sent, wasSent, err := r.sentTime()
+	if err != nil {
+		return err
+	}
+	confidence := openHumanConfidence(d, e.Time.Sub(sent), wasSent)
+	d.HumanConfidence = &confidence
+	details, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return db.Model(&e).UpdateColumn("details", string(details)).Error
+}
+
+// HasKeepAliveBurst returns whether or not any of the result's opens were
+// flagged as a rapid-fire burst over a single keep-alive connection.
+func (r *Result) HasKeepAliveBurst() (bool, error) {
+	es, err := r.GetEvents()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range es {
+		if e.Message != EVENT_OPENED {
+			continue
+		}
+		d, err := e.parseDetails()
+		if err != nil {
+			return false, err
+		}
+		if d.Browser["keepalive-burst"] == "true" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ErrInvalidIPAddress is thrown when an IP address can't be parsed
+var ErrInvalidIPAddress = errors.New("Invalid IP address")
+
+// UpdateGeo updates the latitude, longitude, country and city of the result in
+// the database given an IPv4 or IPv6 address. The address is stored in its
+// normalized form, so that IPv4-mapped IPv6 addresses are stored as IPv4.
+// Addresses which aren't publicly routable are stored without updating the
+// location. If the address isn't in the GeoIP database, it's stored without
+// updating the location and ErrGeoNotFound is returned. When ReverseDNSLookup
+// is enabled, the reverse DNS name of public addresses is stored as well.
+func (r *Result) UpdateGeo(addr string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ErrInvalidIPAddress
+	}
+	addr = ip.String()
+	// Internal addresses, such as from a load balancer or test clicks from the
+	// office, don't have a meaningful location
+	if !isPublicIP(ip) {
+		r.IP = addr
+		return ResultStorage.Save(r)
+	}
+	if ReverseDNSLookup {
+		r.queueReverseDNS(addr)
+	}
+	var city mmCity
+	// Get the record
+	err := lookupGeoIP(ip, &city)
+	if err == ErrGeoNotFound {
+		r.IP = addr
+		err = ResultStorage.Save(r)
+		if err != nil {
+			return err
+		}
+		return ErrGeoNotFound
+	}
+	if err != nil {
+		return err
+	}
+	// Update the database with the record information
+	r.IP = addr
+	r.setLocation(city)
+	err = ResultStorage.Save(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// NextSendJitter returns the delay to wait after sending to the result before
+// sending to the next target: the base delay plus a random amount up to
+// SendJitter.
+func (r *Result) NextSendJitter(base time.Duration) time.Duration {
+	if SendJitter <= 0 {
+		return base
+	}
+	return base + time.Duration(jitterSource.Int63n(int64(SendJitter)+1))
+}
+
+// Hold pauses sending the email to the result until it's released, such as
+// when the recipient is on leave.
+func (r *Result) Hold() error {
+	_, err := r.createEvent(EVENT_HELD, nil)
+	if err != nil {
+		return err
+	}
+	r.OnHold = true
+	return ResultStorage.Save(r)
+}
+
+// Release resumes sending the email to a result that was put on hold.
+func (r *Result) Release() error {
+	_, err := r.createEvent(EVENT_RELEASED, nil)
+	if err != nil {
+		return err
+	}
+	r.OnHold = false
+	return ResultStorage.Save(r)
+}
+
+// idSource is the source of randomness used to generate result IDs. It can be
+// replaced in tests to produce predictable IDs.
+var idSource io.Reader = rand.Reader
+
+// IdLookupBatchSize is the most generated result IDs which are checked for
+// collisions with a single query
+var IdLookupBatchSize = 500
+
+// existingIds returns which of the given result IDs are already in use. It
+// can be replaced in tests to simulate collisions.
+var existingIds = func(rids []string) (map[string]bool, error) {
+	found := []string{}
+	// Removed results keep their ID, so they're included
+	err := db.Unscoped().Table("results").Where("r_id in (?)", rids).Pluck("r_id", &found).Error
+	existing := make(map[string]bool)
+	for _, rid := range found {
+		existing[rid] = true
+	}
+	return existing, err
+}
+
+// randomId generates a random key that can be used to represent a result,
+// using the configured length and alphabet.
+func randomId() (string, error) {
+	k := make([]byte, RecipientIdLength)
+	for i := range k {
+		idx, err := rand.Int(idSource, big.NewInt(int64(len(RecipientIdAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		k[i] = RecipientIdAlphabet[idx.Int64()]
+	}
+	return string(k), nil
+}
+
+// GenerateIds generates n unique keys that can be used to represent results.
+// The keys are unique both within the batch and across the existing results.
+// Keys are generated in batches of up to IdLookupBatchSize, and each batch is
+// checked against the existing results with a single query.
+func GenerateIds(n int) ([]string, error) {
+	ids := []string{}
+	seen := make(map[string]bool)
+	// Keep trying until we generate enough unique keys (collisions should be
+	// rare, so this shouldn't take many extra batches)
+	for len(ids) < n {
+		count := n - len(ids)
+		if count > IdLookupBatchSize {
+			count = IdLookupBatchSize
+		}
+		candidates := []string{}
+		for len(candidates) < count {
+			id, err := randomId()
+			if err != nil {
+				return nil, err
+			}
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			candidates = append(candidates, id)
+		}
+		existing, err := existingIds(candidates)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range candidates {
+			if !existing[id] {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// GenerateId generates a unique key to represent the result
+// in the database
+func (r *Result) GenerateId() error {
+	ids, err := GenerateIds(1)
+	if err != nil {
+		return err
+	}
+	r.RId = ids[0]
+	return nil
+}
+
+// setMessageId generates a unique Message-Id header value for the result's
+// email. The value is stored so that it can later be matched against the
+// sending MTA's delivery logs.
+func (r *Result) setMessageId() error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Error(err)
+		hostname = "localhost"
+	}
+	r.MessageId = fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), r.RId, hostname)
+	return ResultStorage.Save(r)
+}
+
+// nameTokens splits a name into lowercase tokens consisting only of letters.
+func nameTokens(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(c rune) bool {
+		return !unicode.IsLetter(c)
+	})
+}
+
+// NameEmailMismatch returns whether or not the result's name appears to have
+// nothing in common with the local part of its email address, which usually
+// indicates misaligned columns in an imported CSV. To avoid false positives,
+// a result is only flagged when none of the name tokens (or their first three
+// letters) can be found in the local part and the local part isn't made up
+// of the name's initials.
+func (r *Result) NameEmailMismatch() bool {
+	tokens := append(nameTokens(r.FirstName), nameTokens(r.LastName)...)
+	local := strings.SplitN(r.Email, "@", 2)[0]
+	letters := strings.Join(nameTokens(local), "")
+	if len(tokens) == 0 || letters == "" {
+		return false
+	}
+	initials := ""
+	for _, t := range tokens {
+		prefix := []rune(t)
+		if len(prefix) > 3 {
+			prefix = prefix[:3]
+		}
+		if strings.Contains(letters, string(prefix)) {
+			return false
+		}
+		initials += string(prefix[0])
+	}
+	return !strings.HasPrefix(letters, initials)
+}
+
+// ErrInvalidEmail is thrown when a recipient's email address can't be parsed
+var ErrInvalidEmail = errors.New("Invalid email address")
+
+// Validate checks that the result's email address is a bare address which can
+// be parsed, so that broken recipients are caught before they're sent to.
+func (r *Result) Validate() error {
+	if strings.TrimSpace(r.Email) == "" {
+		return ErrEmailNotSpecified
+	}
+	a, err := mail.ParseAddress(r.Email)
+	if err != nil || a.Address != r.Email {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+// FormatAddress returns the email address to use in the "To" header of the email.
+// Display names containing non-ASCII characters are RFC 2047 encoded.
+func (r *Result) FormatAddress() string {
+	addr := r.Email
+	if r.FirstName != "" && r.LastName != "" {
+		a := &mail.Address{
+			Name:    fmt.Sprintf("%s %s", r.FirstName, r.LastName),
+			Address: r.Email,
+		}
+		addr = a.String()
+	}
+	return addr
+}
+
+// GetResult returns the Result object from the database
+// given the ResultId
+func GetResult(rid string) (Result, error) {
+	return ResultStorage.Get(rid)
+}
+
+// DeleteResult removes the result with the given result ID from its campaign,
+// such as when the target shouldn't have been included. The result is soft
+// deleted, so it's excluded from lookups and statistics but its row and events
+// are kept for auditing, and it can be brought back with RestoreResult. Its
+// email is no longer sent.
+func DeleteResult(rid string) error {
+	// Held saves are written first, so that they can't restore the result
+	// later
+	err := FlushResults()
+	if err != nil {
+		return err
+	}
+	r, err := ResultStorage.Get(rid)
+	if err != nil {
+		return err
+	}
+	return db.Model(&r).UpdateColumn("deleted_at", time.Now().UTC()).Error
+}
+
+// RestoreResult undoes the removal of the result with the given result ID by
+// DeleteResult. If there's no removed result with the ID,
+// gorm.ErrRecordNotFound is returned.
+func RestoreResult(rid string) error {
+	err := FlushResults()
+	if err != nil {
+		return err
+	}
+	query := db.Unscoped().Model(&Result{}).Where("r_id=? and deleted_at is not null", rid).
+		UpdateColumn("deleted_at", nil)
+	if query.Error != nil {
+		return query.Error
+	}
+	if query.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetDeletedResults returns the results which have been removed from the
+// campaign specified by the given id and user_id by DeleteResult.
+func GetDeletedResults(cid int64, uid int64) ([]Result, error) {
+	rs := []Result{}
+	err := FlushResults()
+	if err != nil {
+		return rs, err
+	}
+	err = db.Unscoped().Where("campaign_id=? and user_id in (?) and deleted_at is not null", cid, teamUserIds(uid)).
+		Order("id asc").Find(&rs).Error
+	return rs, err
+}
+
+// normalizeMessageId strips the surrounding whitespace and angle brackets
+// from a Message-Id so that values from different sources can be compared.
+func normalizeMessageId(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
+
+// ReconcileDelivery marks the results in the given campaign whose Message-Id
+// appears in the provided list of delivered message ids, typically taken
+// from the sending MTA's delivery logs. It returns the number of delivered
+// ids that matched a result and the number that didn't match any result.
+func ReconcileDelivery(cid int64, uid int64, delivered []string) (int, int, error) {
+	matched, unmatched := 0, 0
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return matched, unmatched, err
+	}
+	byId := make(map[string]*Result)
+	for i := range rs {
+		if rs[i].MessageId == "" {
+			continue
+		}
+		byId[normalizeMessageId(rs[i].MessageId)] = &rs[i]
+	}
+	for _, id := range delivered {
+		r, ok := byId[normalizeMessageId(id)]
+		if !ok {
+			unmatched++
+			continue
+		}
+		matched++
+		if r.Delivered {
+			continue
+		}
+		r.Delivered = true
+		err = ResultStorage.Save(r)
+		if err != nil {
+			return matched, unmatched, err
+		}
+	}
+	return matched, unmatched, nil
+}
+
+// FindNameEmailMismatches returns the results in the given campaign whose
+// names don't appear to match their email addresses.
+func FindNameEmailMismatches(cid int64, uid int64) ([]Result, error) {
+	ms := []Result{}
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return ms, err
+	}
+	for _, r := range rs {
+		if r.NameEmailMismatch() {
+			ms = append(ms, r)
+		}
+	}
+	return ms, nil
+}
+
+// ErrInvalidFilterAttribute is thrown when a ResultFilter references an
+// attribute that results can't be filtered on.
+var ErrInvalidFilterAttribute = errors.New("Invalid result filter attribute")
+
+// filterAttributes maps the attribute names in a ResultFilter which are
+// stored in their own database columns to those columns. Any other attribute
+// is matched against the target attributes kept with the result.
+var filterAttributes = map[string]string{
+	"email":      "email",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+	"position":   "position",
+}
+
+// ResultFilter describes a segment of campaign results. Results must match
+// every include predicate and none of the exclude predicates. Empty predicates
+// are ignored. Attributes are either the result's email, first_name,
+// last_name or position, or one of the custom attributes the target was
+// imported with, whose names are matched ignoring case. Results match the
+// included tags if they have any of them, ignoring case.
+type ResultFilter struct {
+	IncludeStatuses   []string            `json:"include_statuses"`
+	ExcludeStatuses   []string            `json:"exclude_statuses"`
+	Reported          *bool               `json:"reported"`
+	IncludeAttributes map[string][]string `json:"include_attributes"`
+	ExcludeAttributes map[string][]string `json:"exclude_attributes"`
+	IncludeTags       []string            `json:"include_tags"`
+	ExcludeTags       []string            `json:"exclude_tags"`
+}
+
+// lowerAll returns a copy of the given strings in lower case.
+func lowerAll(ss []string) []string {
+	lower := make([]string, len(ss))
+	for i, s := range ss {
+		lower[i] = strings.ToLower(s)
+	}
+	return lower
+}
+
+// matchesCustomAttributes returns whether or not the custom attributes of the
+// result match every included value and none of the excluded values. Results
+// without an attribute are treated as having an empty value for it.
+func (r *Result) matchesCustomAttributes(include map[string][]string, exclude map[string][]string) (bool, error) {
+	attrs, err := r.Attributes()
+	if err != nil {
+		return false, err
+	}
+	value := func(name string) string {
+		for k, v := range attrs {
+			if strings.EqualFold(k, name) {
+				return v
+			}
+		}
+		return ""
+	}
+	contains := func(vs []string, v string) bool {
+		for _, s := range vs {
+			if s == v {
+				return true
+			}
+		}
+		return false
+	}
+	for k, vs := range include {
+		if len(vs) > 0 && !contains(vs, value(k)) {
+			return false, nil
+		}
+	}
+	for k, vs := range exclude {
+		if contains(vs, value(k)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// QueryResults returns the results in the given campaign that match the
+// filter. For example, to find the recipients who clicked the link but
+// didn't report the email, include EVENT_CLICKED and set Reported to false.
+func QueryResults(cid int64, uid int64, f ResultFilter) ([]Result, error) {
+	rs := []Result{}
+	err := FlushResults()
+	if err != nil {
+		return rs, err
+	}
+	query := db.Table("results").Where("campaign_id=? and user_id in (?)", cid, teamUserIds(uid))
+	if len(f.IncludeStatuses) > 0 {
+		query = query.Where("status in (?)", f.IncludeStatuses)
+	}
+	if len(f.ExcludeStatuses) > 0 {
+		query = query.Where("status not in (?)", f.ExcludeStatuses)
+	}
+	if f.Reported != nil {
+		query = query.Where("reported=?", *f.Reported)
+	}
+	if len(f.IncludeTags) > 0 {
+		query = query.Where("r_id in (select r_id from result_tags where campaign_id=? and lower(tag) in (?))",
+			cid, lowerAll(f.IncludeTags))
+	}
+	if len(f.ExcludeTags) > 0 {
+		query = query.Where("r_id not in (select r_id from result_tags where campaign_id=? and lower(tag) in (?))",
+			cid, lowerAll(f.ExcludeTags))
+	}
+	// Attributes with their own columns are filtered in the query, and the
+	// custom attributes once the results are loaded
+	include := make(map[string][]string)
+	exclude := make(map[string][]string)
+	for k, vs := range f.IncludeAttributes {
+		if strings.TrimSpace(k) == "" {
+			return rs, ErrInvalidFilterAttribute
+		}
+		col, ok := filterAttributes[k]
+		switch {
+		case !ok:
+			include[k] = vs
+		case len(vs) > 0:
+			query = query.Where(col+" in (?)", vs)
+		}
+	}
+	for k, vs := range f.ExcludeAttributes {
+		if strings.TrimSpace(k) == "" {
+			return rs, ErrInvalidFilterAttribute
+		}
+		col, ok := filterAttributes[k]
+		switch {
+		case !ok:
+			exclude[k] = vs
+		case len(vs) > 0:
+			query = query.Where(col+" not in (?)", vs)
+		}
+	}
+	err = query.Find(&rs).Error
+	if err != nil || len(include)+len(exclude) == 0 {
+		return rs, err
+	}
+	matched := []Result{}
+	for i := range rs {
+		ok, err := rs[i].matchesCustomAttributes(include, exclude)
+		if err != nil {
+			return matched, err
+		}
+		if ok {
+			matched = append(matched, rs[i])
+		}
+	}
+	return matched, nil
+}
+
+// DefaultResultPageSize is the number of results returned in a page when the
+// query doesn't specify a limit.
+var DefaultResultPageSize = 100
+
+// MaxResultPageSize is the largest number of results returned in a page.
+var MaxResultPageSize = 1000
+
+// ResultQuery describes a page of a campaign's results. Results can be
+// filtered by status, whether they were reported, a case-insensitive
+// substring of their email address and the range of times they were last
+// modified. Empty filters are ignored. Sort is a comma separated list of
+// fields, each prefixed with "-" to sort in descending order.
+type ResultQuery struct {
+	Offset   int       `json:"offset"`
+	Limit    int       `json:"limit"`
+	Statuses []string  `json:"statuses"`
+	Search   string    `json:"search"`
+	Reported *bool     `json:"reported"`
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	Sort     string    `json:"sort"`
+}
+
+// resultSortColumns are the fields a page of results can be sorted by
+var resultSortColumns = map[string]string{
+	"email":         "email",
+	"first_name":    "first_name",
+	"last_name":     "last_name",
+	"position":      "position",
+	"status":        "status",
+	"reported":      "reported",
+	"send_date":     "send_date",
+	"modified_date": "modified_date",
+}
+
+// likeEscaper escapes the wildcard characters in a LIKE pattern, using "!"
+// as the escape character since it's handled the same way by every database.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// GetResultsPage returns a page of the results in the campaign specified by
+// the given id and user_id which match the query, along with the total number
+// of matching results. Results are ordered by id unless the query is sorted.
+func GetResultsPage(cid int64, uid int64, q ResultQuery) ([]Result, int64, error) {
+	rs := []Result{}
+	err := FlushResults()
+	if err != nil {
+		return rs, 0, err
+	}
+	query := db.Model(&Result{}).Where("campaign_id=? and user_id in (?)", cid, teamUserIds(uid))
+	if len(q.Statuses) > 0 {
+		query = query.Where("status in (?)", q.Statuses)
+	}
+	if q.Search != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(q.Search)) + "%"
+		query = query.Where("lower(email) like ? escape '!'", pattern)
+	}
+	if q.Reported != nil {
+		query = query.Where("reported=?", *q.Reported)
+	}
+	if !q.Since.IsZero() {
+		query = query.Where("modified_date >= ?", q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		query = query.Where("modified_date <= ?", q.Until.UTC())
+	}
+	order, err := parseSort(q.Sort, resultSortColumns)
+	if err != nil {
+		return rs, 0, err
+	}
+	var total int64
+	err = query.Count(&total).Error
+	if err != nil {
+		return rs, 0, err
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultResultPageSize
+	}
+	if limit > MaxResultPageSize {
+		limit = MaxResultPageSize
+	}
+	offset := q.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	err = query.Order(order).Offset(offset).Limit(limit).Find(&rs).Error
+	return rs, total, err
+}
+
+// IsLateEngagement returns whether or not the recipient's first engagement
+// with the campaign, such as opening the email or clicking the link, occurred
+// after the given campaign end. Results without any engagement are not late.
+func (r *Result) IsLateEngagement(end time.Time) (bool, error) {
+	es, err := r.GetEvents()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range es {
+		switch e.Message {
+		case EVENT_OPENED, EVENT_CLICKED, EVENT_DATA_SUBMIT:
+			return e.Time.After(end), nil
+		}
+	}
+	return false, nil
+}
+
+// GetLateEngagements returns the results in the campaign specified by the given
+// id and user_id whose first engagement occurred after the given end.
+func GetLateEngagements(cid int64, uid int64, end time.Time) ([]Result, error) {
+	late := []Result{}
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return late, err
+	}
+	for _, r := range rs {
+		ok, err := r.IsLateEngagement(end)
+		if err != nil {
+			return late, err
+		}
+		if ok {
+			late = append(late, r)
+		}
+	}
+	return late, nil
+}
+
+// ErrInvalidTrackingDomain is thrown when a result is assigned a tracking
+// domain that isn't a valid host name.
+var ErrInvalidTrackingDomain = errors.New("Invalid tracking domain")
+
+// validTrackingDomain returns whether or not the given domain is a valid host
+// name, with an optional port, that can be used in a campaign URL.
+func validTrackingDomain(domain string) bool {
+	u, err := url.Parse("http://" + domain)
+	if err != nil || u.Host != domain || u.Hostname() == "" {
+		return false
+	}
+	for _, label := range strings.Split(u.Hostname(), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SetTrackingDomain sets the domain used in the URLs sent to the result,
+// overriding the domain in the campaign's URL. An empty domain reverts to the
+// campaign's default.
+func (r *Result) SetTrackingDomain(domain string) error {
+	if domain != "" && !validTrackingDomain(domain) {
+		return ErrInvalidTrackingDomain
+	}
+	r.TrackingDomain = domain
+	return ResultStorage.Save(r)
+}
+
+// LandingPageStatus returns the HTTP status code of the landing page most
+// recently served to the recipient, and whether or not a status was recorded.
+func (r *Result) LandingPageStatus() (int, bool) {
+	es, err := r.GetEvents()
+	if err != nil {
+		log.Error(err)
+		return 0, false
+	}
+	for i := len(es) - 1; i >= 0; i-- {
+		if es[i].Message != EVENT_CLICKED && es[i].Message != EVENT_DATA_SUBMIT {
+			continue
+		}
+		d, err := es[i].parseDetails()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if d.StatusCode != 0 {
+			return d.StatusCode, true
+		}
+	}
+	return 0, false
+}
+
+// FinalLandingURL returns the URL the recipient ended up on after the landing
+// page most recently served to them, including any redirect after submitting
+// data, and whether or not a URL was recorded.
+func (r *Result) FinalLandingURL() (string, bool) {
+	es, err := r.GetEvents()
+	if err != nil {
+		log.Error(err)
+		return "", false
+	}
+	for i := len(es) - 1; i >= 0; i-- {
+		if es[i].Message != EVENT_CLICKED && es[i].Message != EVENT_DATA_SUBMIT {
+			continue
+		}
+		d, err := es[i].parseDetails()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if d.LandingURL != "" {
+			return d.LandingURL, true
+		}
+	}
+	return "", false
+}
+
+// The types of email providers a result's email address can be hosted by
+const (
+	PROVIDER_WEBMAIL   string = "webmail"
+	PROVIDER_CORPORATE string = "corporate"
+	PROVIDER_UNKNOWN   string = "unknown"
+)
+
+// WebmailDomains are the email domains classified as personal webmail
+// providers. Subdomains of these domains are also matched.
+var WebmailDomains = []string{
+	"gmail.com", "googlemail.com", "yahoo.com", "outlook.com", "hotmail.com",
+	"live.com", "msn.com", "aol.com", "icloud.com", "me.com", "protonmail.com",
+	"gmx.com", "mail.com", "yandex.com", "zoho.com",
+}
+
+// CorporateDomains are the email domains classified as corporate mail
+// providers, such as the organization's own domains. Subdomains of these
+// domains are also matched.
+var CorporateDomains = []string{}
+
+// ProviderMXLookup determines whether or not domains which aren't in either
+// list are classified by looking up their MX records. Domains that accept
+// mail are then considered corporate.
+var ProviderMXLookup = false
+
+// lookupMX is used to look up MX records when classifying providers. It can
+// be replaced in tests to avoid network access.
+var lookupMX = net.LookupMX
+
+// configureEmailProviders sets the corporate domains, whether or not other
+// domains are classified by their MX records, and the hosts mail gateways
+// rewrite links to. The DefaultSafeLinksHosts are used unless a list of hosts
+// is given.
+func configureEmailProviders(conf config.EmailProviders) {
+	CorporateDomains = []string{}
+	for _, d := range conf.CorporateDomains {
+		if d = strings.TrimSpace(d); d != "" {
+			CorporateDomains = append(CorporateDomains, d)
+		}
+	}
+	ProviderMXLookup = conf.MXLookup
+	SafeLinksHosts = DefaultSafeLinksHosts
+	if conf.SafeLinksHosts != nil {
+		SafeLinksHosts = []string{}
+		for _, h := range conf.SafeLinksHosts {
+			if h = strings.TrimSpace(h); h != "" {
+				SafeLinksHosts = append(SafeLinksHosts, h)
+			}
+		}
+	}
+}
+
+// matchesDomain returns whether or not the domain is, or is a subdomain of,
+// one of the given domains.
+func matchesDomain(domain string, domains []string) bool {
+	for _, d := range domains {
+		d = strings.ToLower(d)
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyProvider sets the result's provider type based on the domain of
+// its email address.
+func (r *Result) classifyProvider() {
+	r.ProviderType = PROVIDER_UNKNOWN
+	i := strings.LastIndex(r.Email, "@")
+	if i == -1 {
+		return
+	}
+	domain := normalizeEmail(r.Email[i+1:])
+	switch {
+	case matchesDomain(domain, WebmailDomains):
+		r.ProviderType = PROVIDER_WEBMAIL
+	case matchesDomain(domain, CorporateDomains):
+		r.ProviderType = PROVIDER_CORPORATE
+	case ProviderMXLookup:
+		mxs, err := lookupMX(domain)
+		if err == nil && len(mxs) > 0 {
+			r.ProviderType = PROVIDER_CORPORATE
+		}
+	}
+}
+
+// ClassifyProvider classifies the result's email provider as webmail,
+// corporate, or unknown and updates the result in the database.
+func (r *Result) ClassifyProvider() error {
+	r.classifyProvider()
+	return ResultStorage.Save(r)
+}
+
+// GetProviderBreakdown returns the number of results in the campaign specified
+// by the given id and user_id of each provider type. Results which haven't
+// been classified are counted as unknown.
+func GetProviderBreakdown(cid int64, uid int64) (map[string]int64, error) {
+	breakdown := map[string]int64{
+		PROVIDER_WEBMAIL:   0,
+		PROVIDER_CORPORATE: 0,
+		PROVIDER_UNKNOWN:   0,
+	}
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return breakdown, err
+	}
+	for _, r := range rs {
+		pt := r.ProviderType
+		if pt == "" {
+			pt = PROVIDER_UNKNOWN
+		}
+		breakdown[pt]++
+	}
+	return breakdown, nil
+}
+
+// HostingNetworks are the IP networks, in CIDR notation, of hosting and cloud
+// providers. Engagement from these networks is likely automated rather than
+// from the recipient.
+var HostingNetworks = []string{}
+
+// HostingASNs are the autonomous system numbers of hosting and cloud
+// providers, which are looked up in the GeoIP ASN database. Like the
+// HostingNetworks, engagement from them is likely automated.
+var HostingASNs = []uint{}
+
+// CorporateNetworks are the IP networks, in CIDR notation, belonging to the
+// organization being tested, such as its offices and VPN egress addresses.
+var CorporateNetworks = []string{}
+
+// CorporateASNs are the autonomous system numbers belonging to the
+// organization being tested, which are looked up in the GeoIP ASN database.
+var CorporateASNs = []uint{}
+
+// TorExitNodes are the IP addresses of known Tor exit nodes.
+var TorExitNodes = []string{}
+
+// ErrInvalidNetwork is thrown when one of the configured hosting networks
+// isn't in CIDR notation, or one of the Tor exit nodes isn't an IP address
+var ErrInvalidNetwork = errors.New("Hosting networks must be in CIDR notation and Tor exit nodes must be IP addresses")
+
+// configureGeoSuspicion sets the hosting networks and autonomous systems and
+// the Tor exit nodes, returning an error if any of them aren't valid.
+func configureGeoSuspicion(conf config.GeoSuspicion) error {
+	for _, network := range conf.HostingNetworks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return ErrInvalidNetwork
+		}
+	}
+	for _, node := range conf.TorExitNodes {
+		if net.ParseIP(node) == nil {
+			return ErrInvalidNetwork
+		}
+	}
+	HostingNetworks = append([]string{}, conf.HostingNetworks...)
+	HostingASNs = append([]uint{}, conf.HostingASNs...)
+	TorExitNodes = append([]string{}, conf.TorExitNodes...)
+	return nil
+}
+
+// ErrInvalidCorporateNetwork is thrown when one of the configured corporate
+// networks isn't in CIDR notation
+var ErrInvalidCorporateNetwork = errors.New("Corporate networks must be in CIDR notation")
+
+// configureCorporateNetworks sets the networks and autonomous systems
+// belonging to the organization being tested, returning an error if any of
+// the networks aren't valid.
+func configureCorporateNetworks(conf config.CorporateNetworks) error {
+	for _, network := range conf.Networks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return ErrInvalidCorporateNetwork
+		}
+	}
+	CorporateNetworks = append([]string{}, conf.Networks...)
+	CorporateASNs = append([]uint{}, conf.ASNs...)
+	return nil
+}
+
+// ImpossibleTravelSpeed is the fastest speed, in kilometers per hour, that a
+// recipient can plausibly travel between two events.
+var ImpossibleTravelSpeed = 1000.0
+
+// SuspicionReport contains the geolocation signals that indicate a result's
+// engagement may not have come from the recipient.
+type SuspicionReport struct {
+	HostingNetwork   bool `json:"hosting_network"`
+	HostingASN       bool `json:"hosting_asn"`
+	TorExitNode      bool `json:"tor_exit_node"`
+	ImpossibleTravel bool `json:"impossible_travel"`
+	CountryMismatch  bool `json:"country_mismatch"`
+}
+
+// Suspicious returns whether or not any of the signals in the report tripped.
+func (sr SuspicionReport) Suspicious() bool {
+	return sr.HostingNetwork || sr.HostingASN || sr.TorExitNode || sr.ImpossibleTravel || sr.CountryMismatch
+}
+
+// distance returns the great-circle distance in kilometers between the two
+// points using the haversine formula.
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371.0
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(lat2 - lat1)
+	dLon := rad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// inNetworks returns whether or not the address belongs to one of the given
+// networks, in CIDR notation.
+func inNetworks(addr string, networks []string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range networks {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// inHostingASN returns whether or not the address belongs to one of the
+// HostingASNs.
+func inHostingASN(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil || len(HostingASNs) == 0 {
+		return false
+	}
+	asn, ok := lookupASN(ip)
+	return ok && containsASN(HostingASNs, asn)
+}
+
+// isCorporateAddress returns whether or not the address belongs to one of the
+// CorporateNetworks or CorporateASNs.
+func isCorporateAddress(addr string) bool {
+	if inNetworks(addr, CorporateNetworks) {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || len(CorporateASNs) == 0 {
+		return false
+	}
+	asn, ok := lookupASN(ip)
+	return ok && containsASN(CorporateASNs, asn)
+}
+
+// isHostingAddress returns whether or not the address belongs to one of the
+// HostingNetworks or HostingASNs.
+func isHostingAddress(addr string) bool {
+	return inNetworks(addr, HostingNetworks) || inHostingASN(addr)
+}
+
+// isTorExitNode returns whether or not the address is one of the TorExitNodes.
+func isTorExitNode(addr string) bool {
+	for _, node := range TorExitNodes {
+		if addr == node {
+			return true
+		}
+	}
+	return false
+}
+
+// GeoSuspicion returns which of the geolocation signals tripped for the
+// result's events: engagement from a hosting network, a hosting provider's
+// autonomous system or a Tor exit node, travel between two events faster than
+// ImpossibleTravelSpeed, or engagement from more than one country.
+func (r *Result) GeoSuspicion() (SuspicionReport, error) {
+	sr := SuspicionReport{}
+	es, err := r.GetEvents()
+	if err != nil {
+		return sr, err
+	}
+	countries := make(map[string]bool)
+	for _, e := range es {
+		d, err := e.parseDetails()
+		if err != nil {
+			return sr, err
+		}
+		addr := d.Browser["address"]
+		if addr != "" {
+			sr.HostingNetwork = sr.HostingNetwork || inNetworks(addr, HostingNetworks)
+			sr.HostingASN = sr.HostingASN || inHostingASN(addr)
+			sr.TorExitNode = sr.TorExitNode || isTorExitNode(addr)
+		}
+		if d.Country != "" {
+			countries[d.Country] = true
+		}
+	}
+	sr.CountryMismatch = len(countries) > 1
+	trail, err := r.GeoTrail()
+	if err != nil {
+		return sr, err
+	}
+	for i := 1; i < len(trail); i++ {
+		prev, cur := trail[i-1], trail[i]
+		km := distance(prev.Latitude, prev.Longitude, cur.Latitude, cur.Longitude)
+		if cur.Time.Sub(prev.Time).Hours() < km/ImpossibleTravelSpeed {
+			sr.ImpossibleTravel = true
+			break
+		}
+	}
+	return sr, nil
+}
+
+// GetSuspiciousGeoResults returns the suspicion reports for the results in
+// the campaign specified by the given id and user_id, keyed by result ID.
+// Only results that tripped at least one of the signals are included.
+func GetSuspiciousGeoResults(cid int64, uid int64) (map[string]SuspicionReport, error) {
+	reports := make(map[string]SuspicionReport)
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return reports, err
+	}
+	for _, r := range rs {
+		sr, err := r.GeoSuspicion()
+		if err != nil {
+			return reports, err
+		}
+		if sr.Suspicious() {
+			reports[r.RId] = sr
+		}
+	}
+	return reports, nil
+}
+
+// CrossedDevices returns whether or not the recipient engaged with the email
+// both from one of the CorporateNetworks or CorporateASNs and from a
+// residential network, indicating they viewed it on a personal device as well
+// as a work one. Addresses in the HostingNetworks, HostingASNs or TorExitNodes
+// aren't considered residential.
+func (r *Result) CrossedDevices() (bool, error) {
+	es, err := r.GetEvents()
+	if err != nil {
+		return false, err
+	}
+	corporate, residential := false, false
+	for _, e := range es {
+		d, err := e.parseDetails()
+		if err != nil {
+			return false, err
+		}
+		addr := d.Browser["address"]
+		switch {
+		case addr == "":
+			continue
+		case isCorporateAddress(addr):
+			corporate = true
+		case !isHostingAddress(addr) && !isTorExitNode(addr):
+			residential = true
+		}
+		if corporate && residential {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GeoDivergenceThreshold is the distance, in kilometers, between where the
+// email was opened and where the link was clicked beyond which the click is
+// annotated in the result's risk timeline.
+var GeoDivergenceThreshold = 500.0
+
+// ANNOTATION_GEO_DIVERGENCE annotates a click which geolocated far from where
+// the email was opened.
+const ANNOTATION_GEO_DIVERGENCE string = "open-click-geo-divergence"
+
+// TimelineEntry is a single event in a result's risk timeline, along with any
+// annotations derived from the result's other events.
+type TimelineEntry struct {
+	Message     string    `json:"message"`
+	Time        time.Time `json:"time"`
+	Annotations []string  `json:"annotations"`
+}
+
+// isKnownProxy returns whether or not the address belongs to a hosting
+// network or Tor exit node, and so doesn't reflect where the recipient is.
+func isKnownProxy(addr string) bool {
+	return isHostingAddress(addr) || isTorExitNode(addr)
+}
+
+// openClickLocations returns the details of the first open and click events
+// which have coordinates and didn't come from a known proxy, along with the
+// index of the click event.
+func openClickLocations(es []Event) (open EventDetails, click EventDetails, clickIdx int, err error) {
+	foundOpen, foundClick := false, false
+	clickIdx = -1
+	for i, e := range es {
+		if e.Message != EVENT_OPENED && e.Message != EVENT_CLICKED {
+			continue
+		}
+		d, err := e.parseDetails()
+		if err != nil {
+			return open, click, -1, err
+		}
+		if (d.Latitude == 0 && d.Longitude == 0) || isKnownProxy(d.Browser["address"]) {
+			continue
+		}
+		switch {
+		case e.Message == EVENT_OPENED && !foundOpen:
+			open, foundOpen = d, true
+		case e.Message == EVENT_CLICKED && !foundClick:
+			click, foundClick, clickIdx = d, true, i
+		}
+	}
+	if !foundOpen {
+		clickIdx = -1
+	}
+	return open, click, clickIdx, nil
+}
+
+// OpenClickGeoDivergence returns the distance, in kilometers, between where
+// the recipient first opened the email and where they first clicked the link.
+// Events without coordinates or from known proxies are ignored, and false is
+// returned if either location isn't available.
+func (r *Result) OpenClickGeoDivergence() (float64, bool, error) {
+	es, err := r.GetEvents()
+	if err != nil {
+		return 0, false, err
+	}
+	open, click, idx, err := openClickLocations(es)
+	if err != nil || idx == -1 {
+		return 0, false, err
+	}
+	return distance(open.Latitude, open.Longitude, click.Latitude, click.Longitude), true, nil
+}
+
+// RiskTimeline returns the result's events in order, annotated with signals
+// derived from the events, such as a click far from where the email was
+// opened.
+func (r *Result) RiskTimeline() ([]TimelineEntry, error) {
+	timeline := []TimelineEntry{}
+	es, err := r.GetEvents()
+	if err != nil {
+		return timeline, err
+	}
+	for _, e := range es {
+		timeline = append(timeline, TimelineEntry{
+			Message:     e.Message,
+			Time:        e.Time,
+			Annotations: []string{},
+		})
+	}
+	open, click, idx, err := openClickLocations(es)
+	if err != nil {
+		return timeline, err
+	}
+	if idx != -1 && distance(open.Latitude, open.Longitude, click.Latitude, click.Longitude) > GeoDivergenceThreshold {
+		timeline[idx].Annotations = append(timeline[idx].Annotations, ANNOTATION_GEO_DIVERGENCE)
+	}
+	return timeline, nil
+}
+
+// DefaultSafeLinksHosts are the SafeLinksHosts used if none are configured.
+var DefaultSafeLinksHosts = []string{
+	"safelinks.protection.outlook.com",
+	"urldefense.proofpoint.com",
+	"urldefense.com",
+	"protect.mimecast.com",
+	"linkprotect.cudasvc.com",
+	"secure-web.cisco.com",
+}
+
+// SafeLinksHosts are the hosts used by mail gateways to rewrite links for
+// scanning, such as Microsoft's Safe Links. Subdomains of these hosts are
+// also matched.
+var SafeLinksHosts = DefaultSafeLinksHosts
+
+// CameViaSafeLinks returns whether or not the recipient reached the landing
+// page through a link rewritten by a mail gateway, based on the referer
+// recorded when they clicked the link or submitted data.
+func (r *Result) CameViaSafeLinks() (bool, error) {
+	es, err := r.GetEvents()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range es {
+		if e.Message != EVENT_CLICKED && e.Message != EVENT_DATA_SUBMIT {
+			continue
+		}
+		d, err := e.parseDetails()
+		if err != nil {
+			return false, err
+		}
+		u, err := url.Parse(d.Browser["referer"])
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		if matchesDomain(strings.ToLower(u.Hostname()), SafeLinksHosts) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SendOffset returns how long after the campaign launched the email was sent
+// to the result. If the email hasn't been sent yet, the offset of the
+// scheduled send date is returned.
+func (r *Result) SendOffset() time.Duration {
+	c := Campaign{}
+	err := db.Where("id=?", r.CampaignId).First(&c).Error
+	if err != nil {
+		log.Error(err)
+		return 0
+	}
+	sent := r.SendDate
+	e := Event{}
+	err = db.Where("campaign_id=? and email=? and message=?", r.CampaignId, r.Email, EVENT_SENT).
+		Order("time asc, id asc").First(&e).Error
+	if err == nil {
+		sent = e.Time
+	} else if err != gorm.ErrRecordNotFound {
+		log.Error(err)
+	}
+	return sent.Sub(c.LaunchDate)
+}
+
+// EngagementOffsets returns how long after the email was sent the recipient
+// first opened it, clicked the link, and submitted data, keyed by the event.
+// Events the recipient never triggered are omitted.
+func (r *Result) EngagementOffsets() (map[string]time.Duration, error) {
+	offsets := make(map[string]time.Duration)
+	for _, message := range []string{EVENT_OPENED, EVENT_CLICKED, EVENT_DATA_SUBMIT} {
+		d, ok, err := r.timeTo(message)
+		if err != nil {
+			return offsets, err
+		}
+		if ok {
+			offsets[message] = d
+		}
+	}
+	return offsets, nil
+}
+
+// ClickedWithoutAnyOpen returns whether or not the recipient clicked the link
+// without the tracking pixel or an attachment ever being opened. This can
+// indicate a client which blocks images, or that the link was forwarded and
+// clicked by someone else.
+func (r *Result) ClickedWithoutAnyOpen() (bool, error) {
+	es, err := r.GetEvents()
+	if err != nil {
+		return false, err
+	}
+	clicked := false
+	for _, e := range es {
+		switch e.Message {
+		case EVENT_OPENED, EVENT_ATTACHMENT:
+			return false, nil
+		case EVENT_CLICKED, EVENT_DATA_SUBMIT:
+			clicked = true
+		}
+	}
+	return clicked, nil
+}
+
+// GetClickedWithoutOpenResults returns the results in the campaign specified
+// by the given id and user_id which clicked the link without any recorded
+// open.
+func GetClickedWithoutOpenResults(cid int64, uid int64) ([]Result, error) {
+	found := []Result{}
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return found, err
+	}
+	for _, r := range rs {
+		ok, err := r.ClickedWithoutAnyOpen()
+		if err != nil {
+			return found, err
+		}
+		if ok {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+// FirstTouch returns the type and time of the first event in which the
+// recipient engaged with the email, such as opening it, clicking the link or
+// reporting it. An empty type is returned if the recipient never engaged.
+func (r *Result) FirstTouch() (string, time.Time, error) {
+	es, err := r.GetEvents()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	for _, e := range es {
+		switch e.Message {
+		case EVENT_OPENED, EVENT_ATTACHMENT, EVENT_CLICKED, EVENT_DATA_SUBMIT, EVENT_REPORTED:
+			return e.Message, e.Time, nil
+		}
+	}
+	return "", time.Time{}, nil
+}
+
+// GetFirstTouchBreakdown returns the number of results in the campaign
+// specified by the given id and user_id whose first engagement was each type
+// of event. Results which never engaged are not counted.
+func GetFirstTouchBreakdown(cid int64, uid int64) (map[string]int64, error) {
+	breakdown := map[string]int64{}
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return breakdown, err
+	}
+	for _, r := range rs {
+		touch, _, err := r.FirstTouch()
+		if err != nil {
+			return breakdown, err
+		}
+		if touch != "" {
+			breakdown[touch]++
+		}
+	}
+	return breakdown, nil
+}
+
+// location returns the location used to interpret the result's event times.
+// The given location is preferred, followed by the timezone offset reported
+// by the recipient's client, falling back to UTC.
+func (r *Result) location(tz *time.Location) *time.Location {
+	switch {
+	case tz != nil:
+		return tz
+	case r.ClientTZOffset != nil:
+		return time.FixedZone("", *r.ClientTZOffset*60)
+	}
+	return time.UTC
+}
+
+// duringBusinessHours returns whether or not the given time falls between
+// start and end, measured from midnight, on a weekday in the given location.
+func duringBusinessHours(t time.Time, start, end time.Duration, loc *time.Location) bool {
+	t = t.In(loc)
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	h, m, sec := t.Clock()
+	tod := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+	return tod >= start && tod < end
+}
+
+// EngagedDuringBusinessHours returns whether or not the result's first
+// engagement happened during business hours, which run from start to end
+// (measured from midnight) on weekdays in the given location. If no location
+// is given, the timezone reported by the recipient's client is used, falling
+// back to UTC. Results which never engaged return false.
+func (r *Result) EngagedDuringBusinessHours(start, end time.Duration, tz *time.Location) (bool, error) {
+	touch, t, err := r.FirstTouch()
+	if err != nil || touch == "" {
+		return false, err
+	}
+	return duringBusinessHours(t, start, end, r.location(tz)), nil
+}
+
+// BusinessHoursSplit contains the number of results in a campaign whose first
+// engagement was during business hours, and the number whose first engagement
+// was outside of them.
+type BusinessHoursSplit struct {
+	DuringHours  int64 `json:"during_hours"`
+	OutsideHours int64 `json:"outside_hours"`
+}
+
+// GetBusinessHoursSplit returns the split of engaged results in the campaign
+// specified by the given id and user_id between those who first engaged during
+// business hours and those who first engaged outside of them. Results which
+// never engaged are not counted.
+func GetBusinessHoursSplit(cid int64, uid int64, start, end time.Duration, tz *time.Location) (BusinessHoursSplit, error) {
+	split := BusinessHoursSplit{}
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return split, err
+	}
+	for _, r := range rs {
+		touch, t, err := r.FirstTouch()
+		if err != nil {
+			return split, err
+		}
+		if touch == "" {
+			continue
+		}
+		if duringBusinessHours(t, start, end, r.location(tz)) {
+			split.DuringHours++
+		} else {
+			split.OutsideHours++
+		}
+	}
+	return split, nil
+}
+
+// ErrEventsOutOfOrder is thrown when a result has an engagement event
+// timestamped before the email was sent to it.
+var ErrEventsOutOfOrder = errors.New("Engagement event recorded before the email was sent")
+
+// ValidateEventOrdering checks that none of the result's engagement events are
+// timestamped before the email was first sent, which can happen with server
+// clock skew or replayed requests. ErrEventsOutOfOrder is returned if any are.
+func (r *Result) ValidateEventOrdering() error {
+	es, err := r.GetEvents()
+	if err != nil {
+		return err
+	}
+	var sent time.Time
+	for _, e := range es {
+		if e.Message == EVENT_SENT {
+			sent = e.Time
+			break
+		}
+	}
+	if sent.IsZero() {
+		return nil
+	}
+	for _, e := range es {
+		switch e.Message {
+		case EVENT_OPENED, EVENT_ATTACHMENT, EVENT_CLICKED, EVENT_DATA_SUBMIT, EVENT_REPORTED:
+			if e.Time.Before(sent) {
+				return ErrEventsOutOfOrder
+			}
+		}
+	}
+	return nil
+}
+
+// GetClockSkewedResults returns the results in the campaign specified by the
+// given id and user_id which have engagement events timestamped before the
+// email was sent.
+func GetClockSkewedResults(cid int64, uid int64) ([]Result, error) {
+	skewed := []Result{}
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return skewed, err
+	}
+	for _, r := range rs {
+		err := r.ValidateEventOrdering()
+		if err == ErrEventsOutOfOrder {
+			skewed = append(skewed, r)
+		} else if err != nil {
+			return skewed, err
+		}
+	}
+	return skewed, nil
+}
+
+// ReportedWithinGraceAfterClick returns whether or not the recipient reported
+// the email within the given amount of time after first clicking the link,
+// recognizing their mistake. Reports made before the click don't count.
+func (r *Result) ReportedWithinGraceAfterClick(grace time.Duration) (bool, error) {
+	es, err := r.GetEvents()
+	if err != nil {
+		return false, err
+	}
+	var clicked time.Time
+	for _, e := range es {
+		switch {
+		case e.Message == EVENT_CLICKED && clicked.IsZero():
+			clicked = e.Time
+		case e.Message == EVENT_REPORTED && !clicked.IsZero():
+			return e.Time.Sub(clicked) <= grace, nil
+		}
+	}
+	return false, nil
+}
+
+// The folders a seed account can report a campaign's email was delivered to
+const (
+	PLACEMENT_INBOX   string = "inbox"
+	PLACEMENT_SPAM    string = "spam"
+	PLACEMENT_MISSING string = "missing"
+)
+
+// ErrInvalidPlacement is thrown when an unknown inbox placement is recorded
+var ErrInvalidPlacement = errors.New("Invalid inbox placement")
+
+// EventPlacement is a struct that wraps the inbox placement reported for a
+// seed account
+type EventPlacement struct {
+	Placement string `json:"placement"`
+}
+
+// HandleInboxPlacement records where the email was delivered for a result
+// belonging to a seed account, which is used to monitor deliverability. The
+// placement must be one of PLACEMENT_INBOX, PLACEMENT_SPAM or
+// PLACEMENT_MISSING. The result's status is left unchanged.
+func (r *Result) HandleInboxPlacement(placement string) error {
+	switch placement {
+	case PLACEMENT_INBOX, PLACEMENT_SPAM, PLACEMENT_MISSING:
+	default:
+		return ErrInvalidPlacement
+	}
+	_, err := r.createEvent(EVENT_PLACEMENT, EventPlacement{Placement: placement})
+	if err != nil {
+		return err
+	}
+	r.InboxPlacement = placement
+	return ResultStorage.Save(r)
+}
+
+// GetCampaignInboxPlacement returns the number of seed account results in the
+// campaign specified by the given id and user_id which were delivered to each
+// placement. Results without a recorded placement aren't seed accounts, and
+// aren't counted.
+func GetCampaignInboxPlacement(cid int64, uid int64) (map[string]int64, error) {
+	placements := map[string]int64{
+		PLACEMENT_INBOX:   0,
+		PLACEMENT_SPAM:    0,
+		PLACEMENT_MISSING: 0,
+	}
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return placements, err
+	}
+	for _, r := range rs {
+		if r.InboxPlacement != "" {
+			placements[r.InboxPlacement]++
+		}
+	}
+	return placements, nil
+}
+
+// ForwardedAddressThreshold is the number of distinct addresses which must
+// engage with a single result before the email is considered to have been
+// forwarded.
+var ForwardedAddressThreshold = 3
+
+// submittedOtherIdentity returns whether or not the submitted payload contains
+// an email address other than the recipient's, such as when a colleague the
+// email was forwarded to entered their own credentials.
+func (r *Result) submittedOtherIdentity(payload url.Values) bool {
+	email := normalizeEmail(r.Email)
+	for _, vs := range payload {
+		for _, v := range vs {
+			v = strings.TrimSpace(v)
+			a, err := mail.ParseAddress(v)
+			if err != nil || a.Address != v {
+				continue
+			}
+			if normalizeEmail(v) != email {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LikelyForwardedInternally returns whether or not the recipient's engagement
+// implies the email was forwarded to colleagues. This is the case when someone
+// submitted a different email address than the recipient's, or when the link
+// was used from at least ForwardedAddressThreshold distinct addresses. If
+// CorporateNetworks or CorporateASNs are configured only internal addresses
+// are counted, otherwise any address which isn't a known proxy is.
+func (r *Result) LikelyForwardedInternally() (bool, error) {
+	es, err := r.GetEvents()
+	if err != nil {
+		return false, err
+	}
+	addrs := make(map[string]bool)
+	for _, e := range es {
+		switch e.Message {
+		case EVENT_OPENED, EVENT_CLICKED, EVENT_DATA_SUBMIT:
+		default:
+			continue
+		}
+		d, err := e.parseDetails()
+		if err != nil {
+			return false, err
+		}
+		if e.Message == EVENT_DATA_SUBMIT && r.submittedOtherIdentity(d.Payload) {
+			return true, nil
+		}
+		addr := d.Browser["address"]
+		switch {
+		case addr == "":
+			continue
+		case len(CorporateNetworks)+len(CorporateASNs) > 0 && !isCorporateAddress(addr):
+			continue
+		case isKnownProxy(addr):
+			continue
+		}
+		addrs[addr] = true
+	}
+	return len(addrs) >= ForwardedAddressThreshold, nil
+}
+
+// GetLikelyForwardedResults returns the results in the campaign specified by
+// the given id and user_id whose email was likely forwarded internally.
+func GetLikelyForwardedResults(cid int64, uid int64) ([]Result, error) {
+	found := []Result{}
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return found, err
+	}
+	for _, r := range rs {
+		ok, err := r.LikelyForwardedInternally()
+		if err != nil {
+			return found, err
+		}
+		if ok {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+// fingerprintSubnet returns the network the given address belongs to, so that
+// a client whose address changes within its network keeps the same
+// fingerprint. IPv4 addresses are grouped by /24 and IPv6 addresses by /48.
+func fingerprintSubnet(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// engagementFingerprint returns a fingerprint of the client which triggered
+// the event, computed from stable request signals: the address's subnet, the
+// User-Agent and the Accept-Language header. An empty fingerprint is returned
+// if the event wasn't triggered by a request.
+func engagementFingerprint(d EventDetails) string {
+	subnet := fingerprintSubnet(d.Browser["address"])
+	ua := d.Browser["user-agent"]
+	if subnet == "" && ua == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(strings.Join([]string{subnet, ua, d.Browser["accept-language"]}, "\n")))
+	return hex.EncodeToString(h[:8])
+}
+
+// EngagementFingerprints returns the distinct fingerprints of the clients
+// which engaged with the result, in the order they were first seen.
+func (r *Result) EngagementFingerprints() ([]string, error) {
+	fps := []string{}
+	es, err := r.GetEvents()
+	if err != nil {
+		return fps, err
+	}
+	seen := make(map[string]bool)
+	for _, e := range es {
+		fp, err := e.fingerprint()
+		if err != nil {
+			return fps, err
+		}
+		if fp != "" && !seen[fp] {
+			seen[fp] = true
+			fps = append(fps, fp)
+		}
+	}
+	return fps, nil
+}
+
+// fingerprint returns the client fingerprint stored with an engagement event,
+// computing it from the event details for events recorded before
+// fingerprints were stored.
+func (e *Event) fingerprint() (string, error) {
+	switch e.Message {
+	case EVENT_OPENED, EVENT_ATTACHMENT, EVENT_CLICKED, EVENT_DATA_SUBMIT, EVENT_REPORTED:
+	default:
+		return "", nil
+	}
+	d, err := e.parseDetails()
+	if err != nil {
+		return "", err
+	}
+	if d.Fingerprint != "" {
+		return d.Fingerprint, nil
+	}
+	return engagementFingerprint(d), nil
+}
+
+// GetSharedFingerprints returns the client fingerprints in the campaign
+// specified by the given id and user_id which engaged with at least min
+// distinct results, mapped to the result IDs they engaged with. This can
+// reveal a single person, such as a researcher, clicking many tracking links.
+func GetSharedFingerprints(cid int64, uid int64, min int) (map[string][]string, error) {
+	shared := make(map[string][]string)
+	c, err := GetCampaign(cid, uid)
+	if err != nil {
+		return shared, err
+	}
+	rids := make(map[string]string)
+	for _, r := range c.Results {
+		rids[r.Email] = r.RId
+	}
+	seen := make(map[string]map[string]bool)
+	for _, e := range c.Events {
+		fp, err := e.fingerprint()
+		if err != nil {
+			return shared, err
+		}
+		rid, ok := rids[e.Email]
+		if fp == "" || !ok {
+			continue
+		}
+		if _, ok := seen[fp]; !ok {
+			seen[fp] = make(map[string]bool)
+		}
+		seen[fp][rid] = true
+	}
+	for fp, rs := range seen {
+		if len(rs) < min {
+			continue
+		}
+		for rid := range rs {
+			shared[fp] = append(shared[fp], rid)
+		}
+		sort.Strings(shared[fp])
+	}
+	return shared, nil
+}
+
+// setAttributes stores a copy of the given target attributes with the result,
+// so that they're kept as they were when the campaign was launched.
+func (r *Result) setAttributes(attrs map[string]string) error {
+	r.Variables = make(map[string]string)
+	if len(attrs) == 0 {
+		r.AttributesJSON = ""
+		return nil
+	}
+	aj, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	r.AttributesJSON = string(aj)
+	for k, v := range attrs {
+		r.Variables[k] = v
+	}
+	return nil
+}
+
+// Attributes returns the custom attributes the target had when the campaign
+// was launched, such as their department or manager.
+func (r *Result) Attributes() (map[string]string, error) {
+	attrs := make(map[string]string)
+	if r.AttributesJSON == "" {
+		return attrs, nil
+	}
+	err := json.Unmarshal([]byte(r.AttributesJSON), &attrs)
+	return attrs, err
+}
+
+// customFieldName converts an attribute name into one which can be used as a
+// template field, by removing any characters other than letters and digits
+// and capitalizing each word, so "employee id" becomes "EmployeeId".
+func customFieldName(name string) string {
+	words := strings.FieldsFunc(name, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	for i, w := range words {
+		rs := []rune(w)
+		rs[0] = unicode.ToUpper(rs[0])
+		words[i] = string(rs)
+	}
+	return strings.Join(words, "")
+}
+
+// customFields returns the attributes keyed by both their names and their
+// names as template fields. Attributes named exactly as another attribute's
+// field name take precedence.
+func customFields(attrs map[string]string) map[string]string {
+	custom := make(map[string]string)
+	for k, v := range attrs {
+		if f := customFieldName(k); f != "" {
+			custom[f] = v
+		}
+	}
+	for k, v := range attrs {
+		custom[k] = v
+	}
+	return custom
+}
+
+// Custom returns the result's custom attributes for use in templates, so
+// that the attribute "department" can be used as {{.Custom.Department}} and
+// "employee id" as {{.Custom.EmployeeId}}.
+func (r Result) Custom() map[string]string {
+	return customFields(r.Variables)
+}
+
+// AfterFind loads the result's template variables from its stored attributes
+// whenever the result is read from the database. The result's timestamps are
+// normalized to UTC, since some database drivers return them in local time.
+func (r *Result) AfterFind() error {
+	r.SendDate = r.SendDate.UTC()
+	r.ModifiedDate = r.ModifiedDate.UTC()
+	if r.LinkExpiresAt != nil {
+		expires := r.LinkExpiresAt.UTC()
+		r.LinkExpiresAt = &expires
+	}
+	vars, err := r.Attributes()
+	if err != nil {
+		log.Error(err)
+	}
+	r.Variables = vars
+	return nil
 }
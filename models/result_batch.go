@@ -0,0 +1,312 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// UpdateResults saves the given results to the database in a single
+// transaction. Either every result is saved, or none of them are. Results
+// which are no longer in the database, such as those of a deleted campaign,
+// aren't created again.
+func UpdateResults(rs []*Result) error {
+	if len(rs) == 0 {
+		return nil
+	}
+	tx := db.Begin()
+	for _, r := range rs {
+		err := updateResult(tx, r)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// updateResult writes every column of the existing result using the given
+// connection. Unlike Save, nothing is inserted if the result's row has been
+// deleted, and removed results aren't brought back.
+func updateResult(tx *gorm.DB, r *Result) error {
+	columns := make(map[string]interface{})
+	for _, f := range insertFields(tx.NewScope(r)) {
+		if f.DBName == "deleted_at" {
+			continue
+		}
+		columns[f.DBName] = f.Field.Interface()
+	}
+	return tx.Model(&Result{}).Where("id = ?", r.Id).UpdateColumns(columns).Error
+}
+
+// ResultInsertBatchSize is the most rows inserted by a single statement when
+// a campaign's results are created
+var ResultInsertBatchSize = 500
+
+// maxInsertVariables is the most values bound by a single insert statement,
+// which is SQLite's default limit
+const maxInsertVariables = 999
+
+// insertResults inserts the results of a newly created campaign and their
+// maillogs in a single transaction, using multi-row inserts. Either every
+// result is inserted, or none of them are. The results are assigned their ids
+// once they're inserted.
+func insertResults(c *Campaign, rs []*Result) error {
+	if len(rs) == 0 {
+		return nil
+	}
+	results := make([]interface{}, len(rs))
+	maillogs := make([]interface{}, len(rs))
+	for i, r := range rs {
+		results[i] = r
+		maillogs[i] = newMailLog(c, r)
+	}
+	tx := db.Begin()
+	for _, rows := range [][]interface{}{results, maillogs} {
+		err := batchInsert(tx, rows)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	// Multi-row inserts don't return the ids of the rows, so they're looked
+	// up afterwards
+	inserted := []Result{}
+	err := tx.Table("results").Select("id, r_id").Where("campaign_id=?", c.Id).Find(&inserted).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	ids := make(map[string]int64)
+	for _, r := range inserted {
+		ids[r.RId] = r.Id
+	}
+	for _, r := range rs {
+		r.Id = ids[r.RId]
+	}
+	return tx.Commit().Error
+}
+
+// batchInsert inserts the given rows, which are pointers to the same model,
+// using as few statements as possible. The rows' primary keys aren't set, and
+// gorm's callbacks aren't run.
+func batchInsert(tx *gorm.DB, rows []interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	scope := tx.NewScope(rows[0])
+	columns := []string{}
+	for _, f := range insertFields(scope) {
+		columns = append(columns, scope.Quote(f.DBName))
+	}
+	size := ResultInsertBatchSize
+	if size*len(columns) > maxInsertVariables {
+		size = maxInsertVariables / len(columns)
+	}
+	if size < 1 {
+		size = 1
+	}
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+	for start := 0; start < len(rows); start += size {
+		end := start + size
+		if end > len(rows) {
+			end = len(rows)
+		}
+		placeholders := []string{}
+		values := []interface{}{}
+		for _, row := range rows[start:end] {
+			for _, f := range insertFields(tx.NewScope(row)) {
+				values = append(values, f.Field.Interface())
+			}
+			placeholders = append(placeholders, placeholder)
+		}
+		sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", scope.QuotedTableName(),
+			strings.Join(columns, ","), strings.Join(placeholders, ","))
+		err := tx.Exec(sql, values...).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertFields returns the fields of the model which are stored in its table,
+// other than the primary key
+func insertFields(scope *gorm.Scope) []*gorm.Field {
+	fields := []*gorm.Field{}
+	for _, f := range scope.Fields() {
+		if f.IsNormal && !f.IsIgnored && !f.IsPrimaryKey {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// BatchResultStore is a ResultStore which coalesces saves of existing results
+// over a short window and writes them to the database together, reducing the
+// number of round-trips when many events arrive at once. Each result's status
+// transitions are unchanged, since only the final state of a result within
+// the window is written. Results are read through the store with their held
+// saves applied, and held saves are flushed before campaign statistics are
+// computed and before queries and updates which use the results table
+// directly. It's installed as ResultStorage when a batch window is configured.
+type BatchResultStore struct {
+	// Window is how long saves are held before being flushed.
+	Window time.Duration
+	// MaxPending is the number of pending results which triggers an immediate
+	// flush. A zero value only flushes when the window has passed.
+	MaxPending int
+
+	// flushMu is held while a batch is written, so that a flush doesn't
+	// return until the saves held before it have been committed.
+	flushMu sync.Mutex
+	mu      sync.Mutex
+	// pending and flushing hold the saves waiting for the next batch and
+	// those being written by the current one, keyed by result ID.
+	pending  map[string]Result
+	flushing map[string]Result
+	timer    *time.Timer
+	store    dbResultStore
+}
+
+// NewBatchResultStore returns a BatchResultStore which flushes pending saves
+// after the given window, or once maxPending results are waiting.
+func NewBatchResultStore(window time.Duration, maxPending int) *BatchResultStore {
+	return &BatchResultStore{
+		Window:     window,
+		MaxPending: maxPending,
+		pending:    make(map[string]Result),
+		flushing:   make(map[string]Result),
+	}
+}
+
+// Save queues the given result to be written with the next batch. Results
+// which haven't been inserted yet are saved immediately, so that they're
+// assigned an id.
+func (s *BatchResultStore) Save(r *Result) error {
+	if r.Id == 0 {
+		return s.store.Save(r)
+	}
+	s.mu.Lock()
+	s.pending[r.RId] = *r
+	full := s.MaxPending > 0 && len(s.pending) >= s.MaxPending
+	if !full {
+		s.armTimer()
+	}
+	s.mu.Unlock()
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// armTimer starts the timer which flushes the pending saves once the window
+// has passed, unless it's already running. The caller must hold mu.
+func (s *BatchResultStore) armTimer() {
+	if s.timer != nil || len(s.pending) == 0 {
+		return
+	}
+	s.timer = time.AfterFunc(s.Window, func() {
+		err := s.Flush()
+		if err != nil {
+			log.Error(err)
+		}
+	})
+}
+
+// Flush writes every pending result to the database in a single transaction.
+// If the transaction fails, the results are written one at a time instead,
+// and any which still fail are logged and dropped, so that a single bad
+// result can't hold back the rest. The first of their errors is returned.
+func (s *BatchResultStore) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.flushing, s.pending = s.pending, make(map[string]Result)
+	batch := s.flushing
+	s.mu.Unlock()
+
+	rs := make([]*Result, 0, len(batch))
+	for rid := range batch {
+		r := batch[rid]
+		rs = append(rs, &r)
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Id < rs[j].Id })
+	var err error
+	if UpdateResults(rs) != nil {
+		for _, r := range rs {
+			rerr := updateResult(db, r)
+			if rerr == nil {
+				continue
+			}
+			log.WithFields(logrus.Fields{
+				"rid": r.RId,
+			}).Errorf("dropping result update: %s", rerr)
+			if err == nil {
+				err = rerr
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.flushing = make(map[string]Result)
+	s.armTimer()
+	s.mu.Unlock()
+	return err
+}
+
+// held returns the latest save held for the result with the given result ID,
+// if there is one
+func (s *BatchResultStore) held(rid string) (Result, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.pending[rid]; ok {
+		return r, true
+	}
+	r, ok := s.flushing[rid]
+	return r, ok
+}
+
+// Get returns the result with the given result ID, including any save held
+// for it, without flushing the pending saves
+func (s *BatchResultStore) Get(rid string) (Result, error) {
+	if r, ok := s.held(rid); ok {
+		return r, nil
+	}
+	return s.store.Get(rid)
+}
+
+// List returns the results for the given campaign from the database, with
+// any saves held for them applied
+func (s *BatchResultStore) List(cid int64, uid int64) ([]Result, error) {
+	rs, err := s.store.List(cid, uid)
+	if err != nil {
+		return rs, err
+	}
+	for i := range rs {
+		if r, ok := s.held(rs[i].RId); ok {
+			rs[i] = r
+		}
+	}
+	return rs, nil
+}
+
+// Summary flushes any pending saves and returns the campaign statistics
+// computed by the database
+func (s *BatchResultStore) Summary(cid int64) (CampaignStats, error) {
+	err := s.Flush()
+	if err != nil {
+		return CampaignStats{}, err
+	}
+	return s.store.Summary(cid)
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	"gopkg.in/check.v1"
+)
+
+// dbStatus returns the status of the result stored in the database, bypassing
+// ResultStorage.
+func dbStatus(ch *check.C, rid string) string {
+	r := Result{}
+	ch.Assert(db.Where("r_id=?", rid).First(&r).Error, check.Equals, nil)
+	return r.Status
+}
+
+func (s *ModelsSuite) TestUpdateResults(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	rs := []*Result{}
+	for i := range campaign.Results {
+		campaign.Results[i].Status = EVENT_OPENED
+		rs = append(rs, &campaign.Results[i])
+	}
+	ch.Assert(UpdateResults(rs), check.Equals, nil)
+	for _, r := range rs {
+		ch.Assert(dbStatus(ch, r.RId), check.Equals, EVENT_OPENED)
+	}
+	ch.Assert(UpdateResults(nil), check.Equals, nil)
+}
+
+func (s *ModelsSuite) TestBatchResultStore(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	first, second := campaign.Results[0], campaign.Results[1]
+
+	store := NewBatchResultStore(time.Hour, 0)
+	defer func(rs ResultStore) { ResultStorage = rs }(ResultStorage)
+	ResultStorage = store
+
+	// Saves are held until the batch is flushed
+	ch.Assert(first.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(first.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(second.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(dbStatus(ch, first.RId), check.Equals, STATUS_SENDING)
+
+	// Reading through the store returns the latest held state of each result
+	// without flushing it
+	got, err := GetResult(first.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, EVENT_CLICKED)
+	rs, err := ResultStorage.List(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	for _, r := range rs {
+		if r.RId == second.RId {
+			ch.Assert(r.Status, check.Equals, EVENT_OPENED)
+		}
+	}
+	ch.Assert(dbStatus(ch, first.RId), check.Equals, STATUS_SENDING)
+
+	// A later, lower priority event read from the held state still doesn't
+	// regress the status
+	got, err = GetResult(first.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(store.Flush(), check.Equals, nil)
+	ch.Assert(dbStatus(ch, first.RId), check.Equals, EVENT_CLICKED)
+	ch.Assert(dbStatus(ch, second.RId), check.Equals, EVENT_OPENED)
+}
+
+func (s *ModelsSuite) TestBatchResultStoreDeletedCampaign(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	defer func(rs ResultStore) { ResultStorage = rs }(ResultStorage)
+	ResultStorage = NewBatchResultStore(time.Hour, 0)
+
+	// Saves held when the campaign is deleted, or made afterwards, don't
+	// recreate its result
+	ch.Assert(r.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(DeleteCampaign(campaign.Id), check.Equals, nil)
+	r.Status = EVENT_CLICKED
+	ch.Assert(ResultStorage.Save(&r), check.Equals, nil)
+	ch.Assert(FlushResults(), check.Equals, nil)
+	var count int
+	ch.Assert(db.Unscoped().Model(&Result{}).Where("r_id=?", r.RId).Count(&count).Error, check.Equals, nil)
+	ch.Assert(count, check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestBatchResultStoreFlushTriggers(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	first, second := campaign.Results[0], campaign.Results[1]
+	defer func(rs ResultStore) { ResultStorage = rs }(ResultStorage)
+
+	// Reaching MaxPending flushes immediately
+	ResultStorage = NewBatchResultStore(time.Hour, 2)
+	ch.Assert(first.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(dbStatus(ch, first.RId), check.Equals, STATUS_SENDING)
+	ch.Assert(second.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(dbStatus(ch, first.RId), check.Equals, EVENT_OPENED)
+	ch.Assert(dbStatus(ch, second.RId), check.Equals, EVENT_OPENED)
+
+	// Pending saves are flushed once the window passes
+	ResultStorage = NewBatchResultStore(10*time.Millisecond, 0)
+	ch.Assert(first.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	deadline := time.Now().Add(time.Second)
+	for dbStatus(ch, first.RId) != EVENT_CLICKED && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	ch.Assert(dbStatus(ch, first.RId), check.Equals, EVENT_CLICKED)
+}
+
+func (s *ModelsSuite) TestConfigureResultStore(ch *check.C) {
+	defer func(rs ResultStore) { ResultStorage = rs }(ResultStorage)
+
+	configureResultStore(config.ResultStore{BatchWindowMilliseconds: 250, MaxPending: 50})
+	store, ok := ResultStorage.(*BatchResultStore)
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(store.Window, check.Equals, 250*time.Millisecond)
+	ch.Assert(store.MaxPending, check.Equals, 50)
+
+	// Without a window, results are written as they're saved
+	configureResultStore(config.ResultStore{})
+	_, ok = ResultStorage.(*dbResultStore)
+	ch.Assert(ok, check.Equals, true)
+}
+
+func (s *ModelsSuite) TestBatchResultStoreDirectWrites(ch *check.C) {
+	defer func(lookup func(context.Context, string) ([]string, error)) {
+		lookupAddr = lookup
+		configureReverseDNS(config.ReverseDNS{})
+	}(lookupAddr)
+	configureReverseDNS(config.ReverseDNS{})
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		return []string{"host-5.isp.example.net."}, nil
+	}
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	defer func(rs ResultStore) { ResultStorage = rs }(ResultStorage)
+	ResultStorage = NewBatchResultStore(time.Hour, 0)
+
+	// A save held before a column is updated directly is written first, so
+	// that it can't overwrite the column once it's flushed
+	ch.Assert(r.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(r.UpdateReverseDNS("203.0.113.5"), check.Equals, nil)
+	ch.Assert(FlushResults(), check.Equals, nil)
+	got := Result{}
+	ch.Assert(db.Where("r_id=?", r.RId).First(&got).Error, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, EVENT_OPENED)
+	ch.Assert(got.ReverseDNS, check.Equals, "host-5.isp.example.net")
+
+	// Queries which use the results table directly see held saves
+	ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	rs, err := QueryResults(campaign.Id, campaign.UserId, ResultFilter{
+		IncludeStatuses: []string{EVENT_CLICKED},
+	})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(rs), check.Equals, 1)
+	ch.Assert(rs[0].RId, check.Equals, r.RId)
+}
+
+func (s *ModelsSuite) TestPostCampaignInsertsResultsInBatches(ch *check.C) {
+	defer func(lookup, insert int, existing func([]string) (map[string]bool, error)) {
+		IdLookupBatchSize, ResultInsertBatchSize, existingIds = lookup, insert, existing
+	}(IdLookupBatchSize, ResultInsertBatchSize, existingIds)
+	IdLookupBatchSize = 8
+	ResultInsertBatchSize = 4
+	lookups := 0
+	lookup := existingIds
+	existingIds = func(rids []string) (map[string]bool, error) {
+		lookups++
+		return lookup(rids)
+	}
+
+	campaign := s.createCampaignWithTargets(ch, generateTargets(30))
+	ch.Assert(len(campaign.Results), check.Equals, 30)
+	ch.Assert(lookups, check.Equals, 4)
+
+	// Every result is inserted, assigned its id and given a maillog
+	for _, r := range campaign.Results {
+		ch.Assert(r.Id, check.Not(check.Equals), int64(0))
+		got, err := GetResult(r.RId)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(got.Id, check.Equals, r.Id)
+		ch.Assert(got.Email, check.Equals, r.Email)
+		ch.Assert(got.Status, check.Equals, STATUS_SENDING)
+		m := MailLog{}
+		ch.Assert(db.Where("r_id=?", r.RId).Find(&m).Error, check.Equals, nil)
+		ch.Assert(m.CampaignId, check.Equals, campaign.Id)
+	}
+	var count int
+	ch.Assert(db.Model(&Result{}).Where("campaign_id=?", campaign.Id).Count(&count).Error, check.Equals, nil)
+	ch.Assert(count, check.Equals, 30)
+}
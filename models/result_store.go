@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gophish/gophish/config"
+)
+
+// ResultStore is the interface used to persist and retrieve campaign results.
+// The default implementation stores results in the Gophish database, but an
+// alternate backend, such as a dedicated analytics store, can be used by
+// setting ResultStorage.
+type ResultStore interface {
+	// Save inserts or updates the given result
+	Save(r *Result) error
+	// Get returns the result with the given result ID
+	Get(rid string) (Result, error)
+	// List returns the results for the given campaign, owned by the given user.
+	List(cid int64, uid int64) ([]Result, error)
+	// Summary returns the aggregated statistics for the given campaign
+	Summary(cid int64) (CampaignStats, error)
+}
+
+// ResultStorage is the ResultStore used to persist campaign results.
+var ResultStorage ResultStore = &dbResultStore{}
+
+// configureResultStore sets ResultStorage to coalesce result updates over the
+// configured window, or to write them as they happen if there isn't one.
+func configureResultStore(conf config.ResultStore) {
+	if conf.BatchWindowMilliseconds <= 0 {
+		ResultStorage = &dbResultStore{}
+		return
+	}
+	window := time.Duration(conf.BatchWindowMilliseconds) * time.Millisecond
+	ResultStorage = NewBatchResultStore(window, conf.MaxPending)
+}
+
+// resultFlusher is implemented by ResultStores which hold saves before
+// writing them to the database.
+type resultFlusher interface {
+	Flush() error
+}
+
+// FlushResults writes any saves held by ResultStorage to the database. It's
+// called before queries and updates which use the results table directly, so
+// that they see the held saves, and so that an older held save can't later
+// overwrite what they write, and when Gophish shuts down.
+func FlushResults() error {
+	if f, ok := ResultStorage.(resultFlusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// dbResultStore is a ResultStore that persists results using gorm.
+type dbResultStore struct{}
+
+// Save inserts or updates the given result in the database
+func (s *dbResultStore) Save(r *Result) error {
+	return db.Save(r).Error
+}
+
+// Get returns the result with the given result ID from the database
+func (s *dbResultStore) Get(rid string) (Result, error) {
+	r := Result{}
+	err := db.Where("r_id=?", rid).First(&r).Error
+	return r, err
+}
+
+// List returns the results for the given campaign from the database
+func (s *dbResultStore) List(cid int64, uid int64) ([]Result, error) {
+	rs := []Result{}
+	err := db.Table("results").Where("campaign_id=? and user_id in (?)", cid, teamUserIds(uid)).Find(&rs).Error
+	return rs, err
+}
+
+// Summary returns the campaign statistics computed by the database
+func (s *dbResultStore) Summary(cid int64) (CampaignStats, error) {
+	return getCampaignStats(cid)
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"gopkg.in/check.v1"
+)
+
+// memResultStore is an in-memory ResultStore used to verify that results are
+// persisted through the ResultStorage interface.
+type memResultStore struct {
+	results map[string]Result
+	saves   int
+}
+
+func newMemResultStore() *memResultStore {
+	return &memResultStore{results: make(map[string]Result)}
+}
+
+func (s *memResultStore) Save(r *Result) error {
+	s.saves++
+	s.results[r.RId] = *r
+	return nil
+}
+
+func (s *memResultStore) Get(rid string) (Result, error) {
+	r, ok := s.results[rid]
+	if !ok {
+		return r, gorm.ErrRecordNotFound
+	}
+	return r, nil
+}
+
+func (s *memResultStore) List(cid int64, uid int64) ([]Result, error) {
+	rs := []Result{}
+	for _, r := range s.results {
+		if r.CampaignId == cid && r.UserId == uid {
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil
+}
+
+func (s *memResultStore) Summary(cid int64) (CampaignStats, error) {
+	cs := CampaignStats{}
+	for _, r := range s.results {
+		if r.CampaignId == cid {
+			cs.Total++
+		}
+	}
+	return cs, nil
+}
+
+func (s *ModelsSuite) TestResultStorage(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+
+	store := newMemResultStore()
+	defer func(rs ResultStore) { ResultStorage = rs }(ResultStorage)
+	ResultStorage = store
+
+	ch.Assert(result.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(result.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(store.saves, check.Equals, 2)
+
+	got, err := GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, EVENT_CLICKED)
+	rs, err := ResultStorage.List(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(rs), check.Equals, 1)
+	summary, err := GetCampaignSummary(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(summary.Stats.Total, check.Equals, int64(1))
+
+	// The database shouldn't have been touched
+	ResultStorage = &dbResultStore{}
+	got, err = GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, STATUS_SENDING)
+}
@@ -1,10 +1,23 @@
 package models
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/mail"
+	"net/url"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/gophish/gomail"
+	"github.com/gophish/gophish/config"
+	"github.com/jinzhu/gorm"
 	"gopkg.in/check.v1"
 )
 
@@ -34,6 +47,27 @@ func (s *ModelsSuite) TestFormatAddress(c *check.C) {
 	c.Assert(r.FormatAddress(), check.Equals, r.Email)
 }
 
+func (s *ModelsSuite) TestFormatAddressNonASCII(c *check.C) {
+	names := [][2]string{
+		{"José", "Álvarez"},
+		{"山田", "太郎"},
+		{"Zoë", "O'Brien-Müller"},
+	}
+	for _, n := range names {
+		r := Result{FirstName: n[0], LastName: n[1], Email: "target@example.com"}
+		addr := r.FormatAddress()
+		c.Assert(strings.HasPrefix(addr, "=?utf-8?"), check.Equals, true)
+		parsed, err := mail.ParseAddress(addr)
+		c.Assert(err, check.Equals, nil)
+		c.Assert(parsed.Name, check.Equals, n[0]+" "+n[1])
+		c.Assert(parsed.Address, check.Equals, r.Email)
+	}
+
+	// ASCII names keep the plain quoted form
+	r := Result{FirstName: "John", LastName: "Doe", Email: "johndoe@example.com"}
+	c.Assert(r.FormatAddress(), check.Equals, `"John Doe" <johndoe@example.com>`)
+}
+
 func (s *ModelsSuite) TestResultSendingStatus(ch *check.C) {
 	c := s.createCampaignDependencies(ch)
 	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
@@ -99,3 +133,1913 @@ func (s *ModelsSuite) TestDuplicateResults(ch *check.C) {
 	ch.Assert(c.Results[0].Email, check.Equals, group.Targets[0].Email)
 	ch.Assert(c.Results[1].Email, check.Equals, group.Targets[2].Email)
 }
+
+func (s *ModelsSuite) TestResultAcceptLanguage(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	details := EventDetails{Browser: map[string]string{
+		"accept-language": "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5",
+	}}
+	ch.Assert(result.HandleEmailOpened(details), check.Equals, nil)
+
+	got, err := GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.AcceptLanguage, check.Equals, "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5")
+	locale, ok := got.InferredLocale()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(locale, check.Equals, "fr-CH")
+
+	// A click without the header shouldn't clear the recorded value
+	ch.Assert(got.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	got, err = GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.AcceptLanguage, check.Equals, "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5")
+}
+
+func (s *ModelsSuite) TestResultInferredLocale(ch *check.C) {
+	tests := map[string]string{
+		"en-US,en;q=0.9":    "en-US",
+		"de;q=0.7, es-MX":   "es-MX",
+		"da, en-GB;q=0.8":   "da",
+		" ja ; q=1 , en ":   "ja",
+		"en;q=0.5,de;q=bad": "en",
+	}
+	for header, expected := range tests {
+		r := Result{AcceptLanguage: header}
+		locale, ok := r.InferredLocale()
+		ch.Assert(ok, check.Equals, true)
+		ch.Assert(locale, check.Equals, expected)
+	}
+	for _, header := range []string{"", "*", "en;q=0", " , "} {
+		r := Result{AcceptLanguage: header}
+		_, ok := r.InferredLocale()
+		ch.Assert(ok, check.Equals, false)
+	}
+}
+
+func (s *ModelsSuite) TestResultGetEvents(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	ch.Assert(result.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(result.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(result.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(result.HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	// Events for other results in the campaign aren't included
+	other := campaign.Results[1]
+	ch.Assert(other.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	// Store the open with an earlier time than the sent event, so ordering
+	// is by time rather than the order events were recorded
+	sent := time.Now().UTC().Add(-time.Hour)
+	err := db.Model(&Event{}).Where("email=? and message=?", result.Email, EVENT_SENT).
+		Update("time", sent).Error
+	ch.Assert(err, check.Equals, nil)
+	err = db.Model(&Event{}).Where("email=? and message=?", result.Email, EVENT_OPENED).
+		Update("time", sent.Add(-time.Minute)).Error
+	ch.Assert(err, check.Equals, nil)
+
+	es, err := result.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	messages := []string{}
+	for i, e := range es {
+		ch.Assert(e.Email, check.Equals, result.Email)
+		ch.Assert(e.CampaignId, check.Equals, campaign.Id)
+		if i > 0 {
+			ch.Assert(e.Time.Before(es[i-1].Time), check.Equals, false)
+		}
+		messages = append(messages, e.Message)
+	}
+	ch.Assert(messages, check.DeepEquals, []string{
+		EVENT_OPENED, EVENT_SENT, EVENT_CLICKED, EVENT_DATA_SUBMIT,
+	})
+}
+
+func (s *ModelsSuite) TestResultGeoTrail(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	ch.Assert(result.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(result.HandleEmailOpened(EventDetails{Latitude: 40.7, Longitude: -74.0}), check.Equals, nil)
+	ch.Assert(result.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(result.HandleClickedLink(EventDetails{Latitude: 51.5, Longitude: -0.1}), check.Equals, nil)
+	ch.Assert(result.HandleFormSubmit(EventDetails{Latitude: 48.9, Longitude: 2.3}), check.Equals, nil)
+	// Events for other results shouldn't show up in the trail
+	other := campaign.Results[1]
+	ch.Assert(other.HandleEmailOpened(EventDetails{Latitude: 1, Longitude: 1}), check.Equals, nil)
+
+	trail, err := result.GeoTrail()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(trail), check.Equals, 3)
+	expected := []GeoStep{
+		GeoStep{Message: EVENT_OPENED, Latitude: 40.7, Longitude: -74.0},
+		GeoStep{Message: EVENT_CLICKED, Latitude: 51.5, Longitude: -0.1},
+		GeoStep{Message: EVENT_DATA_SUBMIT, Latitude: 48.9, Longitude: 2.3},
+	}
+	for i, step := range trail {
+		ch.Assert(step.Message, check.Equals, expected[i].Message)
+		ch.Assert(step.Latitude, check.Equals, expected[i].Latitude)
+		ch.Assert(step.Longitude, check.Equals, expected[i].Longitude)
+		if i > 0 {
+			ch.Assert(step.Time.Before(trail[i-1].Time), check.Equals, false)
+		}
+	}
+}
+
+func (s *ModelsSuite) TestReconcileDelivery(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	for _, r := range campaign.Results {
+		m := &MailLog{}
+		err := db.Where("r_id=? AND campaign_id=?", r.RId, campaign.Id).Find(m).Error
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(m.Generate(gomail.NewMessage()), check.Equals, nil)
+	}
+	delivered, err := GetResult(campaign.Results[0].RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(delivered.MessageId, check.Not(check.Equals), "")
+
+	// Message-Ids from MTA logs commonly omit the brackets
+	ids := []string{
+		strings.Trim(delivered.MessageId, "<>"),
+		"<unknown@example.com>",
+	}
+	matched, unmatched, err := ReconcileDelivery(campaign.Id, campaign.UserId, ids)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(matched, check.Equals, 1)
+	ch.Assert(unmatched, check.Equals, 1)
+
+	delivered, err = GetResult(delivered.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(delivered.Delivered, check.Equals, true)
+	deferred, err := GetResult(campaign.Results[1].RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(deferred.Delivered, check.Equals, false)
+
+	// Another user shouldn't be able to reconcile this campaign
+	matched, unmatched, err = ReconcileDelivery(campaign.Id, 2, ids)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(matched, check.Equals, 0)
+	ch.Assert(unmatched, check.Equals, 2)
+}
+
+func (s *ModelsSuite) TestNameEmailMismatch(ch *check.C) {
+	matches := []Result{
+		Result{FirstName: "Jane", LastName: "Smith", Email: "jane.smith@example.com"},
+		Result{FirstName: "Jane", LastName: "Smith", Email: "jsmith@example.com"},
+		Result{FirstName: "Jane", LastName: "Smith", Email: "smithj42@example.com"},
+		Result{FirstName: "Jonathan", LastName: "Doe", Email: "jon.d@example.com"},
+		Result{FirstName: "Jane", LastName: "Smith", Email: "js@example.com"},
+		Result{FirstName: "José", LastName: "García", Email: "jose.garcia@example.com"},
+		Result{FirstName: "", LastName: "", Email: "bob.jones@example.com"},
+		Result{FirstName: "Jane", LastName: "Smith", Email: "1234@example.com"},
+	}
+	for _, r := range matches {
+		ch.Assert(r.NameEmailMismatch(), check.Equals, false, check.Commentf("%s %s <%s>", r.FirstName, r.LastName, r.Email))
+	}
+	mismatches := []Result{
+		Result{FirstName: "Jane", LastName: "Smith", Email: "bob.jones@example.com"},
+		Result{FirstName: "Alice", LastName: "Wong", Email: "mkumar@example.com"},
+		Result{FirstName: "Jane", LastName: "", Email: "xy@example.com"},
+	}
+	for _, r := range mismatches {
+		ch.Assert(r.NameEmailMismatch(), check.Equals, true, check.Commentf("%s %s <%s>", r.FirstName, r.LastName, r.Email))
+	}
+}
+
+func (s *ModelsSuite) TestFindNameEmailMismatches(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, []Target{
+		Target{Email: "jane.smith@example.com", FirstName: "Jane", LastName: "Smith"},
+		Target{Email: "bob.jones@example.com", FirstName: "Alice", LastName: "Wong"},
+	})
+	ms, err := FindNameEmailMismatches(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ms), check.Equals, 1)
+	ch.Assert(ms[0].Email, check.Equals, "bob.jones@example.com")
+}
+
+func (s *ModelsSuite) TestResultKeepAliveBurst(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	conn := EventDetails{Browser: map[string]string{"connection": "10.0.0.1:51234"}}
+
+	// A scanner fetching the pixel repeatedly over one connection
+	scanned := campaign.Results[0]
+	ch.Assert(scanned.HandleEmailOpened(conn), check.Equals, nil)
+	burst, err := scanned.HasKeepAliveBurst()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(burst, check.Equals, false)
+	ch.Assert(scanned.HandleEmailOpened(conn), check.Equals, nil)
+	burst, err = scanned.HasKeepAliveBurst()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(burst, check.Equals, true)
+
+	// A human opening the email again later, and from a new connection
+	human := campaign.Results[1]
+	ch.Assert(human.HandleEmailOpened(conn), check.Equals, nil)
+	err = db.Model(&Event{}).Where("email=?", human.Email).
+		Update("time", time.Now().UTC().Add(-time.Hour)).Error
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(human.HandleEmailOpened(conn), check.Equals, nil)
+	other := EventDetails{Browser: map[string]string{"connection": "10.0.0.1:52345"}}
+	ch.Assert(human.HandleEmailOpened(other), check.Equals, nil)
+	burst, err = human.HasKeepAliveBurst()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(burst, check.Equals, false)
+}
+
+func (s *ModelsSuite) TestQueryResults(ch *check.C) {
+	ts := generateTargets(4)
+	ts[0].Position = "Engineering"
+	ts[1].Position = "Engineering"
+	ts[2].Position = "Sales"
+	campaign := s.createCampaignWithTargets(ch, ts)
+	rs := campaign.Results
+	// 0: clicked and reported, 1: clicked, 2: submitted, 3: no activity
+	ch.Assert(rs[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[0].HandleEmailReport(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleFormSubmit(EventDetails{}), check.Equals, nil)
+
+	emails := func(f ResultFilter) []string {
+		got, err := QueryResults(campaign.Id, campaign.UserId, f)
+		ch.Assert(err, check.Equals, nil)
+		es := []string{}
+		for _, r := range got {
+			es = append(es, r.Email)
+		}
+		return es
+	}
+	notReported := false
+	reported := true
+	ch.Assert(emails(ResultFilter{}), check.HasLen, 4)
+	ch.Assert(emails(ResultFilter{
+		IncludeStatuses: []string{EVENT_CLICKED},
+		Reported:        &notReported,
+	}), check.DeepEquals, []string{rs[1].Email})
+	ch.Assert(emails(ResultFilter{
+		ExcludeStatuses: []string{EVENT_DATA_SUBMIT, STATUS_SENDING},
+		Reported:        &reported,
+	}), check.DeepEquals, []string{rs[0].Email})
+	ch.Assert(emails(ResultFilter{
+		IncludeAttributes: map[string][]string{"position": []string{"Engineering"}},
+		ExcludeStatuses:   []string{EVENT_CLICKED},
+	}), check.DeepEquals, []string{})
+	ch.Assert(emails(ResultFilter{
+		ExcludeAttributes: map[string][]string{"position": []string{"Engineering"}},
+	}), check.DeepEquals, []string{rs[2].Email, rs[3].Email})
+
+	_, err := QueryResults(campaign.Id, campaign.UserId, ResultFilter{
+		IncludeAttributes: map[string][]string{" ": []string{"x"}},
+	})
+	ch.Assert(err, check.Equals, ErrInvalidFilterAttribute)
+}
+
+func (s *ModelsSuite) TestQueryResultsTagsAndAttributes(ch *check.C) {
+	ts := generateTargets(4)
+	ts[0].Attributes = map[string]string{"Department": "Sales", "manager": "Boss"}
+	ts[1].Attributes = map[string]string{"Department": "Sales"}
+	ts[2].Attributes = map[string]string{"Department": "IT"}
+	ts[0].Position = "Lead"
+	campaign := s.createCampaignWithTargets(ch, ts)
+	rs := campaign.Results
+	// 0: clicked, 1: clicked, 2: clicked, 3: no activity
+	for _, r := range rs[:3] {
+		ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	}
+	_, err := PutResultTags(rs[0].RId, campaign.UserId, []string{"VIP"})
+	ch.Assert(err, check.Equals, nil)
+	_, err = PutResultTags(rs[2].RId, campaign.UserId, []string{"vip", "false positive"})
+	ch.Assert(err, check.Equals, nil)
+
+	emails := func(f ResultFilter) []string {
+		got, err := QueryResults(campaign.Id, campaign.UserId, f)
+		ch.Assert(err, check.Equals, nil)
+		es := []string{}
+		for _, r := range got {
+			es = append(es, r.Email)
+		}
+		return es
+	}
+	// Tags are matched ignoring case
+	ch.Assert(emails(ResultFilter{IncludeTags: []string{"vip"}}), check.DeepEquals,
+		[]string{rs[0].Email, rs[2].Email})
+	ch.Assert(emails(ResultFilter{
+		IncludeTags: []string{"VIP"},
+		ExcludeTags: []string{"False Positive"},
+	}), check.DeepEquals, []string{rs[0].Email})
+	ch.Assert(emails(ResultFilter{
+		ExcludeTags:     []string{"vip"},
+		IncludeStatuses: []string{EVENT_CLICKED},
+	}), check.DeepEquals, []string{rs[1].Email})
+
+	// Custom attributes are matched by name ignoring case, and results without
+	// the attribute are treated as having an empty value
+	ch.Assert(emails(ResultFilter{
+		IncludeAttributes: map[string][]string{"department": []string{"Sales"}},
+	}), check.DeepEquals, []string{rs[0].Email, rs[1].Email})
+	ch.Assert(emails(ResultFilter{
+		ExcludeAttributes: map[string][]string{"Department": []string{"Sales"}},
+	}), check.DeepEquals, []string{rs[2].Email, rs[3].Email})
+	ch.Assert(emails(ResultFilter{
+		IncludeAttributes: map[string][]string{"manager": []string{""}},
+	}), check.DeepEquals, []string{rs[1].Email, rs[2].Email, rs[3].Email})
+
+	// Custom attributes, columns, tags and statuses combine
+	ch.Assert(emails(ResultFilter{
+		IncludeAttributes: map[string][]string{"department": []string{"Sales", "IT"}},
+		ExcludeAttributes: map[string][]string{"position": []string{"Lead"}},
+		IncludeTags:       []string{"vip"},
+		IncludeStatuses:   []string{EVENT_CLICKED},
+	}), check.DeepEquals, []string{rs[2].Email})
+	ch.Assert(emails(ResultFilter{
+		IncludeAttributes: map[string][]string{"department": []string{"Sales"}, "position": []string{"Lead"}},
+		ExcludeTags:       []string{"vip"},
+	}), check.DeepEquals, []string{})
+}
+
+func (s *ModelsSuite) TestResultClientTZOffset(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	_, ok := result.InferredTimezone()
+	ch.Assert(ok, check.Equals, false)
+
+	details := EventDetails{Payload: url.Values{TZOffsetParameter: []string{"-300"}}}
+	ch.Assert(result.HandleFormSubmit(details), check.Equals, nil)
+	got, err := GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(*got.ClientTZOffset, check.Equals, -300)
+	tz, ok := got.InferredTimezone()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(tz, check.Equals, "UTC-05:00")
+
+	// Invalid offsets are ignored
+	details.Payload.Set(TZOffsetParameter, "9999")
+	ch.Assert(got.HandleFormSubmit(details), check.Equals, nil)
+	got, err = GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(*got.ClientTZOffset, check.Equals, -300)
+
+	// Without a reported offset, fall back to the geolocation
+	r := Result{Latitude: 28.6, Longitude: 77.2}
+	tz, ok = r.InferredTimezone()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(tz, check.Equals, "UTC+05:00")
+	offset := 330
+	r.ClientTZOffset = &offset
+	tz, ok = r.InferredTimezone()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(tz, check.Equals, "UTC+05:30")
+}
+
+func (s *ModelsSuite) TestResultExcludeFromReport(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	ch.Assert(result.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(result.ExcludeFromReport("Sent to the wrong person"), check.Equals, nil)
+
+	got, err := GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.ExcludedFromReport, check.Equals, true)
+	ch.Assert(got.Status, check.Equals, EVENT_CLICKED)
+
+	// The exclusion is kept in the audit trail
+	e := Event{}
+	err = db.Where("campaign_id=? and email=? and message=?", campaign.Id,
+		result.Email, EVENT_EXCLUDED).First(&e).Error
+	ch.Assert(err, check.Equals, nil)
+	d := EventExclusion{}
+	ch.Assert(json.Unmarshal([]byte(e.Details), &d), check.Equals, nil)
+	ch.Assert(d.Reason, check.Equals, "Sent to the wrong person")
+
+	cs, err := GetCampaignSummary(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(cs.Stats.Total, check.Equals, int64(len(campaign.Results)-1))
+	ch.Assert(cs.Stats.ClickedLink, check.Equals, int64(0))
+
+	defer func(include bool) { IncludeExcludedResults = include }(IncludeExcludedResults)
+	IncludeExcludedResults = true
+	cs, err = GetCampaignSummary(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(cs.Stats.Total, check.Equals, int64(len(campaign.Results)))
+	ch.Assert(cs.Stats.ClickedLink, check.Equals, int64(1))
+}
+
+func (s *ModelsSuite) TestGetLateEngagements(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(3))
+	early, late, idle := campaign.Results[0], campaign.Results[1], campaign.Results[2]
+	end := time.Now().UTC().Add(-time.Hour)
+
+	// Backdate the early recipient's open so it falls before the end. Later
+	// engagement doesn't matter since only the first engagement is considered.
+	ch.Assert(early.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	err := db.Model(&Event{}).Where("campaign_id=? and email=?", campaign.Id, early.Email).
+		Update("time", end.Add(-time.Hour)).Error
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(early.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(late.HandleClickedLink(EventDetails{}), check.Equals, nil)
+
+	ok, err := early.IsLateEngagement(end)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ok, check.Equals, false)
+	ok, err = late.IsLateEngagement(end)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ok, check.Equals, true)
+	ok, err = idle.IsLateEngagement(end)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ok, check.Equals, false)
+
+	rs, err := GetLateEngagements(campaign.Id, campaign.UserId, end)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(rs), check.Equals, 1)
+	ch.Assert(rs[0].Email, check.Equals, late.Email)
+}
+
+func (s *ModelsSuite) TestResultFinalLandingURL(ch *check.C) {
+	campaign := s.createCampaign(ch)
+
+	// A single redirect: the link lands on the page, and submitting data
+	// redirects to the configured URL
+	single := campaign.Results[0]
+	_, ok := single.FinalLandingURL()
+	ch.Assert(ok, check.Equals, false)
+	ch.Assert(single.HandleClickedLink(EventDetails{
+		StatusCode: 200, LandingURL: "https://phish.example.com/?rid=" + single.RId,
+	}), check.Equals, nil)
+	landing, ok := single.FinalLandingURL()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(landing, check.Equals, "https://phish.example.com/?rid="+single.RId)
+	ch.Assert(single.HandleFormSubmit(EventDetails{
+		StatusCode: 302, LandingURL: "https://example.com/login",
+	}), check.Equals, nil)
+	landing, ok = single.FinalLandingURL()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(landing, check.Equals, "https://example.com/login")
+
+	// A chain of redirects, where only the last landing counts, and events
+	// without a recorded URL don't replace it
+	multi := campaign.Results[1]
+	chain := []string{
+		"https://phish.example.com/?rid=" + multi.RId,
+		"https://phish.example.com/step2?rid=" + multi.RId,
+		"https://example.com/done",
+	}
+	ch.Assert(multi.HandleClickedLink(EventDetails{LandingURL: chain[0]}), check.Equals, nil)
+	ch.Assert(multi.HandleFormSubmit(EventDetails{LandingURL: chain[1]}), check.Equals, nil)
+	ch.Assert(multi.HandleFormSubmit(EventDetails{LandingURL: chain[2]}), check.Equals, nil)
+	ch.Assert(multi.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	landing, ok = multi.FinalLandingURL()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(landing, check.Equals, chain[2])
+}
+
+func (s *ModelsSuite) TestResultLandingPageStatus(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	_, ok := result.LandingPageStatus()
+	ch.Assert(ok, check.Equals, false)
+
+	ch.Assert(result.HandleClickedLink(EventDetails{StatusCode: 200}), check.Equals, nil)
+	status, ok := result.LandingPageStatus()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(status, check.Equals, 200)
+
+	ch.Assert(result.HandleFormSubmit(EventDetails{StatusCode: 302}), check.Equals, nil)
+	status, ok = result.LandingPageStatus()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(status, check.Equals, 302)
+
+	// Events without a recorded status don't replace the last known status
+	ch.Assert(result.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(result.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	status, ok = result.LandingPageStatus()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(status, check.Equals, 302)
+
+	other := campaign.Results[1]
+	ch.Assert(other.HandleClickedLink(EventDetails{StatusCode: 500}), check.Equals, nil)
+	status, ok = other.LandingPageStatus()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(status, check.Equals, 500)
+}
+
+func (s *ModelsSuite) TestGenerateIdsCollision(ch *check.C) {
+	defer func(source io.Reader, existing func([]string) (map[string]bool, error)) {
+		idSource, existingIds = source, existing
+	}(idSource, existingIds)
+
+	// Each byte maps to a single character, so every 7 bytes produce one id:
+	// "aaaaaaa" collides with an existing result, "bbbbbbb" is unique, the
+	// second "bbbbbbb" collides within the batch, and "ccccccc" is unique.
+	seq := []byte{}
+	for _, b := range []byte{0, 1, 1, 2} {
+		seq = append(seq, bytes.Repeat([]byte{b}, 7)...)
+	}
+	idSource = bytes.NewReader(seq)
+	existingIds = func(rids []string) (map[string]bool, error) {
+		return map[string]bool{"aaaaaaa": true}, nil
+	}
+	ids, err := GenerateIds(2)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ids, check.DeepEquals, []string{"bbbbbbb", "ccccccc"})
+
+	// Running out of randomness is surfaced as an error
+	_, err = GenerateIds(1)
+	ch.Assert(err, check.Equals, io.EOF)
+}
+
+func (s *ModelsSuite) TestGenerateIdDatabaseError(ch *check.C) {
+	defer func(existing func([]string) (map[string]bool, error)) { existingIds = existing }(existingIds)
+	dbErr := errors.New("database is locked")
+	calls := 0
+	existingIds = func(rids []string) (map[string]bool, error) {
+		calls++
+		return nil, dbErr
+	}
+	r := Result{}
+	ch.Assert(r.GenerateId(), check.Equals, dbErr)
+	ch.Assert(calls, check.Equals, 1)
+	ch.Assert(r.RId, check.Equals, "")
+}
+
+func (s *ModelsSuite) TestResultClassifyProvider(ch *check.C) {
+	defer func(corporate []string, mx bool) {
+		CorporateDomains, ProviderMXLookup = corporate, mx
+	}(CorporateDomains, ProviderMXLookup)
+	configureEmailProviders(config.EmailProviders{CorporateDomains: []string{"example.com", " "}})
+	ch.Assert(CorporateDomains, check.DeepEquals, []string{"example.com"})
+	ch.Assert(ProviderMXLookup, check.Equals, false)
+
+	campaign := s.createCampaignWithTargets(ch, []Target{
+		{Email: "personal@Gmail.com"},
+		{Email: "employee@mail.example.com"},
+		{Email: "someone@unknown.org"},
+	})
+	for _, r := range campaign.Results {
+		switch r.Email {
+		case "personal@Gmail.com":
+			ch.Assert(r.ProviderType, check.Equals, PROVIDER_WEBMAIL)
+		case "employee@mail.example.com":
+			ch.Assert(r.ProviderType, check.Equals, PROVIDER_CORPORATE)
+		default:
+			ch.Assert(r.ProviderType, check.Equals, PROVIDER_UNKNOWN)
+		}
+	}
+	breakdown, err := GetProviderBreakdown(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(breakdown, check.DeepEquals, map[string]int64{
+		PROVIDER_WEBMAIL:   1,
+		PROVIDER_CORPORATE: 1,
+		PROVIDER_UNKNOWN:   1,
+	})
+
+	// Unknown domains which accept mail are considered corporate when MX
+	// lookups are enabled
+	defer func(lookup func(string) ([]*net.MX, error)) { lookupMX = lookup }(lookupMX)
+	lookupMX = func(domain string) ([]*net.MX, error) {
+		ch.Assert(domain, check.Equals, "unknown.org")
+		return []*net.MX{{Host: "mx.unknown.org.", Pref: 10}}, nil
+	}
+	ProviderMXLookup = true
+	for _, r := range campaign.Results {
+		if r.Email == "someone@unknown.org" {
+			ch.Assert(r.ClassifyProvider(), check.Equals, nil)
+		}
+	}
+	breakdown, err = GetProviderBreakdown(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(breakdown[PROVIDER_CORPORATE], check.Equals, int64(2))
+	ch.Assert(breakdown[PROVIDER_UNKNOWN], check.Equals, int64(0))
+}
+
+func (s *ModelsSuite) TestResultUpdateReverseDNS(ch *check.C) {
+	defer func(lookup func(context.Context, string) ([]string, error)) {
+		lookupAddr = lookup
+		configureReverseDNS(config.ReverseDNS{})
+	}(lookupAddr)
+	configureReverseDNS(config.ReverseDNS{})
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		ch.Assert(addr, check.Equals, "203.0.113.5")
+		return []string{"host-5.isp.example.net."}, nil
+	}
+
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	ch.Assert(r.UpdateReverseDNS("203.0.113.5"), check.Equals, nil)
+	ch.Assert(r.ReverseDNS, check.Equals, "host-5.isp.example.net")
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.ReverseDNS, check.Equals, "host-5.isp.example.net")
+
+	// The reverse DNS name is available in exports
+	buff := &bytes.Buffer{}
+	err = ExportForMailMerge(buff, campaign.Id, campaign.UserId, ResultFilter{}, []string{"email", "reverse_dns"})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(strings.Contains(buff.String(), r.Email+",host-5.isp.example.net\n"), check.Equals, true)
+
+	// Lookups which take too long are abandoned, leaving the result as-is
+	ReverseDNSTimeout = 10 * time.Millisecond
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	r = campaign.Results[1]
+	ch.Assert(r.UpdateReverseDNS("203.0.113.6"), check.Equals, context.DeadlineExceeded)
+	got, err = GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.ReverseDNS, check.Equals, "")
+}
+
+func (s *ModelsSuite) TestReverseDNSCache(ch *check.C) {
+	defer func(lookup func(context.Context, string) ([]string, error), slots chan struct{}) {
+		lookupAddr, reverseDNSSlots = lookup, slots
+		configureReverseDNS(config.ReverseDNS{})
+	}(lookupAddr, reverseDNSSlots)
+	configureReverseDNS(config.ReverseDNS{Enabled: true})
+	lookups := 0
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		if addr == "203.0.113.7" {
+			return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+		}
+		return []string{"host-5.isp.example.net."}, nil
+	}
+
+	// Repeated lookups of the same address are served from the cache,
+	// including addresses without a name
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	ch.Assert(r.UpdateReverseDNS("203.0.113.5"), check.Equals, nil)
+	ch.Assert(r.UpdateReverseDNS("203.0.113.5"), check.Equals, nil)
+	ch.Assert(r.UpdateReverseDNS("203.0.113.7"), check.Equals, nil)
+	ch.Assert(r.UpdateReverseDNS("203.0.113.7"), check.Equals, nil)
+	ch.Assert(lookups, check.Equals, 2)
+	ch.Assert(r.ReverseDNS, check.Equals, "host-5.isp.example.net")
+
+	// Cached names are set on the result straight away, so that they can be
+	// recorded with the event
+	r = campaign.Results[1]
+	r.queueReverseDNS("203.0.113.5")
+	ch.Assert(r.ReverseDNS, check.Equals, "host-5.isp.example.net")
+	r.queueReverseDNS("203.0.113.7")
+	ch.Assert(r.ReverseDNS, check.Equals, "")
+
+	// Lookups are skipped while every slot is taken
+	reverseDNSSlots = make(chan struct{}, 1)
+	reverseDNSSlots <- struct{}{}
+	r.queueReverseDNS("203.0.113.8")
+	ch.Assert(len(reverseDNSSlots), check.Equals, 1)
+	ch.Assert(lookups, check.Equals, 2)
+}
+
+func (s *ModelsSuite) TestConfigureReverseDNS(ch *check.C) {
+	defer configureReverseDNS(config.ReverseDNS{})
+	configureReverseDNS(config.ReverseDNS{})
+	ch.Assert(ReverseDNSLookup, check.Equals, false)
+	ch.Assert(ReverseDNSTimeout, check.Equals, DefaultReverseDNSTimeout)
+	ch.Assert(ReverseDNSCacheTTL, check.Equals, DefaultReverseDNSCacheTTL)
+	ch.Assert(cap(reverseDNSSlots), check.Equals, DefaultReverseDNSWorkers)
+
+	configureReverseDNS(config.ReverseDNS{Enabled: true, TimeoutMilliseconds: 500, Workers: 3, CacheMinutes: 5})
+	ch.Assert(ReverseDNSLookup, check.Equals, true)
+	ch.Assert(ReverseDNSTimeout, check.Equals, 500*time.Millisecond)
+	ch.Assert(ReverseDNSCacheTTL, check.Equals, 5*time.Minute)
+	ch.Assert(cap(reverseDNSSlots), check.Equals, 3)
+}
+
+func (s *ModelsSuite) TestConfigureGeoSuspicion(ch *check.C) {
+	defer func(hosting, tor []string, asns []uint) {
+		HostingNetworks, TorExitNodes, HostingASNs = hosting, tor, asns
+	}(HostingNetworks, TorExitNodes, HostingASNs)
+	ch.Assert(configureGeoSuspicion(config.GeoSuspicion{HostingNetworks: []string{"203.0.113.1"}}), check.Equals, ErrInvalidNetwork)
+	ch.Assert(configureGeoSuspicion(config.GeoSuspicion{TorExitNodes: []string{"exit.example.com"}}), check.Equals, ErrInvalidNetwork)
+	ch.Assert(configureGeoSuspicion(config.GeoSuspicion{
+		HostingNetworks: []string{"203.0.113.0/24"},
+		HostingASNs:     []uint{16509},
+		TorExitNodes:    []string{"198.51.100.7"},
+	}), check.Equals, nil)
+	ch.Assert(HostingNetworks, check.DeepEquals, []string{"203.0.113.0/24"})
+	ch.Assert(HostingASNs, check.DeepEquals, []uint{16509})
+	ch.Assert(TorExitNodes, check.DeepEquals, []string{"198.51.100.7"})
+}
+
+func (s *ModelsSuite) TestResultGeoSuspicion(ch *check.C) {
+	defer func(hosting, tor []string, asns []uint, lookup func(net.IP) (uint, bool)) {
+		HostingNetworks, TorExitNodes, HostingASNs, lookupASN = hosting, tor, asns, lookup
+	}(HostingNetworks, TorExitNodes, HostingASNs, lookupASN)
+	ch.Assert(configureGeoSuspicion(config.GeoSuspicion{
+		HostingNetworks: []string{"203.0.113.0/24"},
+		HostingASNs:     []uint{16509},
+		TorExitNodes:    []string{"198.51.100.7"},
+	}), check.Equals, nil)
+	lookupASN = func(ip net.IP) (uint, bool) {
+		if ip.String() == "192.0.2.200" {
+			return 16509, true
+		}
+		return 64496, true
+	}
+
+	campaign := s.createCampaignWithTargets(ch, generateTargets(7))
+	rs := campaign.Results
+	browser := func(addr string) map[string]string {
+		return map[string]string{"address": addr}
+	}
+	newYork := EventDetails{Browser: browser("192.0.2.1"), Latitude: 40.7, Longitude: -74.0, Country: "US"}
+
+	// A recipient who stays in one place from a residential address
+	ch.Assert(rs[0].HandleEmailOpened(newYork), check.Equals, nil)
+	ch.Assert(rs[0].HandleClickedLink(newYork), check.Equals, nil)
+
+	// Engagement from a hosting provider
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{Browser: browser("203.0.113.50")}), check.Equals, nil)
+
+	// Engagement from a Tor exit node
+	ch.Assert(rs[2].HandleClickedLink(EventDetails{Browser: browser("198.51.100.7")}), check.Equals, nil)
+
+	// Opening in New York and clicking in London moments later. The countries
+	// are left out so that only the travel signal trips.
+	ch.Assert(rs[3].HandleEmailOpened(EventDetails{Latitude: 40.7, Longitude: -74.0}), check.Equals, nil)
+	ch.Assert(rs[3].HandleClickedLink(EventDetails{Latitude: 51.5, Longitude: -0.1}), check.Equals, nil)
+
+	// Engagement from two countries without location data
+	ch.Assert(rs[4].HandleEmailOpened(EventDetails{Country: "US"}), check.Equals, nil)
+	ch.Assert(rs[4].HandleClickedLink(EventDetails{Country: "RU"}), check.Equals, nil)
+
+	// Every signal at once
+	ch.Assert(rs[5].HandleEmailOpened(newYork), check.Equals, nil)
+	ch.Assert(rs[5].HandleClickedLink(EventDetails{Browser: browser("203.0.113.9"), Latitude: 55.7, Longitude: 37.6, Country: "RU"}), check.Equals, nil)
+	ch.Assert(rs[5].HandleFormSubmit(EventDetails{Browser: browser("198.51.100.7")}), check.Equals, nil)
+	ch.Assert(rs[5].HandleFormSubmit(EventDetails{Browser: browser("192.0.2.200")}), check.Equals, nil)
+
+	// Engagement from a hosting provider's autonomous system outside of the
+	// hosting networks
+	ch.Assert(rs[6].HandleClickedLink(EventDetails{Browser: browser("192.0.2.200")}), check.Equals, nil)
+
+	expected := map[string]SuspicionReport{
+		rs[1].RId: {HostingNetwork: true},
+		rs[2].RId: {TorExitNode: true},
+		rs[3].RId: {ImpossibleTravel: true},
+		rs[4].RId: {CountryMismatch: true},
+		rs[5].RId: {HostingNetwork: true, HostingASN: true, TorExitNode: true, ImpossibleTravel: true, CountryMismatch: true},
+		rs[6].RId: {HostingASN: true},
+	}
+	sr, err := rs[0].GeoSuspicion()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(sr.Suspicious(), check.Equals, false)
+	for _, r := range rs[1:] {
+		sr, err := r.GeoSuspicion()
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(sr, check.Equals, expected[r.RId])
+	}
+	reports, err := GetSuspiciousGeoResults(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(reports, check.DeepEquals, expected)
+
+	// Travelling the same distance over a day is plausible
+	err = db.Model(&Event{}).Where("campaign_id=? and email=? and message=?",
+		campaign.Id, rs[3].Email, EVENT_OPENED).
+		Update("time", time.Now().UTC().Add(-24*time.Hour)).Error
+	ch.Assert(err, check.Equals, nil)
+	sr, err = rs[3].GeoSuspicion()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(sr.Suspicious(), check.Equals, false)
+}
+
+func (s *ModelsSuite) TestResultNextRetryAt(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+
+	// Results which aren't being retried aren't waiting
+	_, ok := result.NextRetryAt()
+	ch.Assert(ok, check.Equals, false)
+	ch.Assert(result.RetryWaiting(), check.Equals, false)
+
+	next := time.Now().UTC().Add(4 * time.Minute)
+	ch.Assert(result.HandleEmailBackoff(errors.New("Try again later"), next), check.Equals, nil)
+	at, ok := result.NextRetryAt()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(at, check.Equals, next)
+	ch.Assert(result.RetryWaiting(), check.Equals, true)
+
+	// Past due retries still report when they were scheduled
+	past := time.Now().UTC().Add(-time.Minute)
+	ch.Assert(result.HandleEmailBackoff(errors.New("Try again later"), past), check.Equals, nil)
+	at, ok = result.NextRetryAt()
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(at, check.Equals, past)
+	ch.Assert(result.RetryWaiting(), check.Equals, false)
+
+	ch.Assert(result.HandleEmailSent(), check.Equals, nil)
+	_, ok = result.NextRetryAt()
+	ch.Assert(ok, check.Equals, false)
+}
+
+func (s *ModelsSuite) TestConfigureCorporateNetworks(ch *check.C) {
+	defer func(networks []string, asns []uint) {
+		CorporateNetworks, CorporateASNs = networks, asns
+	}(CorporateNetworks, CorporateASNs)
+	err := configureCorporateNetworks(config.CorporateNetworks{Networks: []string{"10.0.0.1"}})
+	ch.Assert(err, check.Equals, ErrInvalidCorporateNetwork)
+	err = configureCorporateNetworks(config.CorporateNetworks{
+		Networks: []string{"10.0.0.0/8"},
+		ASNs:     []uint{64500},
+	})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(CorporateNetworks, check.DeepEquals, []string{"10.0.0.0/8"})
+	ch.Assert(CorporateASNs, check.DeepEquals, []uint{64500})
+}
+
+func (s *ModelsSuite) TestResultCrossedDevicesCorporateASN(ch *check.C) {
+	defer func(networks []string, asns []uint, lookup func(net.IP) (uint, bool)) {
+		CorporateNetworks, CorporateASNs, lookupASN = networks, asns, lookup
+	}(CorporateNetworks, CorporateASNs, lookupASN)
+	ch.Assert(configureCorporateNetworks(config.CorporateNetworks{ASNs: []uint{64500}}), check.Equals, nil)
+	lookupASN = func(ip net.IP) (uint, bool) {
+		if ip.Equal(net.ParseIP("198.51.100.7")) {
+			return 64500, true
+		}
+		return 0, false
+	}
+	from := func(addr string) EventDetails {
+		return EventDetails{Browser: map[string]string{"address": addr}}
+	}
+
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	ch.Assert(r.HandleEmailOpened(from("198.51.100.7")), check.Equals, nil)
+	crossed, err := r.CrossedDevices()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(crossed, check.Equals, false)
+	ch.Assert(r.HandleClickedLink(from("192.0.2.10")), check.Equals, nil)
+	crossed, err = r.CrossedDevices()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(crossed, check.Equals, true)
+}
+
+func (s *ModelsSuite) TestResultCrossedDevices(ch *check.C) {
+	defer func(corporate, hosting []string) {
+		CorporateNetworks, HostingNetworks = corporate, hosting
+	}(CorporateNetworks, HostingNetworks)
+	CorporateNetworks = []string{"10.0.0.0/8"}
+	HostingNetworks = []string{"203.0.113.0/24"}
+	from := func(addr string) EventDetails {
+		return EventDetails{Browser: map[string]string{"address": addr}}
+	}
+
+	campaign := s.createCampaignWithTargets(ch, generateTargets(4))
+	rs := campaign.Results
+	// Only the office network
+	ch.Assert(rs[0].HandleEmailOpened(from("10.1.2.3")), check.Equals, nil)
+	ch.Assert(rs[0].HandleClickedLink(from("10.4.5.6")), check.Equals, nil)
+	// Only a home network
+	ch.Assert(rs[1].HandleClickedLink(from("192.0.2.10")), check.Equals, nil)
+	// Opened at the office, then clicked from home
+	ch.Assert(rs[2].HandleEmailOpened(from("10.1.2.3")), check.Equals, nil)
+	ch.Assert(rs[2].HandleClickedLink(from("192.0.2.10")), check.Equals, nil)
+	// A mail scanner in a hosting network isn't a personal device
+	ch.Assert(rs[3].HandleEmailOpened(from("203.0.113.5")), check.Equals, nil)
+	ch.Assert(rs[3].HandleClickedLink(from("10.1.2.3")), check.Equals, nil)
+
+	expected := []bool{false, false, true, false}
+	for i, r := range rs {
+		crossed, err := r.CrossedDevices()
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(crossed, check.Equals, expected[i])
+	}
+}
+
+func (s *ModelsSuite) TestResultOpenClickGeoDivergence(ch *check.C) {
+	defer func(hosting []string) { HostingNetworks = hosting }(HostingNetworks)
+	HostingNetworks = []string{"203.0.113.0/24"}
+	at := func(lat, lon float64, addr string) EventDetails {
+		return EventDetails{
+			Browser:   map[string]string{"address": addr},
+			Latitude:  lat,
+			Longitude: lon,
+		}
+	}
+	campaign := s.createCampaignWithTargets(ch, generateTargets(4))
+	rs := campaign.Results
+
+	// Opened and clicked from the same city
+	ch.Assert(rs[0].HandleEmailOpened(at(40.7, -74.0, "192.0.2.1")), check.Equals, nil)
+	ch.Assert(rs[0].HandleClickedLink(at(40.7, -74.0, "192.0.2.1")), check.Equals, nil)
+	km, ok, err := rs[0].OpenClickGeoDivergence()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(km, check.Equals, 0.0)
+	timeline, err := rs[0].RiskTimeline()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(timeline), check.Equals, 2)
+	for _, entry := range timeline {
+		ch.Assert(entry.Annotations, check.DeepEquals, []string{})
+	}
+
+	// Opened in New York, clicked in London
+	ch.Assert(rs[1].HandleEmailOpened(at(40.7, -74.0, "192.0.2.1")), check.Equals, nil)
+	ch.Assert(rs[1].HandleClickedLink(at(51.5, -0.1, "192.0.2.2")), check.Equals, nil)
+	km, ok, err = rs[1].OpenClickGeoDivergence()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(km > 5500 && km < 5600, check.Equals, true)
+	timeline, err = rs[1].RiskTimeline()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(timeline[0].Message, check.Equals, EVENT_OPENED)
+	ch.Assert(timeline[0].Annotations, check.DeepEquals, []string{})
+	ch.Assert(timeline[1].Message, check.Equals, EVENT_CLICKED)
+	ch.Assert(timeline[1].Annotations, check.DeepEquals, []string{ANNOTATION_GEO_DIVERGENCE})
+
+	// The open has no coordinates
+	ch.Assert(rs[2].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleClickedLink(at(51.5, -0.1, "192.0.2.2")), check.Equals, nil)
+	_, ok, err = rs[2].OpenClickGeoDivergence()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ok, check.Equals, false)
+
+	// The open came from a proxy, so it doesn't reflect the recipient
+	ch.Assert(rs[3].HandleEmailOpened(at(40.7, -74.0, "203.0.113.1")), check.Equals, nil)
+	ch.Assert(rs[3].HandleClickedLink(at(51.5, -0.1, "192.0.2.2")), check.Equals, nil)
+	_, ok, err = rs[3].OpenClickGeoDivergence()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ok, check.Equals, false)
+}
+
+func (s *ModelsSuite) TestResultOpenedAttachments(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	names, err := result.OpenedAttachments()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(names, check.DeepEquals, []string{})
+
+	for _, name := range []string{"Invoice.docx", "Payroll.xlsx", "Invoice.docx"} {
+		ch.Assert(result.HandleAttachmentOpened(EventDetails{AttachmentName: name}), check.Equals, nil)
+	}
+	ch.Assert(result.Status, check.Equals, EVENT_OPENED)
+	names, err = result.OpenedAttachments()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(names, check.DeepEquals, []string{"Invoice.docx", "Payroll.xlsx"})
+
+	other := campaign.Results[1]
+	ch.Assert(other.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(other.HandleAttachmentOpened(EventDetails{AttachmentName: "Invoice.docx"}), check.Equals, nil)
+	// Opening an attachment doesn't undo a click
+	ch.Assert(other.Status, check.Equals, EVENT_CLICKED)
+
+	breakdown, err := GetCampaignAttachmentBreakdown(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(breakdown, check.DeepEquals, map[string]int64{
+		"Invoice.docx": 2,
+		"Payroll.xlsx": 1,
+	})
+}
+
+func (s *ModelsSuite) TestResultLinkExpiry(ch *check.C) {
+	campaign := s.createCampaign(ch)
+
+	// Without a policy, links never expire
+	noExpiry := campaign.Results[0]
+	ch.Assert(noExpiry.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(noExpiry.LinkExpiresAt == nil, check.Equals, true)
+	ch.Assert(noExpiry.LinkExpired(), check.Equals, false)
+
+	defer func(expiry time.Duration) { LinkExpiry = expiry }(LinkExpiry)
+	LinkExpiry = 30 * 24 * time.Hour
+	unexpired := campaign.Results[1]
+	ch.Assert(unexpired.HandleEmailSent(), check.Equals, nil)
+	got, err := GetResult(unexpired.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.LinkExpiresAt, check.NotNil)
+	ch.Assert(got.LinkExpiresAt.After(time.Now().UTC().Add(29*24*time.Hour)), check.Equals, true)
+	ch.Assert(got.LinkExpired(), check.Equals, false)
+
+	// Resending doesn't extend the expiry
+	expires := *got.LinkExpiresAt
+	ch.Assert(got.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(got.LinkExpiresAt.Equal(expires), check.Equals, true)
+
+	expired := Result{LinkExpiresAt: &time.Time{}}
+	ch.Assert(expired.LinkExpired(), check.Equals, true)
+}
+
+func (s *ModelsSuite) TestResultCameViaSafeLinks(ch *check.C) {
+	referred := func(referer string) EventDetails {
+		return EventDetails{Browser: map[string]string{"referer": referer}}
+	}
+	campaign := s.createCampaignWithTargets(ch, generateTargets(3))
+	direct, wrapped, opened := campaign.Results[0], campaign.Results[1], campaign.Results[2]
+	ch.Assert(direct.HandleClickedLink(referred("")), check.Equals, nil)
+	ch.Assert(direct.HandleFormSubmit(referred("http://phish.example.com/")), check.Equals, nil)
+	ch.Assert(wrapped.HandleClickedLink(referred(
+		"https://NAM02.SafeLinks.Protection.Outlook.com/?url=http%3A%2F%2Fphish.example.com")), check.Equals, nil)
+	// Only clicks and submissions are considered
+	ch.Assert(opened.HandleEmailOpened(referred("https://urldefense.proofpoint.com/v2/url")), check.Equals, nil)
+
+	expected := []bool{false, true, false}
+	for i, r := range []Result{direct, wrapped, opened} {
+		via, err := r.CameViaSafeLinks()
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(via, check.Equals, expected[i])
+	}
+
+	// The hosts can be configured, and default to the well known gateways
+	defer configureEmailProviders(config.EmailProviders{})
+	configureEmailProviders(config.EmailProviders{SafeLinksHosts: []string{"phish.example.com", " "}})
+	ch.Assert(SafeLinksHosts, check.DeepEquals, []string{"phish.example.com"})
+	via, err := direct.CameViaSafeLinks()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(via, check.Equals, true)
+	via, err = wrapped.CameViaSafeLinks()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(via, check.Equals, false)
+
+	configureEmailProviders(config.EmailProviders{})
+	ch.Assert(SafeLinksHosts, check.DeepEquals, DefaultSafeLinksHosts)
+}
+
+func (s *ModelsSuite) TestResultSubmitWasBot(ch *check.C) {
+	defer func(fields []string) { HoneypotFields = fields }(HoneypotFields)
+	HoneypotFields = []string{"website", "fax"}
+	campaign := s.createCampaignWithTargets(ch, generateTargets(3))
+	human, bot, mixed := campaign.Results[0], campaign.Results[1], campaign.Results[2]
+
+	ch.Assert(human.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(human.HandleFormSubmit(EventDetails{Payload: url.Values{
+		"username": []string{"jdoe"},
+		"password": []string{"hunter2"},
+		"website":  []string{""},
+	}}), check.Equals, nil)
+	ch.Assert(human.Status, check.Equals, EVENT_DATA_SUBMIT)
+
+	// The bot fills in the hidden fields, and the recipient ID it was given
+	ch.Assert(bot.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(bot.HandleFormSubmit(EventDetails{Payload: url.Values{
+		RecipientParameter: []string{bot.RId},
+		"website":          []string{"http://spam.example.com"},
+		"fax":              []string{"555-0100"},
+	}}), check.Equals, nil)
+	ch.Assert(bot.Status, check.Equals, EVENT_CLICKED)
+
+	ch.Assert(mixed.HandleFormSubmit(EventDetails{Payload: url.Values{
+		"password": []string{"hunter2"},
+		"fax":      []string{"555-0100"},
+	}}), check.Equals, nil)
+	ch.Assert(mixed.Status, check.Equals, EVENT_DATA_SUBMIT)
+
+	expected := []bool{false, true, false}
+	for i, r := range []Result{human, bot, mixed} {
+		wasBot, err := r.SubmitWasBot()
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(wasBot, check.Equals, expected[i])
+	}
+
+	// The honeypots that were filled are recorded with the submission
+	e := Event{}
+	err := db.Where("campaign_id=? and email=? and message=?", campaign.Id,
+		bot.Email, EVENT_DATA_SUBMIT).First(&e).Error
+	ch.Assert(err, check.Equals, nil)
+	d, err := e.parseDetails()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(d.Bot, check.Equals, true)
+	ch.Assert(d.Honeypots, check.DeepEquals, []string{"fax", "website"})
+
+	cs, err := GetCampaignSummary(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(cs.Stats.SubmittedData, check.Equals, int64(2))
+}
+
+func (s *ModelsSuite) TestPageHoneypotFields(ch *check.C) {
+	defer func(fields []string) { HoneypotFields = fields }(HoneypotFields)
+	configureHoneypots([]string{"fax"})
+	p := Page{HoneypotFields: "website, nickname\nfax"}
+	ch.Assert(p.Honeypots(), check.DeepEquals, []string{"website", "nickname", "fax"})
+	ch.Assert((&Page{}).Honeypots(), check.HasLen, 0)
+
+	campaign := s.createCampaignWithTargets(ch, generateTargets(2))
+	page, global := campaign.Results[0], campaign.Results[1]
+
+	// The page's own honeypot fields are checked alongside the global ones
+	ch.Assert(page.HandleFormSubmit(EventDetails{
+		Payload:       url.Values{"website": []string{"http://spam.example.com"}},
+		PageHoneypots: p.Honeypots(),
+	}), check.Equals, nil)
+	ch.Assert(global.HandleFormSubmit(EventDetails{
+		Payload:       url.Values{"fax": []string{"555-0100"}},
+		PageHoneypots: p.Honeypots(),
+	}), check.Equals, nil)
+	for _, r := range []Result{page, global} {
+		wasBot, err := r.SubmitWasBot()
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(wasBot, check.Equals, true)
+	}
+
+	// Without the page's fields, its honeypot looks like a person's input
+	ch.Assert(page.HandleFormSubmit(EventDetails{
+		Payload: url.Values{"website": []string{"http://spam.example.com"}},
+	}), check.Equals, nil)
+	wasBot, err := page.SubmitWasBot()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(wasBot, check.Equals, false)
+}
+
+func (s *ModelsSuite) TestResultOffsets(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	ch.Assert(result.SendOffset(), check.Equals, time.Duration(0))
+
+	ch.Assert(result.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(result.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(result.HandleClickedLink(EventDetails{}), check.Equals, nil)
+
+	// Pin the timeline so the offsets are predictable
+	setTime := func(message string, t time.Time) {
+		err := db.Model(&Event{}).Where("campaign_id=? and email=? and message=?",
+			campaign.Id, result.Email, message).Update("time", t).Error
+		ch.Assert(err, check.Equals, nil)
+	}
+	sent := campaign.LaunchDate.Add(5 * time.Minute)
+	setTime(EVENT_SENT, sent)
+	setTime(EVENT_OPENED, sent.Add(3*time.Minute))
+	setTime(EVENT_CLICKED, sent.Add(12*time.Minute))
+
+	ch.Assert(result.SendOffset(), check.Equals, 5*time.Minute)
+	offsets, err := result.EngagementOffsets()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(offsets, check.DeepEquals, map[string]time.Duration{
+		EVENT_OPENED:  3 * time.Minute,
+		EVENT_CLICKED: 12 * time.Minute,
+	})
+
+	// Results which were never sent have no engagement offsets
+	offsets, err = campaign.Results[1].EngagementOffsets()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(offsets), check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestResultClickedWithoutAnyOpen(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(4))
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	// A late pixel request still counts as an open
+	ch.Assert(rs[3].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[3].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+
+	expected := []bool{true, false, false, false}
+	for i, r := range rs {
+		ok, err := r.ClickedWithoutAnyOpen()
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(ok, check.Equals, expected[i])
+	}
+	found, err := GetClickedWithoutOpenResults(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(found), check.Equals, 1)
+	ch.Assert(found[0].RId, check.Equals, rs[0].RId)
+}
+
+func (s *ModelsSuite) TestResultNextSendJitter(ch *check.C) {
+	defer func(interval, jitter time.Duration) {
+		SendInterval, SendJitter = interval, jitter
+	}(SendInterval, SendJitter)
+	r := Result{}
+	ch.Assert(r.NextSendJitter(time.Minute), check.Equals, time.Minute)
+
+	configureSending(config.Sending{JitterSeconds: 30})
+	ch.Assert(SendInterval, check.Equals, time.Duration(0))
+	ch.Assert(SendJitter, check.Equals, 30*time.Second)
+	SeedSendJitter(42)
+	delays := []time.Duration{}
+	for i := 0; i < 50; i++ {
+		d := r.NextSendJitter(time.Minute)
+		ch.Assert(d >= time.Minute && d <= time.Minute+SendJitter, check.Equals, true)
+		delays = append(delays, d)
+	}
+	// The same seed produces the same delays
+	SeedSendJitter(42)
+	for _, expected := range delays {
+		ch.Assert(r.NextSendJitter(time.Minute), check.Equals, expected)
+	}
+
+	// The jitter can be drawn by concurrent campaigns
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.NextSendJitter(time.Minute)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The delays are persisted to the send dates of each result and maillog
+	configureSending(config.Sending{IntervalSeconds: 60, JitterSeconds: 30})
+	campaign := s.createCampaignWithTargets(ch, generateTargets(5))
+	ms, err := GetMailLogsByCampaign(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	sendDates := make(map[string]time.Time)
+	for _, m := range ms {
+		sendDates[m.RId] = m.SendDate
+	}
+	previous := campaign.LaunchDate
+	for i, r := range campaign.Results {
+		got, err := GetResult(r.RId)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(got.SendDate.Equal(sendDates[r.RId]), check.Equals, true)
+		if i == 0 {
+			ch.Assert(got.SendDate.Equal(campaign.LaunchDate), check.Equals, true)
+			continue
+		}
+		gap := got.SendDate.Sub(previous)
+		ch.Assert(gap >= SendInterval && gap <= SendInterval+SendJitter, check.Equals, true)
+		previous = got.SendDate
+	}
+}
+
+func (s *ModelsSuite) TestResultFirstTouch(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(5))
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleEmailReport(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[3].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+
+	expected := []string{EVENT_OPENED, EVENT_CLICKED, EVENT_REPORTED, EVENT_OPENED, ""}
+	for i, r := range rs {
+		touch, at, err := r.FirstTouch()
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(touch, check.Equals, expected[i])
+		ch.Assert(at.IsZero(), check.Equals, touch == "")
+	}
+	breakdown, err := GetFirstTouchBreakdown(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(breakdown, check.DeepEquals, map[string]int64{
+		EVENT_OPENED:   2,
+		EVENT_CLICKED:  1,
+		EVENT_REPORTED: 1,
+	})
+}
+
+func (s *ModelsSuite) TestResultTravelPath(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	_, total, err := result.TravelPath()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, 0.0)
+
+	// New York, then Boston, then Washington, with a sent event and a click
+	// without coordinates in between
+	ch.Assert(result.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(result.HandleEmailOpened(EventDetails{Latitude: 40.71, Longitude: -74.01}), check.Equals, nil)
+	ch.Assert(result.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(result.HandleClickedLink(EventDetails{Latitude: 42.36, Longitude: -71.06}), check.Equals, nil)
+	ch.Assert(result.HandleFormSubmit(EventDetails{Latitude: 38.91, Longitude: -77.04}), check.Equals, nil)
+	// Space the events out by a day so the moves are plausible
+	es, err := result.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	start := time.Now().UTC().Add(-7 * 24 * time.Hour)
+	for i, e := range es {
+		err = db.Model(&Event{}).Where("id=?", e.Id).
+			Update("time", start.Add(time.Duration(i)*24*time.Hour)).Error
+		ch.Assert(err, check.Equals, nil)
+	}
+
+	path, total, err := result.TravelPath()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(path), check.Equals, 3)
+	ch.Assert(path[0].Message, check.Equals, EVENT_OPENED)
+	ch.Assert(path[2].Message, check.Equals, EVENT_DATA_SUBMIT)
+	expected := distance(40.71, -74.01, 42.36, -71.06) + distance(42.36, -71.06, 38.91, -77.04)
+	ch.Assert(total, check.Equals, expected)
+	// Roughly 300km to Boston, and 630km on to Washington
+	ch.Assert(total > 900 && total < 970, check.Equals, true)
+
+	sr, err := result.GeoSuspicion()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(sr.ImpossibleTravel, check.Equals, false)
+}
+
+func (s *ModelsSuite) TestResultEngagedDuringBusinessHours(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(4))
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	// The second recipient's client reported UTC-06:00
+	offset := -360
+	rs[1].ClientTZOffset = &offset
+	ch.Assert(db.Save(&rs[1]).Error, check.Equals, nil)
+
+	setTime := func(r Result, t time.Time) {
+		err := db.Model(&Event{}).Where("campaign_id=? and email=?", campaign.Id, r.Email).
+			Update("time", t).Error
+		ch.Assert(err, check.Equals, nil)
+	}
+	// Monday at 10:00 UTC, Monday at 16:00 in UTC-06:00, and Saturday morning
+	setTime(rs[0], time.Date(2018, 6, 4, 10, 0, 0, 0, time.UTC))
+	setTime(rs[1], time.Date(2018, 6, 4, 22, 0, 0, 0, time.UTC))
+	setTime(rs[2], time.Date(2018, 6, 9, 11, 0, 0, 0, time.UTC))
+
+	start, end := 9*time.Hour, 17*time.Hour
+	expected := []bool{true, true, false, false}
+	for i := range rs {
+		during, err := rs[i].EngagedDuringBusinessHours(start, end, nil)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(during, check.Equals, expected[i])
+	}
+	split, err := GetBusinessHoursSplit(campaign.Id, campaign.UserId, start, end, nil)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(split, check.Equals, BusinessHoursSplit{DuringHours: 2, OutsideHours: 1})
+
+	// In Tokyo, every engagement was outside of business hours
+	tokyo := time.FixedZone("JST", 9*60*60)
+	during, err := rs[0].EngagedDuringBusinessHours(start, end, tokyo)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(during, check.Equals, false)
+	split, err = GetBusinessHoursSplit(campaign.Id, campaign.UserId, start, end, tokyo)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(split, check.Equals, BusinessHoursSplit{OutsideHours: 3})
+}
+
+func (s *ModelsSuite) TestResultValidateEventOrdering(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	rs := campaign.Results
+	for i := range rs {
+		ch.Assert(rs[i].HandleEmailSent(), check.Equals, nil)
+		ch.Assert(rs[i].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	}
+	// Skew the first result's click to before the email was sent
+	sent := Event{}
+	err := db.Where("campaign_id=? and email=? and message=?", campaign.Id, rs[0].Email, EVENT_SENT).First(&sent).Error
+	ch.Assert(err, check.Equals, nil)
+	err = db.Model(&Event{}).Where("campaign_id=? and email=? and message=?", campaign.Id, rs[0].Email, EVENT_CLICKED).
+		UpdateColumn("time", sent.Time.Add(-time.Hour)).Error
+	ch.Assert(err, check.Equals, nil)
+
+	ch.Assert(rs[0].ValidateEventOrdering(), check.Equals, ErrEventsOutOfOrder)
+	ch.Assert(rs[1].ValidateEventOrdering(), check.Equals, nil)
+	skewed, err := GetClockSkewedResults(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(skewed), check.Equals, 1)
+	ch.Assert(skewed[0].RId, check.Equals, rs[0].RId)
+
+	// The latency of the skewed click is clamped rather than going negative
+	offsets, err := rs[0].EngagementOffsets()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(offsets[EVENT_CLICKED], check.Equals, time.Duration(0))
+	offsets, err = rs[1].EngagementOffsets()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(offsets[EVENT_CLICKED] >= 0, check.Equals, true)
+}
+
+func (s *ModelsSuite) TestResultReportedWithinGraceAfterClick(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(4))
+	rs := campaign.Results
+	// Clicked and then quickly reported
+	ch.Assert(rs[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[0].HandleEmailReport(EventDetails{}), check.Equals, nil)
+	// Clicked and reported much later
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleEmailReport(EventDetails{}), check.Equals, nil)
+	err := db.Model(&Event{}).Where("campaign_id=? and email=? and message=?", campaign.Id, rs[1].Email, EVENT_REPORTED).
+		UpdateColumn("time", time.Now().UTC().Add(2*time.Hour)).Error
+	ch.Assert(err, check.Equals, nil)
+	// Reported before clicking
+	ch.Assert(rs[2].HandleEmailReport(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	// Clicked without reporting
+	ch.Assert(rs[3].HandleClickedLink(EventDetails{}), check.Equals, nil)
+
+	expected := []bool{true, false, false, false}
+	for i, r := range rs {
+		ok, err := r.ReportedWithinGraceAfterClick(time.Hour)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(ok, check.Equals, expected[i])
+	}
+	ok, err := rs[1].ReportedWithinGraceAfterClick(3 * time.Hour)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ok, check.Equals, true)
+}
+
+func (s *ModelsSuite) TestResultHandleInboxPlacement(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(5))
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleInboxPlacement(PLACEMENT_INBOX), check.Equals, nil)
+	ch.Assert(rs[1].HandleInboxPlacement(PLACEMENT_SPAM), check.Equals, nil)
+	ch.Assert(rs[2].HandleInboxPlacement(PLACEMENT_SPAM), check.Equals, nil)
+	ch.Assert(rs[3].HandleInboxPlacement(PLACEMENT_MISSING), check.Equals, nil)
+	ch.Assert(rs[4].HandleInboxPlacement("junk"), check.Equals, ErrInvalidPlacement)
+
+	got, err := GetResult(rs[1].RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.InboxPlacement, check.Equals, PLACEMENT_SPAM)
+	ch.Assert(got.Status, check.Equals, rs[1].Status)
+	es, err := got.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(es[len(es)-1].Message, check.Equals, EVENT_PLACEMENT)
+
+	placements, err := GetCampaignInboxPlacement(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(placements, check.DeepEquals, map[string]int64{
+		PLACEMENT_INBOX:   1,
+		PLACEMENT_SPAM:    2,
+		PLACEMENT_MISSING: 1,
+	})
+}
+
+func (s *ModelsSuite) TestResultLikelyForwardedInternally(ch *check.C) {
+	defer func(corporate []string) { CorporateNetworks = corporate }(CorporateNetworks)
+	CorporateNetworks = []string{}
+	from := func(addr string) EventDetails {
+		return EventDetails{Browser: map[string]string{"address": addr}}
+	}
+	campaign := s.createCampaignWithTargets(ch, generateTargets(5))
+	rs := campaign.Results
+	// Normal engagement from a single address
+	ch.Assert(rs[0].HandleEmailOpened(from("10.0.0.1")), check.Equals, nil)
+	ch.Assert(rs[0].HandleClickedLink(from("10.0.0.1")), check.Equals, nil)
+	// The same link used from several addresses
+	for _, addr := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		ch.Assert(rs[1].HandleClickedLink(from(addr)), check.Equals, nil)
+	}
+	// Someone else entered their own email address
+	d := from("10.0.0.5")
+	d.Payload = url.Values{"username": []string{"colleague@example.com"}}
+	ch.Assert(rs[2].HandleFormSubmit(d), check.Equals, nil)
+	// The recipient entered their own email address
+	d = from("10.0.0.6")
+	d.Payload = url.Values{"username": []string{strings.ToUpper(rs[3].Email)}, "password": []string{"hunter2"}}
+	ch.Assert(rs[3].HandleFormSubmit(d), check.Equals, nil)
+	// Only one of the addresses is internal
+	for _, addr := range []string{"10.0.0.7", "203.0.113.8", "203.0.113.9"} {
+		ch.Assert(rs[4].HandleClickedLink(from(addr)), check.Equals, nil)
+	}
+
+	expected := []bool{false, true, true, false, true}
+	for i, r := range rs {
+		ok, err := r.LikelyForwardedInternally()
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(ok, check.Equals, expected[i], check.Commentf("result %d", i))
+	}
+
+	CorporateNetworks = []string{"10.0.0.0/8"}
+	ok, err := rs[4].LikelyForwardedInternally()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(ok, check.Equals, false)
+	found, err := GetLikelyForwardedResults(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(found), check.Equals, 2)
+}
+
+func (s *ModelsSuite) TestResultEngagementFingerprints(ch *check.C) {
+	client := func(addr, ua string) EventDetails {
+		return EventDetails{Browser: map[string]string{
+			"address":         addr,
+			"user-agent":      ua,
+			"accept-language": "en-US",
+		}}
+	}
+	researcher := "Mozilla/5.0 (X11; Linux x86_64) Firefox/60.0"
+	campaign := s.createCampaignWithTargets(ch, generateTargets(4))
+	rs := campaign.Results
+	// The same client, with its address changing within its subnet, clicks
+	// three different results' links
+	ch.Assert(rs[0].HandleClickedLink(client("203.0.113.5", researcher)), check.Equals, nil)
+	ch.Assert(rs[1].HandleClickedLink(client("203.0.113.6", researcher)), check.Equals, nil)
+	ch.Assert(rs[2].HandleEmailOpened(client("203.0.113.7", researcher)), check.Equals, nil)
+	// The recipient themselves on two different devices
+	ch.Assert(rs[0].HandleEmailOpened(client("198.51.100.20", "Outlook")), check.Equals, nil)
+	ch.Assert(rs[3].HandleClickedLink(client("198.51.100.30", "Mobile Safari")), check.Equals, nil)
+
+	fps, err := rs[0].EngagementFingerprints()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(fps), check.Equals, 2)
+	other, err := rs[1].EngagementFingerprints()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(other, check.DeepEquals, fps[:1])
+	// Events without request details, such as sending, aren't fingerprinted
+	ch.Assert(rs[3].HandleEmailSent(), check.Equals, nil)
+	fps, err = rs[3].EngagementFingerprints()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(fps), check.Equals, 1)
+
+	shared, err := GetSharedFingerprints(campaign.Id, campaign.UserId, 2)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(shared), check.Equals, 1)
+	expected := []string{rs[0].RId, rs[1].RId, rs[2].RId}
+	sort.Strings(expected)
+	ch.Assert(shared[other[0]], check.DeepEquals, expected)
+}
+
+func (s *ModelsSuite) TestCanTransition(ch *check.C) {
+	order := []string{EVENT_SENT, EVENT_OPENED, EVENT_CLICKED, EVENT_DATA_SUBMIT, EVENT_MFA_SUBMIT}
+	for i, from := range order {
+		for j, to := range order {
+			ch.Assert(canTransition(from, to), check.Equals, j >= i, check.Commentf("%s -> %s", from, to))
+		}
+	}
+	// Statuses from before delivery can move between each other, but not
+	// back from a delivered status
+	for _, pre := range []string{STATUS_SCHEDULED, STATUS_SENDING, STATUS_RETRY, ERROR} {
+		ch.Assert(canTransition(pre, EVENT_SENT), check.Equals, true)
+		ch.Assert(canTransition(pre, ERROR), check.Equals, true)
+		ch.Assert(canTransition(EVENT_SENT, pre), check.Equals, false)
+	}
+}
+
+func (s *ModelsSuite) TestResultStatusNeverRegresses(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	ch.Assert(r.HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(r.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(r.HandleAttachmentOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(r.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(r.HandleEmailError(errors.New("late bounce")), check.Equals, nil)
+	ch.Assert(r.HandleEmailBackoff(errors.New("late retry"), time.Now().UTC()), check.Equals, nil)
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, EVENT_DATA_SUBMIT)
+	// Every event is still recorded
+	es, err := got.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(es), check.Equals, 7)
+}
+
+func (s *ModelsSuite) TestHandleMFASubmit(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	ch.Assert(r.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(r.HandleFormSubmit(EventDetails{
+		Payload: url.Values{"username": {"user"}, "password": {"hunter2"}},
+	}), check.Equals, nil)
+	ch.Assert(r.HandleMFASubmit(EventDetails{
+		Payload: url.Values{"otp": {"123456"}},
+	}), check.Equals, nil)
+	// A later credential submission doesn't downgrade the status
+	ch.Assert(r.HandleFormSubmit(EventDetails{
+		Payload: url.Values{"username": {"user"}, "password": {"hunter2"}},
+	}), check.Equals, nil)
+
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, EVENT_MFA_SUBMIT)
+	es, err := got.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	found := false
+	for _, e := range es {
+		if e.Message != EVENT_MFA_SUBMIT {
+			continue
+		}
+		found = true
+		d, err := e.parseDetails()
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(d.Payload.Get("otp"), check.Equals, "123456")
+	}
+	ch.Assert(found, check.Equals, true)
+
+	// Capturing a second factor counts as submitting data
+	ch.Assert(campaign.Results[1].HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	stats, err := getCampaignStats(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(stats.SubmittedMFA, check.Equals, int64(1))
+	ch.Assert(stats.SubmittedData, check.Equals, int64(2))
+	ch.Assert(stats.ClickedLink, check.Equals, int64(2))
+}
+
+func (s *ModelsSuite) TestHandleEmailOpenedDuplicateWindow(ch *check.C) {
+	defer func(window time.Duration) { DuplicateOpenWindow = window }(DuplicateOpenWindow)
+	DuplicateOpenWindow = 5 * time.Second
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	opens := func() int {
+		count := 0
+		err := db.Model(&Event{}).Where("campaign_id=? and email=? and message=?",
+			campaign.Id, result.Email, EVENT_OPENED).Count(&count).Error
+		ch.Assert(err, check.Equals, nil)
+		return count
+	}
+
+	// The first open is recorded, and the scanner burst after it is collapsed
+	for i := 0; i < 5; i++ {
+		ch.Assert(result.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	}
+	ch.Assert(opens(), check.Equals, 1)
+	r, err := GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(r.Status, check.Equals, EVENT_OPENED)
+
+	// Opens after the window has passed are recorded again
+	err = db.Model(&Event{}).Where("email=? and message=?", result.Email, EVENT_OPENED).
+		Update("time", time.Now().UTC().Add(-time.Minute)).Error
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(result.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(opens(), check.Equals, 2)
+
+	// Disabling the window records every open
+	DuplicateOpenWindow = 0
+	ch.Assert(result.HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(opens(), check.Equals, 3)
+}
+
+func (s *ModelsSuite) TestConfigureDuplicateOpens(ch *check.C) {
+	defer func(window time.Duration) { DuplicateOpenWindow = window }(DuplicateOpenWindow)
+	configureDuplicateOpens(config.DuplicateOpens{})
+	ch.Assert(DuplicateOpenWindow, check.Equals, DefaultDuplicateOpenWindow)
+	configureDuplicateOpens(config.DuplicateOpens{WindowSeconds: 30})
+	ch.Assert(DuplicateOpenWindow, check.Equals, 30*time.Second)
+	configureDuplicateOpens(config.DuplicateOpens{WindowSeconds: -1})
+	ch.Assert(DuplicateOpenWindow, check.Equals, time.Duration(0))
+}
+
+func (s *ModelsSuite) TestConfigureLinkExpiry(ch *check.C) {
+	defer func(expiry time.Duration, conf config.LinkExpiry) {
+		LinkExpiry, config.Conf.PhishConf.LinkExpiry = expiry, conf
+	}(LinkExpiry, config.Conf.PhishConf.LinkExpiry)
+	configureLinkExpiry(config.LinkExpiry{Hours: 48})
+	ch.Assert(LinkExpiry, check.Equals, 48*time.Hour)
+	configureLinkExpiry(config.LinkExpiry{Hours: -1})
+	ch.Assert(LinkExpiry, check.Equals, time.Duration(0))
+
+	// The expiry is applied when the models are set up
+	config.Conf.PhishConf.LinkExpiry = config.LinkExpiry{Hours: 24}
+	ch.Assert(Setup(), check.Equals, nil)
+	ch.Assert(LinkExpiry, check.Equals, 24*time.Hour)
+	campaign := s.createCampaign(ch)
+	r := campaign.Results[0]
+	ch.Assert(r.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(r.LinkExpiresAt, check.NotNil)
+	ch.Assert(r.LinkExpiresAt.Sub(r.SendDate) > 23*time.Hour, check.Equals, true)
+}
+
+func (s *ModelsSuite) TestResultValidate(ch *check.C) {
+	valid := []string{"target@example.com", "first.last+tag@sub.example.co.uk"}
+	for _, email := range valid {
+		r := Result{Email: email}
+		ch.Assert(r.Validate(), check.Equals, nil)
+	}
+	ch.Assert((&Result{Email: ""}).Validate(), check.Equals, ErrEmailNotSpecified)
+	ch.Assert((&Result{Email: "   "}).Validate(), check.Equals, ErrEmailNotSpecified)
+	invalid := []string{
+		"John Smith <john",
+		"not an email",
+		"John Smith <john@example.com>",
+		"@example.com",
+	}
+	for _, email := range invalid {
+		r := Result{Email: email}
+		ch.Assert(r.Validate(), check.Equals, ErrInvalidEmail)
+	}
+}
+
+func (s *ModelsSuite) TestPostCampaignInvalidRecipient(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	g := c.Groups[0]
+	g.Targets = generateTargets(3)
+	ch.Assert(PutGroup(&g), check.Equals, nil)
+	// Simulate a recipient which was stored before addresses were validated
+	err := db.Model(&Target{}).Where("email=?", "target1@example.com").
+		Update("email", "John Smith <john").Error
+	ch.Assert(err, check.Equals, nil)
+
+	c.Groups = []Group{g}
+	err = PostCampaign(&c, c.UserId)
+	ch.Assert(err, check.NotNil)
+	ch.Assert(strings.Contains(err.Error(), ErrInvalidEmail.Error()), check.Equals, true)
+	ch.Assert(strings.Contains(err.Error(), `"John Smith <john"`), check.Equals, true)
+
+	// Nothing was created for the campaign
+	count := 0
+	ch.Assert(db.Model(&Campaign{}).Count(&count).Error, check.Equals, nil)
+	ch.Assert(count, check.Equals, 0)
+	ch.Assert(db.Model(&Result{}).Count(&count).Error, check.Equals, nil)
+	ch.Assert(count, check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestPostCampaignDuplicateTargets(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	other := Group{Name: "Other Group", UserId: c.UserId}
+	other.Targets = []Target{
+		{Email: "TEST1@Example.com", FirstName: "Duplicate", LastName: "Example"},
+		{Email: "test3@example.com", FirstName: "Third", LastName: "Example"},
+		{Email: "Test2@example.com", FirstName: "Duplicate", LastName: "Example"},
+	}
+	ch.Assert(PostGroup(&other), check.Equals, nil)
+	c.Groups = append(c.Groups, other)
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+	ch.Assert(c.DuplicatesSkipped, check.Equals, 2)
+	ch.Assert(len(c.Results), check.Equals, 3)
+	// The first target with each address wins
+	ch.Assert(c.Results[0].Email, check.Equals, "test1@example.com")
+	ch.Assert(c.Results[0].FirstName, check.Equals, "First")
+	ch.Assert(c.Results[1].Email, check.Equals, "test2@example.com")
+	ch.Assert(c.Results[2].Email, check.Equals, "test3@example.com")
+	ms, err := GetMailLogsByCampaign(c.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ms), check.Equals, 3)
+
+	// Results in other campaigns are independent
+	c2 := Campaign{Name: "Second campaign", UserId: c.UserId, Template: c.Template,
+		Page: c.Page, SMTP: c.SMTP, Groups: []Group{other}}
+	ch.Assert(PostCampaign(&c2, c2.UserId), check.Equals, nil)
+	ch.Assert(c2.DuplicatesSkipped, check.Equals, 0)
+	ch.Assert(len(c2.Results), check.Equals, 3)
+}
+
+func (s *ModelsSuite) TestGetResultsPage(ch *check.C) {
+	ts := generateTargets(7)
+	ts[5].Email = "Some_One@Example.com"
+	ts[6].Email = "someXone@example.com"
+	campaign := s.createCampaignWithTargets(ch, ts)
+	rs := campaign.Results
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[3].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[4].HandleFormSubmit(EventDetails{}), check.Equals, nil)
+
+	// Paging through every result neither skips nor repeats any
+	seen := []string{}
+	for offset := 0; ; offset += 3 {
+		page, total, err := GetResultsPage(campaign.Id, campaign.UserId, ResultQuery{Offset: offset, Limit: 3})
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(total, check.Equals, int64(7))
+		if len(page) == 0 {
+			break
+		}
+		for _, r := range page {
+			seen = append(seen, r.RId)
+		}
+	}
+	ch.Assert(len(seen), check.Equals, 7)
+	for i, r := range rs {
+		ch.Assert(seen[i], check.Equals, r.RId)
+	}
+
+	page, total, err := GetResultsPage(campaign.Id, campaign.UserId, ResultQuery{
+		Statuses: []string{EVENT_CLICKED, EVENT_DATA_SUBMIT}, Limit: 2,
+	})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, int64(3))
+	ch.Assert(len(page), check.Equals, 2)
+	ch.Assert(page[0].RId, check.Equals, rs[1].RId)
+	ch.Assert(page[1].RId, check.Equals, rs[3].RId)
+
+	// The search is case-insensitive, and wildcards are matched literally
+	page, total, err = GetResultsPage(campaign.Id, campaign.UserId, ResultQuery{Search: "some_one@"})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, int64(1))
+	ch.Assert(page[0].Email, check.Equals, "Some_One@Example.com")
+	page, total, err = GetResultsPage(campaign.Id, campaign.UserId, ResultQuery{Search: "TARGET"})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, int64(5))
+	ch.Assert(len(page), check.Equals, 5)
+
+	// Other users can't page through the campaign's results
+	page, total, err = GetResultsPage(campaign.Id, campaign.UserId+1, ResultQuery{})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, int64(0))
+	ch.Assert(len(page), check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestGetResultsPageSortAndFilter(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(4))
+	rs := campaign.Results
+	ch.Assert(rs[2].HandleEmailReport(EventDetails{}), check.Equals, nil)
+
+	reported := true
+	page, total, err := GetResultsPage(campaign.Id, campaign.UserId, ResultQuery{Reported: &reported})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, int64(1))
+	ch.Assert(page[0].RId, check.Equals, rs[2].RId)
+
+	page, _, err = GetResultsPage(campaign.Id, campaign.UserId, ResultQuery{Sort: "-email"})
+	ch.Assert(err, check.Equals, nil)
+	for i := 1; i < len(page); i++ {
+		ch.Assert(page[i-1].Email >= page[i].Email, check.Equals, true)
+	}
+
+	// Results are matched by when they were last modified
+	_, total, err = GetResultsPage(campaign.Id, campaign.UserId, ResultQuery{Since: time.Now().Add(time.Hour)})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, int64(0))
+
+	_, _, err = GetResultsPage(campaign.Id, campaign.UserId, ResultQuery{Sort: "email,ip"})
+	ch.Assert(err, check.Equals, ErrInvalidSort)
+
+	// Only the events for the page of results are included in the timeline
+	cr, total, err := GetCampaignResultsPage(campaign.Id, campaign.UserId, ResultQuery{Limit: 1, Offset: 2})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, int64(4))
+	ch.Assert(len(cr.Results), check.Equals, 1)
+	ch.Assert(cr.Results[0].RId, check.Equals, rs[2].RId)
+	ch.Assert(len(cr.Events) > 1, check.Equals, true)
+	for _, e := range cr.Events {
+		ch.Assert(e.Email == "" || e.Email == rs[2].Email, check.Equals, true)
+	}
+}
+
+func (s *ModelsSuite) TestGetFilteredCampaignsAndGroups(ch *check.C) {
+	s.createCampaign(ch)
+	c := s.createCampaign(ch)
+	ch.Assert(db.Model(&c).Update("name", "Other campaign").Error, check.Equals, nil)
+
+	cs, total, err := GetFilteredCampaigns(c.UserId, CampaignFilter{Name: "OTHER"})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, int64(1))
+	ch.Assert(cs[0].Id, check.Equals, c.Id)
+
+	cs, total, err = GetFilteredCampaigns(c.UserId, CampaignFilter{Sort: "-id", Limit: 1})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, int64(2))
+	ch.Assert(len(cs), check.Equals, 1)
+	ch.Assert(cs[0].Id, check.Equals, c.Id)
+	ch.Assert(len(cs[0].Results), check.Equals, 2)
+
+	_, total, err = GetFilteredCampaigns(c.UserId, CampaignFilter{Status: CAMPAIGN_COMPLETE})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, int64(0))
+
+	gs, total, err := GetFilteredGroups(c.UserId, GroupFilter{Offset: 1})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(gs), check.Equals, int(total)-1)
+	_, _, err = GetFilteredGroups(c.UserId, GroupFilter{Sort: "targets"})
+	ch.Assert(err, check.Equals, ErrInvalidSort)
+}
+
+func (s *ModelsSuite) TestResultTimesUTC(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	zone := time.FixedZone("UTC+10", 10*60*60)
+	sendDate := time.Date(2018, 7, 1, 9, 0, 0, 0, zone)
+	ch.Assert(result.HandleEmailBackoff(errors.New("Temporary failure"), sendDate), check.Equals, nil)
+	ch.Assert(result.SendDate.Location(), check.Equals, time.UTC)
+	ch.Assert(result.SendDate.Equal(sendDate), check.Equals, true)
+	ch.Assert(result.ModifiedDate.Location(), check.Equals, time.UTC)
+
+	result, err := GetResult(result.RId)
+	ch.Assert(err, check.Equals, nil)
+	rj, err := json.Marshal(result)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(strings.Contains(string(rj), `"send_date":"2018-06-30T23:00:00Z"`), check.Equals, true)
+	ch.Assert(result.ModifiedDate.Location(), check.Equals, time.UTC)
+
+	es, err := result.GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(es) > 0, check.Equals, true)
+	for _, e := range es {
+		ch.Assert(e.Time.Location(), check.Equals, time.UTC)
+		ej, err := json.Marshal(e)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(regexp.MustCompile(`"time":"[^"]+Z"`).MatchString(string(ej)), check.Equals, true)
+	}
+}
+
+func (s *ModelsSuite) TestDeleteResult(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(3))
+	rs := campaign.Results
+	ch.Assert(rs[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(DeleteResult(rs[0].RId), check.Equals, nil)
+
+	// Removed results are excluded from lookups and statistics
+	_, err := GetResult(rs[0].RId)
+	ch.Assert(err, check.Equals, gorm.ErrRecordNotFound)
+	active, err := ResultStorage.List(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(active), check.Equals, 2)
+	_, total, err := GetResultsPage(campaign.Id, campaign.UserId, ResultQuery{})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, int64(2))
+	stats, err := getCampaignStats(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(stats.Total, check.Equals, int64(2))
+	ch.Assert(stats.ClickedLink, check.Equals, int64(0))
+	ms, err := GetQueuedMailLogs(time.Now().UTC())
+	ch.Assert(err, check.Equals, nil)
+	for _, m := range ms {
+		ch.Assert(m.RId, check.Not(check.Equals), rs[0].RId)
+	}
+
+	// but they're kept, along with their events, for auditing
+	deleted, err := GetDeletedResults(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(deleted), check.Equals, 1)
+	ch.Assert(deleted[0].RId, check.Equals, rs[0].RId)
+	ch.Assert(deleted[0].Status, check.Equals, EVENT_CLICKED)
+	ch.Assert(deleted[0].DeletedAt, check.NotNil)
+	es, err := deleted[0].GetEvents()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(es) > 0, check.Equals, true)
+	existing, err := existingIds([]string{rs[0].RId})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(existing[rs[0].RId], check.Equals, true)
+
+	ch.Assert(RestoreResult(rs[0].RId), check.Equals, nil)
+	got, err := GetResult(rs[0].RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, EVENT_CLICKED)
+	ch.Assert(got.DeletedAt, check.IsNil)
+	stats, err = getCampaignStats(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(stats.Total, check.Equals, int64(3))
+	// Only removed results can be restored
+	ch.Assert(RestoreResult(rs[0].RId), check.Equals, gorm.ErrRecordNotFound)
+	ch.Assert(RestoreResult("missing"), check.Equals, gorm.ErrRecordNotFound)
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	log "github.com/gophish/gophish/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// The actions a retention policy can take once a campaign's data has been
+// kept for long enough
+const (
+	RETENTION_ANONYMIZE string = "anonymize"
+	RETENTION_PURGE     string = "purge"
+)
+
+// RetentionDays is the number of days after a campaign is completed that its
+// results are kept, unless the campaign gives its own. Results are kept
+// indefinitely when it's 0.
+var RetentionDays = 0
+
+// RetentionAction is what's done with a campaign's results once they've been
+// kept for RetentionDays, unless the campaign gives its own action.
+var RetentionAction = RETENTION_ANONYMIZE
+
+// RetentionHashKey is the secret used to hash the email addresses of results
+// anonymized by a retention policy. If none is configured, a random key is
+// used, so the same address hashes differently once Gophish is restarted.
+var RetentionHashKey = generateSecureKey()
+
+// ErrInvalidRetentionAction is thrown when a retention policy's action isn't
+// anonymize or purge
+var ErrInvalidRetentionAction = errors.New("Retention action must be anonymize or purge")
+
+// ErrInvalidRetentionDays is thrown when a retention policy's number of days
+// is negative
+var ErrInvalidRetentionDays = errors.New("Retention days can't be negative")
+
+// ErrCampaignNotCompleted is thrown when a retention action is applied to a
+// campaign which hasn't been completed
+var ErrCampaignNotCompleted = errors.New("Campaign must be completed before its results can be anonymized or purged")
+
+// validateRetention checks that the retention policy's number of days and
+// action are valid. An empty action uses the global action.
+func validateRetention(days int, action string) error {
+	if days < 0 {
+		return ErrInvalidRetentionDays
+	}
+	switch action {
+	case "", RETENTION_ANONYMIZE, RETENTION_PURGE:
+		return nil
+	}
+	return ErrInvalidRetentionAction
+}
+
+// configureRetention applies the configured global retention policy.
+func configureRetention(conf config.Retention) error {
+	err := validateRetention(conf.Days, conf.Action)
+	if err != nil {
+		return err
+	}
+	RetentionDays = conf.Days
+	if conf.Action != "" {
+		RetentionAction = conf.Action
+	}
+	if conf.HashKey != "" {
+		RetentionHashKey = conf.HashKey
+	}
+	return nil
+}
+
+// RetentionPolicy returns the number of days after the campaign is completed
+// that its results are kept, and what's done with them afterwards. The
+// campaign's own policy overrides the global one, and the results are kept
+// indefinitely if the number of days is 0.
+func (c *Campaign) RetentionPolicy() (int, string) {
+	days, action := RetentionDays, RetentionAction
+	if c.RetentionDays > 0 {
+		days = c.RetentionDays
+	}
+	if c.RetentionAction != "" {
+		action = c.RetentionAction
+	}
+	return days, action
+}
+
+// retentionDue returns whether or not the campaign's retention policy is due
+// to be applied at the given time.
+func (c *Campaign) retentionDue(t time.Time) bool {
+	days, _ := c.RetentionPolicy()
+	if days == 0 || !c.IsComplete() || !c.RetentionDate.IsZero() {
+		return false
+	}
+	return !c.CompletedDate.AddDate(0, 0, days).After(t)
+}
+
+// GetDueRetentionCampaigns returns the completed campaigns whose retention
+// policy is due to be applied at the given time.
+func GetDueRetentionCampaigns(t time.Time) ([]Campaign, error) {
+	cs := []Campaign{}
+	err := db.Where("status in (?) and (retention_date is null or retention_date <= ?)", []string{CAMPAIGN_COMPLETE, CAMPAIGN_ARCHIVED}, time.Time{}).
+		Find(&cs).Error
+	if err != nil {
+		log.Error(err)
+		return cs, err
+	}
+	due := []Campaign{}
+	for _, c := range cs {
+		if c.retentionDue(t) {
+			due = append(due, c)
+		}
+	}
+	return due, nil
+}
+
+// ApplyRetention removes the personal information from the campaign's
+// results and events using the given action, recording the time it was
+// applied. Anonymizing replaces each email address with its hash, so that
+// repeat offenders can still be counted across campaigns, and blanks the
+// names, IP addresses, locations and event details, which include submitted
+// data, as described by Result.Anonymize. Purging deletes the results and
+// their events, send attempts and snapshots entirely. The campaign itself is
+// kept either way. Since an archive can't be anonymized, the archive of an
+// archived campaign is deleted by either action.
+func (c *Campaign) ApplyRetention(action string, t time.Time) error {
+	if !c.IsComplete() {
+		return ErrCampaignNotCompleted
+	}
+	var err error
+	switch action {
+	case RETENTION_ANONYMIZE:
+		var rs []*Result
+		rs, err = allCampaignResults(c.Id, c.UserId)
+		if err == nil {
+			err = anonymizeResults(c.Id, rs, true)
+		}
+	case RETENTION_PURGE:
+		err = purgeCampaignResults(c.Id)
+	default:
+		return ErrInvalidRetentionAction
+	}
+	if err == nil && c.Status == CAMPAIGN_ARCHIVED {
+		err = removeArchive(c.Id)
+	}
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		"campaign_id": c.Id,
+		"action":      action,
+	}).Info("Applied retention policy to campaign")
+	c.RetentionDate = t.UTC()
+	return db.Model(c).UpdateColumn("retention_date", c.RetentionDate).Error
+}
+
+// purgeCampaignResults deletes the results of the campaign with the given id,
+// along with the events, send attempts, snapshots, tags, notes and short
+// links recorded for them, in a single transaction.
+func purgeCampaignResults(cid int64) error {
+	err := FlushResults()
+	if err != nil {
+		return err
+	}
+	tx := db.Begin()
+	for _, m := range []interface{}{&Result{}, &Event{}, &SendAttempt{}, &Snapshot{}, &MailLog{}, &ResultTag{}, &ResultNote{}, &ShortURL{}} {
+		err := tx.Unscoped().Where("campaign_id=?", cid).Delete(m).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// AnonymizeCampaign applies the given retention action to the campaign
+// specified by the given id and user_id immediately, regardless of its
+// retention policy, returning the action applied. The campaign's own action,
+// or the global one, is used if no action is given.
+func AnonymizeCampaign(id int64, uid int64, action string) (string, error) {
+	c, err := GetCampaign(id, uid)
+	if err != nil {
+		return action, err
+	}
+	if action == "" {
+		_, action = c.RetentionPolicy()
+	}
+	return action, c.ApplyRetention(action, time.Now().UTC())
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestCampaignValidateRetention(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	c.RetentionDays = -1
+	ch.Assert(c.Validate(), check.Equals, ErrInvalidRetentionDays)
+	c.RetentionDays = 30
+	c.RetentionAction = "delete"
+	ch.Assert(c.Validate(), check.Equals, ErrInvalidRetentionAction)
+	c.RetentionAction = RETENTION_PURGE
+	ch.Assert(c.Validate(), check.Equals, nil)
+	ch.Assert(configureRetention(config.Retention{Days: 30, Action: "delete"}), check.Equals, ErrInvalidRetentionAction)
+}
+
+func (s *ModelsSuite) TestCampaignRetentionPolicy(ch *check.C) {
+	defer func(days int, action string) {
+		RetentionDays, RetentionAction = days, action
+	}(RetentionDays, RetentionAction)
+	c := Campaign{}
+	days, action := c.RetentionPolicy()
+	ch.Assert(days, check.Equals, 0)
+	ch.Assert(action, check.Equals, RETENTION_ANONYMIZE)
+
+	ch.Assert(configureRetention(config.Retention{Days: 90, Action: RETENTION_PURGE}), check.Equals, nil)
+	days, action = c.RetentionPolicy()
+	ch.Assert(days, check.Equals, 90)
+	ch.Assert(action, check.Equals, RETENTION_PURGE)
+
+	// The campaign's own policy overrides the global one
+	c.RetentionDays = 30
+	c.RetentionAction = RETENTION_ANONYMIZE
+	days, action = c.RetentionPolicy()
+	ch.Assert(days, check.Equals, 30)
+	ch.Assert(action, check.Equals, RETENTION_ANONYMIZE)
+}
+
+func (s *ModelsSuite) TestGetDueRetentionCampaigns(ch *check.C) {
+	c := s.createCampaign(ch)
+	now := time.Now().UTC()
+	due, err := GetDueRetentionCampaigns(now)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(due), check.Equals, 0)
+
+	ch.Assert(CompleteCampaign(c.Id, c.UserId), check.Equals, nil)
+	ch.Assert(db.Model(&c).Updates(map[string]interface{}{
+		"completed_date": now.AddDate(0, 0, -31),
+		"retention_days": 30,
+	}).Error, check.Equals, nil)
+	due, err = GetDueRetentionCampaigns(now)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(due), check.Equals, 1)
+	ch.Assert(due[0].Id, check.Equals, c.Id)
+	c = due[0]
+	due, err = GetDueRetentionCampaigns(now.AddDate(0, 0, -2))
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(due), check.Equals, 0)
+
+	// Campaigns are only anonymized once
+	ch.Assert(c.ApplyRetention(RETENTION_ANONYMIZE, now), check.Equals, nil)
+	due, err = GetDueRetentionCampaigns(now)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(due), check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestApplyRetentionAnonymize(ch *check.C) {
+	c := s.createCampaign(ch)
+	victim := c.Results[0]
+	ch.Assert(victim.HandleFormSubmit(EventDetails{
+		Payload: url.Values{"username": {"victim"}, "password": {"hunter2"}},
+	}), check.Equals, nil)
+	ch.Assert(c.ApplyRetention(RETENTION_ANONYMIZE, time.Now()), check.Equals, ErrCampaignNotCompleted)
+
+	ch.Assert(CompleteCampaign(c.Id, c.UserId), check.Equals, nil)
+	c, err := GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	before, err := getCampaignStats(c.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(c.ApplyRetention(RETENTION_ANONYMIZE, time.Now()), check.Equals, nil)
+
+	// Email addresses are replaced with their hashes, and the rest of the
+	// personal information is removed
+	got, err := GetResult(victim.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Email, check.Equals, hashEmail(victim.Email))
+	ch.Assert(got.Email, check.Not(check.Equals), victim.Email)
+	ch.Assert(got.FirstName, check.Equals, "")
+	ch.Assert(got.LastName, check.Equals, "")
+	ch.Assert(got.Status, check.Equals, EVENT_DATA_SUBMIT)
+	es := []Event{}
+	ch.Assert(db.Where("campaign_id=? and message=?", c.Id, EVENT_DATA_SUBMIT).Find(&es).Error, check.Equals, nil)
+	ch.Assert(len(es), check.Equals, 1)
+	ch.Assert(es[0].Email, check.Equals, got.Email)
+	ch.Assert(es[0].Details, check.Equals, "")
+	after, err := getCampaignStats(c.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(after, check.Equals, before)
+	c, err = GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(c.RetentionDate.IsZero(), check.Equals, false)
+}
+
+func (s *ModelsSuite) TestHashEmailUsesKey(ch *check.C) {
+	defer func(key string) { RetentionHashKey = key }(RetentionHashKey)
+	ch.Assert(configureRetention(config.Retention{HashKey: "first-key"}), check.Equals, nil)
+	ch.Assert(RetentionHashKey, check.Equals, "first-key")
+
+	// Addresses are normalized before they're hashed
+	hashed := hashEmail("Victim@Example.com ")
+	ch.Assert(hashed, check.Equals, hashEmail("victim@example.com"))
+	ch.Assert(hashEmail(""), check.Equals, "")
+
+	// The hash can't be reproduced without the key
+	plain := sha256.Sum256([]byte("victim@example.com"))
+	ch.Assert(strings.Contains(hashed, hex.EncodeToString(plain[:])), check.Equals, false)
+	RetentionHashKey = "second-key"
+	ch.Assert(hashEmail("victim@example.com"), check.Not(check.Equals), hashed)
+}
+
+func (s *ModelsSuite) TestApplyRetentionPurge(ch *check.C) {
+	c := s.createCampaign(ch)
+	ch.Assert(c.Results[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(CompleteCampaign(c.Id, c.UserId), check.Equals, nil)
+
+	action, err := AnonymizeCampaign(c.Id, c.UserId, RETENTION_PURGE)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(action, check.Equals, RETENTION_PURGE)
+	count := 0
+	ch.Assert(db.Unscoped().Model(&Result{}).Where("campaign_id=?", c.Id).Count(&count).Error, check.Equals, nil)
+	ch.Assert(count, check.Equals, 0)
+	ch.Assert(db.Model(&Event{}).Where("campaign_id=?", c.Id).Count(&count).Error, check.Equals, nil)
+	ch.Assert(count, check.Equals, 0)
+
+	// The campaign itself is kept
+	got, err := GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Status, check.Equals, CAMPAIGN_COMPLETE)
+	ch.Assert(len(got.Results), check.Equals, 0)
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	log "github.com/gophish/gophish/logger"
+)
+
+// DefaultReverseDNSTimeout is the ReverseDNSTimeout used if none is
+// configured.
+const DefaultReverseDNSTimeout = 2 * time.Second
+
+// DefaultReverseDNSWorkers is the number of reverse DNS lookups which can run
+// at once if no limit is configured.
+const DefaultReverseDNSWorkers = 10
+
+// DefaultReverseDNSCacheTTL is the ReverseDNSCacheTTL used if none is
+// configured.
+const DefaultReverseDNSCacheTTL = time.Hour
+
+// maxReverseDNSCacheEntries is the number of addresses kept in the reverse
+// DNS cache before it's pruned.
+const maxReverseDNSCacheEntries = 10000
+
+// ReverseDNSLookup determines whether or not the reverse DNS (PTR) name of
+// the IP address is looked up when updating the geolocation of a result.
+var ReverseDNSLookup = false
+
+// ReverseDNSTimeout is the maximum amount of time to wait for a reverse DNS
+// lookup to complete.
+var ReverseDNSTimeout = DefaultReverseDNSTimeout
+
+// ReverseDNSCacheTTL is how long the reverse DNS name of an address is cached
+// after it's looked up.
+var ReverseDNSCacheTTL = DefaultReverseDNSCacheTTL
+
+// lookupAddr is used to perform reverse DNS lookups. It can be replaced to
+// avoid network requests.
+var lookupAddr = net.DefaultResolver.LookupAddr
+
+// reverseDNSSlots limits the number of reverse DNS lookups running in the
+// background. A lookup is only started if a slot is free.
+var reverseDNSSlots = make(chan struct{}, DefaultReverseDNSWorkers)
+
+// reverseDNSEntry is a cached reverse DNS name. An empty name means the
+// address doesn't have one.
+type reverseDNSEntry struct {
+	name    string
+	expires time.Time
+}
+
+// reverseDNSCache holds the reverse DNS names of recently looked up
+// addresses, so that repeated requests from the same address don't each
+// cause a lookup.
+var reverseDNSCache = map[string]reverseDNSEntry{}
+
+// reverseDNSLock guards reverseDNSCache
+var reverseDNSLock sync.Mutex
+
+// configureReverseDNS sets up reverse DNS lookups from the given
+// configuration, using the defaults for any limits which aren't set.
+func configureReverseDNS(conf config.ReverseDNS) {
+	ReverseDNSLookup = conf.Enabled
+	ReverseDNSTimeout = DefaultReverseDNSTimeout
+	if conf.TimeoutMilliseconds > 0 {
+		ReverseDNSTimeout = time.Duration(conf.TimeoutMilliseconds) * time.Millisecond
+	}
+	workers := DefaultReverseDNSWorkers
+	if conf.Workers > 0 {
+		workers = conf.Workers
+	}
+	reverseDNSSlots = make(chan struct{}, workers)
+	ReverseDNSCacheTTL = DefaultReverseDNSCacheTTL
+	if conf.CacheMinutes > 0 {
+		ReverseDNSCacheTTL = time.Duration(conf.CacheMinutes) * time.Minute
+	}
+	reverseDNSLock.Lock()
+	reverseDNSCache = map[string]reverseDNSEntry{}
+	reverseDNSLock.Unlock()
+}
+
+// cachedReverseDNS returns the cached reverse DNS name of the given address,
+// and whether or not one was cached.
+func cachedReverseDNS(addr string) (string, bool) {
+	reverseDNSLock.Lock()
+	defer reverseDNSLock.Unlock()
+	e, ok := reverseDNSCache[addr]
+	if !ok || time.Now().After(e.expires) {
+		return "", false
+	}
+	return e.name, true
+}
+
+// cacheReverseDNS caches the reverse DNS name of the given address. Expired
+// entries are pruned once the cache is full, and if that isn't enough the
+// cache is emptied, so that it can't grow without bound.
+func cacheReverseDNS(addr string, name string) {
+	reverseDNSLock.Lock()
+	defer reverseDNSLock.Unlock()
+	now := time.Now()
+	if len(reverseDNSCache) >= maxReverseDNSCacheEntries {
+		for a, e := range reverseDNSCache {
+			if now.After(e.expires) {
+				delete(reverseDNSCache, a)
+			}
+		}
+		if len(reverseDNSCache) >= maxReverseDNSCacheEntries {
+			reverseDNSCache = map[string]reverseDNSEntry{}
+		}
+	}
+	reverseDNSCache[addr] = reverseDNSEntry{name: name, expires: now.Add(ReverseDNSCacheTTL)}
+}
+
+// lookupReverseDNS returns the reverse DNS name of the given address, giving
+// up after ReverseDNSTimeout. Names are served from the cache when possible,
+// and addresses without a name are cached so that they aren't looked up
+// again.
+func lookupReverseDNS(addr string) (string, error) {
+	if name, ok := cachedReverseDNS(addr); ok {
+		return name, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ReverseDNSTimeout)
+	defer cancel()
+	names, err := lookupAddr(ctx, addr)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	name := ""
+	if len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	cacheReverseDNS(addr, name)
+	return name, nil
+}
+
+// queueReverseDNS sets the reverse DNS name of the result to the cached name
+// of the given address. If the address isn't cached, the name is cleared and
+// looked up in the background, so that the tracking request isn't blocked on
+// the lookup. Lookups are skipped if too many are already running.
+func (r *Result) queueReverseDNS(addr string) {
+	if name, ok := cachedReverseDNS(addr); ok {
+		r.ReverseDNS = name
+		return
+	}
+	r.ReverseDNS = ""
+	select {
+	case reverseDNSSlots <- struct{}{}:
+	default:
+		log.Debugf("Too many reverse DNS lookups running, skipping lookup of %s", addr)
+		return
+	}
+	go func(r Result, slots chan struct{}) {
+		defer func() { <-slots }()
+		err := r.UpdateReverseDNS(addr)
+		if err != nil {
+			log.Warn(err)
+		}
+	}(*r, reverseDNSSlots)
+}
+
+// UpdateReverseDNS looks up the reverse DNS (PTR) name of the given IP
+// address, giving up after ReverseDNSTimeout, and stores it on the result.
+func (r *Result) UpdateReverseDNS(addr string) error {
+	name, err := lookupReverseDNS(addr)
+	if err != nil || name == "" {
+		return err
+	}
+	r.ReverseDNS = name
+	// Only update the column, since the rest of the result may have changed
+	// while the lookup was running. Held saves are written first, so that
+	// they can't overwrite it later.
+	err = FlushResults()
+	if err != nil {
+		return err
+	}
+	return db.Model(&Result{}).Where("id = ?", r.Id).UpdateColumn("reverse_dns", r.ReverseDNS).Error
+}
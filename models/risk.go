@@ -0,0 +1,241 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// The weights given to each action a recipient takes when computing their
+// risk score. Reporting the email reduces the score for that campaign.
+var (
+	RiskOpenWeight   = 1.0
+	RiskClickWeight  = 3.0
+	RiskSubmitWeight = 6.0
+	RiskReportWeight = 2.0
+)
+
+// RiskHalfLife is how long it takes for a campaign's contribution to a
+// recipient's risk score to halve, so that recent behaviour outweighs old
+// mistakes. A zero value weighs every campaign equally.
+var RiskHalfLife = 180 * 24 * time.Hour
+
+// RepeatOffenderThreshold is the number of campaigns in which a recipient
+// must have clicked the link to be flagged as a repeat offender.
+var RepeatOffenderThreshold = 2
+
+// UserRisk contains the engagement of a single person across all of the
+// campaigns they were a target of, along with their risk score from 0 to 100.
+type UserRisk struct {
+	Email          string    `json:"email"`
+	FirstName      string    `json:"first_name"`
+	LastName       string    `json:"last_name"`
+	Position       string    `json:"position"`
+	Campaigns      int       `json:"campaigns"`
+	Opened         int       `json:"opened"`
+	Clicked        int       `json:"clicked"`
+	Submitted      int       `json:"submitted"`
+	Reported       int       `json:"reported"`
+	Score          float64   `json:"score"`
+	RepeatOffender bool      `json:"repeat_offender"`
+	LastCampaign   time.Time `json:"last_campaign_date"`
+}
+
+// GroupRisk contains the risk scores of the targets in a group.
+type GroupRisk struct {
+	Id              int64      `json:"id"`
+	Name            string     `json:"name"`
+	NumTargets      int        `json:"num_targets"`
+	AverageScore    float64    `json:"average_score"`
+	RepeatOffenders int        `json:"repeat_offenders"`
+	Users           []UserRisk `json:"users"`
+}
+
+// wasSent returns whether or not the result's email was sent, so that it can
+// count towards the recipient's risk.
+func (r *Result) wasSent() bool {
+	switch r.Status {
+	case STATUS_SCHEDULED, STATUS_SENDING, STATUS_RETRY, STATUS_QUEUED, ERROR, EVENT_SENDING_ERROR:
+		return false
+	}
+	return true
+}
+
+// riskPoints returns the fraction of the maximum risk the recipient reached
+// in the result's campaign.
+func (r *Result) riskPoints() float64 {
+	points := 0.0
+	if r.hasOpened() {
+		points += RiskOpenWeight
+	}
+	if r.hasClicked() {
+		points += RiskClickWeight
+	}
+	if r.hasSubmitted() {
+		points += RiskSubmitWeight
+	}
+	if r.Reported {
+		points -= RiskReportWeight
+	}
+	max := RiskOpenWeight + RiskClickWeight + RiskSubmitWeight
+	if max <= 0 {
+		return 0
+	}
+	return math.Max(0, math.Min(points/max, 1))
+}
+
+// riskDecay returns the weight given to a campaign launched at the given time.
+func riskDecay(launched time.Time, now time.Time) float64 {
+	if RiskHalfLife <= 0 {
+		return 1
+	}
+	age := now.Sub(launched)
+	if age < 0 {
+		age = 0
+	}
+	return math.Pow(0.5, float64(age)/float64(RiskHalfLife))
+}
+
+// getUserRisks returns the risk of each person targeted by the campaigns
+// owned by the given user, keyed by their normalized email address.
+func getUserRisks(uid int64) (map[string]*UserRisk, error) {
+	risks := make(map[string]*UserRisk)
+	err := FlushResults()
+	if err != nil {
+		return risks, err
+	}
+	cs := []Campaign{}
+	err = db.Where("user_id in (?)", teamUserIds(uid)).Find(&cs).Error
+	if err != nil {
+		return risks, err
+	}
+	launched := make(map[int64]time.Time)
+	for _, c := range cs {
+		launched[c.Id] = c.LaunchDate
+	}
+	query := reportScope(db.Where("user_id in (?)", teamUserIds(uid)))
+	rs := []Result{}
+	err = query.Order("id asc").Find(&rs).Error
+	if err != nil {
+		return risks, err
+	}
+	now := time.Now().UTC()
+	weights := make(map[string]float64)
+	clicked := make(map[string]map[int64]bool)
+	for _, r := range rs {
+		if !r.wasSent() || r.Email == "" || r.isAnonymized() {
+			continue
+		}
+		email := normalizeEmail(r.Email)
+		ur, ok := risks[email]
+		if !ok {
+			ur = &UserRisk{Email: email}
+			risks[email] = ur
+			clicked[email] = make(map[int64]bool)
+		}
+		// The details from the latest campaign win
+		ur.FirstName = r.FirstName
+		ur.LastName = r.LastName
+		ur.Position = r.Position
+		ur.Campaigns++
+		if r.hasOpened() {
+			ur.Opened++
+		}
+		if r.hasClicked() {
+			ur.Clicked++
+			clicked[email][r.CampaignId] = true
+		}
+		if r.hasSubmitted() {
+			ur.Submitted++
+		}
+		if r.Reported {
+			ur.Reported++
+		}
+		date := launched[r.CampaignId]
+		if date.After(ur.LastCampaign) {
+			ur.LastCampaign = date
+		}
+		w := riskDecay(date, now)
+		weights[email] += w
+		ur.Score += w * r.riskPoints()
+	}
+	for email, ur := range risks {
+		if weights[email] > 0 {
+			ur.Score = 100 * ur.Score / weights[email]
+		}
+		ur.RepeatOffender = len(clicked[email]) >= RepeatOffenderThreshold
+	}
+	return risks, nil
+}
+
+// sortUserRisks orders the risks from the highest score to the lowest.
+func sortUserRisks(urs []UserRisk) {
+	sort.SliceStable(urs, func(i, j int) bool {
+		if urs[i].Score == urs[j].Score {
+			return urs[i].Email < urs[j].Email
+		}
+		return urs[i].Score > urs[j].Score
+	})
+}
+
+// GetUserRisk returns the risk of each person targeted by the campaigns owned
+// by the given user, ordered from the highest score to the lowest. Results
+// for the same email address are combined across campaigns, regardless of
+// case. Each campaign the person was sent an email in adds the fraction of
+// the RiskOpenWeight, RiskClickWeight and RiskSubmitWeight they reached, less
+// RiskReportWeight if they reported it, and recent campaigns are weighted
+// more heavily according to RiskHalfLife.
+func GetUserRisk(uid int64) ([]UserRisk, error) {
+	urs := []UserRisk{}
+	risks, err := getUserRisks(uid)
+	if err != nil {
+		return urs, err
+	}
+	for _, ur := range risks {
+		urs = append(urs, *ur)
+	}
+	sortUserRisks(urs)
+	return urs, nil
+}
+
+// GetGroupRisk returns the risk of each target in the group specified by the
+// given id and user_id, as described by GetUserRisk. Targets who haven't been
+// sent any emails are included with a score of 0, but aren't counted in the
+// group's average score.
+func GetGroupRisk(id int64, uid int64) (GroupRisk, error) {
+	gr := GroupRisk{Users: []UserRisk{}}
+	g, err := GetGroup(id, uid)
+	if err != nil {
+		return gr, err
+	}
+	gr.Id = g.Id
+	gr.Name = g.Name
+	gr.NumTargets = len(g.Targets)
+	risks, err := getUserRisks(uid)
+	if err != nil {
+		return gr, err
+	}
+	scored := 0
+	for _, t := range g.Targets {
+		ur, ok := risks[normalizeEmail(t.Email)]
+		if !ok {
+			ur = &UserRisk{Email: normalizeEmail(t.Email)}
+		}
+		ur.FirstName = t.FirstName
+		ur.LastName = t.LastName
+		ur.Position = t.Position
+		if ur.Campaigns > 0 {
+			gr.AverageScore += ur.Score
+			scored++
+		}
+		if ur.RepeatOffender {
+			gr.RepeatOffenders++
+		}
+		gr.Users = append(gr.Users, *ur)
+	}
+	if scored > 0 {
+		gr.AverageScore /= float64(scored)
+	}
+	sortUserRisks(gr.Users)
+	return gr, nil
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+// createRiskCampaigns creates two campaigns in which the first target falls
+// for both emails and the second target ignores or reports them.
+func (s *ModelsSuite) createRiskCampaigns(ch *check.C) (Campaign, Campaign) {
+	first := s.createCampaign(ch)
+	ch.Assert(first.Results[0].HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	ch.Assert(first.Results[1].HandleEmailSent(), check.Equals, nil)
+	ch.Assert(first.Results[1].HandleEmailReport(EventDetails{}), check.Equals, nil)
+
+	second := s.createCampaign(ch)
+	ch.Assert(second.Results[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(second.Results[1].HandleEmailSent(), check.Equals, nil)
+	return first, second
+}
+
+func (s *ModelsSuite) TestGetUserRisk(ch *check.C) {
+	defer func(d time.Duration) { RiskHalfLife = d }(RiskHalfLife)
+	RiskHalfLife = 0
+	first, _ := s.createRiskCampaigns(ch)
+
+	urs, err := GetUserRisk(first.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(urs), check.Equals, 2)
+	ch.Assert(urs[0].Email, check.Equals, first.Results[0].Email)
+	ch.Assert(urs[0].Campaigns, check.Equals, 2)
+	ch.Assert(urs[0].Opened, check.Equals, 2)
+	ch.Assert(urs[0].Clicked, check.Equals, 2)
+	ch.Assert(urs[0].Submitted, check.Equals, 1)
+	ch.Assert(urs[0].Reported, check.Equals, 0)
+	ch.Assert(urs[0].RepeatOffender, check.Equals, true)
+	// Submitting scores 100 and clicking scores 40, which average to 70
+	ch.Assert(math.Abs(urs[0].Score-70) < 0.001, check.Equals, true, check.Commentf("score %f", urs[0].Score))
+
+	ch.Assert(urs[1].Email, check.Equals, first.Results[1].Email)
+	ch.Assert(urs[1].Campaigns, check.Equals, 2)
+	ch.Assert(urs[1].Reported, check.Equals, 1)
+	ch.Assert(urs[1].Score, check.Equals, 0.0)
+	ch.Assert(urs[1].RepeatOffender, check.Equals, false)
+
+	// Other users can't see the scores
+	urs, err = GetUserRisk(2)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(urs), check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestGetUserRiskDecay(ch *check.C) {
+	first, _ := s.createRiskCampaigns(ch)
+	// The older campaign is only worth half as much as the recent one
+	launched := time.Now().UTC().Add(-RiskHalfLife)
+	err := db.Model(&Campaign{}).Where("id=?", first.Id).UpdateColumn("launch_date", launched).Error
+	ch.Assert(err, check.Equals, nil)
+
+	urs, err := GetUserRisk(first.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(math.Abs(urs[0].Score-60) < 0.01, check.Equals, true, check.Commentf("score %f", urs[0].Score))
+}
+
+func (s *ModelsSuite) TestGetGroupRisk(ch *check.C) {
+	defer func(d time.Duration) { RiskHalfLife = d }(RiskHalfLife)
+	RiskHalfLife = 0
+	first, _ := s.createRiskCampaigns(ch)
+
+	// Targets are matched regardless of case, and ones who haven't been sent
+	// an email aren't counted in the average
+	g := first.Groups[0]
+	g.Targets[0].Email = strings.ToUpper(g.Targets[0].Email)
+	g.Targets = append(g.Targets, Target{Email: "new@example.com"})
+	ch.Assert(PutGroup(&g), check.Equals, nil)
+
+	gr, err := GetGroupRisk(g.Id, first.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(gr.Name, check.Equals, g.Name)
+	ch.Assert(gr.NumTargets, check.Equals, 3)
+	ch.Assert(gr.RepeatOffenders, check.Equals, 1)
+	ch.Assert(math.Abs(gr.AverageScore-35) < 0.001, check.Equals, true, check.Commentf("average %f", gr.AverageScore))
+	ch.Assert(len(gr.Users), check.Equals, 3)
+	ch.Assert(gr.Users[0].RepeatOffender, check.Equals, true)
+	ch.Assert(gr.Users[1].Email, check.Equals, "new@example.com")
+	ch.Assert(gr.Users[1].Campaigns, check.Equals, 0)
+
+	_, err = GetGroupRisk(g.Id, 2)
+	ch.Assert(err, check.NotNil)
+}
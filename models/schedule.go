@@ -0,0 +1,216 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"time"
+
+	"github.com/gophish/gophish/cron"
+	log "github.com/gophish/gophish/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// CampaignSchedule is a blueprint for a campaign which is launched on a
+// recurring schedule, such as a monthly awareness program. Each time the
+// schedule's cron expression is due, a campaign is created from the
+// blueprint's template, landing page, sending profile and group, and
+// launched. If a sample percentage is given, each campaign is sent to a
+// different random sample of the group.
+type CampaignSchedule struct {
+	Id            int64     `json:"id"`
+	UserId        int64     `json:"-"`
+	Name          string    `json:"name"`
+	TemplateId    int64     `json:"template_id"`
+	PageId        int64     `json:"page_id"`
+	SMTPId        int64     `json:"smtp_id"`
+	GroupId       int64     `json:"group_id"`
+	URL           string    `json:"url"`
+	Recurrence    string    `json:"recurrence"`
+	SamplePercent int       `json:"sample_percent"`
+	IsActive      bool      `json:"is_active"`
+	NextRunDate   time.Time `json:"next_run_date"`
+	LastRunDate   time.Time `json:"last_run_date"`
+	ModifiedDate  time.Time `json:"modified_date"`
+}
+
+// ErrScheduleNameNotSpecified is thrown when a campaign schedule has no name
+var ErrScheduleNameNotSpecified = errors.New("Schedule name not specified")
+
+// ErrInvalidRecurrence is thrown when a campaign schedule's recurrence isn't a
+// valid cron expression
+var ErrInvalidRecurrence = errors.New("Recurrence must be a valid cron expression")
+
+// ErrInvalidSamplePercent is thrown when the percentage of a group's targets
+// to sample isn't between 0 and 100
+var ErrInvalidSamplePercent = errors.New("Sample percent must be between 0 and 100")
+
+// sampleSource is the source of randomness used to sample targets. It can be
+// reseeded to make the samples reproducible.
+var sampleSource = mathrand.New(newLockedSource())
+
+// sampleTargets replaces the targets in each of the campaign's groups with a
+// random sample of SamplePercent of them, keeping at least one target from
+// each group which has any.
+func (c *Campaign) sampleTargets() {
+	if c.SamplePercent <= 0 || c.SamplePercent >= 100 {
+		return
+	}
+	for i, g := range c.Groups {
+		n := (len(g.Targets)*c.SamplePercent + 99) / 100
+		sample := make([]Target, 0, n)
+		for _, j := range sampleSource.Perm(len(g.Targets))[:n] {
+			sample = append(sample, g.Targets[j])
+		}
+		c.Groups[i].Targets = sample
+	}
+}
+
+// Validate ensures that the schedule has a name and a valid recurrence, and
+// that the template, landing page, sending profile and group it launches
+// campaigns with belong to the user.
+func (s *CampaignSchedule) Validate() error {
+	switch {
+	case s.Name == "":
+		return ErrScheduleNameNotSpecified
+	case s.SamplePercent < 0 || s.SamplePercent > 100:
+		return ErrInvalidSamplePercent
+	}
+	if _, err := cron.Parse(s.Recurrence); err != nil {
+		return ErrInvalidRecurrence
+	}
+	if _, err := GetTemplate(s.TemplateId, s.UserId); err != nil {
+		return ErrTemplateNotFound
+	}
+	if _, err := GetPage(s.PageId, s.UserId); err != nil {
+		return ErrPageNotFound
+	}
+	if _, err := GetSMTP(s.SMTPId, s.UserId); err != nil {
+		return ErrSMTPNotFound
+	}
+	if _, err := GetGroup(s.GroupId, s.UserId); err != nil {
+		return ErrGroupNotFound
+	}
+	return nil
+}
+
+// next returns when the schedule is next due after the given time
+func (s *CampaignSchedule) next(t time.Time) time.Time {
+	sched, err := cron.Parse(s.Recurrence)
+	if err != nil {
+		return time.Time{}
+	}
+	return sched.Next(t.UTC())
+}
+
+// GetCampaignSchedules returns the campaign schedules visible to the given
+// user
+func GetCampaignSchedules(uid int64) ([]CampaignSchedule, error) {
+	ss := []CampaignSchedule{}
+	err := db.Where("user_id in (?)", teamUserIds(uid)).Order("id asc").Find(&ss).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return ss, err
+}
+
+// GetCampaignSchedule returns the campaign schedule, if it exists, specified
+// by the given id and user_id.
+func GetCampaignSchedule(id int64, uid int64) (CampaignSchedule, error) {
+	s := CampaignSchedule{}
+	err := db.Where("id=? and user_id in (?)", id, teamUserIds(uid)).First(&s).Error
+	return s, err
+}
+
+// PostCampaignSchedule creates a new campaign schedule in the database.
+func PostCampaignSchedule(s *CampaignSchedule) error {
+	return PutCampaignSchedule(s)
+}
+
+// PutCampaignSchedule edits an existing campaign schedule in the database.
+// The next run is recalculated from the current time, so that a changed
+// recurrence takes effect immediately.
+func PutCampaignSchedule(s *CampaignSchedule) error {
+	err := s.Validate()
+	if err != nil {
+		return err
+	}
+	s.ModifiedDate = time.Now().UTC()
+	s.NextRunDate = s.next(s.ModifiedDate)
+	err = db.Save(s).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// DeleteCampaignSchedule deletes the campaign schedule specified by the given
+// id and user_id. The campaigns it launched are kept.
+func DeleteCampaignSchedule(id int64, uid int64) error {
+	s, err := GetCampaignSchedule(id, uid)
+	if err != nil {
+		return err
+	}
+	err = db.Delete(&s).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// GetDueCampaignSchedules returns the active campaign schedules which are due
+// to launch a campaign at the given time.
+func GetDueCampaignSchedules(t time.Time) ([]CampaignSchedule, error) {
+	ss := []CampaignSchedule{}
+	err := db.Where("is_active=? and next_run_date <= ?", true, t).Find(&ss).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return ss, err
+}
+
+// Launch creates a campaign from the schedule's blueprint which launches
+// immediately, and advances the schedule to its next run. The schedule is
+// advanced even if the campaign can't be created, such as when its group
+// has been deleted, so that it isn't retried every minute.
+func (s *CampaignSchedule) Launch(t time.Time) (Campaign, error) {
+	c := Campaign{
+		Name:          fmt.Sprintf("%s (%s)", s.Name, t.UTC().Format("2006-01-02 15:04")),
+		URL:           s.URL,
+		ScheduleId:    s.Id,
+		SamplePercent: s.SamplePercent,
+	}
+	s.LastRunDate = t.UTC()
+	s.NextRunDate = s.next(t)
+	err := db.Model(s).Updates(map[string]interface{}{
+		"last_run_date": s.LastRunDate,
+		"next_run_date": s.NextRunDate,
+	}).Error
+	if err != nil {
+		log.Error(err)
+		return c, err
+	}
+	err = s.Validate()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"schedule_id": s.Id,
+		}).Error(err)
+		return c, err
+	}
+	// Validate has ensured these exist
+	tmpl, _ := GetTemplate(s.TemplateId, s.UserId)
+	p, _ := GetPage(s.PageId, s.UserId)
+	smtp, _ := GetSMTP(s.SMTPId, s.UserId)
+	g, _ := GetGroup(s.GroupId, s.UserId)
+	c.Template = Template{Name: tmpl.Name}
+	c.Page = Page{Name: p.Name}
+	c.SMTP = SMTP{Name: smtp.Name}
+	c.Groups = []Group{{Name: g.Name}}
+	err = PostCampaign(&c, s.UserId)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"schedule_id": s.Id,
+		}).Error(err)
+	}
+	return c, err
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) createSchedule(ch *check.C) CampaignSchedule {
+	c := s.createCampaignDependencies(ch)
+	g := c.Groups[0]
+	g.Targets = generateTargets(10)
+	ch.Assert(PutGroup(&g), check.Equals, nil)
+	return CampaignSchedule{
+		UserId:        c.UserId,
+		Name:          "Monthly awareness",
+		TemplateId:    c.Template.Id,
+		PageId:        c.Page.Id,
+		SMTPId:        c.SMTP.Id,
+		GroupId:       g.Id,
+		URL:           "http://example.com",
+		Recurrence:    "@monthly",
+		SamplePercent: 25,
+		IsActive:      true,
+	}
+}
+
+func (s *ModelsSuite) TestPostCampaignScheduleValidation(ch *check.C) {
+	sched := s.createSchedule(ch)
+	for _, tc := range []struct {
+		modify   func(*CampaignSchedule)
+		expected error
+	}{
+		{func(cs *CampaignSchedule) { cs.Name = "" }, ErrScheduleNameNotSpecified},
+		{func(cs *CampaignSchedule) { cs.Recurrence = "every month" }, ErrInvalidRecurrence},
+		{func(cs *CampaignSchedule) { cs.SamplePercent = 101 }, ErrInvalidSamplePercent},
+		{func(cs *CampaignSchedule) { cs.TemplateId = 0 }, ErrTemplateNotFound},
+		{func(cs *CampaignSchedule) { cs.GroupId = 0 }, ErrGroupNotFound},
+		{func(cs *CampaignSchedule) { cs.UserId = 2 }, ErrTemplateNotFound},
+	} {
+		cs := sched
+		tc.modify(&cs)
+		ch.Assert(PostCampaignSchedule(&cs), check.Equals, tc.expected)
+	}
+
+	ch.Assert(PostCampaignSchedule(&sched), check.Equals, nil)
+	now := time.Now().UTC()
+	expected := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	ch.Assert(sched.NextRunDate.Equal(expected), check.Equals, true)
+}
+
+func (s *ModelsSuite) TestLaunchCampaignSchedule(ch *check.C) {
+	sched := s.createSchedule(ch)
+	ch.Assert(PostCampaignSchedule(&sched), check.Equals, nil)
+	inactive := sched
+	inactive.Id = 0
+	inactive.IsActive = false
+	ch.Assert(PostCampaignSchedule(&inactive), check.Equals, nil)
+
+	// Only active schedules which have reached their next run are due
+	ss, err := GetDueCampaignSchedules(time.Now().UTC())
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ss), check.Equals, 0)
+	t := sched.NextRunDate
+	ss, err = GetDueCampaignSchedules(t)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ss), check.Equals, 1)
+	ch.Assert(ss[0].Id, check.Equals, sched.Id)
+
+	c, err := ss[0].Launch(t)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(c.Status, check.Equals, CAMPAIGN_IN_PROGRESS)
+	ch.Assert(c.ScheduleId, check.Equals, sched.Id)
+	ch.Assert(len(c.Results), check.Equals, 3)
+
+	// The schedule is advanced to its next run
+	sched, err = GetCampaignSchedule(sched.Id, sched.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(sched.LastRunDate.Equal(t), check.Equals, true)
+	ch.Assert(sched.NextRunDate.Equal(t.AddDate(0, 1, 0)), check.Equals, true)
+	ss, err = GetDueCampaignSchedules(t)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ss), check.Equals, 0)
+
+	// The campaigns launched by the schedule can be listed
+	c2, err := sched.Launch(sched.NextRunDate)
+	ch.Assert(err, check.Equals, nil)
+	cs, total, err := GetFilteredCampaigns(sched.UserId, CampaignFilter{ScheduleId: sched.Id})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(total, check.Equals, int64(2))
+	ch.Assert(cs[0].Id, check.Equals, c.Id)
+	ch.Assert(cs[1].Id, check.Equals, c2.Id)
+	ch.Assert(c.Name == c2.Name, check.Equals, false)
+}
+
+func (s *ModelsSuite) TestCampaignSamplePercent(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	c.SamplePercent = -1
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, ErrInvalidSamplePercent)
+	// At least one target is sampled from each group
+	c.SamplePercent = 1
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+	ch.Assert(len(c.Results), check.Equals, 1)
+}
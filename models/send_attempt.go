@@ -0,0 +1,138 @@
+package models
+
+import (
+	"net"
+	"net/textproto"
+	"time"
+)
+
+// The categories used to describe why a send attempt failed
+const (
+	ATTEMPT_AUTH       string = "auth"
+	ATTEMPT_PERMANENT  string = "permanent"
+	ATTEMPT_TEMPORARY  string = "temporary"
+	ATTEMPT_CONNECTION string = "connection"
+	ATTEMPT_OTHER      string = "other"
+)
+
+// SendAttempt is a record of a single attempt to deliver a result's email,
+// kept so that the full delivery history for a target can be reviewed.
+type SendAttempt struct {
+	Id         int64     `json:"-"`
+	CampaignId int64     `json:"campaign_id"`
+	RId        string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Profile    string    `json:"profile"`
+	Success    bool      `json:"success"`
+	Code       int       `json:"code,omitempty"`
+	Category   string    `json:"category,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// categorizeSendError returns the SMTP response code, if any, and the
+// category of the given send error.
+func categorizeSendError(e error) (int, string) {
+	switch err := e.(type) {
+	case *textproto.Error:
+		switch err.Code {
+		case 454, 530, 534, 535:
+			return err.Code, ATTEMPT_AUTH
+		}
+		if err.Code >= 500 {
+			return err.Code, ATTEMPT_PERMANENT
+		}
+		return err.Code, ATTEMPT_TEMPORARY
+	case net.Error:
+		return 0, ATTEMPT_CONNECTION
+	}
+	return 0, ATTEMPT_OTHER
+}
+
+// recordSendAttempt stores the outcome of an attempt to send the maillog's
+// email. A nil error indicates the attempt succeeded.
+func (m *MailLog) recordSendAttempt(e error) error {
+	// Maillogs created before sending profiles were rotated are sent through
+	// the campaign's sending profile
+	sid := m.SMTPId
+	if sid == 0 {
+		c := Campaign{}
+		err := db.Where("id=?", m.CampaignId).First(&c).Error
+		if err != nil {
+			return err
+		}
+		sid = c.SMTPId
+	}
+	s := SMTP{}
+	err := db.Table("smtp").Where("id=?", sid).First(&s).Error
+	if err != nil {
+		return err
+	}
+	a := &SendAttempt{
+		CampaignId: m.CampaignId,
+		RId:        m.RId,
+		Time:       time.Now().UTC(),
+		Profile:    s.Name,
+		Success:    e == nil,
+	}
+	if e != nil {
+		a.Code, a.Category = categorizeSendError(e)
+		a.Error = e.Error()
+	}
+	return db.Save(a).Error
+}
+
+// SendAttempts returns every attempt made to send the result's email, ordered
+// from the earliest attempt to the latest.
+func (r *Result) SendAttempts() ([]SendAttempt, error) {
+	as := []SendAttempt{}
+	err := db.Where("campaign_id=? and r_id=?", r.CampaignId, r.RId).
+		Order("time asc, id asc").Find(&as).Error
+	return as, err
+}
+
+// RequeueByErrorCategory queues the email to be sent again to each result in
+// the campaign specified by the given id and user_id which failed to send,
+// and whose last send attempt failed with the given category of error. This
+// is useful to retry sending after fixing the cause, such as the sending
+// profile's credentials. It returns the number of results requeued.
+func RequeueByErrorCategory(cid int64, uid int64, category string) (int, error) {
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return 0, err
+	}
+	requeued := 0
+	for i := range rs {
+		r := &rs[i]
+		if r.Status != ERROR {
+			continue
+		}
+		as, err := r.SendAttempts()
+		if err != nil {
+			return requeued, err
+		}
+		if len(as) == 0 {
+			continue
+		}
+		last := as[len(as)-1]
+		if last.Success || last.Category != category {
+			continue
+		}
+		m := &MailLog{
+			UserId:     uid,
+			CampaignId: cid,
+			RId:        r.RId,
+			SendDate:   time.Now().UTC(),
+		}
+		err = db.Save(m).Error
+		if err != nil {
+			return requeued, err
+		}
+		r.Status = STATUS_SENDING
+		err = ResultStorage.Save(r)
+		if err != nil {
+			return requeued, err
+		}
+		requeued++
+	}
+	return requeued, nil
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestResultSendAttempts(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	result := campaign.Results[0]
+	m := &MailLog{}
+	err := db.Where("r_id=? AND campaign_id=?", result.RId, campaign.Id).
+		Find(m).Error
+	ch.Assert(err, check.Equals, nil)
+
+	as, err := result.SendAttempts()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(as), check.Equals, 0)
+
+	greylisted := &textproto.Error{Code: 421, Msg: "Try again later"}
+	ch.Assert(m.Backoff(greylisted), check.Equals, nil)
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	ch.Assert(m.Backoff(refused), check.Equals, nil)
+	ch.Assert(m.Success(), check.Equals, nil)
+
+	as, err = result.SendAttempts()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(as), check.Equals, 3)
+	for _, a := range as {
+		ch.Assert(a.RId, check.Equals, result.RId)
+		ch.Assert(a.Profile, check.Equals, campaign.SMTP.Name)
+	}
+	ch.Assert(as[0].Success, check.Equals, false)
+	ch.Assert(as[0].Code, check.Equals, 421)
+	ch.Assert(as[0].Category, check.Equals, ATTEMPT_TEMPORARY)
+	ch.Assert(as[0].Error, check.Equals, greylisted.Error())
+	ch.Assert(as[1].Success, check.Equals, false)
+	ch.Assert(as[1].Code, check.Equals, 0)
+	ch.Assert(as[1].Category, check.Equals, ATTEMPT_CONNECTION)
+	ch.Assert(as[2].Success, check.Equals, true)
+	ch.Assert(as[2].Category, check.Equals, "")
+	ch.Assert(as[2].Error, check.Equals, "")
+
+	// Permanent errors are recorded for other results independently
+	other := campaign.Results[1]
+	m = &MailLog{}
+	err = db.Where("r_id=? AND campaign_id=?", other.RId, campaign.Id).
+		Find(m).Error
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(m.Error(&textproto.Error{Code: 550, Msg: "No such user"}), check.Equals, nil)
+	as, err = other.SendAttempts()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(as), check.Equals, 1)
+	ch.Assert(as[0].Code, check.Equals, 550)
+	ch.Assert(as[0].Category, check.Equals, ATTEMPT_PERMANENT)
+}
+
+func (s *ModelsSuite) TestRequeueByErrorCategory(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(4))
+	fail := func(r Result, e error) {
+		m := &MailLog{}
+		err := db.Where("r_id=? AND campaign_id=?", r.RId, campaign.Id).Find(m).Error
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(m.Error(e), check.Equals, nil)
+	}
+	authFailure := &textproto.Error{Code: 535, Msg: "Authentication failed"}
+	rs := campaign.Results
+	fail(rs[0], authFailure)
+	fail(rs[1], authFailure)
+	fail(rs[2], &textproto.Error{Code: 550, Msg: "No such user"})
+	// The last result is still waiting to be sent
+
+	requeued, err := RequeueByErrorCategory(campaign.Id, campaign.UserId, ATTEMPT_AUTH)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(requeued, check.Equals, 2)
+
+	ms, err := GetMailLogsByCampaign(campaign.Id)
+	ch.Assert(err, check.Equals, nil)
+	queued := make(map[string]bool)
+	for _, m := range ms {
+		queued[m.RId] = true
+	}
+	ch.Assert(len(queued), check.Equals, 3)
+	ch.Assert(queued[rs[0].RId], check.Equals, true)
+	ch.Assert(queued[rs[1].RId], check.Equals, true)
+	ch.Assert(queued[rs[2].RId], check.Equals, false)
+	ch.Assert(queued[rs[3].RId], check.Equals, true)
+	for i, expected := range []string{STATUS_SENDING, STATUS_SENDING, ERROR} {
+		r, err := GetResult(rs[i].RId)
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(r.Status, check.Equals, expected)
+	}
+
+	// Requeued results aren't requeued again while they're waiting
+	requeued, err = RequeueByErrorCategory(campaign.Id, campaign.UserId, ATTEMPT_AUTH)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(requeued, check.Equals, 0)
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+)
+
+// ErrInvalidSendWindow is thrown when a campaign's send window can't be
+// parsed, or doesn't end after it starts
+var ErrInvalidSendWindow = errors.New("Invalid send window")
+
+// TimezoneAttribute is the name of the target attribute holding the target's
+// IANA timezone, such as "America/Chicago". Emails to targets with the
+// attribute are sent within the campaign's send window in their timezone.
+var TimezoneAttribute = "timezone"
+
+// weekdays maps the day names accepted in a send window to their weekday
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// sendWindow is the parsed form of a campaign's send window. The start and
+// end are the number of minutes after midnight.
+type sendWindow struct {
+	start int
+	end   int
+	days  map[time.Weekday]bool
+	loc   *time.Location
+}
+
+// parseClock returns the number of minutes after midnight of the given
+// 24-hour time, such as "17:30".
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, ErrInvalidSendWindow
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// sendWindow parses the campaign's send window. If the campaign doesn't have
+// one, nil is returned.
+func (c *Campaign) sendWindow() (*sendWindow, error) {
+	if c.SendWindowStart == "" && c.SendWindowEnd == "" {
+		return nil, nil
+	}
+	w := &sendWindow{loc: time.UTC}
+	var err error
+	w.start, err = parseClock(c.SendWindowStart)
+	if err != nil {
+		return nil, err
+	}
+	w.end, err = parseClock(c.SendWindowEnd)
+	if err != nil {
+		return nil, err
+	}
+	if w.end <= w.start {
+		return nil, ErrInvalidSendWindow
+	}
+	if c.SendWindowDays != "" {
+		w.days = make(map[time.Weekday]bool)
+		for _, d := range strings.Split(c.SendWindowDays, ",") {
+			day, ok := weekdays[strings.ToLower(strings.TrimSpace(d))]
+			if !ok {
+				return nil, ErrInvalidSendWindow
+			}
+			w.days[day] = true
+		}
+	}
+	if c.SendWindowTimezone != "" {
+		w.loc, err = time.LoadLocation(c.SendWindowTimezone)
+		if err != nil {
+			return nil, ErrInvalidSendWindow
+		}
+	}
+	return w, nil
+}
+
+// location returns the timezone the window applies in for the given result.
+// The result's timezone attribute is preferred, falling back to the
+// campaign's timezone.
+func (w *sendWindow) location(r *Result) *time.Location {
+	tz := r.Variables[TimezoneAttribute]
+	if tz == "" {
+		return w.loc
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Warnf("invalid timezone %q for %s: %s", tz, r.Email, err)
+		return w.loc
+	}
+	return loc
+}
+
+// next returns the earliest time at or after t which falls within the window
+// in the given timezone.
+func (w *sendWindow) next(t time.Time, loc *time.Location) time.Time {
+	lt := t.In(loc)
+	for i := 0; i < 8; i++ {
+		d := lt.AddDate(0, 0, i)
+		if w.days != nil && !w.days[d.Weekday()] {
+			continue
+		}
+		start := time.Date(d.Year(), d.Month(), d.Day(), w.start/60, w.start%60, 0, 0, loc)
+		end := time.Date(d.Year(), d.Month(), d.Day(), w.end/60, w.end%60, 0, 0, loc)
+		if i > 0 || lt.Before(start) {
+			return start.UTC()
+		}
+		if lt.Before(end) {
+			return t.UTC()
+		}
+	}
+	// The window has no days, which validation prevents
+	return t.UTC()
+}
+
+// scheduleSend returns the earliest time at or after t that the email to the
+// given result can be sent within the campaign's send window. If the campaign
+// doesn't have a send window, t is returned.
+func (c *Campaign) scheduleSend(r *Result, t time.Time) (time.Time, error) {
+	w, err := c.sendWindow()
+	if err != nil || w == nil {
+		return t, err
+	}
+	return w.next(t, w.location(r)), nil
+}
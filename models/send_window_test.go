@@ -0,0 +1,102 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestSendWindowNext(ch *check.C) {
+	c := Campaign{SendWindowStart: "09:00", SendWindowEnd: "17:00", SendWindowDays: "Mon, tue,WED,thu,fri"}
+	w, err := c.sendWindow()
+	ch.Assert(err, check.Equals, nil)
+	chicago, err := time.LoadLocation("America/Chicago")
+	ch.Assert(err, check.Equals, nil)
+	tests := []struct {
+		at       time.Time
+		expected time.Time
+	}{
+		// Within the window
+		{time.Date(2018, 7, 9, 10, 30, 0, 0, chicago), time.Date(2018, 7, 9, 10, 30, 0, 0, chicago)},
+		// Before the window opens
+		{time.Date(2018, 7, 9, 6, 0, 0, 0, chicago), time.Date(2018, 7, 9, 9, 0, 0, 0, chicago)},
+		// After the window closes
+		{time.Date(2018, 7, 9, 17, 0, 0, 0, chicago), time.Date(2018, 7, 10, 9, 0, 0, 0, chicago)},
+		// Over the weekend
+		{time.Date(2018, 7, 6, 18, 0, 0, 0, chicago), time.Date(2018, 7, 9, 9, 0, 0, 0, chicago)},
+		{time.Date(2018, 7, 8, 12, 0, 0, 0, chicago), time.Date(2018, 7, 9, 9, 0, 0, 0, chicago)},
+	}
+	for _, t := range tests {
+		got := w.next(t.at, chicago)
+		ch.Assert(got.Equal(t.expected), check.Equals, true, check.Commentf("%s: got %s", t.at, got))
+		ch.Assert(got.Location(), check.Equals, time.UTC)
+	}
+
+	// No send window means the email can be sent whenever
+	w, err = (&Campaign{}).sendWindow()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(w, check.IsNil)
+
+	invalid := []Campaign{
+		{SendWindowStart: "09:00"},
+		{SendWindowStart: "9am", SendWindowEnd: "17:00"},
+		{SendWindowStart: "17:00", SendWindowEnd: "09:00"},
+		{SendWindowStart: "09:00", SendWindowEnd: "17:00", SendWindowDays: "mon,funday"},
+		{SendWindowStart: "09:00", SendWindowEnd: "17:00", SendWindowTimezone: "Mars/Olympus_Mons"},
+	}
+	for _, c := range invalid {
+		_, err := c.sendWindow()
+		ch.Assert(err, check.Equals, ErrInvalidSendWindow)
+	}
+}
+
+func (s *ModelsSuite) TestPostCampaignSendWindow(ch *check.C) {
+	defer func(interval, jitter time.Duration) { SendInterval, SendJitter = interval, jitter }(SendInterval, SendJitter)
+	SendInterval = 10 * time.Minute
+	SendJitter = 0
+	c := s.createCampaignDependencies(ch)
+	g := c.Groups[0]
+	g.Targets = generateTargets(3)
+	g.Targets[2].Attributes = map[string]string{TimezoneAttribute: "Asia/Tokyo"}
+	ch.Assert(PutGroup(&g), check.Equals, nil)
+	c.Groups = []Group{g}
+	c.SendWindowStart = "09:00"
+	c.SendWindowEnd = "17:00"
+	c.SendWindowDays = "mon,tue,wed,thu,fri"
+	c.SendWindowTimezone = "America/Chicago"
+
+	// Invalid send windows are rejected
+	c.SendWindowEnd = "5pm"
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, ErrInvalidSendWindow)
+	c.SendWindowEnd = "17:00"
+
+	// Launched on a Saturday evening, the emails wait until Monday morning
+	// in each target's timezone
+	c.LaunchDate = time.Date(2030, 7, 6, 20, 0, 0, 0, time.UTC)
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+	ch.Assert(len(c.Results), check.Equals, 3)
+	expected := []time.Time{
+		time.Date(2030, 7, 8, 14, 0, 0, 0, time.UTC),
+		time.Date(2030, 7, 8, 14, 10, 0, 0, time.UTC),
+		time.Date(2030, 7, 8, 0, 0, 0, 0, time.UTC),
+	}
+	for i, r := range c.Results {
+		ch.Assert(r.SendDate.Equal(expected[i]), check.Equals, true, check.Commentf("%s: got %s", r.Email, r.SendDate))
+		m := MailLog{}
+		ch.Assert(db.Where("r_id=?", r.RId).Find(&m).Error, check.Equals, nil)
+		ch.Assert(m.SendDate.Equal(expected[i]), check.Equals, true)
+	}
+
+	// Retries are kept within the window too
+	defer func(base time.Duration) { RetryBackoffBase = base }(RetryBackoffBase)
+	RetryBackoffBase = 24 * time.Hour
+	m := MailLog{}
+	ch.Assert(db.Where("r_id=?", c.Results[0].RId).Find(&m).Error, check.Equals, nil)
+	ch.Assert(m.Backoff(errors.New("Temporary failure")), check.Equals, nil)
+	chicago, err := time.LoadLocation("America/Chicago")
+	ch.Assert(err, check.Equals, nil)
+	local := m.SendDate.In(chicago)
+	ch.Assert(local.Hour() >= 9 && local.Hour() < 17, check.Equals, true, check.Commentf("got %s", local))
+	ch.Assert(local.Weekday() != time.Saturday && local.Weekday() != time.Sunday, check.Equals, true)
+}
@@ -0,0 +1,281 @@
+package models
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	"github.com/jinzhu/gorm"
+)
+
+// The types of URL shortener which can be configured
+const (
+	SHORTENER_BUILTIN string = "builtin"
+	SHORTENER_API     string = "api"
+)
+
+// ShortCodeLength is the number of characters in the codes identifying the
+// links served by the built-in shortener
+const ShortCodeLength = 8
+
+// ShortCodeAlphabet is the set of characters short codes are chosen from
+var ShortCodeAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// ShortenerTimeout is the maximum amount of time to wait for a shortener API
+// to respond.
+var ShortenerTimeout = 5 * time.Second
+
+// DefaultShortenerResponseField is the field of a shortener API's response
+// which contains the short link, if no other field is configured.
+const DefaultShortenerResponseField = "short_url"
+
+// URLShortener shortens the phishing URLs of campaigns which shorten their
+// links. URLs can't be shortened if it's nil, which is the default.
+var URLShortener Shortener
+
+// ErrInvalidShortenerType is thrown when the configured URL shortener isn't
+// "builtin" or "api"
+var ErrInvalidShortenerType = errors.New("URL shortener type must be builtin or api")
+
+// ErrInvalidShortenerURL is thrown when the base URL of the built-in
+// shortener or the URL of a shortener API isn't an absolute http(s) URL
+var ErrInvalidShortenerURL = errors.New("URL shortener URLs must be absolute http or https URLs")
+
+// ErrShortenerNotConfigured is thrown when a campaign shortens its links but
+// no URL shortener is configured
+var ErrShortenerNotConfigured = errors.New("No URL shortener is configured")
+
+// ErrInvalidShortURL is thrown when a shortener API doesn't respond with a
+// short link
+var ErrInvalidShortURL = errors.New("URL shortener didn't return a valid URL")
+
+// ShortURL is the short link a recipient was sent in place of one of their
+// phishing URLs. The target is the tagged phishing URL, including the
+// recipient's ID, so following the short link still records the click
+// against the right result. Links made by the built-in shortener are looked
+// up by their code, which is empty for links made by a shortener API.
+type ShortURL struct {
+	Id          int64     `json:"-"`
+	CampaignId  int64     `json:"-"`
+	RId         string    `json:"-"`
+	Code        string    `json:"-"`
+	URL         string    `json:"url"`
+	Target      string    `json:"target"`
+	CreatedDate time.Time `json:"created_date"`
+}
+
+// Shortener makes the short link for a phishing URL, filling in the
+// ShortURL's URL, and its code if the link is looked up by the phishing
+// server.
+type Shortener interface {
+	Shorten(su *ShortURL) error
+}
+
+// BuiltinShortener serves short links from its own domain, which is pointed
+// at the phishing server, redirecting each link to its target.
+type BuiltinShortener struct {
+	BaseURL *url.URL
+}
+
+// APIShortener shortens links through an external shortener API. The URL is
+// POSTed to the API as {"url": "..."}, and the short link is read from the
+// given field of the response.
+type APIShortener struct {
+	URL           string
+	APIKey        string
+	ResponseField string
+}
+
+// parseShortenerURL parses the URL, checking that it's an absolute http(s)
+// URL
+func parseShortenerURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, ErrInvalidShortenerURL
+	}
+	return u, nil
+}
+
+// configureShortener sets up the URL shortener from the given configuration
+func configureShortener(conf config.URLShortener) error {
+	switch conf.Type {
+	case "":
+		URLShortener = nil
+	case SHORTENER_BUILTIN:
+		u, err := parseShortenerURL(conf.BaseURL)
+		if err != nil {
+			return err
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		URLShortener = &BuiltinShortener{BaseURL: u}
+	case SHORTENER_API:
+		_, err := parseShortenerURL(conf.APIURL)
+		if err != nil {
+			return err
+		}
+		field := conf.ResponseField
+		if field == "" {
+			field = DefaultShortenerResponseField
+		}
+		URLShortener = &APIShortener{URL: conf.APIURL, APIKey: conf.APIKey, ResponseField: field}
+	default:
+		return ErrInvalidShortenerType
+	}
+	return nil
+}
+
+// shortCode returns a random short code
+func shortCode() (string, error) {
+	k := make([]byte, ShortCodeLength)
+	for i := range k {
+		idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(ShortCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		k[i] = ShortCodeAlphabet[idx.Int64()]
+	}
+	return string(k), nil
+}
+
+// Shorten gives the link an unused code, served from the shortener's base
+// URL
+func (s *BuiltinShortener) Shorten(su *ShortURL) error {
+	for {
+		code, err := shortCode()
+		if err != nil {
+			return err
+		}
+		_, err = GetShortURL(code)
+		if err == gorm.ErrRecordNotFound {
+			su.Code = code
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	u := *s.BaseURL
+	u.Path = u.Path + "/" + su.Code
+	su.URL = u.String()
+	return nil
+}
+
+// Code returns the short code requested from the given host and path, if the
+// request was made for one of the shortener's links.
+func (s *BuiltinShortener) Code(host string, path string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if !strings.EqualFold(host, s.BaseURL.Hostname()) {
+		return "", false
+	}
+	code := strings.TrimPrefix(path, s.BaseURL.Path+"/")
+	if code == path || code == "" || strings.Contains(code, "/") {
+		return "", false
+	}
+	return code, true
+}
+
+// Shorten sends the link's target to the shortener API
+func (s *APIShortener) Shorten(su *ShortURL) error {
+	body, err := json.Marshal(map[string]string{"url": su.Target})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	}
+	client := &http.Client{Timeout: ShortenerTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status from URL shortener: %s", resp.Status)
+	}
+	data := map[string]interface{}{}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return err
+	}
+	short, _ := data[s.ResponseField].(string)
+	if _, err := parseShortenerURL(short); err != nil {
+		return ErrInvalidShortURL
+	}
+	su.URL = short
+	return nil
+}
+
+// GetShortURL returns the built-in shortener's link with the given code
+func GetShortURL(code string) (ShortURL, error) {
+	su := ShortURL{}
+	if code == "" {
+		return su, gorm.ErrRecordNotFound
+	}
+	err := db.Where("code=?", code).First(&su).Error
+	return su, err
+}
+
+// shortenURL returns the short link for the result's phishing URL, making
+// it the first time the URL is shortened. The same link is returned each
+// time the email is rendered, such as when it's retried.
+func shortenURL(cid int64, rid string, target string) (string, error) {
+	su := ShortURL{}
+	err := db.Where("r_id=? and target=?", rid, target).First(&su).Error
+	if err == nil {
+		return su.URL, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return "", err
+	}
+	su = ShortURL{CampaignId: cid, RId: rid, Target: target, CreatedDate: time.Now().UTC()}
+	err = URLShortener.Shorten(&su)
+	if err != nil {
+		return "", err
+	}
+	err = db.Save(&su).Error
+	return su.URL, err
+}
+
+// shortLinks shortens the phishing URLs in a result's email, recording the
+// first URL which couldn't be shortened so that the email isn't sent with a
+// broken link.
+type shortLinks struct {
+	campaignId int64
+	rid        string
+	err        error
+}
+
+// shorten returns the short link for the URL
+func (s *shortLinks) shorten(u string) (string, error) {
+	short, err := shortenURL(s.campaignId, s.rid, u)
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	return short, err
+}
+
+// shortLinks returns the shortener for the result's phishing URLs, or nil if
+// the campaign doesn't shorten its links. Synthetic results, such as those
+// used for test emails, aren't shortened.
+func (c *Campaign) shortLinks(r *Result) *shortLinks {
+	if !c.ShortenURLs || URLShortener == nil || c.Id == 0 || r.RId == "" {
+		return nil
+	}
+	return &shortLinks{campaignId: c.Id, rid: r.RId}
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/gophish/gomail"
+	"github.com/gophish/gophish/config"
+	"github.com/jordan-wright/email"
+	"gopkg.in/check.v1"
+)
+
+// createShortenedCampaign creates a campaign which shortens its links, with
+// a text template linking to both untagged and tagged phishing URLs
+func (s *ModelsSuite) createShortenedCampaign(ch *check.C) Campaign {
+	c := s.createCampaign(ch)
+	ch.Assert(db.Model(&Campaign{}).Where("id=?", c.Id).UpdateColumn("shorten_urls", true).Error, check.Equals, nil)
+	ch.Assert(db.Model(&Template{}).Where("id=?", c.TemplateId).
+		UpdateColumn("text", `{{.URL}} {{.URL "invoice"}}`).Error, check.Equals, nil)
+	return c
+}
+
+// generateText returns the text of the email generated for the result
+func generateText(ch *check.C, c Campaign, r Result) (string, error) {
+	m := &MailLog{}
+	ch.Assert(db.Where("r_id=? AND campaign_id=?", r.RId, c.Id).Find(m).Error, check.Equals, nil)
+	msg := gomail.NewMessage()
+	err := m.Generate(msg)
+	if err != nil {
+		return "", err
+	}
+	b := &bytes.Buffer{}
+	_, err = msg.WriteTo(b)
+	ch.Assert(err, check.Equals, nil)
+	got, err := email.NewEmailFromReader(b)
+	ch.Assert(err, check.Equals, nil)
+	return string(got.Text), nil
+}
+
+func (s *ModelsSuite) TestConfigureShortener(ch *check.C) {
+	defer func() { URLShortener = nil }()
+	ch.Assert(configureShortener(config.URLShortener{}), check.Equals, nil)
+	ch.Assert(URLShortener, check.IsNil)
+
+	ch.Assert(configureShortener(config.URLShortener{Type: "bitly"}), check.Equals, ErrInvalidShortenerType)
+	ch.Assert(configureShortener(config.URLShortener{Type: SHORTENER_BUILTIN, BaseURL: "go.example.com"}),
+		check.Equals, ErrInvalidShortenerURL)
+	ch.Assert(configureShortener(config.URLShortener{Type: SHORTENER_API, APIURL: "ftp://example.com"}),
+		check.Equals, ErrInvalidShortenerURL)
+
+	ch.Assert(configureShortener(config.URLShortener{Type: SHORTENER_BUILTIN, BaseURL: "https://go.example.com/s/"}),
+		check.Equals, nil)
+	b, ok := URLShortener.(*BuiltinShortener)
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(b.BaseURL.String(), check.Equals, "https://go.example.com/s")
+
+	ch.Assert(configureShortener(config.URLShortener{Type: SHORTENER_API, APIURL: "https://api.example.com/shorten"}),
+		check.Equals, nil)
+	a, ok := URLShortener.(*APIShortener)
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(a.ResponseField, check.Equals, DefaultShortenerResponseField)
+}
+
+func (s *ModelsSuite) TestBuiltinShortenerCode(ch *check.C) {
+	u, _ := url.Parse("https://go.example.com/s")
+	b := &BuiltinShortener{BaseURL: u}
+	code, ok := b.Code("GO.example.com:443", "/s/abc123")
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(code, check.Equals, "abc123")
+	for _, r := range [][2]string{
+		{"phish.example.com", "/s/abc123"},
+		{"go.example.com", "/abc123"},
+		{"go.example.com", "/s/"},
+		{"go.example.com", "/s/abc/123"},
+	} {
+		_, ok = b.Code(r[0], r[1])
+		ch.Assert(ok, check.Equals, false)
+	}
+}
+
+func (s *ModelsSuite) TestCampaignValidateShortenURLs(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	c.ShortenURLs = true
+	ch.Assert(c.Validate(), check.Equals, ErrShortenerNotConfigured)
+	defer func() { URLShortener = nil }()
+	u, _ := url.Parse("https://go.example.com")
+	URLShortener = &BuiltinShortener{BaseURL: u}
+	ch.Assert(c.Validate(), check.Equals, nil)
+}
+
+func (s *ModelsSuite) TestBuiltinShortenerGenerate(ch *check.C) {
+	defer func() { URLShortener = nil }()
+	u, _ := url.Parse("https://go.example.com")
+	URLShortener = &BuiltinShortener{BaseURL: u}
+	c := s.createShortenedCampaign(ch)
+	r := c.Results[0]
+
+	text, err := generateText(ch, c, r)
+	ch.Assert(err, check.Equals, nil)
+	links := strings.Fields(text)
+	ch.Assert(len(links), check.Equals, 2)
+	ch.Assert(strings.Contains(text, "rid="), check.Equals, false)
+
+	// Each short link redirects to the tagged phishing URL it replaced
+	targets := []string{}
+	for _, l := range links {
+		ch.Assert(strings.HasPrefix(l, "https://go.example.com/"), check.Equals, true)
+		su, err := GetShortURL(strings.TrimPrefix(l, "https://go.example.com/"))
+		ch.Assert(err, check.Equals, nil)
+		ch.Assert(su.RId, check.Equals, r.RId)
+		ch.Assert(su.CampaignId, check.Equals, c.Id)
+		targets = append(targets, su.Target)
+	}
+	ch.Assert(strings.Contains(targets[0], "rid="+r.RId), check.Equals, true)
+	ch.Assert(strings.Contains(targets[0], LinkParameter+"="), check.Equals, false)
+	ch.Assert(strings.Contains(targets[1], LinkParameter+"=invoice"), check.Equals, true)
+
+	// The same links are sent each time the email is generated
+	again, err := generateText(ch, c, r)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(again, check.Equals, text)
+
+	// Deleting the campaign deletes its short links
+	ch.Assert(DeleteCampaign(c.Id), check.Equals, nil)
+	_, err = GetShortURL(strings.TrimPrefix(links[0], "https://go.example.com/"))
+	ch.Assert(err, check.NotNil)
+}
+
+func (s *ModelsSuite) TestAPIShortenerGenerate(ch *check.C) {
+	fail := false
+	requested := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ch.Assert(r.Header.Get("Authorization"), check.Equals, "Bearer secret")
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body := map[string]string{}
+		ch.Assert(json.NewDecoder(r.Body).Decode(&body), check.Equals, nil)
+		requested = append(requested, body["url"])
+		fmt.Fprintf(w, `{"link": "https://sho.rt/%d"}`, len(requested))
+	}))
+	defer ts.Close()
+	defer func() { URLShortener = nil }()
+	URLShortener = &APIShortener{URL: ts.URL, APIKey: "secret", ResponseField: "link"}
+	c := s.createShortenedCampaign(ch)
+
+	text, err := generateText(ch, c, c.Results[0])
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(text, check.Equals, "https://sho.rt/1 https://sho.rt/2")
+	ch.Assert(len(requested), check.Equals, 2)
+	ch.Assert(strings.Contains(requested[1], "rid="+c.Results[0].RId), check.Equals, true)
+
+	// Emails whose links can't be shortened aren't sent
+	fail = true
+	_, err = generateText(ch, c, c.Results[1])
+	ch.Assert(err, check.NotNil)
+}
@@ -0,0 +1,205 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+)
+
+// SMS contains the attributes needed to send campaign messages as text
+// messages through an HTTP gateway, such as Twilio's Messages API. Messages
+// are POSTed to the gateway URL as a form with the To, From and Body fields,
+// using HTTP basic authentication if a username is given.
+type SMS struct {
+	Id           int64     `json:"id" gorm:"column:id; primary_key:yes"`
+	UserId       int64     `json:"-" gorm:"column:user_id"`
+	Name         string    `json:"name"`
+	GatewayURL   string    `json:"gateway_url"`
+	Username     string    `json:"username,omitempty"`
+	Password     string    `json:"password,omitempty"`
+	FromNumber   string    `json:"from_number"`
+	ModifiedDate time.Time `json:"modified_date"`
+}
+
+// SMSTimeout is how long to wait for the SMS gateway to accept a message
+var SMSTimeout = 10 * time.Second
+
+// ErrGatewayURLNotSpecified is thrown when there is no gateway URL specified
+// in the SMS configuration
+var ErrGatewayURLNotSpecified = errors.New("No SMS gateway URL specified")
+
+// ErrInvalidGatewayURL is thrown when the SMS gateway URL isn't an absolute
+// HTTP or HTTPS URL
+var ErrInvalidGatewayURL = errors.New("Invalid SMS gateway URL")
+
+// ErrFromNumberNotSpecified is thrown when there is no "From" number
+// specified in the SMS configuration
+var ErrFromNumberNotSpecified = errors.New("No From number specified")
+
+// ErrInvalidPhoneNumber is thrown when a phone number isn't in the
+// international E.164 format, such as +15555550100
+var ErrInvalidPhoneNumber = errors.New("Invalid phone number")
+
+// ErrSMSNotFound is thrown when a campaign's SMS profile doesn't exist
+var ErrSMSNotFound = errors.New("SMS profile not found")
+
+// e164Regex matches phone numbers in the international E.164 format
+var e164Regex = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// phoneSeparators are the characters commonly used to format phone numbers,
+// which are removed when normalizing them
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "")
+
+// normalizePhone removes the formatting from the given phone number, so that
+// "+1 (555) 555-0100" becomes "+15555550100".
+func normalizePhone(phone string) string {
+	return phoneSeparators.Replace(strings.TrimSpace(phone))
+}
+
+// validatePhone checks that the given phone number is in the E.164 format
+// once its formatting is removed.
+func validatePhone(phone string) error {
+	if !e164Regex.MatchString(normalizePhone(phone)) {
+		return ErrInvalidPhoneNumber
+	}
+	return nil
+}
+
+// TableName specifies the database tablename for Gorm to use
+func (s SMS) TableName() string {
+	return "sms"
+}
+
+// Validate ensures that the SMS profile is valid
+func (s *SMS) Validate() error {
+	switch {
+	case s.GatewayURL == "":
+		return ErrGatewayURLNotSpecified
+	case s.FromNumber == "":
+		return ErrFromNumberNotSpecified
+	}
+	u, err := url.Parse(s.GatewayURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidGatewayURL
+	}
+	return validatePhone(s.FromNumber)
+}
+
+// SMSGatewayError is returned when the SMS gateway doesn't accept a message
+type SMSGatewayError struct {
+	StatusCode int
+}
+
+// Error returns the response status from the gateway
+func (e *SMSGatewayError) Error() string {
+	return fmt.Sprintf("SMS gateway returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Temporary returns whether or not the message may be accepted if it's sent
+// again later, such as when the gateway is rate limiting requests.
+func (e *SMSGatewayError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Send sends the given message body to the given phone number through the
+// SMS gateway.
+func (s *SMS) Send(to string, body string) error {
+	form := url.Values{}
+	form.Set("To", normalizePhone(to))
+	form.Set("From", normalizePhone(s.FromNumber))
+	form.Set("Body", body)
+	req, err := http.NewRequest("POST", s.GatewayURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if s.Username != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	client := &http.Client{Timeout: SMSTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &SMSGatewayError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// GetSMSProfiles returns the SMS profiles owned by the given user.
+func GetSMSProfiles(uid int64) ([]SMS, error) {
+	ss := []SMS{}
+	err := db.Where("user_id in (?)", teamUserIds(uid)).Find(&ss).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return ss, err
+}
+
+// GetSMSProfile returns the SMS profile, if it exists, specified by the given
+// id and user_id.
+func GetSMSProfile(id int64, uid int64) (SMS, error) {
+	s := SMS{}
+	err := db.Where("user_id in (?) and id=?", teamUserIds(uid), id).Find(&s).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return s, err
+}
+
+// GetSMSProfileByName returns the SMS profile, if it exists, specified by the
+// given name and user_id.
+func GetSMSProfileByName(n string, uid int64) (SMS, error) {
+	s := SMS{}
+	err := db.Where("user_id in (?) and name=?", teamUserIds(uid), n).Find(&s).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return s, err
+}
+
+// PostSMSProfile creates a new SMS profile in the database.
+func PostSMSProfile(s *SMS) error {
+	err := s.Validate()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Save(s).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// PutSMSProfile edits an existing SMS profile in the database.
+// Per the PUT Method RFC, it presumes all data for a SMS profile is provided.
+func PutSMSProfile(s *SMS) error {
+	err := s.Validate()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Where("id=?", s.Id).Save(s).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// DeleteSMSProfile deletes an existing SMS profile in the database.
+func DeleteSMSProfile(id int64, uid int64) error {
+	err := db.Where("user_id in (?)", teamUserIds(uid)).Delete(SMS{Id: id}).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"gopkg.in/check.v1"
+)
+
+// smsGateway is a fake SMS gateway which records the messages it receives
+type smsGateway struct {
+	server   *httptest.Server
+	status   int
+	messages []url.Values
+	username string
+	password string
+}
+
+func newSMSGateway() *smsGateway {
+	g := &smsGateway{status: http.StatusCreated}
+	g.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		g.messages = append(g.messages, r.PostForm)
+		g.username, g.password, _ = r.BasicAuth()
+		w.WriteHeader(g.status)
+	}))
+	return g
+}
+
+func (s *ModelsSuite) createSMSCampaign(ch *check.C, gatewayURL string) Campaign {
+	c := s.createCampaignDependencies(ch)
+	group := Group{Name: "SMS Group", UserId: 1}
+	group.Targets = []Target{
+		Target{Email: "test1@example.com", FirstName: "First", LastName: "Example", Phone: "+1 (555) 555-0101"},
+		Target{Email: "test2@example.com", FirstName: "Second", LastName: "Example", Phone: "+15555550102"},
+	}
+	ch.Assert(PostGroup(&group), check.Equals, nil)
+	sms := SMS{Name: "Test SMS", UserId: 1, GatewayURL: gatewayURL, FromNumber: "+15555550100"}
+	sms.Username = "user"
+	sms.Password = "secret"
+	ch.Assert(PostSMSProfile(&sms), check.Equals, nil)
+	c.Groups = []Group{group}
+	c.SMTP = SMTP{}
+	c.SMS = sms
+	c.URL = "http://phish.example.com"
+	c.Template.Text = "Hi {{.FirstName}}, visit {{.URL}}"
+	ch.Assert(PutTemplate(&c.Template), check.Equals, nil)
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+	return c
+}
+
+func (s *ModelsSuite) TestSMSValidate(ch *check.C) {
+	sms := SMS{GatewayURL: "https://api.example.com/messages", FromNumber: "+1 555-555-0100"}
+	ch.Assert(sms.Validate(), check.Equals, nil)
+
+	tests := []struct {
+		sms      SMS
+		expected error
+	}{
+		{SMS{FromNumber: "+15555550100"}, ErrGatewayURLNotSpecified},
+		{SMS{GatewayURL: "https://api.example.com/messages"}, ErrFromNumberNotSpecified},
+		{SMS{GatewayURL: "api.example.com/messages", FromNumber: "+15555550100"}, ErrInvalidGatewayURL},
+		{SMS{GatewayURL: "ftp://api.example.com", FromNumber: "+15555550100"}, ErrInvalidGatewayURL},
+		{SMS{GatewayURL: "https://api.example.com/messages", FromNumber: "5555550100"}, ErrInvalidPhoneNumber},
+	}
+	for _, t := range tests {
+		ch.Assert(t.sms.Validate(), check.Equals, t.expected)
+	}
+}
+
+func (s *ModelsSuite) TestSMSSend(ch *check.C) {
+	g := newSMSGateway()
+	defer g.server.Close()
+	sms := SMS{GatewayURL: g.server.URL, FromNumber: "+15555550100", Username: "user", Password: "secret"}
+	ch.Assert(sms.Send("+1 (555) 555-0101", "Hello"), check.Equals, nil)
+	ch.Assert(len(g.messages), check.Equals, 1)
+	ch.Assert(g.messages[0].Get("To"), check.Equals, "+15555550101")
+	ch.Assert(g.messages[0].Get("From"), check.Equals, "+15555550100")
+	ch.Assert(g.messages[0].Get("Body"), check.Equals, "Hello")
+	ch.Assert(g.username, check.Equals, "user")
+	ch.Assert(g.password, check.Equals, "secret")
+
+	g.status = http.StatusTooManyRequests
+	err := sms.Send("+15555550101", "Hello")
+	ge, ok := err.(*SMSGatewayError)
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(ge.Temporary(), check.Equals, true)
+
+	g.status = http.StatusBadRequest
+	err = sms.Send("+15555550101", "Hello")
+	ge, ok = err.(*SMSGatewayError)
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(ge.Temporary(), check.Equals, false)
+}
+
+func (s *ModelsSuite) TestPostSMSCampaign(ch *check.C) {
+	c := s.createSMSCampaign(ch, "https://api.example.com/messages")
+	ch.Assert(c.SMSId, check.Not(check.Equals), int64(0))
+	ch.Assert(c.SMTPId, check.Equals, int64(0))
+	ch.Assert(c.Results[0].Phone, check.Equals, "+15555550101")
+
+	got, err := GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.SMS.Name, check.Equals, "Test SMS")
+
+	// Every target needs a valid phone number
+	c = s.createCampaignDependencies(ch)
+	c.SMS = SMS{Name: "Test SMS"}
+	err = PostCampaign(&c, c.UserId)
+	ch.Assert(err, check.ErrorMatches, "Invalid phone number: .*")
+
+	// The SMS profile has to exist
+	c.SMS = SMS{Name: "Missing SMS"}
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, ErrSMSNotFound)
+}
+
+func (s *ModelsSuite) TestMailLogSendSMS(ch *check.C) {
+	g := newSMSGateway()
+	defer g.server.Close()
+	c := s.createSMSCampaign(ch, g.server.URL)
+	ms, err := GetMailLogsByCampaign(c.Id)
+	ch.Assert(err, check.Equals, nil)
+
+	ch.Assert(ms[0].SendSMS(), check.Equals, nil)
+	ch.Assert(len(g.messages), check.Equals, 1)
+	r, err := GetResult(ms[0].RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(r.Status, check.Equals, EVENT_SENT)
+	ch.Assert(g.messages[0].Get("To"), check.Equals, r.Phone)
+	ch.Assert(g.messages[0].Get("Body"), check.Equals, "Hi First, visit http://phish.example.com?rid="+r.RId)
+
+	// Temporary gateway errors are retried later
+	g.status = http.StatusInternalServerError
+	ch.Assert(ms[1].SendSMS(), check.Equals, nil)
+	r, err = GetResult(ms[1].RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(r.Status, check.Equals, STATUS_RETRY)
+	m, err := GetMailLogsByCampaign(c.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(m), check.Equals, 1)
+	ch.Assert(m[0].SendAttempt, check.Equals, 1)
+}
@@ -1,8 +1,10 @@
 package models
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net/mail"
 	"os"
 	"strconv"
@@ -21,6 +23,7 @@ import (
 // between mailer and gomail.
 type Dialer struct {
 	*gomail.Dialer
+	pool mailer.PoolSettings
 }
 
 // Dial wraps the gomail dialer's Dial command
@@ -28,19 +31,93 @@ func (d *Dialer) Dial() (mailer.Sender, error) {
 	return d.Dialer.Dial()
 }
 
-// SMTP contains the attributes needed to handle the sending of campaign emails
+// PoolKey identifies the server and credentials the dialer connects with, so
+// that the mailer only reuses connections made with the same settings
+func (d *Dialer) PoolKey() string {
+	password := sha256.Sum256([]byte(d.Password))
+	return fmt.Sprintf("%s:%d|%s|%x|%t|%s", d.Host, d.Port, d.Username, password,
+		d.TLSConfig.InsecureSkipVerify, d.LocalName)
+}
+
+// PoolSettings returns the sending profile's limits on reusing connections
+func (d *Dialer) PoolSettings() mailer.PoolSettings {
+	return d.pool
+}
+
+// DKIMDialer is a Dialer for a sending profile with DKIM configured, which
+// has the mailer sign each message before it's sent.
+type DKIMDialer struct {
+	mailer.Dialer
+	signer *mailer.DKIMSigner
+}
+
+// Sign adds a DKIM signature to the message
+func (d *DKIMDialer) Sign(msg []byte) ([]byte, error) {
+	return d.signer.Sign(msg)
+}
+
+// PoolKey returns the pool key of the wrapped dialer, if its connections are
+// pooled
+func (d *DKIMDialer) PoolKey() string {
+	if pd, ok := d.Dialer.(mailer.PooledDialer); ok {
+		return pd.PoolKey()
+	}
+	return ""
+}
+
+// PoolSettings returns the wrapped dialer's limits on reusing connections
+func (d *DKIMDialer) PoolSettings() mailer.PoolSettings {
+	if pd, ok := d.Dialer.(mailer.PooledDialer); ok {
+		return pd.PoolSettings()
+	}
+	return mailer.PoolSettings{}
+}
+
+// The interfaces a sending profile can send its emails through. Profiles
+// without an interface use SMTP.
+const (
+	INTERFACE_SMTP     string = "SMTP"
+	INTERFACE_GRAPH    string = "Microsoft Graph"
+	INTERFACE_SES      string = "Amazon SES"
+	INTERFACE_MAILGUN  string = "Mailgun"
+	INTERFACE_SENDGRID string = "SendGrid"
+)
+
+// SMTP contains the attributes needed to handle the sending of campaign emails.
+// Profiles using a sending API instead of SMTP keep their credentials in the
+// Username and Password, which are the client ID and secret for Microsoft
+// Graph, the access key ID and secret access key for Amazon SES, and just the
+// API key in the Password for Mailgun and SendGrid. The Tenant is the
+// Microsoft Graph directory, and the Region is the Amazon SES or Mailgun
+// region.
 type SMTP struct {
-	Id               int64     `json:"id" gorm:"column:id; primary_key:yes"`
-	UserId           int64     `json:"-" gorm:"column:user_id"`
-	Interface        string    `json:"interface_type" gorm:"column:interface_type"`
-	Name             string    `json:"name"`
-	Host             string    `json:"host"`
-	Username         string    `json:"username,omitempty"`
-	Password         string    `json:"password,omitempty"`
-	FromAddress      string    `json:"from_address"`
-	IgnoreCertErrors bool      `json:"ignore_cert_errors"`
-	Headers          []Header  `json:"headers"`
-	ModifiedDate     time.Time `json:"modified_date"`
+	Id               int64    `json:"id" gorm:"column:id; primary_key:yes"`
+	UserId           int64    `json:"-" gorm:"column:user_id"`
+	Interface        string   `json:"interface_type" gorm:"column:interface_type"`
+	Name             string   `json:"name"`
+	Host             string   `json:"host"`
+	Username         string   `json:"username,omitempty"`
+	Password         string   `json:"password,omitempty"`
+	FromAddress      string   `json:"from_address"`
+	Tenant           string   `json:"tenant,omitempty"`
+	Region           string   `json:"region,omitempty"`
+	IgnoreCertErrors bool     `json:"ignore_cert_errors"`
+	Headers          []Header `json:"headers"`
+	DKIMDomain       string   `json:"dkim_domain" gorm:"column:dkim_domain"`
+	DKIMSelector     string   `json:"dkim_selector" gorm:"column:dkim_selector"`
+	DKIMPrivateKey   string   `json:"dkim_private_key,omitempty" gorm:"column:dkim_private_key"`
+	// Connections to SMTP servers are reused between batches of emails. The
+	// connection limits are the most emails sent over a connection, and how
+	// many seconds an idle connection is kept open, with the mailer's
+	// defaults used when they're 0.
+	MaxMessagesPerConnection int `json:"max_messages_per_connection" gorm:"column:max_messages_per_connection"`
+	ConnectionIdleTimeout    int `json:"connection_idle_timeout" gorm:"column:connection_idle_timeout"`
+	// The sending limits are the most emails the worker sends through the
+	// profile each minute and each hour, across all of its campaigns. Emails
+	// over the limits are rescheduled, and there's no limit when they're 0.
+	MaxMessagesPerMinute int       `json:"max_messages_per_minute" gorm:"column:max_messages_per_minute"`
+	MaxMessagesPerHour   int       `json:"max_messages_per_hour" gorm:"column:max_messages_per_hour"`
+	ModifiedDate         time.Time `json:"modified_date"`
 }
 
 // Header contains the fields and methods for a sending profile to have
@@ -63,6 +140,34 @@ var ErrHostNotSpecified = errors.New("No SMTP Host specified")
 // ErrInvalidHost indicates that the SMTP server string is invalid
 var ErrInvalidHost = errors.New("Invalid SMTP server address")
 
+// ErrInvalidConnectionLimits is thrown when a sending profile's connection
+// limits are negative
+var ErrInvalidConnectionLimits = errors.New("Connection limits can't be negative")
+
+// ErrInvalidSendingLimits is thrown when a sending profile's limits on the
+// number of emails sent each minute or hour are negative
+var ErrInvalidSendingLimits = errors.New("Sending limits can't be negative")
+
+// ErrUnknownInterface is thrown when the sending profile's interface type
+// isn't supported
+var ErrUnknownInterface = errors.New("Unknown sending profile interface")
+
+// ErrAPICredentialsNotSpecified is thrown when a sending profile using a
+// sending API is missing its credentials
+var ErrAPICredentialsNotSpecified = errors.New("No API credentials specified")
+
+// ErrTenantNotSpecified is thrown when a Microsoft Graph sending profile has
+// no tenant specified
+var ErrTenantNotSpecified = errors.New("No tenant specified")
+
+// ErrRegionNotSpecified is thrown when an Amazon SES sending profile has no
+// region specified
+var ErrRegionNotSpecified = errors.New("No region specified")
+
+// ErrDKIMIncomplete is thrown when only some of the DKIM domain, selector and
+// private key are specified in the SMTP configuration
+var ErrDKIMIncomplete = errors.New("DKIM domain, selector and private key must all be specified")
+
 // TableName specifies the database tablename for Gorm to use
 func (s SMTP) TableName() string {
 	return "smtp"
@@ -73,13 +178,30 @@ func (s *SMTP) Validate() error {
 	switch {
 	case s.FromAddress == "":
 		return ErrFromAddressNotSpecified
-	case s.Host == "":
+	case s.usesSMTP() && s.Host == "":
 		return ErrHostNotSpecified
+	case s.MaxMessagesPerConnection < 0 || s.ConnectionIdleTimeout < 0:
+		return ErrInvalidConnectionLimits
+	case s.MaxMessagesPerMinute < 0 || s.MaxMessagesPerHour < 0:
+		return ErrInvalidSendingLimits
 	}
 	_, err := mail.ParseAddress(s.FromAddress)
 	if err != nil {
 		return err
 	}
+	for _, h := range s.Headers {
+		err = validateHeader(h.Key, h.Value)
+		if err != nil {
+			return err
+		}
+	}
+	if !s.usesSMTP() {
+		err = s.validateAPI()
+		if err != nil {
+			return err
+		}
+		return s.validateDKIM()
+	}
 	// Make sure addr is in host:port format
 	hp := strings.Split(s.Host, ":")
 	if len(hp) > 2 {
@@ -91,11 +213,98 @@ func (s *SMTP) Validate() error {
 	if err != nil {
 		return ErrInvalidHost
 	}
+	return s.validateDKIM()
+}
+
+// usesSMTP returns whether or not the profile sends its emails over SMTP
+func (s *SMTP) usesSMTP() bool {
+	return s.Interface == "" || s.Interface == INTERFACE_SMTP
+}
+
+// validateAPI ensures that the profile has the settings required by its
+// sending API.
+func (s *SMTP) validateAPI() error {
+	switch s.Interface {
+	case INTERFACE_GRAPH:
+		if s.Tenant == "" {
+			return ErrTenantNotSpecified
+		}
+		if s.Username == "" || s.Password == "" {
+			return ErrAPICredentialsNotSpecified
+		}
+	case INTERFACE_SES:
+		if s.Region == "" {
+			return ErrRegionNotSpecified
+		}
+		if s.Username == "" || s.Password == "" {
+			return ErrAPICredentialsNotSpecified
+		}
+	case INTERFACE_MAILGUN, INTERFACE_SENDGRID:
+		if s.Password == "" {
+			return ErrAPICredentialsNotSpecified
+		}
+	default:
+		return ErrUnknownInterface
+	}
+	return nil
+}
+
+// apiProvider returns the provider for the profile's sending API
+func (s *SMTP) apiProvider() (mailer.APIProvider, error) {
+	switch s.Interface {
+	case INTERFACE_GRAPH:
+		return &mailer.GraphProvider{Tenant: s.Tenant, ClientID: s.Username, ClientSecret: s.Password}, nil
+	case INTERFACE_SES:
+		return &mailer.SESProvider{AccessKeyID: s.Username, SecretAccessKey: s.Password, Region: s.Region}, nil
+	case INTERFACE_MAILGUN:
+		return &mailer.MailgunProvider{APIKey: s.Password, Region: s.Region}, nil
+	case INTERFACE_SENDGRID:
+		return &mailer.SendGridProvider{APIKey: s.Password}, nil
+	}
+	return nil, ErrUnknownInterface
+}
+
+// signingDialer wraps the dialer so that its messages are signed, if the
+// profile has DKIM configured.
+func (s *SMTP) signingDialer(d mailer.Dialer) (mailer.Dialer, error) {
+	if s.DKIMDomain == "" {
+		return d, nil
+	}
+	key, err := mailer.ParseDKIMKey(s.DKIMPrivateKey)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	signer := &mailer.DKIMSigner{
+		Domain:   s.DKIMDomain,
+		Selector: s.DKIMSelector,
+		Key:      key,
+	}
+	return &DKIMDialer{d, signer}, nil
+}
+
+// validateDKIM ensures that the DKIM configuration, if any, is complete and
+// that the private key can be used for signing.
+func (s *SMTP) validateDKIM() error {
+	if s.DKIMDomain == "" && s.DKIMSelector == "" && s.DKIMPrivateKey == "" {
+		return nil
+	}
+	if s.DKIMDomain == "" || s.DKIMSelector == "" || s.DKIMPrivateKey == "" {
+		return ErrDKIMIncomplete
+	}
+	_, err := mailer.ParseDKIMKey(s.DKIMPrivateKey)
 	return err
 }
 
 // GetDialer returns a dialer for the given SMTP profile
 func (s *SMTP) GetDialer() (mailer.Dialer, error) {
+	if !s.usesSMTP() {
+		p, err := s.apiProvider()
+		if err != nil {
+			return nil, err
+		}
+		return s.signingDialer(mailer.NewAPIDialer(p))
+	}
 	// Setup the message and dial
 	hp := strings.Split(s.Host, ":")
 	if len(hp) < 2 {
@@ -119,13 +328,23 @@ func (s *SMTP) GetDialer() (mailer.Dialer, error) {
 		hostname = "localhost"
 	}
 	d.LocalName = hostname
-	return &Dialer{d}, err
+	pd := &Dialer{
+		Dialer: d,
+		pool: mailer.PoolSettings{
+			MaxMessages: s.MaxMessagesPerConnection,
+			IdleTimeout: time.Duration(s.ConnectionIdleTimeout) * time.Second,
+		},
+	}
+	if s.DKIMDomain == "" {
+		return pd, err
+	}
+	return s.signingDialer(pd)
 }
 
 // GetSMTPs returns the SMTPs owned by the given user.
 func GetSMTPs(uid int64) ([]SMTP, error) {
 	ss := []SMTP{}
-	err := db.Where("user_id=?", uid).Find(&ss).Error
+	err := db.Where("user_id in (?)", teamUserIds(uid)).Find(&ss).Error
 	if err != nil {
 		log.Error(err)
 		return ss, err
@@ -143,7 +362,7 @@ func GetSMTPs(uid int64) ([]SMTP, error) {
 // GetSMTP returns the SMTP, if it exists, specified by the given id and user_id.
 func GetSMTP(id int64, uid int64) (SMTP, error) {
 	s := SMTP{}
-	err := db.Where("user_id=? and id=?", uid, id).Find(&s).Error
+	err := db.Where("user_id in (?) and id=?", teamUserIds(uid), id).Find(&s).Error
 	if err != nil {
 		log.Error(err)
 	}
@@ -158,7 +377,7 @@ func GetSMTP(id int64, uid int64) (SMTP, error) {
 // GetSMTPByName returns the SMTP, if it exists, specified by the given name and user_id.
 func GetSMTPByName(n string, uid int64) (SMTP, error) {
 	s := SMTP{}
-	err := db.Where("user_id=? and name=?", uid, n).Find(&s).Error
+	err := db.Where("user_id in (?) and name=?", teamUserIds(uid), n).Find(&s).Error
 	if err != nil {
 		log.Error(err)
 		return s, err
@@ -232,7 +451,7 @@ func DeleteSMTP(id int64, uid int64) error {
 		log.Error(err)
 		return err
 	}
-	err = db.Where("user_id=?", uid).Delete(SMTP{Id: id}).Error
+	err = db.Where("user_id in (?)", teamUserIds(uid)).Delete(SMTP{Id: id}).Error
 	if err != nil {
 		log.Error(err)
 	}
@@ -1,8 +1,14 @@
 package models
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"time"
 
+	"github.com/gophish/gophish/mailer"
 	check "gopkg.in/check.v1"
 )
 
@@ -74,3 +80,145 @@ func (s *ModelsSuite) TestSMTPGetDialer(ch *check.C) {
 	ch.Assert(dialer.TLSConfig.ServerName, check.Equals, smtp.Host)
 	ch.Assert(dialer.TLSConfig.InsecureSkipVerify, check.Equals, smtp.IgnoreCertErrors)
 }
+
+func (s *ModelsSuite) TestSMTPSendingLimits(ch *check.C) {
+	smtp := SMTP{
+		Name:                 "Test SMTP",
+		Host:                 "1.1.1.1:25",
+		FromAddress:          "foo@example.com",
+		MaxMessagesPerMinute: -1,
+	}
+	ch.Assert(smtp.Validate(), check.Equals, ErrInvalidSendingLimits)
+	smtp.MaxMessagesPerMinute = 10
+	smtp.MaxMessagesPerHour = -1
+	ch.Assert(smtp.Validate(), check.Equals, ErrInvalidSendingLimits)
+	smtp.MaxMessagesPerHour = 200
+	ch.Assert(smtp.Validate(), check.Equals, nil)
+	// Emails are spaced to stay within the stricter of the limits
+	ch.Assert(smtp.sendInterval(), check.Equals, 18*time.Second)
+	smtp.MaxMessagesPerHour = 0
+	ch.Assert(smtp.sendInterval(), check.Equals, 6*time.Second)
+}
+
+func (s *ModelsSuite) TestSMTPConnectionLimits(ch *check.C) {
+	smtp := SMTP{
+		Name:                     "Test SMTP",
+		Host:                     "1.1.1.1:25",
+		FromAddress:              "foo@example.com",
+		MaxMessagesPerConnection: -1,
+	}
+	ch.Assert(smtp.Validate(), check.Equals, ErrInvalidConnectionLimits)
+	smtp.MaxMessagesPerConnection = 50
+	smtp.ConnectionIdleTimeout = -1
+	ch.Assert(smtp.Validate(), check.Equals, ErrInvalidConnectionLimits)
+	smtp.ConnectionIdleTimeout = 60
+	ch.Assert(smtp.Validate(), check.Equals, nil)
+
+	d, err := smtp.GetDialer()
+	ch.Assert(err, check.Equals, nil)
+	pd, ok := d.(mailer.PooledDialer)
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(pd.PoolSettings(), check.Equals, mailer.PoolSettings{MaxMessages: 50, IdleTimeout: time.Minute})
+
+	// Connections are only shared by profiles with the same server and
+	// credentials
+	other := smtp
+	other.Password = "different"
+	od, err := other.GetDialer()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(od.(mailer.PooledDialer).PoolKey(), check.Not(check.Equals), pd.PoolKey())
+	other.Password = smtp.Password
+	od, err = other.GetDialer()
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(od.(mailer.PooledDialer).PoolKey(), check.Equals, pd.PoolKey())
+}
+
+func (s *ModelsSuite) TestSMTPValidateDKIM(c *check.C) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	c.Assert(err, check.Equals, nil)
+	pk := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	smtp := SMTP{
+		Name:         "Test SMTP",
+		Host:         "1.1.1.1:25",
+		FromAddress:  "Foo Bar <foo@example.com>",
+		UserId:       1,
+		DKIMDomain:   "example.com",
+		DKIMSelector: "gophish",
+	}
+	c.Assert(smtp.Validate(), check.Equals, ErrDKIMIncomplete)
+	smtp.DKIMPrivateKey = "not a key"
+	c.Assert(smtp.Validate(), check.Equals, mailer.ErrInvalidDKIMKey)
+	smtp.DKIMPrivateKey = string(pk)
+	c.Assert(smtp.Validate(), check.Equals, nil)
+
+	// Sending profiles with DKIM configured have their messages signed
+	d, err := smtp.GetDialer()
+	c.Assert(err, check.Equals, nil)
+	_, ok := d.(mailer.SigningDialer)
+	c.Assert(ok, check.Equals, true)
+
+	smtp.DKIMDomain = ""
+	smtp.DKIMSelector = ""
+	smtp.DKIMPrivateKey = ""
+	d, err = smtp.GetDialer()
+	c.Assert(err, check.Equals, nil)
+	_, ok = d.(mailer.SigningDialer)
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *ModelsSuite) TestSMTPValidateAPIInterface(c *check.C) {
+	smtp := SMTP{
+		Name:        "Test SMTP",
+		FromAddress: "Foo Bar <foo@example.com>",
+		UserId:      1,
+		Interface:   "Carrier Pigeon",
+	}
+	c.Assert(smtp.Validate(), check.Equals, ErrUnknownInterface)
+
+	// Sending APIs don't need a host
+	smtp.Interface = INTERFACE_GRAPH
+	c.Assert(smtp.Validate(), check.Equals, ErrTenantNotSpecified)
+	smtp.Tenant = "tenant-id"
+	c.Assert(smtp.Validate(), check.Equals, ErrAPICredentialsNotSpecified)
+	smtp.Username = "client-id"
+	smtp.Password = "secret"
+	c.Assert(smtp.Validate(), check.Equals, nil)
+
+	smtp.Interface = INTERFACE_SES
+	c.Assert(smtp.Validate(), check.Equals, ErrRegionNotSpecified)
+	smtp.Region = "us-east-1"
+	c.Assert(smtp.Validate(), check.Equals, nil)
+
+	smtp.Interface = INTERFACE_SENDGRID
+	smtp.Password = ""
+	c.Assert(smtp.Validate(), check.Equals, ErrAPICredentialsNotSpecified)
+	smtp.Password = "api-key"
+	c.Assert(smtp.Validate(), check.Equals, nil)
+
+	// SMTP profiles still require a host
+	smtp.Interface = INTERFACE_SMTP
+	c.Assert(smtp.Validate(), check.Equals, ErrHostNotSpecified)
+}
+
+func (s *ModelsSuite) TestSMTPGetAPIDialer(c *check.C) {
+	smtp := SMTP{
+		FromAddress: "foo@example.com",
+		Interface:   INTERFACE_MAILGUN,
+		Password:    "api-key",
+		Region:      "eu",
+	}
+	d, err := smtp.GetDialer()
+	c.Assert(err, check.Equals, nil)
+	ad, ok := d.(*mailer.APIDialer)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(ad.Provider, check.DeepEquals, &mailer.MailgunProvider{APIKey: "api-key", Region: "eu"})
+
+	smtp.Interface = INTERFACE_GRAPH
+	smtp.Tenant = "tenant-id"
+	smtp.Username = "client-id"
+	smtp.Password = "secret"
+	d, err = smtp.GetDialer()
+	c.Assert(err, check.Equals, nil)
+	ad = d.(*mailer.APIDialer)
+	c.Assert(ad.Provider, check.DeepEquals, &mailer.GraphProvider{Tenant: "tenant-id", ClientID: "client-id", ClientSecret: "secret"})
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Snapshot is an immutable, point-in-time copy of a campaign's results, used
+// so that finalized reports aren't changed by events which arrive later.
+type Snapshot struct {
+	Id          int64            `json:"id"`
+	CampaignId  int64            `json:"campaign_id"`
+	UserId      int64            `json:"-"`
+	Time        time.Time        `json:"time"`
+	CreatedDate time.Time        `json:"created_date"`
+	Data        string           `json:"-"`
+	Stats       CampaignStats    `json:"stats" sql:"-"`
+	Results     []SnapshotResult `json:"results" sql:"-"`
+}
+
+// SnapshotResult is the state of a single result at the time a snapshot was
+// taken.
+type SnapshotResult struct {
+	Id        string `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Position  string `json:"position"`
+	Status    string `json:"status"`
+	Reported  bool   `json:"reported"`
+	Excluded  bool   `json:"excluded_from_report"`
+}
+
+// snapshotData is the content of a snapshot that is stored as JSON.
+type snapshotData struct {
+	Stats   CampaignStats    `json:"stats"`
+	Results []SnapshotResult `json:"results"`
+}
+
+// statusAt returns the result's status and whether or not it had been
+// reported, using only the events that occurred at or before the given time.
+func statusAt(es []Event, at time.Time) (string, bool) {
+	status := STATUS_SCHEDULED
+	reported := false
+	rank := map[string]int{
+		EVENT_SENT:        1,
+		EVENT_OPENED:      2,
+		EVENT_CLICKED:     3,
+		EVENT_DATA_SUBMIT: 4,
+		EVENT_MFA_SUBMIT:  5,
+	}
+	for _, e := range es {
+		if e.Time.After(at) {
+			continue
+		}
+		switch e.Message {
+		case EVENT_REPORTED:
+			reported = true
+		case EVENT_SENDING_ERROR:
+			if rank[status] == 0 {
+				status = ERROR
+			}
+		case EVENT_BOUNCED:
+			if rank[status] <= rank[EVENT_SENT] {
+				status = EVENT_BOUNCED
+			}
+		default:
+			if rank[e.Message] > rank[status] {
+				status = e.Message
+			}
+		}
+	}
+	return status, reported
+}
+
+// snapshotStats aggregates the snapshot results in the same way as the live
+// campaign statistics.
+func snapshotStats(srs []SnapshotResult) CampaignStats {
+	s := CampaignStats{}
+	for _, sr := range srs {
+		if sr.Excluded && !IncludeExcludedResults {
+			continue
+		}
+		s.Total++
+		if sr.Reported {
+			s.EmailReported++
+		}
+		switch sr.Status {
+		case EVENT_MFA_SUBMIT:
+			s.SubmittedMFA++
+			fallthrough
+		case EVENT_DATA_SUBMIT:
+			s.SubmittedData++
+			fallthrough
+		case EVENT_CLICKED:
+			s.ClickedLink++
+			fallthrough
+		case EVENT_OPENED:
+			s.OpenedEmail++
+			fallthrough
+		case EVENT_SENT:
+			s.EmailsSent++
+		case EVENT_BOUNCED:
+			s.Bounced++
+		case ERROR:
+			s.Error++
+		}
+	}
+	return s
+}
+
+// SnapshotCampaignResults stores a copy of the results of the campaign
+// specified by the given id and user_id as they were at the given time, and
+// returns the id of the new snapshot. Events after the given time are ignored.
+func SnapshotCampaignResults(cid int64, uid int64, at time.Time) (int64, error) {
+	c, err := GetCampaign(cid, uid)
+	if err != nil {
+		return 0, err
+	}
+	events := make(map[string][]Event)
+	for _, e := range c.Events {
+		events[e.Email] = append(events[e.Email], e)
+	}
+	srs := []SnapshotResult{}
+	for _, r := range c.Results {
+		status, reported := statusAt(events[r.Email], at)
+		srs = append(srs, SnapshotResult{
+			Id:        r.RId,
+			Email:     r.Email,
+			FirstName: r.FirstName,
+			LastName:  r.LastName,
+			Position:  r.Position,
+			Status:    status,
+			Reported:  reported,
+			Excluded:  r.ExcludedFromReport,
+		})
+	}
+	data, err := json.Marshal(snapshotData{Stats: snapshotStats(srs), Results: srs})
+	if err != nil {
+		return 0, err
+	}
+	s := &Snapshot{
+		CampaignId:  cid,
+		UserId:      uid,
+		Time:        at.UTC(),
+		CreatedDate: time.Now().UTC(),
+		Data:        string(data),
+	}
+	err = db.Save(s).Error
+	return s.Id, err
+}
+
+// GetSnapshot returns the snapshot, if it exists, specified by the given id
+// and user_id.
+func GetSnapshot(id int64, uid int64) (Snapshot, error) {
+	s := Snapshot{}
+	err := db.Where("id=? and user_id in (?)", id, teamUserIds(uid)).First(&s).Error
+	if err != nil {
+		return s, err
+	}
+	sd := snapshotData{}
+	err = json.Unmarshal([]byte(s.Data), &sd)
+	s.Stats = sd.Stats
+	s.Results = sd.Results
+	return s, err
+}
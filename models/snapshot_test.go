@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestSnapshotCampaignResults(ch *check.C) {
+	campaign := s.createCampaign(ch)
+	first, second := campaign.Results[0], campaign.Results[1]
+	ch.Assert(first.HandleEmailSent(), check.Equals, nil)
+	ch.Assert(first.HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(second.HandleEmailSent(), check.Equals, nil)
+
+	id, err := SnapshotCampaignResults(campaign.Id, campaign.UserId, time.Now().UTC())
+	ch.Assert(err, check.Equals, nil)
+
+	// Events arriving after the snapshot still update the live results
+	ch.Assert(second.HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	ch.Assert(first.HandleEmailReport(EventDetails{}), check.Equals, nil)
+	live, err := GetCampaignSummary(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(live.Stats.SubmittedData, check.Equals, int64(1))
+	ch.Assert(live.Stats.EmailReported, check.Equals, int64(1))
+
+	snapshot, err := GetSnapshot(id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(snapshot.CampaignId, check.Equals, campaign.Id)
+	ch.Assert(snapshot.Stats, check.Equals, CampaignStats{
+		Total:       2,
+		EmailsSent:  2,
+		OpenedEmail: 1,
+		ClickedLink: 1,
+	})
+	statuses := make(map[string]SnapshotResult)
+	for _, sr := range snapshot.Results {
+		statuses[sr.Id] = sr
+	}
+	ch.Assert(statuses[first.RId].Status, check.Equals, EVENT_CLICKED)
+	ch.Assert(statuses[first.RId].Reported, check.Equals, false)
+	ch.Assert(statuses[second.RId].Status, check.Equals, EVENT_SENT)
+
+	// Snapshots can be taken as of an earlier time
+	id, err = SnapshotCampaignResults(campaign.Id, campaign.UserId, time.Now().UTC().Add(-time.Hour))
+	ch.Assert(err, check.Equals, nil)
+	snapshot, err = GetSnapshot(id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(snapshot.Stats, check.Equals, CampaignStats{Total: 2})
+
+	// Other users can't retrieve the snapshot
+	_, err = GetSnapshot(id, 2)
+	ch.Assert(err, check.NotNil)
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	log "github.com/gophish/gophish/logger"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// CampaignStep is a landing page shown after the recipient submits the page
+// before it, so that a campaign can simulate a multi-step flow, such as a
+// username page followed by a password page and then a second factor prompt.
+// The landing page of the campaign, or of the recipient's variant, is the
+// first step of the flow. Each step captures submissions using its own page's
+// capture settings, and submissions to steps marked as MFA are recorded as a
+// submitted second factor.
+type CampaignStep struct {
+	Id         int64 `json:"id"`
+	CampaignId int64 `json:"-"`
+	// Position is the step's place in the flow, starting at 1 for the page
+	// shown after the landing page
+	Position int   `json:"position"`
+	PageId   int64 `json:"-"`
+	Page     Page  `json:"page" sql:"-"`
+	MFA      bool  `json:"mfa"`
+}
+
+// validateSteps checks that every step names a landing page
+func (c *Campaign) validateSteps() error {
+	for _, s := range c.Steps {
+		if s.Page.Name == "" {
+			return ErrPageNotSpecified
+		}
+	}
+	return nil
+}
+
+// lookupSteps fills in the landing page of each step from those owned by the
+// given user, numbering the steps in the order they were given.
+func (c *Campaign) lookupSteps(uid int64) error {
+	for i := range c.Steps {
+		s := &c.Steps[i]
+		p, err := GetPageByName(s.Page.Name, uid)
+		if err == gorm.ErrRecordNotFound {
+			log.WithFields(logrus.Fields{
+				"page": s.Page.Name,
+			}).Error("Page does not exist")
+			return ErrPageNotFound
+		} else if err != nil {
+			log.Error(err)
+			return err
+		}
+		s.Page = p
+		s.PageId = p.Id
+		s.Position = i + 1
+	}
+	return nil
+}
+
+// getSteps retrieves the campaign's steps and their landing pages from the
+// database.
+func (c *Campaign) getSteps() error {
+	err := db.Where("campaign_id=?", c.Id).Order("position asc").Find(&c.Steps).Error
+	if err != nil {
+		return err
+	}
+	for i := range c.Steps {
+		s := &c.Steps[i]
+		err = db.Table("pages").Where("id=?", s.PageId).Find(&s.Page).Error
+		if err != nil {
+			if err != gorm.ErrRecordNotFound {
+				return err
+			}
+			s.Page = Page{Name: "[Deleted]"}
+			log.Warnf("%s: page not found for campaign step", err)
+		}
+	}
+	return nil
+}
+
+// StepFor returns the step of the campaign's flow at the given position for
+// the result, and whether the flow has a step at that position. Position 0
+// is the landing page of the result's variant.
+func (c *Campaign) StepFor(r *Result, position int) (CampaignStep, bool) {
+	if position == 0 {
+		v := c.VariantFor(r)
+		return CampaignStep{CampaignId: c.Id, PageId: v.PageId, Page: v.Page}, true
+	}
+	if position < 0 || position > len(c.Steps) {
+		return CampaignStep{}, false
+	}
+	return c.Steps[position-1], true
+}
+
+// recordStep records that the recipient submitted the step of the flow given
+// in the event details, returning whether the result progressed further
+// through the flow than it had before.
+func (r *Result) recordStep(details EventDetails) bool {
+	if details.Step+1 <= r.StepsSubmitted {
+		return false
+	}
+	r.StepsSubmitted = details.Step + 1
+	return true
+}
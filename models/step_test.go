@@ -0,0 +1,101 @@
+package models
+
+import (
+	"encoding/json"
+	"net/url"
+
+	"gopkg.in/check.v1"
+)
+
+// createStepCampaign launches a campaign whose landing page is followed by a
+// password step and an MFA step
+func (s *ModelsSuite) createStepCampaign(ch *check.C) Campaign {
+	c := s.createCampaignDependencies(ch)
+	password := Page{Name: "Password Page", HTML: "<html>Password</html>", UserId: 1, CaptureCredentials: true}
+	ch.Assert(PostPage(&password), check.Equals, nil)
+	mfa := Page{Name: "MFA Page", HTML: "<html>Code</html>", UserId: 1, CaptureCredentials: true}
+	ch.Assert(PostPage(&mfa), check.Equals, nil)
+	c.Steps = []CampaignStep{
+		{Page: Page{Name: password.Name}},
+		{Page: Page{Name: mfa.Name}, MFA: true},
+	}
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+	return c
+}
+
+func (s *ModelsSuite) TestPostCampaignSteps(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	c.Steps = []CampaignStep{{}}
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, ErrPageNotSpecified)
+	c.Steps = []CampaignStep{{Page: Page{Name: "Missing Page"}}}
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, ErrPageNotFound)
+
+	c = s.createStepCampaign(ch)
+	got, err := GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(got.Steps), check.Equals, 2)
+	ch.Assert(got.Steps[0].Position, check.Equals, 1)
+	ch.Assert(got.Steps[0].Page.Name, check.Equals, "Password Page")
+	ch.Assert(got.Steps[0].MFA, check.Equals, false)
+	ch.Assert(got.Steps[1].Position, check.Equals, 2)
+	ch.Assert(got.Steps[1].Page.Name, check.Equals, "MFA Page")
+	ch.Assert(got.Steps[1].MFA, check.Equals, true)
+
+	r := got.Results[0]
+	step, ok := got.StepFor(&r, 0)
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(step.PageId, check.Equals, got.PageId)
+	step, ok = got.StepFor(&r, 2)
+	ch.Assert(ok, check.Equals, true)
+	ch.Assert(step.PageId, check.Equals, got.Steps[1].PageId)
+	_, ok = got.StepFor(&r, 3)
+	ch.Assert(ok, check.Equals, false)
+	_, ok = got.StepFor(&r, -1)
+	ch.Assert(ok, check.Equals, false)
+
+	// Copies of the campaign keep its flow
+	cp := Campaign{Groups: []Group{{Name: c.Groups[0].Name}}}
+	ch.Assert(CopyCampaign(c.Id, c.UserId, &cp), check.Equals, nil)
+	ch.Assert(len(cp.Steps), check.Equals, 2)
+	ch.Assert(cp.Steps[1].PageId, check.Equals, got.Steps[1].PageId)
+	ch.Assert(cp.Steps[1].MFA, check.Equals, true)
+
+	ch.Assert(DeleteCampaign(c.Id), check.Equals, nil)
+	var count int
+	ch.Assert(db.Model(&CampaignStep{}).Where("campaign_id=?", c.Id).Count(&count).Error, check.Equals, nil)
+	ch.Assert(count, check.Equals, 0)
+}
+
+func (s *ModelsSuite) TestStepSubmissions(ch *check.C) {
+	c := s.createStepCampaign(ch)
+	r := c.Results[0]
+	payload := url.Values{"username": {"jdoe"}}
+	ch.Assert(r.HandleFormSubmit(EventDetails{Payload: payload}), check.Equals, nil)
+	ch.Assert(r.StepsSubmitted, check.Equals, 1)
+	ch.Assert(r.HandleFormSubmit(EventDetails{Payload: url.Values{"password": {"secret"}}, Step: 1}), check.Equals, nil)
+	ch.Assert(r.StepsSubmitted, check.Equals, 2)
+	ch.Assert(r.Status, check.Equals, EVENT_DATA_SUBMIT)
+
+	// Going back to an earlier step doesn't undo the progress
+	ch.Assert(r.HandleFormSubmit(EventDetails{Payload: payload}), check.Equals, nil)
+	ch.Assert(r.StepsSubmitted, check.Equals, 2)
+
+	policy := &CapturePolicy{Mode: CAPTURE_NAMES}
+	d := EventDetails{Payload: url.Values{"code": {"123456"}}, Step: 2, CapturePolicy: policy}
+	ch.Assert(r.HandleMFASubmit(d), check.Equals, nil)
+	ch.Assert(r.StepsSubmitted, check.Equals, 3)
+	ch.Assert(r.Status, check.Equals, EVENT_MFA_SUBMIT)
+	got, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.StepsSubmitted, check.Equals, 3)
+
+	// Each submission records the step it was made to, using the step's
+	// capture settings
+	es := []Event{}
+	ch.Assert(db.Where("campaign_id=? and message=?", c.Id, EVENT_MFA_SUBMIT).Find(&es).Error, check.Equals, nil)
+	ch.Assert(len(es), check.Equals, 1)
+	ed := EventDetails{}
+	ch.Assert(json.Unmarshal([]byte(es[0].Details), &ed), check.Equals, nil)
+	ch.Assert(ed.Step, check.Equals, 2)
+	ch.Assert(ed.Payload.Get("code"), check.Equals, "")
+}
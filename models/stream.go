@@ -0,0 +1,90 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// StreamBufferSize is the number of events held for each client streaming a
+// campaign's events. Events are dropped for clients which fall this far
+// behind, rather than blocking the event from being recorded.
+var StreamBufferSize = 100
+
+// StreamEvent is an event recorded for a result, as streamed to the clients
+// watching the campaign's results live.
+type StreamEvent struct {
+	Id      int64     `json:"id"`
+	RId     string    `json:"rid" gorm:"column:r_id"`
+	Email   string    `json:"email"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+	Details string    `json:"details"`
+}
+
+// eventStreams holds the channels of the clients streaming each campaign's
+// events
+var eventStreams = struct {
+	sync.Mutex
+	subscribers map[int64]map[chan StreamEvent]bool
+}{subscribers: make(map[int64]map[chan StreamEvent]bool)}
+
+// SubscribeCampaignEvents returns a channel which receives the events
+// recorded for the campaign's results from now on, and a function which
+// must be called once they're no longer needed.
+func SubscribeCampaignEvents(cid int64) (<-chan StreamEvent, func()) {
+	ch := make(chan StreamEvent, StreamBufferSize)
+	eventStreams.Lock()
+	if eventStreams.subscribers[cid] == nil {
+		eventStreams.subscribers[cid] = make(map[chan StreamEvent]bool)
+	}
+	eventStreams.subscribers[cid][ch] = true
+	eventStreams.Unlock()
+	return ch, func() {
+		eventStreams.Lock()
+		delete(eventStreams.subscribers[cid], ch)
+		if len(eventStreams.subscribers[cid]) == 0 {
+			delete(eventStreams.subscribers, cid)
+		}
+		eventStreams.Unlock()
+	}
+}
+
+// publishEvent sends the event recorded for the result to the clients
+// streaming the campaign's events.
+func publishEvent(r *Result, e *Event) {
+	se := StreamEvent{
+		Id:      e.Id,
+		RId:     r.RId,
+		Email:   e.Email,
+		Message: e.Message,
+		Time:    e.Time,
+		Details: e.Details,
+	}
+	eventStreams.Lock()
+	defer eventStreams.Unlock()
+	for ch := range eventStreams.subscribers[e.CampaignId] {
+		select {
+		case ch <- se:
+		default:
+		}
+	}
+}
+
+// GetCampaignStreamEvents returns the events recorded for the results of the
+// campaign specified by the given id and user_id after the event with the
+// given id, oldest first. It's used to catch up clients which reconnect to
+// the campaign's stream.
+func GetCampaignStreamEvents(id int64, uid int64, after int64) ([]StreamEvent, error) {
+	es := []StreamEvent{}
+	_, err := GetCampaignSummary(id, uid)
+	if err != nil {
+		return es, err
+	}
+	err = db.Table("events").
+		Select("events.id, results.r_id, events.email, events.message, events.time, events.details").
+		Joins("join results on results.campaign_id = events.campaign_id and results.email = events.email").
+		Where("events.campaign_id = ? and events.id > ?", id, after).
+		Order("events.id asc").
+		Scan(&es).Error
+	return es, err
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"errors"
+
+	log "github.com/gophish/gophish/logger"
+)
+
+// The roles a user can have. Administrators can manage users and teams,
+// campaign operators can create and edit campaigns and their resources, and
+// auditors can only view them.
+const (
+	ROLE_ADMIN    = "admin"
+	ROLE_OPERATOR = "operator"
+	ROLE_AUDITOR  = "auditor"
+)
+
+// Team is a workspace shared by a group of users. Campaigns, groups,
+// templates, landing pages and sending profiles belonging to any member of
+// a team are visible to every other member. Users who aren't in a team only
+// see their own.
+type Team struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name" sql:"not null"`
+}
+
+// ErrInvalidRole is thrown when a user has an unknown role
+var ErrInvalidRole = errors.New("Invalid role")
+
+// ErrTeamNameNotSpecified is thrown when a team has no name
+var ErrTeamNameNotSpecified = errors.New("Team name not specified")
+
+// ErrTeamNotFound is thrown when a user is added to a team which doesn't
+// exist
+var ErrTeamNotFound = errors.New("Team not found")
+
+// Validate ensures that the team has a name
+func (t *Team) Validate() error {
+	if t.Name == "" {
+		return ErrTeamNameNotSpecified
+	}
+	return nil
+}
+
+// GetTeams returns all of the teams
+func GetTeams() ([]Team, error) {
+	ts := []Team{}
+	err := db.Order("id asc").Find(&ts).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return ts, err
+}
+
+// GetTeam returns the team, if it exists, specified by the given id.
+func GetTeam(id int64) (Team, error) {
+	t := Team{}
+	err := db.Where("id=?", id).First(&t).Error
+	return t, err
+}
+
+// PostTeam creates a new team in the database.
+func PostTeam(t *Team) error {
+	err := t.Validate()
+	if err != nil {
+		return err
+	}
+	err = db.Save(t).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// PutTeam edits an existing team in the database.
+func PutTeam(t *Team) error {
+	err := t.Validate()
+	if err != nil {
+		return err
+	}
+	err = db.Save(t).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// DeleteTeam deletes the team specified by the given id. Its members are
+// removed from the team, and keep the resources they own.
+func DeleteTeam(id int64) error {
+	err := db.Model(&User{}).Where("team_id=?", id).Update("team_id", 0).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Where("id=?", id).Delete(&Team{}).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// userInTeam returns whether or not the resources of the user with the given
+// owner id are visible to the given user, which is the case when they're the
+// same user or members of the same team.
+func userInTeam(owner int64, uid int64) bool {
+	for _, id := range teamUserIds(uid) {
+		if id == owner {
+			return true
+		}
+	}
+	return false
+}
+
+// teamUserIds returns the ids of the users whose resources are visible to
+// the given user: the members of their team, or just the user if they
+// aren't in one.
+func teamUserIds(uid int64) []int64 {
+	u, err := GetUser(uid)
+	if err != nil || u.TeamId == 0 {
+		return []int64{uid}
+	}
+	ids := []int64{}
+	err = db.Model(&User{}).Where("team_id=?", u.TeamId).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return []int64{uid}
+	}
+	return ids
+}
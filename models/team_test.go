@@ -0,0 +1,73 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) createTeamUser(ch *check.C, username string, role string, tid int64) User {
+	u := User{Username: username, ApiKey: username + "-key", Role: role, TeamId: tid}
+	ch.Assert(PutUser(&u), check.Equals, nil)
+	return u
+}
+
+func (s *ModelsSuite) TestUserValidation(ch *check.C) {
+	u := User{Role: "superuser"}
+	ch.Assert(u.Validate(), check.Equals, ErrInvalidRole)
+	u = User{Role: ROLE_AUDITOR, TeamId: 100}
+	ch.Assert(u.Validate(), check.Equals, ErrTeamNotFound)
+
+	t := Team{Name: "Red Team"}
+	ch.Assert(PostTeam(&t), check.Equals, nil)
+	u.TeamId = t.Id
+	ch.Assert(u.Validate(), check.Equals, nil)
+	ch.Assert(u.CanWrite(), check.Equals, false)
+	ch.Assert(u.IsAdmin(), check.Equals, false)
+}
+
+func (s *ModelsSuite) TestPostTeamValidation(ch *check.C) {
+	t := Team{}
+	ch.Assert(PostTeam(&t), check.Equals, ErrTeamNameNotSpecified)
+}
+
+func (s *ModelsSuite) TestTeamSharesResources(ch *check.C) {
+	t := Team{Name: "Red Team"}
+	ch.Assert(PostTeam(&t), check.Equals, nil)
+	ch.Assert(db.Model(&User{}).Where("id=?", 1).Update("team_id", t.Id).Error, check.Equals, nil)
+	teammate := s.createTeamUser(ch, "operator", ROLE_OPERATOR, t.Id)
+	outsider := s.createTeamUser(ch, "outsider", ROLE_OPERATOR, 0)
+
+	c := s.createCampaign(ch)
+
+	// Teammates can see each other's campaigns and their resources
+	got, err := GetCampaign(c.Id, teammate.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Id, check.Equals, c.Id)
+	cs, err := GetCampaigns(teammate.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(cs), check.Equals, 1)
+	gs, err := GetGroups(teammate.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(gs), check.Equals, 1)
+	_, err = GetTemplate(c.TemplateId, teammate.Id)
+	ch.Assert(err, check.Equals, nil)
+	_, err = GetSMTP(c.SMTPId, teammate.Id)
+	ch.Assert(err, check.Equals, nil)
+
+	// Users outside the team can't
+	_, err = GetCampaign(c.Id, outsider.Id)
+	ch.Assert(err, check.Equals, gorm.ErrRecordNotFound)
+	gs, err = GetGroups(outsider.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(gs), check.Equals, 0)
+
+	// Once the team is deleted, its members only see their own resources
+	ch.Assert(DeleteTeam(t.Id), check.Equals, nil)
+	_, err = GetCampaign(c.Id, teammate.Id)
+	ch.Assert(err, check.Equals, gorm.ErrRecordNotFound)
+	_, err = GetCampaign(c.Id, 1)
+	ch.Assert(err, check.Equals, nil)
+	u, err := GetUser(teammate.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(u.TeamId, check.Equals, int64(0))
+}
@@ -3,7 +3,9 @@ package models
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"html/template"
+	"net/url"
 	"time"
 
 	log "github.com/gophish/gophish/logger"
@@ -20,6 +22,9 @@ type Template struct {
 	HTML         string       `json:"html" gorm:"column:html"`
 	ModifiedDate time.Time    `json:"modified_date"`
 	Attachments  []Attachment `json:"attachments"`
+	// Localizations are the translations of the template sent to targets
+	// whose locale matches. See TemplateLocalization for details.
+	Localizations []TemplateLocalization `json:"localizations"`
 }
 
 // ErrTemplateNameNotSpecified is thrown when a template name is not specified
@@ -28,6 +33,23 @@ var ErrTemplateNameNotSpecified = errors.New("Template name not specified")
 // ErrTemplateMissingParameter is thrown when a needed parameter is not provided
 var ErrTemplateMissingParameter = errors.New("Need to specify at least plaintext or HTML content")
 
+// exampleTemplateContext returns the context templates are rendered with when
+// they're validated, for an example recipient
+func exampleTemplateContext() templateContext {
+	return templateContext{
+		Result{
+			Email:     "foo@bar.com",
+			FirstName: "Foo",
+			LastName:  "Bar",
+			Position:  "Test",
+		},
+		phishingURL{url: &url.URL{Scheme: "http", Host: "foo.bar"}, qr: &qrCodes{}},
+		"http://foo.bar/track",
+		"<img src='http://foo.bar/track",
+		"John Doe <foo@bar.com>",
+	}
+}
+
 // Validate checks the given template to make sure values are appropriate and complete
 func (t *Template) Validate() error {
 	switch {
@@ -39,25 +61,8 @@ func (t *Template) Validate() error {
 	var buff bytes.Buffer
 	// Test that the variables used in the template
 	// validate with no issues
-	td := struct {
-		Result
-		URL         string
-		TrackingURL string
-		Tracker     string
-		From        string
-	}{
-		Result{
-			Email:     "foo@bar.com",
-			FirstName: "Foo",
-			LastName:  "Bar",
-			Position:  "Test",
-		},
-		"http://foo.bar",
-		"http://foo.bar/track",
-		"<img src='http://foo.bar/track",
-		"John Doe <foo@bar.com>",
-	}
-	tmpl, err := template.New("html_template").Parse(t.HTML)
+	td := exampleTemplateContext()
+	tmpl, err := template.New("html_template").Option("missingkey=zero").Parse(t.HTML)
 	if err != nil {
 		return err
 	}
@@ -66,18 +71,29 @@ func (t *Template) Validate() error {
 		return err
 	}
 
-	tmpl, err = template.New("text_template").Parse(t.Text)
+	tmpl, err = template.New("text_template").Option("missingkey=zero").Parse(t.Text)
 	if err != nil {
 		return err
 	}
 	err = tmpl.Execute(&buff, td)
-	return err
+	if err != nil {
+		return err
+	}
+	// Test the variables used in the attachments as well
+	ac := attachmentContext{td, "http://foo.bar/attachment", "<img src='http://foo.bar/attachment'/>"}
+	for _, a := range t.Attachments {
+		_, err = a.Render(ac)
+		if err != nil {
+			return fmt.Errorf("%s: %s", a.Name, err)
+		}
+	}
+	return t.validateLocalizations()
 }
 
 // GetTemplates returns the templates owned by the given user.
 func GetTemplates(uid int64) ([]Template, error) {
 	ts := []Template{}
-	err := db.Where("user_id=?", uid).Find(&ts).Error
+	err := db.Where("user_id in (?)", teamUserIds(uid)).Find(&ts).Error
 	if err != nil {
 		log.Error(err)
 		return ts, err
@@ -92,6 +108,11 @@ func GetTemplates(uid int64) ([]Template, error) {
 			log.Error(err)
 			return ts, err
 		}
+		err = ts[i].getLocalizations()
+		if err != nil {
+			log.Error(err)
+			return ts, err
+		}
 	}
 	return ts, err
 }
@@ -99,7 +120,7 @@ func GetTemplates(uid int64) ([]Template, error) {
 // GetTemplate returns the template, if it exists, specified by the given id and user_id.
 func GetTemplate(id int64, uid int64) (Template, error) {
 	t := Template{}
-	err := db.Where("user_id=? and id=?", uid, id).Find(&t).Error
+	err := db.Where("user_id in (?) and id=?", teamUserIds(uid), id).Find(&t).Error
 	if err != nil {
 		log.Error(err)
 		return t, err
@@ -114,13 +135,17 @@ func GetTemplate(id int64, uid int64) (Template, error) {
 	if err == nil && len(t.Attachments) == 0 {
 		t.Attachments = make([]Attachment, 0)
 	}
+	err = t.getLocalizations()
+	if err != nil {
+		log.Error(err)
+	}
 	return t, err
 }
 
 // GetTemplateByName returns the template, if it exists, specified by the given name and user_id.
 func GetTemplateByName(n string, uid int64) (Template, error) {
 	t := Template{}
-	err := db.Where("user_id=? and name=?", uid, n).Find(&t).Error
+	err := db.Where("user_id in (?) and name=?", teamUserIds(uid), n).Find(&t).Error
 	if err != nil {
 		log.Error(err)
 		return t, err
@@ -135,6 +160,10 @@ func GetTemplateByName(n string, uid int64) (Template, error) {
 	if err == nil && len(t.Attachments) == 0 {
 		t.Attachments = make([]Attachment, 0)
 	}
+	err = t.getLocalizations()
+	if err != nil {
+		log.Error(err)
+	}
 	return t, err
 }
 
@@ -159,7 +188,7 @@ func PostTemplate(t *Template) error {
 			return err
 		}
 	}
-	return nil
+	return t.saveLocalizations()
 }
 
 // PutTemplate edits an existing template in the database.
@@ -185,6 +214,16 @@ func PutTemplate(t *Template) error {
 			return err
 		}
 	}
+	// Replace the localizations in the same way
+	err = db.Where("template_id=?", t.Id).Delete(&TemplateLocalization{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = t.saveLocalizations()
+	if err != nil {
+		return err
+	}
 
 	// Save final template
 	err = db.Where("id=?", t.Id).Save(t).Error
@@ -205,8 +244,14 @@ func DeleteTemplate(id int64, uid int64) error {
 		return err
 	}
 
+	err = db.Where("template_id=?", id).Delete(&TemplateLocalization{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
 	// Finally, delete the template itself
-	err = db.Where("user_id=?", uid).Delete(Template{Id: id}).Error
+	err = db.Where("user_id in (?)", teamUserIds(uid)).Delete(Template{Id: id}).Error
 	if err != nil {
 		log.Error(err)
 		return err
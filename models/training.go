@@ -0,0 +1,104 @@
+package models
+
+import (
+	"crypto/hmac"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrInvalidTrainingURL is thrown when a campaign's training URL isn't an
+// absolute http or https URL, or a path on the phishing server
+var ErrInvalidTrainingURL = errors.New("Training URL must be an http or https URL, or a path starting with /")
+
+// ErrInvalidTrainingToken is thrown when a training token wasn't signed for
+// any of the campaign's results
+var ErrInvalidTrainingToken = errors.New("Invalid training token")
+
+// validateTrainingURL checks that the training URL is either empty, an
+// absolute http or https URL, or a path on the phishing server
+func validateTrainingURL(s string) error {
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return ErrInvalidTrainingURL
+	}
+	switch {
+	case u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/"):
+		return nil
+	case (u.Scheme == "http" || u.Scheme == "https") && u.Host != "":
+		return nil
+	}
+	return ErrInvalidTrainingURL
+}
+
+// TrainingToken returns the signed token which identifies the result to the
+// campaign's training page. The token is the result's ID followed by the
+// hex-encoded HMAC-SHA256 of the ID using the campaign's training key.
+func (c *Campaign) TrainingToken(r *Result) string {
+	return r.RId + "." + signPayload(c.TrainingKey, []byte(r.RId))
+}
+
+// TrainingRedirectURL returns the campaign's training URL for the result,
+// including the result's training token.
+func (c *Campaign) TrainingRedirectURL(r *Result) (string, error) {
+	u, err := url.Parse(c.TrainingURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(TrainingTokenParameter, c.TrainingToken(r))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// GetResultByTrainingToken returns the result identified by the training
+// token, after checking the token was signed with its campaign's training
+// key.
+func GetResultByTrainingToken(token string) (Result, error) {
+	i := strings.LastIndex(token, ".")
+	if i <= 0 {
+		return Result{}, ErrInvalidTrainingToken
+	}
+	r, err := GetResult(token[:i])
+	if err == gorm.ErrRecordNotFound {
+		return r, ErrInvalidTrainingToken
+	}
+	if err != nil {
+		return r, err
+	}
+	c := Campaign{}
+	err = db.Where("id = ?", r.CampaignId).Find(&c).Error
+	if err == gorm.ErrRecordNotFound {
+		return r, ErrInvalidTrainingToken
+	}
+	if err != nil {
+		return r, err
+	}
+	if c.TrainingKey == "" {
+		return r, ErrInvalidTrainingToken
+	}
+	if !hmac.Equal([]byte(token), []byte(c.TrainingToken(&r))) {
+		return r, ErrInvalidTrainingToken
+	}
+	return r, nil
+}
+
+// HandleTrainingCompleted records that the recipient completed the training
+// they were redirected to. Only the first completion is recorded.
+func (r *Result) HandleTrainingCompleted(details EventDetails) error {
+	if r.TrainingCompleted {
+		return nil
+	}
+	event, err := r.createEvent(EVENT_TRAINING_COMPLETED, details)
+	if err != nil {
+		return err
+	}
+	r.TrainingCompleted = true
+	r.ModifiedDate = event.Time
+	return ResultStorage.Save(r)
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"net/url"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestValidateTrainingURL(ch *check.C) {
+	valid := []string{"", "/training", "https://lms.example.com/course/1", "http://example.com"}
+	for _, u := range valid {
+		ch.Assert(validateTrainingURL(u), check.Equals, nil, check.Commentf(u))
+	}
+	invalid := []string{"training", "ftp://example.com/", "https://", "javascript:alert(1)"}
+	for _, u := range invalid {
+		ch.Assert(validateTrainingURL(u), check.Equals, ErrInvalidTrainingURL, check.Commentf(u))
+	}
+	c := s.createCampaignDependencies(ch)
+	c.TrainingURL = "lms.example.com"
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, ErrInvalidTrainingURL)
+}
+
+func (s *ModelsSuite) TestTrainingRedirectURL(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	c.TrainingURL = "https://lms.example.com/course?id=1"
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+	ch.Assert(c.TrainingKey, check.Not(check.Equals), "")
+	r := c.Results[0]
+	u, err := c.TrainingRedirectURL(&r)
+	ch.Assert(err, check.Equals, nil)
+	parsed, err := url.Parse(u)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(parsed.Host, check.Equals, "lms.example.com")
+	ch.Assert(parsed.Query().Get("id"), check.Equals, "1")
+	token := parsed.Query().Get(TrainingTokenParameter)
+	ch.Assert(token, check.Equals, c.TrainingToken(&r))
+
+	got, err := GetResultByTrainingToken(token)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.RId, check.Equals, r.RId)
+}
+
+func (s *ModelsSuite) TestGetResultByTrainingTokenInvalid(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	c.TrainingURL = "/training"
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+	r := c.Results[0]
+	tokens := []string{
+		"",
+		r.RId,
+		r.RId + ".",
+		r.RId + ".deadbeef",
+		"unknown." + signPayload(c.TrainingKey, []byte("unknown")),
+		// Signed with another key
+		r.RId + "." + signPayload("secret", []byte(r.RId)),
+	}
+	for _, token := range tokens {
+		_, err := GetResultByTrainingToken(token)
+		ch.Assert(err, check.Equals, ErrInvalidTrainingToken, check.Commentf(token))
+	}
+}
+
+func (s *ModelsSuite) TestHandleTrainingCompleted(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	c.TrainingURL = "/training"
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+	r := c.Results[0]
+	ch.Assert(r.HandleTrainingCompleted(EventDetails{}), check.Equals, nil)
+	ch.Assert(r.TrainingCompleted, check.Equals, true)
+	// Completing the training again isn't recorded twice
+	r, err := GetResult(r.RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(r.TrainingCompleted, check.Equals, true)
+	ch.Assert(r.HandleTrainingCompleted(EventDetails{}), check.Equals, nil)
+	var count int
+	err = db.Model(&Event{}).Where("campaign_id=? and message=?", c.Id, EVENT_TRAINING_COMPLETED).Count(&count).Error
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(count, check.Equals, 1)
+
+	stats, err := getCampaignStats(c.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(stats.TrainingCompleted, check.Equals, int64(1))
+	ch.Assert(NormalizeRates(stats).TrainingCompleted, check.Equals, 100*1/float64(stats.Total))
+}
@@ -0,0 +1,340 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The intervals trend statistics can be grouped by
+const (
+	INTERVAL_DAY   string = "day"
+	INTERVAL_WEEK  string = "week"
+	INTERVAL_MONTH string = "month"
+)
+
+// DefaultTrendAttribute is the target attribute results are grouped by when
+// no attribute is given for attribute trends.
+const DefaultTrendAttribute = "department"
+
+// UnknownTrendValue is the value results without the grouped attribute are
+// counted under.
+const UnknownTrendValue = "Unknown"
+
+// ErrInvalidTrendInterval is thrown when trend statistics are grouped by an
+// interval which isn't day, week or month
+var ErrInvalidTrendInterval = errors.New("Interval must be day, week or month")
+
+// TrendFilter selects the campaigns statistics are aggregated across, which
+// are those with the given ids, if any, launched between Since and Until.
+// Trends are grouped by the given interval, which defaults to month, and
+// attribute trends group results by the given target attribute, which
+// defaults to DefaultTrendAttribute. Campaigns which haven't launched yet, and
+// dry runs, are never included.
+type TrendFilter struct {
+	CampaignIds []int64
+	Since       time.Time
+	Until       time.Time
+	Interval    string
+	Attribute   string
+}
+
+// CampaignComparison contains the summaries of a set of campaigns, in the
+// order they were launched, along with their combined statistics.
+type CampaignComparison struct {
+	Campaigns  []CampaignSummary `json:"campaigns"`
+	Total      CampaignStats     `json:"total"`
+	Normalized NormalizedRates   `json:"normalized_stats"`
+}
+
+// TrendBucket contains the combined statistics of the campaigns launched
+// within a single interval. Change is the difference between the bucket's
+// rates and those of the closest earlier bucket with any targets, such as an
+// improvement in the report rate, and is empty for the first bucket.
+type TrendBucket struct {
+	Start      time.Time       `json:"start"`
+	Campaigns  int             `json:"campaigns"`
+	Stats      CampaignStats   `json:"stats"`
+	Normalized NormalizedRates `json:"normalized_stats"`
+	Change     NormalizedRates `json:"change"`
+}
+
+// AttributeTrend contains the trend of the results whose target had a value
+// of the grouped attribute, such as every result in a department.
+type AttributeTrend struct {
+	Value   string        `json:"value"`
+	Buckets []TrendBucket `json:"buckets"`
+}
+
+// add counts the result in the statistics, in the same way as
+// getCampaignStats, so that each status implies the statuses before it.
+func (s *CampaignStats) add(r *Result) {
+	s.Total++
+	switch r.Status {
+	case EVENT_MFA_SUBMIT:
+		s.SubmittedMFA++
+		fallthrough
+	case EVENT_DATA_SUBMIT:
+		s.SubmittedData++
+		fallthrough
+	case EVENT_CLICKED:
+		s.ClickedLink++
+		fallthrough
+	case EVENT_OPENED:
+		s.OpenedEmail++
+		fallthrough
+	case EVENT_SENT:
+		s.EmailsSent++
+	case EVENT_BOUNCED:
+		s.Bounced++
+	case ERROR:
+		s.Error++
+	}
+	if r.Reported {
+		s.EmailReported++
+	}
+	if r.TrainingCompleted {
+		s.TrainingCompleted++
+	}
+}
+
+// merge adds the given statistics to these
+func (s *CampaignStats) merge(o CampaignStats) {
+	s.Total += o.Total
+	s.EmailsSent += o.EmailsSent
+	s.OpenedEmail += o.OpenedEmail
+	s.ClickedLink += o.ClickedLink
+	s.SubmittedData += o.SubmittedData
+	s.SubmittedMFA += o.SubmittedMFA
+	s.EmailReported += o.EmailReported
+	s.Bounced += o.Bounced
+	s.Error += o.Error
+	s.TrainingCompleted += o.TrainingCompleted
+}
+
+// rateChange returns the difference between the rates
+func rateChange(current NormalizedRates, previous NormalizedRates) NormalizedRates {
+	return NormalizedRates{
+		EmailsSent:        current.EmailsSent - previous.EmailsSent,
+		OpenedEmail:       current.OpenedEmail - previous.OpenedEmail,
+		ClickedLink:       current.ClickedLink - previous.ClickedLink,
+		SubmittedData:     current.SubmittedData - previous.SubmittedData,
+		EmailReported:     current.EmailReported - previous.EmailReported,
+		Error:             current.Error - previous.Error,
+		TrainingCompleted: current.TrainingCompleted - previous.TrainingCompleted,
+	}
+}
+
+// intervalStart returns the start of the interval containing the time. Weeks
+// start on Monday, and intervals are aligned to UTC.
+func intervalStart(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch interval {
+	case INTERVAL_WEEK:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case INTERVAL_MONTH:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day
+}
+
+// nextInterval returns the start of the interval after the one starting at
+// the given time
+func nextInterval(start time.Time, interval string) time.Time {
+	switch interval {
+	case INTERVAL_WEEK:
+		return start.AddDate(0, 0, 7)
+	case INTERVAL_MONTH:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// validate fills in the filter's defaults and checks its interval
+func (f *TrendFilter) validate() error {
+	if f.Interval == "" {
+		f.Interval = INTERVAL_MONTH
+	}
+	switch f.Interval {
+	case INTERVAL_DAY, INTERVAL_WEEK, INTERVAL_MONTH:
+	default:
+		return ErrInvalidTrendInterval
+	}
+	if f.Attribute == "" {
+		f.Attribute = DefaultTrendAttribute
+	}
+	now := time.Now().UTC()
+	if f.Until.IsZero() || f.Until.After(now) {
+		f.Until = now
+	}
+	f.Since, f.Until = f.Since.UTC(), f.Until.UTC()
+	return nil
+}
+
+// campaigns returns the campaigns owned by the given user which the filter
+// selects, in the order they were launched.
+func (f *TrendFilter) campaigns(uid int64) ([]Campaign, error) {
+	cs := []Campaign{}
+	query := db.Where("user_id in (?) and status <> ?", teamUserIds(uid), CAMPAIGN_DRY_RUN).
+		Where("launch_date <= ?", f.Until)
+	if !f.Since.IsZero() {
+		query = query.Where("launch_date >= ?", f.Since)
+	}
+	if len(f.CampaignIds) > 0 {
+		query = query.Where("id in (?)", f.CampaignIds)
+	}
+	err := query.Order("launch_date asc, id asc").Find(&cs).Error
+	return cs, err
+}
+
+// buckets returns the empty buckets of the filter's interval from the one
+// containing the given start time to the one containing Until.
+func (f *TrendFilter) buckets(start time.Time) []TrendBucket {
+	bs := []TrendBucket{}
+	if !f.Since.IsZero() {
+		start = f.Since
+	}
+	for t := intervalStart(start, f.Interval); !t.After(f.Until); t = nextInterval(t, f.Interval) {
+		bs = append(bs, TrendBucket{Start: t})
+	}
+	return bs
+}
+
+// bucketIndex returns the index of the bucket containing the time
+func bucketIndex(bs []TrendBucket, t time.Time) int {
+	return sort.Search(len(bs), func(i int) bool { return bs[i].Start.After(t) }) - 1
+}
+
+// finishBuckets calculates the rates of each bucket, and the change from the
+// closest earlier bucket with any targets.
+func finishBuckets(bs []TrendBucket) {
+	var previous *NormalizedRates
+	for i := range bs {
+		if bs[i].Stats.Total == 0 {
+			continue
+		}
+		bs[i].Normalized = NormalizeRates(bs[i].Stats)
+		if previous != nil {
+			bs[i].Change = rateChange(bs[i].Normalized, *previous)
+		}
+		previous = &bs[i].Normalized
+	}
+}
+
+// CompareCampaigns returns the summaries and combined statistics of the
+// campaigns owned by the given user which the filter selects.
+func CompareCampaigns(uid int64, f TrendFilter) (CampaignComparison, error) {
+	cc := CampaignComparison{Campaigns: []CampaignSummary{}}
+	err := f.validate()
+	if err != nil {
+		return cc, err
+	}
+	cs, err := f.campaigns(uid)
+	if err != nil {
+		return cc, err
+	}
+	for _, c := range cs {
+		s, err := GetCampaignSummary(c.Id, uid)
+		if err != nil {
+			return cc, err
+		}
+		cc.Campaigns = append(cc.Campaigns, s)
+		cc.Total.merge(s.Stats)
+	}
+	cc.Normalized = NormalizeRates(cc.Total)
+	return cc, nil
+}
+
+// GetCampaignTrends returns the combined statistics of the campaigns owned by
+// the given user which the filter selects, grouped by the interval they were
+// launched in, such as the click rate of each month's campaigns. Intervals
+// without any campaigns are included, with empty statistics.
+func GetCampaignTrends(uid int64, f TrendFilter) ([]TrendBucket, error) {
+	err := f.validate()
+	if err != nil {
+		return []TrendBucket{}, err
+	}
+	cs, err := f.campaigns(uid)
+	if err != nil || len(cs) == 0 {
+		return []TrendBucket{}, err
+	}
+	bs := f.buckets(cs[0].LaunchDate)
+	for _, c := range cs {
+		i := bucketIndex(bs, c.LaunchDate)
+		if i < 0 {
+			continue
+		}
+		s, err := ResultStorage.Summary(c.Id)
+		if err != nil {
+			return bs, err
+		}
+		bs[i].Campaigns++
+		bs[i].Stats.merge(s)
+	}
+	finishBuckets(bs)
+	return bs, nil
+}
+
+// GetAttributeTrends returns the trends of the campaigns owned by the given
+// user which the filter selects for each value of the filter's target
+// attribute, such as the click rate of each department over time. Attribute
+// names are matched regardless of case, and results without the attribute
+// are grouped under UnknownTrendValue. The trends are ordered by value.
+func GetAttributeTrends(uid int64, f TrendFilter) ([]AttributeTrend, error) {
+	ats := []AttributeTrend{}
+	err := f.validate()
+	if err != nil {
+		return ats, err
+	}
+	cs, err := f.campaigns(uid)
+	if err != nil || len(cs) == 0 {
+		return ats, err
+	}
+	first := cs[0].LaunchDate
+	trends := make(map[string][]TrendBucket)
+	for _, c := range cs {
+		rs, err := ResultStorage.List(c.Id, uid)
+		if err != nil {
+			return ats, err
+		}
+		counted := make(map[string]bool)
+		for i := range rs {
+			r := &rs[i]
+			if !r.inReport() {
+				continue
+			}
+			attrs, err := r.Attributes()
+			if err != nil {
+				return ats, err
+			}
+			value := UnknownTrendValue
+			for k, v := range attrs {
+				if strings.EqualFold(k, f.Attribute) && strings.TrimSpace(v) != "" {
+					value = strings.TrimSpace(v)
+				}
+			}
+			bs, ok := trends[value]
+			if !ok {
+				bs = f.buckets(first)
+				trends[value] = bs
+			}
+			j := bucketIndex(bs, c.LaunchDate)
+			if j < 0 {
+				continue
+			}
+			bs[j].Stats.add(r)
+			if !counted[value] {
+				bs[j].Campaigns++
+				counted[value] = true
+			}
+		}
+	}
+	for value, bs := range trends {
+		finishBuckets(bs)
+		ats = append(ats, AttributeTrend{Value: value, Buckets: bs})
+	}
+	sort.Slice(ats, func(i, j int) bool { return ats[i].Value < ats[j].Value })
+	return ats, nil
+}
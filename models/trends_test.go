@@ -0,0 +1,102 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+// launchCampaignAt moves the campaign's launch date to the given time
+func launchCampaignAt(ch *check.C, c *Campaign, t time.Time) {
+	c.LaunchDate = t
+	ch.Assert(db.Model(c).UpdateColumn("launch_date", t).Error, check.Equals, nil)
+}
+
+func (s *ModelsSuite) TestCampaignStatsAdd(ch *check.C) {
+	c := s.createCampaignWithTargets(ch, generateTargets(4))
+	rs := c.Results
+	ch.Assert(rs[0].HandleEmailOpened(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[2].HandleFormSubmit(EventDetails{}), check.Equals, nil)
+	ch.Assert(rs[3].HandleEmailReport(EventDetails{}), check.Equals, nil)
+
+	rs, err := ResultStorage.List(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	stats := CampaignStats{}
+	for i := range rs {
+		stats.add(&rs[i])
+	}
+	expected, err := ResultStorage.Summary(c.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(stats, check.Equals, expected)
+}
+
+func (s *ModelsSuite) TestGetCampaignTrends(ch *check.C) {
+	c1 := s.createCampaignWithTargets(ch, generateTargets(2))
+	c2 := s.createCampaignWithTargets(ch, generateTargets(2))
+	c3 := s.createCampaignWithTargets(ch, generateTargets(2))
+	launchCampaignAt(ch, &c1, time.Date(2018, 1, 10, 12, 0, 0, 0, time.UTC))
+	launchCampaignAt(ch, &c2, time.Date(2018, 3, 5, 12, 0, 0, 0, time.UTC))
+	launchCampaignAt(ch, &c3, time.Date(2018, 3, 20, 12, 0, 0, 0, time.UTC))
+	ch.Assert(c1.Results[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(c1.Results[1].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(c2.Results[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+	ch.Assert(c3.Results[0].HandleEmailReport(EventDetails{}), check.Equals, nil)
+
+	f := TrendFilter{Until: time.Date(2018, 3, 31, 0, 0, 0, 0, time.UTC)}
+	bs, err := GetCampaignTrends(c1.UserId, f)
+	ch.Assert(err, check.Equals, nil)
+	// Months without any campaigns are included
+	ch.Assert(len(bs), check.Equals, 3)
+	ch.Assert(bs[0].Start, check.Equals, time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	ch.Assert(bs[0].Campaigns, check.Equals, 1)
+	ch.Assert(bs[0].Normalized.ClickedLink, check.Equals, 100.0)
+	ch.Assert(bs[1].Campaigns, check.Equals, 0)
+	ch.Assert(bs[2].Campaigns, check.Equals, 2)
+	ch.Assert(bs[2].Stats.Total, check.Equals, int64(4))
+	ch.Assert(bs[2].Normalized.ClickedLink, check.Equals, 25.0)
+	// The change is from the closest earlier month with any targets
+	ch.Assert(bs[2].Change.ClickedLink, check.Equals, -75.0)
+	ch.Assert(bs[2].Change.EmailReported, check.Equals, 25.0)
+
+	// Trends can be limited to a set of campaigns and grouped by week
+	f = TrendFilter{CampaignIds: []int64{c2.Id, c3.Id}, Interval: INTERVAL_WEEK, Until: f.Until}
+	bs, err = GetCampaignTrends(c1.UserId, f)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(bs[0].Start, check.Equals, time.Date(2018, 3, 5, 0, 0, 0, 0, time.UTC))
+	ch.Assert(len(bs), check.Equals, 4)
+	ch.Assert(bs[2].Campaigns, check.Equals, 1)
+
+	cc, err := CompareCampaigns(c1.UserId, f)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(cc.Campaigns), check.Equals, 2)
+	ch.Assert(cc.Campaigns[0].Id, check.Equals, c2.Id)
+	ch.Assert(cc.Total.Total, check.Equals, int64(4))
+	ch.Assert(cc.Normalized.ClickedLink, check.Equals, 25.0)
+
+	_, err = GetCampaignTrends(c1.UserId, TrendFilter{Interval: "year"})
+	ch.Assert(err, check.Equals, ErrInvalidTrendInterval)
+}
+
+func (s *ModelsSuite) TestGetAttributeTrends(ch *check.C) {
+	c := s.createCampaignWithTargets(ch, generateTargets(3))
+	launchCampaignAt(ch, &c, time.Date(2018, 2, 1, 12, 0, 0, 0, time.UTC))
+	rs := c.Results
+	rs[0].AttributesJSON = `{"Department":"Finance"}`
+	rs[1].AttributesJSON = `{"department":"Finance"}`
+	for i := range rs[:2] {
+		ch.Assert(db.Save(&rs[i]).Error, check.Equals, nil)
+	}
+	ch.Assert(rs[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+
+	ats, err := GetAttributeTrends(c.UserId, TrendFilter{Until: time.Date(2018, 2, 28, 0, 0, 0, 0, time.UTC)})
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(ats), check.Equals, 2)
+	ch.Assert(ats[0].Value, check.Equals, "Finance")
+	ch.Assert(len(ats[0].Buckets), check.Equals, 1)
+	ch.Assert(ats[0].Buckets[0].Campaigns, check.Equals, 1)
+	ch.Assert(ats[0].Buckets[0].Stats.Total, check.Equals, int64(2))
+	ch.Assert(ats[0].Buckets[0].Normalized.ClickedLink, check.Equals, 50.0)
+	ch.Assert(ats[1].Value, check.Equals, UnknownTrendValue)
+	ch.Assert(ats[1].Buckets[0].Stats.Total, check.Equals, int64(1))
+}
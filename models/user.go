@@ -1,11 +1,55 @@
 package models
 
+import "github.com/jinzhu/gorm"
+
 // User represents the user model for gophish.
 type User struct {
 	Id       int64  `json:"id"`
 	Username string `json:"username" sql:"not null;unique"`
 	Hash     string `json:"-"`
 	ApiKey   string `json:"api_key" sql:"not null;unique"`
+	Role     string `json:"role"`
+	TeamId   int64  `json:"team_id"`
+
+	TOTPEnabled       bool   `json:"totp_enabled" gorm:"column:totp_enabled"`
+	TOTPSecret        string `json:"-" gorm:"column:totp_secret"`
+	TOTPRecoveryCodes string `json:"-" gorm:"column:totp_recovery_codes"`
+	TOTPLastCounter   int64  `json:"-" gorm:"column:totp_last_counter"`
+
+	// SSOSubject identifies the user at the single sign-on provider they
+	// log in through, if any
+	SSOSubject string `json:"-" gorm:"column:sso_subject"`
+}
+
+// IsAdmin returns whether or not the user can manage users and teams
+func (u *User) IsAdmin() bool {
+	return u.Role == ROLE_ADMIN
+}
+
+// CanWrite returns whether or not the user can create, edit or delete
+// campaigns and their resources. Auditors have read-only access.
+func (u *User) CanWrite() bool {
+	return u.Role != ROLE_AUDITOR
+}
+
+// Validate ensures that the user has a known role and, if they're in a team,
+// that the team exists.
+func (u *User) Validate() error {
+	switch u.Role {
+	case ROLE_ADMIN, ROLE_OPERATOR, ROLE_AUDITOR:
+	default:
+		return ErrInvalidRole
+	}
+	if u.TeamId != 0 {
+		_, err := GetTeam(u.TeamId)
+		if err == gorm.ErrRecordNotFound {
+			return ErrTeamNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // GetUser returns the user that the given id corresponds to. If no user is found, an
@@ -16,6 +60,13 @@ func GetUser(id int64) (User, error) {
 	return u, err
 }
 
+// GetUsers returns all of the users
+func GetUsers() ([]User, error) {
+	us := []User{}
+	err := db.Order("id asc").Find(&us).Error
+	return us, err
+}
+
 // GetUserByAPIKey returns the user that the given API Key corresponds to. If no user is found, an
 // error is thrown.
 func GetUserByAPIKey(key string) (User, error) {
@@ -32,6 +83,14 @@ func GetUserByUsername(username string) (User, error) {
 	return u, err
 }
 
+// GetUserBySSOSubject returns the user linked to the given single sign-on
+// subject. If no user is found, an error is thrown.
+func GetUserBySSOSubject(subject string) (User, error) {
+	u := User{}
+	err := db.Where("sso_subject = ?", subject).First(&u).Error
+	return u, err
+}
+
 // PutUser updates the given user
 func PutUser(u *User) error {
 	err := db.Save(u).Error
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+// The types of issues a campaign validation pass can report for a result
+const (
+	ISSUE_INVALID_EMAIL string = "invalid_email"
+	ISSUE_DUPLICATE     string = "duplicate"
+	ISSUE_SUPPRESSED    string = "suppressed"
+	ISSUE_OFF_DOMAIN    string = "off_domain"
+	ISSUE_NO_MX         string = "no_mx"
+	ISSUE_NAME_MISMATCH string = "name_mismatch"
+)
+
+// SuppressedEmails are the email addresses which should never be sent to,
+// such as executives or addresses which have opted out. Campaigns including
+// them are flagged when validated.
+var SuppressedEmails = []string{}
+
+// ErrInvalidSuppressedEmail is thrown when one of the configured suppressed
+// emails isn't a valid email address
+var ErrInvalidSuppressedEmail = errors.New("Suppressed emails must be valid email addresses")
+
+// configureSuppressedEmails sets the email addresses which should never be
+// sent to, returning an error if any of them aren't valid.
+func configureSuppressedEmails(emails []string) error {
+	suppressed := []string{}
+	for _, e := range emails {
+		a, err := mail.ParseAddress(e)
+		if err != nil {
+			return ErrInvalidSuppressedEmail
+		}
+		suppressed = append(suppressed, a.Address)
+	}
+	SuppressedEmails = suppressed
+	return nil
+}
+
+// ValidationIssue is a single problem found with a result during a campaign
+// validation pass.
+type ValidationIssue struct {
+	RId   string `json:"id"`
+	Email string `json:"email"`
+	Issue string `json:"issue"`
+}
+
+// ValidationReport contains every issue found with the results of a campaign,
+// along with the number of results which were checked.
+type ValidationReport struct {
+	Total  int               `json:"total"`
+	Issues []ValidationIssue `json:"issues"`
+}
+
+// Valid returns whether or not the validation pass found no issues.
+func (vr *ValidationReport) Valid() bool {
+	return len(vr.Issues) == 0
+}
+
+// emailDomain returns the normalized domain of the email address, or an empty
+// string if it doesn't have one.
+func emailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i == -1 {
+		return ""
+	}
+	return normalizeEmail(email[i+1:])
+}
+
+// ValidateCampaignResults checks every result in the campaign specified by the
+// given id and user_id before it's launched, returning a report of the issues
+// found. Results are checked for an invalid email address, another result with
+// the same address, an address in SuppressedEmails, an address outside of
+// CorporateDomains (if any are configured), a domain without MX records (if
+// ProviderMXLookup is enabled), and a name which doesn't match the address. A
+// result may have more than one issue.
+func ValidateCampaignResults(cid int64, uid int64) (ValidationReport, error) {
+	vr := ValidationReport{Issues: []ValidationIssue{}}
+	rs, err := ResultStorage.List(cid, uid)
+	if err != nil {
+		return vr, err
+	}
+	vr.Total = len(rs)
+	suppressed := make(map[string]bool)
+	for _, e := range SuppressedEmails {
+		suppressed[normalizeEmail(e)] = true
+	}
+	seen := make(map[string]bool)
+	mx := make(map[string]bool)
+	for _, r := range rs {
+		flag := func(issue string) {
+			vr.Issues = append(vr.Issues, ValidationIssue{RId: r.RId, Email: r.Email, Issue: issue})
+		}
+		email := normalizeEmail(r.Email)
+		if seen[email] {
+			flag(ISSUE_DUPLICATE)
+		}
+		seen[email] = true
+		if suppressed[email] {
+			flag(ISSUE_SUPPRESSED)
+		}
+		if r.NameEmailMismatch() {
+			flag(ISSUE_NAME_MISMATCH)
+		}
+		if r.Validate() != nil {
+			flag(ISSUE_INVALID_EMAIL)
+			continue
+		}
+		domain := emailDomain(r.Email)
+		if len(CorporateDomains) > 0 && !matchesDomain(domain, CorporateDomains) {
+			flag(ISSUE_OFF_DOMAIN)
+		}
+		if !ProviderMXLookup {
+			continue
+		}
+		ok, checked := mx[domain]
+		if !checked {
+			mxs, err := lookupMX(domain)
+			ok = err == nil && len(mxs) > 0
+			mx[domain] = ok
+		}
+		if !ok {
+			flag(ISSUE_NO_MX)
+		}
+	}
+	return vr, nil
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"errors"
+	"net"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestValidateCampaignResults(ch *check.C) {
+	ts := generateTargets(7)
+	ts[3].Email = "target3@gmail.com"
+	ts[4].FirstName, ts[4].LastName, ts[4].Email = "Alice", "Smith", "bob.jones@example.com"
+	ts[5].Email = "target5@nomx.example.com"
+	campaign := s.createCampaignWithTargets(ch, ts)
+	// Simulate a recipient which was stored before addresses were validated
+	err := db.Model(&Result{}).Where("email=?", ts[6].Email).
+		Updates(map[string]interface{}{"email": "John Smith <john", "first_name": "", "last_name": ""}).Error
+	ch.Assert(err, check.Equals, nil)
+	// Duplicates are skipped when the campaign is created, so simulate one
+	// which was introduced afterwards
+	err = db.Model(&Result{}).Where("email=?", ts[1].Email).Update("email", "TARGET0@example.com").Error
+	ch.Assert(err, check.Equals, nil)
+
+	defer func(suppressed, corporate []string, mxLookup bool, lookup func(string) ([]*net.MX, error)) {
+		SuppressedEmails, CorporateDomains, ProviderMXLookup, lookupMX = suppressed, corporate, mxLookup, lookup
+	}(SuppressedEmails, CorporateDomains, ProviderMXLookup, lookupMX)
+	ch.Assert(configureSuppressedEmails([]string{"not an address"}), check.Equals, ErrInvalidSuppressedEmail)
+	ch.Assert(configureSuppressedEmails([]string{"Target Two <Target2@Example.com>"}), check.Equals, nil)
+	CorporateDomains = []string{"example.com"}
+	ProviderMXLookup = true
+	lookups := map[string]int{}
+	lookupMX = func(domain string) ([]*net.MX, error) {
+		lookups[domain]++
+		if domain == "nomx.example.com" {
+			return nil, errors.New("no such host")
+		}
+		return []*net.MX{&net.MX{Host: "mx." + domain}}, nil
+	}
+
+	vr, err := ValidateCampaignResults(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(vr.Total, check.Equals, 7)
+	ch.Assert(vr.Valid(), check.Equals, false)
+	issues := map[string]string{}
+	for _, i := range vr.Issues {
+		ch.Assert(i.RId, check.Not(check.Equals), "")
+		issues[i.Email] = i.Issue
+	}
+	ch.Assert(issues, check.DeepEquals, map[string]string{
+		"TARGET0@example.com":      ISSUE_DUPLICATE,
+		"target2@example.com":      ISSUE_SUPPRESSED,
+		"target3@gmail.com":        ISSUE_OFF_DOMAIN,
+		"bob.jones@example.com":    ISSUE_NAME_MISMATCH,
+		"target5@nomx.example.com": ISSUE_NO_MX,
+		"John Smith <john":         ISSUE_INVALID_EMAIL,
+	})
+	ch.Assert(len(vr.Issues), check.Equals, 6)
+	// Each domain is only looked up once
+	ch.Assert(lookups["example.com"], check.Equals, 1)
+}
+
+func (s *ModelsSuite) TestValidateCampaignResultsValid(ch *check.C) {
+	campaign := s.createCampaignWithTargets(ch, generateTargets(3))
+	vr, err := ValidateCampaignResults(campaign.Id, campaign.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(vr.Total, check.Equals, 3)
+	ch.Assert(vr.Valid(), check.Equals, true)
+}
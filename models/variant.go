@@ -0,0 +1,165 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// CampaignVariant is one of the combinations of email template and landing
+// page which a campaign's targets can be assigned. Each result is assigned a
+// variant at random in proportion to its weight, so that the performance of
+// each lure can be compared within a single campaign.
+type CampaignVariant struct {
+	Id         int64    `json:"id"`
+	CampaignId int64    `json:"-"`
+	Name       string   `json:"name"`
+	TemplateId int64    `json:"-"`
+	Template   Template `json:"template" sql:"-"`
+	PageId     int64    `json:"-"`
+	Page       Page     `json:"page" sql:"-"`
+	Weight     int      `json:"weight"`
+}
+
+// ErrInvalidVariantWeight is thrown when a campaign variant has a negative
+// weight
+var ErrInvalidVariantWeight = errors.New("Variant weight must not be negative")
+
+// variantSource is the source of randomness used to assign variants. It can
+// be reseeded to make the assignments reproducible.
+var variantSource = mathrand.New(newLockedSource())
+
+// validateVariants checks that every variant names a template and landing
+// page, and has a valid weight. Variants without a weight are given a weight
+// of 1.
+func (c *Campaign) validateVariants() error {
+	for i := range c.Variants {
+		v := &c.Variants[i]
+		switch {
+		case v.Template.Name == "":
+			return ErrTemplateNotSpecified
+		case v.Page.Name == "":
+			return ErrPageNotSpecified
+		case v.Weight < 0:
+			return ErrInvalidVariantWeight
+		}
+		if v.Weight == 0 {
+			v.Weight = 1
+		}
+	}
+	return nil
+}
+
+// lookupVariants fills in the template and landing page of each variant from
+// those owned by the given user.
+func (c *Campaign) lookupVariants(uid int64) error {
+	for i := range c.Variants {
+		v := &c.Variants[i]
+		t, err := GetTemplateByName(v.Template.Name, uid)
+		if err == gorm.ErrRecordNotFound {
+			log.WithFields(logrus.Fields{
+				"template": v.Template.Name,
+			}).Error("Template does not exist")
+			return ErrTemplateNotFound
+		} else if err != nil {
+			log.Error(err)
+			return err
+		}
+		v.Template = t
+		v.TemplateId = t.Id
+		p, err := GetPageByName(v.Page.Name, uid)
+		if err == gorm.ErrRecordNotFound {
+			log.WithFields(logrus.Fields{
+				"page": v.Page.Name,
+			}).Error("Page does not exist")
+			return ErrPageNotFound
+		} else if err != nil {
+			log.Error(err)
+			return err
+		}
+		v.Page = p
+		v.PageId = p.Id
+		if v.Name == "" {
+			v.Name = fmt.Sprintf("Variant %c", 'A'+i)
+		}
+	}
+	return nil
+}
+
+// getVariants retrieves the campaign's variants and their templates and
+// landing pages from the database.
+func (c *Campaign) getVariants() error {
+	err := db.Where("campaign_id=?", c.Id).Order("id asc").Find(&c.Variants).Error
+	if err != nil {
+		return err
+	}
+	for i := range c.Variants {
+		v := &c.Variants[i]
+		err = db.Table("templates").Where("id=?", v.TemplateId).Find(&v.Template).Error
+		if err != nil {
+			if err != gorm.ErrRecordNotFound {
+				return err
+			}
+			v.Template = Template{Name: "[Deleted]"}
+			log.Warnf("%s: template not found for campaign variant", err)
+		}
+		err = db.Where("template_id=?", v.Template.Id).Find(&v.Template.Attachments).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		err = v.Template.getLocalizations()
+		if err != nil {
+			return err
+		}
+		err = db.Table("pages").Where("id=?", v.PageId).Find(&v.Page).Error
+		if err != nil {
+			if err != gorm.ErrRecordNotFound {
+				return err
+			}
+			v.Page = Page{Name: "[Deleted]"}
+			log.Warnf("%s: page not found for campaign variant", err)
+		}
+	}
+	return nil
+}
+
+// pickVariant returns the id of a variant chosen at random in proportion to
+// the variants' weights, or 0 if there are no variants.
+func (c *Campaign) pickVariant() int64 {
+	total := 0
+	for _, v := range c.Variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return 0
+	}
+	n := variantSource.Intn(total)
+	for _, v := range c.Variants {
+		if n < v.Weight {
+			return v.Id
+		}
+		n -= v.Weight
+	}
+	return 0
+}
+
+// VariantFor returns the variant assigned to the given result. Results which
+// weren't assigned a variant use the campaign's template and landing page.
+func (c *Campaign) VariantFor(r *Result) CampaignVariant {
+	for _, v := range c.Variants {
+		if r.VariantId != 0 && v.Id == r.VariantId {
+			return v
+		}
+	}
+	return CampaignVariant{
+		CampaignId: c.Id,
+		TemplateId: c.TemplateId,
+		Template:   c.Template,
+		PageId:     c.PageId,
+		Page:       c.Page,
+	}
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gophish/gomail"
+	"github.com/jordan-wright/email"
+	"gopkg.in/check.v1"
+)
+
+// createVariantCampaign creates a campaign with two variants, the second of
+// which is three times as likely to be assigned.
+func (s *ModelsSuite) createVariantCampaign(ch *check.C, ts []Target) Campaign {
+	c := s.createCampaignDependencies(ch)
+	g := c.Groups[0]
+	g.Targets = ts
+	ch.Assert(PutGroup(&g), check.Equals, nil)
+	c.Groups = []Group{g}
+
+	t := Template{Name: "Variant Template", UserId: 1}
+	t.Subject = "{{.FirstName}} - Variant Subject"
+	t.Text = "Variant Text"
+	ch.Assert(PostTemplate(&t), check.Equals, nil)
+	p := Page{Name: "Variant Page", UserId: 1, HTML: "<html>Variant</html>"}
+	ch.Assert(PostPage(&p), check.Equals, nil)
+
+	c.Template = Template{}
+	c.Page = Page{}
+	c.Variants = []CampaignVariant{
+		CampaignVariant{Template: Template{Name: "Test Template"}, Page: Page{Name: "Test Page"}},
+		CampaignVariant{Name: "Lure", Template: Template{Name: t.Name}, Page: Page{Name: p.Name}, Weight: 3},
+	}
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, nil)
+	return c
+}
+
+func (s *ModelsSuite) TestCampaignVariantValidate(ch *check.C) {
+	c := s.createCampaignDependencies(ch)
+	c.Variants = []CampaignVariant{CampaignVariant{Page: Page{Name: "Test Page"}}}
+	ch.Assert(c.Validate(), check.Equals, ErrTemplateNotSpecified)
+	c.Variants = []CampaignVariant{CampaignVariant{Template: Template{Name: "Test Template"}}}
+	ch.Assert(c.Validate(), check.Equals, ErrPageNotSpecified)
+	c.Variants = []CampaignVariant{
+		CampaignVariant{Template: Template{Name: "Test Template"}, Page: Page{Name: "Test Page"}, Weight: -1},
+	}
+	ch.Assert(c.Validate(), check.Equals, ErrInvalidVariantWeight)
+
+	c.Variants = []CampaignVariant{
+		CampaignVariant{Template: Template{Name: "Missing"}, Page: Page{Name: "Test Page"}},
+	}
+	ch.Assert(PostCampaign(&c, c.UserId), check.Equals, ErrTemplateNotFound)
+}
+
+func (s *ModelsSuite) TestPostCampaignVariants(ch *check.C) {
+	variantSource.Seed(1)
+	c := s.createVariantCampaign(ch, generateTargets(200))
+	ch.Assert(c.Template.Name, check.Equals, "Test Template")
+	ch.Assert(c.Page.Name, check.Equals, "Test Page")
+	ch.Assert(c.Variants[0].Name, check.Equals, "Variant A")
+	ch.Assert(c.Variants[0].Weight, check.Equals, 1)
+
+	got, err := GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(got.Variants), check.Equals, 2)
+	ch.Assert(got.Variants[1].Name, check.Equals, "Lure")
+	ch.Assert(got.Variants[1].Template.Name, check.Equals, "Variant Template")
+	ch.Assert(got.Variants[1].Page.Name, check.Equals, "Variant Page")
+
+	// Variants are assigned in proportion to their weights
+	counts := make(map[int64]int)
+	for _, r := range got.Results {
+		counts[r.VariantId]++
+	}
+	ch.Assert(len(counts), check.Equals, 2)
+	lure := counts[got.Variants[1].Id]
+	ch.Assert(lure > 120 && lure < 180, check.Equals, true, check.Commentf("%d results given the lure", lure))
+}
+
+func (s *ModelsSuite) TestMailLogGenerateVariant(ch *check.C) {
+	c := s.createVariantCampaign(ch, generateTargets(20))
+	c, err := GetCampaign(c.Id, c.UserId)
+	ch.Assert(err, check.Equals, nil)
+	var result Result
+	for _, r := range c.Results {
+		if r.VariantId == c.Variants[1].Id {
+			result = r
+			break
+		}
+	}
+	ch.Assert(result.RId, check.Not(check.Equals), "")
+	ch.Assert(c.VariantFor(&result).Page.Name, check.Equals, "Variant Page")
+
+	m := &MailLog{}
+	err = db.Where("r_id=?", result.RId).Find(m).Error
+	ch.Assert(err, check.Equals, nil)
+	msg := gomail.NewMessage()
+	ch.Assert(m.Generate(msg), check.Equals, nil)
+	msgBuff := &bytes.Buffer{}
+	_, err = msg.WriteTo(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	got, err := email.NewEmailFromReader(msgBuff)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.Subject, check.Equals, "Target - Variant Subject")
+	ch.Assert(string(got.Text), check.Equals, "Variant Text")
+
+	// The variant is recorded in the details of the result's events
+	ch.Assert(result.HandleEmailSent(), check.Equals, nil)
+	e := Event{}
+	err = db.Where("campaign_id=? and email=? and message=?", c.Id, result.Email, EVENT_SENT).First(&e).Error
+	ch.Assert(err, check.Equals, nil)
+	d := EventDetails{}
+	ch.Assert(json.Unmarshal([]byte(e.Details), &d), check.Equals, nil)
+	ch.Assert(d.VariantId, check.Equals, result.VariantId)
+}
@@ -0,0 +1,577 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	log "github.com/gophish/gophish/logger"
+)
+
+// WebhookURL is the endpoint which is notified of every result event. Webhooks
+// are disabled when it's empty.
+var WebhookURL = ""
+
+// WebhookSecret is the key used to sign webhook payloads. The hex-encoded
+// HMAC-SHA256 of the delivery's timestamp, a ".", and the request body is
+// sent in the WebhookSignatureHeader header so that the receiver can verify
+// the payload came from gophish, and reject deliveries whose timestamp is too
+// old to prevent them being replayed.
+var WebhookSecret = ""
+
+// WebhookSignatureHeader is the header containing the payload signature.
+const WebhookSignatureHeader = "X-Gophish-Signature"
+
+// WebhookTimestampHeader is the header containing the time the delivery was
+// made, in seconds since the Unix epoch.
+const WebhookTimestampHeader = "X-Gophish-Timestamp"
+
+// WebhookTimeout is the maximum amount of time to wait for the webhook
+// endpoint to respond to each delivery attempt.
+var WebhookTimeout = 5 * time.Second
+
+// WebhookRetries is the number of times a failed delivery is retried before
+// it's added to the dead-letter queue.
+var WebhookRetries = 2
+
+// WebhookBackoff is the delay before the first retry. The delay doubles after
+// each failed attempt.
+var WebhookBackoff = time.Second
+
+// WebhookAllowedNetworks are the networks, in CIDR notation, which users'
+// webhooks can deliver to even though they aren't public. WebhookURL, which
+// only admins can configure, isn't restricted.
+var WebhookAllowedNetworks = []string{}
+
+// WebhookPayload is the JSON body posted to the webhook endpoint for each
+// result event.
+type WebhookPayload struct {
+	CampaignId int64           `json:"campaign_id"`
+	RId        string          `json:"id"`
+	Email      string          `json:"email"`
+	Status     string          `json:"status"`
+	Time       time.Time       `json:"time"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+// Webhook is an endpoint a user has configured to be notified of result
+// events. Webhooks can be scoped to specific campaigns and event types, and
+// are otherwise notified of every event in the campaigns visible to the
+// user.
+type Webhook struct {
+	Id           int64     `json:"id"`
+	UserId       int64     `json:"-"`
+	Name         string    `json:"name"`
+	URL          string    `json:"url"`
+	Secret       string    `json:"secret"`
+	IsActive     bool      `json:"is_active"`
+	CampaignIds  []int64   `json:"campaign_ids" sql:"-"`
+	EventTypes   []string  `json:"event_types" sql:"-"`
+	CampaignList string    `json:"-" gorm:"column:campaign_ids"`
+	EventList    string    `json:"-" gorm:"column:event_types"`
+	ModifiedDate time.Time `json:"modified_date"`
+}
+
+// WebhookDeadLetter is a webhook delivery which failed after every retry.
+// It's kept so that it can be replayed once the endpoint is available again.
+// Deliveries to WebhookURL have no WebhookId.
+type WebhookDeadLetter struct {
+	Id          int64     `json:"id"`
+	UserId      int64     `json:"-"`
+	WebhookId   int64     `json:"webhook_id"`
+	URL         string    `json:"url"`
+	Payload     string    `json:"payload"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	CreatedDate time.Time `json:"created_date"`
+}
+
+// ErrWebhookNameNotSpecified is thrown when a webhook has no name
+var ErrWebhookNameNotSpecified = errors.New("Webhook name not specified")
+
+// ErrInvalidWebhookURL is thrown when a webhook's URL isn't an absolute http
+// or https URL
+var ErrInvalidWebhookURL = errors.New("Webhook URL must be an http or https URL")
+
+// ErrWebhookCampaignNotFound is thrown when a webhook is scoped to a campaign
+// which doesn't exist
+var ErrWebhookCampaignNotFound = errors.New("Webhook campaign not found")
+
+// ErrWebhookAddressNotAllowed is thrown when a webhook's URL is at an address
+// which isn't public, such as a loopback or private address, and isn't in one
+// of the WebhookAllowedNetworks. Otherwise users could have the server make
+// requests to internal services.
+var ErrWebhookAddressNotAllowed = errors.New("Webhook URL must be at a public address, or in one of the webhook's allowed_networks")
+
+// ErrWebhookHostNotFound is thrown when the host of a webhook's URL can't be
+// resolved
+var ErrWebhookHostNotFound = errors.New("Webhook URL's host couldn't be resolved")
+
+// ErrInvalidWebhookNetwork is thrown when one of the webhook allowed networks
+// isn't in CIDR notation
+var ErrInvalidWebhookNetwork = errors.New("Webhook allowed networks must be in CIDR notation")
+
+// configureWebhook sets the endpoint which is notified of every result event,
+// and the networks users' webhooks can deliver to, returning an error if
+// either isn't valid.
+func configureWebhook(conf config.Webhook) error {
+	if conf.URL != "" {
+		u, err := url.Parse(conf.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidWebhookURL
+		}
+	}
+	for _, network := range conf.AllowedNetworks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return ErrInvalidWebhookNetwork
+		}
+	}
+	WebhookURL = conf.URL
+	WebhookSecret = conf.Secret
+	WebhookAllowedNetworks = conf.AllowedNetworks
+	return nil
+}
+
+// CheckWebhookAddress returns ErrWebhookAddressNotAllowed unless users'
+// webhooks can deliver to the address, which is the case for public
+// addresses and those in the WebhookAllowedNetworks.
+func CheckWebhookAddress(ip net.IP) error {
+	if isPublicIP(ip) || inNetworks(ip.String(), WebhookAllowedNetworks) {
+		return nil
+	}
+	return ErrWebhookAddressNotAllowed
+}
+
+// resolveWebhookHost returns the addresses of the host of a webhook's URL, or
+// an error if any of them can't be delivered to.
+func resolveWebhookHost(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return nil, ErrWebhookHostNotFound
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		err = CheckWebhookAddress(addr.IP)
+		if err != nil {
+			return nil, err
+		}
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// webhookDialer dials the endpoints of users' webhooks
+var webhookDialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+// webhookTransport is used to deliver to users' webhooks. It doesn't use the
+// environment's HTTP proxy, so that the addresses it connects to are always
+// checked.
+var webhookTransport = &http.Transport{
+	DialContext:           dialWebhook,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// dialWebhook connects to a webhook's endpoint once its host has been
+// resolved and each of its addresses checked, so that a host which resolves
+// to an internal address after the webhook was saved, or a redirect to one,
+// can't be delivered to.
+func dialWebhook(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := resolveWebhookHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = webhookDialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// webhookClient returns the client used to deliver to the webhook with the
+// given id. Deliveries to users' webhooks are only made to the addresses
+// allowed by CheckWebhookAddress, while deliveries to WebhookURL, which have
+// no webhook id, aren't restricted.
+func webhookClient(wid int64) *http.Client {
+	if wid == 0 {
+		return &http.Client{Timeout: WebhookTimeout}
+	}
+	return &http.Client{Timeout: WebhookTimeout, Transport: webhookTransport}
+}
+
+// TableName specifies the database tablename for Gorm to use
+func (w WebhookDeadLetter) TableName() string {
+	return "webhook_dead_letters"
+}
+
+// Validate ensures that the webhook has a name and a valid URL, and that the
+// campaigns it's scoped to belong to the user. The URL's host is resolved, so
+// that it can't point at internal services. It's checked again each time the
+// webhook is delivered to, since its addresses can change after it's saved.
+func (w *Webhook) Validate() error {
+	if w.Name == "" {
+		return ErrWebhookNameNotSpecified
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), WebhookTimeout)
+	defer cancel()
+	_, err = resolveWebhookHost(ctx, u.Hostname())
+	if err != nil {
+		return err
+	}
+	if len(w.CampaignIds) > 0 {
+		count := 0
+		err = db.Table("campaigns").Where("id in (?) and user_id in (?)", w.CampaignIds, teamUserIds(w.UserId)).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count != len(w.CampaignIds) {
+			return ErrWebhookCampaignNotFound
+		}
+	}
+	return nil
+}
+
+// loadScope fills in the webhook's campaigns and event types from the stored
+// lists
+func (w *Webhook) loadScope() {
+	w.CampaignIds = []int64{}
+	w.EventTypes = []string{}
+	for _, id := range strings.Split(w.CampaignList, ",") {
+		if cid, err := strconv.ParseInt(id, 10, 64); err == nil {
+			w.CampaignIds = append(w.CampaignIds, cid)
+		}
+	}
+	if w.EventList != "" {
+		w.EventTypes = strings.Split(w.EventList, ",")
+	}
+}
+
+// storeScope fills in the stored lists from the webhook's campaigns and
+// event types
+func (w *Webhook) storeScope() {
+	ids := []string{}
+	for _, id := range w.CampaignIds {
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+	w.CampaignList = strings.Join(ids, ",")
+	w.EventList = strings.Join(w.EventTypes, ",")
+}
+
+// Matches returns whether or not the webhook should be notified of the
+// event.
+func (w *Webhook) Matches(e *Event) bool {
+	if !w.IsActive {
+		return false
+	}
+	if len(w.CampaignIds) > 0 {
+		found := false
+		for _, id := range w.CampaignIds {
+			if id == e.CampaignId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(w.EventTypes) > 0 {
+		for _, t := range w.EventTypes {
+			if t == e.Message {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// GetWebhooks returns the webhooks visible to the given user
+func GetWebhooks(uid int64) ([]Webhook, error) {
+	ws := []Webhook{}
+	err := db.Where("user_id in (?)", teamUserIds(uid)).Order("id asc").Find(&ws).Error
+	if err != nil {
+		log.Error(err)
+		return ws, err
+	}
+	for i := range ws {
+		ws[i].loadScope()
+	}
+	return ws, nil
+}
+
+// GetWebhook returns the webhook, if it exists, specified by the given id and
+// user_id.
+func GetWebhook(id int64, uid int64) (Webhook, error) {
+	w := Webhook{}
+	err := db.Where("id=? and user_id in (?)", id, teamUserIds(uid)).First(&w).Error
+	if err != nil {
+		return w, err
+	}
+	w.loadScope()
+	return w, nil
+}
+
+// PostWebhook creates a new webhook in the database.
+func PostWebhook(w *Webhook) error {
+	return PutWebhook(w)
+}
+
+// PutWebhook edits an existing webhook in the database.
+func PutWebhook(w *Webhook) error {
+	err := w.Validate()
+	if err != nil {
+		return err
+	}
+	w.storeScope()
+	w.ModifiedDate = time.Now().UTC()
+	err = db.Save(w).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// DeleteWebhook deletes the webhook specified by the given id and user_id,
+// along with its dead letters.
+func DeleteWebhook(id int64, uid int64) error {
+	w, err := GetWebhook(id, uid)
+	if err != nil {
+		return err
+	}
+	err = db.Where("webhook_id=?", w.Id).Delete(&WebhookDeadLetter{}).Error
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = db.Delete(&w).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// deadLetterUserIds returns the ids of the users whose failed webhook
+// deliveries are visible to the given user. Deliveries to WebhookURL have no
+// owner, and are only visible to admins.
+func deadLetterUserIds(uid int64) []int64 {
+	ids := teamUserIds(uid)
+	u, err := GetUser(uid)
+	if err == nil && u.IsAdmin() {
+		ids = append(ids, 0)
+	}
+	return ids
+}
+
+// GetWebhookDeadLetters returns the failed webhook deliveries visible to the
+// given user, oldest first.
+func GetWebhookDeadLetters(uid int64) ([]WebhookDeadLetter, error) {
+	ds := []WebhookDeadLetter{}
+	err := db.Where("user_id in (?)", deadLetterUserIds(uid)).Order("id asc").Find(&ds).Error
+	if err != nil {
+		log.Error(err)
+	}
+	return ds, err
+}
+
+// GetWebhookDeadLetter returns the failed webhook delivery, if it exists,
+// specified by the given id and user_id.
+func GetWebhookDeadLetter(id int64, uid int64) (WebhookDeadLetter, error) {
+	d := WebhookDeadLetter{}
+	err := db.Where("id=? and user_id in (?)", id, deadLetterUserIds(uid)).First(&d).Error
+	return d, err
+}
+
+// DeleteWebhookDeadLetter deletes the failed webhook delivery specified by
+// the given id and user_id without replaying it.
+func DeleteWebhookDeadLetter(id int64, uid int64) error {
+	d, err := GetWebhookDeadLetter(id, uid)
+	if err != nil {
+		return err
+	}
+	return db.Delete(&d).Error
+}
+
+// ReplayWebhookDeadLetter attempts to deliver the failed webhook delivery
+// specified by the given id and user_id again, signed with the webhook's
+// current secret. It's removed from the dead-letter queue once it's
+// delivered, and otherwise kept with the new error.
+func ReplayWebhookDeadLetter(id int64, uid int64) error {
+	d, err := GetWebhookDeadLetter(id, uid)
+	if err != nil {
+		return err
+	}
+	secret := WebhookSecret
+	if d.WebhookId != 0 {
+		w := Webhook{}
+		err = db.Where("id=?", d.WebhookId).First(&w).Error
+		if err != nil {
+			return err
+		}
+		d.URL = w.URL
+		secret = w.Secret
+	}
+	body := []byte(d.Payload)
+	err = postWebhook(webhookClient(d.WebhookId), d.URL, body, secret)
+	if err != nil {
+		d.Attempts++
+		d.LastError = err.Error()
+		if serr := db.Save(&d).Error; serr != nil {
+			log.Error(serr)
+		}
+		return err
+	}
+	return db.Delete(&d).Error
+}
+
+// signPayload returns the hex-encoded HMAC-SHA256 of the body using the
+// given secret.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signDelivery returns the signature of a delivery of the body made at the
+// given time, which is the hex-encoded HMAC-SHA256 of the timestamp and the
+// body using the given secret.
+func signDelivery(secret string, timestamp string, body []byte) string {
+	return signPayload(secret, append([]byte(timestamp+"."), body...))
+}
+
+// notifyWebhook delivers the event recorded for the result to WebhookURL, if
+// one is configured, and to each of the matching webhooks configured by the
+// campaign's owner or their team, in the background. Delivery never blocks
+// or fails the event itself.
+func notifyWebhook(r *Result, e *Event) {
+	ws := []Webhook{}
+	err := db.Where("user_id in (?) and is_active=?", teamUserIds(r.UserId), true).Find(&ws).Error
+	if err != nil {
+		log.Error(err)
+	}
+	matches := []Webhook{}
+	for _, w := range ws {
+		w.loadScope()
+		if w.Matches(e) {
+			matches = append(matches, w)
+		}
+	}
+	if WebhookURL == "" && len(matches) == 0 {
+		return
+	}
+	p := WebhookPayload{
+		CampaignId: e.CampaignId,
+		RId:        r.RId,
+		Email:      e.Email,
+		Status:     e.Message,
+		Time:       e.Time,
+	}
+	if e.Details != "" {
+		p.Details = json.RawMessage(e.Details)
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if WebhookURL != "" {
+		go deliverOrDeadLetter(0, 0, WebhookURL, body, WebhookSecret)
+	}
+	for _, w := range matches {
+		go deliverOrDeadLetter(w.Id, w.UserId, w.URL, body, w.Secret)
+	}
+}
+
+// deliverOrDeadLetter delivers the body to the given URL of the webhook with
+// the given id, signed with the secret, adding it to the dead-letter queue of
+// the given user if every attempt fails.
+func deliverOrDeadLetter(wid int64, uid int64, u string, body []byte, secret string) {
+	err := deliverWebhook(webhookClient(wid), u, body, secret)
+	if err == nil {
+		return
+	}
+	webhookDeadLetters.Inc()
+	d := WebhookDeadLetter{
+		UserId:      uid,
+		WebhookId:   wid,
+		URL:         u,
+		Payload:     string(body),
+		Attempts:    WebhookRetries + 1,
+		LastError:   err.Error(),
+		CreatedDate: time.Now().UTC(),
+	}
+	err = db.Save(&d).Error
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// deliverWebhook posts the body to the given URL using the client, signed with
+// the secret, retrying transient failures with exponential backoff before
+// giving up.
+func deliverWebhook(client *http.Client, u string, body []byte, secret string) error {
+	backoff := WebhookBackoff
+	var err error
+	for attempt := 0; attempt <= WebhookRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		err = postWebhook(client, u, body, secret)
+		if err == nil {
+			return nil
+		}
+		webhookFailures.Inc()
+		log.Warnf("webhook delivery attempt %d failed: %s", attempt+1, err)
+	}
+	log.Errorf("webhook delivery failed after %d attempts: %s", WebhookRetries+1, err)
+	return err
+}
+
+// postWebhook makes a single delivery attempt, signed with the secret at the
+// time it's made. Any non-2xx response is treated as a failure.
+func postWebhook(client *http.Client, u string, body []byte, secret string) error {
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, signDelivery(secret, timestamp, body))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status from webhook: %s", resp.Status)
+	}
+	return nil
+}
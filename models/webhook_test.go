@@ -0,0 +1,305 @@
+package models
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	"gopkg.in/check.v1"
+)
+
+func (s *ModelsSuite) TestWebhookOnEvent(ch *check.C) {
+	type delivery struct {
+		body      []byte
+		signature string
+		timestamp string
+	}
+	deliveries := make(chan delivery, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		deliveries <- delivery{
+			body:      body,
+			signature: r.Header.Get(WebhookSignatureHeader),
+			timestamp: r.Header.Get(WebhookTimestampHeader),
+		}
+	}))
+	defer ts.Close()
+	defer func(u, secret string) {
+		WebhookURL, WebhookSecret = u, secret
+	}(WebhookURL, WebhookSecret)
+
+	// Events created before the webhook is configured aren't delivered
+	campaign := s.createCampaign(ch)
+	ch.Assert(configureWebhook(config.Webhook{URL: ts.URL, Secret: "secret"}), check.Equals, nil)
+
+	result := campaign.Results[0]
+	ch.Assert(result.HandleClickedLink(EventDetails{Browser: map[string]string{"user-agent": "test-agent"}}), check.Equals, nil)
+
+	var d delivery
+	select {
+	case d = <-deliveries:
+	case <-time.After(5 * time.Second):
+		ch.Fatal("webhook was not delivered")
+	}
+	// The signature covers the time the delivery was made, so that receivers
+	// can reject replayed deliveries
+	sent, err := strconv.ParseInt(d.timestamp, 10, 64)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(time.Since(time.Unix(sent, 0)) < time.Minute, check.Equals, true)
+	ch.Assert(d.signature, check.Equals, signPayload("secret", []byte(d.timestamp+"."+string(d.body))))
+	ch.Assert(d.signature, check.Not(check.Equals), signPayload("secret", d.body))
+
+	p := WebhookPayload{}
+	ch.Assert(json.Unmarshal(d.body, &p), check.Equals, nil)
+	ch.Assert(p.CampaignId, check.Equals, campaign.Id)
+	ch.Assert(p.RId, check.Equals, result.RId)
+	ch.Assert(p.Email, check.Equals, result.Email)
+	ch.Assert(p.Status, check.Equals, EVENT_CLICKED)
+	ch.Assert(p.Time.IsZero(), check.Equals, false)
+	details := EventDetails{}
+	ch.Assert(json.Unmarshal(p.Details, &details), check.Equals, nil)
+	ch.Assert(details.Browser["user-agent"], check.Equals, "test-agent")
+
+	select {
+	case <-deliveries:
+		ch.Fatal("unexpected webhook delivery")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *ModelsSuite) TestDeliverWebhookRetries(ch *check.C) {
+	defer func(retries int, backoff time.Duration) {
+		WebhookRetries, WebhookBackoff = retries, backoff
+	}(WebhookRetries, WebhookBackoff)
+	WebhookRetries = 2
+	WebhookBackoff = time.Millisecond
+
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	// The third attempt succeeds
+	ch.Assert(deliverWebhook(webhookClient(0), ts.URL, []byte("{}"), "secret"), check.Equals, nil)
+	ch.Assert(atomic.LoadInt32(&attempts), check.Equals, int32(3))
+
+	// Deliveries which keep failing return an error after the retries
+	atomic.StoreInt32(&attempts, -10)
+	ch.Assert(deliverWebhook(webhookClient(0), ts.URL, []byte("{}"), "secret"), check.NotNil)
+	ch.Assert(atomic.LoadInt32(&attempts), check.Equals, int32(-7))
+}
+
+func (s *ModelsSuite) TestConfigureWebhook(ch *check.C) {
+	defer func(u, secret string) {
+		WebhookURL, WebhookSecret = u, secret
+	}(WebhookURL, WebhookSecret)
+	ch.Assert(configureWebhook(config.Webhook{URL: "ftp://example.com/hook"}), check.Equals, ErrInvalidWebhookURL)
+	ch.Assert(configureWebhook(config.Webhook{URL: "/hook"}), check.Equals, ErrInvalidWebhookURL)
+	ch.Assert(configureWebhook(config.Webhook{URL: "https://example.com/hook", Secret: "secret"}), check.Equals, nil)
+	ch.Assert(WebhookURL, check.Equals, "https://example.com/hook")
+	ch.Assert(WebhookSecret, check.Equals, "secret")
+	ch.Assert(configureWebhook(config.Webhook{}), check.Equals, nil)
+	ch.Assert(WebhookURL, check.Equals, "")
+	ch.Assert(configureWebhook(config.Webhook{AllowedNetworks: []string{"10.1.2.3"}}), check.Equals, ErrInvalidWebhookNetwork)
+}
+
+func (s *ModelsSuite) TestWebhookInternalAddresses(ch *check.C) {
+	defer func(networks []string) { WebhookAllowedNetworks = networks }(WebhookAllowedNetworks)
+	WebhookAllowedNetworks = []string{}
+
+	// Webhooks at internal addresses can only be saved if they're allowed
+	for _, u := range []string{"http://127.0.0.1:8080", "http://localhost", "http://10.1.2.3", "http://169.254.169.254/latest/meta-data", "http://[::1]", "http://[fd00::1]"} {
+		w := Webhook{UserId: 1, Name: "Internal", URL: u}
+		ch.Assert(PostWebhook(&w), check.Equals, ErrWebhookAddressNotAllowed, check.Commentf(u))
+	}
+	WebhookAllowedNetworks = []string{"10.0.0.0/8"}
+	w := Webhook{UserId: 1, Name: "Internal", URL: "http://10.1.2.3/hook"}
+	ch.Assert(PostWebhook(&w), check.Equals, nil)
+
+	// Addresses are checked again when delivering, so a webhook which is no
+	// longer allowed isn't delivered to
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ch.Error("webhook was delivered to an internal address")
+	}))
+	defer ts.Close()
+	WebhookAllowedNetworks = []string{"127.0.0.0/8"}
+	w = Webhook{UserId: 1, Name: "Local", URL: ts.URL}
+	ch.Assert(PostWebhook(&w), check.Equals, nil)
+	WebhookAllowedNetworks = []string{}
+	err := postWebhook(webhookClient(w.Id), w.URL, []byte("{}"), "secret")
+	ch.Assert(err, check.NotNil)
+	ch.Assert(strings.Contains(err.Error(), ErrWebhookAddressNotAllowed.Error()), check.Equals, true)
+}
+
+func (s *ModelsSuite) TestGlobalWebhookDeadLetters(ch *check.C) {
+	defer func(u, secret string, retries int, backoff time.Duration) {
+		WebhookURL, WebhookSecret = u, secret
+		WebhookRetries, WebhookBackoff = retries, backoff
+	}(WebhookURL, WebhookSecret, WebhookRetries, WebhookBackoff)
+	WebhookRetries = 0
+	WebhookBackoff = time.Millisecond
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	// The campaign is owned by an operator, who can't see the failed
+	// deliveries to the admin's webhook
+	operator := s.createTeamUser(ch, "operator", ROLE_OPERATOR, 0)
+	campaign := s.createCampaign(ch)
+	ch.Assert(db.Model(&Campaign{}).Where("id=?", campaign.Id).UpdateColumn("user_id", operator.Id).Error, check.Equals, nil)
+	ch.Assert(db.Model(&Result{}).Where("campaign_id=?", campaign.Id).UpdateColumn("user_id", operator.Id).Error, check.Equals, nil)
+	result, err := GetResult(campaign.Results[0].RId)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(configureWebhook(config.Webhook{URL: ts.URL, Secret: "secret"}), check.Equals, nil)
+	ch.Assert(result.HandleClickedLink(EventDetails{}), check.Equals, nil)
+
+	var dls []WebhookDeadLetter
+	deadline := time.Now().Add(5 * time.Second)
+	for len(dls) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		dls, err = GetWebhookDeadLetters(1)
+		ch.Assert(err, check.Equals, nil)
+	}
+	ch.Assert(len(dls), check.Equals, 1)
+	ch.Assert(dls[0].UserId, check.Equals, int64(0))
+	ch.Assert(dls[0].WebhookId, check.Equals, int64(0))
+	got, err := GetWebhookDeadLetters(operator.Id)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(got), check.Equals, 0)
+	_, err = GetWebhookDeadLetter(dls[0].Id, operator.Id)
+	ch.Assert(err, check.NotNil)
+	ch.Assert(ReplayWebhookDeadLetter(dls[0].Id, operator.Id), check.NotNil)
+}
+
+func (s *ModelsSuite) TestPostWebhookValidation(ch *check.C) {
+	w := Webhook{UserId: 1, URL: "https://203.0.113.10/hook"}
+	ch.Assert(PostWebhook(&w), check.Equals, ErrWebhookNameNotSpecified)
+	w.Name = "Test Webhook"
+	w.URL = "ftp://203.0.113.10/hook"
+	ch.Assert(PostWebhook(&w), check.Equals, ErrInvalidWebhookURL)
+	w.URL = "https://203.0.113.10/hook"
+	w.CampaignIds = []int64{1000}
+	ch.Assert(PostWebhook(&w), check.Equals, ErrWebhookCampaignNotFound)
+	w.CampaignIds = []int64{}
+	w.EventTypes = []string{EVENT_CLICKED, EVENT_DATA_SUBMIT}
+	ch.Assert(PostWebhook(&w), check.Equals, nil)
+
+	got, err := GetWebhook(w.Id, 1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(got.CampaignIds, check.DeepEquals, []int64{})
+	ch.Assert(got.EventTypes, check.DeepEquals, []string{EVENT_CLICKED, EVENT_DATA_SUBMIT})
+}
+
+func (s *ModelsSuite) TestScopedWebhooks(ch *check.C) {
+	defer func(networks []string) { WebhookAllowedNetworks = networks }(WebhookAllowedNetworks)
+	WebhookAllowedNetworks = []string{"127.0.0.0/8"}
+	deliveries := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(WebhookSignatureHeader) != signDelivery("secret", r.Header.Get(WebhookTimestampHeader), body) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		deliveries <- r.URL.Path
+	}))
+	defer ts.Close()
+
+	campaign := s.createCampaign(ch)
+	hooks := []Webhook{
+		Webhook{Name: "Clicks", URL: ts.URL + "/clicks", EventTypes: []string{EVENT_CLICKED}},
+		Webhook{Name: "Submissions", URL: ts.URL + "/submissions", EventTypes: []string{EVENT_DATA_SUBMIT}},
+		Webhook{Name: "Campaign", URL: ts.URL + "/campaign", CampaignIds: []int64{campaign.Id}},
+		Webhook{Name: "Inactive", URL: ts.URL + "/inactive"},
+	}
+	for i := range hooks {
+		hooks[i].UserId = 1
+		hooks[i].Secret = "secret"
+		hooks[i].IsActive = hooks[i].Name != "Inactive"
+		ch.Assert(PostWebhook(&hooks[i]), check.Equals, nil)
+	}
+
+	result := campaign.Results[0]
+	ch.Assert(result.HandleClickedLink(EventDetails{}), check.Equals, nil)
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case path := <-deliveries:
+			got[path] = true
+		case <-time.After(5 * time.Second):
+			ch.Fatal("webhook was not delivered")
+		}
+	}
+	ch.Assert(got, check.DeepEquals, map[string]bool{"/clicks": true, "/campaign": true})
+
+	select {
+	case path := <-deliveries:
+		ch.Fatalf("unexpected webhook delivery to %s", path)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *ModelsSuite) TestWebhookDeadLetters(ch *check.C) {
+	defer func(retries int, backoff time.Duration) {
+		WebhookRetries, WebhookBackoff = retries, backoff
+	}(WebhookRetries, WebhookBackoff)
+	WebhookRetries = 1
+	WebhookBackoff = time.Millisecond
+	defer func(networks []string) { WebhookAllowedNetworks = networks }(WebhookAllowedNetworks)
+	WebhookAllowedNetworks = []string{"127.0.0.0/8"}
+
+	var failing int32 = 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	campaign := s.createCampaign(ch)
+	hook := Webhook{UserId: 1, Name: "Test Webhook", URL: ts.URL, Secret: "secret", IsActive: true}
+	ch.Assert(PostWebhook(&hook), check.Equals, nil)
+	ch.Assert(campaign.Results[0].HandleClickedLink(EventDetails{}), check.Equals, nil)
+
+	// Deliveries which fail every retry are added to the dead-letter queue
+	var dls []WebhookDeadLetter
+	deadline := time.Now().Add(5 * time.Second)
+	for len(dls) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		dls, err = GetWebhookDeadLetters(1)
+		ch.Assert(err, check.Equals, nil)
+	}
+	ch.Assert(len(dls), check.Equals, 1)
+	ch.Assert(dls[0].WebhookId, check.Equals, hook.Id)
+	ch.Assert(dls[0].Attempts, check.Equals, 2)
+	ch.Assert(dls[0].LastError, check.Not(check.Equals), "")
+	p := WebhookPayload{}
+	ch.Assert(json.Unmarshal([]byte(dls[0].Payload), &p), check.Equals, nil)
+	ch.Assert(p.Status, check.Equals, EVENT_CLICKED)
+
+	// Replays which fail are kept in the queue
+	ch.Assert(ReplayWebhookDeadLetter(dls[0].Id, 1), check.NotNil)
+	d, err := GetWebhookDeadLetter(dls[0].Id, 1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(d.Attempts, check.Equals, 3)
+
+	// Other users can't see or replay the dead letter
+	_, err = GetWebhookDeadLetter(dls[0].Id, 2)
+	ch.Assert(err, check.NotNil)
+
+	atomic.StoreInt32(&failing, 0)
+	ch.Assert(ReplayWebhookDeadLetter(dls[0].Id, 1), check.Equals, nil)
+	dls, err = GetWebhookDeadLetters(1)
+	ch.Assert(err, check.Equals, nil)
+	ch.Assert(len(dls), check.Equals, 0)
+}
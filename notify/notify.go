@@ -0,0 +1,99 @@
+// Package notify sends short messages about campaign milestones to Slack or
+// Microsoft Teams incoming webhooks, or by email.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gophish/gomail"
+	"github.com/gophish/gophish/mailer"
+)
+
+// Timeout is how long a notification request may take
+var Timeout = 10 * time.Second
+
+// maxErrorBody is the most of an error response which is included in the
+// returned error
+const maxErrorBody = 512
+
+// Notifier sends a notification with the given subject and text.
+type Notifier interface {
+	Notify(subject string, text string) error
+}
+
+// SlackNotifier posts notifications to a Slack incoming webhook.
+type SlackNotifier struct {
+	URL string
+}
+
+// Notify posts the subject and text to the webhook as a single message.
+func (n *SlackNotifier) Notify(subject string, text string) error {
+	return postJSON(n.URL, map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", subject, text),
+	})
+}
+
+// TeamsNotifier posts notifications to a Microsoft Teams incoming webhook.
+type TeamsNotifier struct {
+	URL string
+}
+
+// Notify posts the subject and text to the webhook as a message card.
+func (n *TeamsNotifier) Notify(subject string, text string) error {
+	return postJSON(n.URL, map[string]string{
+		"@type":    "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary":  subject,
+		"title":    subject,
+		"text":     text,
+	})
+}
+
+// EmailNotifier emails notifications to an address using a dialer, such as
+// the one for a sending profile.
+type EmailNotifier struct {
+	Dialer mailer.Dialer
+	From   string
+	To     string
+}
+
+// Notify emails the text with the given subject.
+func (n *EmailNotifier) Notify(subject string, text string) error {
+	msg := gomail.NewMessage()
+	msg.SetHeader("From", n.From)
+	msg.SetHeader("To", n.To)
+	msg.SetHeader("Subject", subject)
+	msg.SetBody("text/plain", text)
+	sender, err := n.Dialer.Dial()
+	if err != nil {
+		return err
+	}
+	defer sender.Close()
+	return gomail.Send(sender, msg)
+}
+
+// postJSON posts the payload to the URL, returning an error if the response
+// isn't successful.
+func postJSON(u string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: Timeout}
+	resp, err := client.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("notification returned status %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
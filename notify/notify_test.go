@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gophish/gophish/mailer"
+	"github.com/stretchr/testify/suite"
+)
+
+type NotifySuite struct {
+	suite.Suite
+}
+
+type mockSender struct {
+	from string
+	to   []string
+	msg  string
+}
+
+func (s *mockSender) Send(from string, to []string, msg io.WriterTo) error {
+	s.from = from
+	s.to = to
+	b := &strings.Builder{}
+	msg.WriteTo(b)
+	s.msg = b.String()
+	return nil
+}
+
+func (s *mockSender) Close() error { return nil }
+func (s *mockSender) Reset() error { return nil }
+
+type mockDialer struct {
+	sender *mockSender
+}
+
+func (d *mockDialer) Dial() (mailer.Sender, error) {
+	return d.sender, nil
+}
+
+func (s *NotifySuite) TestSlackNotifier() {
+	var got map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("application/json", r.Header.Get("Content-Type"))
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+	n := &SlackNotifier{URL: ts.URL}
+	s.Nil(n.Notify("Subject", "Text"))
+	s.Equal("*Subject*\nText", got["text"])
+}
+
+func (s *NotifySuite) TestTeamsNotifier() {
+	var got map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+	n := &TeamsNotifier{URL: ts.URL}
+	s.Nil(n.Notify("Subject", "Text"))
+	s.Equal("MessageCard", got["@type"])
+	s.Equal("Subject", got["title"])
+	s.Equal("Text", got["text"])
+}
+
+func (s *NotifySuite) TestNotifierError() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("no_team"))
+	}))
+	defer ts.Close()
+	err := (&SlackNotifier{URL: ts.URL}).Notify("Subject", "Text")
+	s.NotNil(err)
+	s.Contains(err.Error(), "no_team")
+}
+
+func (s *NotifySuite) TestEmailNotifier() {
+	sender := &mockSender{}
+	n := &EmailNotifier{Dialer: &mockDialer{sender: sender}, From: "gophish@example.com", To: "operator@example.com"}
+	s.Nil(n.Notify("Subject", "Text"))
+	s.Equal("gophish@example.com", sender.from)
+	s.Equal([]string{"operator@example.com"}, sender.to)
+	s.Contains(sender.msg, "Subject: Subject")
+	s.Contains(sender.msg, "Text")
+}
+
+func TestNotifySuite(t *testing.T) {
+	suite.Run(t, new(NotifySuite))
+}
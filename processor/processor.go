@@ -0,0 +1,320 @@
+// Package processor lets external processors intercept campaign events before
+// they're recorded, so that custom workflows don't require changes to
+// gophish. A processor can enrich an event's details, such as with threat
+// intelligence on the source address, suppress the event, or start a
+// workflow of its own, such as opening a ticket. Processors are either HTTP
+// callbacks, which are sent each event as JSON, or Go plugins.
+package processor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"plugin"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	log "github.com/gophish/gophish/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The types of processor which can be configured
+const (
+	TypeHTTP   = "http"
+	TypePlugin = "plugin"
+)
+
+// The actions a processor can take on an event
+const (
+	ActionKeep     = "keep"
+	ActionSuppress = "suppress"
+)
+
+// PluginSymbol is the name of the variable Go plugins export their Processor
+// as
+const PluginSymbol = "Processor"
+
+// SignatureHeader is the header containing the signature of the events sent
+// to HTTP processors, which is the hex-encoded HMAC-SHA256 of the request
+// body using the processor's secret.
+const SignatureHeader = "X-Gophish-Signature"
+
+// DefaultTimeout is the maximum amount of time to wait for an HTTP processor
+// to respond, if no other timeout is configured.
+var DefaultTimeout = 2 * time.Second
+
+// MaxResponseSize is the largest response read from an HTTP processor
+var MaxResponseSize int64 = 1 << 20
+
+// ErrInvalidType is thrown when the processor type isn't http or plugin
+var ErrInvalidType = errors.New("Event processor type must be http or plugin")
+
+// ErrInvalidURL is thrown when an HTTP processor's URL isn't an absolute
+// http(s) URL
+var ErrInvalidURL = errors.New("Event processor URL must be an http or https URL")
+
+// ErrPathNotSpecified is thrown when a plugin processor has no path
+var ErrPathNotSpecified = errors.New("Event processor plugin path not specified")
+
+// ErrInvalidPlugin is thrown when a Go plugin doesn't export a Processor
+var ErrInvalidPlugin = errors.New("Event processor plugin must export a Processor")
+
+// ErrInvalidAction is thrown when a processor returns an action which isn't
+// keep or suppress
+var ErrInvalidAction = errors.New("Event processor action must be keep or suppress")
+
+// ErrInvalidDetails is thrown when a processor enriches an event with
+// details which aren't a JSON object
+var ErrInvalidDetails = errors.New("Event processor details must be a JSON object")
+
+// processedEvents counts the events handled by each processor, labeled by
+// the action taken, or "error".
+var processedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "gophish_processed_events_total",
+	Help: "The number of campaign events handled by each event processor, by action.",
+}, []string{"processor", "action"})
+
+// RegisterMetrics registers the collectors for the processors' metrics with
+// the given registerer. It should be called once at startup.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	return reg.Register(processedEvents)
+}
+
+// Event is a campaign event about to be recorded for a result
+type Event struct {
+	CampaignId   int64           `json:"campaign_id"`
+	CampaignName string          `json:"campaign_name"`
+	RId          string          `json:"rid"`
+	Email        string          `json:"email"`
+	Message      string          `json:"message"`
+	Time         time.Time       `json:"time"`
+	Details      json.RawMessage `json:"details,omitempty"`
+}
+
+// Enrich adds the fields to the event's details, replacing any fields with
+// the same names.
+func (e *Event) Enrich(fields map[string]interface{}) error {
+	details := map[string]interface{}{}
+	if len(e.Details) > 0 {
+		err := json.Unmarshal(e.Details, &details)
+		if err != nil {
+			return ErrInvalidDetails
+		}
+	}
+	for k, v := range fields {
+		details[k] = v
+	}
+	d, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	e.Details = d
+	return nil
+}
+
+// Processor is given each event before it's recorded. It can change the
+// event's details, and returns ActionKeep to record the event or
+// ActionSuppress to drop it. Go plugins export a Processor as the variable
+// named by PluginSymbol.
+type Processor interface {
+	Process(e *Event) (string, error)
+}
+
+// ProcessorFunc adapts a function to a Processor
+type ProcessorFunc func(e *Event) (string, error)
+
+// Process calls f(e)
+func (f ProcessorFunc) Process(e *Event) (string, error) {
+	return f(e)
+}
+
+// Response is the JSON body HTTP processors respond with. Details are added
+// to the event's details, and an empty action keeps the event. Processors
+// can also respond with 204 No Content to keep the event unchanged.
+type Response struct {
+	Action  string                 `json:"action"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// HTTPProcessor POSTs each event to a URL as JSON
+type HTTPProcessor struct {
+	URL    string
+	Secret string
+	client *http.Client
+}
+
+// NewHTTPProcessor returns a processor which POSTs events to the URL,
+// waiting up to the given timeout for it to respond.
+func NewHTTPProcessor(u string, secret string, timeout time.Duration) (*HTTPProcessor, error) {
+	pu, err := url.Parse(u)
+	if err != nil || pu.Host == "" || (pu.Scheme != "http" && pu.Scheme != "https") {
+		return nil, ErrInvalidURL
+	}
+	return &HTTPProcessor{URL: u, Secret: secret, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Process sends the event to the processor's URL, applying the details and
+// action it responds with. Any non-2xx response is treated as a failure.
+func (p *HTTPProcessor) Process(e *Event) (string, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", p.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(p.Secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected status from event processor: %s", resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxResponseSize))
+	if err != nil {
+		return "", err
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return ActionKeep, nil
+	}
+	r := Response{}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return "", err
+	}
+	if len(r.Details) > 0 {
+		err = e.Enrich(r.Details)
+		if err != nil {
+			return "", err
+		}
+	}
+	if r.Action == "" {
+		return ActionKeep, nil
+	}
+	return r.Action, nil
+}
+
+// loadPlugin returns the Processor exported by the Go plugin at the path
+func loadPlugin(path string) (Processor, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(PluginSymbol)
+	if err != nil {
+		return nil, ErrInvalidPlugin
+	}
+	// Exported variables are looked up as pointers to the variable
+	switch v := sym.(type) {
+	case *Processor:
+		return *v, nil
+	case Processor:
+		return v, nil
+	}
+	return nil, ErrInvalidPlugin
+}
+
+// entry is a processor in the chain, along with the events it's given
+type entry struct {
+	name      string
+	processor Processor
+	events    map[string]bool
+}
+
+// Chain runs each event through its processors in order
+type Chain struct {
+	entries []entry
+}
+
+// New returns a Chain of the processors in the given configuration, loading
+// any Go plugins.
+func New(confs []config.EventProcessor) (*Chain, error) {
+	c := &Chain{}
+	for i, conf := range confs {
+		name := conf.Name
+		if name == "" {
+			name = fmt.Sprintf("processor-%d", i+1)
+		}
+		var p Processor
+		var err error
+		switch conf.Type {
+		case TypeHTTP:
+			timeout := DefaultTimeout
+			if conf.TimeoutSeconds > 0 {
+				timeout = time.Duration(conf.TimeoutSeconds) * time.Second
+			}
+			p, err = NewHTTPProcessor(conf.URL, conf.Secret, timeout)
+		case TypePlugin:
+			if conf.Path == "" {
+				return nil, ErrPathNotSpecified
+			}
+			p, err = loadPlugin(conf.Path)
+		default:
+			err = ErrInvalidType
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		c.Register(name, p, conf.Events...)
+	}
+	return c, nil
+}
+
+// Register adds the processor to the end of the chain. It's only given the
+// events with the given messages, or every event if none are given.
+func (c *Chain) Register(name string, p Processor, events ...string) {
+	en := entry{name: name, processor: p}
+	if len(events) > 0 {
+		en.events = make(map[string]bool)
+		for _, m := range events {
+			en.events[m] = true
+		}
+	}
+	c.entries = append(c.entries, en)
+}
+
+// Process runs the event through each of the processors, returning whether
+// or not the event should be recorded. Processing stops at the first
+// processor to suppress the event. Processors which fail are skipped, so
+// that a processor being unavailable never loses events.
+func (c *Chain) Process(e *Event) bool {
+	for _, en := range c.entries {
+		if en.events != nil && !en.events[e.Message] {
+			continue
+		}
+		// Processors are given a copy, so that a failed processor can't
+		// leave the event half changed
+		pe := *e
+		action, err := en.processor.Process(&pe)
+		if err == nil && action != ActionKeep && action != ActionSuppress {
+			err = ErrInvalidAction
+		}
+		if err != nil {
+			log.Warnf("event processor %s failed: %s", en.name, err)
+			processedEvents.WithLabelValues(en.name, "error").Inc()
+			continue
+		}
+		processedEvents.WithLabelValues(en.name, action).Inc()
+		*e = pe
+		if action == ActionSuppress {
+			return false
+		}
+	}
+	return true
+}
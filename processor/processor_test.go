@@ -0,0 +1,142 @@
+package processor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProcessorSuite struct {
+	suite.Suite
+}
+
+func testEvent() Event {
+	return Event{
+		CampaignId:   1,
+		CampaignName: "Q3 | Finance",
+		RId:          "abc123",
+		Email:        "test@example.com",
+		Message:      "Clicked Link",
+		Time:         time.Date(2018, 7, 19, 10, 30, 0, 0, time.UTC),
+		Details:      json.RawMessage(`{"browser":{"address":"192.0.2.1"}}`),
+	}
+}
+
+func (s *ProcessorSuite) TestNewValidation() {
+	_, err := New([]config.EventProcessor{{Name: "intel", Type: "grpc"}})
+	s.EqualError(err, "intel: "+ErrInvalidType.Error())
+	_, err = New([]config.EventProcessor{{Type: TypeHTTP, URL: "ftp://example.com"}})
+	s.EqualError(err, "processor-1: "+ErrInvalidURL.Error())
+	_, err = New([]config.EventProcessor{{Type: TypePlugin}})
+	s.Equal(ErrPathNotSpecified, err)
+	_, err = New([]config.EventProcessor{{Type: TypePlugin, Path: "/nonexistent/processor.so"}})
+	s.NotNil(err)
+	c, err := New([]config.EventProcessor{{Type: TypeHTTP, URL: "https://example.com/events"}})
+	s.Nil(err)
+	s.Equal(1, len(c.entries))
+}
+
+func (s *ProcessorSuite) TestEnrich() {
+	e := testEvent()
+	s.Nil(e.Enrich(map[string]interface{}{"threat_score": 90}))
+	details := map[string]interface{}{}
+	s.Nil(json.Unmarshal(e.Details, &details))
+	s.Equal(float64(90), details["threat_score"])
+	s.NotNil(details["browser"])
+
+	e.Details = json.RawMessage(`"not an object"`)
+	s.Equal(ErrInvalidDetails, e.Enrich(map[string]interface{}{"threat_score": 90}))
+	e.Details = nil
+	s.Nil(e.Enrich(map[string]interface{}{"threat_score": 90}))
+	s.Equal(`{"threat_score":90}`, string(e.Details))
+}
+
+func (s *ProcessorSuite) TestHTTPProcessor() {
+	response := `{"action": "keep", "details": {"threat_score": 90}}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		s.Nil(err)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		s.Equal(hex.EncodeToString(mac.Sum(nil)), r.Header.Get(SignatureHeader))
+		e := Event{}
+		s.Nil(json.Unmarshal(body, &e))
+		s.Equal("abc123", e.RId)
+		w.Write([]byte(response))
+	}))
+	defer ts.Close()
+	p, err := NewHTTPProcessor(ts.URL, "secret", time.Second)
+	s.Nil(err)
+
+	e := testEvent()
+	action, err := p.Process(&e)
+	s.Nil(err)
+	s.Equal(ActionKeep, action)
+	s.JSONEq(`{"browser":{"address":"192.0.2.1"},"threat_score":90}`, string(e.Details))
+
+	response = `{"action": "suppress"}`
+	action, err = p.Process(&e)
+	s.Nil(err)
+	s.Equal(ActionSuppress, action)
+
+	// An empty response keeps the event unchanged
+	response = ""
+	e = testEvent()
+	action, err = p.Process(&e)
+	s.Nil(err)
+	s.Equal(ActionKeep, action)
+	s.Equal(testEvent().Details, e.Details)
+}
+
+func (s *ProcessorSuite) TestChain() {
+	c := &Chain{}
+	calls := []string{}
+	c.Register("failing", ProcessorFunc(func(e *Event) (string, error) {
+		calls = append(calls, "failing")
+		e.Details = json.RawMessage(`{"half":"changed"}`)
+		return "", errors.New("unavailable")
+	}))
+	c.Register("intel", ProcessorFunc(func(e *Event) (string, error) {
+		calls = append(calls, "intel")
+		return ActionKeep, e.Enrich(map[string]interface{}{"threat_score": 90})
+	}))
+	c.Register("suppress", ProcessorFunc(func(e *Event) (string, error) {
+		calls = append(calls, "suppress")
+		return ActionSuppress, nil
+	}), "Email Opened")
+
+	// Failed processors are skipped without changing the event, and
+	// processors are only given the events they're registered for
+	e := testEvent()
+	s.True(c.Process(&e))
+	s.Equal([]string{"failing", "intel"}, calls)
+	s.JSONEq(`{"browser":{"address":"192.0.2.1"},"threat_score":90}`, string(e.Details))
+
+	calls = []string{}
+	e = testEvent()
+	e.Message = "Email Opened"
+	s.False(c.Process(&e))
+	s.Equal([]string{"failing", "intel", "suppress"}, calls)
+
+	// Unknown actions are treated as failures, which keep the event
+	c = &Chain{}
+	c.Register("invalid", ProcessorFunc(func(e *Event) (string, error) {
+		return "drop", nil
+	}))
+	e = testEvent()
+	s.True(c.Process(&e))
+}
+
+func TestProcessorSuite(t *testing.T) {
+	suite.Run(t, new(ProcessorSuite))
+}
@@ -0,0 +1,505 @@
+// Package qr encodes text, such as a recipient's phishing URL, as a QR code.
+//
+// Codes are encoded in byte mode with the medium (M) error correction level,
+// which recovers from roughly 15% of the code being damaged or obscured.
+// Versions 1 to 20 are supported, which holds up to 666 bytes.
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// ErrTooLong is returned when the text is too long to be encoded as a QR code
+var ErrTooLong = errors.New("Text is too long to be encoded as a QR code")
+
+// QuietZone is the width, in modules, of the light border around a code
+const QuietZone = 4
+
+// block describes a group of error correction blocks in a version
+type block struct {
+	count     int
+	dataBytes int
+}
+
+// version describes the error correction blocks of a QR code version at the
+// M error correction level, along with the positions of its alignment
+// patterns
+type version struct {
+	ecBytes   int
+	blocks    []block
+	alignment []int
+}
+
+// versions are the supported versions, indexed by version number - 1
+var versions = []version{
+	{10, []block{{1, 16}}, nil},
+	{16, []block{{1, 28}}, []int{6, 18}},
+	{26, []block{{1, 44}}, []int{6, 22}},
+	{18, []block{{2, 32}}, []int{6, 26}},
+	{24, []block{{2, 43}}, []int{6, 30}},
+	{16, []block{{4, 27}}, []int{6, 34}},
+	{18, []block{{4, 31}}, []int{6, 22, 38}},
+	{22, []block{{2, 38}, {2, 39}}, []int{6, 24, 42}},
+	{22, []block{{3, 36}, {2, 37}}, []int{6, 26, 46}},
+	{26, []block{{4, 43}, {1, 44}}, []int{6, 28, 50}},
+	{30, []block{{1, 50}, {4, 51}}, []int{6, 30, 54}},
+	{22, []block{{6, 36}, {2, 37}}, []int{6, 32, 58}},
+	{22, []block{{8, 37}, {1, 38}}, []int{6, 34, 62}},
+	{24, []block{{4, 40}, {5, 41}}, []int{6, 26, 46, 66}},
+	{24, []block{{5, 41}, {5, 42}}, []int{6, 26, 48, 70}},
+	{28, []block{{7, 45}, {3, 46}}, []int{6, 26, 50, 74}},
+	{28, []block{{10, 46}, {1, 47}}, []int{6, 30, 54, 78}},
+	{26, []block{{9, 43}, {4, 44}}, []int{6, 30, 56, 82}},
+	{26, []block{{3, 44}, {11, 45}}, []int{6, 30, 58, 86}},
+	{26, []block{{3, 41}, {13, 42}}, []int{6, 34, 62, 90}},
+}
+
+// dataBytes returns the number of data codewords in the version
+func (v version) dataBytes() int {
+	n := 0
+	for _, b := range v.blocks {
+		n += b.count * b.dataBytes
+	}
+	return n
+}
+
+// Code is an encoded QR code
+type Code struct {
+	// Version is the QR code version, which determines its size
+	Version int
+	// Size is the width and height of the code in modules, excluding the
+	// quiet zone
+	Size int
+
+	modules  [][]bool
+	function [][]bool
+}
+
+// Black returns whether the module at the given column and row is dark.
+// Modules outside of the code are light.
+func (c *Code) Black(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+	return c.modules[y][x]
+}
+
+// Image returns the code as an image, with each module drawn as a square of
+// scale pixels and surrounded by the quiet zone
+func (c *Code) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+	size := (c.Size + 2*QuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c.Black(x/scale-QuietZone, y/scale-QuietZone) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// PNG returns the code as a PNG image. See Image.
+func (c *Code) PNG(scale int) ([]byte, error) {
+	buff := &bytes.Buffer{}
+	err := png.Encode(buff, c.Image(scale))
+	return buff.Bytes(), err
+}
+
+// Encode returns the smallest QR code which holds the text
+func Encode(text string) (*Code, error) {
+	for i, v := range versions {
+		n := i + 1
+		// The mode indicator, character count and data must fit
+		bits := 4 + countBits(n) + 8*len(text)
+		if bits <= v.dataBytes()*8 {
+			return encode(n, v, []byte(text)), nil
+		}
+	}
+	return nil, ErrTooLong
+}
+
+// countBits returns the length of the character count in byte mode
+func countBits(n int) int {
+	if n < 10 {
+		return 8
+	}
+	return 16
+}
+
+// bitBuffer accumulates the bits of the data codewords
+type bitBuffer struct {
+	bytes []byte
+	n     int
+}
+
+func (b *bitBuffer) append(v uint, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if b.n%8 == 0 {
+			b.bytes = append(b.bytes, 0)
+		}
+		if (v>>uint(i))&1 == 1 {
+			b.bytes[b.n/8] |= 0x80 >> uint(b.n%8)
+		}
+		b.n++
+	}
+}
+
+// dataCodewords returns the data codewords holding the text, padded to the
+// version's capacity
+func dataCodewords(n int, v version, data []byte) []byte {
+	capacity := v.dataBytes() * 8
+	b := &bitBuffer{}
+	b.append(0x4, 4)
+	b.append(uint(len(data)), countBits(n))
+	for _, d := range data {
+		b.append(uint(d), 8)
+	}
+	// Terminate the data, then pad to a whole number of codewords
+	terminator := capacity - b.n
+	if terminator > 4 {
+		terminator = 4
+	}
+	b.append(0, terminator)
+	if b.n%8 != 0 {
+		b.append(0, 8-b.n%8)
+	}
+	for pad := uint(0xEC); b.n < capacity; pad ^= 0xEC ^ 0x11 {
+		b.append(pad, 8)
+	}
+	return b.bytes
+}
+
+// codewords splits the data codewords into the version's blocks, adds the
+// error correction codewords to each and interleaves them
+func codewords(v version, data []byte) []byte {
+	generator := rsGenerator(v.ecBytes)
+	dataBlocks := [][]byte{}
+	ecBlocks := [][]byte{}
+	for _, b := range v.blocks {
+		for i := 0; i < b.count; i++ {
+			d := data[:b.dataBytes]
+			data = data[b.dataBytes:]
+			dataBlocks = append(dataBlocks, d)
+			ecBlocks = append(ecBlocks, rsRemainder(d, generator))
+		}
+	}
+	result := []byte{}
+	for i := 0; ; i++ {
+		added := false
+		for _, d := range dataBlocks {
+			if i < len(d) {
+				result = append(result, d[i])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	for i := 0; i < v.ecBytes; i++ {
+		for _, ec := range ecBlocks {
+			result = append(result, ec[i])
+		}
+	}
+	return result
+}
+
+// encode draws the QR code for the data, choosing the mask which makes the
+// code easiest to scan
+func encode(n int, v version, data []byte) *Code {
+	cws := codewords(v, dataCodewords(n, v, data))
+	size := 17 + 4*n
+	best := (*Code)(nil)
+	bestPenalty := 0
+	for mask := 0; mask < 8; mask++ {
+		c := &Code{Version: n, Size: size}
+		c.modules = make([][]bool, size)
+		c.function = make([][]bool, size)
+		for i := range c.modules {
+			c.modules[i] = make([]bool, size)
+			c.function[i] = make([]bool, size)
+		}
+		c.drawFunctionPatterns(v)
+		c.drawCodewords(cws)
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if p := c.penalty(); best == nil || p < bestPenalty {
+			best, bestPenalty = c, p
+		}
+	}
+	return best
+}
+
+// set sets a function module, which isn't masked
+func (c *Code) set(x, y int, black bool) {
+	c.modules[y][x] = black
+	c.function[y][x] = true
+}
+
+// drawFunctionPatterns draws the finder, timing and alignment patterns, and
+// reserves the areas holding the format and version information
+func (c *Code) drawFunctionPatterns(v version) {
+	for i := 0; i < c.Size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+	last := len(v.alignment) - 1
+	for i, x := range v.alignment {
+		for j, y := range v.alignment {
+			// Alignment patterns don't overlap the finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			c.drawAlignment(x, y)
+		}
+	}
+	// Reserve the format information until the mask is chosen
+	c.drawFormat(0)
+	c.drawVersion()
+}
+
+// drawFinder draws the finder pattern, and its separator, centered on the
+// given module
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+			d := distance(dx, dy)
+			c.set(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+// drawAlignment draws the alignment pattern centered on the given module
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.set(x+dx, y+dy, distance(dx, dy) != 1)
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information for the mask, along
+// with the dark module
+func (c *Code) drawFormat(mask int) {
+	bits := formatBits(mask)
+	bit := func(i int) bool {
+		return (bits>>uint(i))&1 == 1
+	}
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(i))
+	}
+	c.set(8, c.Size-8, true)
+}
+
+// formatBits returns the format information for the mask, which is the
+// error correction level and mask protected by a BCH code
+func formatBits(mask int) int {
+	// The M error correction level is encoded as 00
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// versionBits returns the version information for the version, protected
+// by a BCH code
+func versionBits(n int) int {
+	rem := n
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return n<<12 | rem
+}
+
+// drawVersion draws both copies of the version information, which codes from
+// version 7 include
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	bits := versionBits(c.Version)
+	for i := 0; i < 18; i++ {
+		black := (bits>>uint(i))&1 == 1
+		a, b := c.Size-11+i%3, i/3
+		c.set(a, b, black)
+		c.set(b, a, black)
+	}
+}
+
+// drawCodewords places the codewords in the modules which aren't part of a
+// function pattern, in two module wide columns zigzagging up and down from
+// the bottom right corner
+func (c *Code) drawCodewords(cws []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		// Skip the vertical timing pattern
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if c.function[y][x] || i >= len(cws)*8 {
+					continue
+				}
+				c.modules[y][x] = (cws[i/8]>>uint(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask inverts the modules, other than the function patterns, selected
+// by the mask
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.function[y][x] {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to scan, penalizing long runs of
+// modules of the same color, blocks of the same color, patterns which look
+// like a finder pattern and an imbalance of dark and light modules
+func (c *Code) penalty() int {
+	p := 0
+	finder := []bool{true, false, true, true, true, false, true}
+	for i := 0; i < c.Size; i++ {
+		row := make([]bool, c.Size)
+		col := make([]bool, c.Size)
+		for j := 0; j < c.Size; j++ {
+			row[j] = c.modules[i][j]
+			col[j] = c.modules[j][i]
+		}
+		for _, line := range [][]bool{row, col} {
+			run := 1
+			for j := 1; j <= len(line); j++ {
+				if j < len(line) && line[j] == line[j-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p += 3 + run - 5
+				}
+				run = 1
+			}
+			for j := 0; j+len(finder) <= len(line); j++ {
+				if !matches(line[j:j+len(finder)], finder) {
+					continue
+				}
+				if lightRun(line, j-4, j) || lightRun(line, j+len(finder), j+len(finder)+4) {
+					p += 40
+				}
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size {
+				m := c.modules[y][x]
+				if c.modules[y][x+1] == m && c.modules[y+1][x] == m && c.modules[y+1][x+1] == m {
+					p += 3
+				}
+			}
+		}
+	}
+	total := c.Size * c.Size
+	percent := dark * 100 / total
+	p += abs(percent-50) / 5 * 10
+	return p
+}
+
+// matches returns whether the modules match the pattern
+func matches(line, pattern []bool) bool {
+	for i := range pattern {
+		if line[i] != pattern[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lightRun returns whether the modules from start up to end are light,
+// counting modules outside of the code as light
+func lightRun(line []bool, start, end int) bool {
+	for i := start; i < end; i++ {
+		if i >= 0 && i < len(line) && line[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// distance returns the Chebyshev distance of the offset from the origin
+func distance(dx, dy int) int {
+	if abs(dx) > abs(dy) {
+		return abs(dx)
+	}
+	return abs(dy)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
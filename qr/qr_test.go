@@ -0,0 +1,196 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type QRSuite struct {
+	suite.Suite
+}
+
+func (s *QRSuite) TestReedSolomon() {
+	// The data and error correction codewords of "HELLO WORLD" as a 1-M code
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	s.Equal(expected, rsRemainder(data, rsGenerator(10)))
+}
+
+func (s *QRSuite) TestDataCodewords() {
+	expected := []byte{0x40, 0x56, 0x86, 0x56, 0xC6, 0xC6, 0xF0, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC}
+	s.Equal(expected, dataCodewords(1, versions[0], []byte("hello")))
+}
+
+func (s *QRSuite) TestFormatAndVersionBits() {
+	s.Equal(0x5412, formatBits(0))
+	s.Equal(0x5125, formatBits(1))
+	s.Equal(0x7C94, versionBits(7))
+}
+
+func (s *QRSuite) TestVersionCapacity() {
+	totals := []int{26, 44, 70, 100, 134, 172, 196, 242, 292, 346, 404, 466, 532, 581, 655, 733, 815, 901, 991, 1085}
+	for i, v := range versions {
+		n := i + 1
+		total := v.dataBytes()
+		for _, b := range v.blocks {
+			total += b.count * v.ecBytes
+		}
+		s.Equal(totals[i], total, n)
+		// Every module which isn't part of a function pattern holds data
+		c := encode(n, v, nil)
+		modules := 0
+		for y := 0; y < c.Size; y++ {
+			for x := 0; x < c.Size; x++ {
+				if !c.function[y][x] {
+					modules++
+				}
+			}
+		}
+		s.Equal(total, modules/8, n)
+	}
+	c, err := Encode(strings.Repeat("a", 14))
+	s.Nil(err)
+	s.Equal(1, c.Version)
+	s.Equal(21, c.Size)
+	c, err = Encode(strings.Repeat("a", 15))
+	s.Nil(err)
+	s.Equal(2, c.Version)
+	c, err = Encode(strings.Repeat("a", 666))
+	s.Nil(err)
+	s.Equal(20, c.Version)
+	_, err = Encode(strings.Repeat("a", 667))
+	s.Equal(ErrTooLong, err)
+}
+
+// readCodewords reads the codewords back out of the code, undoing the mask
+// given by its format information
+func readCodewords(c *Code) ([]byte, int) {
+	bits := 0
+	for i := 0; i <= 5; i++ {
+		if c.Black(8, i) {
+			bits |= 1 << uint(i)
+		}
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		// Only the low bits are compared, which differ for every mask
+		if formatBits(m)&0x3F == bits {
+			mask = m
+		}
+	}
+	unmasked := &Code{Version: c.Version, Size: c.Size, modules: c.modules, function: c.function}
+	unmasked.modules = make([][]bool, c.Size)
+	for y := range unmasked.modules {
+		unmasked.modules[y] = append([]bool{}, c.modules[y]...)
+	}
+	unmasked.applyMask(mask)
+	cws := []byte{}
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if c.function[y][x] {
+					continue
+				}
+				if i%8 == 0 {
+					cws = append(cws, 0)
+				}
+				if unmasked.modules[y][x] {
+					cws[i/8] |= 0x80 >> uint(i%8)
+				}
+				i++
+			}
+		}
+	}
+	return cws, mask
+}
+
+func (s *QRSuite) TestEncode() {
+	text := "https://example.com/?rid=Xz91bQa&qr=1"
+	c, err := Encode(text)
+	s.Nil(err)
+	s.Equal(3, c.Version)
+	// The finder patterns are in three of the corners
+	for _, corner := range [][2]int{{0, 0}, {c.Size - 7, 0}, {0, c.Size - 7}} {
+		for d := 0; d < 7; d++ {
+			s.True(c.Black(corner[0]+d, corner[1]))
+			s.True(c.Black(corner[0], corner[1]+d))
+		}
+		s.False(c.Black(corner[0]+1, corner[1]+1))
+		s.True(c.Black(corner[0]+3, corner[1]+3))
+	}
+	s.True(c.Black(8, c.Size-8))
+
+	v := versions[c.Version-1]
+	cws, mask := readCodewords(c)
+	s.NotEqual(-1, mask)
+	expected := codewords(v, dataCodewords(c.Version, v, []byte(text)))
+	s.Equal(expected, cws[:len(expected)])
+	// Both copies of the format information match
+	first, second := 0, 0
+	for i := 0; i < 8; i++ {
+		if c.Black(c.Size-1-i, 8) {
+			second |= 1 << uint(i)
+		}
+	}
+	for i := 0; i <= 5; i++ {
+		if c.Black(8, i) {
+			first |= 1 << uint(i)
+		}
+	}
+	if c.Black(8, 7) {
+		first |= 1 << 6
+	}
+	if c.Black(8, 8) {
+		first |= 1 << 7
+	}
+	s.Equal(first, second)
+	s.Equal(formatBits(mask)&0xFF, first)
+}
+
+func (s *QRSuite) TestVersionInformation() {
+	c, err := Encode(strings.Repeat("a", 120))
+	s.Nil(err)
+	s.Equal(7, c.Version)
+	v := versionBits(7)
+	for i := 0; i < 18; i++ {
+		black := (v>>uint(i))&1 == 1
+		s.Equal(black, c.Black(c.Size-11+i%3, i/3))
+		s.Equal(black, c.Black(i/3, c.Size-11+i%3))
+	}
+	cws, _ := readCodewords(c)
+	expected := codewords(versions[6], dataCodewords(7, versions[6], []byte(strings.Repeat("a", 120))))
+	s.Equal(expected, cws[:len(expected)])
+}
+
+func (s *QRSuite) TestPNG() {
+	c, err := Encode("https://example.com")
+	s.Nil(err)
+	b, err := c.PNG(4)
+	s.Nil(err)
+	img, err := png.Decode(bytes.NewReader(b))
+	s.Nil(err)
+	size := (c.Size + 2*QuietZone) * 4
+	s.Equal(size, img.Bounds().Dx())
+	s.Equal(size, img.Bounds().Dy())
+	// The quiet zone is light, and the top left finder pattern starts after it
+	r, _, _, _ := img.At(0, 0).RGBA()
+	s.Equal(uint32(0xFFFF), r)
+	r, _, _, _ = img.At(QuietZone*4, QuietZone*4).RGBA()
+	s.Equal(uint32(0), r)
+}
+
+func TestQRSuite(t *testing.T) {
+	suite.Run(t, new(QRSuite))
+}
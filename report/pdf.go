@@ -0,0 +1,220 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The layout of PDF reports, which are set in 8 point Courier on landscape A4
+// pages. Sizes are in points.
+const (
+	pdfPageWidth    = 842
+	pdfPageHeight   = 595
+	pdfMargin       = 36
+	pdfFontSize     = 8
+	pdfLineHeight   = 10
+	pdfCharsPerLine = (pdfPageWidth - 2*pdfMargin) * 10 / (6 * pdfFontSize)
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin - pdfLineHeight) / pdfLineHeight
+)
+
+// maxColumnWidth is the most characters a column of a table is given in a
+// PDF report. Longer cells are cut short.
+const maxColumnWidth = 40
+
+// minColumnWidth is the fewest characters a column is shrunk to when a table
+// is too wide for the page
+const minColumnWidth = 4
+
+// pdfLine is a line of text on a page of a PDF report
+type pdfLine struct {
+	text string
+	bold bool
+}
+
+// pdfText converts the text to the Latin-1 characters the report's fonts can
+// show, replacing those they can't with "?" and control characters with
+// spaces.
+func pdfText(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x20:
+			b = append(b, ' ')
+		case r < 0x7f || (r >= 0xa0 && r <= 0xff):
+			b = append(b, byte(r))
+		default:
+			b = append(b, '?')
+		}
+	}
+	return string(b)
+}
+
+// columnWidths returns the width of each of the table's columns, shrinking
+// the widest until the table fits the width of the page.
+func columnWidths(header []string, rows [][]string) []int {
+	widths := make([]int, len(header))
+	for i, h := range header {
+		widths[i] = len(h)
+	}
+	for _, r := range rows {
+		for i, c := range r {
+			if i < len(widths) && len(c) > widths[i] {
+				widths[i] = len(c)
+			}
+		}
+	}
+	total := 0
+	for i := range widths {
+		if widths[i] > maxColumnWidth {
+			widths[i] = maxColumnWidth
+		}
+		total += widths[i] + 2
+	}
+	for total-2 > pdfCharsPerLine {
+		widest := 0
+		for i := range widths {
+			if widths[i] > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= minColumnWidth {
+			break
+		}
+		widths[widest]--
+		total--
+	}
+	return widths
+}
+
+// formatRow lays out the cells in columns of the given widths
+func formatRow(cells []string, widths []int) string {
+	parts := make([]string, len(widths))
+	for i, w := range widths {
+		c := ""
+		if i < len(cells) {
+			c = cells[i]
+		}
+		if len(c) > w {
+			c = c[:w]
+		}
+		parts[i] = c + strings.Repeat(" ", w-len(c))
+	}
+	line := strings.TrimRight(strings.Join(parts, "  "), " ")
+	if len(line) > pdfCharsPerLine {
+		line = line[:pdfCharsPerLine]
+	}
+	return line
+}
+
+// tableLines lays out the table as lines of text
+func tableLines(t Table) []pdfLine {
+	header := make([]string, len(t.Header))
+	for i, h := range t.Header {
+		header[i] = pdfText(h)
+	}
+	rows := make([][]string, len(t.Rows))
+	for i, r := range t.Rows {
+		rows[i] = make([]string, len(r))
+		for j, c := range r {
+			rows[i][j] = pdfText(c)
+		}
+	}
+	widths := columnWidths(header, rows)
+	rule := make([]string, len(widths))
+	for i, w := range widths {
+		rule[i] = strings.Repeat("-", w)
+	}
+	lines := []pdfLine{
+		{pdfText(t.Title), true},
+		{formatRow(header, widths), true},
+		{formatRow(rule, widths), false},
+	}
+	for _, r := range rows {
+		lines = append(lines, pdfLine{formatRow(r, widths), false})
+	}
+	return lines
+}
+
+// escapePDF escapes the text for use in a PDF string
+func escapePDF(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "(", "\\(", ")", "\\)").Replace(s)
+}
+
+// pageContent returns the content stream drawing the lines of a page, along
+// with its page number
+func pageContent(lines []pdfLine, page int, pages int) string {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLineHeight, pdfMargin, pdfPageHeight-pdfMargin-pdfFontSize)
+	bold := false
+	for _, l := range lines {
+		if l.bold != bold {
+			font := "F1"
+			if l.bold {
+				font = "F2"
+			}
+			fmt.Fprintf(b, "/%s %d Tf\n", font, pdfFontSize)
+			bold = l.bold
+		}
+		fmt.Fprintf(b, "(%s) Tj T*\n", escapePDF(l.text))
+	}
+	b.WriteString("ET\n")
+	fmt.Fprintf(b, "BT\n/F1 %d Tf\n%d %d Td\n(%s) Tj\nET\n", pdfFontSize, pdfMargin, pdfMargin-pdfFontSize,
+		escapePDF(fmt.Sprintf("Page %d of %d", page, pages)))
+	return b.String()
+}
+
+// WritePDF writes the report to w as a PDF document, starting with the
+// report's title and followed by each table. Columns wider than the page
+// allows are cut short.
+func WritePDF(w io.Writer, title string, ts []Table) error {
+	lines := []pdfLine{{pdfText(title), true}, {"", false}}
+	for i, t := range ts {
+		if i > 0 {
+			lines = append(lines, pdfLine{"", false})
+		}
+		lines = append(lines, tableLines(t)...)
+	}
+	var pages [][]pdfLine
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	// Objects 1 to 4 are the catalog, page tree and fonts, followed by each
+	// page and its content stream
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>",
+	}
+	kids := []string{}
+	for i, p := range pages {
+		page := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+		objects = append(objects, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, page+1))
+		content := pageContent(p, i+1, len(pages))
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	b := &bytes.Buffer{}
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := b.Len()
+	fmt.Fprintf(b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(b, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	_, err := b.WriteTo(w)
+	return err
+}
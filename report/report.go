@@ -0,0 +1,85 @@
+// Package report writes tabular reports as CSV, XLSX and PDF files.
+//
+// A report is a list of tables, each with a title, a header and rows of
+// text. CSV reports list the tables one after another, XLSX reports put each
+// table on its own worksheet, and PDF reports lay the tables out as
+// fixed-width text on landscape A4 pages.
+package report
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+)
+
+// The formats a report can be written in
+const (
+	FormatCSV  string = "csv"
+	FormatXLSX string = "xlsx"
+	FormatPDF  string = "pdf"
+)
+
+// ErrInvalidFormat is returned when a report is written in a format which
+// isn't supported
+var ErrInvalidFormat = errors.New("Report format must be csv, xlsx or pdf")
+
+// Table is a titled table of text in a report
+type Table struct {
+	Title  string
+	Header []string
+	Rows   [][]string
+}
+
+// ContentType returns the MIME type of reports written in the given format
+func ContentType(format string) string {
+	switch format {
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case FormatPDF:
+		return "application/pdf"
+	}
+	return "text/csv"
+}
+
+// Write writes the report with the given title and tables to w in the given
+// format.
+func Write(w io.Writer, format string, title string, ts []Table) error {
+	switch format {
+	case FormatCSV:
+		return WriteCSV(w, ts)
+	case FormatXLSX:
+		return WriteXLSX(w, ts)
+	case FormatPDF:
+		return WritePDF(w, title, ts)
+	}
+	return ErrInvalidFormat
+}
+
+// WriteCSV writes the tables to w as a single CSV file. Each table starts
+// with a row holding its title, followed by its header and rows, and tables
+// are separated by an empty row.
+func WriteCSV(w io.Writer, ts []Table) error {
+	cw := csv.NewWriter(w)
+	for i, t := range ts {
+		if i > 0 {
+			err := cw.Write([]string{""})
+			if err != nil {
+				return err
+			}
+		}
+		err := cw.Write([]string{t.Title})
+		if err != nil {
+			return err
+		}
+		err = cw.Write(t.Header)
+		if err != nil {
+			return err
+		}
+		err = cw.WriteAll(t.Rows)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
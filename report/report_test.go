@@ -0,0 +1,120 @@
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ReportSuite struct {
+	suite.Suite
+}
+
+var testTables = []Table{
+	Table{Title: "Summary", Header: []string{"Metric", "Value"}, Rows: [][]string{{"Sent", "10"}, {"Clicked", "3"}}},
+	Table{Title: "Targets", Header: []string{"Email", "Zip"}, Rows: [][]string{{"a&b@example.com", "02134"}}},
+}
+
+func (s *ReportSuite) TestWriteCSV() {
+	b := &bytes.Buffer{}
+	s.Nil(Write(b, FormatCSV, "Report", testTables))
+	r := csv.NewReader(bytes.NewReader(b.Bytes()))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	s.Nil(err)
+	s.Equal([]string{"Summary"}, records[0])
+	s.Equal([]string{"Metric", "Value"}, records[1])
+	// The empty row separating the tables is skipped by the reader
+	s.Equal([]string{"Targets"}, records[4])
+	s.Equal(7, len(records))
+	s.Contains(b.String(), "3\n\nTargets")
+}
+
+func (s *ReportSuite) TestWriteXLSX() {
+	b := &bytes.Buffer{}
+	s.Nil(Write(b, FormatXLSX, "Report", testTables))
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	s.Nil(err)
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		s.Nil(err)
+		content, err := ioutil.ReadAll(rc)
+		s.Nil(err)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+	s.Contains(files["xl/workbook.xml"], `<sheet name="Summary" sheetId="1" r:id="rId1"/>`)
+	s.Contains(files["xl/workbook.xml"], `<sheet name="Targets" sheetId="2" r:id="rId2"/>`)
+	s.Contains(files["[Content_Types].xml"], "/xl/worksheets/sheet2.xml")
+	// Numbers are written as numbers, but text which looks like a number
+	// with leading zeros isn't
+	s.Contains(files["xl/worksheets/sheet1.xml"], `<c r="B2"><v>10</v></c>`)
+	s.Contains(files["xl/worksheets/sheet2.xml"], `<t xml:space="preserve">a&amp;b@example.com</t>`)
+	s.Contains(files["xl/worksheets/sheet2.xml"], `<c r="B2" t="inlineStr"><is><t xml:space="preserve">02134</t></is></c>`)
+}
+
+func (s *ReportSuite) TestSheetName() {
+	seen := make(map[string]bool)
+	s.Equal("Sheet1", sheetName("", 0, seen))
+	s.Equal("Q1 Results (EMEA)", sheetName("Q1/Results [EMEA]", 1, seen))
+	long := strings.Repeat("x", 40)
+	s.Equal(strings.Repeat("x", 31), sheetName(long, 2, seen))
+	s.Equal(strings.Repeat("x", 27)+" (2)", sheetName(long, 3, seen))
+	s.Equal("AA", columnName(26))
+	s.Equal("Z", columnName(25))
+}
+
+func (s *ReportSuite) TestWritePDF() {
+	ts := []Table{Table{Title: "Timeline (all)", Header: []string{"Event"}}}
+	for i := 0; i < 100; i++ {
+		ts[0].Rows = append(ts[0].Rows, []string{fmt.Sprintf("Event %d", i)})
+	}
+	b := &bytes.Buffer{}
+	s.Nil(Write(b, FormatPDF, "Report", ts))
+	pdf := b.String()
+	s.True(strings.HasPrefix(pdf, "%PDF-1.4\n"))
+	s.True(strings.HasSuffix(pdf, "%%EOF\n"))
+	s.Contains(pdf, "/Count 3")
+	s.Contains(pdf, `(Timeline \(all\)) Tj`)
+	s.Contains(pdf, "(Page 3 of 3) Tj")
+
+	// The cross-reference table points at each object
+	start := strings.Index(pdf, "xref\n")
+	s.Contains(pdf, fmt.Sprintf("startxref\n%d\n", start))
+	entries := strings.Split(pdf[start:], "\n")[3:]
+	for i := 1; i <= 10; i++ {
+		var offset int
+		fmt.Sscanf(entries[i-1], "%d", &offset)
+		s.True(strings.HasPrefix(pdf[offset:], fmt.Sprintf("%d 0 obj", i)))
+	}
+}
+
+func (s *ReportSuite) TestColumnWidths() {
+	widths := columnWidths([]string{"a", "b"}, [][]string{{strings.Repeat("x", 100), strings.Repeat("y", 10)}})
+	s.Equal([]int{maxColumnWidth, 10}, widths)
+	header := make([]string, 10)
+	for i := range header {
+		header[i] = strings.Repeat("h", 30)
+	}
+	total := 0
+	for _, w := range columnWidths(header, nil) {
+		total += w + 2
+	}
+	s.True(total-2 <= pdfCharsPerLine)
+	s.Equal("caf\xe9 ?", pdfText("café ☃"))
+}
+
+func (s *ReportSuite) TestInvalidFormat() {
+	s.Equal(ErrInvalidFormat, Write(&bytes.Buffer{}, "docx", "Report", testTables))
+}
+
+func TestReportSuite(t *testing.T) {
+	suite.Run(t, new(ReportSuite))
+}
@@ -0,0 +1,149 @@
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxSheetName is the longest name a worksheet can have
+const maxSheetName = 31
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+%s</Types>`
+
+const xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets>%s</sheets>
+</workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+%s</Relationships>`
+
+// numberPattern matches the cells written as numbers. Numbers with leading
+// zeros, such as postal codes, are kept as text.
+var numberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]{0,14})(\.[0-9]+)?$`)
+
+// sheetNameReplacer removes the characters worksheet names can't contain
+var sheetNameReplacer = strings.NewReplacer(":", " ", "\\", " ", "/", " ", "?", " ", "*", " ", "[", "(", "]", ")")
+
+// sheetName returns a unique worksheet name for the table's title
+func sheetName(title string, i int, seen map[string]bool) string {
+	name := strings.TrimSpace(sheetNameReplacer.Replace(title))
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", i+1)
+	}
+	if len([]rune(name)) > maxSheetName {
+		name = string([]rune(name)[:maxSheetName])
+	}
+	for base, n := name, 2; seen[strings.ToLower(name)]; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		r := []rune(base)
+		if len(r)+len(suffix) > maxSheetName {
+			r = r[:maxSheetName-len(suffix)]
+		}
+		name = string(r) + suffix
+	}
+	seen[strings.ToLower(name)] = true
+	return name
+}
+
+// escapeXML returns the text escaped for use in XML
+func escapeXML(s string) string {
+	b := &bytes.Buffer{}
+	xml.EscapeText(b, []byte(s))
+	return b.String()
+}
+
+// columnName returns the letters naming the column with the given index,
+// such as "A" for 0 and "AA" for 26
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// writeRow writes the cells of the row with the given index to the sheet.
+// Cells which are numbers are written as numbers, so that they can be
+// calculated with, and the rest are written as inline strings.
+func writeRow(b *bytes.Buffer, row int, cells []string) {
+	fmt.Fprintf(b, `<row r="%d">`, row+1)
+	for i, c := range cells {
+		ref := columnName(i) + strconv.Itoa(row+1)
+		if numberPattern.MatchString(c) {
+			fmt.Fprintf(b, `<c r="%s"><v>%s</v></c>`, ref, c)
+			continue
+		}
+		fmt.Fprintf(b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, escapeXML(c))
+	}
+	b.WriteString(`</row>`)
+}
+
+// WriteXLSX writes the tables to w as an XLSX workbook, with each table on
+// its own worksheet named after the table's title.
+func WriteXLSX(w io.Writer, ts []Table) error {
+	// A workbook must have at least one worksheet
+	if len(ts) == 0 {
+		ts = []Table{Table{}}
+	}
+	zw := zip.NewWriter(w)
+	overrides, sheets, rels := &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}
+	seen := make(map[string]bool)
+	for i, t := range ts {
+		fmt.Fprintf(overrides, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`+"\n", i+1)
+		fmt.Fprintf(sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escapeXML(sheetName(t.Title, i, seen)), i+1, i+1)
+		fmt.Fprintf(rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`+"\n", i+1, i+1)
+		b := &bytes.Buffer{}
+		b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+		b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+		writeRow(b, 0, t.Header)
+		for j, r := range t.Rows {
+			writeRow(b, j+1, r)
+		}
+		b.WriteString(`</sheetData></worksheet>`)
+		err := writeZipFile(zw, fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), b.String())
+		if err != nil {
+			return err
+		}
+	}
+	files := []struct{ name, content string }{
+		{"[Content_Types].xml", fmt.Sprintf(xlsxContentTypes, overrides)},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, sheets)},
+		{"xl/_rels/workbook.xml.rels", fmt.Sprintf(xlsxWorkbookRels, rels)},
+	}
+	for _, f := range files {
+		err := writeZipFile(zw, f.name, f.content)
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// writeZipFile adds a file with the given name and content to the zip file
+func writeZipFile(zw *zip.Writer, name string, content string) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, content)
+	return err
+}
@@ -0,0 +1,500 @@
+// Package sso lets users log in to the admin server through an OpenID Connect
+// provider, such as Okta, Azure AD or Keycloak, using the authorization code
+// flow. The provider's endpoints and signing keys are discovered from its
+// issuer URL, and the ID tokens it returns are verified before the identity
+// they contain is used.
+package sso
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophish/gophish/config"
+)
+
+// DefaultScopes are the scopes requested if no other scopes are configured
+var DefaultScopes = []string{"openid", "profile", "email"}
+
+// DefaultUsernameClaim is the ID token claim used as the username, if no
+// other claim is configured. Users are identified by their email claim if
+// the token doesn't contain it.
+const DefaultUsernameClaim = "preferred_username"
+
+// DefaultGroupsClaim is the ID token claim containing the groups the user is
+// in, if no other claim is configured.
+const DefaultGroupsClaim = "groups"
+
+// Timeout is the maximum amount of time to wait for the provider to respond
+var Timeout = 10 * time.Second
+
+// ClockSkew is how far the provider's clock can be from gophish's when
+// checking when ID tokens expire
+var ClockSkew = time.Minute
+
+// MaxResponseSize is the largest response read from the provider
+var MaxResponseSize int64 = 1 << 20
+
+// ErrInvalidIssuer is thrown when the issuer isn't an absolute https URL, or
+// when the provider's configuration is for a different issuer
+var ErrInvalidIssuer = errors.New("SSO issuer must be the https URL of an OpenID Connect provider")
+
+// ErrInvalidRedirectURL is thrown when the redirect URL isn't an absolute
+// http(s) URL
+var ErrInvalidRedirectURL = errors.New("SSO redirect URL must be an http or https URL")
+
+// ErrClientIDNotSpecified is thrown when no client ID is configured
+var ErrClientIDNotSpecified = errors.New("SSO client ID not specified")
+
+// ErrInvalidToken is thrown when an ID token can't be parsed, or isn't
+// signed by one of the provider's keys
+var ErrInvalidToken = errors.New("Invalid ID token")
+
+// ErrUnsupportedAlgorithm is thrown when an ID token isn't signed with RS256
+// or ES256
+var ErrUnsupportedAlgorithm = errors.New("ID token must be signed with RS256 or ES256")
+
+// ErrInvalidClaims is thrown when an ID token wasn't issued by the provider
+// to gophish, has expired, or doesn't contain the nonce sent to the provider
+var ErrInvalidClaims = errors.New("ID token isn't valid for this login")
+
+// ErrEmailNotVerified is thrown when an ID token doesn't say that the
+// provider has verified the user's email address
+var ErrEmailNotVerified = errors.New("SSO provider hasn't verified the user's email address")
+
+// ErrNoIDToken is thrown when the provider's token response doesn't contain
+// an ID token
+var ErrNoIDToken = errors.New("SSO provider didn't return an ID token")
+
+// Identity is the user a verified ID token was issued for
+type Identity struct {
+	// Subject uniquely identifies the user at the provider
+	Subject  string
+	Username string
+	Email    string
+	Groups   []string
+}
+
+// discovery holds the fields used from the provider's configuration
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// jwk is one of the provider's signing keys
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// tokenResponse is the provider's response to exchanging an authorization
+// code
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Provider is an OpenID Connect provider gophish is registered with as a
+// client. Its configuration and keys are fetched the first time they're
+// needed, and the keys are fetched again if a token is signed with a key
+// which isn't known.
+type Provider struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	UsernameClaim string
+	GroupsClaim   string
+	Client        *http.Client
+
+	mu   sync.Mutex
+	conf *discovery
+	keys map[string]crypto.PublicKey
+}
+
+// NewProvider returns the provider in the given configuration
+func NewProvider(c config.SSO) (*Provider, error) {
+	u, err := url.Parse(c.Issuer)
+	if err != nil || u.Host == "" || u.Scheme != "https" {
+		return nil, ErrInvalidIssuer
+	}
+	if c.ClientID == "" {
+		return nil, ErrClientIDNotSpecified
+	}
+	u, err = url.Parse(c.RedirectURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, ErrInvalidRedirectURL
+	}
+	p := &Provider{
+		Issuer:        strings.TrimSuffix(c.Issuer, "/"),
+		ClientID:      c.ClientID,
+		ClientSecret:  c.ClientSecret,
+		RedirectURL:   c.RedirectURL,
+		Scopes:        c.Scopes,
+		UsernameClaim: c.UsernameClaim,
+		GroupsClaim:   c.GroupsClaim,
+		Client:        &http.Client{Timeout: Timeout},
+	}
+	if len(p.Scopes) == 0 {
+		p.Scopes = DefaultScopes
+	}
+	if p.UsernameClaim == "" {
+		p.UsernameClaim = DefaultUsernameClaim
+	}
+	if p.GroupsClaim == "" {
+		p.GroupsClaim = DefaultGroupsClaim
+	}
+	return p, nil
+}
+
+// getJSON decodes the JSON response to the request into v
+func (p *Provider) getJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxResponseSize))
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		return fmt.Errorf("unexpected status from SSO provider: %s", resp.Status)
+	}
+	return err
+}
+
+// discover returns the provider's configuration, fetching it if needed
+func (p *Provider) discover() (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conf != nil {
+		return p.conf, nil
+	}
+	req, err := http.NewRequest("GET", p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	d := &discovery{}
+	err = p.getJSON(req, d)
+	if err != nil {
+		return nil, err
+	}
+	// The configuration must be for the issuer it was fetched from, so that
+	// tokens from other issuers aren't accepted
+	if strings.TrimSuffix(d.Issuer, "/") != p.Issuer {
+		return nil, ErrInvalidIssuer
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("SSO provider configuration is missing endpoints")
+	}
+	p.conf = d
+	return d, nil
+}
+
+// AuthCodeURL returns the URL users are sent to to log in to the provider.
+// The state and nonce are returned unchanged by the provider, and should be
+// checked when the user returns to the redirect URL.
+func (p *Provider) AuthCodeURL(state, nonce string) (string, error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange exchanges the authorization code the user was redirected back
+// with for an ID token, returning the identity the token was issued for once
+// it's been verified.
+func (p *Provider) Exchange(code, nonce string) (*Identity, error) {
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	t := tokenResponse{}
+	err = p.getJSON(req, &t)
+	if err != nil {
+		return nil, err
+	}
+	if t.Error != "" {
+		return nil, fmt.Errorf("SSO provider returned %s: %s", t.Error, t.ErrorDescription)
+	}
+	if t.IDToken == "" {
+		return nil, ErrNoIDToken
+	}
+	return p.Verify(t.IDToken, nonce)
+}
+
+// key returns the provider's signing key with the given ID, fetching the
+// provider's keys if it isn't known
+func (p *Provider) key(kid string) (crypto.PublicKey, error) {
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	req, err := http.NewRequest("GET", d.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	err = p.getJSON(req, &set)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pk, err := parseJWK(k)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pk
+	}
+	p.keys = keys
+	k, ok := keys[kid]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return k, nil
+}
+
+// parseJWK returns the RSA or P-256 public key
+func parseJWK(k jwk) (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, ErrInvalidToken
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pk := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pk.Curve.IsOnCurve(pk.X, pk.Y) {
+			return nil, ErrInvalidToken
+		}
+		return pk, nil
+	}
+	return nil, ErrUnsupportedAlgorithm
+}
+
+// Verify checks that the ID token is signed by the provider, was issued to
+// gophish for the login with the given nonce and hasn't expired, returning
+// the identity it was issued for.
+func (p *Provider) Verify(token, nonce string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(b, &header) != nil {
+		return nil, ErrInvalidToken
+	}
+	if header.Alg != "RS256" && header.Alg != "ES256" {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return nil, ErrInvalidToken
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 {
+			return nil, ErrInvalidToken
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return nil, ErrInvalidToken
+		}
+	default:
+		return nil, ErrInvalidToken
+	}
+	claims := map[string]interface{}{}
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(b, &claims) != nil {
+		return nil, ErrInvalidToken
+	}
+	err = p.checkClaims(claims, nonce, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	// Users can often set their email address at the provider themselves, so
+	// tokens are only trusted once the provider has verified it
+	if !verifiedClaim(claims, "email_verified") {
+		return nil, ErrEmailNotVerified
+	}
+	id := &Identity{
+		Subject:  stringClaim(claims, "sub"),
+		Username: stringClaim(claims, p.UsernameClaim),
+		Email:    stringClaim(claims, "email"),
+		Groups:   stringsClaim(claims, p.GroupsClaim),
+	}
+	if id.Username == "" {
+		id.Username = id.Email
+	}
+	return id, nil
+}
+
+// verifiedClaim returns whether the claim is true. Some providers send
+// boolean claims as strings.
+func verifiedClaim(claims map[string]interface{}, name string) bool {
+	switch v := claims[name].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// checkClaims checks that the claims are for a token issued by the provider
+// to gophish, for the login with the given nonce, which is valid at the time
+func (p *Provider) checkClaims(claims map[string]interface{}, nonce string, now time.Time) error {
+	if strings.TrimSuffix(stringClaim(claims, "iss"), "/") != p.Issuer {
+		return ErrInvalidClaims
+	}
+	if stringClaim(claims, "sub") == "" {
+		return ErrInvalidClaims
+	}
+	aud := stringsClaim(claims, "aud")
+	found := false
+	for _, a := range aud {
+		if a == p.ClientID {
+			found = true
+		}
+	}
+	if !found {
+		return ErrInvalidClaims
+	}
+	// Tokens issued to several clients must name gophish as the party
+	// they're authorized for
+	if azp := stringClaim(claims, "azp"); (len(aud) > 1 || azp != "") && azp != p.ClientID {
+		return ErrInvalidClaims
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-ClockSkew).After(time.Unix(int64(exp), 0)) {
+		return ErrInvalidClaims
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(ClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return ErrInvalidClaims
+	}
+	if nonce == "" || stringClaim(claims, "nonce") != nonce {
+		return ErrInvalidClaims
+	}
+	return nil
+}
+
+// stringClaim returns the claim if it's a string
+func stringClaim(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// stringsClaim returns the claim as a list of strings. Claims which are a
+// single string are returned as a list containing that string.
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		ss := []string{}
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				ss = append(ss, s)
+			}
+		}
+		return ss
+	}
+	return nil
+}
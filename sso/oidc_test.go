@@ -0,0 +1,259 @@
+package sso
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gophish/gophish/config"
+	"github.com/stretchr/testify/suite"
+)
+
+type SSOSuite struct {
+	suite.Suite
+	idp      *httptest.Server
+	rsaKey   *rsa.PrivateKey
+	ecKey    *ecdsa.PrivateKey
+	keyFetch int
+	code     string
+	token    string
+	provider *Provider
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (s *SSOSuite) SetupTest() {
+	var err error
+	s.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	s.Nil(err)
+	s.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Nil(err)
+	s.keyFetch = 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.idp.URL,
+			"authorization_endpoint": s.idp.URL + "/authorize",
+			"token_endpoint":         s.idp.URL + "/token",
+			"jwks_uri":               s.idp.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		s.keyFetch++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "rsa",
+				"use": "sig",
+				"n":   b64(s.rsaKey.N.Bytes()),
+				"e":   b64(big.NewInt(int64(s.rsaKey.E)).Bytes()),
+			},
+			{
+				"kty": "EC",
+				"kid": "ec",
+				"crv": "P-256",
+				"x":   b64(s.ecKey.X.Bytes()),
+				"y":   b64(s.ecKey.Y.Bytes()),
+			},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "gophish" || secret != "secret" || r.FormValue("code") != s.code ||
+			r.FormValue("grant_type") != "authorization_code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": s.token})
+	})
+	s.idp = httptest.NewTLSServer(mux)
+	s.provider, err = NewProvider(config.SSO{
+		Issuer:       s.idp.URL,
+		ClientID:     "gophish",
+		ClientSecret: "secret",
+		RedirectURL:  "https://gophish.example.com/login/sso/callback",
+	})
+	s.Nil(err)
+	s.provider.Client = s.idp.Client()
+}
+
+func (s *SSOSuite) TearDownTest() {
+	s.idp.Close()
+}
+
+// claims returns valid claims for the login with the given nonce
+func (s *SSOSuite) claims(nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                s.idp.URL,
+		"sub":                "00u1a2b3c",
+		"aud":                "gophish",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"preferred_username": "jdoe",
+		"email":              "jdoe@example.com",
+		"email_verified":     true,
+		"groups":             []string{"Security", "Everyone"},
+	}
+}
+
+// sign returns the claims as an ID token signed with the key
+func (s *SSOSuite) sign(alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	unsigned := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(unsigned))
+	var sig []byte
+	switch alg {
+	case "RS256":
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		r, ss, _ := ecdsa.Sign(rand.Reader, s.ecKey, digest[:])
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), ss.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+	return unsigned + "." + b64(sig)
+}
+
+func (s *SSOSuite) TestNewProviderValidation() {
+	_, err := NewProvider(config.SSO{Issuer: "http://idp.example.com", ClientID: "gophish", RedirectURL: "https://gophish.example.com/login/sso/callback"})
+	s.Equal(ErrInvalidIssuer, err)
+	_, err = NewProvider(config.SSO{Issuer: "https://idp.example.com", RedirectURL: "https://gophish.example.com/login/sso/callback"})
+	s.Equal(ErrClientIDNotSpecified, err)
+	_, err = NewProvider(config.SSO{Issuer: "https://idp.example.com", ClientID: "gophish", RedirectURL: "/login/sso/callback"})
+	s.Equal(ErrInvalidRedirectURL, err)
+}
+
+func (s *SSOSuite) TestAuthCodeURL() {
+	u, err := s.provider.AuthCodeURL("state", "nonce")
+	s.Nil(err)
+	pu, err := url.Parse(u)
+	s.Nil(err)
+	s.Equal("/authorize", pu.Path)
+	q := pu.Query()
+	s.Equal("code", q.Get("response_type"))
+	s.Equal("gophish", q.Get("client_id"))
+	s.Equal("openid profile email", q.Get("scope"))
+	s.Equal("state", q.Get("state"))
+	s.Equal("nonce", q.Get("nonce"))
+	s.Equal("https://gophish.example.com/login/sso/callback", q.Get("redirect_uri"))
+}
+
+func (s *SSOSuite) TestExchange() {
+	s.code = "authcode"
+	s.token = s.sign("RS256", "rsa", s.claims("nonce"))
+	id, err := s.provider.Exchange("authcode", "nonce")
+	s.Nil(err)
+	s.Equal(&Identity{
+		Subject:  "00u1a2b3c",
+		Username: "jdoe",
+		Email:    "jdoe@example.com",
+		Groups:   []string{"Security", "Everyone"},
+	}, id)
+
+	_, err = s.provider.Exchange("invalid", "nonce")
+	s.EqualError(err, "SSO provider returned invalid_grant: ")
+}
+
+func (s *SSOSuite) TestVerify() {
+	// Both RSA and EC keys are supported
+	_, err := s.provider.Verify(s.sign("ES256", "ec", s.claims("nonce")), "nonce")
+	s.Nil(err)
+	_, err = s.provider.Verify(s.sign("RS256", "rsa", s.claims("nonce")), "nonce")
+	s.Nil(err)
+	s.Equal(1, s.keyFetch)
+
+	// The email is used if the token doesn't contain a username
+	claims := s.claims("nonce")
+	delete(claims, "preferred_username")
+	id, err := s.provider.Verify(s.sign("RS256", "rsa", claims), "nonce")
+	s.Nil(err)
+	s.Equal("jdoe@example.com", id.Username)
+
+	// Unknown keys are fetched again, in case the provider rotated its keys
+	_, err = s.provider.Verify(s.sign("RS256", "rotated", s.claims("nonce")), "nonce")
+	s.Equal(ErrInvalidToken, err)
+	s.Equal(2, s.keyFetch)
+
+	// Tokens must be signed by the provider with an asymmetric algorithm
+	_, err = s.provider.Verify(s.sign("ES256", "rsa", s.claims("nonce")), "nonce")
+	s.Equal(ErrInvalidToken, err)
+	token := s.sign("RS256", "rsa", s.claims("nonce"))
+	_, err = s.provider.Verify(token[:len(token)-4]+"AAAA", "nonce")
+	s.Equal(ErrInvalidToken, err)
+	header := b64([]byte(`{"alg":"none"}`))
+	_, err = s.provider.Verify(header+"."+b64([]byte(`{}`))+".", "nonce")
+	s.Equal(ErrUnsupportedAlgorithm, err)
+	_, err = s.provider.Verify("not a token", "nonce")
+	s.Equal(ErrInvalidToken, err)
+}
+
+func (s *SSOSuite) TestVerifyClaims() {
+	for _, change := range []func(map[string]interface{}){
+		func(c map[string]interface{}) { c["iss"] = "https://attacker.example.com" },
+		func(c map[string]interface{}) { c["aud"] = "other-client" },
+		func(c map[string]interface{}) { c["aud"] = []string{"gophish", "other-client"} },
+		func(c map[string]interface{}) { c["azp"] = "other-client" },
+		func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		func(c map[string]interface{}) { delete(c, "exp") },
+		func(c map[string]interface{}) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+		func(c map[string]interface{}) { c["nonce"] = "replayed" },
+		func(c map[string]interface{}) { delete(c, "sub") },
+	} {
+		claims := s.claims("nonce")
+		change(claims)
+		_, err := s.provider.Verify(s.sign("RS256", "rsa", claims), "nonce")
+		s.Equal(ErrInvalidClaims, err)
+	}
+	// The provider must have verified the user's email address
+	for _, verified := range []interface{}{false, "false", nil} {
+		claims := s.claims("nonce")
+		claims["email_verified"] = verified
+		_, err := s.provider.Verify(s.sign("RS256", "rsa", claims), "nonce")
+		s.Equal(ErrEmailNotVerified, err)
+	}
+	claims := s.claims("nonce")
+	claims["email_verified"] = "true"
+	_, err := s.provider.Verify(s.sign("RS256", "rsa", claims), "nonce")
+	s.Nil(err)
+
+	// Tokens for several clients are accepted if they're authorized for
+	// gophish
+	claims = s.claims("nonce")
+	claims["aud"] = []string{"gophish", "other-client"}
+	claims["azp"] = "gophish"
+	_, err = s.provider.Verify(s.sign("RS256", "rsa", claims), "nonce")
+	s.Nil(err)
+}
+
+func (s *SSOSuite) TestDiscoveryIssuerMismatch() {
+	p, err := NewProvider(config.SSO{
+		Issuer:      s.idp.URL + "/tenant",
+		ClientID:    "gophish",
+		RedirectURL: "https://gophish.example.com/login/sso/callback",
+	})
+	s.Nil(err)
+	p.Client = s.idp.Client()
+	_, err = p.AuthCodeURL("state", "nonce")
+	s.NotNil(err)
+}
+
+func TestSSOSuite(t *testing.T) {
+	suite.Run(t, new(SSOSuite))
+}
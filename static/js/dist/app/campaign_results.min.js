@@ -1 +1 @@
-function dismiss(){$("#modal\\.flashes").empty(),$("#modal").modal("hide"),$("#resultsTable").dataTable().DataTable().clear().draw()}function deleteCampaign(){swal({title:"Are you sure?",text:"This will delete the campaign. This can't be undone!",type:"warning",animation:!1,showCancelButton:!0,confirmButtonText:"Delete Campaign",confirmButtonColor:"#428bca",reverseButtons:!0,allowOutsideClick:!1,showLoaderOnConfirm:!0,preConfirm:function(){return new Promise(function(e,t){api.campaignId.delete(campaign.id).success(function(t){e()}).error(function(e){t(e.responseJSON.message)})})}}).then(function(){swal("Campaign Deleted!","This campaign has been deleted!","success"),$('button:contains("OK")').on("click",function(){location.href="/campaigns"})})}function completeCampaign(){swal({title:"Are you sure?",text:"Gophish will stop processing events for this campaign",type:"warning",animation:!1,showCancelButton:!0,confirmButtonText:"Complete Campaign",confirmButtonColor:"#428bca",reverseButtons:!0,allowOutsideClick:!1,showLoaderOnConfirm:!0,preConfirm:function(){return new Promise(function(e,t){api.campaignId.complete(campaign.id).success(function(t){e()}).error(function(e){t(e.responseJSON.message)})})}}).then(function(){swal("Campaign Completed!","This campaign has been completed!","success"),$("#complete_button")[0].disabled=!0,$("#complete_button").text("Completed!"),doPoll=!1})}function exportAsCSV(e){exportHTML=$("#exportButton").html();var t=null,a=campaign.name+" - "+capitalize(e)+".csv";switch(e){case"results":t=campaign.results;break;case"events":t=campaign.timeline}if(t){$("#exportButton").html('<i class="fa fa-spinner fa-spin"></i>');var s=Papa.unparse(t,{}),i=new Blob([s],{type:"text/csv;charset=utf-8;"});if(navigator.msSaveBlob)navigator.msSaveBlob(i,a);else{var l=window.URL.createObjectURL(i),n=document.createElement("a");n.href=l,n.setAttribute("download",a),document.body.appendChild(n),n.click(),document.body.removeChild(n)}$("#exportButton").html(exportHTML)}}function replay(e){function t(){form.attr({action:url}),form.appendTo("body").submit().remove()}request=campaign.timeline[e],details=JSON.parse(request.details),url=null,form=$("<form>").attr({method:"POST",target:"_blank"}),$.each(Object.keys(details.payload),function(e,t){return"rid"==t||("__original_url"==t?(url=details.payload[t],!0):void $("<input>").attr({name:t}).val(details.payload[t]).appendTo(form))}),swal({title:"Where do you want the credentials submitted to?",input:"text",showCancelButton:!0,inputPlaceholder:"http://example.com/login",inputValue:url||"",inputValidator:function(e){return new Promise(function(t,a){e?t():a("Invalid URL.")})}}).then(function(e){url=e,t()})}function renderTimeline(e){return record={first_name:e[2],last_name:e[3],email:e[4],position:e[5],status:e[6],send_date:e[7],reported:e[8]},results='<div class="timeline col-sm-12 well well-lg"><h6>Timeline for '+escapeHtml(record.first_name)+" "+escapeHtml(record.last_name)+'</h6><span class="subtitle">Email: '+escapeHtml(record.email)+'</span><div class="timeline-graph col-sm-6">',$.each(campaign.timeline,function(e,t){t.email&&t.email!=record.email||(results+='<div class="timeline-entry">    <div class="timeline-bar"></div>',results+='    <div class="timeline-icon '+statuses[t.message].label+'">    <i class="fa '+statuses[t.message].icon+'"></i></div>    <div class="timeline-message">'+escapeHtml(t.message)+'    <span class="timeline-date">'+moment.utc(t.time).local().format("MMMM Do YYYY h:mm:ss a")+"</span>",t.details&&("Submitted Data"==t.message&&(results+='<div class="timeline-replay-button"><button onclick="replay('+e+')" class="btn btn-success">',results+='<i class="fa fa-refresh"></i> Replay Credentials</button></div>',results+='<div class="timeline-event-details"><i class="fa fa-caret-right"></i> View Details</div>'),details=JSON.parse(t.details),details.payload&&(results+='<div class="timeline-event-results">',results+='    <table class="table table-condensed table-bordered table-striped">',results+="        <thead><tr><th>Parameter</th><th>Value(s)</tr></thead><tbody>",$.each(Object.keys(details.payload),function(e,t){if("rid"==t)return!0;results+="    <tr>",results+="        <td>"+escapeHtml(t)+"</td>",results+="        <td>"+escapeHtml(details.payload[t])+"</td>",results+="    </tr>"}),results+="       </tbody></table>",results+="</div>"),details.error&&(results+='<div class="timeline-event-details"><i class="fa fa-caret-right"></i> View Details</div>',results+='<div class="timeline-event-results">',results+='<span class="label label-default">Error</span> '+details.error,results+="</div>")),results+="</div></div>")}),"Scheduled"!=record.status&&"Retrying"!=record.status||(results+='<div class="timeline-entry">    <div class="timeline-bar"></div>',results+='    <div class="timeline-icon '+statuses[record.status].label+'">    <i class="fa '+statuses[record.status].icon+'"></i></div>    <div class="timeline-message">Scheduled to send at '+record.send_date+"</span>"),results+="</div></div>",results}function createStatusLabel(e,t){var a=statuses[e].label||"label-default",s='<span class="label '+a+'">'+e+"</span>";if("Scheduled"==e||"Retrying"==e){s='<span class="label '+a+'" data-toggle="tooltip" data-placement="top" data-html="true" title="'+("Scheduled to send at "+t)+'">'+e+"</span>"}return s}function poll(){api.campaignId.results(campaign.id).success(function(e){campaign=e;var t=[];$.each(campaign.timeline,function(e,a){var s=moment.utc(a.time).local();t.push({email:a.email,x:s.valueOf(),y:1})});var t=[];$.each(campaign.timeline,function(e,a){var s=moment.utc(a.time).local();t.push({email:a.email,message:a.message,x:s.valueOf(),y:1,marker:{fillColor:statuses[a.message].color}})}),$("#timeline_chart").highcharts().series[0].update({data:t});var a={};Object.keys(statusMapping).forEach(function(e){a[e]=0}),$.each(campaign.results,function(e,t){a[t.status]++,t.reported&&a["Email Reported"]++;for(var s=progressListing.indexOf(t.status),e=0;e<s;e++)a[progressListing[e]]++}),$.each(a,function(e,t){var a=[];if(!(e in statusMapping))return!0;a.push({name:e,y:t}),a.push({name:"",y:campaign.results.length-t}),$("#"+statusMapping[e]+"_chart").highcharts().series[0].update({data:a})}),resultsTable=$("#resultsTable").DataTable(),resultsTable.rows().every(function(e,t,a){var s=this.row(e),i=s.data(),l=i[0];$.each(campaign.results,function(t,a){if(a.id==l)return i[8]=moment(a.send_date).format("MMMM Do YYYY, h:mm:ss a"),i[7]=a.reported,i[6]=a.status,resultsTable.row(e).data(i),s.child.isShown()&&($(s.node()).find("#caret").removeClass("fa-caret-right"),$(s.node()).find("#caret").addClass("fa-caret-down"),s.child(renderTimeline(s.data()))),!1})}),resultsTable.draw(!1),updateMap(campaign.results),$('[data-toggle="tooltip"]').tooltip(),$("#refresh_message").hide(),$("#refresh_btn").show()})}function load(){campaign.id=window.location.pathname.split("/").slice(-1)[0];var e=JSON.parse(localStorage.getItem("gophish.use_map"));api.campaignId.results(campaign.id).success(function(t){if(campaign=t){$("title").text(t.name+" - Gophish"),$("#loading").hide(),$("#campaignResults").show(),$("#page-title").text("Results for "+t.name),"Completed"==t.status&&($("#complete_button")[0].disabled=!0,$("#complete_button").text("Completed!"),doPoll=!1),$("#resultsTable").on("click",".timeline-event-details",function(){payloadResults=$(this).parent().find(".timeline-event-results"),payloadResults.is(":visible")?($(this).find("i").removeClass("fa-caret-down"),$(this).find("i").addClass("fa-caret-right"),payloadResults.hide()):($(this).find("i").removeClass("fa-caret-right"),$(this).find("i").addClass("fa-caret-down"),payloadResults.show())}),resultsTable=$("#resultsTable").DataTable({destroy:!0,order:[[2,"asc"]],columnDefs:[{orderable:!1,targets:"no-sort"},{className:"details-control",targets:[1]},{visible:!1,targets:[0,8]},{render:function(e,t,a){return createStatusLabel(e,a[8])},targets:[6]},{className:"text-center",render:function(e,t,a){return e?"<i class='fa fa-check-circle text-center text-success'></i>":"<i class='fa fa-times-circle text-center text-muted'></i>"},targets:[7]}]}),resultsTable.clear();var a={},s=[];Object.keys(statusMapping).forEach(function(e){a[e]=0}),$.each(campaign.results,function(e,t){resultsTable.row.add([t.id,'<i id="caret" class="fa fa-caret-right"></i>',escapeHtml(t.first_name)||"",escapeHtml(t.last_name)||"",escapeHtml(t.email)||"",escapeHtml(t.position)||"",t.status,t.reported,moment(t.send_date).format("MMMM Do YYYY, h:mm:ss a")]),a[t.status]++,t.reported&&a["Email Reported"]++;for(var s=progressListing.indexOf(t.status),e=0;e<s;e++)a[progressListing[e]]++}),resultsTable.draw(),$('[data-toggle="tooltip"]').tooltip(),$("#resultsTable tbody").on("click","td.details-control",function(){var e=$(this).closest("tr"),t=resultsTable.row(e);t.child.isShown()?(t.child.hide(),e.removeClass("shown"),$(this).find("i").removeClass("fa-caret-down"),$(this).find("i").addClass("fa-caret-right")):($(this).find("i").removeClass("fa-caret-right"),$(this).find("i").addClass("fa-caret-down"),t.child(renderTimeline(t.data())).show(),e.addClass("shown"))}),$.each(campaign.timeline,function(e,t){if("Campaign Created"==t.message)return!0;var a=moment.utc(t.time).local();s.push({email:t.email,message:t.message,x:a.valueOf(),y:1,marker:{fillColor:statuses[t.message].color}})}),renderTimelineChart({data:s}),$.each(a,function(e,t){var a=[];if(!(e in statusMapping))return!0;a.push({name:e,y:t}),a.push({name:"",y:campaign.results.length-t});renderPieChart({elemId:statusMapping[e]+"_chart",title:e,name:e,data:a,colors:[statuses[e].color,"#dddddd"]})}),e&&($("#resultsMapContainer").show(),map=new Datamap({element:document.getElementById("resultsMap"),responsive:!0,fills:{defaultFill:"#ffffff",point:"#283F50"},geographyConfig:{highlightFillColor:"#1abc9c",borderColor:"#283F50"},bubblesConfig:{borderColor:"#283F50"}})),updateMap(campaign.results)}}).error(function(){$("#loading").hide(),errorFlash(" Campaign not found!")})}function refresh(){doPoll&&($("#refresh_message").show(),$("#refresh_btn").hide(),poll(),clearTimeout(setRefresh),setRefresh=setTimeout(refresh,6e4))}var map=null,doPoll=!0,statuses={"Email Sent":{color:"#1abc9c",label:"label-success",icon:"fa-envelope",point:"ct-point-sent"},"Emails Sent":{color:"#1abc9c",label:"label-success",icon:"fa-envelope",point:"ct-point-sent"},"In progress":{label:"label-primary"},Queued:{label:"label-info"},Completed:{label:"label-success"},"Email Opened":{color:"#f9bf3b",label:"label-warning",icon:"fa-envelope-open",point:"ct-point-opened"},"Clicked Link":{color:"#F39C12",label:"label-clicked",icon:"fa-mouse-pointer",point:"ct-point-clicked"},Success:{color:"#f05b4f",label:"label-danger",icon:"fa-exclamation",point:"ct-point-clicked"},"Email Reported":{color:"#45d6ef",label:"label-info",icon:"fa-bullhorn",point:"ct-point-reported"},Error:{color:"#6c7a89",label:"label-default",icon:"fa-times",point:"ct-point-error"},"Error Sending Email":{color:"#6c7a89",label:"label-default",icon:"fa-times",point:"ct-point-error"},"Submitted Data":{color:"#f05b4f",label:"label-danger",icon:"fa-exclamation",point:"ct-point-clicked"},Unknown:{color:"#6c7a89",label:"label-default",icon:"fa-question",point:"ct-point-error"},Sending:{color:"#428bca",label:"label-primary",icon:"fa-spinner",point:"ct-point-sending"},Retrying:{color:"#6c7a89",label:"label-default",icon:"fa-clock-o",point:"ct-point-error"},Scheduled:{color:"#428bca",label:"label-primary",icon:"fa-clock-o",point:"ct-point-sending"},"Campaign Created":{label:"label-success",icon:"fa-rocket"}},statusMapping={"Email Sent":"sent","Email Opened":"opened","Clicked Link":"clicked","Submitted Data":"submitted_data","Email Reported":"reported"},progressListing=["Email Sent","Email Opened","Clicked Link","Submitted Data"],campaign={},bubbles=[],renderTimelineChart=function(e){return Highcharts.chart("timeline_chart",{chart:{zoomType:"x",type:"line",height:"200px"},title:{text:"Campaign Timeline"},xAxis:{type:"datetime",dateTimeLabelFormats:{second:"%l:%M:%S",minute:"%l:%M",hour:"%l:%M",day:"%b %d, %Y",week:"%b %d, %Y",month:"%b %Y"}},yAxis:{min:0,max:2,visible:!1,tickInterval:1,labels:{enabled:!1},title:{text:""}},tooltip:{formatter:function(){return Highcharts.dateFormat("%A, %b %d %l:%M:%S %P",new Date(this.x))+"<br>Event: "+this.point.message+"<br>Email: <b>"+this.point.email+"</b>"}},legend:{enabled:!1},plotOptions:{series:{marker:{enabled:!0,symbol:"circle",radius:3},cursor:"pointer"},line:{states:{hover:{lineWidth:1}}}},credits:{enabled:!1},series:[{data:e.data,dashStyle:"shortdash",color:"#cccccc",lineWidth:1,turboThreshold:0}]})},renderPieChart=function(e){return Highcharts.chart(e.elemId,{chart:{type:"pie",events:{load:function(){var t=this,a=t.renderer,s=t.series[0],i=t.plotLeft+s.center[0],l=t.plotTop+s.center[1];this.innerText=a.text(e.data[0].y,i,l).attr({"text-anchor":"middle","font-size":"24px","font-weight":"bold",fill:e.colors[0],"font-family":"Helvetica,Arial,sans-serif"}).add()},render:function(){this.innerText.attr({text:e.data[0].y})}}},title:{text:e.title},plotOptions:{pie:{innerSize:"80%",dataLabels:{enabled:!1}}},credits:{enabled:!1},tooltip:{formatter:function(){return void 0!=this.key&&'<span style="color:'+this.color+'">●</span>'+this.point.name+": <b>"+this.y+"</b><br/>"}},series:[{data:e.data,colors:e.colors}]})},updateMap=function(e){map&&(bubbles=[],$.each(campaign.results,function(e,t){if(0==t.latitude&&0==t.longitude)return!0;newIP=!0,$.each(bubbles,function(e,a){if(a.ip==t.ip)return bubbles[e].radius+=1,newIP=!1,!1}),newIP&&bubbles.push({latitude:t.latitude,longitude:t.longitude,name:t.ip,fillKey:"point",radius:2})}),map.bubbles(bubbles))},setRefresh;$(document).ready(function(){Highcharts.setOptions({global:{useUTC:!1}}),load(),setRefresh=setTimeout(refresh,6e4)});
\ No newline at end of file
+var map=null,doPoll=!0,statuses={"Email Sent":{color:"#1abc9c",label:"label-success",icon:"fa-envelope",point:"ct-point-sent"},"Emails Sent":{color:"#1abc9c",label:"label-success",icon:"fa-envelope",point:"ct-point-sent"},"In progress":{label:"label-primary"},Queued:{label:"label-info"},Completed:{label:"label-success"},"Email Opened":{color:"#f9bf3b",label:"label-warning",icon:"fa-envelope-open",point:"ct-point-opened"},"Clicked Link":{color:"#F39C12",label:"label-clicked",icon:"fa-mouse-pointer",point:"ct-point-clicked"},Success:{color:"#f05b4f",label:"label-danger",icon:"fa-exclamation",point:"ct-point-clicked"},"Email Reported":{color:"#45d6ef",label:"label-info",icon:"fa-bullhorn",point:"ct-point-reported"},"Email Replied":{color:"#45d6ef",label:"label-info",icon:"fa-reply-all",point:"ct-point-reported"},"Training Completed":{color:"#1abc9c",label:"label-success",icon:"fa-graduation-cap",point:"ct-point-reported"},"Suspected Bot Click":{color:"#6c7a89",label:"label-default",icon:"fa-android",point:"ct-point-error"},Error:{color:"#6c7a89",label:"label-default",icon:"fa-times",point:"ct-point-error"},"Error Sending Email":{color:"#6c7a89",label:"label-default",icon:"fa-times",point:"ct-point-error"},"Email Bounced":{color:"#6c7a89",label:"label-default",icon:"fa-reply",point:"ct-point-error"},"Submitted Data":{color:"#f05b4f",label:"label-danger",icon:"fa-exclamation",point:"ct-point-clicked"},Unknown:{color:"#6c7a89",label:"label-default",icon:"fa-question",point:"ct-point-error"},Sending:{color:"#428bca",label:"label-primary",icon:"fa-spinner",point:"ct-point-sending"},Retrying:{color:"#6c7a89",label:"label-default",icon:"fa-clock-o",point:"ct-point-error"},Scheduled:{color:"#428bca",label:"label-primary",icon:"fa-clock-o",point:"ct-point-sending"},"Campaign Created":{label:"label-success",icon:"fa-rocket"}},statusMapping={"Email Sent":"sent","Email Opened":"opened","Clicked Link":"clicked","Submitted Data":"submitted_data","Email Reported":"reported"},progressListing=["Email Sent","Email Opened","Clicked Link","Submitted Data"],campaign={},bubbles=[];function dismiss(){$("#modal\\.flashes").empty(),$("#modal").modal("hide"),$("#resultsTable").dataTable().DataTable().clear().draw()}function deleteCampaign(){swal({title:"Are you sure?",text:"This will delete the campaign. This can't be undone!",type:"warning",animation:!1,showCancelButton:!0,confirmButtonText:"Delete Campaign",confirmButtonColor:"#428bca",reverseButtons:!0,allowOutsideClick:!1,showLoaderOnConfirm:!0,preConfirm:function(){return new Promise(function(a,s){api.campaignId.delete(campaign.id).success(function(t){a()}).error(function(t){s(t.responseJSON.message)})})}}).then(function(){swal("Campaign Deleted!","This campaign has been deleted!","success"),$('button:contains("OK")').on("click",function(){location.href="/campaigns"})})}function completeCampaign(){swal({title:"Are you sure?",text:"Gophish will stop processing events for this campaign",type:"warning",animation:!1,showCancelButton:!0,confirmButtonText:"Complete Campaign",confirmButtonColor:"#428bca",reverseButtons:!0,allowOutsideClick:!1,showLoaderOnConfirm:!0,preConfirm:function(){return new Promise(function(a,s){api.campaignId.complete(campaign.id).success(function(t){a()}).error(function(t){s(t.responseJSON.message)})})}}).then(function(){swal("Campaign Completed!","This campaign has been completed!","success"),$("#complete_button")[0].disabled=!0,$("#complete_button").text("Completed!"),doPoll=!1})}function exportAsCSV(a){exportHTML=$("#exportButton").html();var s=null,t=campaign.name+" - "+capitalize(a)+".csv";switch(a){case"results":s=campaign.results;break;case"events":s=campaign.timeline;break}if(s){$("#exportButton").html('<i class="fa fa-spinner fa-spin"></i>');var l=Papa.unparse(s,{}),e=new Blob([l],{type:"text/csv;charset=utf-8;"});if(navigator.msSaveBlob)navigator.msSaveBlob(e,t);else{var i=window.URL.createObjectURL(e),r=document.createElement("a");r.href=i,r.setAttribute("download",t),document.body.appendChild(r),r.click(),document.body.removeChild(r)}$("#exportButton").html(exportHTML)}}function replay(a){request=campaign.timeline[a],details=JSON.parse(request.details),url=null,form=$("<form>").attr({method:"POST",target:"_blank"}),$.each(Object.keys(details.payload),function(t,l){if(l=="rid")return!0;if(l=="__original_url")return url=details.payload[l],!0;$("<input>").attr({name:l}).val(details.payload[l]).appendTo(form)}),swal({title:"Where do you want the credentials submitted to?",input:"text",showCancelButton:!0,inputPlaceholder:"http://example.com/login",inputValue:url||"",inputValidator:function(t){return new Promise(function(l,e){t?l():e("Invalid URL.")})}}).then(function(t){url=t,s()});return;function s(){form.attr({action:url}),form.appendTo("body").submit().remove()}}function renderTimeline(a){return record={first_name:a[2],last_name:a[3],email:a[4],position:a[5],status:a[6],send_date:a[7],reported:a[8]},results='<div class="timeline col-sm-12 well well-lg"><h6>Timeline for '+escapeHtml(record.first_name)+" "+escapeHtml(record.last_name)+'</h6><span class="subtitle">Email: '+escapeHtml(record.email)+'</span><div class="timeline-graph col-sm-6">',$.each(campaign.timeline,function(s,t){(!t.email||t.email==record.email)&&(results+='<div class="timeline-entry">    <div class="timeline-bar"></div>',results+='    <div class="timeline-icon '+statuses[t.message].label+'">    <i class="fa '+statuses[t.message].icon+'"></i></div>    <div class="timeline-message">'+escapeHtml(t.message)+'    <span class="timeline-date">'+moment.utc(t.time).local().format("MMMM Do YYYY h:mm:ss a")+"</span>",t.details&&(t.message=="Submitted Data"&&(results+='<div class="timeline-replay-button"><button onclick="replay('+s+')" class="btn btn-success">',results+='<i class="fa fa-refresh"></i> Replay Credentials</button></div>',results+='<div class="timeline-event-details"><i class="fa fa-caret-right"></i> View Details</div>'),details=JSON.parse(t.details),details.payload&&(results+='<div class="timeline-event-results">',results+='    <table class="table table-condensed table-bordered table-striped">',results+="        <thead><tr><th>Parameter</th><th>Value(s)</tr></thead><tbody>",$.each(Object.keys(details.payload),function(l,e){if(e=="rid")return!0;results+="    <tr>",results+="        <td>"+escapeHtml(e)+"</td>",results+="        <td>"+escapeHtml(details.payload[e])+"</td>",results+="    </tr>"}),results+="       </tbody></table>",results+="</div>"),details.error&&(results+='<div class="timeline-event-details"><i class="fa fa-caret-right"></i> View Details</div>',results+='<div class="timeline-event-results">',results+='<span class="label label-default">Error</span> '+details.error,results+="</div>")),results+="</div></div>")}),(record.status=="Scheduled"||record.status=="Retrying")&&(results+='<div class="timeline-entry">    <div class="timeline-bar"></div>',results+='    <div class="timeline-icon '+statuses[record.status].label+'">    <i class="fa '+statuses[record.status].icon+'"></i></div>    <div class="timeline-message">Scheduled to send at '+record.send_date+"</span>"),results+="</div></div>",results}var renderTimelineChart=function(a){return Highcharts.chart("timeline_chart",{chart:{zoomType:"x",type:"line",height:"200px"},title:{text:"Campaign Timeline"},xAxis:{type:"datetime",dateTimeLabelFormats:{second:"%l:%M:%S",minute:"%l:%M",hour:"%l:%M",day:"%b %d, %Y",week:"%b %d, %Y",month:"%b %Y"}},yAxis:{min:0,max:2,visible:!1,tickInterval:1,labels:{enabled:!1},title:{text:""}},tooltip:{formatter:function(){return Highcharts.dateFormat("%A, %b %d %l:%M:%S %P",new Date(this.x))+"<br>Event: "+this.point.message+"<br>Email: <b>"+this.point.email+"</b>"}},legend:{enabled:!1},plotOptions:{series:{marker:{enabled:!0,symbol:"circle",radius:3},cursor:"pointer"},line:{states:{hover:{lineWidth:1}}}},credits:{enabled:!1},series:[{data:a.data,dashStyle:"shortdash",color:"#cccccc",lineWidth:1,turboThreshold:0}]})},renderPieChart=function(a){return Highcharts.chart(a.elemId,{chart:{type:"pie",events:{load:function(){var s=this,t=s.renderer,l=s.series[0],e=s.plotLeft+l.center[0],i=s.plotTop+l.center[1];this.innerText=t.text(a.data[0].y,e,i).attr({"text-anchor":"middle","font-size":"24px","font-weight":"bold",fill:a.colors[0],"font-family":"Helvetica,Arial,sans-serif"}).add()},render:function(){this.innerText.attr({text:a.data[0].y})}}},title:{text:a.title},plotOptions:{pie:{innerSize:"80%",dataLabels:{enabled:!1}}},credits:{enabled:!1},tooltip:{formatter:function(){return this.key==null?!1:'<span style="color:'+this.color+'">\u25CF</span>'+this.point.name+": <b>"+this.y+"</b><br/>"}},series:[{data:a.data,colors:a.colors}]})},updateMap=function(a){map&&(bubbles=[],$.each(campaign.results,function(s,t){if(t.latitude==0&&t.longitude==0)return!0;newIP=!0,$.each(bubbles,function(l,e){if(e.ip==t.ip)return bubbles[l].radius+=1,newIP=!1,!1}),newIP&&bubbles.push({latitude:t.latitude,longitude:t.longitude,name:t.ip,fillKey:"point",radius:2})}),map.bubbles(bubbles))};function createStatusLabel(a,s){var t=statuses[a].label||"label-default",l='<span class="label '+t+'">'+a+"</span>";if(a=="Scheduled"||a=="Retrying"){var e="Scheduled to send at "+s;l='<span class="label '+t+'" data-toggle="tooltip" data-placement="top" data-html="true" title="'+e+'">'+a+"</span>"}return l}function poll(){api.campaignId.results(campaign.id).success(function(a){campaign=a,update()})}function update(){var a=[];$.each(campaign.timeline,function(l,e){var i=moment.utc(e.time).local();a.push({email:e.email,x:i.valueOf(),y:1})});var a=[];$.each(campaign.timeline,function(l,e){var i=moment.utc(e.time).local();a.push({email:e.email,message:e.message,x:i.valueOf(),y:1,marker:{fillColor:statuses[e.message].color}})});var s=$("#timeline_chart").highcharts();s.series[0].update({data:a});var t={};Object.keys(statusMapping).forEach(function(l){t[l]=0}),$.each(campaign.results,function(l,e){t[e.status]++,e.reported&&t["Email Reported"]++;for(var i=progressListing.indexOf(e.status),l=0;l<i;l++)t[progressListing[l]]++}),$.each(t,function(l,e){var i=[];if(!(l in statusMapping))return!0;i.push({name:l,y:e}),i.push({name:"",y:campaign.results.length-e});var r=$("#"+statusMapping[l]+"_chart").highcharts();r.series[0].update({data:i})}),resultsTable=$("#resultsTable").DataTable(),resultsTable.rows().every(function(l,e,i){var r=this.row(l),n=r.data(),c=n[0];$.each(campaign.results,function(d,o){if(o.id==c)return n[8]=moment(o.send_date).format("MMMM Do YYYY, h:mm:ss a"),n[7]=o.reported,n[6]=o.status,resultsTable.row(l).data(n),r.child.isShown()&&($(r.node()).find("#caret").removeClass("fa-caret-right"),$(r.node()).find("#caret").addClass("fa-caret-down"),r.child(renderTimeline(r.data()))),!1})}),resultsTable.draw(!1),updateMap(campaign.results),$('[data-toggle="tooltip"]').tooltip(),$("#refresh_message").hide(),$("#refresh_btn").show()}var stream,streamUpdate;function applyStreamEvent(a){campaign.timeline.push(a),$.each(campaign.results,function(s,t){return t.id!=a.rid?!0:(a.message=="Email Reported"?t.reported=!0:progressListing.indexOf(a.message)>progressListing.indexOf(t.status)&&(t.status=a.message),!1)}),clearTimeout(streamUpdate),streamUpdate=setTimeout(update,1e3)}function startStream(){!window.EventSource||!doPoll||(stream=new EventSource("/api/campaigns/"+campaign.id+"/stream?api_key="+user.api_key),stream.onopen=function(){clearTimeout(setRefresh)},stream.onmessage=function(a){applyStreamEvent(JSON.parse(a.data))})}function load(){campaign.id=window.location.pathname.split("/").slice(-1)[0];var a=JSON.parse(localStorage.getItem("gophish.use_map"));api.campaignId.results(campaign.id).success(function(s){if(campaign=s,campaign){$("title").text(s.name+" - Gophish"),$("#loading").hide(),$("#campaignResults").show(),$("#page-title").text("Results for "+s.name),s.status=="Completed"&&($("#complete_button")[0].disabled=!0,$("#complete_button").text("Completed!"),doPoll=!1),$("#resultsTable").on("click",".timeline-event-details",function(){payloadResults=$(this).parent().find(".timeline-event-results"),payloadResults.is(":visible")?($(this).find("i").removeClass("fa-caret-down"),$(this).find("i").addClass("fa-caret-right"),payloadResults.hide()):($(this).find("i").removeClass("fa-caret-right"),$(this).find("i").addClass("fa-caret-down"),payloadResults.show())}),resultsTable=$("#resultsTable").DataTable({destroy:!0,order:[[2,"asc"]],columnDefs:[{orderable:!1,targets:"no-sort"},{className:"details-control",targets:[1]},{visible:!1,targets:[0,8]},{render:function(e,i,r){return createStatusLabel(e,r[8])},targets:[6]},{className:"text-center",render:function(e,i,r){return e?"<i class='fa fa-check-circle text-center text-success'></i>":"<i class='fa fa-times-circle text-center text-muted'></i>"},targets:[7]}]}),resultsTable.clear();var t={},l=[];Object.keys(statusMapping).forEach(function(e){t[e]=0}),$.each(campaign.results,function(e,i){resultsTable.row.add([i.id,'<i id="caret" class="fa fa-caret-right"></i>',escapeHtml(i.first_name)||"",escapeHtml(i.last_name)||"",escapeHtml(i.email)||"",escapeHtml(i.position)||"",i.status,i.reported,moment(i.send_date).format("MMMM Do YYYY, h:mm:ss a")]),t[i.status]++,i.reported&&t["Email Reported"]++;for(var r=progressListing.indexOf(i.status),e=0;e<r;e++)t[progressListing[e]]++}),resultsTable.draw(),$('[data-toggle="tooltip"]').tooltip(),$("#resultsTable tbody").on("click","td.details-control",function(){var e=$(this).closest("tr"),i=resultsTable.row(e);i.child.isShown()?(i.child.hide(),e.removeClass("shown"),$(this).find("i").removeClass("fa-caret-down"),$(this).find("i").addClass("fa-caret-right")):($(this).find("i").removeClass("fa-caret-right"),$(this).find("i").addClass("fa-caret-down"),i.child(renderTimeline(i.data())).show(),e.addClass("shown"))}),$.each(campaign.timeline,function(e,i){if(i.message=="Campaign Created")return!0;var r=moment.utc(i.time).local();l.push({email:i.email,message:i.message,x:r.valueOf(),y:1,marker:{fillColor:statuses[i.message].color}})}),renderTimelineChart({data:l}),$.each(t,function(e,i){var r=[];if(!(e in statusMapping))return!0;r.push({name:e,y:i}),r.push({name:"",y:campaign.results.length-i});var n=renderPieChart({elemId:statusMapping[e]+"_chart",title:e,name:e,data:r,colors:[statuses[e].color,"#dddddd"]})}),a&&($("#resultsMapContainer").show(),map=new Datamap({element:document.getElementById("resultsMap"),responsive:!0,fills:{defaultFill:"#ffffff",point:"#283F50"},geographyConfig:{highlightFillColor:"#1abc9c",borderColor:"#283F50"},bubblesConfig:{borderColor:"#283F50"}})),updateMap(campaign.results),startStream()}}).error(function(){$("#loading").hide(),errorFlash(" Campaign not found!")})}var setRefresh;function refresh(){doPoll&&($("#refresh_message").show(),$("#refresh_btn").hide(),poll(),clearTimeout(setRefresh),setRefresh=setTimeout(refresh,6e4))}$(document).ready(function(){Highcharts.setOptions({global:{useUTC:!1}}),load(),setRefresh=setTimeout(refresh,6e4)});
@@ -1 +1 @@
-$(document).ready(function(){$("#apiResetForm").submit(function(e){return api.reset().success(function(e){user.api_key=e.data,successFlash(e.message),$("#api_key").val(user.api_key)}).error(function(e){errorFlash(e.message)}),!1}),$("#settingsForm").submit(function(e){return $.post("/settings",$(this).serialize()).done(function(e){successFlash(e.message)}).fail(function(e){errorFlash(e.responseJSON.message)}),!1});var e=localStorage.getItem("gophish.use_map");$("#use_map").prop("checked",JSON.parse(e)),$("#use_map").on("change",function(){localStorage.setItem("gophish.use_map",JSON.stringify(this.checked))})});
\ No newline at end of file
+$(document).ready(function(){$("#apiResetForm").submit(function(s){return api.reset().success(function(e){user.api_key=e.data,successFlash(e.message),$("#api_key").val(user.api_key)}).error(function(e){errorFlash(e.message)}),!1}),$("#settingsForm").submit(function(s){return $.post("/settings",$(this).serialize()).done(function(e){successFlash(e.message)}).fail(function(e){errorFlash(e.responseJSON.message)}),!1}),$("#totpEnrollForm").submit(function(s){return $.post("/settings/2fa",$(this).serialize()).done(function(e){$("#totp_uri").val(e.data.uri),$("#totp_secret").val(e.data.secret),$("#totpEnrollForm").hide(),$("#totpEnableForm").show(),successFlash(e.message)}).fail(function(e){errorFlash(e.responseJSON.message)}),!1}),$("#totpEnableForm").submit(function(s){return $.post("/settings/2fa",$(this).serialize()).done(function(e){$("#totpEnableForm").hide(),$("#totp_recovery_codes").text(e.data.join("\n")),$("#totpRecoveryCodes").show(),successFlash(e.message)}).fail(function(e){errorFlash(e.responseJSON.message)}),!1}),$("#totpDisableForm").submit(function(s){return $.post("/settings/2fa",$(this).serialize()).done(function(e){successFlash(e.message),location.reload()}).fail(function(e){errorFlash(e.responseJSON.message)}),!1});var t=localStorage.getItem("gophish.use_map");$("#use_map").prop("checked",JSON.parse(t)),$("#use_map").on("change",function(){localStorage.setItem("gophish.use_map",JSON.stringify(this.checked))})});
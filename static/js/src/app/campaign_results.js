@@ -49,6 +49,27 @@ var statuses = {
         icon: "fa-bullhorn",
         point: "ct-point-reported"
     },
+    //not a status, but is used for the campaign timeline and user timeline
+    "Email Replied": {
+        color: "#45d6ef",
+        label: "label-info",
+        icon: "fa-reply-all",
+        point: "ct-point-reported"
+    },
+    //not a status, but is used for the campaign timeline and user timeline
+    "Training Completed": {
+        color: "#1abc9c",
+        label: "label-success",
+        icon: "fa-graduation-cap",
+        point: "ct-point-reported"
+    },
+    //not a status, but is used for the campaign timeline and user timeline
+    "Suspected Bot Click": {
+        color: "#6c7a89",
+        label: "label-default",
+        icon: "fa-android",
+        point: "ct-point-error"
+    },
     "Error": {
         color: "#6c7a89",
         label: "label-default",
@@ -61,6 +82,12 @@ var statuses = {
         icon: "fa-times",
         point: "ct-point-error"
     },
+    "Email Bounced": {
+        color: "#6c7a89",
+        label: "label-default",
+        icon: "fa-reply",
+        point: "ct-point-error"
+    },
     "Submitted Data": {
         color: "#f05b4f",
         label: "label-danger",
@@ -545,97 +572,145 @@ function poll() {
     api.campaignId.results(campaign.id)
         .success(function (c) {
             campaign = c
-            /* Update the timeline */
-            var timeline_series_data = []
-            $.each(campaign.timeline, function (i, event) {
-                var event_date = moment.utc(event.time).local()
-                timeline_series_data.push({
-                    email: event.email,
-                    x: event_date.valueOf(),
-                    y: 1
-                })
-            })
-            var timeline_series_data = []
-            $.each(campaign.timeline, function (i, event) {
-                var event_date = moment.utc(event.time).local()
-                timeline_series_data.push({
-                    email: event.email,
-                    message: event.message,
-                    x: event_date.valueOf(),
-                    y: 1,
-                    marker: {
-                        fillColor: statuses[event.message].color
-                    }
-                })
-            })
-            var timeline_chart = $("#timeline_chart").highcharts()
-            timeline_chart.series[0].update({
-                data: timeline_series_data
-            })
-            /* Update the results donut chart */
-            var email_series_data = {}
-            // Load the initial data
-            Object.keys(statusMapping).forEach(function (k) {
-                email_series_data[k] = 0
-            });
-            $.each(campaign.results, function (i, result) {
-                email_series_data[result.status]++;
-                if (result.reported) {
-                    email_series_data['Email Reported']++
-                }
-                // Backfill status values
-                var step = progressListing.indexOf(result.status)
-                for (var i = 0; i < step; i++) {
-                    email_series_data[progressListing[i]]++
-                }
-            })
-            $.each(email_series_data, function (status, count) {
-                var email_data = []
-                if (!(status in statusMapping)) {
-                    return true
-                }
-                email_data.push({
-                    name: status,
-                    y: count
-                })
-                email_data.push({
-                    name: '',
-                    y: campaign.results.length - count
-                })
-                var chart = $("#" + statusMapping[status] + "_chart").highcharts()
-                chart.series[0].update({
-                    data: email_data
-                })
-            })
+            update()
+        })
+}
 
-            /* Update the datatable */
-            resultsTable = $("#resultsTable").DataTable()
-            resultsTable.rows().every(function (i, tableLoop, rowLoop) {
-                var row = this.row(i)
-                var rowData = row.data()
-                var rid = rowData[0]
-                $.each(campaign.results, function (j, result) {
-                    if (result.id == rid) {
-                        rowData[8] = moment(result.send_date).format('MMMM Do YYYY, h:mm:ss a')
-                        rowData[7] = result.reported
-                        rowData[6] = result.status
-                        resultsTable.row(i).data(rowData)
-                        if (row.child.isShown()) {
-                            $(row.node()).find("#caret").removeClass("fa-caret-right")
-                            $(row.node()).find("#caret").addClass("fa-caret-down")
-                            row.child(renderTimeline(row.data()))
-                        }
-                        return false
-                    }
-                })
-            })
-            resultsTable.draw(false)
-            /* Update the map information */
-            updateMap(campaign.results)
-            $('[data-toggle="tooltip"]').tooltip()
-            $("#refresh_message").hide()
-            $("#refresh_btn").show()
+/* update - Redraws the charts, map and table from the campaign's results */
+function update() {
+    /* Update the timeline */
+    var timeline_series_data = []
+    $.each(campaign.timeline, function (i, event) {
+        var event_date = moment.utc(event.time).local()
+        timeline_series_data.push({
+            email: event.email,
+            x: event_date.valueOf(),
+            y: 1
+        })
+    })
+    var timeline_series_data = []
+    $.each(campaign.timeline, function (i, event) {
+        var event_date = moment.utc(event.time).local()
+        timeline_series_data.push({
+            email: event.email,
+            message: event.message,
+            x: event_date.valueOf(),
+            y: 1,
+            marker: {
+                fillColor: statuses[event.message].color
+            }
+        })
+    })
+    var timeline_chart = $("#timeline_chart").highcharts()
+    timeline_chart.series[0].update({
+        data: timeline_series_data
+    })
+    /* Update the results donut chart */
+    var email_series_data = {}
+    // Load the initial data
+    Object.keys(statusMapping).forEach(function (k) {
+        email_series_data[k] = 0
+    });
+    $.each(campaign.results, function (i, result) {
+        email_series_data[result.status]++;
+        if (result.reported) {
+            email_series_data['Email Reported']++
+        }
+        // Backfill status values
+        var step = progressListing.indexOf(result.status)
+        for (var i = 0; i < step; i++) {
+            email_series_data[progressListing[i]]++
+        }
+    })
+    $.each(email_series_data, function (status, count) {
+        var email_data = []
+        if (!(status in statusMapping)) {
+            return true
+        }
+        email_data.push({
+            name: status,
+            y: count
         })
+        email_data.push({
+            name: '',
+            y: campaign.results.length - count
+        })
+        var chart = $("#" + statusMapping[status] + "_chart").highcharts()
+        chart.series[0].update({
+            data: email_data
+        })
+    })
+
+    /* Update the datatable */
+    resultsTable = $("#resultsTable").DataTable()
+    resultsTable.rows().every(function (i, tableLoop, rowLoop) {
+        var row = this.row(i)
+        var rowData = row.data()
+        var rid = rowData[0]
+        $.each(campaign.results, function (j, result) {
+            if (result.id == rid) {
+                rowData[8] = moment(result.send_date).format('MMMM Do YYYY, h:mm:ss a')
+                rowData[7] = result.reported
+                rowData[6] = result.status
+                resultsTable.row(i).data(rowData)
+                if (row.child.isShown()) {
+                    $(row.node()).find("#caret").removeClass("fa-caret-right")
+                    $(row.node()).find("#caret").addClass("fa-caret-down")
+                    row.child(renderTimeline(row.data()))
+                }
+                return false
+            }
+        })
+    })
+    resultsTable.draw(false)
+    /* Update the map information */
+    updateMap(campaign.results)
+    $('[data-toggle="tooltip"]').tooltip()
+    $("#refresh_message").hide()
+    $("#refresh_btn").show()
+}
+
+var stream
+var streamUpdate
+
+/*
+ * applyStreamEvent - Adds an event streamed from the server to the campaign's
+ * timeline and result, redrawing the results shortly after, so that bursts of
+ * events are only drawn once.
+ */
+function applyStreamEvent(event) {
+    campaign.timeline.push(event)
+    $.each(campaign.results, function (i, result) {
+        if (result.id != event.rid) {
+            return true
+        }
+        if (event.message == "Email Reported") {
+            result.reported = true
+        } else if (progressListing.indexOf(event.message) > progressListing.indexOf(result.status)) {
+            result.status = event.message
+        }
+        return false
+    })
+    clearTimeout(streamUpdate)
+    streamUpdate = setTimeout(update, 1000)
+}
+
+/*
+ * startStream - Streams the campaign's events as they occur, instead of
+ * polling for the full results. Browsers without server-sent events keep
+ * polling.
+ */
+function startStream() {
+    if (!window.EventSource || !doPoll) {
+        return
+    }
+    stream = new EventSource("/api/campaigns/" + campaign.id + "/stream?api_key=" + user.api_key)
+    stream.onopen = function () {
+        clearTimeout(setRefresh)
+    }
+    stream.onmessage = function (e) {
+        applyStreamEvent(JSON.parse(e.data))
+    }
 }
 
 function load() {
@@ -813,6 +888,7 @@ function load() {
                     });
                 }
                 updateMap(campaign.results)
+                startStream()
             }
         })
         .error(function () {
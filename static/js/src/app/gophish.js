@@ -152,6 +152,21 @@ var api = {
             return query("/pages/", "POST", page, false)
         }
     },
+    // pageAssets contains the endpoints for /pages/:id/assets
+    pageAssets: {
+        // get() - Queries the API for GET /pages/:id/assets
+        get: function (id) {
+            return query("/pages/" + id + "/assets", "GET", {}, false)
+        },
+        // post() - Uploads an asset to POST /pages/:id/assets
+        post: function (id, asset) {
+            return query("/pages/" + id + "/assets", "POST", asset, false)
+        },
+        // delete() - Deletes an asset at DELETE /pages/:id/assets/:asset_id
+        delete: function (id, assetId) {
+            return query("/pages/" + id + "/assets/" + assetId, "DELETE", {}, false)
+        }
+    },
     // pageId contains the endpoints for /pages/:id
     pageId: {
         // get() - Queries the API for GET /pages/:id
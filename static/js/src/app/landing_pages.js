@@ -4,6 +4,19 @@
 	Author: Jordan Wright <github.com/jordan-wright>
 */
 var pages = []
+// importedAssets are the resources downloaded when importing a site, which
+// are uploaded as the page's assets once it's saved
+var importedAssets = []
+
+// uploadAssets uploads the resources downloaded when importing a site as
+// assets of the page with the given id
+function uploadAssets(id) {
+    var uploads = $.map(importedAssets, function (asset) {
+        return api.pageAssets.post(id, asset)
+    })
+    importedAssets = []
+    return $.when.apply($, uploads)
+}
 
 // Save attempts to POST to /templates/
 function save(idx) {
@@ -14,21 +27,28 @@ function save(idx) {
     page.capture_credentials = $("#capture_credentials_checkbox").prop("checked")
     page.capture_passwords = $("#capture_passwords_checkbox").prop("checked")
     page.redirect_url = $("#redirect_url_input").val()
+    page.honeypot_fields = $("#honeypot_fields_input").val()
+    page.proxy_url = $("#proxy_url_input").val()
+    page.proxy_capture_rules = $("#proxy_capture_rules_input").val()
     if (idx != -1) {
         page.id = pages[idx].id
         api.pageId.put(page)
             .success(function (data) {
-                successFlash("Page edited successfully!")
-                load()
-                dismiss()
+                uploadAssets(data.id).always(function () {
+                    successFlash("Page edited successfully!")
+                    load()
+                    dismiss()
+                })
             })
     } else {
         // Submit the page
         api.pages.post(page)
             .success(function (data) {
-                successFlash("Page added successfully!")
-                load()
-                dismiss()
+                uploadAssets(data.id).always(function () {
+                    successFlash("Page added successfully!")
+                    load()
+                    dismiss()
+                })
             })
             .error(function (data) {
                 modalError(data.responseJSON.message)
@@ -42,7 +62,11 @@ function dismiss() {
     $("#html_editor").val("")
     $("#url").val("")
     $("#redirect_url_input").val("")
+    $("#honeypot_fields_input").val("")
+    $("#proxy_url_input").val("")
+    $("#proxy_capture_rules_input").val("")
     $("#modal").find("input[type='checkbox']").prop("checked", false)
+    importedAssets = []
     $("#capture_passwords").hide()
     $("#redirect_url").hide()
     $("#modal").modal('hide')
@@ -65,11 +89,11 @@ function importSite() {
     } else {
         api.clone_site({
             url: url,
-            include_resources: false
+            include_resources: $("#include_resources_checkbox").prop("checked")
         })
             .success(function (data) {
-                console.log($("#html_editor"))
                 $("#html_editor").val(data.html)
+                importedAssets = data.assets || []
                 $("#importSiteModal").modal("hide")
             })
             .error(function (data) {
@@ -91,6 +115,9 @@ function edit(idx) {
         $("#capture_credentials_checkbox").prop("checked", page.capture_credentials)
         $("#capture_passwords_checkbox").prop("checked", page.capture_passwords)
         $("#redirect_url_input").val(page.redirect_url)
+        $("#honeypot_fields_input").val(page.honeypot_fields)
+        $("#proxy_url_input").val(page.proxy_url)
+        $("#proxy_capture_rules_input").val(page.proxy_capture_rules)
         if (page.capture_credentials) {
             $("#capture_passwords").show()
             $("#redirect_url").show()
@@ -21,6 +21,44 @@ $(document).ready(function () {
             })
         return false
     })
+    $("#totpEnrollForm").submit(function (e) {
+        $.post("/settings/2fa", $(this).serialize())
+            .done(function (data) {
+                $("#totp_uri").val(data.data.uri)
+                $("#totp_secret").val(data.data.secret)
+                $("#totpEnrollForm").hide()
+                $("#totpEnableForm").show()
+                successFlash(data.message)
+            })
+            .fail(function (data) {
+                errorFlash(data.responseJSON.message)
+            })
+        return false
+    })
+    $("#totpEnableForm").submit(function (e) {
+        $.post("/settings/2fa", $(this).serialize())
+            .done(function (data) {
+                $("#totpEnableForm").hide()
+                $("#totp_recovery_codes").text(data.data.join("\n"))
+                $("#totpRecoveryCodes").show()
+                successFlash(data.message)
+            })
+            .fail(function (data) {
+                errorFlash(data.responseJSON.message)
+            })
+        return false
+    })
+    $("#totpDisableForm").submit(function (e) {
+        $.post("/settings/2fa", $(this).serialize())
+            .done(function (data) {
+                successFlash(data.message)
+                location.reload()
+            })
+            .fail(function (data) {
+                errorFlash(data.responseJSON.message)
+            })
+        return false
+    })
     var use_map = localStorage.getItem('gophish.use_map')
     $("#use_map").prop('checked', JSON.parse(use_map))
     $("#use_map").on('change', function () {
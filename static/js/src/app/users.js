@@ -1,14 +1,25 @@
 var groups = []
+// The custom fields of each target in the group being edited, keyed by email
+var targetAttributes = {}
+
+// formatAttributes returns the escaped custom fields of a target for display
+function formatAttributes(attributes) {
+    return $.map(attributes || {}, function (value, name) {
+        return escapeHtml(name) + ": " + escapeHtml(value)
+    }).join("<br>")
+}
 
 // Save attempts to POST or PUT to /groups/
 function save(id) {
     var targets = []
     $.each($("#targetsTable").DataTable().rows().data(), function (i, target) {
+        var email = unescapeHtml(target[2])
         targets.push({
             first_name: unescapeHtml(target[0]),
             last_name: unescapeHtml(target[1]),
-            email: unescapeHtml(target[2]),
-            position: unescapeHtml(target[3])
+            email: email,
+            position: unescapeHtml(target[3]),
+            attributes: targetAttributes[email]
         })
     })
     var group = {
@@ -48,6 +59,7 @@ function save(id) {
 
 function dismiss() {
     $("#targetsTable").dataTable().DataTable().clear().draw()
+    targetAttributes = {}
     $("#name").val("")
     $("#modal\\.flashes").empty()
 }
@@ -70,12 +82,14 @@ function edit(id) {
             .success(function (group) {
                 $("#name").val(group.name)
                 $.each(group.targets, function (i, record) {
+                    targetAttributes[record.email] = record.attributes
                     targets.DataTable()
                         .row.add([
                             escapeHtml(record.first_name),
                             escapeHtml(record.last_name),
                             escapeHtml(record.email),
                             escapeHtml(record.position),
+                            formatAttributes(record.attributes),
                             '<span style="cursor:pointer;"><i class="fa fa-trash-o"></i></span>'
                         ]).draw()
                 });
@@ -105,7 +119,8 @@ function edit(id) {
                     record.first_name,
                     record.last_name,
                     record.email,
-                    record.position);
+                    record.position,
+                    record.attributes);
             });
             targets.DataTable().draw();
         }
@@ -129,14 +144,18 @@ function deleteGroup(id) {
     }
 }
 
-function addTarget(firstNameInput, lastNameInput, emailInput, positionInput) {
+function addTarget(firstNameInput, lastNameInput, emailInput, positionInput, attributes) {
     // Create new data row.
     var email = escapeHtml(emailInput).toLowerCase();
+    if (attributes) {
+        targetAttributes[unescapeHtml(email)] = attributes
+    }
     var newRow = [
         escapeHtml(firstNameInput),
         escapeHtml(lastNameInput),
         email,
         escapeHtml(positionInput),
+        formatAttributes(targetAttributes[unescapeHtml(email)]),
         '<span style="cursor:pointer;"><i class="fa fa-trash-o"></i></span>'
     ];
 
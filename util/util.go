@@ -62,10 +62,16 @@ func ParseCSV(r *http.Request) ([]models.Target, error) {
 		li := -1
 		ei := -1
 		pi := -1
+		phi := -1
+		lci := -1
 		fn := ""
 		ln := ""
 		ea := ""
 		ps := ""
+		ph := ""
+		lc := ""
+		// Any other columns are imported as custom attributes
+		ai := make(map[int]string)
 		for i, v := range record {
 			switch {
 			case v == "First Name":
@@ -76,6 +82,12 @@ func ParseCSV(r *http.Request) ([]models.Target, error) {
 				ei = i
 			case v == "Position":
 				pi = i
+			case v == "Phone":
+				phi = i
+			case v == "Locale":
+				lci = i
+			case v != "":
+				ai[i] = v
 			}
 		}
 		for {
@@ -99,11 +111,28 @@ func ParseCSV(r *http.Request) ([]models.Target, error) {
 			if pi != -1 {
 				ps = record[pi]
 			}
+			if phi != -1 {
+				ph = record[phi]
+			}
+			if lci != -1 {
+				lc = record[lci]
+			}
 			t := models.Target{
 				FirstName: fn,
 				LastName:  ln,
 				Email:     ea,
 				Position:  ps,
+				Phone:     ph,
+				Locale:    lc,
+			}
+			for i, name := range ai {
+				if i >= len(record) {
+					continue
+				}
+				if t.Attributes == nil {
+					t.Attributes = make(map[string]string)
+				}
+				t.Attributes[name] = record[i]
 			}
 			ts = append(ts, t)
 		}
@@ -29,7 +29,10 @@ func (s *UtilSuite) SetupSuite() {
 }
 
 func buildCSVRequest(csvPayload string) (*http.Request, error) {
-	csvHeader := "First Name,Last Name,Email\n"
+	return buildCSVRequestWithHeader("First Name,Last Name,Email\n", csvPayload)
+}
+
+func buildCSVRequestWithHeader(csvHeader string, csvPayload string) (*http.Request, error) {
 	body := new(bytes.Buffer)
 	writer := multipart.NewWriter(body)
 	part, err := writer.CreateFormFile("files[]", "example.csv")
@@ -69,6 +72,31 @@ func (s *UtilSuite) TestParseCSVEmail() {
 	}
 }
 
+func (s *UtilSuite) TestParseCSVAttributes() {
+	r, err := buildCSVRequestWithHeader("First Name,Last Name,Email,Department,Manager\n",
+		"John,Doe,johndoe@example.com,Sales,Jane Roe\nJill,Doe,jilldoe@example.com,IT,\n")
+	s.Nil(err)
+
+	got, err := ParseCSV(r)
+	s.Nil(err)
+	s.Equal(len(got), 2)
+	s.Equal(got[0].Email, "johndoe@example.com")
+	s.Equal(got[0].Attributes, map[string]string{"Department": "Sales", "Manager": "Jane Roe"})
+	s.Equal(got[1].Attributes, map[string]string{"Department": "IT", "Manager": ""})
+}
+
+func (s *UtilSuite) TestParseCSVLocale() {
+	r, err := buildCSVRequestWithHeader("First Name,Last Name,Email,Locale\n",
+		"John,Doe,johndoe@example.com,fr-CA\n")
+	s.Nil(err)
+
+	got, err := ParseCSV(r)
+	s.Nil(err)
+	s.Equal(len(got), 1)
+	s.Equal(got[0].Locale, "fr-CA")
+	s.Nil(got[0].Attributes)
+}
+
 func TestUtilSuite(t *testing.T) {
 	suite.Run(t, new(UtilSuite))
 }
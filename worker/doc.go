@@ -29,4 +29,10 @@ THE SOFTWARE.
 // to be launched.
 // If a campaign is found, it gathers the maillogs associated with the campaign and
 // sends them to the mailer package to be processed.
+//
+// Maillogs are claimed from a Queue, which locks each maillog to the instance
+// sending it. Several gophish instances can share a database, with each
+// instance sending the maillogs it claimed. Claimed maillogs over their
+// sending profile's limits on emails per minute or hour are rescheduled
+// instead of being sent.
 package worker
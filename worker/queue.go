@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/gophish/gophish/models"
+)
+
+// Queue hands out the maillogs which are due to be sent, so that several
+// gophish instances, or worker processes, can share the sending of campaigns
+// without sending the same email twice. The maillogs returned by a Queue are
+// locked to the instance which claimed them until they're processed.
+type Queue interface {
+	// Claim returns the maillogs which are due to be sent at the given time
+	Claim(t time.Time) ([]*models.MailLog, error)
+	// ClaimCampaign returns the maillogs of the given campaign which aren't
+	// already being sent
+	ClaimCampaign(cid int64) ([]*models.MailLog, error)
+	// Renew extends the locks on the maillogs which are still being sent
+	Renew() error
+}
+
+// DBQueue is a Queue backed by the database, where each maillog is leased to
+// the instance which claimed it. Instances are identified by
+// models.InstanceId, so every instance sharing a database needs its own ID.
+type DBQueue struct{}
+
+// Claim leases the maillogs which are due to be sent at the given time
+func (q *DBQueue) Claim(t time.Time) ([]*models.MailLog, error) {
+	return models.ClaimMailLogs(t, models.InstanceId)
+}
+
+// ClaimCampaign leases the maillogs of the given campaign which aren't
+// leased by another instance
+func (q *DBQueue) ClaimCampaign(cid int64) ([]*models.MailLog, error) {
+	return models.ClaimCampaignMailLogs(cid, models.InstanceId)
+}
+
+// Renew extends the leases on the maillogs this instance is sending
+func (q *DBQueue) Renew() error {
+	return models.RenewMailLogLeases(models.InstanceId)
+}
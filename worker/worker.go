@@ -10,11 +10,22 @@ import (
 )
 
 // Worker is the background worker that handles watching for new campaigns and sending emails appropriately.
-type Worker struct{}
+type Worker struct {
+	// Queue is where the worker claims the maillogs it sends from
+	Queue Queue
+}
+
+// batchKey identifies the maillogs which are sent to the mailer together,
+// which belong to the same campaign and are sent through the same sending
+// profile
+type batchKey struct {
+	campaignId int64
+	smtpId     int64
+}
 
 // New creates a new worker object to handle the creation of campaigns
 func New() *Worker {
-	return &Worker{}
+	return &Worker{Queue: &DBQueue{}}
 }
 
 // Start launches the worker to poll the database every minute for any pending maillogs
@@ -22,28 +33,38 @@ func New() *Worker {
 func (w *Worker) Start() {
 	log.Info("Background Worker Started Successfully - Waiting for Campaigns")
 	for t := range time.Tick(1 * time.Minute) {
-		ms, err := models.GetQueuedMailLogs(t.UTC())
+		w.launchSchedules(t.UTC())
+		go w.syncDirectories(t.UTC())
+		go w.applyRetention(t.UTC())
+		// Keep the maillogs which are still being sent from being claimed
+		// by another instance
+		err := w.Queue.Renew()
+		if err != nil {
+			log.Error(err)
+		}
+		// The claimed maillogs are locked until they're processed
+		ms, err := w.Queue.Claim(t.UTC())
 		if err != nil {
 			log.Error(err)
 			continue
 		}
-		// Lock the MailLogs (they will be unlocked after processing)
-		err = models.LockMailLogs(ms, true)
+		ms, err = throttle(ms, t.UTC())
 		if err != nil {
 			log.Error(err)
 			continue
 		}
-		// We'll group the maillogs by campaign ID to (sort of) group
-		// them by sending profile. This lets the mailer re-use the Sender
-		// instead of having to re-connect to the SMTP server for every
-		// email.
-		msg := make(map[int64][]mailer.Mail)
+		// We'll group the maillogs by campaign ID and sending profile,
+		// since campaigns can rotate between profiles. This lets the
+		// mailer re-use the Sender instead of having to re-connect to the
+		// SMTP server for every email.
+		msg := make(map[batchKey][]mailer.Mail)
 		for _, m := range ms {
-			msg[m.CampaignId] = append(msg[m.CampaignId], m)
+			k := batchKey{m.CampaignId, m.SMTPId}
+			msg[k] = append(msg[k], m)
 		}
 
 		// Next, we process each group of maillogs in parallel
-		for cid, msc := range msg {
+		for k, msc := range msg {
 			go func(cid int64, msc []mailer.Mail) {
 				uid := msc[0].(*models.MailLog).UserId
 				c, err := models.GetCampaign(cid, uid)
@@ -52,6 +73,12 @@ func (w *Worker) Start() {
 					errorMail(err, msc)
 					return
 				}
+				// The campaign may have been paused since the maillogs
+				// were fetched, so they're left for when it's resumed
+				if c.Status == models.CAMPAIGN_PAUSED {
+					unlockMail(msc)
+					return
+				}
 				if c.Status == models.CAMPAIGN_QUEUED {
 					err := c.UpdateStatus(models.CAMPAIGN_IN_PROGRESS)
 					if err != nil {
@@ -59,30 +86,117 @@ func (w *Worker) Start() {
 						return
 					}
 				}
+				if c.SMSId != 0 {
+					sendSMS(msc)
+					return
+				}
 				log.WithFields(logrus.Fields{
 					"num_emails": len(msc),
 				}).Info("Sending emails to mailer for processing")
 				mailer.Mailer.Queue <- msc
-			}(cid, msc)
+			}(k.campaignId, msc)
+		}
+	}
+}
+
+// launchSchedules creates and launches a campaign for each recurring campaign
+// schedule which is due at the given time.
+func (w *Worker) launchSchedules(t time.Time) {
+	ss, err := models.GetDueCampaignSchedules(t)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, s := range ss {
+		c, err := s.Launch(t)
+		if err != nil {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"schedule_id": s.Id,
+			"campaign_id": c.Id,
+		}).Info("Launched scheduled campaign")
+		if c.Status == models.CAMPAIGN_IN_PROGRESS {
+			go w.LaunchCampaign(c)
+		}
+	}
+}
+
+// syncDirectories syncs the groups of each directory which is due to be
+// synced at the given time. Directories are synced outside of the worker's
+// loop, since searching a large directory can take a while.
+func (w *Worker) syncDirectories(t time.Time) {
+	ds, err := models.GetDueDirectories(t)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, d := range ds {
+		r, err := d.Sync(t, false)
+		if err != nil {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"directory_id": d.Id,
+			"group_id":     r.GroupId,
+			"added":        len(r.Added),
+			"removed":      len(r.Removed),
+			"updated":      len(r.Updated),
+		}).Info("Synced group with directory")
+	}
+}
+
+// applyRetention anonymizes or purges the results of each completed campaign
+// whose retention policy is due at the given time.
+func (w *Worker) applyRetention(t time.Time) {
+	cs, err := models.GetDueRetentionCampaigns(t)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, c := range cs {
+		_, action := c.RetentionPolicy()
+		err = c.ApplyRetention(action, t)
+		if err != nil {
+			log.Error(err)
 		}
 	}
 }
 
 // LaunchCampaign starts a campaign
 func (w *Worker) LaunchCampaign(c models.Campaign) {
-	ms, err := models.GetMailLogsByCampaign(c.Id)
+	ms, err := w.Queue.ClaimCampaign(c.Id)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	ms, err = throttle(ms, time.Now().UTC())
 	if err != nil {
 		log.Error(err)
 		return
 	}
-	models.LockMailLogs(ms, true)
 	// This is required since you cannot pass a slice of values
 	// that implements an interface as a slice of that interface.
 	mailEntries := []mailer.Mail{}
 	for _, m := range ms {
 		mailEntries = append(mailEntries, m)
 	}
-	mailer.Mailer.Queue <- mailEntries
+	if c.SMSId != 0 {
+		go sendSMS(mailEntries)
+		return
+	}
+	// Each batch sent to the mailer uses a single sending profile
+	profiles := make(map[int64][]mailer.Mail)
+	order := []int64{}
+	for _, m := range ms {
+		if _, ok := profiles[m.SMTPId]; !ok {
+			order = append(order, m.SMTPId)
+		}
+		profiles[m.SMTPId] = append(profiles[m.SMTPId], m)
+	}
+	for _, sid := range order {
+		mailer.Mailer.Queue <- profiles[sid]
+	}
 }
 
 // SendTestEmail sends a test email
@@ -93,6 +207,29 @@ func (w *Worker) SendTestEmail(s *models.SendTestEmailRequest) error {
 	return <-s.ErrorChan
 }
 
+// TestSend sends the email of a test send request
+func (w *Worker) TestSend(s *models.TestSendRequest) error {
+	go func() {
+		mailer.Mailer.Queue <- []mailer.Mail{s}
+	}()
+	return <-s.ErrorChan
+}
+
+// throttle returns the claimed maillogs which can be sent at the given time
+// without exceeding their sending profiles' limits, rescheduling the rest. If
+// the limits can't be checked, the maillogs are unlocked so they're claimed
+// again later.
+func throttle(ms []*models.MailLog, t time.Time) ([]*models.MailLog, error) {
+	send, err := models.ThrottleMailLogs(ms, t)
+	if err != nil {
+		if uerr := models.LockMailLogs(ms, false); uerr != nil {
+			log.Error(uerr)
+		}
+		return nil, err
+	}
+	return send, nil
+}
+
 // errorMail is a helper to handle erroring out a slice of Mail instances
 // in the case that an unrecoverable error occurs.
 func errorMail(err error, ms []mailer.Mail) {
@@ -100,3 +237,28 @@ func errorMail(err error, ms []mailer.Mail) {
 		m.Error(err)
 	}
 }
+
+// sendSMS sends the text messages for a slice of maillogs belonging to an
+// SMS campaign, which bypass the mailer.
+func sendSMS(ms []mailer.Mail) {
+	log.WithFields(logrus.Fields{
+		"num_messages": len(ms),
+	}).Info("Sending SMS messages")
+	for _, m := range ms {
+		err := m.(*models.MailLog).SendSMS()
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// unlockMail is a helper to unlock a slice of maillogs which weren't
+// processed, so they can be picked up again later.
+func unlockMail(ms []mailer.Mail) {
+	for _, m := range ms {
+		err := m.(*models.MailLog).Unlock()
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}